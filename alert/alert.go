@@ -0,0 +1,71 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Alert is a notification about a failing check
+type Alert struct {
+	Source  string      `json:"source"` // subsystem raising the alert, e.g. "quality"
+	Subject string      `json:"subject"`
+	Status  string      `json:"status"` // "failing" or "resolved"
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
+	At      time.Time   `json:"at"`
+}
+
+// Notifier delivers alerts to a webhook. Alerts are always logged; with no
+// webhook configured logging is the only delivery.
+type Notifier struct {
+	webhookURL string
+	client     *http.Client
+}
+
+func NewNotifier(webhookURL string) *Notifier {
+	return &Notifier{
+		webhookURL: webhookURL,
+		client:     &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (n *Notifier) Send(ctx context.Context, a Alert) {
+	if a.At.IsZero() {
+		a.At = time.Now()
+	}
+	slog.WarnContext(ctx, "Alert", "source", a.Source, "subject", a.Subject, "status", a.Status, "message", a.Message)
+
+	if n == nil || n.webhookURL == "" {
+		return
+	}
+	if err := n.post(ctx, a); err != nil {
+		slog.ErrorContext(ctx, "Alert delivery failed", "error", err)
+	}
+}
+
+func (n *Notifier) post(ctx context.Context, a Alert) error {
+	body, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+// Package artifact keeps the files the server produces or receives, such as
+// saved exports and staged imports, on local disk, in S3 or GCS, or in
+// memory
+package artifact
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"sql-engine/config"
+)
+
+// ErrNotFound is returned for keys without an artifact
+var ErrNotFound = errors.New("artifact not found")
+
+// Object describes a stored artifact
+type Object struct {
+	Key     string    `json:"key"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// Store keeps artifacts under slash-separated keys such as
+// exports/1f2e/users.csv. Putting an existing key replaces it.
+type Store interface {
+	Put(ctx context.Context, key string, r io.Reader) error
+	// Get opens an artifact, or fails with ErrNotFound
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes an artifact; deleting a missing key is not an error
+	Delete(ctx context.Context, key string) error
+	// List returns the artifacts whose keys start with prefix, sorted by key
+	List(ctx context.Context, prefix string) ([]Object, error)
+}
+
+// New opens the configured backend
+func New(cfg config.ArtifactsConfig) (Store, error) {
+	switch cfg.Backend {
+	case "", "local":
+		return NewLocal(cfg.Dir)
+	case "memory":
+		return NewMemory(), nil
+	case "s3":
+		return NewS3(cfg.Bucket, cfg.Prefix, cfg.S3), nil
+	case "gcs":
+		return NewGCS(cfg.Bucket, cfg.Prefix, cfg.S3), nil
+	}
+	return nil, fmt.Errorf("unknown artifacts backend %q", cfg.Backend)
+}
+
+// ValidKey reports whether key is usable with every backend: relative,
+// without empty, . or .. segments
+func ValidKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, "/") || strings.HasSuffix(key, "/") || strings.Contains(key, "\\") {
+		return false
+	}
+	for _, seg := range strings.Split(key, "/") {
+		if seg == "" || seg == "." || seg == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// Expire deletes the artifacts matched by a lifecycle rule that were written
+// longer than its ExpireAfter ago, and returns how many it deleted. A key
+// matched by several rules follows the one with the longest prefix.
+func Expire(ctx context.Context, s Store, rules []config.ArtifactRule, now time.Time) (int, error) {
+	deleted := 0
+	for _, rule := range rules {
+		objects, err := s.List(ctx, rule.Prefix)
+		if err != nil {
+			return deleted, err
+		}
+		for _, obj := range objects {
+			if governing(rules, obj.Key).Prefix != rule.Prefix || now.Sub(obj.ModTime) < rule.ExpireAfter {
+				continue
+			}
+			if err := s.Delete(ctx, obj.Key); err != nil {
+				return deleted, err
+			}
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// ExpiresAt returns when the artifact at key written at t will be deleted
+// by the lifecycle rules, if ever
+func ExpiresAt(rules []config.ArtifactRule, key string, t time.Time) (time.Time, bool) {
+	rule := governing(rules, key)
+	if rule.Prefix == "" {
+		return time.Time{}, false
+	}
+	return t.Add(rule.ExpireAfter), true
+}
+
+func governing(rules []config.ArtifactRule, key string) config.ArtifactRule {
+	var best config.ArtifactRule
+	for _, rule := range rules {
+		if strings.HasPrefix(key, rule.Prefix) && len(rule.Prefix) > len(best.Prefix) {
+			best = rule
+		}
+	}
+	return best
+}
@@ -0,0 +1,191 @@
+package artifact
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"sql-engine/config"
+)
+
+// S3 keeps artifacts in an S3 bucket, or in any store speaking its API
+type S3 struct {
+	bucket string
+	prefix string
+	cfg    config.S3Config
+	// endpoint is set for path-style addressing
+	endpoint string
+	client   *http.Client
+}
+
+// NewS3 keeps artifacts under prefix in bucket. Without an access key the
+// standard AWS_* environment variables are used.
+func NewS3(bucket, prefix string, cfg config.S3Config) *S3 {
+	if cfg.AccessKeyID == "" {
+		cfg.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+		cfg.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		cfg.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+	return &S3{
+		bucket:   bucket,
+		prefix:   prefix,
+		cfg:      cfg,
+		endpoint: strings.TrimRight(cfg.Endpoint, "/"),
+		client:   &http.Client{Timeout: 10 * time.Minute},
+	}
+}
+
+// NewGCS keeps artifacts in a Google Cloud Storage bucket through its
+// S3-compatible XML API, authenticated with HMAC keys
+func NewGCS(bucket, prefix string, cfg config.S3Config) *S3 {
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "https://storage.googleapis.com"
+	}
+	if cfg.Region == "" {
+		cfg.Region = "auto"
+	}
+	return NewS3(bucket, prefix, cfg)
+}
+
+func (s *S3) request(ctx context.Context, method, key string, query url.Values, body io.Reader) (*http.Request, error) {
+	var target string
+	if s.endpoint != "" {
+		target = s.endpoint + "/" + s.bucket + S3Escape(key)
+	} else {
+		target = "https://" + s.bucket + ".s3." + s.cfg.Region + ".amazonaws.com" + S3Escape(key)
+	}
+	if len(query) > 0 {
+		// Signing needs the query in canonical form: sorted, with spaces
+		// as %20
+		target += "?" + strings.ReplaceAll(query.Encode(), "+", "%20")
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	if s.cfg.AccessKeyID != "" {
+		SignV4(req, s.cfg, time.Now())
+	}
+	return req, nil
+}
+
+func (s *S3) do(req *http.Request) (*http.Response, error) {
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound && req.Method != http.MethodDelete {
+		resp.Body.Close()
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s %s: %s %s", req.Method, req.URL.Path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+// Put uploads r, spooled to a temporary file first as S3 needs the length
+// of a body up front
+func (s *S3) Put(ctx context.Context, key string, r io.Reader) error {
+	if !ValidKey(key) {
+		return errors.New("invalid artifact key " + key)
+	}
+	tmp, err := os.CreateTemp("", "artifact-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	size, err := io.Copy(tmp, r)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	req, err := s.request(ctx, http.MethodPut, s.prefix+key, nil, tmp)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (s *S3) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	req, err := s.request(ctx, http.MethodGet, s.prefix+key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	req, err := s.request(ctx, http.MethodDelete, s.prefix+key, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// List pages through ListObjectsV2
+func (s *S3) List(ctx context.Context, prefix string) ([]Object, error) {
+	var objects []Object
+	query := url.Values{"list-type": {"2"}, "prefix": {s.prefix + prefix}}
+	for {
+		req, err := s.request(ctx, http.MethodGet, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := s.do(req)
+		if err != nil {
+			return nil, err
+		}
+		var page listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range page.Contents {
+			objects = append(objects, Object{Key: strings.TrimPrefix(c.Key, s.prefix), Size: c.Size, ModTime: c.LastModified})
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			break
+		}
+		query.Set("continuation-token", page.NextContinuationToken)
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
@@ -0,0 +1,92 @@
+package artifact
+
+import (
+	"context"
+	"io"
+
+	"sql-engine/config"
+)
+
+// Cipher encrypts artifact bodies, binding each to its key. The metadata
+// store's keyring implements it.
+type Cipher interface {
+	SealStream(key string, r io.Reader) io.Reader
+	OpenStream(key string, r io.Reader) (io.Reader, error)
+	StreamSealedWithActive(r io.Reader) (bool, error)
+}
+
+// Sealed encrypts the artifacts of a backend at rest. Artifacts written
+// before encryption was enabled are read unchanged.
+type Sealed struct {
+	Store
+	cipher Cipher
+}
+
+// Seal wraps s so that artifacts are encrypted with c before they reach it
+func Seal(s Store, c Cipher) *Sealed {
+	return &Sealed{Store: s, cipher: c}
+}
+
+func (s *Sealed) Put(ctx context.Context, key string, r io.Reader) error {
+	return s.Store.Put(ctx, key, s.cipher.SealStream(key, r))
+}
+
+func (s *Sealed) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	r, err := s.Store.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	body, err := s.cipher.OpenStream(key, r)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{body, r}, nil
+}
+
+// Rotate re-encrypts the artifacts not yet written with the active key and
+// returns how many it rewrote. Artifacts a lifecycle rule expires are left
+// as they are, since rewriting them would restart their expiry; they stay
+// readable as long as their key is kept in the key file.
+func (s *Sealed) Rotate(ctx context.Context, rules []config.ArtifactRule) (int, error) {
+	objects, err := s.Store.List(ctx, "")
+	if err != nil {
+		return 0, err
+	}
+	rotated := 0
+	for _, obj := range objects {
+		if _, expires := ExpiresAt(rules, obj.Key, obj.ModTime); expires {
+			continue
+		}
+		r, err := s.Store.Get(ctx, obj.Key)
+		if err != nil {
+			return rotated, err
+		}
+		current, err := s.cipher.StreamSealedWithActive(r)
+		r.Close()
+		if err != nil {
+			return rotated, err
+		}
+		if current {
+			continue
+		}
+		if err := s.rewrite(ctx, obj.Key); err != nil {
+			return rotated, err
+		}
+		rotated++
+	}
+	return rotated, nil
+}
+
+// rewrite reads an artifact and writes it back with the active key
+func (s *Sealed) rewrite(ctx context.Context, key string) error {
+	r, err := s.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return s.Put(ctx, key, r)
+}
@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"sql-engine/config"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Context keys holding the caller identity and, for JWTs, the token claims
+const (
+	principalKey = "principal"
+	claimsKey    = "claims"
+)
+
+// Principal returns the identity attached to the request, or "" when the
+// request is unauthenticated
+func Principal(c *gin.Context) string {
+	return c.GetString(principalKey)
+}
+
+// Claims returns the verified JWT claims of the request, if any
+func Claims(c *gin.Context) jwt.MapClaims {
+	claims, _ := c.Get(claimsKey)
+	m, _ := claims.(jwt.MapClaims)
+	return m
+}
+
+// Middleware authenticates requests with an OIDC-issued JWT in the
+// Authorization: Bearer header, or with one of the configured API keys in the
+// Authorization: Bearer or X-API-Key headers. When neither method is
+// configured every request is let through anonymously.
+func Middleware(cfg config.AuthConfig) gin.HandlerFunc {
+	var oidc *OIDC
+	if cfg.OIDC.Issuer != "" {
+		oidc = NewOIDC(cfg.OIDC)
+	}
+	keys := cfg.APIKeys
+
+	return func(c *gin.Context) {
+		if oidc == nil && len(keys) == 0 {
+			c.Next()
+			return
+		}
+
+		bearer := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+
+		if oidc != nil && strings.Count(bearer, ".") == 2 {
+			identity, claims, err := oidc.Verify(bearer)
+			if err != nil {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token: " + err.Error()})
+				return
+			}
+			c.Set(principalKey, identity)
+			c.Set(claimsKey, claims)
+			c.Next()
+			return
+		}
+
+		provided := c.GetHeader("X-API-Key")
+		if provided == "" {
+			provided = bearer
+		}
+		for _, key := range keys {
+			if subtle.ConstantTimeCompare([]byte(provided), []byte(key)) == 1 {
+				c.Set(principalKey, keyPrincipal(key))
+				c.Next()
+				return
+			}
+		}
+
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid or missing credentials"})
+	}
+}
+
+// keyPrincipal identifies an API key without exposing it in logs
+func keyPrincipal(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "apikey:" + hex.EncodeToString(sum[:4])
+}
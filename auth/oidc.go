@@ -0,0 +1,225 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"sql-engine/config"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/sync/singleflight"
+)
+
+// minKeyRefresh limits how often an unknown key id triggers a JWKS refetch
+const minKeyRefresh = time.Minute
+
+// OIDC validates bearer JWTs issued by an OpenID Connect provider, fetching
+// its signing keys through discovery
+type OIDC struct {
+	cfg    config.OIDCConfig
+	client *http.Client
+
+	// refresh lets concurrent requests wait on a single JWKS fetch
+	refresh singleflight.Group
+
+	// mu guards the fields below; keys is replaced, never modified
+	mu          sync.Mutex
+	jwksURL     string
+	keys        map[string]interface{}
+	refreshedAt time.Time
+}
+
+func NewOIDC(cfg config.OIDCConfig) *OIDC {
+	return &OIDC{
+		cfg:     cfg,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		jwksURL: cfg.JWKSURL,
+	}
+}
+
+// Verify checks the token signature, issuer, audience and expiry and returns
+// the caller identity and the token claims
+func (o *OIDC) Verify(token string) (string, jwt.MapClaims, error) {
+	opts := []jwt.ParserOption{
+		jwt.WithIssuer(o.cfg.Issuer),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(30 * time.Second),
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}),
+	}
+	if o.cfg.Audience != "" {
+		opts = append(opts, jwt.WithAudience(o.cfg.Audience))
+	}
+
+	claims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(token, claims, o.keyFunc, opts...); err != nil {
+		return "", nil, err
+	}
+
+	identity, _ := claims[o.cfg.IdentityClaim].(string)
+	if identity == "" {
+		return "", nil, fmt.Errorf("token has no %q claim", o.cfg.IdentityClaim)
+	}
+	return identity, claims, nil
+}
+
+func (o *OIDC) keyFunc(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+
+	o.mu.Lock()
+	key, ok := o.keys[kid]
+	recent := o.keys != nil && time.Since(o.refreshedAt) < minKeyRefresh
+	o.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	// Unknown key: the provider may have rotated, so refetch (rate limited)
+	if recent {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if _, err, _ := o.refresh.Do("jwks", func() (interface{}, error) { return nil, o.refreshKeys() }); err != nil {
+		return nil, err
+	}
+
+	o.mu.Lock()
+	keys := o.keys
+	o.mu.Unlock()
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	// Providers with a single key may omit kid from tokens
+	if kid == "" && len(keys) == 1 {
+		for _, key := range keys {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// refreshKeys reloads the JWKS, discovering its URL first if needed. The
+// provider is called without holding o.mu, which only guards reading and
+// swapping the fields; o.refresh runs one refresh at a time.
+func (o *OIDC) refreshKeys() error {
+	o.mu.Lock()
+	o.refreshedAt = time.Now()
+	jwksURL := o.jwksURL
+	o.mu.Unlock()
+
+	if jwksURL == "" {
+		var discovery struct {
+			JWKSURI string `json:"jwks_uri"`
+		}
+		url := strings.TrimSuffix(o.cfg.Issuer, "/") + "/.well-known/openid-configuration"
+		if err := o.getJSON(url, &discovery); err != nil {
+			return fmt.Errorf("oidc discovery: %w", err)
+		}
+		if discovery.JWKSURI == "" {
+			return errors.New("oidc discovery: no jwks_uri")
+		}
+		jwksURL = discovery.JWKSURI
+		o.mu.Lock()
+		o.jwksURL = jwksURL
+		o.mu.Unlock()
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := o.getJSON(jwksURL, &set); err != nil {
+		return fmt.Errorf("oidc jwks: %w", err)
+	}
+
+	keys := make(map[string]interface{})
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			continue // skip key types we can't use
+		}
+		keys[k.Kid] = key
+	}
+	o.mu.Lock()
+	o.keys = keys
+	o.mu.Unlock()
+	return nil
+}
+
+func (o *OIDC) getJSON(url string, v interface{}) error {
+	resp, err := o.client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// jsonWebKey is the subset of RFC 7517 needed for RSA and EC signing keys
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jsonWebKey) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,272 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"sql-engine/auth"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Policy describes how responses for a route are cached
+type Policy struct {
+	// Route is a gin route pattern such as "/table/:name/columns". A trailing
+	// "*" matches every route with the given prefix.
+	Route string
+	TTL   time.Duration
+	// VaryByPrincipal keeps a separate entry per caller identity
+	VaryByPrincipal bool
+	// BypassHeader, when present on a request, skips the cache lookup and
+	// refreshes the stored entry
+	BypassHeader string
+	// Post caches POST requests too, per caller and request body. Only set
+	// it on routes that read, such as /saved-queries/:id/run.
+	Post bool
+}
+
+// Matches reports whether the policy covers route
+func (p Policy) Matches(route string) bool {
+	if strings.HasSuffix(p.Route, "*") {
+		return strings.HasPrefix(route, strings.TrimSuffix(p.Route, "*"))
+	}
+	return p.Route == route
+}
+
+type entry struct {
+	status      int
+	contentType string
+	// lastModified is replayed so If-Modified-Since works on hits too
+	lastModified string
+	body         []byte
+	expires      time.Time
+}
+
+// Cache is an in-memory response cache driven by a list of policies,
+// holding at most maxEntries responses
+type Cache struct {
+	policies   []Policy
+	maxEntries int
+
+	mu      sync.RWMutex
+	entries map[string]entry
+}
+
+func New(policies []Policy, maxEntries int) *Cache {
+	return &Cache{
+		policies:   policies,
+		maxEntries: maxEntries,
+		entries:    make(map[string]entry),
+	}
+}
+
+// Len returns the number of cached entries, expired or not
+func (c *Cache) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.entries)
+}
+
+// Purge drops every cached entry whose key starts with the given route prefix
+func (c *Cache) Purge(prefix string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.entries {
+		if strings.HasPrefix(key, prefix) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// PurgeAfter returns middleware purging prefix once the wrapped handler
+// responds successfully, for routes that change cached data
+func (c *Cache) PurgeAfter(prefix string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Next()
+		if ctx.Writer.Status() < http.StatusBadRequest {
+			c.Purge(prefix)
+		}
+	}
+}
+
+func (c *Cache) policyFor(route string) (Policy, bool) {
+	for _, p := range c.policies {
+		if p.Matches(route) {
+			return p, true
+		}
+	}
+	return Policy{}, false
+}
+
+// get returns the entry of key, dropping it once it has expired
+func (c *Cache) get(key string) (entry, bool) {
+	c.mu.RLock()
+	e, ok := c.entries[key]
+	c.mu.RUnlock()
+	if !ok {
+		return entry{}, false
+	}
+	if now := time.Now(); now.After(e.expires) {
+		c.mu.Lock()
+		// It may have been refreshed in the meantime
+		if e, ok := c.entries[key]; ok && now.After(e.expires) {
+			delete(c.entries, key)
+		}
+		c.mu.Unlock()
+		return entry{}, false
+	}
+	return e, true
+}
+
+// set stores e under key. A full cache first drops its expired entries,
+// then the entry closest to expiring.
+func (c *Cache) set(key string, e entry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; !ok && c.maxEntries > 0 && len(c.entries) >= c.maxEntries {
+		now := time.Now()
+		for k, old := range c.entries {
+			if now.After(old.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= c.maxEntries {
+			var soonest string
+			for k, old := range c.entries {
+				if soonest == "" || old.expires.Before(c.entries[soonest].expires) {
+					soonest = k
+				}
+			}
+			delete(c.entries, soonest)
+		}
+	}
+	c.entries[key] = e
+}
+
+// Middleware serves cached GET responses for routes covered by a policy,
+// and POST responses for those whose policy sets Post
+func (c *Cache) Middleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		post := ctx.Request.Method == http.MethodPost
+		if ctx.Request.Method != http.MethodGet && !post {
+			ctx.Next()
+			return
+		}
+
+		policy, ok := c.policyFor(ctx.FullPath())
+		if !ok || policy.TTL <= 0 || post && !policy.Post {
+			ctx.Next()
+			return
+		}
+
+		key := ctx.Request.URL.RequestURI()
+		// Some routes answer in the format Accept asks for
+		if accept := ctx.GetHeader("Accept"); accept != "" {
+			key += "|" + accept
+		}
+		if post {
+			sum, err := bodyHash(ctx.Request)
+			if err != nil {
+				ctx.Next()
+				return
+			}
+			key += "|POST " + sum
+		}
+		if policy.VaryByPrincipal || post {
+			// Callers in several workspaces pick one with X-Workspace
+			key += "|" + auth.Principal(ctx) + "|" + ctx.GetHeader("X-Workspace")
+		}
+
+		bypass := policy.BypassHeader != "" && ctx.GetHeader(policy.BypassHeader) != ""
+		if !bypass {
+			if e, ok := c.get(key); ok {
+				ctx.Header("X-Cache", "HIT")
+				if e.lastModified != "" {
+					ctx.Header("Last-Modified", e.lastModified)
+				}
+				ctx.Data(e.status, e.contentType, e.body)
+				ctx.Abort()
+				return
+			}
+		}
+
+		w := &recorder{ResponseWriter: ctx.Writer, ctx: ctx}
+		ctx.Writer = w
+		ctx.Header("X-Cache", "MISS")
+		ctx.Next()
+
+		// POST responses are all no-store for other caches; here only the
+		// handler's own class counts
+		stored := !strings.Contains(w.Header().Get("Cache-Control"), "no-store")
+		if post {
+			class, _ := ctx.Get(classKey)
+			stored = class != NoStore && class != Streamed
+		}
+		if w.Status() == http.StatusOK && stored {
+			c.set(key, entry{
+				status:       w.Status(),
+				contentType:  w.Header().Get("Content-Type"),
+				lastModified: w.Header().Get("Last-Modified"),
+				body:         w.body.Bytes(),
+				expires:      time.Now().Add(policy.TTL),
+			})
+		}
+	}
+}
+
+// bodyHash reads the body of r, leaving it in place for the handler, and
+// returns its SHA-256. JSON bodies are hashed in canonical form, so key
+// order and spacing don't matter.
+func bodyHash(r *http.Request) (string, error) {
+	var body []byte
+	if r.Body != nil {
+		var err error
+		if body, err = io.ReadAll(r.Body); err != nil {
+			return "", err
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err == nil && !dec.More() {
+		if canonical, err := json.Marshal(v); err == nil {
+			body = canonical
+		}
+	}
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// recorder captures the response body while still writing it to the
+// client, except for Streamed responses
+type recorder struct {
+	gin.ResponseWriter
+	ctx  *gin.Context
+	body bytes.Buffer
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	if !r.streamed() {
+		r.body.Write(b)
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *recorder) WriteString(s string) (int, error) {
+	if !r.streamed() {
+		r.body.WriteString(s)
+	}
+	return r.ResponseWriter.WriteString(s)
+}
+
+func (r *recorder) streamed() bool {
+	return IsStreamed(r.ctx)
+}
@@ -0,0 +1,229 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Class says how browsers, CDNs and proxies may cache a response
+type Class int
+
+const (
+	// Revalidate responses may be stored but must be checked against their
+	// ETag before every reuse. It is the default for GET routes without a
+	// policy.
+	Revalidate Class = iota
+	// NoStore responses are never stored, e.g. query results
+	NoStore
+	// Immutable responses never change once served
+	Immutable
+	// Streamed responses are written through as they are produced instead of
+	// being held back for an ETag, e.g. large downloads. They are never
+	// stored. The class must be set before the first write.
+	Streamed
+)
+
+const classKey = "cache.class"
+
+// immutableMaxAge is a year, the conventional maximum
+const immutableMaxAge = 365 * 24 * 60 * 60
+
+// SetClass overrides the class of the response a handler is writing
+func SetClass(ctx *gin.Context, class Class) {
+	ctx.Set(classKey, class)
+}
+
+// IsStreamed reports whether the handler marked its response Streamed
+func IsStreamed(ctx *gin.Context) bool {
+	v, ok := ctx.Get(classKey)
+	return ok && v.(Class) == Streamed
+}
+
+// Headers sets Cache-Control, Vary and ETag on every response and answers
+// If-None-Match with 304, as well as If-Modified-Since for handlers setting
+// Last-Modified. GET routes covered by a policy may be reused for
+// its TTL; other GETs must be revalidated and everything else is no-store.
+// Shared allows caching by CDNs and proxies, which is only safe when every
+// caller is served the same responses.
+func (c *Cache) Headers(shared bool) gin.HandlerFunc {
+	scope := "private"
+	if shared {
+		scope = "public"
+	}
+	return func(ctx *gin.Context) {
+		if ctx.Request.Method != http.MethodGet {
+			ctx.Header("Cache-Control", "no-store")
+			ctx.Next()
+			return
+		}
+
+		w := &bufferedWriter{ResponseWriter: ctx.Writer, ctx: ctx, status: http.StatusOK}
+		h := w.Header()
+		h.Add("Vary", "Accept")
+		if !shared {
+			h.Add("Vary", "Authorization")
+			h.Add("Vary", "X-API-Key")
+			h.Add("Vary", "X-Workspace")
+		}
+		ctx.Writer = w
+		ctx.Next()
+		ctx.Writer = w.ResponseWriter
+
+		if w.streaming {
+			if !w.written {
+				w.flush()
+			}
+			return
+		}
+		if h.Get("Cache-Control") == "" {
+			h.Set("Cache-Control", c.cacheControl(ctx, scope, w.status))
+		}
+		if w.status != http.StatusOK || strings.Contains(h.Get("Cache-Control"), "no-store") {
+			w.flush()
+			return
+		}
+
+		sum := sha256.Sum256(w.body.Bytes())
+		etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+		h.Set("ETag", etag)
+		// If-Modified-Since is ignored when If-None-Match is sent (RFC 9110)
+		inm := ctx.GetHeader("If-None-Match")
+		if matchesETag(inm, etag) || inm == "" && notModifiedSince(ctx.GetHeader("If-Modified-Since"), h.Get("Last-Modified")) {
+			h.Del("Content-Type")
+			h.Del("Content-Length")
+			w.ResponseWriter.WriteHeader(http.StatusNotModified)
+			w.ResponseWriter.WriteHeaderNow()
+			return
+		}
+		w.flush()
+	}
+}
+
+func (c *Cache) cacheControl(ctx *gin.Context, scope string, status int) string {
+	if status != http.StatusOK {
+		return "no-store"
+	}
+	if v, ok := ctx.Get(classKey); ok {
+		switch v.(Class) {
+		case NoStore, Streamed:
+			return "no-store"
+		case Immutable:
+			return scope + ", max-age=" + strconv.Itoa(immutableMaxAge) + ", immutable"
+		}
+		return scope + ", no-cache"
+	}
+	if p, ok := c.policyFor(ctx.FullPath()); ok && p.TTL > 0 {
+		return scope + ", max-age=" + strconv.Itoa(int(p.TTL.Seconds()))
+	}
+	return scope + ", no-cache"
+}
+
+// notModifiedSince reports whether a Last-Modified time is no later than an
+// If-Modified-Since header
+func notModifiedSince(header, lastModified string) bool {
+	if header == "" || lastModified == "" {
+		return false
+	}
+	since, err := http.ParseTime(header)
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(lastModified)
+	return err == nil && !modified.After(since)
+}
+
+// matchesETag reports whether an If-None-Match header lists etag
+func matchesETag(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// bufferedWriter holds back a response until its headers are decided,
+// unless the handler marked it Streamed
+type bufferedWriter struct {
+	gin.ResponseWriter
+	ctx       *gin.Context
+	status    int
+	written   bool
+	streaming bool
+	body      bytes.Buffer
+}
+
+func (w *bufferedWriter) WriteHeader(code int) {
+	w.status = code
+}
+
+func (w *bufferedWriter) WriteHeaderNow() {
+	if w.stream() {
+		return
+	}
+	w.written = true
+}
+
+func (w *bufferedWriter) Write(b []byte) (int, error) {
+	if w.stream() {
+		return w.ResponseWriter.Write(b)
+	}
+	w.written = true
+	return w.body.Write(b)
+}
+
+func (w *bufferedWriter) WriteString(s string) (int, error) {
+	if w.stream() {
+		return w.ResponseWriter.WriteString(s)
+	}
+	w.written = true
+	return w.body.WriteString(s)
+}
+
+// stream reports whether writes go straight to the client, sending the
+// headers on the first one
+func (w *bufferedWriter) stream() bool {
+	if w.streaming {
+		return true
+	}
+	if !IsStreamed(w.ctx) {
+		return false
+	}
+	w.streaming, w.written = true, true
+	w.Header().Set("Cache-Control", "no-store")
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.WriteHeaderNow()
+	return true
+}
+
+func (w *bufferedWriter) Status() int {
+	return w.status
+}
+
+func (w *bufferedWriter) Size() int {
+	if !w.written {
+		return -1
+	}
+	return w.body.Len()
+}
+
+func (w *bufferedWriter) Written() bool {
+	return w.written
+}
+
+// flush sends the held back response
+func (w *bufferedWriter) flush() {
+	w.ResponseWriter.WriteHeader(w.status)
+	if w.body.Len() > 0 {
+		w.ResponseWriter.Write(w.body.Bytes())
+	} else {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
@@ -0,0 +1,456 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	"sql-engine/config"
+	"sql-engine/database"
+	"sql-engine/handlers"
+	"sql-engine/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// queryFormats are the output formats of `query`
+var queryFormats = []string{"csv", "tsv", "json", "ndjson"}
+
+// schemaFormats map the output formats of `schema dump` to the media type
+// GET /schema is asked for
+var schemaFormats = map[string]string{
+	"json": "application/json",
+	"yaml": "application/yaml",
+	"sql":  "application/sql",
+}
+
+// connection holds the flags choosing where client commands send their
+// requests: a running server, or the database itself
+type connection struct {
+	server string
+	apiKey string
+	config string
+	dsn    string
+}
+
+func (conn *connection) register(fs *flag.FlagSet) {
+	fs.StringVar(&conn.server, "server", os.Getenv("SQLENGINE_SERVER"), "URL of a running server; defaults to $SQLENGINE_SERVER")
+	fs.StringVar(&conn.apiKey, "api-key", os.Getenv("SQLENGINE_API_KEY"), "API key for the server; defaults to $SQLENGINE_API_KEY")
+	fs.StringVar(&conn.config, "config", "", "config file to connect to the database directly with, when no server is set")
+	fs.StringVar(&conn.dsn, "dsn", "", "database to connect to directly, when no server is set; overrides the config's")
+}
+
+// client returns the HTTP client and base URL requests go to. Without a
+// server, they are answered in-process by the API's handlers on a
+// connection of their own, which release closes.
+func (conn *connection) client() (client *http.Client, base string, release func(), err error) {
+	if conn.server != "" {
+		return http.DefaultClient, strings.TrimRight(conn.server, "/"), func() {}, nil
+	}
+
+	cfg, err := config.Load(conn.config)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	if conn.dsn != "" {
+		cfg.Database.DSN = conn.dsn
+	}
+	// A one-off command fails rather than waits for the database
+	cfg.Database.ConnectTimeout = 0
+	// Whoever can reach the database directly may as well migrate it
+	cfg.Migrations.Enabled = true
+	dialect, ok := handlers.LookupDialect(database.DriverFor(cfg.Database.DSN))
+	if !ok {
+		return nil, "", nil, errors.New("no dialect registered for driver " + database.DriverFor(cfg.Database.DSN))
+	}
+	if err := database.Init(cfg.Database, dialect.PreparedStatements(), config.TracingConfig{}); err != nil {
+		return nil, "", nil, err
+	}
+
+	// A one-off run shouldn't leave a metadata store behind
+	dir, cleanup := cfg.Store.Dir, func() {}
+	if _, err := os.Stat(dir); err != nil {
+		if dir, err = os.MkdirTemp("", "sqlengine-"); err != nil {
+			database.Close()
+			return nil, "", nil, err
+		}
+		cleanup = func() { os.RemoveAll(dir) }
+	}
+	meta := store.New(dir)
+	if cfg.Store.KeyFile != "" {
+		keys, err := store.LoadKeyring(cfg.Store.KeyFile)
+		if err != nil {
+			database.Close()
+			cleanup()
+			return nil, "", nil, err
+		}
+		meta.UseKeyring(keys)
+	}
+
+	handler := handlers.NewHandler(database.DB, dialect, meta, cfg)
+	r := gin.New()
+	r.POST("/run-query", handler.RunQuery)
+	r.GET("/schema", handler.GetFullSchema)
+	r.GET("/migrations", handler.GetMigrations)
+	r.POST("/migrations/up", handler.MigrateUp)
+	r.POST("/migrations/down", handler.MigrateDown)
+	release = func() {
+		database.Close()
+		cleanup()
+	}
+	return &http.Client{Transport: localTransport{r}}, "http://local", release, nil
+}
+
+// localTransport answers requests with an in-process handler, streaming
+// the response body as the handler writes it
+type localTransport struct {
+	handler http.Handler
+}
+
+func (t localTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	pr, pw := io.Pipe()
+	w := &pipeWriter{header: http.Header{}, body: pw, started: make(chan struct{})}
+	go func() {
+		t.handler.ServeHTTP(w, req)
+		w.WriteHeader(http.StatusOK)
+		pw.Close()
+	}()
+	<-w.started
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", w.code, http.StatusText(w.code)),
+		StatusCode: w.code,
+		Header:     w.header,
+		Body:       pr,
+		Request:    req,
+	}, nil
+}
+
+// pipeWriter is the response writer of localTransport. The response is
+// returned once the handler wrote its status.
+type pipeWriter struct {
+	header  http.Header
+	body    *io.PipeWriter
+	code    int
+	started chan struct{}
+}
+
+func (w *pipeWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *pipeWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+		close(w.started)
+	}
+}
+
+func (w *pipeWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(b)
+}
+
+func (w *pipeWriter) Flush() {}
+
+// runQuery implements `sqlengine query`: it runs a SELECT, read from the
+// arguments or stdin, and prints the rows as they arrive
+func runQuery(args []string) int {
+	fs := flag.NewFlagSet("query", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: sqlengine query [flags] \"SELECT ...\"  (or the SQL on stdin)")
+		fs.PrintDefaults()
+	}
+	var conn connection
+	conn.register(fs)
+	format := fs.String("format", "csv", "output format: "+strings.Join(queryFormats, ", "))
+	noHeader := fs.Bool("no-header", false, "leave out the header line of csv and tsv")
+	positional := parseInterspersed(fs, args)
+	if !slices.Contains(queryFormats, *format) || len(positional) > 1 {
+		fs.Usage()
+		return 2
+	}
+	quietLogs()
+
+	sqlText := ""
+	if len(positional) == 1 && positional[0] != "-" {
+		sqlText = positional[0]
+	} else {
+		b, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return fail(err)
+		}
+		sqlText = string(b)
+	}
+
+	client, base, closeConn, err := conn.client()
+	if err != nil {
+		return fail(err)
+	}
+	defer closeConn()
+
+	body, _ := json.Marshal(handlers.QueryRequest{SQL: sqlText})
+	req, _ := http.NewRequest(http.MethodPost, base+"/run-query", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/x-ndjson")
+	resp, err := conn.do(client, req)
+	if err != nil {
+		return fail(err)
+	}
+	defer resp.Body.Close()
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	w := newRowPrinter(out, *format, !*noHeader)
+	lines := bufio.NewScanner(resp.Body)
+	lines.Buffer(nil, 256<<20)
+	for lines.Scan() {
+		line := lines.Bytes()
+		if len(line) > 0 && line[0] == '[' {
+			var row []json.RawMessage
+			if err := json.Unmarshal(line, &row); err != nil {
+				return fail(err)
+			}
+			if err := w.row(line, row); err != nil {
+				return fail(err)
+			}
+			continue
+		}
+		var msg struct {
+			Columns []string `json:"columns"`
+			Error   string   `json:"error"`
+		}
+		if err := json.Unmarshal(line, &msg); err != nil {
+			return fail(err)
+		}
+		if msg.Error != "" {
+			// Keep the rows printed before the failure
+			w.flush()
+			return fail(errors.New(msg.Error))
+		}
+		if err := w.columns(line, msg.Columns); err != nil {
+			return fail(err)
+		}
+	}
+	if err := lines.Err(); err != nil {
+		return fail(err)
+	}
+	return w.close()
+}
+
+// rowPrinter writes a streamed result in one of queryFormats
+type rowPrinter struct {
+	out    *bufio.Writer
+	format string
+	cols   []string
+	csv    *handlers.CSVWriter
+	// withHeader prints the column names of csv and tsv
+	withHeader bool
+	rows       int
+}
+
+func newRowPrinter(out *bufio.Writer, format string, header bool) *rowPrinter {
+	p := &rowPrinter{out: out, format: format, withHeader: header}
+	switch format {
+	case "csv":
+		p.csv = handlers.NewCSVWriter(out)
+	case "tsv":
+		p.csv = handlers.NewCSVWriter(out)
+		p.csv.Comma = '\t'
+	}
+	return p
+}
+
+// columns takes the columns line
+func (p *rowPrinter) columns(line []byte, cols []string) error {
+	p.cols = cols
+	switch p.format {
+	case "ndjson":
+		_, err := fmt.Fprintf(p.out, "%s\n", line)
+		return err
+	case "json":
+		_, err := p.out.WriteString("[")
+		return err
+	}
+	if p.withHeader {
+		return p.csv.Write(cols, nil)
+	}
+	return nil
+}
+
+// row takes a row line and its values
+func (p *rowPrinter) row(line []byte, values []json.RawMessage) error {
+	p.rows++
+	switch p.format {
+	case "ndjson":
+		_, err := fmt.Fprintf(p.out, "%s\n", line)
+		return err
+	case "json":
+		// Objects keep the columns' order
+		var b bytes.Buffer
+		if p.rows > 1 {
+			b.WriteString(",")
+		}
+		b.WriteString("\n  {")
+		for i, v := range values {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			name, _ := json.Marshal(p.cols[i])
+			fmt.Fprintf(&b, "%s: %s", name, v)
+		}
+		b.WriteString("}")
+		_, err := p.out.Write(b.Bytes())
+		return err
+	}
+	record := make([]string, len(values))
+	nulls := make([]bool, len(values))
+	for i, v := range values {
+		record[i] = csvField(v)
+		nulls[i] = string(v) == "null"
+	}
+	return p.csv.Write(record, nulls)
+}
+
+// close ends the output, returning the exit code
+func (p *rowPrinter) close() int {
+	switch p.format {
+	case "json":
+		if p.rows > 0 {
+			p.out.WriteString("\n")
+		}
+		p.out.WriteString("]\n")
+	}
+	if err := p.flush(); err != nil {
+		return fail(err)
+	}
+	return 0
+}
+
+// flush writes out the rows csv and tsv buffer
+func (p *rowPrinter) flush() error {
+	if p.csv == nil {
+		return nil
+	}
+	return p.csv.Flush()
+}
+
+// csvField prints a JSON value as a CSV field: strings unquoted and
+// objects or arrays as JSON. NULLs are left to the writer.
+func csvField(v json.RawMessage) string {
+	var s string
+	if json.Unmarshal(v, &s) == nil || string(v) == "null" {
+		return s
+	}
+	return string(v)
+}
+
+// runSchema implements `sqlengine schema dump`: it prints the tables of a
+// schema with their columns and keys, or their CREATE statements
+func runSchema(args []string) int {
+	fs := flag.NewFlagSet("schema dump", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: sqlengine schema dump [flags]")
+		fs.PrintDefaults()
+	}
+	var conn connection
+	conn.register(fs)
+	schema := fs.String("schema", "", "schema to dump; defaults to the database's default schema")
+	format := fs.String("format", "json", "output format: json, yaml or sql")
+	positional := parseInterspersed(fs, args)
+	mime, ok := schemaFormats[*format]
+	if !ok || len(positional) != 1 || positional[0] != "dump" {
+		fs.Usage()
+		return 2
+	}
+	quietLogs()
+
+	client, base, closeConn, err := conn.client()
+	if err != nil {
+		return fail(err)
+	}
+	defer closeConn()
+
+	target := base + "/schema"
+	if *schema != "" {
+		target += "?" + url.Values{"schema": {*schema}}.Encode()
+	}
+	req, _ := http.NewRequest(http.MethodGet, target, nil)
+	req.Header.Set("Accept", mime)
+	resp, err := conn.do(client, req)
+	if err != nil {
+		return fail(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fail(err)
+	}
+	if *format == "json" {
+		var indented bytes.Buffer
+		if json.Indent(&indented, body, "", "  ") == nil {
+			body = append(indented.Bytes(), '\n')
+		}
+	}
+	os.Stdout.Write(body)
+	return 0
+}
+
+// do sends req with the API key, turning error responses into errors
+// unless their status is among accept
+func (conn *connection) do(client *http.Client, req *http.Request, accept ...int) (*http.Response, error) {
+	if conn.apiKey != "" {
+		req.Header.Set("X-API-Key", conn.apiKey)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusOK || slices.Contains(accept, resp.StatusCode) {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	var body struct {
+		Error string `json:"error"`
+	}
+	b, _ := io.ReadAll(resp.Body)
+	if json.Unmarshal(b, &body) != nil || body.Error == "" {
+		body.Error = strings.TrimSpace(string(b))
+	}
+	return nil, fmt.Errorf("%s: %s", resp.Status, body.Error)
+}
+
+// parseInterspersed parses flags placed before, between or after the
+// positional arguments, which it returns
+func parseInterspersed(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		fs.Parse(args)
+		if fs.NArg() == 0 {
+			return positional
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// quietLogs keeps the server's logs out of a client command's output
+func quietLogs() {
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError})))
+	gin.SetMode(gin.ReleaseMode)
+}
+
+// fail reports err and returns the exit code of a failed command
+func fail(err error) int {
+	fmt.Fprintln(os.Stderr, "sqlengine:", err)
+	return 1
+}
@@ -1,31 +1,314 @@
 package database
 
 import (
+	"context"
 	"database/sql"
-	"log"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
 
-	_ "github.com/jackc/pgx/v5/stdlib"
+	"sql-engine/config"
+	"sql-engine/secrets"
+	"sql-engine/tracing"
+
+	"github.com/XSAM/otelsql"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	_ "modernc.org/sqlite"
+)
+
+// Supported database/sql driver names
+const (
+	DriverPostgres = "pgx"
+	DriverSQLite   = "sqlite"
 )
 
 var DB *sql.DB
 
-func Init(dsn string) error {
-	var err error
-	DB, err = sql.Open("pgx", dsn)
+// LowPriorityDB is the pool of low-priority callers, nil unless
+// database.low_priority is configured
+var LowPriorityDB *sql.DB
+
+// ReplicaDBs are the pools of the read replicas in database.replicas
+var ReplicaDBs []*sql.DB
+
+// schemes maps DSN URL schemes to the driver registered for them
+var schemes = map[string]string{}
+
+// RegisterScheme routes DSNs using the given URL scheme (e.g. "clickhouse")
+// to a database/sql driver. The DSN is passed to the driver unchanged.
+func RegisterScheme(scheme, driver string) {
+	schemes[scheme] = driver
+}
+
+// Driver is the database/sql driver selected from the DSN passed to Init
+var Driver string
+
+// DriverFor returns the driver Init selects for a DSN
+func DriverFor(dsn string) string {
+	driver, _ := parseDSN(dsn)
+	return driver
+}
+
+// traceCfg instruments the pools opened by Init when tracing is enabled
+var traceCfg config.TracingConfig
+
+// Init opens the connection pool. prepare lists statements prepared on every
+// new Postgres connection, so that the first queries after a connection is
+// opened skip parsing and planning. With tracing enabled, every database
+// call gets a span.
+func Init(cfg config.DatabaseConfig, prepare []string, trace config.TracingConfig) error {
+	Driver, _ = parseDSN(cfg.DSN)
+	traceCfg = trace
+	resolver = secrets.New(cfg.Secrets)
+
+	source, secret, err := resolveDSN(cfg.DSN)
 	if err != nil {
 		return err
 	}
-
-	if err = DB.Ping(); err != nil {
+	if cfg.SSH.Enabled() {
+		if Driver != DriverPostgres {
+			return errors.New("database.ssh needs a PostgreSQL database")
+		}
+		if tunnel, err = newSSHTunnel(cfg.SSH); err != nil {
+			return fmt.Errorf("database.ssh: %w", err)
+		}
+	}
+	if DB, err = openPool(cfg, source, prepare, secret); err != nil {
+		return err
+	}
+	if err = connect(DB, cfg); err != nil {
 		return err
 	}
+	if cfg.LowPriority.Enabled() {
+		if LowPriorityDB, err = openLowPriority(cfg, source, secret); err != nil {
+			return fmt.Errorf("low-priority pool: %w", err)
+		}
+	}
+	var rotating []*secretDSN
+	if secret != nil {
+		rotating = append(rotating, secret)
+	}
+	for i, dsn := range cfg.Replicas.DSNs {
+		secret, err := openReplica(cfg, dsn, prepare)
+		if err != nil {
+			return fmt.Errorf("replica %d: %w", i+1, err)
+		}
+		if secret != nil {
+			rotating = append(rotating, secret)
+		}
+	}
+	refreshSecrets(cfg.Secrets, rotating)
 
-	log.Println("Database connected successfully")
+	slog.Info("Database connected")
 	return nil
 }
 
+// maxConnectBackoff caps the wait between connection attempts at startup
+const maxConnectBackoff = 10 * time.Second
+
+// connect pings the database until it answers, retrying with exponential
+// backoff for database.connect_timeout, so that the server can start
+// alongside a database that is still starting up
+func connect(db *sql.DB, cfg config.DatabaseConfig) error {
+	deadline := time.Now().Add(cfg.ConnectTimeout)
+	delay := cfg.ConnectBackoff
+	for {
+		err := db.Ping()
+		if err == nil {
+			return nil
+		}
+		if time.Now().Add(delay).After(deadline) {
+			return err
+		}
+		slog.Warn("Database not reachable yet, retrying", "error", err, "retry_in", delay.String())
+		time.Sleep(delay)
+		delay = min(delay*2, maxConnectBackoff)
+	}
+}
+
+// openPool opens a pool on source with the pool settings of cfg, logging
+// in with the credentials of secret when set
+func openPool(cfg config.DatabaseConfig, source string, prepare []string, secret *secretDSN) (*sql.DB, error) {
+	var db *sql.DB
+	var err error
+	if Driver == DriverPostgres {
+		db, err = openPostgres(source, prepare, secret)
+	} else {
+		db, err = open(source)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Zero values keep the database/sql defaults
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+	secret.use(db, cfg.MaxIdleConns)
+	return db, nil
+}
+
+// openReplica adds the pool of a read replica to ReplicaDBs. A replica
+// that doesn't answer yet only gets queries once its health checks pass.
+func openReplica(cfg config.DatabaseConfig, dsn string, prepare []string) (*secretDSN, error) {
+	if driver := DriverFor(dsn); driver != Driver {
+		return nil, fmt.Errorf("uses driver %s, the primary %s", driver, Driver)
+	}
+	source, secret, err := resolveDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	db, err := openPool(cfg, source, prepare, secret)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		slog.Warn("Replica unavailable, queries stay on the primary until it answers", "replica", fmt.Sprintf("replica_%d", len(ReplicaDBs)+1), "error", err)
+	}
+	ReplicaDBs = append(ReplicaDBs, db)
+	return secret, nil
+}
+
+// openLowPriority opens the low-priority pool. Its PostgreSQL connections
+// start with the pool's statement_timeout and work_mem.
+func openLowPriority(cfg config.DatabaseConfig, source string, secret *secretDSN) (*sql.DB, error) {
+	low := cfg.LowPriority
+	var db *sql.DB
+	if Driver == DriverPostgres {
+		connConfig, err := parsePostgres(source)
+		if err != nil {
+			return nil, err
+		}
+		if low.StatementTimeout > 0 {
+			connConfig.RuntimeParams["statement_timeout"] = strconv.FormatInt(low.StatementTimeout.Milliseconds(), 10)
+		}
+		if low.WorkMem != "" {
+			connConfig.RuntimeParams["work_mem"] = low.WorkMem
+		}
+		var options []stdlib.OptionOpenDB
+		if secret != nil {
+			options = append(options, stdlib.OptionBeforeConnect(secret.beforeConnect))
+		}
+		db = openConnector(stdlib.GetConnector(*connConfig, options...))
+	} else {
+		var err error
+		if db, err = open(source); err != nil {
+			return nil, err
+		}
+	}
+	db.SetMaxOpenConns(low.MaxOpenConns)
+	db.SetMaxIdleConns(low.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	secret.use(db, low.MaxIdleConns)
+	return db, nil
+}
+
+// parseDSN maps a DSN to the driver that handles it and the data source name
+// that driver expects. sqlite:// DSNs point at a database file, e.g.
+// sqlite://demo.db or sqlite:///var/lib/demo.db.
+func parseDSN(dsn string) (driver, source string) {
+	if path, ok := strings.CutPrefix(dsn, "sqlite://"); ok {
+		if !strings.Contains(path, "_pragma=") {
+			sep := "?"
+			if strings.Contains(path, "?") {
+				sep = "&"
+			}
+			path += sep + "_pragma=foreign_keys(1)"
+		}
+		return DriverSQLite, path
+	}
+	if scheme, _, ok := strings.Cut(dsn, "://"); ok {
+		if driver, ok := schemes[scheme]; ok {
+			return driver, dsn
+		}
+	}
+	return DriverPostgres, dsn
+}
+
+// parsePostgres parses a PostgreSQL DSN, dialing through the SSH tunnel
+// when one is configured
+func parsePostgres(source string) (*pgx.ConnConfig, error) {
+	connConfig, err := pgx.ParseConfig(source)
+	if err != nil {
+		return nil, err
+	}
+	if tunnel != nil {
+		tunnel.apply(connConfig)
+	}
+	return connConfig, nil
+}
+
+func openPostgres(source string, prepare []string, secret *secretDSN) (*sql.DB, error) {
+	connConfig, err := parsePostgres(source)
+	if err != nil {
+		return nil, err
+	}
+	afterConnect := func(ctx context.Context, conn *pgx.Conn) error {
+		for _, stmt := range prepare {
+			// Preparing under the SQL text makes pgx use the statement
+			// whenever that exact text is queried
+			if _, err := conn.Prepare(ctx, stmt, stmt); err != nil {
+				return fmt.Errorf("preparing introspection statement: %w", err)
+			}
+		}
+		return nil
+	}
+	options := []stdlib.OptionOpenDB{stdlib.OptionAfterConnect(afterConnect)}
+	if secret != nil {
+		options = append(options, stdlib.OptionBeforeConnect(secret.beforeConnect))
+	}
+	return openConnector(stdlib.GetConnector(*connConfig, options...)), nil
+}
+
+// open opens a pool with the selected driver, instrumented when tracing is
+// enabled
+func open(source string) (*sql.DB, error) {
+	if traceCfg.Enabled {
+		return otelsql.Open(Driver, source, tracing.DatabaseOptions(traceCfg, dbSystem())...)
+	}
+	return sql.Open(Driver, source)
+}
+
+func openConnector(connector driver.Connector) *sql.DB {
+	if traceCfg.Enabled {
+		return otelsql.OpenDB(connector, tracing.DatabaseOptions(traceCfg, dbSystem())...)
+	}
+	return sql.OpenDB(connector)
+}
+
+// dbSystem names the database in spans
+func dbSystem() string {
+	switch Driver {
+	case DriverPostgres:
+		return "postgresql"
+	case DriverSQLite:
+		return "sqlite"
+	}
+	return Driver
+}
+
 func Close() {
 	if DB != nil {
 		DB.Close()
 	}
+	if LowPriorityDB != nil {
+		LowPriorityDB.Close()
+	}
+	for _, db := range ReplicaDBs {
+		db.Close()
+	}
+	if tunnel != nil {
+		tunnel.close()
+	}
 }
@@ -0,0 +1,133 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"sql-engine/config"
+	"sql-engine/secrets"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// resolver fetches the secrets referenced in DSNs
+var resolver *secrets.Resolver
+
+// secretDSN is a DSN referring to secrets. New PostgreSQL connections of
+// its pools log in with the credentials fetched last.
+type secretDSN struct {
+	template string
+
+	mu     sync.Mutex
+	source string
+	pools  []pool
+}
+
+// pool is a pool using a secretDSN, and the idle connections it keeps
+type pool struct {
+	db      *sql.DB
+	maxIdle int
+}
+
+// resolveDSN fetches the secrets a DSN refers to. s is nil for DSNs
+// without references.
+func resolveDSN(dsn string) (source string, s *secretDSN, err error) {
+	if !secrets.HasReferences(dsn) {
+		_, source = parseDSN(dsn)
+		return source, nil, nil
+	}
+	if source, err = expandDSN(dsn); err != nil {
+		return "", nil, err
+	}
+	return source, &secretDSN{template: dsn, source: source}, nil
+}
+
+func expandDSN(dsn string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	// In PostgreSQL URLs secrets are escaped, so that passwords may hold
+	// any character
+	var escape func(string) string
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		escape = url.PathEscape
+	}
+	expanded, err := resolver.Expand(ctx, dsn, escape)
+	if err != nil {
+		return "", err
+	}
+	_, source := parseDSN(expanded)
+	return source, nil
+}
+
+// use records that db takes its credentials from s
+func (s *secretDSN) use(db *sql.DB, maxIdle int) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pools = append(s.pools, pool{db: db, maxIdle: maxIdle})
+}
+
+// beforeConnect sets the user and password fetched last
+func (s *secretDSN) beforeConnect(ctx context.Context, connConfig *pgx.ConnConfig) error {
+	s.mu.Lock()
+	source := s.source
+	s.mu.Unlock()
+	fresh, err := pgx.ParseConfig(source)
+	if err != nil {
+		return err
+	}
+	connConfig.User, connConfig.Password = fresh.User, fresh.Password
+	return nil
+}
+
+// refresh fetches the secrets again. When they changed, the idle
+// connections of its pools are closed, so that the ones opened next log in
+// with the new credentials; busy connections are closed by
+// conn_max_lifetime. A failed fetch keeps the current credentials.
+func (s *secretDSN) refresh() {
+	source, err := expandDSN(s.template)
+	if err != nil {
+		slog.Error("Fetching database credentials failed, keeping the current ones", "error", err)
+		return
+	}
+	s.mu.Lock()
+	changed := source != s.source
+	s.source = source
+	pools := s.pools
+	s.mu.Unlock()
+	if !changed {
+		return
+	}
+	if Driver != DriverPostgres {
+		slog.Warn("Database credentials changed; restart to use them", "driver", Driver)
+		return
+	}
+	slog.Info("Database credentials rotated, reconnecting idle connections")
+	for _, p := range pools {
+		p.db.SetMaxIdleConns(0)
+		p.db.SetMaxIdleConns(p.maxIdle)
+	}
+}
+
+// refreshSecrets fetches the secrets of every DSN again every interval
+func refreshSecrets(cfg config.SecretsConfig, dsns []*secretDSN) {
+	if cfg.RefreshInterval == 0 || len(dsns) == 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(cfg.RefreshInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			for _, s := range dsns {
+				s.refresh()
+			}
+		}
+	}()
+}
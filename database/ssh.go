@@ -0,0 +1,167 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"sql-engine/config"
+
+	"github.com/jackc/pgx/v5"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// tunnel dials the PostgreSQL connections of every pool through the SSH
+// bastion of database.ssh; nil connects directly
+var tunnel *sshTunnel
+
+// sshTunnel holds one SSH connection to a bastion, opened on first use and
+// again after it drops, and forwards database connections over it. The
+// bastion resolves the database's host name, so private names such as an
+// RDS endpoint work.
+type sshTunnel struct {
+	addr      string
+	clientCfg *ssh.ClientConfig
+	keepAlive time.Duration
+
+	mu     sync.Mutex
+	client *ssh.Client
+}
+
+func newSSHTunnel(cfg config.SSHTunnelConfig) (*sshTunnel, error) {
+	key, err := os.ReadFile(cfg.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	var signer ssh.Signer
+	if cfg.KeyPassphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(cfg.KeyPassphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey(key)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("key_file: %w", err)
+	}
+	hostKey := ssh.InsecureIgnoreHostKey()
+	if !cfg.InsecureIgnoreHostKey {
+		if hostKey, err = knownhosts.New(cfg.KnownHostsFile); err != nil {
+			return nil, fmt.Errorf("known_hosts_file: %w", err)
+		}
+	}
+	addr := cfg.Host
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "22")
+	}
+	return &sshTunnel{
+		addr: addr,
+		clientCfg: &ssh.ClientConfig{
+			User:            cfg.User,
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			HostKeyCallback: hostKey,
+			Timeout:         10 * time.Second,
+		},
+		keepAlive: cfg.KeepAlive,
+	}, nil
+}
+
+// apply makes pgx dial through the tunnel and leave name resolution to the
+// bastion
+func (t *sshTunnel) apply(connConfig *pgx.ConnConfig) {
+	connConfig.DialFunc = t.dial
+	connConfig.LookupFunc = func(ctx context.Context, host string) ([]string, error) {
+		return []string{host}, nil
+	}
+}
+
+// dial opens a connection to addr from the bastion. A dial failing on an
+// SSH connection that broke since its last use is tried once more on a
+// new one.
+func (t *sshTunnel) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	for retried := false; ; retried = true {
+		client, err := t.connect(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("ssh tunnel to %s: %w", t.addr, err)
+		}
+		conn, err := client.DialContext(ctx, network, addr)
+		if err == nil {
+			return conn, nil
+		}
+		var openErr *ssh.OpenChannelError
+		if retried || ctx.Err() != nil || errors.As(err, &openErr) {
+			// The bastion refusing the forward is no broken connection
+			return nil, fmt.Errorf("ssh tunnel to %s: %w", t.addr, err)
+		}
+		t.drop(client, err)
+	}
+}
+
+// connect returns the SSH connection, opening it if needed
+func (t *sshTunnel) connect(ctx context.Context) (*ssh.Client, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.client != nil {
+		return t.client, nil
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", t.addr)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, t.addr, t.clientCfg)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	t.client = ssh.NewClient(c, chans, reqs)
+	slog.Info("SSH tunnel connected", "bastion", t.addr)
+	if t.keepAlive > 0 {
+		go t.keepAlives(t.client)
+	}
+	return t.client, nil
+}
+
+// keepAlives pings the bastion so that idle tunnels aren't closed by
+// firewalls and a dead one is noticed before the next dial
+func (t *sshTunnel) keepAlives(client *ssh.Client) {
+	ticker := time.NewTicker(t.keepAlive)
+	defer ticker.Stop()
+	for range ticker.C {
+		if _, _, err := client.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+			t.drop(client, err)
+			return
+		}
+	}
+}
+
+// drop closes a broken SSH connection so that the next dial opens another.
+// Database connections forwarded over it break too, and the pool replaces
+// them.
+func (t *sshTunnel) drop(client *ssh.Client, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.client != client {
+		return
+	}
+	slog.Warn("SSH tunnel lost, reconnecting on next use", "bastion", t.addr, "error", err)
+	client.Close()
+	t.client = nil
+}
+
+func (t *sshTunnel) close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.client != nil {
+		t.client.Close()
+		t.client = nil
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"sql-engine/config"
+	"sql-engine/database"
+	"sql-engine/handlers"
+	"sql-engine/store"
+)
+
+// doctor runs the self-checks of GET /admin/doctor without starting the
+// server, for diagnosing one that won't start, and returns the exit code:
+// 1 when a check failed
+func doctor(args []string) int {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	configPath := fs.String("config", "", "path to a YAML config file")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	fs.Parse(args)
+
+	// Keep the log from interleaving with the report
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn})))
+
+	var checks []handlers.DoctorCheck
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		checks = append(checks, handlers.DoctorCheck{Name: "config", Status: handlers.CheckFail, Message: err.Error()})
+		return printReport(handlers.NewDoctorReport(checks), *asJSON)
+	}
+
+	dialect, ok := handlers.LookupDialect(database.DriverFor(cfg.Database.DSN))
+	if !ok {
+		checks = append(checks, handlers.DoctorCheck{
+			Name: "config", Status: handlers.CheckFail,
+			Message: "No dialect registered for driver " + database.DriverFor(cfg.Database.DSN),
+		})
+		return printReport(handlers.NewDoctorReport(checks), *asJSON)
+	}
+	// The checks report connection errors themselves, without waiting for
+	// the database to come up
+	cfg.Database.ConnectTimeout = 0
+	database.Init(cfg.Database, nil, config.TracingConfig{})
+	defer database.Close()
+
+	meta := store.New(cfg.Store.Dir)
+	if cfg.Store.KeyFile != "" {
+		keys, err := store.LoadKeyring(cfg.Store.KeyFile)
+		if err != nil {
+			checks = append(checks, handlers.DoctorCheck{
+				Name: "store_keyring", Status: handlers.CheckFail,
+				Message: "Metadata store keyring failed to load: " + err.Error(),
+			})
+		} else {
+			meta.UseKeyring(keys)
+		}
+	}
+
+	handler := handlers.NewHandler(database.DB, dialect, meta, cfg)
+	handler.UseLowPriorityPool(database.LowPriorityDB)
+	checks = append(handler.DoctorChecks(context.Background()), checks...)
+	return printReport(handlers.NewDoctorReport(checks), *asJSON)
+}
+
+func printReport(report handlers.DoctorReport, asJSON bool) int {
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		writeReport(os.Stdout, report)
+	}
+	if report.Status == handlers.CheckFail {
+		return 1
+	}
+	return 0
+}
+
+// writeReport prints a check per line, with its hint below it
+func writeReport(w io.Writer, report handlers.DoctorReport) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, check := range report.Checks {
+		// Driver errors may span lines
+		message := strings.Join(strings.Fields(check.Message), " ")
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", check.Status, check.Name, message)
+		if check.Hint != "" {
+			fmt.Fprintf(tw, "\t\t→ %s\n", check.Hint)
+		}
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "\nOverall: %s\n", report.Status)
+}
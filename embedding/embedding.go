@@ -0,0 +1,42 @@
+// Package embedding turns text into vectors, e.g. to search pgvector
+// columns by the meaning of a phrase
+package embedding
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"sql-engine/config"
+)
+
+// Embedder returns the embedding of a text
+type Embedder interface {
+	Embed(ctx context.Context, text string) ([]float64, error)
+}
+
+var (
+	embeddersMu sync.RWMutex
+	embedders   = map[string]func(cfg config.EmbeddingConfig) Embedder{}
+)
+
+// Register makes an embedder available under name for embedding.provider
+func Register(name string, factory func(cfg config.EmbeddingConfig) Embedder) {
+	embeddersMu.Lock()
+	defer embeddersMu.Unlock()
+	embedders[name] = factory
+}
+
+// New returns the configured embedder, or nil when no URL is configured
+func New(cfg config.EmbeddingConfig) (Embedder, error) {
+	if cfg.URL == "" {
+		return nil, nil
+	}
+	embeddersMu.RLock()
+	factory, ok := embedders[cfg.Provider]
+	embeddersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown embedding provider %q", cfg.Provider)
+	}
+	return factory(cfg), nil
+}
@@ -0,0 +1,79 @@
+package embedding
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"sql-engine/config"
+)
+
+func init() {
+	Register("openai", func(cfg config.EmbeddingConfig) Embedder {
+		return &openAI{cfg: cfg, client: &http.Client{Timeout: cfg.Timeout}}
+	})
+}
+
+// openAI talks to an OpenAI-compatible embeddings endpoint
+type openAI struct {
+	cfg    config.EmbeddingConfig
+	client *http.Client
+}
+
+type embeddingRequest struct {
+	Model string `json:"model"`
+	Input string `json:"input"`
+}
+
+type embeddingResponse struct {
+	Data []struct {
+		Embedding []float64 `json:"embedding"`
+	} `json:"data"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (o *openAI) Embed(ctx context.Context, text string) ([]float64, error) {
+	body, err := json.Marshal(embeddingRequest{Model: o.cfg.Model, Input: text})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(o.cfg.URL, "/")+"/embeddings", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if o.cfg.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+o.cfg.APIKey)
+	}
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if err != nil {
+		return nil, err
+	}
+	var out embeddingResponse
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, fmt.Errorf("embedding API returned %s", resp.Status)
+	}
+	if out.Error != nil {
+		return nil, fmt.Errorf("embedding API returned %s: %s", resp.Status, out.Error.Message)
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("embedding API returned %s", resp.Status)
+	}
+	if len(out.Data) == 0 || len(out.Data[0].Embedding) == 0 {
+		return nil, errors.New("embedding API returned no embedding")
+	}
+	return out.Data[0].Embedding, nil
+}
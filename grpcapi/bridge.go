@@ -0,0 +1,158 @@
+package grpcapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// decodeJSON reads REST responses into messages, whose field names match
+// and which leave out the fields gRPC clients don't get
+var decodeJSON = protojson.UnmarshalOptions{DiscardUnknown: true}
+
+// newRequest builds the REST request a call stands for. The call's metadata
+// become headers, so that credentials pass as authorization or x-api-key.
+func newRequest(ctx context.Context, method, target string, body any) (*http.Request, error) {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+	r, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(payload))
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	r.RequestURI = target
+	r.Proto, r.ProtoMajor, r.ProtoMinor = "HTTP/2.0", 2, 0
+	md, _ := metadata.FromIncomingContext(ctx)
+	for key, values := range md {
+		switch {
+		case strings.HasPrefix(key, ":"), strings.HasPrefix(key, "grpc-"),
+			key == "content-type", key == "accept", key == "accept-encoding", key == "te":
+			continue
+		}
+		for _, v := range values {
+			r.Header.Add(key, v)
+		}
+	}
+	if authority := md.Get(":authority"); len(authority) > 0 {
+		r.Host = authority[0]
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		r.RemoteAddr = p.Addr.String()
+	}
+	if body != nil {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	r.Header.Set("Accept", "application/json")
+	return r, nil
+}
+
+// call serves a unary call's request and decodes the JSON answer into resp
+func (s *Service) call(ctx context.Context, method, target string, body any, resp proto.Message) error {
+	r, err := newRequest(ctx, method, target, body)
+	if err != nil {
+		return err
+	}
+	w := &recorder{header: http.Header{}}
+	s.api.ServeHTTP(w, r)
+	grpc.SetHeader(ctx, responseMetadata(w.header))
+	if w.status() != http.StatusOK {
+		return statusError(w.status(), w.body.Bytes())
+	}
+	if err := decodeJSON.Unmarshal(w.body.Bytes(), resp); err != nil {
+		return status.Error(codes.Internal, "Malformed response: "+err.Error())
+	}
+	return nil
+}
+
+// recorder buffers a response
+type recorder struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func (w *recorder) Header() http.Header {
+	return w.header
+}
+
+func (w *recorder) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+}
+
+func (w *recorder) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(b)
+}
+
+func (w *recorder) Flush() {}
+
+func (w *recorder) status() int {
+	if w.code == 0 {
+		return http.StatusOK
+	}
+	return w.code
+}
+
+// responseMetadata forwards the headers telling clients about the request
+// rather than the body: X-Request-ID, rate limits, Retry-After and the like
+func responseMetadata(header http.Header) metadata.MD {
+	md := metadata.MD{}
+	for key, values := range header {
+		if strings.HasPrefix(key, "X-") || key == "Retry-After" {
+			md.Append(strings.ToLower(key), values...)
+		}
+	}
+	return md
+}
+
+// statusError turns an error response into the gRPC status closest to its
+// HTTP status, with the message of its error field
+func statusError(code int, body []byte) error {
+	var resp struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(body, &resp) != nil || resp.Error == "" {
+		resp.Error = http.StatusText(code)
+	}
+	return status.Error(statusCode(code), resp.Error)
+}
+
+func statusCode(code int) codes.Code {
+	switch code {
+	case http.StatusBadRequest, http.StatusNotAcceptable, http.StatusUnprocessableEntity:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.Aborted
+	case http.StatusRequestEntityTooLarge, http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusNotImplemented:
+		return codes.Unimplemented
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return codes.Unavailable
+	case http.StatusGatewayTimeout:
+		return codes.DeadlineExceeded
+	}
+	return codes.Internal
+}
@@ -0,0 +1,201 @@
+package grpcapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net/http"
+	"strings"
+
+	pb "sql-engine/grpcapi/sqlenginepb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// maxBatchBytes bounds the NDJSON behind one RowBatch, keeping messages
+// well under clients' default 4 MiB receive limit
+const maxBatchBytes = 1 << 20
+
+// maxExactInt is the largest integer a double holds exactly
+const maxExactInt = 1 << 53
+
+// rowWriter turns the NDJSON answer of POST /run-query into RunQuery
+// messages: a header for the columns line, then a batch of rows each time
+// the handler flushes. Error answers are buffered for finish to report.
+type rowWriter struct {
+	stream grpc.ServerStreamingServer[pb.RunQueryResponse]
+	header http.Header
+	code   int
+	buf    bytes.Buffer
+	batch  []*pb.Row
+	size   int
+	// err is the stream's failure, which ends the query at its next write
+	err error
+}
+
+func newRowWriter(stream grpc.ServerStreamingServer[pb.RunQueryResponse]) *rowWriter {
+	return &rowWriter{stream: stream, header: http.Header{}}
+}
+
+func (w *rowWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *rowWriter) WriteHeader(code int) {
+	if w.code != 0 {
+		return
+	}
+	w.code = code
+	if code == http.StatusOK {
+		w.stream.SetHeader(responseMetadata(w.header))
+	}
+}
+
+func (w *rowWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	if w.err != nil {
+		return 0, w.err
+	}
+	w.buf.Write(b)
+	if w.code == http.StatusOK {
+		w.consume()
+	}
+	return len(b), w.err
+}
+
+// Flush sends the rows read so far
+func (w *rowWriter) Flush() {
+	if w.code == http.StatusOK && w.err == nil {
+		w.consume()
+		w.send()
+	}
+}
+
+// finish sends the remaining rows, or returns the status of an error
+// answer or of an error line ending the stream
+func (w *rowWriter) finish() error {
+	if w.code != 0 && w.code != http.StatusOK {
+		w.stream.SetHeader(responseMetadata(w.header))
+		return statusError(w.code, w.buf.Bytes())
+	}
+	if w.err == nil {
+		w.consume()
+		w.send()
+	}
+	return w.err
+}
+
+// consume parses the complete lines buffered, sending the header and
+// batches past maxBatchBytes
+func (w *rowWriter) consume() {
+	for w.err == nil {
+		line, ok := w.nextLine()
+		if !ok {
+			return
+		}
+		switch {
+		case len(line) == 0:
+		case line[0] == '[':
+			values, err := decodeRow(line)
+			if err != nil {
+				w.err = status.Error(codes.Internal, "Malformed row: "+err.Error())
+				return
+			}
+			w.batch = append(w.batch, &pb.Row{Values: values})
+			if w.size += len(line); w.size >= maxBatchBytes {
+				w.send()
+			}
+		default:
+			var msg struct {
+				Columns          []string `json:"columns"`
+				SuppressedGroups int32    `json:"suppressed_groups"`
+				Error            string   `json:"error"`
+			}
+			if err := json.Unmarshal(line, &msg); err != nil {
+				w.err = status.Error(codes.Internal, "Malformed response: "+err.Error())
+				return
+			}
+			if msg.Error != "" {
+				w.send()
+				if w.err == nil {
+					w.err = status.Error(codes.Internal, msg.Error)
+				}
+				return
+			}
+			w.err = w.stream.Send(&pb.RunQueryResponse{Part: &pb.RunQueryResponse_Header{
+				Header: &pb.QueryHeader{Columns: msg.Columns, SuppressedGroups: msg.SuppressedGroups},
+			}})
+		}
+	}
+}
+
+// nextLine takes the next complete line out of the buffer
+func (w *rowWriter) nextLine() ([]byte, bool) {
+	i := bytes.IndexByte(w.buf.Bytes(), '\n')
+	if i < 0 {
+		return nil, false
+	}
+	line := w.buf.Next(i + 1)
+	return bytes.TrimSpace(line), true
+}
+
+// send sends the rows batched so far, if any
+func (w *rowWriter) send() {
+	if len(w.batch) == 0 || w.err != nil {
+		return
+	}
+	w.err = w.stream.Send(&pb.RunQueryResponse{Part: &pb.RunQueryResponse_Rows{Rows: &pb.RowBatch{Rows: w.batch}}})
+	w.batch, w.size = nil, 0
+}
+
+// decodeRow converts a row's JSON values. Integers a double can't hold
+// exactly stay decimal strings.
+func decodeRow(line []byte) ([]*structpb.Value, error) {
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.UseNumber()
+	var row []any
+	if err := dec.Decode(&row); err != nil {
+		return nil, err
+	}
+	values := make([]*structpb.Value, len(row))
+	for i, v := range row {
+		values[i] = toValue(v)
+	}
+	return values, nil
+}
+
+func toValue(v any) *structpb.Value {
+	switch v := v.(type) {
+	case json.Number:
+		if !strings.ContainsAny(v.String(), ".eE") {
+			if n, err := v.Int64(); err != nil || n > maxExactInt || n < -maxExactInt {
+				return structpb.NewStringValue(v.String())
+			}
+		}
+		f, err := v.Float64()
+		if err != nil || math.IsInf(f, 0) {
+			return structpb.NewStringValue(v.String())
+		}
+		return structpb.NewNumberValue(f)
+	case []any:
+		list := make([]*structpb.Value, len(v))
+		for i, item := range v {
+			list[i] = toValue(item)
+		}
+		return structpb.NewListValue(&structpb.ListValue{Values: list})
+	case map[string]any:
+		fields := make(map[string]*structpb.Value, len(v))
+		for k, item := range v {
+			fields[k] = toValue(item)
+		}
+		return structpb.NewStructValue(&structpb.Struct{Fields: fields})
+	case string:
+		return structpb.NewStringValue(v)
+	case bool:
+		return structpb.NewBoolValue(v)
+	}
+	return structpb.NewNullValue()
+}
@@ -0,0 +1,84 @@
+// Package grpcapi serves the SQLEngine gRPC service. Its calls are replayed
+// as requests to the REST API's handler, so they go through the same
+// middleware (authentication, grants, rate limits, logging) and handlers,
+// and answer the same results.
+package grpcapi
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"sql-engine/config"
+	pb "sql-engine/grpcapi/sqlenginepb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/reflection"
+)
+
+// Service implements SQLEngine on top of the REST API
+type Service struct {
+	pb.UnimplementedSQLEngineServer
+	api http.Handler
+}
+
+// NewService returns the service answering through api, the REST API's
+// handler
+func NewService(api http.Handler) *Service {
+	return &Service{api: api}
+}
+
+// NewServer returns a gRPC server for the service, serving TLS when cfg
+// has a certificate
+func NewServer(cfg config.GRPCConfig, api http.Handler) (*grpc.Server, error) {
+	var opts []grpc.ServerOption
+	if cfg.CertFile != "" {
+		creds, err := credentials.NewServerTLSFromFile(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+	srv := grpc.NewServer(opts...)
+	pb.RegisterSQLEngineServer(srv, NewService(api))
+	if cfg.Reflection {
+		reflection.Register(srv)
+	}
+	return srv, nil
+}
+
+func (s *Service) ListSchemas(ctx context.Context, req *pb.ListSchemasRequest) (*pb.ListSchemasResponse, error) {
+	resp := &pb.ListSchemasResponse{}
+	return resp, s.call(ctx, http.MethodGet, "/schemas", nil, resp)
+}
+
+func (s *Service) ListTables(ctx context.Context, req *pb.ListTablesRequest) (*pb.ListTablesResponse, error) {
+	resp := &pb.ListTablesResponse{}
+	return resp, s.call(ctx, http.MethodGet, "/tables"+schemaQuery(req.GetSchema()), nil, resp)
+}
+
+func (s *Service) DescribeSchema(ctx context.Context, req *pb.DescribeSchemaRequest) (*pb.DescribeSchemaResponse, error) {
+	resp := &pb.DescribeSchemaResponse{}
+	return resp, s.call(ctx, http.MethodGet, "/schema"+schemaQuery(req.GetSchema()), nil, resp)
+}
+
+// RunQuery streams the NDJSON answer of POST /run-query as messages
+func (s *Service) RunQuery(req *pb.RunQueryRequest, stream grpc.ServerStreamingServer[pb.RunQueryResponse]) error {
+	r, err := newRequest(stream.Context(), http.MethodPost, "/run-query", map[string]string{"sql": req.GetSql()})
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Accept", "application/x-ndjson")
+	w := newRowWriter(stream)
+	s.api.ServeHTTP(w, r)
+	return w.finish()
+}
+
+// schemaQuery is the query string selecting schema, empty for the default
+func schemaQuery(schema string) string {
+	if schema == "" {
+		return ""
+	}
+	return "?" + url.Values{"schema": {schema}}.Encode()
+}
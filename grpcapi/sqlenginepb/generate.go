@@ -0,0 +1,5 @@
+// Package sqlenginepb holds the messages and service stubs generated from
+// sqlengine.proto
+package sqlenginepb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative sqlengine.proto
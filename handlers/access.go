@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"sql-engine/rbac"
+
+	sqlparser "github.com/blastrain/vitess-sqlparser/sqlparser"
+	"github.com/gin-gonic/gin"
+)
+
+// UsePolicy sets the policy resolving the access of saved query owners,
+// under which their scheduled tests, pipelines and webhook runs execute
+func (h *Handler) UsePolicy(p *rbac.Policy) {
+	h.policy = p
+}
+
+// asOwner attaches the access of q's owner to ctx, for runs of q that no
+// caller makes
+func (h *Handler) asOwner(ctx context.Context, q SavedQuery) (context.Context, error) {
+	if h.policy == nil {
+		return ctx, nil
+	}
+	access, err := h.policy.AccessOf(ctx, q.Owner)
+	if err != nil {
+		return nil, err
+	}
+	return rbac.WithAccess(ctx, access), nil
+}
+
+// authorizeStatement rejects statements reading tables or columns outside
+// the caller's grants
+func authorizeStatement(access *rbac.Access, stmt sqlparser.Statement) error {
+	if access == nil {
+		return nil
+	}
+	denied := func(format string, args ...interface{}) error {
+		return &QueryError{Status: http.StatusForbidden, Message: "Access denied: " + fmt.Sprintf(format, args...)}
+	}
+
+	refs := analyzeStatement(stmt)
+	for _, t := range refs.Tables {
+		if !access.CanTable(t.Schema, t.Name) {
+			return denied("table %s is not granted", t.Name)
+		}
+	}
+
+	for _, star := range refs.Stars {
+		tables := refs.Tables
+		if star.Name != "" {
+			tables = []tableRef{star}
+		}
+		for _, t := range tables {
+			if access.RestrictsColumns(t.Schema, t.Name) {
+				return denied("SELECT * is not allowed on %s, list the granted columns instead", t.Name)
+			}
+		}
+	}
+
+	for _, col := range refs.Columns {
+		if col.Table != "" {
+			if !access.CanColumn(col.Schema, col.Table, col.Name) {
+				return denied("column %s.%s is not granted", col.Table, col.Name)
+			}
+			continue
+		}
+		// Unresolved columns could come from any restricted table
+		for _, t := range refs.Tables {
+			if access.RestrictsColumns(t.Schema, t.Name) && !access.CanColumn(t.Schema, t.Name, col.Name) {
+				return denied("column %s must be qualified with its table", col.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// grantSchema maps the dialect's default schema to "", which grants and
+// unqualified query references treat as the default
+func (h *Handler) grantSchema(schema string) string {
+	if h.isDefaultSchema(schema) {
+		return ""
+	}
+	return schema
+}
+
+// requireTable writes a 403 response if the caller may not read table
+func (h *Handler) requireTable(c *gin.Context, schema, table string) bool {
+	if !rbac.FromContext(c.Request.Context()).CanTable(h.grantSchema(schema), table) {
+		body := gin.H{"error": "Access denied: table " + table + " is not granted"}
+		if h.cfg.RBAC.AccessRequests.Enabled {
+			body["hint"] = "Ask for access with POST /access-requests"
+		}
+		c.JSON(http.StatusForbidden, body)
+		return false
+	}
+	return true
+}
+
+func filterTables(access *rbac.Access, schema string, tables []TableInfo) []TableInfo {
+	if access == nil {
+		return tables
+	}
+	var out []TableInfo
+	for _, t := range tables {
+		if access.CanTable(schema, t.Name) {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+func filterColumns(access *rbac.Access, schema, table string, columns []ColumnInfo) []ColumnInfo {
+	if access == nil {
+		return columns
+	}
+	var out []ColumnInfo
+	for _, col := range columns {
+		if access.CanColumn(schema, table, col.Name) {
+			out = append(out, col)
+		}
+	}
+	return out
+}
+
+// filterPrimaryKeys drops the primary key columns the caller can't read
+func filterPrimaryKeys(access *rbac.Access, schema, table string, keys []string) []string {
+	if access == nil {
+		return keys
+	}
+	var out []string
+	for _, col := range keys {
+		if access.CanColumn(schema, table, col) {
+			out = append(out, col)
+		}
+	}
+	return out
+}
+
+// filterConstraints drops the constraints over a column the caller can't
+// read, as their definitions tell about its values
+func filterConstraints(access *rbac.Access, schema, table string, constraints []ConstraintInfo) []ConstraintInfo {
+	if access == nil {
+		return constraints
+	}
+	var out []ConstraintInfo
+	for _, con := range constraints {
+		if !slices.ContainsFunc(con.Columns, func(col string) bool { return !access.CanColumn(schema, table, col) }) {
+			out = append(out, con)
+		}
+	}
+	return out
+}
+
+// filterIndexes drops the indexes over a column the caller can't read.
+// Expression keys count as unreadable once the caller's columns of the
+// table are restricted.
+func filterIndexes(access *rbac.Access, schema, table string, indexes []IndexInfo) []IndexInfo {
+	if access == nil {
+		return indexes
+	}
+	var out []IndexInfo
+	for _, idx := range indexes {
+		if !slices.ContainsFunc(idx.Columns, func(col string) bool { return !access.CanColumn(schema, table, col) }) {
+			out = append(out, idx)
+		}
+	}
+	return out
+}
+
+func (h *Handler) filterForeignKeys(access *rbac.Access, schema, table string, fks []ForeignKeyInfo) []ForeignKeyInfo {
+	if access == nil {
+		return fks
+	}
+	var out []ForeignKeyInfo
+	for _, fk := range fks {
+		if access.CanColumn(h.grantSchema(schema), table, fk.Column) && access.CanTable(h.grantSchema(fk.ForeignSchema), fk.ForeignTable) {
+			out = append(out, fk)
+		}
+	}
+	return out
+}
+
+// filterSchema trims a full schema to what the caller may see
+func (h *Handler) filterSchema(access *rbac.Access, schema []TableSchema) []TableSchema {
+	if access == nil {
+		return schema
+	}
+	var out []TableSchema
+	for _, t := range schema {
+		grant := h.grantSchema(t.Schema)
+		if !access.CanTable(grant, t.Name) {
+			continue
+		}
+		t.Columns = filterColumns(access, grant, t.Name, t.Columns)
+		t.PrimaryKeys = filterPrimaryKeys(access, grant, t.Name, t.PrimaryKeys)
+		t.ForeignKeys = h.filterForeignKeys(access, t.Schema, t.Name, t.ForeignKeys)
+		t.Constraints = filterConstraints(access, grant, t.Name, t.Constraints)
+		t.FullText = filterFullText(access, grant, t.Name, t.FullText)
+		out = append(out, t)
+	}
+	return out
+}
@@ -0,0 +1,527 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"sql-engine/alert"
+	"sql-engine/auth"
+	"sql-engine/config"
+	"sql-engine/rbac"
+	"sql-engine/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	accessRequestPrefix = "access-request/"
+	// accessGrantPrefix keys the approved grants of each principal
+	accessGrantPrefix = "access-grant/"
+)
+
+// AccessRequest asks admins for access to a table, or to every table of a
+// schema. History records who did what to it, for auditing.
+type AccessRequest struct {
+	ID        string `json:"id"`
+	Principal string `json:"principal"`
+	// Table is schema.table, or schema.* for the whole schema
+	Table   string   `json:"table"`
+	Columns []string `json:"columns,omitempty"`
+	Write   bool     `json:"write,omitempty"`
+	Reason  string   `json:"reason"`
+	// Duration is how long access is asked for, e.g. 72h
+	Duration string `json:"duration,omitempty"`
+	// Status is pending, approved, denied, withdrawn, revoked or expired
+	Status      string               `json:"status"`
+	RequestedAt time.Time            `json:"requested_at"`
+	ExpiresAt   *time.Time           `json:"expires_at,omitempty"`
+	History     []AccessRequestEvent `json:"history"`
+}
+
+type AccessRequestEvent struct {
+	At     time.Time `json:"at"`
+	By     string    `json:"by"`
+	Action string    `json:"action"`
+	Note   string    `json:"note,omitempty"`
+}
+
+// TemporaryGrant is a grant made by approving an access request
+type TemporaryGrant struct {
+	RequestID string    `json:"request_id"`
+	Table     string    `json:"table"`
+	Columns   []string  `json:"columns,omitempty"`
+	Write     bool      `json:"write,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type temporaryGrants struct {
+	Principal string           `json:"principal"`
+	Grants    []TemporaryGrant `json:"grants"`
+}
+
+type AccessRequestBody struct {
+	Table    string   `json:"table"`
+	Columns  []string `json:"columns"`
+	Write    bool     `json:"write"`
+	Reason   string   `json:"reason"`
+	Duration string   `json:"duration"`
+}
+
+// AccessReviewBody is the decision of an admin. Approvals last until
+// ExpiresAt, for Duration, or else for the duration requested.
+type AccessReviewBody struct {
+	ExpiresAt *time.Time `json:"expires_at"`
+	Duration  string     `json:"duration"`
+	Note      string     `json:"note"`
+}
+
+// GrantsOf implements rbac.GrantSource with the approved access requests
+// of a principal that haven't expired
+func (h *Handler) GrantsOf(principal string) ([]config.GrantConfig, error) {
+	var tg temporaryGrants
+	if err := h.meta.Get(accessGrantPrefix+principal, &tg); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	now := time.Now()
+	var grants []config.GrantConfig
+	for _, g := range tg.Grants {
+		if now.Before(g.ExpiresAt) {
+			grants = append(grants, config.GrantConfig{Table: g.Table, Columns: g.Columns, Write: g.Write})
+		}
+	}
+	return grants, nil
+}
+
+// StartAccessExpiry has the leader mark approved requests whose access ran
+// out as expired, every ten minutes. Grants stop applying at their expiry
+// regardless.
+func (h *Handler) StartAccessExpiry() {
+	if !h.cfg.RBAC.AccessRequests.Enabled {
+		return
+	}
+	h.sched.Every("access-expiry", 10*time.Minute, func(ctx context.Context) {
+		h.expireAccess(time.Now())
+	})
+}
+
+func (h *Handler) expireAccess(now time.Time) {
+	keys, err := h.meta.List(accessRequestPrefix)
+	if err != nil {
+		slog.Error("Failed to list access requests", "error", err)
+		return
+	}
+	h.accessMu.Lock()
+	defer h.accessMu.Unlock()
+	for _, key := range keys {
+		var r AccessRequest
+		if err := h.meta.Get(key, &r); err != nil || r.Status != "approved" || now.Before(*r.ExpiresAt) {
+			continue
+		}
+		if err := h.removeGrant(r); err != nil {
+			slog.Error("Failed to remove the grant of an access request", "access_request", r.ID, "error", err)
+			continue
+		}
+		r.Status = "expired"
+		r.History = append(r.History, AccessRequestEvent{At: now, Action: "expired"})
+		if err := h.meta.Put(key, r); err != nil {
+			slog.Error("Failed to expire access request", "access_request", r.ID, "error", err)
+		}
+	}
+}
+
+// accessRequestsEnabled writes a 404 response unless access requests are
+// enabled
+func (h *Handler) accessRequestsEnabled(c *gin.Context) bool {
+	if !h.cfg.RBAC.AccessRequests.Enabled {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Access requests are not enabled"})
+		return false
+	}
+	return true
+}
+
+// parseAccessDuration parses a duration and checks it is within
+// rbac.access_requests.max_duration
+func (h *Handler) parseAccessDuration(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, errors.New("duration must be a positive duration such as 72h")
+	}
+	if limit := h.cfg.RBAC.AccessRequests.MaxDuration; d > limit {
+		return 0, errors.New("duration exceeds the maximum of " + limit.String())
+	}
+	return d, nil
+}
+
+// RequestAccess asks admins for access to a table (schema.table, or a bare
+// name in the default schema) or to a whole schema (schema.*)
+func (h *Handler) RequestAccess(c *gin.Context) {
+	if !h.accessRequestsEnabled(c) {
+		return
+	}
+	principal := auth.Principal(c)
+	if principal == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Access requests need an authenticated caller"})
+		return
+	}
+	var req AccessRequestBody
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		return
+	}
+	if strings.TrimSpace(req.Reason) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "reason is required"})
+		return
+	}
+	if req.Duration != "" {
+		if _, err := h.parseAccessDuration(req.Duration); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if req.Write && !h.cfg.Writes.Enabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Writes are disabled"})
+		return
+	}
+	schema, table, ok := strings.Cut(req.Table, ".")
+	if !ok {
+		schema, table = h.dialect.DefaultSchema(), req.Table
+	}
+	if !identPattern.MatchString(schema) || (table != "*" && !identPattern.MatchString(table)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "table must be schema.table, schema.* or a table name"})
+		return
+	}
+	if table == "*" && len(req.Columns) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "columns can only be requested for a table"})
+		return
+	}
+	if matchesAny(h.cfg.Query.Denylist.Tables, table) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Denied: table " + table + " is not allowed"})
+		return
+	}
+
+	if table == "*" {
+		schemas, err := h.dialect.ListSchemas(h.db)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if !slices.Contains(schemas, schema) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Schema " + schema + " not found"})
+			return
+		}
+	} else {
+		columns, err := h.dialect.ListColumns(h.db, schema, table)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if len(columns) == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Table not found"})
+			return
+		}
+		for _, col := range req.Columns {
+			if !slices.ContainsFunc(columns, func(ci ColumnInfo) bool { return ci.Name == col }) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Column " + col + " not found"})
+				return
+			}
+		}
+		if h.hasAccess(rbac.FromContext(c.Request.Context()), schema, table, req.Columns, req.Write) {
+			c.JSON(http.StatusConflict, gin.H{"error": "You already have this access"})
+			return
+		}
+	}
+
+	r := AccessRequest{
+		ID:          newID(),
+		Principal:   principal,
+		Table:       schema + "." + table,
+		Columns:     req.Columns,
+		Write:       req.Write,
+		Reason:      req.Reason,
+		Duration:    req.Duration,
+		Status:      "pending",
+		RequestedAt: time.Now(),
+	}
+	r.History = []AccessRequestEvent{{At: r.RequestedAt, By: principal, Action: "requested", Note: req.Reason}}
+	pending, err := h.accessRequests(func(o AccessRequest) bool {
+		return o.Principal == principal && o.Status == "pending" && o.Table == r.Table
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(pending) > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "A request for " + r.Table + " is already pending", "request": pending[0]})
+		return
+	}
+	if err := h.meta.Put(accessRequestPrefix+r.ID, r); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	h.alerts.Send(c.Request.Context(), alert.Alert{
+		Source:  "access",
+		Subject: r.Table,
+		Status:  "requested",
+		Message: principal + " requests access: " + r.Reason,
+		Details: r,
+	})
+	c.JSON(http.StatusCreated, r)
+}
+
+// hasAccess reports whether access already covers what is requested of a
+// table
+func (h *Handler) hasAccess(access *rbac.Access, schema, table string, columns []string, write bool) bool {
+	gs := h.grantSchema(schema)
+	if write {
+		if len(columns) == 0 {
+			return access.CanWrite(gs, table, "")
+		}
+		return !slices.ContainsFunc(columns, func(col string) bool { return !access.CanWrite(gs, table, col) })
+	}
+	if len(columns) == 0 {
+		return access.CanTable(gs, table) && !access.RestrictsColumns(gs, table)
+	}
+	return !slices.ContainsFunc(columns, func(col string) bool { return !access.CanColumn(gs, table, col) })
+}
+
+// accessRequests lists the access requests matching keep, newest first
+func (h *Handler) accessRequests(keep func(AccessRequest) bool) ([]AccessRequest, error) {
+	keys, err := h.meta.List(accessRequestPrefix)
+	if err != nil {
+		return nil, err
+	}
+	requests := []AccessRequest{}
+	for _, key := range keys {
+		var r AccessRequest
+		if err := h.meta.Get(key, &r); err != nil {
+			return nil, err
+		}
+		if keep(r) {
+			requests = append(requests, r)
+		}
+	}
+	slices.SortFunc(requests, func(a, b AccessRequest) int { return b.RequestedAt.Compare(a.RequestedAt) })
+	return requests, nil
+}
+
+// GetAccessRequests lists the caller's access requests, or for admins
+// everyone's, optionally only those with ?status or from ?principal
+func (h *Handler) GetAccessRequests(c *gin.Context) {
+	if !h.accessRequestsEnabled(c) {
+		return
+	}
+	principal := c.Query("principal")
+	if !rbac.FromContext(c.Request.Context()).IsAdmin() {
+		principal = auth.Principal(c)
+	}
+	status := c.Query("status")
+	requests, err := h.accessRequests(func(r AccessRequest) bool {
+		return (principal == "" || r.Principal == principal) && (status == "" || r.Status == status)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"requests": requests})
+}
+
+// accessRequest loads an access request the caller may see, writing an
+// error response if there is none
+func (h *Handler) accessRequest(c *gin.Context) (*AccessRequest, bool) {
+	var r AccessRequest
+	if err := h.meta.Get(accessRequestPrefix+c.Param("id"), &r); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Access request not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return nil, false
+	}
+	if r.Principal != auth.Principal(c) && !rbac.FromContext(c.Request.Context()).IsAdmin() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Access request not found"})
+		return nil, false
+	}
+	return &r, true
+}
+
+func (h *Handler) GetAccessRequest(c *gin.Context) {
+	if !h.accessRequestsEnabled(c) {
+		return
+	}
+	if r, ok := h.accessRequest(c); ok {
+		c.JSON(http.StatusOK, r)
+	}
+}
+
+// WithdrawAccessRequest lets the requester withdraw a pending request
+func (h *Handler) WithdrawAccessRequest(c *gin.Context) {
+	if !h.accessRequestsEnabled(c) {
+		return
+	}
+	h.accessMu.Lock()
+	defer h.accessMu.Unlock()
+	r, ok := h.accessRequest(c)
+	if !ok {
+		return
+	}
+	if r.Principal != auth.Principal(c) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the requester can withdraw a request"})
+		return
+	}
+	h.reviewAccess(c, r, "pending", "withdrawn", "")
+}
+
+// ApproveAccessRequest grants a pending request until an expiry. Admins
+// can't approve their own requests.
+func (h *Handler) ApproveAccessRequest(c *gin.Context) {
+	if !h.accessRequestsEnabled(c) {
+		return
+	}
+	var body AccessReviewBody
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		return
+	}
+	h.accessMu.Lock()
+	defer h.accessMu.Unlock()
+	r, ok := h.accessRequest(c)
+	if !ok {
+		return
+	}
+	if r.Principal == auth.Principal(c) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Requests can't be approved by their requester"})
+		return
+	}
+
+	now := time.Now()
+	duration := body.Duration
+	if duration == "" {
+		duration = r.Duration
+	}
+	var expires time.Time
+	switch {
+	case body.ExpiresAt != nil:
+		expires = *body.ExpiresAt
+		if !expires.After(now) || expires.Sub(now) > h.cfg.RBAC.AccessRequests.MaxDuration {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at must be in the future and within " + h.cfg.RBAC.AccessRequests.MaxDuration.String()})
+			return
+		}
+	case duration != "":
+		d, err := h.parseAccessDuration(duration)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		expires = now.Add(d)
+	default:
+		expires = now.Add(h.cfg.RBAC.AccessRequests.DefaultDuration)
+	}
+	if r.Status != "pending" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Request is " + r.Status, "request": r})
+		return
+	}
+
+	schema, table, _ := strings.Cut(r.Table, ".")
+	if gs := h.grantSchema(schema); gs != "" {
+		schema = gs
+	} else {
+		schema = rbac.DefaultSchema
+	}
+	grant := TemporaryGrant{RequestID: r.ID, Table: schema + "." + table, Columns: r.Columns, Write: r.Write, ExpiresAt: expires}
+	var tg temporaryGrants
+	if err := h.meta.Get(accessGrantPrefix+r.Principal, &tg); err != nil && !errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	tg.Principal = r.Principal
+	tg.Grants = slices.DeleteFunc(tg.Grants, func(g TemporaryGrant) bool { return !now.Before(g.ExpiresAt) })
+	tg.Grants = append(tg.Grants, grant)
+	if err := h.meta.Put(accessGrantPrefix+r.Principal, tg); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	r.ExpiresAt = &expires
+	h.reviewAccess(c, r, "pending", "approved", body.Note)
+}
+
+// DenyAccessRequest refuses a pending request
+func (h *Handler) DenyAccessRequest(c *gin.Context) {
+	h.decideAccess(c, "pending", "denied")
+}
+
+// RevokeAccessRequest ends the access granted by an approved request
+// before it expires
+func (h *Handler) RevokeAccessRequest(c *gin.Context) {
+	h.decideAccess(c, "approved", "revoked")
+}
+
+// decideAccess moves a request from one status to another with the note
+// of the admin deciding
+func (h *Handler) decideAccess(c *gin.Context, from, to string) {
+	if !h.accessRequestsEnabled(c) {
+		return
+	}
+	var body AccessReviewBody
+	if c.Request.ContentLength != 0 {
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+			return
+		}
+	}
+	h.accessMu.Lock()
+	defer h.accessMu.Unlock()
+	r, ok := h.accessRequest(c)
+	if !ok {
+		return
+	}
+	if r.Status == from && to == "revoked" {
+		if err := h.removeGrant(*r); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	h.reviewAccess(c, r, from, to, body.Note)
+}
+
+// reviewAccess records a change of status, answering 409 unless the
+// request had status from
+func (h *Handler) reviewAccess(c *gin.Context, r *AccessRequest, from, to, note string) {
+	if r.Status != from {
+		c.JSON(http.StatusConflict, gin.H{"error": "Request is " + r.Status, "request": r})
+		return
+	}
+	r.Status = to
+	r.History = append(r.History, AccessRequestEvent{At: time.Now(), By: auth.Principal(c), Action: to, Note: note})
+	if err := h.meta.Put(accessRequestPrefix+r.ID, r); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if to != "withdrawn" {
+		slog.InfoContext(c.Request.Context(), "Access request "+to, "access_request", r.ID, "requester", r.Principal, "table", r.Table, "by", auth.Principal(c))
+	}
+	c.JSON(http.StatusOK, r)
+}
+
+// removeGrant removes the grant made by approving a request
+func (h *Handler) removeGrant(r AccessRequest) error {
+	var tg temporaryGrants
+	if err := h.meta.Get(accessGrantPrefix+r.Principal, &tg); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return nil
+		}
+		return err
+	}
+	tg.Grants = slices.DeleteFunc(tg.Grants, func(g TemporaryGrant) bool { return g.RequestID == r.ID })
+	if len(tg.Grants) == 0 {
+		return h.meta.Delete(accessGrantPrefix + r.Principal)
+	}
+	return h.meta.Put(accessGrantPrefix+r.Principal, tg)
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PoolStats mirrors sql.DBStats with JSON-friendly field names
+type PoolStats struct {
+	MaxOpenConnections int    `json:"max_open_connections"`
+	OpenConnections    int    `json:"open_connections"`
+	InUse              int    `json:"in_use"`
+	Idle               int    `json:"idle"`
+	WaitCount          int64  `json:"wait_count"`
+	WaitDuration       string `json:"wait_duration"`
+	MaxIdleClosed      int64  `json:"max_idle_closed"`
+	MaxIdleTimeClosed  int64  `json:"max_idle_time_closed"`
+	MaxLifetimeClosed  int64  `json:"max_lifetime_closed"`
+	MinWarmConnections int    `json:"min_warm_connections"`
+	// LowPriority holds the stats of the low-priority pool, if configured
+	LowPriority *PoolStats `json:"low_priority,omitempty"`
+	// Replicas holds the stats of the read replicas' pools
+	Replicas []PoolStats `json:"replicas,omitempty"`
+}
+
+func (h *Handler) GetPoolStats(c *gin.Context) {
+	stats := poolStats(h.db)
+	stats.MinWarmConnections = h.cfg.Database.MinWarmConns
+	if h.lowDB != nil {
+		low := poolStats(h.lowDB)
+		stats.LowPriority = &low
+	}
+	for _, r := range h.replicas.replicas {
+		stats.Replicas = append(stats.Replicas, poolStats(r.db))
+	}
+	c.JSON(http.StatusOK, stats)
+}
+
+func poolStats(db *sql.DB) PoolStats {
+	s := db.Stats()
+	return PoolStats{
+		MaxOpenConnections: s.MaxOpenConnections,
+		OpenConnections:    s.OpenConnections,
+		InUse:              s.InUse,
+		Idle:               s.Idle,
+		WaitCount:          s.WaitCount,
+		WaitDuration:       s.WaitDuration.String(),
+		MaxIdleClosed:      s.MaxIdleClosed,
+		MaxIdleTimeClosed:  s.MaxIdleTimeClosed,
+		MaxLifetimeClosed:  s.MaxLifetimeClosed,
+	}
+}
+
+// StartPoolWarmer opens database.min_warm_conns connections right away and
+// reopens them well before conn_max_idle_time would close them. It runs on
+// every replica, unlike scheduled jobs.
+func (h *Handler) StartPoolWarmer() {
+	n := h.cfg.Database.MinWarmConns
+	if n == 0 {
+		return
+	}
+	interval := time.Minute
+	if idle := h.cfg.Database.ConnMaxIdleTime; idle > 0 && idle/2 < interval {
+		interval = idle / 2
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if err := h.warmPool(n); err != nil {
+				slog.Error("Warming database connections failed", "error", err)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// warmPool checks out n connections at once and pings them, so that at
+// least n are open and their idle timers restart. Returned to the pool, they
+// stay open as long as max_idle_conns allows.
+func (h *Handler) warmPool(n int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	conns := make([]*sql.Conn, 0, n)
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+	for range n {
+		conn, err := h.db.Conn(ctx)
+		if err != nil {
+			return err
+		}
+		conns = append(conns, conn)
+		if err := conn.PingContext(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,288 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// aggregateGranularities are the units the time dimension of /aggregate
+// can be truncated to
+var aggregateGranularities = []string{"hour", "day", "week", "month", "year"}
+
+// AggregateRequest groups the rows of a table by dimensions and computes
+// metrics per group. With TimeColumn and Granularity, the start of each
+// row's hour, day, week, month or year is the first dimension.
+type AggregateRequest struct {
+	Schema      string                `json:"schema"`
+	Table       string                `json:"table"`
+	Dimensions  []string              `json:"dimensions"`
+	Metrics     []TimeseriesAggregate `json:"metrics"`
+	Filters     []ColumnFilter        `json:"filters"`
+	TimeColumn  string                `json:"time_column"`
+	Granularity string                `json:"granularity"`
+}
+
+// AggregateSeries is the values of one metric for one combination of the
+// dimensions after the first, aligned with the labels
+type AggregateSeries struct {
+	Name   string                 `json:"name"`
+	Metric string                 `json:"metric"`
+	Group  map[string]interface{} `json:"group,omitempty"`
+	Data   []interface{}          `json:"data"`
+}
+
+// Aggregate runs a grouped aggregate query built from the request and
+// returns its rows along with a chart-ready shape: labels are the values of
+// the first dimension (the time buckets, if any), and each series holds a
+// metric for one combination of the other dimensions, null where a group
+// has no rows. Groups are capped at max_rows.
+func (h *Handler) Aggregate(c *gin.Context) {
+	var req AggregateRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		return
+	}
+	if (req.TimeColumn == "") != (req.Granularity == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "time_column and granularity must be set together"})
+		return
+	}
+	if req.Granularity != "" && !slices.Contains(aggregateGranularities, req.Granularity) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "granularity must be one of " + strings.Join(aggregateGranularities, ", ")})
+		return
+	}
+	if len(req.Dimensions) == 0 && req.TimeColumn == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Set dimensions or time_column"})
+		return
+	}
+	if len(req.Metrics) == 0 {
+		req.Metrics = []TimeseriesAggregate{{Function: "count"}}
+	}
+
+	schema := orDefault(h.dialect, req.Schema)
+	if matchesAny(h.cfg.Query.Denylist.Tables, req.Table) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Denied: table " + req.Table + " is not allowed"})
+		return
+	}
+	if !h.requireTable(c, schema, req.Table) || !h.requireRowLevel(c, schema, req.Table) {
+		return
+	}
+	columns, err := h.dialect.ListColumns(h.db, schema, req.Table)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(columns) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Table not found"})
+		return
+	}
+	checkColumn := func(field, col string) bool {
+		return h.analysisColumn(c, schema, req.Table, columns, field, col)
+	}
+
+	q := h.dialect.Quote
+	var selects, groups, dims []string
+	var conds []string
+	if req.TimeColumn != "" {
+		if !checkColumn("time_column", req.TimeColumn) {
+			return
+		}
+		bucket := h.dialect.TruncateTime(q(req.TimeColumn), req.Granularity)
+		selects = append(selects, bucket+" AS "+q(req.TimeColumn))
+		groups = append(groups, bucket)
+		dims = append(dims, req.TimeColumn)
+		conds = append(conds, q(req.TimeColumn)+" IS NOT NULL")
+	}
+	for i, dim := range req.Dimensions {
+		if !checkColumn(fmt.Sprintf("dimensions[%d]", i), dim) {
+			return
+		}
+		if slices.Contains(dims, dim) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Duplicate dimension " + dim})
+			return
+		}
+		selects = append(selects, q(dim))
+		groups = append(groups, q(dim))
+		dims = append(dims, dim)
+	}
+	var metrics []string
+	for i, m := range req.Metrics {
+		m.Function = strings.ToLower(m.Function)
+		if !slices.Contains(timeseriesFunctions, m.Function) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown aggregate function " + m.Function})
+			return
+		}
+		arg := "*"
+		if m.Column != "" {
+			if !checkColumn(fmt.Sprintf("metrics[%d]", i), m.Column) {
+				return
+			}
+			arg = q(m.Column)
+		} else if m.Function != "count" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": m.Function + " needs a column"})
+			return
+		}
+		if m.Alias == "" {
+			m.Alias = m.Function
+			if m.Column != "" {
+				m.Alias += "_" + m.Column
+			}
+		}
+		if slices.Contains(dims, m.Alias) || slices.Contains(metrics, m.Alias) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Duplicate alias " + m.Alias})
+			return
+		}
+		metrics = append(metrics, m.Alias)
+		selects = append(selects, fmt.Sprintf("%s(%s) AS %s", strings.ToUpper(m.Function), arg, q(m.Alias)))
+	}
+
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return h.dialect.Placeholder(len(args))
+	}
+	for i, f := range req.Filters {
+		if !checkColumn(fmt.Sprintf("filters[%d]", i), f.Column) {
+			return
+		}
+		cond, err := h.filterCondition(f, columns, arg)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("filters[%d]: %v", i, err)})
+			return
+		}
+		conds = append(conds, cond)
+	}
+	where := ""
+	if len(conds) > 0 {
+		where = " WHERE " + strings.Join(conds, " AND ")
+	}
+	order := make([]string, len(groups))
+	for i := range groups {
+		order[i] = fmt.Sprint(i + 1)
+	}
+	ctx := c.Request.Context()
+	limit := h.maxRows(ctx)
+	sqlText := fmt.Sprintf("SELECT %s FROM %s.%s%s GROUP BY %s ORDER BY %s %s",
+		strings.Join(selects, ", "), q(schema), q(req.Table), where,
+		strings.Join(groups, ", "), strings.Join(order, ", "), h.dialect.LimitClause(limit))
+
+	rows, err := h.aggregateRows(ctx, sqlText, args)
+	if err != nil {
+		respondQueryError(c, err)
+		return
+	}
+	warnings := []string{}
+	if len(rows) >= limit {
+		warnings = append(warnings, fmt.Sprintf("Only the first %d groups are returned", limit))
+	}
+	labels, series := chartSeries(rows, dims, metrics)
+	c.JSON(http.StatusOK, gin.H{
+		"sql":        sqlText,
+		"dimensions": dims,
+		"metrics":    metrics,
+		"rows":       rows,
+		"labels":     labels,
+		"series":     series,
+		"warnings":   warnings,
+	})
+}
+
+// aggregateRows runs a generated aggregate query read-only within the query
+// timeout
+func (h *Handler) aggregateRows(ctx context.Context, sqlText string, args []interface{}) ([]map[string]interface{}, error) {
+	if timeout := h.queryTimeout(ctx); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	release, err := h.acquireStatement(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	start := time.Now()
+	tx, end, err := h.dialect.BeginReadOnly(ctx, h.queryDB(ctx))
+	if err != nil {
+		return nil, &QueryError{Status: http.StatusInternalServerError, Message: "Failed to start read-only transaction: " + err.Error()}
+	}
+	defer end()
+	rows, err := tx.QueryContext(ctx, sqlText, args...)
+	if err != nil {
+		return nil, &QueryError{Status: http.StatusInternalServerError, Message: "Execution failed: " + err.Error()}
+	}
+	defer rows.Close()
+	_, result, err := scanRowMaps(rows)
+	meterStatement(ctx, time.Since(start), len(result))
+	if err != nil {
+		return nil, &QueryError{Status: http.StatusInternalServerError, Message: err.Error()}
+	}
+	return result, nil
+}
+
+// chartSeries pivots grouped rows: the distinct values of the first
+// dimension become the labels, in row order, and each metric gets a series
+// per combination of the remaining dimensions
+func chartSeries(rows []map[string]interface{}, dims, metrics []string) ([]interface{}, []AggregateSeries) {
+	labels := []interface{}{}
+	labelIndex := map[string]int{}
+	type group struct {
+		values map[string]interface{}
+		data   map[int]map[string]interface{}
+	}
+	var groupOrder []string
+	groups := map[string]*group{}
+	for _, row := range rows {
+		label := jsonValue(row[dims[0]])
+		i, ok := labelIndex[label]
+		if !ok {
+			i = len(labels)
+			labelIndex[label] = i
+			labels = append(labels, row[dims[0]])
+		}
+		values := map[string]interface{}{}
+		var key []any
+		for _, dim := range dims[1:] {
+			values[dim] = row[dim]
+			key = append(key, row[dim])
+		}
+		k := jsonValue(key)
+		g, ok := groups[k]
+		if !ok {
+			g = &group{values: values, data: map[int]map[string]interface{}{}}
+			groups[k] = g
+			groupOrder = append(groupOrder, k)
+		}
+		g.data[i] = row
+	}
+
+	series := []AggregateSeries{}
+	for _, metric := range metrics {
+		for _, k := range groupOrder {
+			g := groups[k]
+			s := AggregateSeries{Name: metric, Metric: metric, Data: make([]interface{}, len(labels))}
+			if len(dims) > 1 {
+				s.Group = g.values
+				var parts []string
+				for _, dim := range dims[1:] {
+					parts = append(parts, fmt.Sprint(g.values[dim]))
+				}
+				s.Name = strings.Join(parts, " / ")
+				if len(metrics) > 1 {
+					s.Name = metric + ": " + s.Name
+				}
+			}
+			for i := range labels {
+				if row, ok := g.data[i]; ok {
+					s.Data[i] = row[metric]
+				}
+			}
+			series = append(series, s)
+		}
+	}
+	return labels, series
+}
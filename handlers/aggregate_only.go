@@ -0,0 +1,237 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"sql-engine/rbac"
+
+	sqlparser "github.com/blastrain/vitess-sqlparser/sqlparser"
+	"github.com/gin-gonic/gin"
+)
+
+// groupSizeColumn is added to aggregate-only queries to count the rows of
+// each group, and removed from their results
+const groupSizeColumn = "sqlengine_group_size"
+
+// safeAggregates are the aggregates that don't return a value of a single
+// row. MIN, MAX and the string and array aggregates do.
+var safeAggregates = []string{
+	"count", "sum", "avg",
+	"stddev", "stddev_pop", "stddev_samp",
+	"variance", "var_pop", "var_samp",
+}
+
+var selectKeyword = regexp.MustCompile(`(?i)^select\s`)
+
+// aggregatePlan is how an aggregate-only query's result is checked: groups
+// of fewer than MinGroupSize rows are left out
+type aggregatePlan struct {
+	Table        string
+	MinGroupSize int
+}
+
+// aggregateOnly reports whether the caller may read a table only through
+// aggregate queries. schema is "" for the default schema.
+func (h *Handler) aggregateOnly(ctx context.Context, schema, table string) bool {
+	cfg := h.cfg.AggregateOnly
+	name := table
+	if schema != "" {
+		name = schema + "." + table
+	}
+	if !matchesAny(cfg.Tables, name) {
+		return false
+	}
+	access := rbac.FromContext(ctx)
+	if access == nil {
+		return true
+	}
+	return !access.IsAdmin() && !slices.ContainsFunc(cfg.ExemptRoles, func(role string) bool { return slices.Contains(access.Roles, role) })
+}
+
+// requireRowLevel writes a 403 response if the caller may only read table
+// through aggregates
+func (h *Handler) requireRowLevel(c *gin.Context, schema, table string) bool {
+	if h.aggregateOnly(c.Request.Context(), h.grantSchema(schema), table) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Table " + table + " can only be queried through aggregates",
+			"hint":  "Use POST /run-query with COUNT, SUM or AVG and GROUP BY",
+		})
+		return false
+	}
+	return true
+}
+
+// planAggregateOnly checks a statement reading an aggregate-only table: the
+// table is read alone, without subqueries, every result column is an
+// aggregate or a grouped expression and aggregates take a bare column or *. It returns nil for statements reading
+// no such table.
+func (h *Handler) planAggregateOnly(ctx context.Context, sqlText string, stmt sqlparser.Statement) (*aggregatePlan, error) {
+	var table string
+	for _, t := range analyzeStatement(stmt).Tables {
+		if h.aggregateOnly(ctx, t.Schema, t.Name) {
+			table = t.Name
+			break
+		}
+	}
+	if table == "" {
+		return nil, nil
+	}
+	rejected := func(format string, args ...interface{}) error {
+		return &QueryError{
+			Status:  http.StatusForbidden,
+			Message: fmt.Sprintf("Table %s can only be queried through aggregates: ", table) + fmt.Sprintf(format, args...),
+			Details: gin.H{"aggregate_only": table},
+		}
+	}
+
+	sel := stmt.(*sqlparser.Select)
+	if len(sel.From) != 1 {
+		return nil, rejected("it cannot be joined")
+	}
+	if _, ok := sel.From[0].(*sqlparser.AliasedTableExpr); !ok {
+		return nil, rejected("it cannot be joined")
+	}
+	hasSubquery := false
+	sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		if _, ok := node.(*sqlparser.Subquery); ok {
+			hasSubquery = true
+		}
+		return !hasSubquery, nil
+	}, stmt)
+	if hasSubquery {
+		return nil, rejected("subqueries are not allowed")
+	}
+	if sel.Distinct != "" || !selectKeyword.MatchString(sqlText) {
+		return nil, rejected("the statement must start with SELECT, without DISTINCT")
+	}
+
+	// The column counting groups comes first, so positions would be off
+	positional := func(expr sqlparser.Expr) bool {
+		v, ok := expr.(*sqlparser.SQLVal)
+		return ok && v.Type == sqlparser.IntVal
+	}
+	grouped := map[string]bool{}
+	for _, expr := range sel.GroupBy {
+		if positional(expr) {
+			return nil, rejected("group by columns by name, not position")
+		}
+		grouped[strings.ToLower(sqlparser.String(expr))] = true
+	}
+	for _, order := range sel.OrderBy {
+		if positional(order.Expr) {
+			return nil, rejected("order by columns by name, not position")
+		}
+	}
+	// An expression inside an aggregate could single out rows, as in
+	// SUM(CASE WHEN id = 42 THEN salary END), so aggregates take a column
+	// or * alone, wherever they are
+	var badArg string
+	sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		if fn, ok := node.(*sqlparser.FuncExpr); ok && slices.Contains(safeAggregates, fn.Name.Lowered()) {
+			badArg = aggregateArgument(fn)
+		}
+		return badArg == "", nil
+	}, stmt)
+	if badArg != "" {
+		return nil, rejected("%s", badArg)
+	}
+
+	for _, expr := range sel.SelectExprs {
+		aliased, ok := expr.(*sqlparser.AliasedExpr)
+		if !ok {
+			return nil, rejected("SELECT * is not allowed")
+		}
+		if !aliased.As.IsEmpty() && grouped[aliased.As.Lowered()] {
+			continue
+		}
+		var bad string
+		sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+			if expr, ok := node.(sqlparser.Expr); ok && grouped[strings.ToLower(sqlparser.String(expr))] {
+				return false, nil
+			}
+			switch node := node.(type) {
+			case *sqlparser.FuncExpr:
+				if slices.Contains(safeAggregates, node.Name.Lowered()) {
+					return false, nil
+				}
+				if node.IsAggregate() {
+					bad = strings.ToUpper(node.Name.String()) + " returns values of single rows"
+				}
+			case *sqlparser.GroupConcatExpr:
+				bad = "GROUP_CONCAT returns values of single rows"
+			case *sqlparser.ColName:
+				bad = "column " + node.Name.String() + " is neither grouped nor aggregated"
+			}
+			return bad == "", nil
+		}, aliased.Expr)
+		if bad != "" {
+			return nil, rejected("%s", bad)
+		}
+	}
+	return &aggregatePlan{Table: table, MinGroupSize: h.cfg.AggregateOnly.MinGroupSize}, nil
+}
+
+// aggregateArgument returns why the arguments of an aggregate call are not
+// allowed, or "" if each is a bare column or *
+func aggregateArgument(fn *sqlparser.FuncExpr) string {
+	for _, arg := range fn.Exprs {
+		switch arg := arg.(type) {
+		case *sqlparser.StarExpr:
+			continue
+		case *sqlparser.AliasedExpr:
+			if _, ok := arg.Expr.(*sqlparser.ColName); ok {
+				continue
+			}
+		}
+		return fmt.Sprintf("%s takes a column or * only, not an expression such as CASE, IF, a comparison or arithmetic (got %s)",
+			strings.ToUpper(fn.Name.String()), sqlparser.String(arg))
+	}
+	return ""
+}
+
+// countGroups adds the column counting the rows of each group to an
+// aggregate-only query
+func (p *aggregatePlan) countGroups(sqlText string) string {
+	return sqlText[:len("select")] + " COUNT(*) AS " + groupSizeColumn + "," + sqlText[len("select"):]
+}
+
+// suppressSmallGroups removes the groups of fewer than MinGroupSize rows,
+// and the column counting them, from a result. It returns how many groups
+// were left out.
+func (p *aggregatePlan) suppressSmallGroups(cols []string, rows []map[string]interface{}) ([]string, []map[string]interface{}, int) {
+	cols = slices.DeleteFunc(cols, func(col string) bool { return col == groupSizeColumn })
+	kept := rows[:0]
+	for _, row := range rows {
+		size, ok := groupSize(row[groupSizeColumn])
+		delete(row, groupSizeColumn)
+		if ok && size >= int64(p.MinGroupSize) {
+			kept = append(kept, row)
+		}
+	}
+	return cols, kept, len(rows) - len(kept)
+}
+
+// groupSize reads a COUNT(*) value as returned by the driver
+func groupSize(v interface{}) (int64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return v, true
+	case int32:
+		return int64(v), true
+	case float64:
+		return int64(v), true
+	case []byte:
+		n, err := strconv.ParseInt(string(v), 10, 64)
+		return n, err == nil
+	case string:
+		n, err := strconv.ParseInt(v, 10, 64)
+		return n, err == nil
+	}
+	return 0, false
+}
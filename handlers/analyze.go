@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	sqlparser "github.com/blastrain/vitess-sqlparser/sqlparser"
+)
+
+// tableRef is a table read by a statement
+type tableRef struct {
+	Schema string // empty when unqualified
+	Name   string
+	Alias  string
+}
+
+// columnRef is a column read by a statement. Table is the resolved table
+// name, or empty when an unqualified column could belong to several tables.
+type columnRef struct {
+	Table  string
+	Schema string
+	Name   string
+}
+
+// queryRefs lists what a statement reads
+type queryRefs struct {
+	Tables  []tableRef
+	Columns []columnRef
+	// Stars holds the tables selected with *; a bare * is recorded with an
+	// empty Name
+	Stars []tableRef
+
+	// columnTables and starTables hold the table each column and qualified
+	// * of the statement resolved to
+	columnTables map[*sqlparser.ColName]tableRef
+	starTables   map[*sqlparser.StarExpr]tableRef
+}
+
+// scope holds the FROM clause of one SELECT. Qualifiers resolve against the
+// innermost scope first and then the enclosing ones, as in SQL.
+type scope struct {
+	tables []tableRef
+	// derived holds the aliases of subqueries in FROM, which shadow outer
+	// tables of the same name but are not tables themselves
+	derived []string
+	parent  *scope
+}
+
+// resolve maps a column or star qualifier to the table it names in s or an
+// enclosing scope
+func (s *scope) resolve(qualifier sqlparser.TableName) (tableRef, bool) {
+	name := qualifier.Name.String()
+	for ; s != nil; s = s.parent {
+		for _, t := range s.tables {
+			if t.Alias == name {
+				return t, true
+			}
+		}
+		for _, alias := range s.derived {
+			if alias == name {
+				return tableRef{}, false
+			}
+		}
+	}
+	return tableRef{}, false
+}
+
+// statementAnalysis collects the references of a statement with the scope
+// each column and * appears in
+type statementAnalysis struct {
+	refs  queryRefs
+	cols  []scopedNode[*sqlparser.ColName]
+	stars []scopedNode[*sqlparser.StarExpr]
+}
+
+type scopedNode[T any] struct {
+	node  T
+	scope *scope
+}
+
+// analyzeStatement collects the tables and columns referenced by stmt,
+// resolving aliases and column qualifiers to table names where possible
+func analyzeStatement(stmt sqlparser.Statement) queryRefs {
+	a := &statementAnalysis{refs: queryRefs{
+		columnTables: map[*sqlparser.ColName]tableRef{},
+		starTables:   map[*sqlparser.StarExpr]tableRef{},
+	}}
+	a.walk(stmt, &scope{})
+
+	refs := a.refs
+	for _, col := range a.cols {
+		t, ok := refs.resolveColumn(col.node.Qualifier, col.scope)
+		if ok {
+			refs.columnTables[col.node] = t
+		}
+		refs.Columns = append(refs.Columns, columnRef{Table: t.Name, Schema: t.Schema, Name: col.node.Name.String()})
+	}
+	for _, star := range a.stars {
+		if star.node.TableName.IsEmpty() {
+			refs.Stars = append(refs.Stars, tableRef{})
+			continue
+		}
+		if t, ok := star.scope.resolve(star.node.TableName); ok {
+			refs.starTables[star.node] = t
+			refs.Stars = append(refs.Stars, t)
+		}
+	}
+	return refs
+}
+
+// walk records the tables, columns and stars of node in sc, opening a new
+// scope for every SELECT
+func (a *statementAnalysis) walk(node sqlparser.SQLNode, sc *scope) {
+	sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		switch node := node.(type) {
+		case *sqlparser.Select:
+			a.walkSelect(node, sc)
+			return false, nil
+		case *sqlparser.AliasedTableExpr:
+			a.fromTable(node, sc)
+			return false, nil
+		case *sqlparser.ColName:
+			a.cols = append(a.cols, scopedNode[*sqlparser.ColName]{node, sc})
+			return false, nil // don't mistake the qualifier for a table
+		case *sqlparser.StarExpr:
+			a.stars = append(a.stars, scopedNode[*sqlparser.StarExpr]{node, sc})
+			return false, nil
+		}
+		return true, nil
+	}, node)
+}
+
+// walkSelect records sel in a scope of its own, collecting its FROM clause
+// before the expressions that may refer to it
+func (a *statementAnalysis) walkSelect(sel *sqlparser.Select, parent *scope) {
+	sc := &scope{parent: parent}
+	var on []sqlparser.Expr
+	var from func(expr sqlparser.TableExpr)
+	from = func(expr sqlparser.TableExpr) {
+		switch expr := expr.(type) {
+		case *sqlparser.AliasedTableExpr:
+			a.fromTable(expr, sc)
+		case *sqlparser.ParenTableExpr:
+			for _, e := range expr.Exprs {
+				from(e)
+			}
+		case *sqlparser.JoinTableExpr:
+			from(expr.LeftExpr)
+			from(expr.RightExpr)
+			if expr.On != nil {
+				on = append(on, expr.On)
+			}
+		}
+	}
+	for _, expr := range sel.From {
+		from(expr)
+	}
+	for _, expr := range on {
+		a.walk(expr, sc)
+	}
+	a.walk(sel.SelectExprs, sc)
+	if sel.Where != nil {
+		a.walk(sel.Where, sc)
+	}
+	a.walk(sel.GroupBy, sc)
+	if sel.Having != nil {
+		a.walk(sel.Having, sc)
+	}
+	a.walk(sel.OrderBy, sc)
+	if sel.Limit != nil {
+		a.walk(sel.Limit, sc)
+	}
+}
+
+// fromTable records a FROM item in sc. A subquery in FROM can't see the
+// other items of sc, so it is scoped under the enclosing SELECT.
+func (a *statementAnalysis) fromTable(expr *sqlparser.AliasedTableExpr, sc *scope) {
+	switch e := expr.Expr.(type) {
+	case sqlparser.TableName:
+		ref := tableRef{
+			Schema: e.Qualifier.String(),
+			Name:   e.Name.String(),
+			Alias:  expr.As.String(),
+		}
+		if ref.Alias == "" {
+			ref.Alias = ref.Name
+		}
+		a.refs.Tables = append(a.refs.Tables, ref)
+		sc.tables = append(sc.tables, ref)
+	case *sqlparser.Subquery:
+		sc.derived = append(sc.derived, expr.As.String())
+		a.walk(e, sc.parent)
+	}
+}
+
+// resolveColumn maps a column qualifier to the table it names as seen from
+// sc. An empty qualifier resolves only when the statement reads a single
+// table, since an unqualified column of a subquery may belong to an outer one.
+func (refs queryRefs) resolveColumn(qualifier sqlparser.TableName, sc *scope) (tableRef, bool) {
+	if qualifier.IsEmpty() {
+		if len(refs.Tables) == 1 {
+			return refs.Tables[0], true
+		}
+		return tableRef{}, false
+	}
+	return sc.resolve(qualifier)
+}
+
+// column returns the table col of the analyzed statement reads, if known
+func (refs queryRefs) column(col *sqlparser.ColName) (tableRef, bool) {
+	t, ok := refs.columnTables[col]
+	return t, ok
+}
+
+// star returns the table a qualified * of the analyzed statement selects
+func (refs queryRefs) star(star *sqlparser.StarExpr) (tableRef, bool) {
+	t, ok := refs.starTables[star]
+	return t, ok
+}
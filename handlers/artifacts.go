@@ -0,0 +1,390 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"sql-engine/artifact"
+	"sql-engine/auth"
+	"sql-engine/cache"
+	"sql-engine/rbac"
+	"sql-engine/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Key prefixes of the artifacts each feature writes
+const (
+	exportArtifactPrefix   = "exports/"
+	snapshotArtifactPrefix = "schema-snapshots/"
+	stagingArtifactPrefix  = "imports/staging/"
+
+	savedExportPrefix = "export/"
+)
+
+// UseArtifacts sets where exports, schema snapshots and staged imports are
+// kept
+func (h *Handler) UseArtifacts(s artifact.Store) {
+	h.artifacts = s
+}
+
+// StartArtifactLifecycle deletes artifacts past the expiry of their
+// lifecycle rule every hour, along with the records of saved exports that
+// expired
+func (h *Handler) StartArtifactLifecycle() {
+	if len(h.cfg.Artifacts.Lifecycle) == 0 {
+		return
+	}
+	expire := func(ctx context.Context) {
+		deleted, err := artifact.Expire(ctx, h.artifacts, h.cfg.Artifacts.Lifecycle, time.Now())
+		if err != nil {
+			slog.Error("Expiring artifacts failed", "error", err)
+		}
+		if deleted > 0 {
+			slog.Info("Deleted expired artifacts", "count", deleted)
+		}
+		h.pruneSavedExports()
+	}
+	h.sched.Go("artifact-lifecycle", expire)
+	h.sched.Every("artifact-lifecycle", time.Hour, expire)
+}
+
+// ListArtifacts lists the stored artifacts, optionally only those under
+// ?prefix, with when the lifecycle rules delete them
+func (h *Handler) ListArtifacts(c *gin.Context) {
+	objects, err := h.artifacts.List(c.Request.Context(), c.Query("prefix"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	items := []gin.H{}
+	var total int64
+	for _, obj := range objects {
+		item := gin.H{"key": obj.Key, "size": obj.Size, "mod_time": obj.ModTime}
+		if at, ok := artifact.ExpiresAt(h.cfg.Artifacts.Lifecycle, obj.Key, obj.ModTime); ok {
+			item["expires_at"] = at
+		}
+		items = append(items, item)
+		total += obj.Size
+	}
+	lifecycle := []gin.H{}
+	for _, rule := range h.cfg.Artifacts.Lifecycle {
+		lifecycle = append(lifecycle, gin.H{"prefix": rule.Prefix, "expire_after": rule.ExpireAfter.String()})
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"backend":   h.cfg.Artifacts.Backend,
+		"artifacts": items,
+		"bytes":     total,
+		"lifecycle": lifecycle,
+	})
+}
+
+// DownloadArtifact streams the artifact at the key following /artifacts/
+func (h *Handler) DownloadArtifact(c *gin.Context) {
+	key := strings.TrimPrefix(c.Param("key"), "/")
+	if !artifact.ValidKey(key) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid artifact key"})
+		return
+	}
+	r, err := h.artifacts.Get(c.Request.Context(), key)
+	if errors.Is(err, artifact.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Artifact not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	streamArtifact(c, r, "application/octet-stream", path.Base(key))
+}
+
+// DeleteArtifact deletes the artifact at the key following /artifacts/
+func (h *Handler) DeleteArtifact(c *gin.Context) {
+	key := strings.TrimPrefix(c.Param("key"), "/")
+	if !artifact.ValidKey(key) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid artifact key"})
+		return
+	}
+	if err := h.artifacts.Delete(c.Request.Context(), key); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// streamArtifact writes an opened artifact as a download and closes it
+func streamArtifact(c *gin.Context, r io.ReadCloser, contentType, filename string) {
+	defer r.Close()
+	cache.SetClass(c, cache.Streamed)
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Status(http.StatusOK)
+	if _, err := io.Copy(c.Writer, r); err != nil {
+		// Dropping the connection tells the client the file is incomplete
+		c.Error(err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// SavedExport is a table export written to the artifact store with
+// ?save=true, to be downloaded later or by someone else
+type SavedExport struct {
+	ID        string    `json:"id"`
+	Schema    string    `json:"schema"`
+	Table     string    `json:"table"`
+	Format    string    `json:"format"`
+	Key       string    `json:"key"`
+	Size      int64     `json:"size"`
+	Owner     string    `json:"owner,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	// ExpiresAt is when the lifecycle rules delete the file, if ever
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// visibleTo reports whether the caller may download the export: its owner
+// and administrators
+func (e SavedExport) visibleTo(c *gin.Context) bool {
+	if access := rbac.FromContext(c.Request.Context()); access != nil && access.IsAdmin() {
+		return true
+	}
+	return e.Owner == "" || e.Owner == auth.Principal(c)
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// saveExport runs an export into the artifact store instead of the response
+// and answers with the record of the saved file
+func (h *Handler) saveExport(c *gin.Context, ctx context.Context, schema, table, format, query string) {
+	e := SavedExport{
+		ID:        newID(),
+		Schema:    schema,
+		Table:     table,
+		Format:    format,
+		Owner:     auth.Principal(c),
+		CreatedAt: time.Now(),
+	}
+	e.Key = exportArtifactPrefix + e.ID + "/" + table + "." + exportFormats[format][1]
+	if at, ok := artifact.ExpiresAt(h.cfg.Artifacts.Lifecycle, e.Key, e.CreatedAt); ok {
+		e.ExpiresAt = &at
+	}
+
+	pr, pw := io.Pipe()
+	copied := make(chan error, 1)
+	start := time.Now()
+	go func() {
+		err := h.dialect.CopyOut(ctx, h.queryDB(ctx), pw, query, format)
+		pw.CloseWithError(err)
+		copied <- err
+	}()
+	body := &countingReader{r: pr}
+	err := h.artifacts.Put(ctx, e.Key, body)
+	// Unblocks the export if storing it failed
+	pr.CloseWithError(errors.New("artifact store failed"))
+	copyErr := <-copied
+	meterStatement(ctx, time.Since(start), 0)
+	switch {
+	case errors.Is(copyErr, errors.ErrUnsupported):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Format " + format + " is not supported by this database"})
+		return
+	case copyErr != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Export failed: " + copyErr.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Storing the export failed: " + err.Error()})
+		return
+	}
+	e.Size = body.n
+	if err := h.meta.Put(savedExportPrefix+e.ID, e); err != nil {
+		h.artifacts.Delete(context.Background(), e.Key)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, e)
+}
+
+// ListSavedExports lists the caller's saved exports, or everyone's for
+// administrators, newest first
+func (h *Handler) ListSavedExports(c *gin.Context) {
+	keys, err := h.meta.List(savedExportPrefix)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	exports := []SavedExport{}
+	for _, key := range keys {
+		var e SavedExport
+		if err := h.meta.Get(key, &e); err != nil || !e.visibleTo(c) {
+			continue
+		}
+		exports = append(exports, e)
+	}
+	sort.Slice(exports, func(i, j int) bool { return exports[i].CreatedAt.After(exports[j].CreatedAt) })
+	c.JSON(http.StatusOK, gin.H{"exports": exports})
+}
+
+// DownloadSavedExport streams a saved export. Exports whose file the
+// lifecycle rules deleted are gone for good. With query.watermark, CSV
+// files end with a watermark naming this download.
+func (h *Handler) DownloadSavedExport(c *gin.Context) {
+	e, ok := h.loadSavedExport(c)
+	if !ok {
+		return
+	}
+	r, err := h.artifacts.Get(c.Request.Context(), e.Key)
+	if errors.Is(err, artifact.ErrNotFound) {
+		c.JSON(http.StatusGone, gin.H{"error": "Export expired"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if e.Format == "csv" {
+		wm, err := h.watermark(c, e.Schema, e.Table, e.ID)
+		if err != nil {
+			r.Close()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record the export's watermark: " + err.Error()})
+			return
+		}
+		if wm != nil {
+			r = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(r, bytes.NewReader(wm.footer())), r}
+		}
+	}
+	kind := exportFormats[e.Format]
+	streamArtifact(c, r, kind[0], e.Table+"."+kind[1])
+}
+
+// DeleteSavedExport deletes a saved export and its file
+func (h *Handler) DeleteSavedExport(c *gin.Context) {
+	e, ok := h.loadSavedExport(c)
+	if !ok {
+		return
+	}
+	if err := h.artifacts.Delete(c.Request.Context(), e.Key); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.meta.Delete(savedExportPrefix + e.ID); err != nil && !errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// loadSavedExport fetches the export named by :id, writing an error
+// response if it can't be loaded. Exports of others are reported as missing.
+func (h *Handler) loadSavedExport(c *gin.Context) (SavedExport, bool) {
+	var e SavedExport
+	err := h.meta.Get(savedExportPrefix+c.Param("id"), &e)
+	if errors.Is(err, store.ErrNotFound) || (err == nil && !e.visibleTo(c)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Export not found"})
+		return e, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return e, false
+	}
+	return e, true
+}
+
+// pruneSavedExports deletes the records of saved exports that expired
+func (h *Handler) pruneSavedExports() {
+	keys, err := h.meta.List(savedExportPrefix)
+	if err != nil {
+		slog.Error("Failed to list saved exports", "error", err)
+		return
+	}
+	now := time.Now()
+	for _, key := range keys {
+		var e SavedExport
+		if err := h.meta.Get(key, &e); err != nil || e.ExpiresAt == nil || now.Before(*e.ExpiresAt) {
+			continue
+		}
+		h.artifacts.Delete(context.Background(), e.Key)
+		if err := h.meta.Delete(key); err != nil && !errors.Is(err, store.ErrNotFound) {
+			slog.Error("Failed to delete expired export", "error", err)
+		}
+	}
+}
+
+// stageImport copies an import source to the artifact store and opens the
+// copy, so that a file failing to load can be inspected afterwards
+func (h *Handler) stageImport(ctx context.Context, name string, body io.Reader) (string, io.ReadCloser, error) {
+	if !artifact.ValidKey(name) || strings.Contains(name, "/") {
+		name = "source"
+	}
+	key := stagingArtifactPrefix + newID() + "/" + name
+	if err := h.artifacts.Put(ctx, key, body); err != nil {
+		h.artifacts.Delete(context.Background(), key)
+		return "", nil, err
+	}
+	staged, err := h.artifacts.Get(ctx, key)
+	if err != nil {
+		return "", nil, err
+	}
+	return key, staged, nil
+}
+
+// finishStaging deletes the staged source of a successful import, and
+// points the result of a failed one at it
+func (h *Handler) finishStaging(key string, result *ImportResult, err error) {
+	if err != nil {
+		result.StagedFile = key
+		return
+	}
+	if err := h.artifacts.Delete(context.Background(), key); err != nil {
+		slog.Error("Failed to delete staged import", "error", err)
+	}
+}
+
+// putSnapshotArtifact writes the tables of a named schema snapshot to the
+// artifact store under a key of its own, so that a trashed snapshot and a
+// new one of the same name don't share contents
+func (h *Handler) putSnapshotArtifact(ctx context.Context, name string, tables []TableSchema) (string, error) {
+	data, err := json.Marshal(tables)
+	if err != nil {
+		return "", err
+	}
+	key := snapshotArtifactPrefix + name + "-" + newID() + ".json"
+	return key, h.artifacts.Put(ctx, key, bytes.NewReader(data))
+}
+
+// snapshotTables returns the tables of a snapshot, reading them from the
+// artifact store unless the snapshot predates it and holds them itself
+func (h *Handler) snapshotTables(ctx context.Context, snap SchemaSnapshot) ([]TableSchema, error) {
+	if snap.Artifact == "" {
+		return snap.Schema, nil
+	}
+	r, err := h.artifacts.Get(ctx, snap.Artifact)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	var tables []TableSchema
+	if err := json.NewDecoder(r).Decode(&tables); err != nil {
+		return nil, err
+	}
+	return tables, nil
+}
@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Assertion types
+const (
+	AssertRowCount = "row_count" // Min/Max bound the number of rows
+	AssertNotNull  = "not_null"  // Column is never NULL
+	AssertCompare  = "compare"   // every Column value satisfies Op Value
+)
+
+// Assertion is a check evaluated against a saved query's result
+type Assertion struct {
+	Type   string      `json:"type"`
+	Column string      `json:"column,omitempty"`
+	Min    *int        `json:"min,omitempty"`
+	Max    *int        `json:"max,omitempty"`
+	Op     string      `json:"op,omitempty"`
+	Value  interface{} `json:"value,omitempty"`
+}
+
+// AssertionResult reports the outcome of one assertion
+type AssertionResult struct {
+	Assertion Assertion `json:"assertion"`
+	Passed    bool      `json:"passed"`
+	Message   string    `json:"message,omitempty"`
+}
+
+// TestRun is the outcome of evaluating a saved query's assertions. Skipped
+// runs did not execute because an upstream was not ready; Error says why.
+type TestRun struct {
+	QueryID  string            `json:"query_id"`
+	RanAt    time.Time         `json:"ran_at"`
+	Duration string            `json:"duration"`
+	Passed   bool              `json:"passed"`
+	Skipped  bool              `json:"skipped,omitempty"`
+	Results  []AssertionResult `json:"results,omitempty"`
+	Error    string            `json:"error,omitempty"`
+	// TriggeredBy is the scheduled query whose pipeline ran this one, or
+	// webhook:<id> for the query of a webhook
+	TriggeredBy string `json:"triggered_by,omitempty"`
+}
+
+func (a Assertion) validate() error {
+	switch a.Type {
+	case AssertRowCount:
+		if a.Min == nil && a.Max == nil {
+			return errors.New("row_count needs min and/or max")
+		}
+	case AssertNotNull:
+		if a.Column == "" {
+			return errors.New("not_null needs a column")
+		}
+	case AssertCompare:
+		if a.Column == "" || a.Value == nil {
+			return errors.New("compare needs a column and value")
+		}
+		switch a.Op {
+		case "=", "!=", "<", "<=", ">", ">=":
+		default:
+			return fmt.Errorf("unknown operator %q", a.Op)
+		}
+	default:
+		return fmt.Errorf("unknown assertion type %q", a.Type)
+	}
+	return nil
+}
+
+// Evaluate checks the assertion against a query result
+func (a Assertion) Evaluate(result *QueryResult) AssertionResult {
+	r := AssertionResult{Assertion: a, Passed: true}
+
+	switch a.Type {
+	case AssertRowCount:
+		n := len(result.Rows)
+		if (a.Min != nil && n < *a.Min) || (a.Max != nil && n > *a.Max) {
+			r.Passed = false
+			r.Message = fmt.Sprintf("got %d rows", n)
+		}
+
+	case AssertNotNull:
+		for i, row := range result.Rows {
+			if v, ok := row[a.Column]; !ok || v == nil {
+				r.Passed = false
+				r.Message = fmt.Sprintf("row %d: %s is NULL", i, a.Column)
+				break
+			}
+		}
+
+	case AssertCompare:
+		for i, row := range result.Rows {
+			if !compareValues(row[a.Column], a.Op, a.Value) {
+				r.Passed = false
+				r.Message = fmt.Sprintf("row %d: %s = %v does not satisfy %s %v", i, a.Column, row[a.Column], a.Op, a.Value)
+				break
+			}
+		}
+	}
+	return r
+}
+
+// compareValues compares numerically when both sides are numbers and as
+// strings otherwise
+func compareValues(left interface{}, op string, right interface{}) bool {
+	if left == nil {
+		return false
+	}
+
+	var cmp int
+	lf, lok := toFloat(left)
+	rf, rok := toFloat(right)
+	if lok && rok {
+		switch {
+		case lf < rf:
+			cmp = -1
+		case lf > rf:
+			cmp = 1
+		}
+	} else {
+		ls, rs := toString(left), toString(right)
+		switch {
+		case ls < rs:
+			cmp = -1
+		case ls > rs:
+			cmp = 1
+		}
+	}
+
+	switch op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	case []byte:
+		f, err := strconv.ParseFloat(string(v), 64)
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func toString(v interface{}) string {
+	switch v := v.(type) {
+	case []byte:
+		return string(v)
+	case string:
+		return v
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,465 @@
+package handlers
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sqlKeywords are offered as completions and never taken for aliases
+var sqlKeywords = []string{
+	"ALL", "AND", "AS", "ASC", "BETWEEN", "BY", "CASE", "CAST", "CROSS", "DESC", "DISTINCT", "ELSE",
+	"END", "EXCEPT", "EXISTS", "EXPLAIN", "FALSE", "FETCH", "FILTER", "FIRST", "FROM", "FULL", "GROUP",
+	"HAVING", "ILIKE", "IN", "INNER", "INTERSECT", "IS", "JOIN", "LAST", "LATERAL", "LEFT", "LIKE",
+	"LIMIT", "NATURAL", "NOT", "NULL", "NULLS", "OFFSET", "ON", "OR", "ORDER", "OUTER", "OVER",
+	"PARTITION", "RECURSIVE", "RIGHT", "SELECT", "THEN", "TRUE", "UNION", "USING", "VALUES", "WHEN",
+	"WHERE", "WINDOW", "WITH",
+}
+
+// tableKeywords are followed by a table name
+var tableKeywords = []string{"from", "join", "into", "update", "table"}
+
+// Completion contexts of GetAutocomplete
+const (
+	completeKeyword    = "keyword"
+	completeTable      = "table"
+	completeColumn     = "column"
+	completeExpression = "expression"
+	// completeNone is reported inside string literals and comments
+	completeNone = "none"
+)
+
+// AutocompleteColumn is a column offered as a completion
+type AutocompleteColumn struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// AutocompleteFunction is a function offered as a completion; built-in
+// functions come without a signature
+type AutocompleteFunction struct {
+	Name       string `json:"name"`
+	Arguments  string `json:"arguments,omitempty"`
+	ReturnType string `json:"return_type,omitempty"`
+}
+
+// GetAutocomplete returns the names an SQL editor completes: schemas,
+// tables, columns with their types, functions and keywords. With ?sql, and
+// ?cursor as a character offset into it (the end by default), only what
+// fits at the cursor and starts with the word being typed is returned,
+// e.g. the columns of the table an alias stands for after "u.".
+func (h *Handler) GetAutocomplete(c *gin.Context) {
+	schema, ok := h.schemaParam(c)
+	if !ok {
+		return
+	}
+	deny := h.cfg.Query.Denylist
+	schemas, err := h.dialect.ListSchemas(h.db)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	schemas = slices.DeleteFunc(schemas, func(s string) bool { return matchesAny(deny.Schemas, s) })
+
+	visible := func(tables []TableSchema) []TableSchema {
+		return slices.DeleteFunc(tables, func(t TableSchema) bool { return matchesAny(deny.Tables, t.Name) })
+	}
+	tables, err := h.visibleSchema(c, schema)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	tables = visible(tables)
+	// Tables of the other schemas a statement refers to are loaded as needed
+	loaded := map[string][]TableSchema{schema: tables}
+	tablesOf := func(schema string) []TableSchema {
+		if tables, ok := loaded[schema]; ok {
+			return tables
+		}
+		var tables []TableSchema
+		if h.isDefaultSchema(schema) || slices.Contains(schemas, schema) {
+			if all, err := h.visibleSchema(c, schema); err == nil {
+				tables = visible(all)
+			}
+		}
+		loaded[schema] = tables
+		return tables
+	}
+
+	userFunctions, err := h.dialect.ListFunctions(h.db, schema)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	functions := []AutocompleteFunction{}
+	for _, f := range userFunctions {
+		functions = append(functions, AutocompleteFunction{Name: f.Name, Arguments: f.Arguments, ReturnType: f.ReturnType})
+	}
+	for _, name := range h.dialect.BuiltinFunctions() {
+		if !slices.ContainsFunc(functions, func(f AutocompleteFunction) bool { return f.Name == name }) {
+			functions = append(functions, AutocompleteFunction{Name: name})
+		}
+	}
+	functions = slices.DeleteFunc(functions, func(f AutocompleteFunction) bool { return matchesAny(deny.Functions, f.Name) })
+
+	columnsOf := func(t TableSchema) []AutocompleteColumn {
+		out := []AutocompleteColumn{}
+		for _, col := range t.Columns {
+			out = append(out, AutocompleteColumn{Name: col.Name, Type: col.DataType})
+		}
+		return out
+	}
+	text, scoped := c.GetQuery("sql")
+	if !scoped {
+		names := []string{}
+		columns := map[string][]AutocompleteColumn{}
+		for _, t := range tables {
+			names = append(names, t.Name)
+			columns[t.Name] = columnsOf(t)
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"schema":    schema,
+			"schemas":   schemas,
+			"tables":    names,
+			"columns":   columns,
+			"functions": functions,
+			"keywords":  sqlKeywords,
+		})
+		return
+	}
+
+	runes := []rune(text)
+	cursor, ok := intQuery(c, "cursor", len(runes), len(runes))
+	if !ok {
+		return
+	}
+	scope := scopeCompletion(string(runes[:cursor]), string(runes[cursor:]))
+	matches := func(name string) bool {
+		return scope.Context != completeNone && strings.HasPrefix(strings.ToLower(name), strings.ToLower(scope.Prefix))
+	}
+
+	out := gin.H{
+		"schema":    schema,
+		"context":   scope.Context,
+		"prefix":    scope.Prefix,
+		"schemas":   []string{},
+		"aliases":   []string{},
+		"tables":    []string{},
+		"columns":   map[string][]AutocompleteColumn{},
+		"functions": []AutocompleteFunction{},
+		"keywords":  []string{},
+	}
+	if scope.Qualifier != "" {
+		out["qualifier"] = scope.Qualifier
+	}
+	// find resolves a table reference of the statement
+	find := func(ref tableRef) (string, TableSchema, bool) {
+		refSchema := orDefault(h.dialect, ref.Schema)
+		if ref.Schema == "" {
+			refSchema = schema
+		}
+		i := slices.IndexFunc(tablesOf(refSchema), func(t TableSchema) bool { return strings.EqualFold(t.Name, ref.Name) })
+		if i < 0 {
+			return "", TableSchema{}, false
+		}
+		t := loaded[refSchema][i]
+		key := t.Name
+		if refSchema != schema {
+			key = refSchema + "." + t.Name
+		}
+		return key, t, true
+	}
+	addColumns := func(refs []tableRef) {
+		columns := out["columns"].(map[string][]AutocompleteColumn)
+		for _, ref := range refs {
+			key, t, ok := find(ref)
+			if !ok {
+				continue
+			}
+			cols := slices.DeleteFunc(columnsOf(t), func(col AutocompleteColumn) bool { return !matches(col.Name) })
+			if len(cols) > 0 {
+				columns[key] = cols
+			}
+		}
+	}
+	addTables := func(tables []TableSchema) {
+		names := out["tables"].([]string)
+		for _, t := range tables {
+			if matches(t.Name) {
+				names = append(names, t.Name)
+			}
+		}
+		out["tables"] = names
+	}
+	addNames := func(field string, names []string) {
+		var keep []string
+		for _, name := range names {
+			if matches(name) {
+				keep = append(keep, name)
+			}
+		}
+		if keep != nil {
+			out[field] = keep
+		}
+	}
+
+	switch scope.Context {
+	case completeKeyword:
+		addNames("keywords", sqlKeywords)
+	case completeTable:
+		if scope.Qualifier != "" {
+			out["schema"] = scope.Qualifier
+			addTables(tablesOf(scope.Qualifier))
+			break
+		}
+		addNames("schemas", schemas)
+		addTables(tables)
+	case completeColumn:
+		// The qualifier is an alias or table of the statement, a table of
+		// the schema, or a schema
+		if i := slices.IndexFunc(scope.Refs, func(ref tableRef) bool { return refersTo(ref, scope.Qualifier) }); i >= 0 {
+			addColumns(scope.Refs[i : i+1])
+		} else if _, _, ok := find(tableRef{Name: scope.Qualifier}); ok {
+			addColumns([]tableRef{{Name: scope.Qualifier}})
+		} else if slices.Contains(schemas, scope.Qualifier) {
+			out["context"] = completeTable
+			out["schema"] = scope.Qualifier
+			addTables(tablesOf(scope.Qualifier))
+		}
+	case completeExpression:
+		refs := scope.Refs
+		if len(refs) == 0 {
+			for _, t := range tables {
+				refs = append(refs, tableRef{Name: t.Name})
+			}
+		}
+		addColumns(refs)
+		var aliases []string
+		for _, ref := range scope.Refs {
+			if ref.Alias != "" {
+				aliases = append(aliases, ref.Alias)
+			}
+		}
+		addNames("aliases", aliases)
+		addTables(tables)
+		var keep []AutocompleteFunction
+		for _, f := range functions {
+			if matches(f.Name) {
+				keep = append(keep, f)
+			}
+		}
+		if keep != nil {
+			out["functions"] = keep
+		}
+		addNames("keywords", sqlKeywords)
+	}
+	c.JSON(http.StatusOK, out)
+}
+
+// completionScope tells what may be completed at a cursor
+type completionScope struct {
+	Context string
+	// Prefix is the part of the word before the cursor, and Qualifier the
+	// name before the dot preceding it, if any
+	Prefix    string
+	Qualifier string
+	// Refs are the tables the statement around the cursor reads or writes
+	Refs []tableRef
+}
+
+// refersTo reports whether columns qualified with name belong to ref
+func refersTo(ref tableRef, name string) bool {
+	if ref.Alias != "" {
+		return strings.EqualFold(ref.Alias, name)
+	}
+	return strings.EqualFold(ref.Name, name)
+}
+
+// scopeCompletion works out the completion context of the statement
+// around a cursor from the text before and after it
+func scopeCompletion(before, after string) completionScope {
+	tokens, open := scanSQL(before)
+	if open {
+		return completionScope{Context: completeNone}
+	}
+	// Only the statement holding the cursor matters
+	for i := len(tokens) - 1; i >= 0; i-- {
+		if !tokens[i].word && tokens[i].text == ";" {
+			tokens = tokens[i+1:]
+			break
+		}
+	}
+	rest, _ := scanSQL(after)
+	if i := slices.IndexFunc(rest, func(t sqlToken) bool { return !t.word && t.text == ";" }); i >= 0 {
+		rest = rest[:i]
+	}
+	scope := completionScope{Refs: tableRefs(slices.Concat(tokens, rest))}
+
+	// The word being typed, when the cursor touches one
+	if n := len(tokens); n > 0 && tokens[n-1].word && (tokens[n-1].unclosed || endsInWord(before)) {
+		scope.Prefix = tokens[n-1].text
+		tokens = tokens[:n-1]
+	}
+	if n := len(tokens); n > 1 && tokens[n-1].text == "." && !tokens[n-1].word && tokens[n-2].word {
+		scope.Qualifier = tokens[n-2].text
+		tokens = tokens[:n-2]
+	}
+
+	switch {
+	case scope.Qualifier != "":
+		scope.Context = completeColumn
+		if n := len(tokens); n > 0 && tokens[n-1].is(tableKeywords...) {
+			scope.Context = completeTable
+		}
+	case len(tokens) == 0:
+		scope.Context = completeKeyword
+	case tokens[len(tokens)-1].is(tableKeywords...):
+		scope.Context = completeTable
+	case !tokens[len(tokens)-1].word && tokens[len(tokens)-1].text == "," && clauseOf(tokens) == "from":
+		scope.Context = completeTable
+	default:
+		scope.Context = completeExpression
+	}
+	return scope
+}
+
+// clauseOf returns the last clause keyword of tokens, lowercased
+func clauseOf(tokens []sqlToken) string {
+	depth := 0
+	for i := len(tokens) - 1; i >= 0; i-- {
+		t := tokens[i]
+		switch {
+		case !t.word && t.text == ")":
+			depth++
+		case !t.word && t.text == "(":
+			depth--
+		case depth <= 0 && t.is("select", "from", "where", "group", "order", "having", "join", "on", "set", "values", "limit"):
+			return strings.ToLower(t.text)
+		}
+	}
+	return ""
+}
+
+// tableRefs collects the tables named after FROM (including comma-separated
+// lists), JOIN, INTO and UPDATE with their aliases
+func tableRefs(tokens []sqlToken) []tableRef {
+	var refs []tableRef
+	for i := 0; i < len(tokens); i++ {
+		if !tokens[i].is("from", "join", "into", "update") {
+			continue
+		}
+		list := tokens[i].is("from")
+		for i+1 < len(tokens) {
+			j := i + 1
+			if tokens[j].is("only", "lateral") {
+				j++
+			}
+			if j >= len(tokens) || !tokens[j].isName() {
+				break
+			}
+			ref := tableRef{Name: tokens[j].text}
+			if j+2 < len(tokens) && tokens[j+1].text == "." && !tokens[j+1].word && tokens[j+2].isName() {
+				ref.Schema, ref.Name = ref.Name, tokens[j+2].text
+				j += 2
+			}
+			if j+1 < len(tokens) && tokens[j+1].is("as") {
+				j++
+			}
+			if j+1 < len(tokens) && tokens[j+1].isName() {
+				ref.Alias = tokens[j+1].text
+				j++
+			}
+			refs = append(refs, ref)
+			i = j
+			if !list || i+1 >= len(tokens) || tokens[i+1].word || tokens[i+1].text != "," {
+				break
+			}
+			i++
+		}
+	}
+	return refs
+}
+
+// sqlToken is a word (identifier or keyword) or a punctuation character
+type sqlToken struct {
+	text   string
+	word   bool
+	quoted bool
+	// unclosed is set on a quoted identifier the text ends inside of
+	unclosed bool
+}
+
+// is reports whether the token is one of the given keywords
+func (t sqlToken) is(keywords ...string) bool {
+	return t.word && !t.quoted && slices.ContainsFunc(keywords, func(k string) bool { return strings.EqualFold(k, t.text) })
+}
+
+// isName reports whether the token can name a table or alias
+func (t sqlToken) isName() bool {
+	return t.word && (t.quoted || !slices.ContainsFunc(sqlKeywords, func(k string) bool { return strings.EqualFold(k, t.text) }))
+}
+
+// scanSQL splits SQL text into tokens, skipping string literals, numbers
+// and comments. open reports that the text ends inside a literal or
+// comment.
+func scanSQL(s string) (tokens []sqlToken, open bool) {
+	for i := 0; i < len(s); {
+		ch := s[i]
+		switch {
+		case ch == '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return tokens, true
+			}
+			i += end + 2
+		case strings.HasPrefix(s[i:], "--"):
+			end := strings.IndexByte(s[i:], '\n')
+			if end < 0 {
+				return tokens, true
+			}
+			i += end + 1
+		case strings.HasPrefix(s[i:], "/*"):
+			end := strings.Index(s[i+2:], "*/")
+			if end < 0 {
+				return tokens, true
+			}
+			i += end + 4
+		case ch == '"' || ch == '`':
+			end := strings.IndexByte(s[i+1:], ch)
+			if end < 0 {
+				return append(tokens, sqlToken{text: s[i+1:], word: true, quoted: true, unclosed: true}), false
+			}
+			tokens = append(tokens, sqlToken{text: s[i+1 : i+1+end], word: true, quoted: true})
+			i += end + 2
+		case ch >= '0' && ch <= '9':
+			for i < len(s) && (isWordByte(s[i]) || s[i] == '.') {
+				i++
+			}
+		case isWordByte(ch):
+			j := i
+			for j < len(s) && isWordByte(s[j]) {
+				j++
+			}
+			tokens = append(tokens, sqlToken{text: s[i:j], word: true})
+			i = j
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r':
+			i++
+		default:
+			tokens = append(tokens, sqlToken{text: string(ch)})
+			i++
+		}
+	}
+	return tokens, false
+}
+
+// isWordByte reports whether b may be part of an unquoted identifier;
+// bytes of multi-byte UTF-8 characters are
+func isWordByte(b byte) bool {
+	return b == '_' || b == '$' || b >= 0x80 || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9'
+}
+
+func endsInWord(s string) bool {
+	return s != "" && isWordByte(s[len(s)-1])
+}
@@ -0,0 +1,290 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"sql-engine/cache"
+	"sql-engine/rbac"
+
+	"github.com/gin-gonic/gin"
+)
+
+// blobChunkSize is how much of a value each query reads while streaming
+const blobChunkSize = 256 << 10
+
+// BinaryPreview replaces a binary value in query results when previews are
+// requested, so that browsers can show attachments without receiving them.
+// Data holds the first query.binary_preview_bytes of the value.
+type BinaryPreview struct {
+	Size      int    `json:"size"`
+	MIMEType  string `json:"mime_type"`
+	Truncated bool   `json:"truncated"`
+	Data      []byte `json:"data,omitempty"`
+}
+
+// binaryPreview describes b, keeping its first limit bytes
+func binaryPreview(b []byte, limit int) BinaryPreview {
+	return BinaryPreview{
+		Size:      len(b),
+		MIMEType:  http.DetectContentType(b),
+		Truncated: len(b) > limit,
+		Data:      b[:min(len(b), limit)],
+	}
+}
+
+// blobRef is a binary value selected by a download or thumbnail request
+type blobRef struct {
+	Schema, Table, Column string
+	// LengthSQL selects the value's size in bytes and SliceSQL a part of it,
+	// taking the 1-based start and the count after Args
+	LengthSQL, SliceSQL string
+	Args                []interface{}
+}
+
+// DownloadBlob streams a single bytea, large object or BLOB value, selected
+// by ?column and the row's primary key: ?key for single-column keys,
+// otherwise ?key.<column> for each key column. The content type is sniffed
+// unless ?content_type is given, ?filename makes it an attachment, and a
+// single byte range may be requested with the Range header.
+func (h *Handler) DownloadBlob(c *gin.Context) {
+	ref, ok := h.resolveBlob(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	release, err := h.acquireStatement(ctx)
+	if err != nil {
+		respondQueryError(c, err)
+		return
+	}
+	defer release()
+	tx, end, err := h.dialect.BeginReadOnly(ctx, h.queryDB(ctx))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start read-only transaction: " + err.Error()})
+		return
+	}
+	defer end()
+
+	size, ok := h.blobSize(c, tx, ref)
+	if !ok {
+		return
+	}
+	first, last, partial, ok := parseByteRange(c.GetHeader("Range"), size)
+	if !ok {
+		c.Header("Content-Range", fmt.Sprintf("bytes */%d", size))
+		c.JSON(http.StatusRequestedRangeNotSatisfiable, gin.H{"error": "Range not satisfiable"})
+		return
+	}
+
+	contentType := c.Query("content_type")
+	if contentType == "" {
+		head, err := h.blobSlice(ctx, tx, ref, 0, min(size, 512))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		contentType = http.DetectContentType(head)
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("Accept-Ranges", "bytes")
+	if filename := c.Query("filename"); filename != "" {
+		c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	}
+	c.Header("Content-Length", strconv.FormatInt(max(last-first+1, 0), 10))
+	status := http.StatusOK
+	if partial {
+		status = http.StatusPartialContent
+		c.Header("Content-Range", fmt.Sprintf("bytes %d-%d/%d", first, last, size))
+	}
+	cache.SetClass(c, cache.Streamed)
+	c.Status(status)
+	c.Writer.WriteHeaderNow()
+
+	for pos := first; pos <= last; pos += blobChunkSize {
+		chunk, err := h.blobSlice(ctx, tx, ref, pos, min(blobChunkSize, last-pos+1))
+		if err == nil {
+			_, err = c.Writer.Write(chunk)
+		}
+		if err != nil {
+			// The status is already sent; the short body tells the client
+			slog.WarnContext(c.Request.Context(), "Blob download aborted", "schema", ref.Schema, "table", ref.Table, "column", ref.Column, "error", err)
+			return
+		}
+		c.Writer.Flush()
+	}
+}
+
+// resolveBlob checks the table, column and key parameters of a binary value
+// request and builds the statements reading the value. It writes the error
+// response and returns false when the request is refused.
+func (h *Handler) resolveBlob(c *gin.Context) (*blobRef, bool) {
+	schema, ok := h.schemaParam(c)
+	if !ok {
+		return nil, false
+	}
+	tableName := c.Param("name")
+	if matchesAny(h.cfg.Query.Denylist.Tables, tableName) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Denied: table " + tableName + " is not allowed"})
+		return nil, false
+	}
+	if !h.requireTable(c, schema, tableName) || !h.requireRowLevel(c, schema, tableName) {
+		return nil, false
+	}
+
+	columns, err := h.dialect.ListColumns(h.db, schema, tableName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if len(columns) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Table not found"})
+		return nil, false
+	}
+	column := c.Query("column")
+	var dataType string
+	found := false
+	for _, col := range columns {
+		if col.Name == column {
+			dataType, found = col.DataType, true
+		}
+	}
+	if !found {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Column " + column + " not found"})
+		return nil, false
+	}
+	access := rbac.FromContext(c.Request.Context())
+	grantSchema := h.grantSchema(schema)
+	if !access.CanColumn(grantSchema, tableName, column) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied: column " + column + " is not granted"})
+		return nil, false
+	}
+	var roles []string
+	if access != nil {
+		roles = access.Roles
+	}
+	if _, masked := h.masks.Columns(grantSchema, tableName, roles)[strings.ToLower(column)]; masked {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Masked column " + column + " cannot be downloaded"})
+		return nil, false
+	}
+
+	keyColumns, err := h.dialect.ListPrimaryKeys(h.db, schema, tableName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if len(keyColumns) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Table has no primary key"})
+		return nil, false
+	}
+	where, args, ok := h.keyCondition(c, keyColumns, nil)
+	if !ok {
+		return nil, false
+	}
+
+	// Offsets and counts follow the key values as bind parameters
+	start, count := h.dialect.Placeholder(len(args)+1), h.dialect.Placeholder(len(args)+2)
+	q := h.dialect.Quote
+	lengthExpr, sliceExpr, ok := h.dialect.BinarySlice(q(column), dataType, start, count)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Column " + column + " is not binary"})
+		return nil, false
+	}
+	from := fmt.Sprintf(" FROM %s.%s WHERE %s", q(schema), q(tableName), where)
+	return &blobRef{
+		Schema:    schema,
+		Table:     tableName,
+		Column:    column,
+		LengthSQL: "SELECT " + lengthExpr + from,
+		SliceSQL:  "SELECT " + sliceExpr + from,
+		Args:      args,
+	}, true
+}
+
+// blobSize reads the size of the value, answering 404 when the row is
+// missing or the value is NULL
+func (h *Handler) blobSize(c *gin.Context, tx *sql.Tx, ref *blobRef) (int64, bool) {
+	var size sql.NullInt64
+	err := h.blobScan(c.Request.Context(), tx, ref.LengthSQL, ref.Args, &size)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Row not found"})
+		return 0, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return 0, false
+	}
+	if !size.Valid {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Value is NULL"})
+		return 0, false
+	}
+	return size.Int64, true
+}
+
+// blobSlice reads n bytes of the value from the 0-based offset
+func (h *Handler) blobSlice(ctx context.Context, tx *sql.Tx, ref *blobRef, offset, n int64) ([]byte, error) {
+	var b []byte
+	err := h.blobScan(ctx, tx, ref.SliceSQL, append(slices.Clip(ref.Args), offset+1, n), &b)
+	return b, err
+}
+
+// blobScan runs one statement of a download under the query timeout, so
+// that a long download is not cut short by it
+func (h *Handler) blobScan(ctx context.Context, tx *sql.Tx, query string, args []interface{}, dest interface{}) error {
+	if timeout := h.queryTimeout(ctx); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return tx.QueryRowContext(ctx, query, args...).Scan(dest)
+}
+
+// parseByteRange reads a Range header of a single "bytes=" range over size
+// bytes and returns the inclusive first and last byte. Headers that are
+// missing, malformed or ask for several ranges select the whole value, as
+// RFC 9110 allows; ok is false only for ranges past the end.
+func parseByteRange(header string, size int64) (first, last int64, partial, ok bool) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, size - 1, false, true
+	}
+	startStr, endStr, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, size - 1, false, true
+	}
+	if startStr == "" {
+		// A suffix range: the last n bytes
+		n, err := strconv.ParseInt(endStr, 10, 64)
+		if err != nil || n < 0 {
+			return 0, size - 1, false, true
+		}
+		if n == 0 || size == 0 {
+			return 0, 0, false, false
+		}
+		return max(size-n, 0), size - 1, true, true
+	}
+	first, err := strconv.ParseInt(startStr, 10, 64)
+	if err != nil || first < 0 {
+		return 0, size - 1, false, true
+	}
+	last = size - 1
+	if endStr != "" {
+		if last, err = strconv.ParseInt(endStr, 10, 64); err != nil || last < first {
+			return 0, size - 1, false, true
+		}
+		last = min(last, size-1)
+	}
+	if first >= size {
+		return 0, 0, false, false
+	}
+	return first, last, true, true
+}
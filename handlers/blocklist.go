@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"sql-engine/auth"
+	"sql-engine/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+const blocklistPrefix = "blocklist/"
+
+// BlockedQuery is a query fingerprint administrators have banned
+type BlockedQuery struct {
+	Fingerprint string    `json:"fingerprint"`
+	Normalized  string    `json:"normalized,omitempty"`
+	Message     string    `json:"message"`
+	Alternative string    `json:"alternative,omitempty"` // link to a suggested replacement
+	CreatedBy   string    `json:"created_by,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// BlockQueryRequest blocks either the fingerprint of SQL or a raw fingerprint
+type BlockQueryRequest struct {
+	SQL         string `json:"sql"`
+	Fingerprint string `json:"fingerprint"`
+	Message     string `json:"message"`
+	Alternative string `json:"alternative"`
+}
+
+func (h *Handler) GetBlocklist(c *gin.Context) {
+	keys, err := h.meta.List(blocklistPrefix)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	blocked := []BlockedQuery{}
+	for _, key := range keys {
+		var b BlockedQuery
+		if err := h.meta.Get(key, &b); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		blocked = append(blocked, b)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"blocklist": blocked})
+}
+
+func (h *Handler) BlockQuery(c *gin.Context) {
+	var req BlockQueryRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		return
+	}
+
+	blocked := BlockedQuery{
+		Fingerprint: strings.TrimSpace(req.Fingerprint),
+		Message:     req.Message,
+		Alternative: req.Alternative,
+		CreatedBy:   auth.Principal(c),
+		CreatedAt:   time.Now(),
+	}
+	if sqlText := strings.TrimSpace(req.SQL); sqlText != "" {
+		stmt, err := h.dialect.ParseStatement(sqlText)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "SQL syntax error: " + err.Error()})
+			return
+		}
+		blocked.Normalized, blocked.Fingerprint = fingerprint(stmt)
+	}
+	if blocked.Fingerprint == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sql or fingerprint is required"})
+		return
+	}
+	if blocked.Message == "" {
+		blocked.Message = "This query has been blocked by an administrator"
+	}
+
+	if err := h.meta.Put(blocklistPrefix+blocked.Fingerprint, blocked); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, blocked)
+}
+
+func (h *Handler) UnblockQuery(c *gin.Context) {
+	if err := h.meta.Delete(blocklistPrefix + c.Param("fingerprint")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// blockedQuery returns the blocklist entry for a fingerprint, if any
+func (h *Handler) blockedQuery(hash string) (*BlockedQuery, error) {
+	var b BlockedQuery
+	if err := h.meta.Get(blocklistPrefix+hash, &b); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &b, nil
+}
@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"sql-engine/auth"
+	"sql-engine/lineage"
+	"sql-engine/rbac"
+
+	"github.com/gin-gonic/gin"
+)
+
+const caggRefreshPrefix = "cagg-refresh/"
+
+// ContinuousAggregateInfo describes a TimescaleDB continuous aggregate
+type ContinuousAggregateInfo struct {
+	Schema           string `json:"schema"`
+	Name             string `json:"name"`
+	HypertableSchema string `json:"hypertable_schema"`
+	HypertableName   string `json:"hypertable_name"`
+	// MaterializedOnly is false when queries also aggregate the rows not
+	// materialized yet (real-time aggregation)
+	MaterializedOnly   bool                       `json:"materialized_only"`
+	CompressionEnabled bool                       `json:"compression_enabled"`
+	Definition         string                     `json:"definition"`
+	RefreshPolicy      *ContinuousAggregatePolicy `json:"refresh_policy,omitempty"`
+	// LastRefresh is the latest successful refresh by the policy or through
+	// the API, and Lag the time since
+	LastRefresh *time.Time   `json:"last_refresh,omitempty"`
+	Lag         string       `json:"lag,omitempty"`
+	LastManual  *ViewRefresh `json:"last_manual_refresh,omitempty"`
+}
+
+// ContinuousAggregatePolicy is the job keeping a continuous aggregate up to
+// date. Offsets are relative to the time the job runs; an empty start
+// offset refreshes from the beginning.
+type ContinuousAggregatePolicy struct {
+	JobID            int64  `json:"job_id"`
+	StartOffset      string `json:"start_offset,omitempty"`
+	EndOffset        string `json:"end_offset,omitempty"`
+	ScheduleInterval string `json:"schedule_interval"`
+	Scheduled        bool   `json:"scheduled"`
+}
+
+// CaggRefreshRequest bounds a manual refresh. Start and End are RFC 3339
+// times, or integers for aggregates over integer time columns; an empty
+// bound leaves the window open on that side.
+type CaggRefreshRequest struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// GetContinuousAggregates lists the continuous aggregates of a schema with
+// their refresh policy and lag. Databases without TimescaleDB have none.
+func (h *Handler) GetContinuousAggregates(c *gin.Context) {
+	schema, ok := h.schemaParam(c)
+	if !ok {
+		return
+	}
+	caggs, err := h.dialect.ListContinuousAggregates(h.db, schema)
+	if errors.Is(err, errors.ErrUnsupported) {
+		c.JSON(http.StatusOK, gin.H{"schema": schema, "timescaledb": false, "continuous_aggregates": []ContinuousAggregateInfo{}})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	access := rbac.FromContext(c.Request.Context())
+	out := []ContinuousAggregateInfo{}
+	for _, cagg := range caggs {
+		if !access.CanTable(h.grantSchema(schema), cagg.Name) {
+			continue
+		}
+		var manual ViewRefresh
+		if err := h.meta.Get(caggRefreshPrefix+schema+"."+cagg.Name, &manual); err == nil {
+			cagg.LastManual = &manual
+			if cagg.LastRefresh == nil || manual.RefreshedAt.After(*cagg.LastRefresh) {
+				cagg.LastRefresh = &manual.RefreshedAt
+			}
+		}
+		if cagg.LastRefresh != nil {
+			cagg.Lag = time.Since(*cagg.LastRefresh).Round(time.Second).String()
+		}
+		out = append(out, cagg)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"schema": schema, "timescaledb": true, "continuous_aggregates": out})
+}
+
+// RefreshContinuousAggregate materializes a continuous aggregate over a
+// time window
+func (h *Handler) RefreshContinuousAggregate(c *gin.Context) {
+	schema, ok := h.schemaParam(c)
+	if !ok {
+		return
+	}
+	name := c.Param("name")
+	if !h.requireTable(c, schema, name) {
+		return
+	}
+	var req CaggRefreshRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		return
+	}
+	start, err := parseWindowBound(req.Start)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "start: " + err.Error()})
+		return
+	}
+	end, err := parseWindowBound(req.End)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "end: " + err.Error()})
+		return
+	}
+
+	caggs, err := h.dialect.ListContinuousAggregates(h.db, schema)
+	if errors.Is(err, errors.ErrUnsupported) {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "Continuous aggregates need the TimescaleDB extension"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	i := slices.IndexFunc(caggs, func(cagg ContinuousAggregateInfo) bool { return cagg.Name == name })
+	if i < 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Continuous aggregate not found"})
+		return
+	}
+	cagg := caggs[i]
+
+	ctx := c.Request.Context()
+	if h.cfg.Query.MaterializeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.cfg.Query.MaterializeTimeout)
+		defer cancel()
+	}
+	release, err := h.acquireStatement(ctx)
+	if err != nil {
+		respondQueryError(c, err)
+		return
+	}
+	defer release()
+
+	var inputs, outputs []lineage.Dataset
+	if h.lineage.Enabled() {
+		inputs = append(inputs, h.lineage.Dataset(cagg.HypertableSchema, cagg.HypertableName))
+		outputs = append(outputs, h.lineage.Dataset(schema, name))
+	}
+	run := h.lineage.StartRun("refresh."+schema+"."+name, "", inputs, outputs)
+
+	began := time.Now()
+	err = h.dialect.RefreshContinuousAggregate(ctx, h.db, schema, name, start, end)
+	run.Finish(err)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Refresh failed: " + err.Error()})
+		return
+	}
+
+	refresh := ViewRefresh{RefreshedAt: began, Duration: time.Since(began).String(), By: auth.Principal(c)}
+	if err := h.meta.Put(caggRefreshPrefix+schema+"."+name, refresh); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"schema":  schema,
+		"view":    name,
+		"start":   req.Start,
+		"end":     req.End,
+		"refresh": refresh,
+	})
+}
+
+// parseWindowBound returns nil for an open bound, an int64 for integer time
+// and a time.Time otherwise
+func parseWindowBound(s string) (any, error) {
+	if s == "" {
+		return nil, nil
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return n, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return nil, errors.New("expected an RFC 3339 time or an integer")
+	}
+	return t, nil
+}
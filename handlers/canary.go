@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+)
+
+// shouldCanary decides whether a rewritten query is sampled for a canary run
+func (h *Handler) shouldCanary() bool {
+	rate := h.cfg.Query.Canary.SampleRate
+	return rate > 0 && rand.Float64() < rate
+}
+
+// runCanary executes the original form of a rewritten query and compares its
+// results and latency with those of the rewritten form, logging any
+// discrepancy. The original is read at most one row past the rewritten
+// result's row cap so a canary never streams an unbounded result. ctx
+// carries the request's workspace but must outlive the request.
+func (h *Handler) runCanary(ctx context.Context, original, rewritten string, rewrittenRows []map[string]interface{}, rewrittenLatency time.Duration) {
+	timeout := h.cfg.Query.Timeout
+	if timeout <= 0 {
+		timeout = time.Minute
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// Canaries are optional; don't queue them behind user statements
+	if h.stmts != nil {
+		select {
+		case h.stmts <- struct{}{}:
+			defer func() { <-h.stmts }()
+		default:
+			return
+		}
+	}
+
+	rowCap := h.maxRows(ctx)
+	start := time.Now()
+	tx, end, err := h.dialect.BeginReadOnly(ctx, h.db)
+	if err != nil {
+		slog.Error("Canary: failed to start read-only transaction", "error", err)
+		return
+	}
+	defer end()
+
+	rows, err := tx.QueryContext(ctx, original)
+	if err != nil {
+		slog.Warn("Canary: original query failed while rewritten succeeded", "error", err, "original", original, "rewritten", rewritten)
+		return
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		slog.Error("Canary: failed to read columns", "error", err)
+		return
+	}
+
+	counts := map[string]int{}
+	originalRows := 0
+	for originalRows <= rowCap && rows.Next() {
+		vals := make([]interface{}, len(cols))
+		ptrs := make([]interface{}, len(cols))
+		for i := range vals {
+			ptrs[i] = &vals[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			slog.Error("Canary: row scan failed", "error", err)
+			return
+		}
+		rowMap := map[string]interface{}{}
+		for i, col := range cols {
+			rowMap[col] = vals[i]
+		}
+		counts[fmt.Sprint(rowMap)]++
+		originalRows++
+	}
+	originalLatency := time.Since(start)
+
+	var discrepancy string
+	switch {
+	case originalRows > rowCap:
+		// The rewrite truncated the result; it must have returned a full page
+		if len(rewrittenRows) != rowCap {
+			discrepancy = fmt.Sprintf("expected %d rows from truncated rewrite, got %d", rowCap, len(rewrittenRows))
+		}
+	case originalRows != len(rewrittenRows):
+		discrepancy = fmt.Sprintf("row count differs: original %d, rewritten %d", originalRows, len(rewrittenRows))
+	default:
+		for _, row := range rewrittenRows {
+			key := fmt.Sprint(row)
+			if counts[key] == 0 {
+				discrepancy = "rewritten result contains rows missing from the original"
+				break
+			}
+			counts[key]--
+		}
+	}
+
+	if discrepancy != "" {
+		slog.Warn("Canary discrepancy", "discrepancy", discrepancy, "original", original, "rewritten", rewritten)
+		return
+	}
+	slog.Debug("Canary ok", "original_ms", float64(originalLatency.Microseconds())/1000, "rewritten_ms", float64(rewrittenLatency.Microseconds())/1000, "rewritten", rewritten)
+}
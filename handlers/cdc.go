@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"time"
+
+	"sql-engine/cache"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	cdcConsumerPrefix = "cdc-consumer/"
+	// cdcKeepalive is the interval of the comments sent to idle change
+	// streams
+	cdcKeepalive = 15 * time.Second
+)
+
+var (
+	// cdcPosition matches the positions of changes: the commit LSN of the
+	// transaction in 16 hexadecimal digits and the change's index in it
+	cdcPosition     = regexp.MustCompile(`^[0-9A-F]{16}-[0-9]{6}$`)
+	cdcConsumerName = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+)
+
+// ChangeEvent is an insert, update, delete or truncate decoded from the
+// write-ahead log. Positions sort in the order changes were committed.
+type ChangeEvent struct {
+	Position    string `json:"position"`
+	Action      string `json:"action"`
+	Schema      string `json:"schema"`
+	Table       string `json:"table"`
+	CommittedAt string `json:"committed_at,omitempty"`
+	// Columns are the new values of inserted and updated rows
+	Columns map[string]interface{} `json:"columns,omitempty"`
+	// Identity is the replica identity (by default the primary key) of
+	// updated and deleted rows
+	Identity map[string]interface{} `json:"identity,omitempty"`
+}
+
+// CDCConsumer is a named reader of the change feed. The slot keeps the
+// changes after the position it last acknowledged while it keeps reading.
+type CDCConsumer struct {
+	Name     string    `json:"name"`
+	Position string    `json:"position"`
+	SeenAt   time.Time `json:"seen_at"`
+}
+
+// StartCDC has the leader release, every minute, the changes every named
+// consumer has acknowledged, or all of them when none reads, so that the
+// slot doesn't hold back the write-ahead log
+func (h *Handler) StartCDC() {
+	if !h.cfg.CDC.Enabled {
+		return
+	}
+	h.sched.Every("cdc-advance", time.Minute, func(ctx context.Context) {
+		h.advanceCDC(ctx, time.Now())
+	})
+}
+
+func (h *Handler) advanceCDC(ctx context.Context, now time.Time) {
+	keys, err := h.meta.List(cdcConsumerPrefix)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to list change feed consumers", "error", err)
+		return
+	}
+	position, consumers := "", 0
+	for _, key := range keys {
+		var consumer CDCConsumer
+		if err := h.meta.Get(key, &consumer); err != nil {
+			continue
+		}
+		if now.Sub(consumer.SeenAt) > h.cfg.CDC.ConsumerTTL {
+			slog.WarnContext(ctx, "Change feed consumer stopped reading; its changes are released", "consumer", consumer.Name, "position", consumer.Position)
+			h.meta.Delete(key)
+			continue
+		}
+		if consumers == 0 || consumer.Position < position {
+			position = consumer.Position
+		}
+		consumers++
+	}
+	if consumers > 0 && position == "" {
+		// A consumer hasn't read anything yet
+		return
+	}
+	cfg := h.cfg.CDC
+	if err := h.dialect.AdvanceSlot(ctx, h.db, cfg.Slot, cfg.Tables, position); err != nil {
+		slog.ErrorContext(ctx, "Failed to advance replication slot", "slot", cfg.Slot, "error", err)
+	}
+}
+
+// ackChanges records that a named consumer has handled the changes up to
+// position
+func (h *Handler) ackChanges(consumer, position string) {
+	if consumer == "" {
+		return
+	}
+	var prev CDCConsumer
+	if err := h.meta.Get(cdcConsumerPrefix+consumer, &prev); err == nil && position == "" {
+		position = prev.Position
+	}
+	c := CDCConsumer{Name: consumer, Position: position, SeenAt: time.Now()}
+	if err := h.meta.Put(cdcConsumerPrefix+consumer, c); err != nil {
+		slog.Error("Failed to record change feed consumer", "error", err)
+	}
+}
+
+// changeFeedParams checks that the feed is enabled and reads the consumer
+// and the position to resume after, writing an error response if invalid
+func (h *Handler) changeFeedParams(c *gin.Context, after string) (consumer string, ok bool) {
+	if !h.cfg.CDC.Enabled {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Change data capture is not enabled"})
+		return "", false
+	}
+	consumer = c.Query("consumer")
+	if consumer != "" && !cdcConsumerName.MatchString(consumer) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "consumer must be letters, digits, dots, dashes and underscores"})
+		return "", false
+	}
+	if after != "" && !cdcPosition.MatchString(after) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid position " + after})
+		return "", false
+	}
+	return consumer, true
+}
+
+// peekChanges reads the changes after a position, writing an error response
+// if it fails
+func (h *Handler) peekChanges(c *gin.Context, ctx context.Context, after string, limit int) ([]ChangeEvent, bool) {
+	cfg := h.cfg.CDC
+	changes, err := h.dialect.PeekChanges(ctx, h.db, cfg.Slot, cfg.Tables, after, limit)
+	switch {
+	case errors.Is(err, errors.ErrUnsupported):
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "Change data capture is not supported by this database"})
+		return nil, false
+	case err != nil && ctx.Err() == nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read changes: " + err.Error()})
+		return nil, false
+	case err != nil:
+		return nil, false
+	}
+	return changes, true
+}
+
+// GetChanges returns the changes to cdc.tables committed after ?after, up
+// to ?limit, with the cursor to pass as after next time. A named ?consumer
+// acknowledges the changes up to after, which the slot may then release.
+func (h *Handler) GetChanges(c *gin.Context) {
+	after := c.Query("after")
+	consumer, ok := h.changeFeedParams(c, after)
+	if !ok {
+		return
+	}
+	limit, ok := intQuery(c, "limit", h.cfg.CDC.BatchSize, h.cfg.CDC.BatchSize)
+	if !ok {
+		return
+	}
+	h.ackChanges(consumer, after)
+	changes, ok := h.peekChanges(c, c.Request.Context(), after, limit)
+	if !ok {
+		return
+	}
+	cursor := after
+	if len(changes) > 0 {
+		cursor = changes[len(changes)-1].Position
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"changes": changes,
+		"cursor":  cursor,
+		"more":    len(changes) >= limit,
+	})
+}
+
+// StreamChanges streams the changes to cdc.tables as server-sent events
+// named change, each with its position as ID, checking the slot every
+// cdc.poll_interval. Clients resume after Last-Event-ID (or ?after). For a
+// named ?consumer, the changes sent count as acknowledged.
+func (h *Handler) StreamChanges(c *gin.Context) {
+	after := c.GetHeader("Last-Event-ID")
+	if after == "" {
+		after = c.Query("after")
+	}
+	consumer, ok := h.changeFeedParams(c, after)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	h.ackChanges(consumer, after)
+	// The first read reports errors before the stream starts
+	changes, ok := h.peekChanges(c, ctx, after, h.cfg.CDC.BatchSize)
+	if !ok {
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("X-Accel-Buffering", "no")
+	cache.SetClass(c, cache.Streamed)
+	c.Status(http.StatusOK)
+	fmt.Fprintf(c.Writer, "retry: %d\n\n", notifyRetry)
+	poll := time.NewTicker(h.cfg.CDC.PollInterval)
+	defer poll.Stop()
+	idle := time.Now()
+	for {
+		for _, change := range changes {
+			data, _ := json.Marshal(change)
+			fmt.Fprintf(c.Writer, "id: %s\nevent: change\ndata: %s\n\n", change.Position, data)
+			after = change.Position
+		}
+		if len(changes) > 0 {
+			h.ackChanges(consumer, after)
+			idle = time.Now()
+		} else if time.Since(idle) >= cdcKeepalive {
+			fmt.Fprint(c.Writer, ": keepalive\n\n")
+			idle = time.Now()
+		}
+		c.Writer.Flush()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-poll.C:
+		}
+		cfg := h.cfg.CDC
+		var err error
+		changes, err = h.dialect.PeekChanges(ctx, h.db, cfg.Slot, cfg.Tables, after, cfg.BatchSize)
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.WarnContext(ctx, "Change stream stopped", "position", after, "error", err)
+			}
+			return
+		}
+	}
+}
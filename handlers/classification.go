@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"sql-engine/pii"
+	"sql-engine/rbac"
+	"sql-engine/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+const classificationPrefix = "classification/"
+
+// classificationTTL is how long the categories of a table are kept for
+// masking before the store is read again, so that scans on other replicas
+// take effect
+const classificationTTL = time.Minute
+
+// classifiedColumns caches the categories of scanned tables, as masks are
+// looked up for each column of every query
+type classifiedColumns struct {
+	mu     sync.Mutex
+	tables map[string]classifiedTable
+}
+
+type classifiedTable struct {
+	categories map[string]string
+	loaded     time.Time
+}
+
+// TableClassification holds the personal-data findings for a table's columns
+type TableClassification struct {
+	Table     string                 `json:"table"`
+	Columns   map[string]pii.Finding `json:"columns"`
+	ScannedAt time.Time              `json:"scanned_at"`
+}
+
+// ScanPII samples table data and records which columns likely hold personal
+// data. A single table can be selected with ?table=, otherwise every table is
+// scanned.
+func (h *Handler) ScanPII(c *gin.Context) {
+	var tables []string
+	if name := c.Query("table"); name != "" {
+		tables = []string{name}
+	} else {
+		infos, err := h.dialect.ListTables(h.db, "")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		for _, t := range infos {
+			tables = append(tables, t.Name)
+		}
+	}
+
+	var results []TableClassification
+	scanErrors := map[string]string{}
+	for _, table := range tables {
+		result, err := h.scanTable(table)
+		if err != nil {
+			scanErrors[table] = err.Error()
+			continue
+		}
+		if err := h.meta.Put(classificationPrefix+table, result); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store classification: " + err.Error()})
+			return
+		}
+		h.classified.forget(table)
+		results = append(results, result)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"classifications": results,
+		"errors":          scanErrors,
+	})
+}
+
+func (h *Handler) GetClassifications(c *gin.Context) {
+	keys, err := h.meta.List(classificationPrefix)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	access := rbac.FromContext(c.Request.Context())
+	var results []TableClassification
+	for _, key := range keys {
+		var tc TableClassification
+		if err := h.meta.Get(key, &tc); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if access.CanTable("", tc.Table) {
+			results = append(results, tc)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"classifications": results})
+}
+
+// classification returns the stored findings for a table, if it was scanned
+func (h *Handler) classification(table string) (TableClassification, bool) {
+	var tc TableClassification
+	if err := h.meta.Get(classificationPrefix+table, &tc); err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			slog.Error("Failed to load classification", "table", table, "error", err)
+		}
+		return tc, false
+	}
+	return tc, true
+}
+
+// Categories implements masking.Classifications with the stored findings of
+// the tables of the default schema. When the store can't be read the
+// categories last read are kept.
+func (h *Handler) Categories(schema, table string) map[string]string {
+	if !h.isDefaultSchema(schema) {
+		return nil
+	}
+	h.classified.mu.Lock()
+	defer h.classified.mu.Unlock()
+	cached, ok := h.classified.tables[table]
+	if ok && time.Since(cached.loaded) < classificationTTL {
+		return cached.categories
+	}
+
+	var tc TableClassification
+	err := h.meta.Get(classificationPrefix+table, &tc)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		slog.Error("Failed to load classification for masking", "table", table, "error", err)
+		return cached.categories
+	}
+	categories := map[string]string{}
+	for col, f := range tc.Columns {
+		categories[strings.ToLower(col)] = f.Category
+	}
+	if h.classified.tables == nil {
+		h.classified.tables = map[string]classifiedTable{}
+	}
+	h.classified.tables[table] = classifiedTable{categories: categories, loaded: time.Now()}
+	return categories
+}
+
+// forget drops the cached categories of a table after it was scanned
+func (c *classifiedColumns) forget(table string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.tables, table)
+}
+
+func (h *Handler) scanTable(table string) (TableClassification, error) {
+	result := TableClassification{
+		Table:     table,
+		Columns:   map[string]pii.Finding{},
+		ScannedAt: time.Now(),
+	}
+
+	columns, err := h.dialect.ListColumns(h.db, "", table)
+	if err != nil {
+		return result, err
+	}
+	if len(columns) == 0 {
+		return result, fmt.Errorf("table %s not found", table)
+	}
+
+	quoted := make([]string, len(columns))
+	for i, col := range columns {
+		quoted[i] = h.dialect.Quote(col.Name)
+	}
+
+	rows, err := h.db.Query(fmt.Sprintf("SELECT %s FROM %s %s",
+		strings.Join(quoted, ", "), h.dialect.Quote(table), h.dialect.LimitClause(h.cfg.PII.SampleRows)))
+	if err != nil {
+		return result, err
+	}
+	defer rows.Close()
+
+	samples := make([][]string, len(columns))
+	for rows.Next() {
+		vals := make([]interface{}, len(columns))
+		ptrs := make([]interface{}, len(columns))
+		for i := range vals {
+			ptrs[i] = &vals[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return result, err
+		}
+
+		for i, v := range vals {
+			switch v := v.(type) {
+			case nil:
+			case []byte:
+				samples[i] = append(samples[i], string(v))
+			case string:
+				samples[i] = append(samples[i], v)
+			default:
+				samples[i] = append(samples[i], fmt.Sprint(v))
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return result, err
+	}
+
+	for i, col := range columns {
+		if finding, ok := pii.Classify(col.Name, samples[i], h.cfg.PII.MatchThreshold); ok {
+			result.Columns[col.Name] = finding
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CommentRequest sets a table or column comment; an empty comment removes it
+type CommentRequest struct {
+	Comment string `json:"comment"`
+}
+
+// SetTableComment stores the comment of a table in the database
+func (h *Handler) SetTableComment(c *gin.Context) {
+	h.setComment(c, "")
+}
+
+// SetColumnComment stores the comment of a column in the database
+func (h *Handler) SetColumnComment(c *gin.Context) {
+	h.setComment(c, c.Param("column"))
+}
+
+func (h *Handler) setComment(c *gin.Context, column string) {
+	schema, ok := h.schemaParam(c)
+	if !ok {
+		return
+	}
+	tableName := c.Param("name")
+	if !h.requireTable(c, schema, tableName) {
+		return
+	}
+	var req CommentRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		return
+	}
+
+	columns, err := h.dialect.ListColumns(h.db, schema, tableName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(columns) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Table not found"})
+		return
+	}
+	if column != "" && !slices.ContainsFunc(columns, func(col ColumnInfo) bool { return col.Name == column }) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Column not found"})
+		return
+	}
+
+	err = h.dialect.SetComment(c.Request.Context(), h.db, schema, tableName, column, req.Comment)
+	if errors.Is(err, errors.ErrUnsupported) {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "Comments are not supported by this database"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	go func() {
+		if _, err := h.reloadSchema(schema); err != nil {
+			slog.ErrorContext(c.Request.Context(), "Schema refresh after comment change failed", "error", err)
+		}
+	}()
+
+	resp := gin.H{"schema": schema, "table_name": tableName, "comment": req.Comment}
+	if column != "" {
+		resp["column"] = column
+	}
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"net/http"
+
+	"sql-engine/rbac"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ConstraintInfo describes a CHECK, UNIQUE or exclusion constraint
+type ConstraintInfo struct {
+	Name string `json:"name"`
+	// Type is CHECK, UNIQUE or EXCLUDE
+	Type    string   `json:"type"`
+	Columns []string `json:"columns"`
+	// Expression is the condition of a CHECK constraint
+	Expression string `json:"expression,omitempty"`
+	Definition string `json:"definition"`
+}
+
+func (h *Handler) GetTableConstraints(c *gin.Context) {
+	schema, ok := h.schemaParam(c)
+	if !ok {
+		return
+	}
+	tableName := c.Param("name")
+	if !h.requireTable(c, schema, tableName) {
+		return
+	}
+
+	constraints, err := h.dialect.ListConstraints(h.db, schema, tableName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	constraints = filterConstraints(rbac.FromContext(c.Request.Context()), h.grantSchema(schema), tableName, constraints)
+	if constraints == nil {
+		constraints = []ConstraintInfo{}
+	}
+
+	h.render(c, gin.H{
+		"schema":      schema,
+		"table_name":  tableName,
+		"constraints": constraints,
+	}, nil)
+}
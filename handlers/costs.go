@@ -0,0 +1,352 @@
+package handlers
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"sql-engine/auth"
+	"sql-engine/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	usagePrefix = "usage/"
+	// usageFlushEvery is how often each replica adds the usage it recorded
+	// to the metadata store
+	usageFlushEvery = time.Minute
+	usageDayFormat  = "2006-01-02"
+)
+
+// UsageTotals is the database work done for a caller
+type UsageTotals struct {
+	Queries int64   `json:"queries"`
+	Seconds float64 `json:"seconds"`
+	Rows    int64   `json:"rows"`
+	Bytes   int64   `json:"bytes"`
+}
+
+func (t *UsageTotals) add(o UsageTotals) {
+	t.Queries += o.Queries
+	t.Seconds += o.Seconds
+	t.Rows += o.Rows
+	t.Bytes += o.Bytes
+}
+
+// UsageEntry is the usage of one principal in one workspace on a day
+type UsageEntry struct {
+	Principal string `json:"principal"`
+	Workspace string `json:"workspace,omitempty"`
+	UsageTotals
+}
+
+// usageBucket identifies the entry a request's usage is added to
+type usageBucket struct {
+	day, principal, workspace string
+}
+
+// usageLedger holds the usage recorded since the last flush
+type usageLedger struct {
+	mu      sync.Mutex
+	pending map[usageBucket]*UsageTotals
+}
+
+func (l *usageLedger) add(b usageBucket, t UsageTotals) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.pending == nil {
+		l.pending = map[usageBucket]*UsageTotals{}
+	}
+	if l.pending[b] == nil {
+		l.pending[b] = &UsageTotals{}
+	}
+	l.pending[b].add(t)
+}
+
+// usageMeter accumulates the statements run for one request
+type usageMeter struct {
+	statements atomic.Int64
+	rows       atomic.Int64
+	nanos      atomic.Int64
+}
+
+type usageMeterKey struct{}
+
+// meterStatement records a statement run for the request of ctx, if its
+// usage is metered
+func meterStatement(ctx context.Context, elapsed time.Duration, rows int) {
+	if m, ok := ctx.Value(usageMeterKey{}).(*usageMeter); ok {
+		m.statements.Add(1)
+		m.rows.Add(int64(rows))
+		m.nanos.Add(int64(elapsed))
+	}
+}
+
+// MeterUsage records the statements each request runs, with the bytes of its
+// response, against the caller and workspace when costs are configured. It
+// must run after authentication and ApplyWorkspace.
+func (h *Handler) MeterUsage() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !h.cfg.Costs.Enabled() {
+			c.Next()
+			return
+		}
+		m := &usageMeter{}
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), usageMeterKey{}, m))
+		// Deferred so that aborted streams are still counted
+		defer func() {
+			if m.statements.Load() == 0 {
+				return
+			}
+			b := usageBucket{day: time.Now().UTC().Format(usageDayFormat), principal: auth.Principal(c)}
+			if b.principal == "" {
+				b.principal = "anonymous"
+			}
+			if ws := workspaceFrom(c.Request.Context()); ws != nil {
+				b.workspace = ws.Name
+			}
+			h.usage.add(b, UsageTotals{
+				Queries: m.statements.Load(),
+				Seconds: time.Duration(m.nanos.Load()).Seconds(),
+				Rows:    m.rows.Load(),
+				Bytes:   int64(max(c.Writer.Size(), 0)),
+			})
+		}()
+		c.Next()
+	}
+}
+
+// StartUsageFlush periodically adds the usage recorded by this replica to
+// the metadata store and deletes days past the retention. It runs on every
+// replica, as each records its own requests.
+func (h *Handler) StartUsageFlush() {
+	if !h.cfg.Costs.Enabled() {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(usageFlushEvery)
+		defer ticker.Stop()
+		for range ticker.C {
+			h.flushUsage()
+			h.pruneUsage()
+		}
+	}()
+}
+
+// usageKey is where a replica's usage of a day is stored; replicas write
+// separate keys so they never overwrite each other
+func (h *Handler) usageKey(day string) string {
+	return usagePrefix + day + "/" + h.cfg.Server.ReplicaID
+}
+
+func (h *Handler) flushUsage() {
+	h.usage.mu.Lock()
+	pending := h.usage.pending
+	h.usage.pending = nil
+	h.usage.mu.Unlock()
+
+	days := map[string][]usageBucket{}
+	for b := range pending {
+		days[b.day] = append(days[b.day], b)
+	}
+	for day, buckets := range days {
+		var entries []UsageEntry
+		err := h.meta.Get(h.usageKey(day), &entries)
+		if err == nil || errors.Is(err, store.ErrNotFound) {
+			for _, b := range buckets {
+				entries = addUsage(entries, UsageEntry{Principal: b.principal, Workspace: b.workspace, UsageTotals: *pending[b]})
+			}
+			err = h.meta.Put(h.usageKey(day), entries)
+		}
+		if err != nil {
+			// Kept for the next flush rather than lost
+			slog.Error("Failed to store usage", "error", err)
+			for _, b := range buckets {
+				h.usage.add(b, *pending[b])
+			}
+		}
+	}
+}
+
+func (h *Handler) pruneUsage() {
+	if h.cfg.Costs.Retention == 0 {
+		return
+	}
+	keys, err := h.meta.List(usagePrefix)
+	if err != nil {
+		slog.Error("Failed to list usage", "error", err)
+		return
+	}
+	oldest := time.Now().UTC().Add(-h.cfg.Costs.Retention).Format(usageDayFormat)
+	for _, key := range keys {
+		day, _, _ := strings.Cut(strings.TrimPrefix(key, usagePrefix), "/")
+		if day < oldest {
+			if err := h.meta.Delete(key); err != nil && !errors.Is(err, store.ErrNotFound) {
+				slog.Error("Failed to delete usage", "error", err)
+			}
+		}
+	}
+}
+
+// addUsage adds e to the entry of the same principal and workspace
+func addUsage(entries []UsageEntry, e UsageEntry) []UsageEntry {
+	for i := range entries {
+		if entries[i].Principal == e.Principal && entries[i].Workspace == e.Workspace {
+			entries[i].add(e.UsageTotals)
+			return entries
+		}
+	}
+	return append(entries, e)
+}
+
+// CostLine is the usage and cost of one group of a cost report
+type CostLine struct {
+	Group string `json:"group"`
+	UsageTotals
+	Cost float64 `json:"cost"`
+}
+
+// GetCosts reports the usage and cost of each user, or each workspace with
+// ?group_by=workspace, between ?from and ?to (dates, both included; the
+// current month by default). ?format=csv downloads the report.
+func (h *Handler) GetCosts(c *gin.Context) {
+	if !h.cfg.Costs.Enabled() {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Cost attribution is not configured"})
+		return
+	}
+	now := time.Now().UTC()
+	from := now.Format("2006-01") + "-01"
+	to := now.Format(usageDayFormat)
+	for _, p := range []struct {
+		name  string
+		value *string
+	}{{"from", &from}, {"to", &to}} {
+		if v := c.Query(p.name); v != "" {
+			if _, err := time.Parse(usageDayFormat, v); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": p.name + " must be a date (YYYY-MM-DD)"})
+				return
+			}
+			*p.value = v
+		}
+	}
+	if from > to {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must not be after to"})
+		return
+	}
+	groupBy := c.DefaultQuery("group_by", "user")
+	if groupBy != "user" && groupBy != "workspace" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "group_by must be user or workspace"})
+		return
+	}
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "csv" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be json or csv"})
+		return
+	}
+
+	keys, err := h.meta.List(usagePrefix)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	groups := map[string]*UsageTotals{}
+	add := func(principal, workspace string, t UsageTotals) {
+		group := principal
+		if groupBy == "workspace" {
+			group = workspace
+		}
+		if groups[group] == nil {
+			groups[group] = &UsageTotals{}
+		}
+		groups[group].add(t)
+	}
+	for _, key := range keys {
+		day, _, _ := strings.Cut(strings.TrimPrefix(key, usagePrefix), "/")
+		if day < from || day > to {
+			continue
+		}
+		var entries []UsageEntry
+		if err := h.meta.Get(key, &entries); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		for _, e := range entries {
+			add(e.Principal, e.Workspace, e.UsageTotals)
+		}
+	}
+	// Usage this replica has not flushed yet
+	h.usage.mu.Lock()
+	for b, t := range h.usage.pending {
+		if b.day >= from && b.day <= to {
+			add(b.principal, b.workspace, *t)
+		}
+	}
+	h.usage.mu.Unlock()
+
+	lines := []CostLine{}
+	total := CostLine{Group: "total"}
+	for group, t := range groups {
+		t.Seconds = math.Round(t.Seconds*1e6) / 1e6
+		lines = append(lines, CostLine{Group: group, UsageTotals: *t, Cost: h.cost(*t)})
+		total.add(*t)
+	}
+	total.Seconds = math.Round(total.Seconds*1e6) / 1e6
+	total.Cost = h.cost(total.UsageTotals)
+	sort.Slice(lines, func(i, j int) bool {
+		if lines[i].Cost != lines[j].Cost {
+			return lines[i].Cost > lines[j].Cost
+		}
+		return lines[i].Group < lines[j].Group
+	})
+
+	if format == "csv" {
+		c.Header("Content-Disposition", `attachment; filename="costs-`+from+`-`+to+`.csv"`)
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		c.Status(http.StatusOK)
+		w := csv.NewWriter(c.Writer)
+		w.Write([]string{groupBy, "queries", "seconds", "rows", "bytes", "cost", "currency"})
+		for _, l := range append(lines, total) {
+			w.Write([]string{
+				l.Group,
+				strconv.FormatInt(l.Queries, 10),
+				strconv.FormatFloat(l.Seconds, 'f', 3, 64),
+				strconv.FormatInt(l.Rows, 10),
+				strconv.FormatInt(l.Bytes, 10),
+				strconv.FormatFloat(l.Cost, 'f', -1, 64),
+				h.cfg.Costs.Currency,
+			})
+		}
+		w.Flush()
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"from":     from,
+		"to":       to,
+		"group_by": groupBy,
+		"currency": h.cfg.Costs.Currency,
+		"prices":   h.cfg.Costs,
+		"lines":    lines,
+		"total":    total,
+	})
+}
+
+// cost prices usage with the configured cost model, rounded to a millionth
+// of the currency unit
+func (h *Handler) cost(t UsageTotals) float64 {
+	p := h.cfg.Costs
+	cost := float64(t.Queries)*p.PerQuery +
+		t.Seconds*p.PerSecond +
+		float64(t.Rows)/1e6*p.PerMillionRows +
+		float64(t.Bytes)/1e9*p.PerGB
+	return math.Round(cost*1e6) / 1e6
+}
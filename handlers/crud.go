@@ -0,0 +1,644 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"sql-engine/rbac"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// originalMember holds, in the body of an update or delete, the values
+	// of the row as the client last read it
+	originalMember = "$original"
+	// rowVersionColumn names the row version in returned rows
+	rowVersionColumn = "_version"
+)
+
+// writeTarget is a table a write request was checked against
+type writeTarget struct {
+	Schema, Table string
+	Columns       []ColumnInfo
+	KeyColumns    []string
+	// Returning lists the columns the caller may read back
+	Returning []string
+	// Versioned adds the row version to the rows read back
+	Versioned bool
+}
+
+// InsertRows inserts a JSON object, or an array of them, into a table in
+// one transaction and returns the inserted rows
+func (h *Handler) InsertRows(c *gin.Context) {
+	t, ok := h.writableTable(c)
+	if !ok {
+		return
+	}
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read body: " + err.Error()})
+		return
+	}
+	var objects []map[string]interface{}
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '{' {
+		var obj map[string]interface{}
+		err = decodeJSONNumbers(trimmed, &obj)
+		objects = append(objects, obj)
+	} else {
+		err = decodeJSONNumbers(trimmed, &objects)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON: expected an object or an array of objects"})
+		return
+	}
+	if len(objects) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No rows to insert"})
+		return
+	}
+	if len(objects) > h.cfg.Writes.MaxRows {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("At most %d rows may be inserted at once", h.cfg.Writes.MaxRows)})
+		return
+	}
+
+	q := h.dialect.Quote
+	var inserted []map[string]interface{}
+	retries, ok := h.writeTx(c, func(ctx context.Context, tx *sql.Tx) error {
+		inserted = []map[string]interface{}{}
+		for i, obj := range objects {
+			cols, args, err := h.writeValues(c, t, obj)
+			if err != nil {
+				return &QueryError{Status: http.StatusBadRequest, Message: fmt.Sprintf("row %d: %s", i+1, err)}
+			}
+			quoted := make([]string, len(cols))
+			marks := make([]string, len(cols))
+			for j, col := range cols {
+				quoted[j] = q(col)
+				marks[j] = h.dialect.Placeholder(j + 1)
+			}
+			stmt := fmt.Sprintf("INSERT INTO %s.%s (%s) VALUES (%s)", q(t.Schema), q(t.Table), strings.Join(quoted, ", "), strings.Join(marks, ", "))
+			if len(cols) == 0 {
+				stmt = fmt.Sprintf("INSERT INTO %s.%s DEFAULT VALUES", q(t.Schema), q(t.Table))
+			}
+			rows, err := h.execWrite(ctx, tx, t, stmt, args)
+			if err != nil {
+				return &QueryError{Status: http.StatusBadRequest, Message: fmt.Sprintf("row %d: Insert failed: %s", i+1, err), Err: err}
+			}
+			inserted = append(inserted, rows...)
+		}
+		return nil
+	})
+	if !ok {
+		return
+	}
+	resp := gin.H{"inserted": len(objects), "rows": h.maskRows(c, t, inserted)}
+	if retries > 0 {
+		resp["retries"] = retries
+	}
+	c.JSON(http.StatusCreated, resp)
+}
+
+// UpdateRow sets the columns in the JSON object of the body on the row whose
+// primary key is given as for DownloadBlob, and returns the updated row.
+// For optimistic concurrency, the body may hold the values the client last
+// read as $original, and If-Match the row version it read as _version; if
+// the row changed since, nothing is written and 409 returns the current row.
+func (h *Handler) UpdateRow(c *gin.Context) {
+	t, ok := h.writableTable(c)
+	if !ok {
+		return
+	}
+	var obj map[string]interface{}
+	body, err := io.ReadAll(c.Request.Body)
+	if err == nil {
+		err = decodeJSONNumbers(body, &obj)
+	}
+	if err != nil || obj == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON: expected an object"})
+		return
+	}
+	original, ok := originalValues(c, obj)
+	if !ok {
+		return
+	}
+	cols, args, err := h.writeValues(c, t, obj)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(cols) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No columns to update"})
+		return
+	}
+	q := h.dialect.Quote
+	sets := make([]string, len(cols))
+	for i, col := range cols {
+		sets[i] = q(col) + " = " + h.dialect.Placeholder(i+1)
+	}
+	where, args, ok := h.keyCondition(c, t.KeyColumns, args)
+	if !ok {
+		return
+	}
+	checks, args, ok := h.rowPreconditions(c, t, original, args)
+	if !ok {
+		return
+	}
+
+	var rows []map[string]interface{}
+	retries, ok := h.writeTx(c, func(ctx context.Context, tx *sql.Tx) error {
+		var err error
+		rows, err = h.execWrite(ctx, tx, t, fmt.Sprintf("UPDATE %s.%s SET %s WHERE %s%s",
+			q(t.Schema), q(t.Table), strings.Join(sets, ", "), where, checks), args)
+		if errors.Is(err, sql.ErrNoRows) {
+			return h.rowConflict(ctx, c, tx, t, checks != "")
+		}
+		if err != nil {
+			return &QueryError{Status: http.StatusBadRequest, Message: "Update failed: " + err.Error(), Err: err}
+		}
+		return nil
+	})
+	if !ok {
+		return
+	}
+	resp := gin.H{"updated": 1}
+	if retries > 0 {
+		resp["retries"] = retries
+	}
+	if rows = h.maskRows(c, t, rows); len(rows) > 0 {
+		resp["row"] = rows[0]
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// DeleteRow deletes the row whose primary key is given as for DownloadBlob.
+// The body may hold $original and If-Match be set as for UpdateRow.
+func (h *Handler) DeleteRow(c *gin.Context) {
+	t, ok := h.writableTable(c)
+	if !ok {
+		return
+	}
+	var original map[string]interface{}
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read body: " + err.Error()})
+		return
+	}
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 {
+		var obj map[string]interface{}
+		if err := decodeJSONNumbers(trimmed, &obj); err != nil || obj == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON: expected an object"})
+			return
+		}
+		if original, ok = originalValues(c, obj); !ok {
+			return
+		}
+		if len(obj) > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Only " + originalMember + " is accepted in the body"})
+			return
+		}
+	}
+	where, args, ok := h.keyCondition(c, t.KeyColumns, nil)
+	if !ok {
+		return
+	}
+	checks, args, ok := h.rowPreconditions(c, t, original, args)
+	if !ok {
+		return
+	}
+	q := h.dialect.Quote
+	// Nothing is read back from a deleted row
+	deleted := *t
+	deleted.Returning = nil
+	if _, ok := h.writeTx(c, func(ctx context.Context, tx *sql.Tx) error {
+		_, err := h.execWrite(ctx, tx, &deleted, fmt.Sprintf("DELETE FROM %s.%s WHERE %s%s", q(t.Schema), q(t.Table), where, checks), args)
+		if errors.Is(err, sql.ErrNoRows) {
+			return h.rowConflict(ctx, c, tx, t, checks != "")
+		}
+		if err != nil {
+			return &QueryError{Status: http.StatusBadRequest, Message: "Delete failed: " + err.Error(), Err: err}
+		}
+		return nil
+	}); !ok {
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// originalValues takes $original out of the body of a write, writing an
+// error response if it is not an object
+func originalValues(c *gin.Context, obj map[string]interface{}) (map[string]interface{}, bool) {
+	v, ok := obj[originalMember]
+	if !ok {
+		return nil, true
+	}
+	delete(obj, originalMember)
+	original, ok := v.(map[string]interface{})
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": originalMember + " must be an object"})
+		return nil, false
+	}
+	return original, true
+}
+
+// rowPreconditions builds the conditions a write adds to its WHERE clause
+// so that it only applies to the row as the client last read it: the
+// version sent in If-Match, and the values in original, each compared
+// null-safely. The values are bound after args.
+func (h *Handler) rowPreconditions(c *gin.Context, t *writeTarget, original map[string]interface{}, args []interface{}) (string, []interface{}, bool) {
+	var conds []string
+	if version := strings.Trim(c.GetHeader("If-Match"), `"`); version != "" && version != "*" {
+		expr := h.dialect.RowVersion()
+		if expr == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": h.dialect.ProductName() + " has no row versions, send " + originalMember + " instead of If-Match"})
+			return "", nil, false
+		}
+		args = append(args, version)
+		conds = append(conds, expr+" = "+h.dialect.Placeholder(len(args)))
+	}
+	for _, name := range slices.Sorted(maps.Keys(original)) {
+		// Comparing a column tells about its value, so it must be readable
+		// unmasked
+		if !h.analysisColumn(c, t.Schema, t.Table, t.Columns, originalMember, name) {
+			return "", nil, false
+		}
+		col := t.Columns[slices.IndexFunc(t.Columns, func(ci ColumnInfo) bool { return ci.Name == name })]
+		if strings.Contains(strings.ToLower(col.DataType), "json") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": originalMember + ": json column " + name + " cannot be compared"})
+			return "", nil, false
+		}
+		var v interface{}
+		if original[name] != nil {
+			var err error
+			if v, err = columnValue(col, original[name]); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": originalMember + ": column " + name + " " + err.Error()})
+				return "", nil, false
+			}
+		}
+		args = append(args, v)
+		conds = append(conds, h.dialect.Quote(name)+" IS NOT DISTINCT FROM "+h.dialect.Placeholder(len(args)))
+	}
+	if len(conds) == 0 {
+		return "", args, true
+	}
+	return " AND " + strings.Join(conds, " AND "), args, true
+}
+
+// rowConflict explains why a write matched no row: without preconditions
+// the row doesn't exist, otherwise it may have changed since the client
+// read it, in which case the error holds the current row
+func (h *Handler) rowConflict(ctx context.Context, c *gin.Context, tx *sql.Tx, t *writeTarget, checked bool) error {
+	notFound := &QueryError{Status: http.StatusNotFound, Message: "Row not found"}
+	if !checked {
+		return notFound
+	}
+	where, args, _ := h.keyCondition(c, t.KeyColumns, nil)
+	selects := []string{"1 AS " + h.dialect.Quote("exists")}
+	if len(t.Returning) > 0 {
+		selects = h.returningColumns(t)
+	}
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM %s.%s WHERE %s",
+		strings.Join(selects, ", "), h.dialect.Quote(t.Schema), h.dialect.Quote(t.Table), where), args...)
+	if err != nil {
+		return &QueryError{Status: http.StatusInternalServerError, Message: "Reading the current row failed: " + err.Error(), Err: err}
+	}
+	defer rows.Close()
+	_, current, err := scanRowMaps(rows)
+	if err != nil {
+		return &QueryError{Status: http.StatusInternalServerError, Message: err.Error(), Err: err}
+	}
+	if len(current) == 0 {
+		return notFound
+	}
+	details := gin.H{}
+	if len(t.Returning) > 0 {
+		details["row"] = h.maskRows(c, t, current)[0]
+	}
+	return &QueryError{Status: http.StatusConflict, Message: "Row was changed by someone else meanwhile", Details: details}
+}
+
+// writableTable checks that writes are enabled for the table of the request
+// and that the caller may write it, and introspects it. Only tables with a
+// primary key can be written, so that every row can be addressed.
+func (h *Handler) writableTable(c *gin.Context) (*writeTarget, bool) {
+	if !h.cfg.Writes.Enabled {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Writes are disabled"})
+		return nil, false
+	}
+	schema, ok := h.schemaParam(c)
+	if !ok {
+		return nil, false
+	}
+	tableName := c.Param("name")
+	qualified := orDefault(h.dialect, schema) + "." + tableName
+	allowed := len(h.cfg.Writes.Tables) == 0
+	for _, pattern := range h.cfg.Writes.Tables {
+		if ok, _ := path.Match(pattern, qualified); ok {
+			allowed = true
+		}
+	}
+	if !allowed || matchesAny(h.cfg.Query.Denylist.Tables, tableName) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Denied: table " + tableName + " is not writable"})
+		return nil, false
+	}
+	access := rbac.FromContext(c.Request.Context())
+	grantSchema := h.grantSchema(schema)
+	if !access.CanWrite(grantSchema, tableName, "") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied: table " + tableName + " is not granted for writing"})
+		return nil, false
+	}
+
+	columns, err := h.dialect.ListColumns(h.db, schema, tableName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if len(columns) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Table not found"})
+		return nil, false
+	}
+	keyColumns, err := h.dialect.ListPrimaryKeys(h.db, schema, tableName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if len(keyColumns) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Table has no primary key"})
+		return nil, false
+	}
+	t := &writeTarget{Schema: schema, Table: tableName, Columns: columns, KeyColumns: keyColumns, Versioned: h.dialect.RowVersion() != ""}
+	for _, col := range filterColumns(access, grantSchema, tableName, columns) {
+		t.Returning = append(t.Returning, col.Name)
+	}
+	return t, true
+}
+
+// writeValues checks the columns of a JSON object against the table and
+// converts the values to their column types. Columns are returned sorted,
+// so that statements are built the same way for every row.
+func (h *Handler) writeValues(c *gin.Context, t *writeTarget, obj map[string]interface{}) ([]string, []interface{}, error) {
+	access := rbac.FromContext(c.Request.Context())
+	grantSchema := h.grantSchema(t.Schema)
+	var cols []string
+	for col := range obj {
+		cols = append(cols, col)
+	}
+	slices.Sort(cols)
+	args := make([]interface{}, len(cols))
+	for i, name := range cols {
+		j := slices.IndexFunc(t.Columns, func(ci ColumnInfo) bool { return ci.Name == name })
+		if j < 0 {
+			return nil, nil, fmt.Errorf("column %s not found", name)
+		}
+		if !access.CanWrite(grantSchema, t.Table, name) {
+			return nil, nil, fmt.Errorf("column %s is not granted for writing", name)
+		}
+		v, err := columnValue(t.Columns[j], obj[name])
+		if err != nil {
+			return nil, nil, fmt.Errorf("column %s: %w", name, err)
+		}
+		args[i] = v
+	}
+	return cols, args, nil
+}
+
+// columnValue converts a JSON value, decoded with json.Number, to a value
+// the driver accepts for a column, rejecting values of the wrong type
+func columnValue(col ColumnInfo, v interface{}) (interface{}, error) {
+	if v == nil {
+		if col.IsNullable == "NO" {
+			return nil, errors.New("cannot be null")
+		}
+		return nil, nil
+	}
+	t := strings.ToLower(col.DataType)
+	if _, ok := v.(map[string]interface{}); ok && !strings.Contains(t, "json") {
+		return nil, errors.New("objects are only accepted for json columns")
+	}
+	if _, ok := v.([]interface{}); ok && !strings.Contains(t, "json") {
+		return nil, errors.New("arrays are only accepted for json columns")
+	}
+	s, isString := v.(string)
+	n, isNumber := v.(json.Number)
+	switch {
+	case len(col.EnumLabels) > 0:
+		if !isString || !slices.Contains(col.EnumLabels, s) {
+			return nil, fmt.Errorf("must be one of %s", strings.Join(col.EnumLabels, ", "))
+		}
+		return s, nil
+	case strings.Contains(t, "json"):
+		b, err := json.Marshal(v)
+		return string(b), err
+	case strings.Contains(t, "bool"):
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+		return nil, errors.New("must be a boolean")
+	case t == "bytea" || t == "blob":
+		if !isString {
+			return nil, errors.New("must be a base64 string")
+		}
+		b, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, errors.New("must be a base64 string")
+		}
+		return b, nil
+	case strings.Contains(t, "int") && !strings.Contains(t, "interval") && !strings.Contains(t, "point"):
+		if isNumber {
+			s = n.String()
+		} else if !isString {
+			return nil, errors.New("must be an integer")
+		}
+		i, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, errors.New("must be an integer")
+		}
+		return i, nil
+	case isNumericType(t):
+		if isNumber {
+			s = n.String()
+		} else if !isString {
+			return nil, errors.New("must be a number")
+		}
+		// Passed as text so that numeric columns keep every digit
+		if _, err := strconv.ParseFloat(s, 64); err != nil {
+			return nil, errors.New("must be a number")
+		}
+		return s, nil
+	case t == "":
+		// SQLite columns without a declared type take any scalar
+		if isNumber {
+			if i, err := n.Int64(); err == nil {
+				return i, nil
+			}
+			return n.Float64()
+		}
+		return v, nil
+	}
+	if isNumber {
+		s = n.String()
+	} else if b, ok := v.(bool); ok {
+		s = strconv.FormatBool(b)
+	}
+	if col.MaxLength != nil && utf8.RuneCountInString(s) > *col.MaxLength {
+		return nil, fmt.Errorf("is longer than %d characters", *col.MaxLength)
+	}
+	return s, nil
+}
+
+// keyCondition builds the WHERE condition selecting a row by its primary
+// key: ?key for single-column keys, otherwise ?key.<column> for each key
+// column. The values are bound after args.
+func (h *Handler) keyCondition(c *gin.Context, keyColumns []string, args []interface{}) (string, []interface{}, bool) {
+	var conds []string
+	for _, col := range keyColumns {
+		v, ok := c.GetQuery("key." + col)
+		if !ok && len(keyColumns) == 1 {
+			v, ok = c.GetQuery("key")
+		}
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "key." + col + " is required"})
+			return "", nil, false
+		}
+		args = append(args, v)
+		conds = append(conds, h.dialect.Quote(col)+" = "+h.dialect.Placeholder(len(args)))
+	}
+	return strings.Join(conds, " AND "), args, true
+}
+
+// writeTx runs the transaction of a write request under the query timeout,
+// retrying it on serialization failures and deadlocks, and returns how many
+// times it was retried. With X-Session-ID, it runs in that session's
+// transaction instead. Retries are also reported in the
+// X-Transaction-Retries header. It writes the error response if the
+// transaction fails.
+func (h *Handler) writeTx(c *gin.Context, fn func(ctx context.Context, tx *sql.Tx) error) (int, bool) {
+	ctx := c.Request.Context()
+	if timeout := h.queryTimeout(ctx); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	release, err := h.acquireStatement(ctx)
+	if err != nil {
+		respondQueryError(c, err)
+		return 0, false
+	}
+	defer release()
+	if id := c.GetHeader("X-Session-ID"); id != "" {
+		return 0, h.sessionWrite(c, ctx, id, fn)
+	}
+	retries, err := h.retryTx(ctx, func(tx *sql.Tx) error { return fn(ctx, tx) })
+	if retries > 0 {
+		c.Header("X-Transaction-Retries", strconv.Itoa(retries))
+	}
+	if err != nil && h.dialect.Retryable(err) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Transaction aborted by concurrent writes: " + err.Error(), "retries": retries})
+		return retries, false
+	}
+	if err != nil {
+		respondQueryError(c, err)
+		return retries, false
+	}
+	return retries, true
+}
+
+// sessionWrite runs the transaction of a write request in a write session
+// instead, where it is neither retried nor committed
+func (h *Handler) sessionWrite(c *gin.Context, ctx context.Context, id string, fn func(ctx context.Context, tx *sql.Tx) error) bool {
+	s, ok := h.acquireSession(c, id)
+	if !ok {
+		return false
+	}
+	defer h.releaseSession(s)
+	if s.Mode != "write" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Session is read-only"})
+		return false
+	}
+	err := s.step(ctx, func(tx *sql.Tx) error { return fn(ctx, tx) })
+	if err != nil && h.dialect.Retryable(err) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Transaction aborted by concurrent writes: " + err.Error()})
+		return false
+	}
+	if err != nil {
+		respondQueryError(c, err)
+		return false
+	}
+	return true
+}
+
+// execWrite runs an INSERT, UPDATE or DELETE, returning the readable columns
+// of the rows it wrote. It returns sql.ErrNoRows when no row was affected.
+func (h *Handler) execWrite(ctx context.Context, tx *sql.Tx, t *writeTarget, stmt string, args []interface{}) ([]map[string]interface{}, error) {
+	if len(t.Returning) == 0 {
+		res, err := tx.ExecContext(ctx, stmt, args...)
+		if err != nil {
+			return nil, err
+		}
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			return nil, sql.ErrNoRows
+		}
+		return nil, nil
+	}
+	rows, err := tx.QueryContext(ctx, stmt+" RETURNING "+strings.Join(h.returningColumns(t), ", "), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	_, result, err := scanRowMaps(rows)
+	if err == nil && len(result) == 0 {
+		err = sql.ErrNoRows
+	}
+	return result, err
+}
+
+// returningColumns lists the expressions reading back a written row: the
+// readable columns and, if versioned, the row version
+func (h *Handler) returningColumns(t *writeTarget) []string {
+	quoted := make([]string, len(t.Returning))
+	for i, col := range t.Returning {
+		quoted[i] = h.dialect.Quote(col)
+	}
+	if t.Versioned {
+		quoted = append(quoted, h.dialect.RowVersion()+" AS "+h.dialect.Quote(rowVersionColumn))
+	}
+	return quoted
+}
+
+// maskRows masks the columns of written rows the caller may only see masked
+func (h *Handler) maskRows(c *gin.Context, t *writeTarget, rows []map[string]interface{}) []map[string]interface{} {
+	access := rbac.FromContext(c.Request.Context())
+	var roles []string
+	if access != nil {
+		roles = access.Roles
+	}
+	masked := h.masks.Columns(h.grantSchema(t.Schema), t.Table, roles)
+	for _, row := range rows {
+		for col, v := range row {
+			if m, ok := masked[strings.ToLower(col)]; ok {
+				row[col] = h.masks.Apply(m, v)
+			}
+		}
+	}
+	return rows
+}
+
+// decodeJSONNumbers decodes JSON keeping numbers as json.Number, so that
+// integers and decimals are not rounded through float64
+func decodeJSONNumbers(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
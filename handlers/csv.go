@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// CSVWriter writes records the way PostgreSQL's COPY ... (FORMAT csv) does,
+// which keeps NULLs and empty strings apart: a NULL is an empty field and an
+// empty string is quoted (""). encoding/csv writes both as empty fields.
+type CSVWriter struct {
+	w *bufio.Writer
+	// Comma separates fields, ',' by default
+	Comma byte
+}
+
+func NewCSVWriter(w io.Writer) *CSVWriter {
+	return &CSVWriter{w: bufio.NewWriter(w), Comma: ','}
+}
+
+// Write writes a record; nulls, when given, marks its NULL fields
+func (w *CSVWriter) Write(record []string, nulls []bool) error {
+	for i, field := range record {
+		if i > 0 {
+			w.w.WriteByte(w.Comma)
+		}
+		if i < len(nulls) && nulls[i] {
+			continue
+		}
+		if field != "" && field != `\.` && !strings.ContainsAny(field, "\"\r\n"+string(rune(w.Comma))) {
+			w.w.WriteString(field)
+			continue
+		}
+		w.w.WriteByte('"')
+		w.w.WriteString(strings.ReplaceAll(field, `"`, `""`))
+		w.w.WriteByte('"')
+	}
+	_, err := w.w.WriteString("\n")
+	return err
+}
+
+// Flush writes out the buffered records
+func (w *CSVWriter) Flush() error {
+	return w.w.Flush()
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCSVWriter(t *testing.T) {
+	tests := []struct {
+		name   string
+		record []string
+		nulls  []bool
+		want   string
+	}{
+		{"null", []string{"a", "", "b"}, []bool{false, true, false}, "a,,b\n"},
+		{"empty string", []string{"a", "", "b"}, nil, "a,\"\",b\n"},
+		{"end of data marker", []string{`\.`}, nil, "\"\\.\"\n"},
+		{"embedded comma", []string{"a,b", "c"}, nil, "\"a,b\",c\n"},
+		{"embedded quote", []string{`say "hi"`}, nil, "\"say \"\"hi\"\"\"\n"},
+		{"embedded newline", []string{"a\nb"}, nil, "\"a\nb\"\n"},
+		{"null and empty string", []string{"", ""}, []bool{true}, ",\"\"\n"},
+		{"null only field", []string{""}, []bool{true}, "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b strings.Builder
+			w := NewCSVWriter(&b)
+			if err := w.Write(tt.record, tt.nulls); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			if got := b.String(); got != tt.want {
+				t.Errorf("Write(%q, %v) = %q, want %q", tt.record, tt.nulls, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCSVWriterComma(t *testing.T) {
+	var b strings.Builder
+	w := NewCSVWriter(&b)
+	w.Comma = ';'
+	w.Write([]string{"a,b", "c;d", ""}, []bool{false, false, true})
+	w.Flush()
+	if got, want := b.String(), "a,b;\"c;d\";\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
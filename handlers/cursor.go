@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"sql-engine/store"
+)
+
+const cursorSecretKey = "query/cursor-secret"
+
+// queryCursor is the position of the next page of a paginated query. Cursors
+// are signed and carry everything needed to resume, so they keep working
+// across restarts and on any replica sharing the signing secret.
+type queryCursor struct {
+	SQL      string `json:"q"`
+	Hash     string `json:"h"` // fingerprint hash of SQL
+	Offset   int    `json:"o"`
+	PageSize int    `json:"n"`
+	IssuedAt int64  `json:"t"`
+}
+
+// queryPage asks executeQuery for one page of results
+type queryPage struct {
+	// Hash, when set, is the fingerprint the query must have
+	Hash     string
+	Offset   int
+	PageSize int
+}
+
+var errBadCursor = errors.New("invalid cursor")
+
+// cursorSecret returns the configured signing secret, or the one generated
+// and persisted on first use
+func (h *Handler) cursorSecret() ([]byte, error) {
+	if h.cfg.Query.CursorSecret != "" {
+		return []byte(h.cfg.Query.CursorSecret), nil
+	}
+
+	h.cursorMu.Lock()
+	defer h.cursorMu.Unlock()
+	if h.cursorKey != nil {
+		return h.cursorKey, nil
+	}
+	var secret string
+	err := h.meta.Get(cursorSecretKey, &secret)
+	if errors.Is(err, store.ErrNotFound) {
+		b := make([]byte, 32)
+		rand.Read(b)
+		// Another replica may have generated one meanwhile; use the winner's
+		if err = h.meta.Create(cursorSecretKey, hex.EncodeToString(b)); err == nil || errors.Is(err, store.ErrExists) {
+			err = h.meta.Get(cursorSecretKey, &secret)
+		}
+	}
+	if err != nil {
+		return nil, &QueryError{Status: http.StatusServiceUnavailable, Message: "Pagination is unavailable: " + err.Error()}
+	}
+	h.cursorKey = []byte(secret)
+	return h.cursorKey, nil
+}
+
+func (h *Handler) encodeCursor(cur queryCursor) (string, error) {
+	secret, err := h.cursorSecret()
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(cur)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// decodeCursor verifies a cursor's signature and age
+func (h *Handler) decodeCursor(token string) (queryCursor, error) {
+	var cur queryCursor
+	secret, err := h.cursorSecret()
+	if err != nil {
+		return cur, err
+	}
+	encoded, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return cur, errBadCursor
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return cur, errBadCursor
+	}
+	got, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return cur, errBadCursor
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return cur, errBadCursor
+	}
+	if err := json.Unmarshal(payload, &cur); err != nil {
+		return cur, errBadCursor
+	}
+	if ttl := h.cfg.Query.CursorTTL; ttl > 0 && time.Since(time.Unix(cur.IssuedAt, 0)) > ttl {
+		return cur, errors.New("cursor expired")
+	}
+	return cur, nil
+}
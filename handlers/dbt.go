@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"sql-engine/rbac"
+
+	"github.com/gin-gonic/gin"
+)
+
+const dbtModelPrefix = "dbt-model/"
+
+// maxManifestBytes caps the size of an uploaded dbt manifest
+const maxManifestBytes = 64 << 20
+
+// DBTModel is dbt metadata attached to a table or view of the catalog
+type DBTModel struct {
+	UniqueID     string            `json:"unique_id"`
+	ResourceType string            `json:"resource_type"`
+	Relation     string            `json:"relation"`
+	Schema       string            `json:"schema,omitempty"`
+	Description  string            `json:"description,omitempty"`
+	Tags         []string          `json:"tags,omitempty"`
+	Materialized string            `json:"materialized,omitempty"`
+	Columns      map[string]string `json:"columns,omitempty"` // column → description
+	Upstream     []string          `json:"upstream,omitempty"`
+	Downstream   []string          `json:"downstream,omitempty"`
+	ImportedAt   time.Time         `json:"imported_at"`
+}
+
+// dbtManifest is the subset of dbt's manifest.json the importer reads
+type dbtManifest struct {
+	Nodes   map[string]dbtNode `json:"nodes"`
+	Sources map[string]dbtNode `json:"sources"`
+}
+
+type dbtNode struct {
+	UniqueID     string   `json:"unique_id"`
+	ResourceType string   `json:"resource_type"`
+	Name         string   `json:"name"`
+	Alias        string   `json:"alias"`
+	Identifier   string   `json:"identifier"` // sources
+	Schema       string   `json:"schema"`
+	Description  string   `json:"description"`
+	Tags         []string `json:"tags"`
+	Config       struct {
+		Materialized string `json:"materialized"`
+	} `json:"config"`
+	Columns map[string]struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+	} `json:"columns"`
+	DependsOn struct {
+		Nodes []string `json:"nodes"`
+	} `json:"depends_on"`
+}
+
+// relation is the table or view a node is materialized as
+func (n dbtNode) relation() string {
+	for _, name := range []string{n.Alias, n.Identifier, n.Name} {
+		if name != "" {
+			return strings.ToLower(name)
+		}
+	}
+	return ""
+}
+
+// ImportDBTManifest replaces the dbt metadata of the catalog with the models,
+// seeds, snapshots and sources of the manifest.json in the request body
+func (h *Handler) ImportDBTManifest(c *gin.Context) {
+	var manifest dbtManifest
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, maxManifestBytes)
+	if err := json.NewDecoder(body).Decode(&manifest); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid manifest: " + err.Error()})
+		return
+	}
+
+	// Sources first so a model materialized under the same name wins
+	var nodes []dbtNode
+	for _, n := range manifest.Sources {
+		nodes = append(nodes, n)
+	}
+	for _, n := range manifest.Nodes {
+		switch n.ResourceType {
+		case "model", "seed", "snapshot":
+			nodes = append(nodes, n)
+		}
+	}
+	if len(nodes) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Manifest contains no models or sources"})
+		return
+	}
+
+	relations := map[string]string{} // unique_id → relation
+	for _, n := range nodes {
+		relations[n.UniqueID] = n.relation()
+	}
+
+	now := time.Now()
+	models := map[string]*DBTModel{}
+	for _, n := range nodes {
+		m := &DBTModel{
+			UniqueID:     n.UniqueID,
+			ResourceType: n.ResourceType,
+			Relation:     n.relation(),
+			Schema:       n.Schema,
+			Description:  n.Description,
+			Tags:         n.Tags,
+			Materialized: n.Config.Materialized,
+			Columns:      map[string]string{},
+			ImportedAt:   now,
+		}
+		for name, col := range n.Columns {
+			if col.Name != "" {
+				name = col.Name
+			}
+			if col.Description != "" {
+				m.Columns[strings.ToLower(name)] = col.Description
+			}
+		}
+		for _, dep := range n.DependsOn.Nodes {
+			if rel, ok := relations[dep]; ok && !slices.Contains(m.Upstream, rel) {
+				m.Upstream = append(m.Upstream, rel)
+			}
+		}
+		models[m.Relation] = m
+	}
+	for _, m := range models {
+		for _, up := range m.Upstream {
+			if parent, ok := models[up]; ok && !slices.Contains(parent.Downstream, m.Relation) {
+				parent.Downstream = append(parent.Downstream, m.Relation)
+			}
+		}
+	}
+
+	// Replace the previous import
+	oldKeys, err := h.meta.List(dbtModelPrefix)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	for _, key := range oldKeys {
+		if _, ok := models[strings.TrimPrefix(key, dbtModelPrefix)]; !ok {
+			if err := h.meta.Delete(key); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+		}
+	}
+	for rel, m := range models {
+		slices.Sort(m.Upstream)
+		slices.Sort(m.Downstream)
+		if err := h.meta.Put(dbtModelPrefix+rel, m); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	// Report which models exist in the database
+	tables, err := h.dialect.ListTables(h.db, "")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	matched, unmatched := []string{}, []string{}
+	for rel := range models {
+		if slices.ContainsFunc(tables, func(t TableInfo) bool { return strings.EqualFold(t.Name, rel) }) {
+			matched = append(matched, rel)
+		} else {
+			unmatched = append(unmatched, rel)
+		}
+	}
+	slices.Sort(matched)
+	slices.Sort(unmatched)
+
+	c.JSON(http.StatusOK, gin.H{
+		"imported":  len(models),
+		"matched":   matched,
+		"unmatched": unmatched,
+	})
+}
+
+// GetDBTModels lists the imported dbt metadata
+func (h *Handler) GetDBTModels(c *gin.Context) {
+	models, err := h.dbtModels()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	list := []*DBTModel{}
+	for _, m := range models {
+		if rbac.FromContext(c.Request.Context()).CanTable("", m.Relation) {
+			list = append(list, m)
+		}
+	}
+	slices.SortFunc(list, func(a, b *DBTModel) int { return strings.Compare(a.Relation, b.Relation) })
+
+	c.JSON(http.StatusOK, gin.H{"models": list})
+}
+
+// dbtModels returns the imported dbt metadata keyed by lowercased relation
+func (h *Handler) dbtModels() (map[string]*DBTModel, error) {
+	keys, err := h.meta.List(dbtModelPrefix)
+	if err != nil {
+		return nil, err
+	}
+	models := map[string]*DBTModel{}
+	for _, key := range keys {
+		var m DBTModel
+		if err := h.meta.Get(key, &m); err != nil {
+			return nil, err
+		}
+		models[m.Relation] = &m
+	}
+	return models, nil
+}
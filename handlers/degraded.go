@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"sql-engine/cache"
+
+	"github.com/gin-gonic/gin"
+)
+
+// storeCheckInterval is how often every replica checks the metadata store
+const storeCheckInterval = 10 * time.Second
+
+// storeHealth tracks whether the metadata store is usable. While it isn't,
+// query and schema endpoints keep working and features that need the store
+// answer 503.
+type storeHealth struct {
+	mu    sync.RWMutex
+	err   error
+	since time.Time
+	// disabled lists the features registered with RequireStore
+	disabled []string
+}
+
+// StartStoreMonitor checks the metadata store now and then periodically.
+// Unlike scheduled jobs it runs on every replica, leader or not.
+func (h *Handler) StartStoreMonitor() {
+	h.checkStore()
+	go func() {
+		ticker := time.NewTicker(storeCheckInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			h.checkStore()
+		}
+	}()
+}
+
+// checkStore pings the store, logs when its availability changes and
+// reports whether it is available
+func (h *Handler) checkStore() bool {
+	start := time.Now()
+	err := h.meta.Ping()
+	h.status.ring("metadata_store", false).record(err == nil, time.Since(start))
+
+	h.health.mu.Lock()
+	defer h.health.mu.Unlock()
+	switch {
+	case err != nil && h.health.err == nil:
+		slog.Error("Metadata store unavailable, running degraded", "error", err)
+		h.health.since = time.Now()
+	case err == nil && h.health.err != nil:
+		slog.Info("Metadata store available again", "down_for", time.Since(h.health.since).Round(time.Second).String())
+	}
+	h.health.err = err
+	return err == nil
+}
+
+// storeDown returns the error of the last failed store check, or nil
+func (h *Handler) storeDown() error {
+	h.health.mu.RLock()
+	defer h.health.mu.RUnlock()
+	return h.health.err
+}
+
+// FlagDegraded marks every response with X-Degraded while the metadata store
+// is unavailable
+func (h *Handler) FlagDegraded() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if h.storeDown() != nil {
+			c.Header("X-Degraded", "metadata-store")
+		}
+		c.Next()
+	}
+}
+
+// RequireStore answers 503 for a feature that needs the metadata store while
+// the store is unavailable
+func (h *Handler) RequireStore(feature string) gin.HandlerFunc {
+	h.health.mu.Lock()
+	h.health.disabled = append(h.health.disabled, feature)
+	h.health.mu.Unlock()
+
+	return func(c *gin.Context) {
+		if err := h.storeDown(); err != nil {
+			c.Header("Retry-After", strconv.Itoa(int(storeCheckInterval.Seconds())))
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+				"error":    "Metadata store unavailable; " + feature + " is disabled",
+				"degraded": true,
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// GetHealth reports whether the API runs degraded and which features are
+// disabled
+func (h *Handler) GetHealth(c *gin.Context) {
+	cache.SetClass(c, cache.NoStore)
+	h.health.mu.RLock()
+	defer h.health.mu.RUnlock()
+
+	store := gin.H{"available": h.health.err == nil}
+	resp := gin.H{
+		"status":         "ok",
+		"replica":        h.cfg.Server.ReplicaID,
+		"metadata_store": store,
+	}
+	if h.health.err != nil {
+		store["error"] = h.health.err.Error()
+		store["unavailable_since"] = h.health.since
+		resp["status"] = "degraded"
+		resp["disabled_features"] = h.health.disabled
+	}
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	sqlparser "github.com/blastrain/vitess-sqlparser/sqlparser"
+	"github.com/gin-gonic/gin"
+)
+
+// checkDenylist rejects statements using constructs banned by the query
+// denylist, naming the first violation found
+func (h *Handler) checkDenylist(stmt sqlparser.Statement) error {
+	deny := h.cfg.Query.Denylist
+	violation := func(kind, name string) error {
+		return &QueryError{
+			Status:  http.StatusForbidden,
+			Message: fmt.Sprintf("Denied: %s %s is not allowed", kind, name),
+			Details: gin.H{"violation": kind, "name": name},
+		}
+	}
+
+	var err error
+	sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		switch node := node.(type) {
+		case *sqlparser.FuncExpr:
+			name := node.Name.Lowered()
+			if matchesAny(deny.Functions, name) {
+				err = violation("function", name)
+			} else if q := node.Qualifier.String(); q != "" && matchesAny(deny.Schemas, q) {
+				err = violation("schema", q)
+			}
+		case *sqlparser.Select:
+			if deny.Locking && node.Lock != "" {
+				err = violation("locking clause", strings.ToUpper(strings.TrimSpace(node.Lock)))
+			}
+		}
+		return err == nil, nil
+	}, stmt)
+	if err != nil {
+		return err
+	}
+
+	for _, t := range analyzeStatement(stmt).Tables {
+		switch {
+		case t.Schema != "" && matchesAny(deny.Schemas, t.Schema):
+			return violation("schema", t.Schema)
+		case t.Schema == "" && matchesAny(deny.Tables, t.Name):
+			return violation("table", t.Name)
+		}
+	}
+	return nil
+}
+
+func matchesAny(patterns []string, name string) bool {
+	name = strings.ToLower(name)
+	for _, p := range patterns {
+		if ok, _ := path.Match(strings.ToLower(p), name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"sync"
+	"time"
+
+	sqlparser "github.com/blastrain/vitess-sqlparser/sqlparser"
+)
+
+// Dialect adapts the handlers to a specific database engine. Implementations
+// for additional engines can be registered with RegisterDialect.
+type Dialect interface {
+	ListDatabases(db *sql.DB) ([]string, error)
+	ListSchemas(db *sql.DB) ([]string, error)
+	// DefaultSchema is the schema unqualified names resolve to. The schema
+	// arguments below fall back to it when empty.
+	DefaultSchema() string
+
+	ListTables(db *sql.DB, schema string) ([]TableInfo, error)
+	ListColumns(db *sql.DB, schema, tableName string) ([]ColumnInfo, error)
+	ListPrimaryKeys(db *sql.DB, schema, tableName string) ([]string, error)
+	ListForeignKeys(db *sql.DB, schema, tableName string) ([]ForeignKeyInfo, error)
+	// ListConstraints returns a table's CHECK, UNIQUE and exclusion
+	// constraints; primary and foreign keys have their own methods
+	ListConstraints(db *sql.DB, schema, tableName string) ([]ConstraintInfo, error)
+	// TableComment returns the comment stored on a table or view
+	TableComment(db *sql.DB, schema, tableName string) (string, error)
+	// SetComment stores the comment of a table, or of one of its columns
+	// when column is set. An empty comment removes it. Engines without
+	// comments return errors.ErrUnsupported.
+	SetComment(ctx context.Context, db *sql.DB, schema, tableName, column, comment string) error
+	// ListIndexes returns a table's indexes with whatever size and usage
+	// statistics the engine keeps
+	ListIndexes(db *sql.DB, schema, tableName string) ([]IndexInfo, error)
+	// ListPartitions returns the partition tree of a table, or nil when it
+	// is not partitioned
+	ListPartitions(db *sql.DB, schema, tableName string) (*PartitionInfo, error)
+	// ListTriggers returns the user-defined triggers of a table
+	ListTriggers(db *sql.DB, schema, tableName string) ([]TriggerInfo, error)
+	// WriteCounter returns a number that changes whenever rows of the table
+	// are written, used to notice modifications between freshness checks
+	WriteCounter(db *sql.DB, schema, tableName string) (int64, error)
+	// ListTableStats returns size and maintenance statistics for one table,
+	// or for every table of the schema when tableName is empty
+	ListTableStats(db *sql.DB, schema, tableName string) ([]TableStats, error)
+	// ListColumnStats returns the statistics the planner gathered on the
+	// columns of a schema's tables, for those it has analyzed
+	ListColumnStats(db *sql.DB, schema string) ([]ColumnStats, error)
+	// ListViews returns the regular and materialized views of a schema with
+	// their definitions and the relations they read
+	ListViews(db *sql.DB, schema string) ([]ViewInfo, error)
+	// ListSequences returns the sequences of a schema with their settings,
+	// last values and owning columns
+	ListSequences(db *sql.DB, schema string) ([]SequenceInfo, error)
+	// ResetSequence moves a sequence owned by a column past the column's
+	// largest value, or back to its start when the table is empty, so that
+	// generated keys don't collide with rows inserted with explicit ones
+	ResetSequence(ctx context.Context, tx *sql.Tx, seq SequenceInfo) error
+	// ListTypes returns the enums, domains and composite types of a schema
+	ListTypes(db *sql.DB, schema string) ([]TypeInfo, error)
+	// ListFunctions returns the user-defined functions and procedures of a
+	// schema, leaving out those installed by extensions
+	ListFunctions(db *sql.DB, schema string) ([]FunctionInfo, error)
+	// ListHypertables returns the TimescaleDB hypertables of a schema, or
+	// errors.ErrUnsupported when the extension is not installed
+	ListHypertables(db *sql.DB, schema string) ([]HypertableInfo, error)
+	// RefreshMaterializedView recomputes a materialized view's contents
+	RefreshMaterializedView(ctx context.Context, db *sql.DB, schema, view string, concurrently bool) error
+	// ListContinuousAggregates returns the TimescaleDB continuous aggregates
+	// of a schema, or errors.ErrUnsupported without the extension
+	ListContinuousAggregates(db *sql.DB, schema string) ([]ContinuousAggregateInfo, error)
+	// RefreshContinuousAggregate materializes a continuous aggregate between
+	// start and end, each nil (open), an int64 or a time.Time
+	RefreshContinuousAggregate(ctx context.Context, db *sql.DB, schema, view string, start, end any) error
+
+	// BeginReadOnly starts a transaction in which the database itself
+	// rejects writes, so statements that slip past the parser checks (e.g.
+	// writing functions) cannot modify data. end must be called once the
+	// results are read.
+	BeginReadOnly(ctx context.Context, db *sql.DB) (tx *sql.Tx, end func(), err error)
+	// SetLocal changes settings for the rest of a transaction, resetting
+	// them when it ends; engines without such settings ignore them
+	SetLocal(ctx context.Context, tx *sql.Tx, settings map[string]string) error
+	// BeginSession starts the transaction of a session, which later requests
+	// continue: reads see one snapshot throughout, and the database rejects
+	// writes unless write is set. ctx must outlive the requests. end rolls
+	// the transaction back unless it was committed and frees its connection.
+	BeginSession(ctx context.Context, db *sql.DB, write bool) (tx *sql.Tx, end func(), err error)
+	// Retryable reports whether err aborted a transaction that may succeed
+	// when run again: a serialization failure or a deadlock
+	Retryable(err error) bool
+	// ConnectionLost reports whether err came from a connection that broke
+	// or was closed by the server rather than from the statement, so that
+	// a read may succeed on another connection
+	ConnectionLost(err error) bool
+	// RowVersion returns an expression giving a token that changes
+	// whenever a row is updated, used for optimistic concurrency; "" when
+	// the engine has none
+	RowVersion() string
+	// CopyOut streams the rows of a SELECT, whose values are inlined, to w
+	// as CSV with a header line or, when format is binary, in the engine's
+	// binary copy format; errors.ErrUnsupported for formats it lacks. The
+	// statement runs read-only.
+	CopyOut(ctx context.Context, db *sql.DB, w io.Writer, query, format string) error
+	// PeekChanges decodes up to limit row changes to tables (schema.table)
+	// from the logical replication slot, in commit order, after the
+	// position after ("" for all), leaving them in the slot. The slot is
+	// created if missing. errors.ErrUnsupported where the engine has no
+	// logical decoding.
+	PeekChanges(ctx context.Context, db *sql.DB, slot string, tables []string, after string, limit int) ([]ChangeEvent, error)
+	// AdvanceSlot releases the changes of the slot committed before the
+	// transaction of position, or all of them when it is empty
+	AdvanceSlot(ctx context.Context, db *sql.DB, slot string, tables []string, position string) error
+	// ReplicationLag reports whether the database is a replica of another
+	// and, if so, how far its replay is behind. errors.ErrUnsupported where
+	// the engine doesn't replicate.
+	ReplicationLag(ctx context.Context, db *sql.DB) (replica bool, lag time.Duration, err error)
+	// ServerInfo returns the database's version and its clock, the zero
+	// time for embedded engines sharing the local one
+	ServerInfo(ctx context.Context, db *sql.DB) (version string, now time.Time, err error)
+	// UnreadableTables lists the tables and views of a schema the
+	// connection lacks the privilege to SELECT from
+	UnreadableTables(ctx context.Context, db *sql.DB, schema string) ([]string, error)
+	// StatementStats returns why the engine's cumulative statement
+	// statistics can't be read, nil when they can; errors.ErrUnsupported
+	// where it keeps none
+	StatementStats(ctx context.Context, db *sql.DB) error
+	// Listen subscribes a connection of db to the notifications of channel
+	// until ctx is done, calling ready once subscribed and notify with the
+	// payload of each notification; errors.ErrUnsupported where the engine
+	// has none
+	Listen(ctx context.Context, db *sql.DB, channel string, ready func(), notify func(payload string)) error
+	// PreparedStatements lists the hot introspection queries worth preparing
+	// on every new connection; nil when the driver cannot
+	PreparedStatements() []string
+
+	// Quote returns ident quoted for safe use as an identifier
+	Quote(ident string) string
+	// Placeholder returns the bind parameter marker for the n-th (1-based)
+	// argument of a statement
+	Placeholder(n int) string
+	// LimitClause returns the clause appended to cap a query at n rows
+	LimitClause(n int) string
+	// BucketEpoch returns an expression giving the start, in Unix seconds,
+	// of the fixed-size bucket of the given length holding timestamp expr
+	BucketEpoch(expr string, seconds int64) string
+	// TruncateTime returns an expression truncating the timestamp expr to
+	// the start of its hour, day, week (starting Monday), month or year
+	TruncateTime(expr, unit string) string
+	// BinarySlice returns expressions giving the length in bytes of a binary
+	// column and count bytes of it from the 1-based position start, both
+	// given as SQL expressions. ok is false for columns of other types.
+	BinarySlice(col, dataType, start, count string) (length, slice string, ok bool)
+	// JSONPath returns an expression reading the value at path, a list of
+	// object keys and array indexes, out of the JSON held by col: as text,
+	// or as a number when numeric is set, null for values of other types.
+	// An empty path reads the whole document. ok is false for columns of
+	// types that can't hold JSON.
+	JSONPath(col, dataType string, path []string, numeric bool) (expr string, ok bool)
+	// TextSearchDocument returns an expression giving the full-text document
+	// of the columns cols, quoted, in the text search configuration config,
+	// or the default one when empty. ok is false where full-text search isn't
+	// supported.
+	TextSearchDocument(cols []string, config string) (expr string, ok bool)
+	// BuiltinFunctions names the commonly used functions the engine
+	// provides, offered as completions next to the user-defined ones
+	BuiltinFunctions() []string
+	// ProductName names the engine, e.g. to tell a language model which
+	// SQL flavour to write
+	ProductName() string
+	// StrictTypes reports whether columns only hold values of their declared
+	// type; SQLite stores any value, an empty string included, in any column
+	StrictTypes() bool
+	// ExplainQuery returns the statement showing the plan of a SELECT
+	// without running it
+	ExplainQuery(query string) string
+	// ParseStatement parses a single SQL statement for validation
+	ParseStatement(sqlText string) (sqlparser.Statement, error)
+}
+
+// orDefault returns schema, or the dialect's default schema when empty
+func orDefault(d Dialect, schema string) string {
+	if schema == "" {
+		return d.DefaultSchema()
+	}
+	return schema
+}
+
+var (
+	dialectsMu sync.RWMutex
+	dialects   = map[string]Dialect{}
+)
+
+// RegisterDialect makes a dialect available for the given database/sql
+// driver name
+func RegisterDialect(driver string, d Dialect) {
+	dialectsMu.Lock()
+	defer dialectsMu.Unlock()
+	dialects[driver] = d
+}
+
+// LookupDialect returns the dialect registered for a driver name
+func LookupDialect(driver string) (Dialect, bool) {
+	dialectsMu.RLock()
+	defer dialectsMu.RUnlock()
+	d, ok := dialects[driver]
+	return d, ok
+}
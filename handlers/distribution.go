@@ -0,0 +1,413 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultDistributionBins = 20
+	maxDistributionBins     = 100
+	defaultDistributionTop  = 10
+)
+
+var distributionQuantiles = []float64{0.01, 0.25, 0.5, 0.75, 0.99}
+
+// DistributionSide selects the values of one column, optionally filtered
+type DistributionSide struct {
+	Schema  string         `json:"schema"`
+	Table   string         `json:"table"`
+	Column  string         `json:"column"`
+	Filters []ColumnFilter `json:"filters"`
+}
+
+// DistributionRequest compares the values of two columns, e.g. a table
+// before and after a migration or two filters of the same table. Bins sets
+// the histogram resolution of numeric columns and Top the number of most
+// frequent values compared otherwise.
+type DistributionRequest struct {
+	Left  DistributionSide `json:"left"`
+	Right DistributionSide `json:"right"`
+	Bins  int              `json:"bins"`
+	Top   int              `json:"top"`
+}
+
+// DistributionStats summarizes one side. Min, Max, Mean and Quantiles are
+// only computed for numeric columns.
+type DistributionStats struct {
+	Rows      int64              `json:"rows"`
+	Nulls     int64              `json:"nulls"`
+	Distinct  int64              `json:"distinct"`
+	Min       *float64           `json:"min,omitempty"`
+	Max       *float64           `json:"max,omitempty"`
+	Mean      *float64           `json:"mean,omitempty"`
+	Quantiles map[string]float64 `json:"quantiles,omitempty"`
+}
+
+// CategoryShare compares how often a value occurs on each side, as a share
+// of the non-null values
+type CategoryShare struct {
+	Value      interface{} `json:"value"`
+	LeftCount  int64       `json:"left_count"`
+	RightCount int64       `json:"right_count"`
+	LeftShare  float64     `json:"left_share"`
+	RightShare float64     `json:"right_share"`
+}
+
+// distributionSource is a validated side ready to be queried
+type distributionSource struct {
+	from  string // table and conditions, appended to SELECT ... FROM
+	col   string // quoted column
+	args  []interface{}
+	stats DistributionStats
+}
+
+// CompareDistributions compares the distribution of two columns. Numeric
+// columns are compared with shared histogram bins and a Kolmogorov-Smirnov
+// statistic over them; other columns by their most frequent values and the
+// total variation distance, pooling the remaining values.
+func (h *Handler) CompareDistributions(c *gin.Context) {
+	var req DistributionRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		return
+	}
+	if req.Bins == 0 {
+		req.Bins = defaultDistributionBins
+	}
+	if req.Top == 0 {
+		req.Top = defaultDistributionTop
+	}
+	if req.Bins < 1 || req.Bins > maxDistributionBins {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("bins must be between 1 and %d", maxDistributionBins)})
+		return
+	}
+	if maxRows := h.maxRows(c.Request.Context()); req.Top < 1 || req.Top > maxRows {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("top must be between 1 and %d", maxRows)})
+		return
+	}
+
+	left, leftType, ok := h.distributionSource(c, "left", req.Left)
+	if !ok {
+		return
+	}
+	right, rightType, ok := h.distributionSource(c, "right", req.Right)
+	if !ok {
+		return
+	}
+	numeric := isNumericType(leftType) && isNumericType(rightType)
+
+	ctx := c.Request.Context()
+	if timeout := h.queryTimeout(ctx); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	release, err := h.acquireStatement(ctx)
+	if err != nil {
+		respondQueryError(c, err)
+		return
+	}
+	defer release()
+	tx, end, err := h.dialect.BeginReadOnly(ctx, h.queryDB(ctx))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start read-only transaction: " + err.Error()})
+		return
+	}
+	defer end()
+
+	for _, src := range []*distributionSource{left, right} {
+		if err := h.distributionStats(ctx, tx, src, numeric); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	resp := gin.H{"left": left.stats, "right": right.stats}
+	if numeric {
+		resp["kind"] = "numeric"
+		edges, leftBins, rightBins, err := h.distributionHistograms(ctx, tx, left, right, req.Bins)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		// The largest gap between the cumulative distributions
+		ks, leftCum, rightCum := 0.0, 0.0, 0.0
+		for i := range leftBins {
+			leftCum += leftBins[i]
+			rightCum += rightBins[i]
+			ks = math.Max(ks, math.Abs(leftCum-rightCum))
+		}
+		resp["histogram"] = gin.H{"edges": edges, "left": leftBins, "right": rightBins}
+		resp["divergence"] = gin.H{"statistic": "ks", "value": ks}
+	} else {
+		resp["kind"] = "categorical"
+		categories, err := h.distributionCategories(ctx, tx, left, right, req.Top)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		tvd, leftOther, rightOther := 0.0, 1.0, 1.0
+		for _, cat := range categories {
+			tvd += math.Abs(cat.LeftShare - cat.RightShare)
+			leftOther -= cat.LeftShare
+			rightOther -= cat.RightShare
+		}
+		tvd = (tvd + math.Abs(leftOther-rightOther)) / 2
+		resp["categories"] = categories
+		resp["divergence"] = gin.H{"statistic": "total_variation", "value": tvd}
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// distributionSource validates one side and returns it with the column's
+// data type. Errors are written to c.
+func (h *Handler) distributionSource(c *gin.Context, field string, side DistributionSide) (*distributionSource, string, bool) {
+	schema := orDefault(h.dialect, side.Schema)
+	if matchesAny(h.cfg.Query.Denylist.Tables, side.Table) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Denied: table " + side.Table + " is not allowed"})
+		return nil, "", false
+	}
+	if !h.requireTable(c, schema, side.Table) || !h.requireRowLevel(c, schema, side.Table) {
+		return nil, "", false
+	}
+	columns, err := h.dialect.ListColumns(h.db, schema, side.Table)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, "", false
+	}
+	if len(columns) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": field + ": table " + side.Table + " not found"})
+		return nil, "", false
+	}
+	if !h.analysisColumn(c, schema, side.Table, columns, field, side.Column) {
+		return nil, "", false
+	}
+
+	q := h.dialect.Quote
+	src := &distributionSource{col: q(side.Column)}
+	var conds []string
+	arg := func(v interface{}) string {
+		src.args = append(src.args, v)
+		return h.dialect.Placeholder(len(src.args))
+	}
+	for i, f := range side.Filters {
+		if !h.analysisColumn(c, schema, side.Table, columns, fmt.Sprintf("%s.filters[%d]", field, i), f.Column) {
+			return nil, "", false
+		}
+		cond, err := h.filterCondition(f, columns, arg)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s.filters[%d]: %v", field, i, err)})
+			return nil, "", false
+		}
+		conds = append(conds, cond)
+	}
+	src.from = q(schema) + "." + q(side.Table)
+	if len(conds) > 0 {
+		src.from += " WHERE " + strings.Join(conds, " AND ")
+	}
+
+	i := slices.IndexFunc(columns, func(ci ColumnInfo) bool { return ci.Name == side.Column })
+	return src, columns[i].DataType, true
+}
+
+// nonNull returns the source restricted to non-null values of its column
+func (src *distributionSource) nonNull() string {
+	if strings.Contains(src.from, " WHERE ") {
+		return src.from + " AND " + src.col + " IS NOT NULL"
+	}
+	return src.from + " WHERE " + src.col + " IS NOT NULL"
+}
+
+func (h *Handler) distributionStats(ctx context.Context, tx *sql.Tx, src *distributionSource, numeric bool) error {
+	s := &src.stats
+	var nonNull int64
+	err := tx.QueryRowContext(ctx, fmt.Sprintf("SELECT COUNT(*), COUNT(%s), COUNT(DISTINCT %s) FROM %s", src.col, src.col, src.from),
+		src.args...).Scan(&s.Rows, &nonNull, &s.Distinct)
+	if err != nil {
+		return err
+	}
+	s.Nulls = s.Rows - nonNull
+	if !numeric || nonNull == 0 {
+		return nil
+	}
+
+	var min, max, mean sql.NullFloat64
+	err = tx.QueryRowContext(ctx, fmt.Sprintf("SELECT MIN(%s), MAX(%s), AVG(%s) FROM %s", src.col, src.col, src.col, src.from),
+		src.args...).Scan(&min, &max, &mean)
+	if err != nil {
+		return err
+	}
+	s.Min, s.Max, s.Mean = &min.Float64, &max.Float64, &mean.Float64
+
+	// Nearest-rank quantiles, read with one ordered query each
+	s.Quantiles = map[string]float64{}
+	for _, p := range distributionQuantiles {
+		offset := int64(math.Round(p * float64(nonNull-1)))
+		var v float64
+		err := tx.QueryRowContext(ctx, fmt.Sprintf("SELECT %s FROM %s ORDER BY %s %s OFFSET %d",
+			src.col, src.nonNull(), src.col, h.dialect.LimitClause(1), offset), src.args...).Scan(&v)
+		if err != nil {
+			return err
+		}
+		s.Quantiles["p"+strconv.FormatFloat(p*100, 'f', -1, 64)] = v
+	}
+	return nil
+}
+
+// distributionHistograms bins both sides over their combined range and
+// returns the bin edges and each side's share of values per bin
+func (h *Handler) distributionHistograms(ctx context.Context, tx *sql.Tx, left, right *distributionSource, bins int) ([]float64, []float64, []float64, error) {
+	leftBins, rightBins := make([]float64, bins), make([]float64, bins)
+	if left.stats.Min == nil || right.stats.Min == nil {
+		return []float64{}, leftBins, rightBins, nil
+	}
+	lo := math.Min(*left.stats.Min, *right.stats.Min)
+	hi := math.Max(*left.stats.Max, *right.stats.Max)
+	width := (hi - lo) / float64(bins)
+	edges := make([]float64, bins+1)
+	for i := range edges {
+		edges[i] = lo + float64(i)*width
+	}
+	edges[bins] = hi
+
+	// Comparisons rather than arithmetic keep the binning portable
+	var cases []string
+	for i := 1; i < bins; i++ {
+		cases = append(cases, fmt.Sprintf("WHEN %s < %s THEN %d", left.col, strconv.FormatFloat(edges[i], 'g', -1, 64), i-1))
+	}
+	for _, side := range []struct {
+		src    *distributionSource
+		shares []float64
+	}{{left, leftBins}, {right, rightBins}} {
+		binExpr := fmt.Sprintf("%d", bins-1)
+		if len(cases) > 0 {
+			binExpr = fmt.Sprintf("CASE %s ELSE %d END", strings.ReplaceAll(strings.Join(cases, " "), left.col, side.src.col), bins-1)
+		}
+		rows, err := tx.QueryContext(ctx, fmt.Sprintf("SELECT %s, COUNT(*) FROM %s GROUP BY 1", binExpr, side.src.nonNull()), side.src.args...)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		total := float64(side.src.stats.Rows - side.src.stats.Nulls)
+		for rows.Next() {
+			var bin, count int64
+			if err := rows.Scan(&bin, &count); err != nil {
+				rows.Close()
+				return nil, nil, nil, err
+			}
+			side.shares[bin] = float64(count) / total
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	return edges, leftBins, rightBins, nil
+}
+
+// distributionCategories counts, on both sides, the most frequent values of
+// either side
+func (h *Handler) distributionCategories(ctx context.Context, tx *sql.Tx, left, right *distributionSource, top int) ([]CategoryShare, error) {
+	var values []interface{}
+	for _, src := range []*distributionSource{left, right} {
+		rows, err := tx.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM %s GROUP BY %s ORDER BY COUNT(*) DESC %s",
+			src.col, src.nonNull(), src.col, h.dialect.LimitClause(top)), src.args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var v interface{}
+			if err := rows.Scan(&v); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if b, ok := v.([]byte); ok {
+				v = string(b)
+			}
+			if !slices.Contains(values, v) {
+				values = append(values, v)
+			}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	categories := make([]CategoryShare, len(values))
+	for i, v := range values {
+		categories[i].Value = v
+	}
+	if len(values) == 0 {
+		return categories, nil
+	}
+	for _, src := range []*distributionSource{left, right} {
+		args := slices.Clone(src.args)
+		placeholders := make([]string, len(values))
+		for i, v := range values {
+			args = append(args, v)
+			placeholders[i] = h.dialect.Placeholder(len(args))
+		}
+		rows, err := tx.QueryContext(ctx, fmt.Sprintf("SELECT %s, COUNT(*) FROM %s AND %s IN (%s) GROUP BY %s",
+			src.col, src.nonNull(), src.col, strings.Join(placeholders, ", "), src.col), args...)
+		if err != nil {
+			return nil, err
+		}
+		total := float64(src.stats.Rows - src.stats.Nulls)
+		for rows.Next() {
+			var v interface{}
+			var count int64
+			if err := rows.Scan(&v, &count); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if b, ok := v.([]byte); ok {
+				v = string(b)
+			}
+			i := slices.Index(values, v)
+			if i < 0 {
+				continue
+			}
+			if src == left {
+				categories[i].LeftCount, categories[i].LeftShare = count, float64(count)/total
+			} else {
+				categories[i].RightCount, categories[i].RightShare = count, float64(count)/total
+			}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return categories, nil
+}
+
+// isNumericType applies SQLite's type affinity rules, which also recognise
+// the numeric types of other engines
+func isNumericType(dataType string) bool {
+	t := strings.ToUpper(dataType)
+	for _, s := range []string{"INT", "REAL", "FLOA", "DOUB", "NUM", "DEC"} {
+		if strings.Contains(t, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// isTemporalType recognises date, time and timestamp types, including
+// SQLite's DATETIME
+func isTemporalType(dataType string) bool {
+	t := strings.ToUpper(dataType)
+	return strings.Contains(t, "DATE") || strings.Contains(t, "TIME")
+}
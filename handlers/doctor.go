@@ -0,0 +1,248 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Statuses of a self-check, from best to worst. Skipped checks don't apply
+// to the configuration or the database engine.
+const (
+	CheckOK      = "ok"
+	CheckSkipped = "skipped"
+	CheckWarn    = "warn"
+	CheckFail    = "fail"
+)
+
+// doctorTimeout bounds each check reaching the database
+const doctorTimeout = 5 * time.Second
+
+// maxClockSkew is the difference between the database's clock and ours past
+// which timestamps compared across them (token expiry, watermarks,
+// freshness) get unreliable
+const maxClockSkew = time.Second
+
+// DoctorCheck is the outcome of one self-check
+type DoctorCheck struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+	// Hint suggests how to fix a failure or warning
+	Hint string `json:"hint,omitempty"`
+}
+
+// DoctorReport is the outcome of the self-checks, with the worst status of
+// them all
+type DoctorReport struct {
+	Status    string        `json:"status"`
+	CheckedAt time.Time     `json:"checked_at"`
+	Checks    []DoctorCheck `json:"checks"`
+}
+
+// NewDoctorReport summarizes checks
+func NewDoctorReport(checks []DoctorCheck) DoctorReport {
+	report := DoctorReport{Status: CheckOK, CheckedAt: time.Now().UTC(), Checks: checks}
+	rank := map[string]int{CheckOK: 0, CheckSkipped: 0, CheckWarn: 1, CheckFail: 2}
+	for _, check := range checks {
+		if rank[check.Status] > rank[report.Status] {
+			report.Status = check.Status
+		}
+	}
+	return report
+}
+
+// GetDoctor runs the self-checks of `sql-engine doctor` against the running
+// server
+func (h *Handler) GetDoctor(c *gin.Context) {
+	c.JSON(http.StatusOK, NewDoctorReport(h.DoctorChecks(c.Request.Context())))
+}
+
+// DoctorChecks verifies the configuration, the database connection and the
+// privileges the API relies on, the clocks' agreement and the metadata
+// store. Checks needing the database are skipped when it is unreachable.
+func (h *Handler) DoctorChecks(ctx context.Context) []DoctorCheck {
+	checks := []DoctorCheck{h.checkConfig()}
+	database := h.checkDatabase(ctx)
+	checks = append(checks, database)
+	if h.cfg.Database.LowPriority.Enabled() {
+		checks = append(checks, h.checkLowPriorityPool(ctx))
+	}
+	if database.Status == CheckFail {
+		for _, name := range []string{"catalog", "statement_stats", "clock_skew"} {
+			checks = append(checks, DoctorCheck{Name: name, Status: CheckSkipped, Message: "Database unreachable"})
+		}
+	} else {
+		checks = append(checks, h.checkCatalog(ctx), h.checkStatementStats(ctx), h.checkClockSkew(ctx))
+	}
+	return append(checks, h.checkMetadataStore())
+}
+
+// checkConfig flags settings that are valid but rarely intended
+func (h *Handler) checkConfig() DoctorCheck {
+	check := DoctorCheck{Name: "config", Status: CheckOK, Message: "Configuration is valid"}
+	if len(h.cfg.Auth.APIKeys) == 0 && h.cfg.Auth.OIDC.Issuer == "" {
+		check.Status = CheckWarn
+		check.Message = "Configuration is valid, but authentication is disabled"
+		check.Hint = "Set auth.api_keys or auth.oidc.issuer unless the API is only reachable by trusted callers"
+	}
+	return check
+}
+
+func (h *Handler) checkDatabase(ctx context.Context) DoctorCheck {
+	check := DoctorCheck{Name: "database"}
+	if h.db == nil {
+		check.Status, check.Message = CheckFail, "Database connection failed to open"
+		check.Hint = "Check database.dsn"
+		return check
+	}
+	ctx, cancel := context.WithTimeout(ctx, doctorTimeout)
+	defer cancel()
+	start := time.Now()
+	if err := h.db.PingContext(ctx); err != nil {
+		check.Status, check.Message = CheckFail, "Database unreachable: "+err.Error()
+		check.Hint = "Check database.dsn, that the database is running and that this host may connect to it"
+		return check
+	}
+	latency := time.Since(start)
+	version, _, err := h.dialect.ServerInfo(ctx, h.db)
+	if err != nil {
+		check.Status, check.Message = CheckFail, "Database version query failed: "+err.Error()
+		return check
+	}
+	check.Status = CheckOK
+	check.Message = fmt.Sprintf("Connected to %s in %s", version, latency.Round(time.Millisecond))
+	return check
+}
+
+func (h *Handler) checkLowPriorityPool(ctx context.Context) DoctorCheck {
+	check := DoctorCheck{Name: "low_priority_pool", Status: CheckOK, Message: "Low-priority pool connected"}
+	if h.lowDB == nil {
+		check.Status, check.Message = CheckFail, "Low-priority pool failed to open"
+		check.Hint = "Check database.low_priority; its connections use database.dsn"
+		return check
+	}
+	ctx, cancel := context.WithTimeout(ctx, doctorTimeout)
+	defer cancel()
+	if err := h.lowDB.PingContext(ctx); err != nil {
+		check.Status, check.Message = CheckFail, "Low-priority pool unreachable: "+err.Error()
+	}
+	return check
+}
+
+// checkCatalog reads the catalogs the schema endpoints are built on, and
+// the privileges on the default schema's tables
+func (h *Handler) checkCatalog(ctx context.Context) DoctorCheck {
+	check := DoctorCheck{Name: "catalog"}
+	ctx, cancel := context.WithTimeout(ctx, doctorTimeout)
+	defer cancel()
+	_, err := h.dialect.ListSchemas(h.db)
+	if err == nil {
+		_, err = h.dialect.ListTables(h.db, "")
+	}
+	if err != nil {
+		check.Status, check.Message = CheckFail, "Catalogs can't be read: "+err.Error()
+		check.Hint = "Grant the database user access to the system catalogs"
+		return check
+	}
+	unreadable, err := h.dialect.UnreadableTables(ctx, h.db, "")
+	if err != nil {
+		check.Status, check.Message = CheckFail, "Table privileges can't be read: "+err.Error()
+		return check
+	}
+	schema := h.dialect.DefaultSchema()
+	if len(unreadable) > 0 {
+		check.Status = CheckWarn
+		check.Message = fmt.Sprintf("%d tables of %s can't be read: %s", len(unreadable), schema, someOf(unreadable))
+		check.Hint = fmt.Sprintf("GRANT SELECT ON ALL TABLES IN SCHEMA %s TO the database user", h.dialect.Quote(schema))
+		return check
+	}
+	check.Status = CheckOK
+	check.Message = fmt.Sprintf("Catalogs and every table of %s are readable", schema)
+	return check
+}
+
+func (h *Handler) checkStatementStats(ctx context.Context) DoctorCheck {
+	check := DoctorCheck{Name: "statement_stats", Status: CheckOK, Message: "pg_stat_statements is readable"}
+	ctx, cancel := context.WithTimeout(ctx, doctorTimeout)
+	defer cancel()
+	err := h.dialect.StatementStats(ctx, h.db)
+	switch {
+	case errors.Is(err, errors.ErrUnsupported):
+		check.Status, check.Message = CheckSkipped, "The database engine keeps no statement statistics"
+	case err != nil:
+		check.Status, check.Message = CheckWarn, err.Error()
+		check.Hint = "Add pg_stat_statements to shared_preload_libraries, run CREATE EXTENSION pg_stat_statements and GRANT pg_read_all_stats to the database user"
+	}
+	return check
+}
+
+// checkClockSkew compares the database's clock to ours, taking the middle
+// of the round trip as the moment it was read
+func (h *Handler) checkClockSkew(ctx context.Context) DoctorCheck {
+	check := DoctorCheck{Name: "clock_skew"}
+	ctx, cancel := context.WithTimeout(ctx, doctorTimeout)
+	defer cancel()
+	start := time.Now()
+	_, now, err := h.dialect.ServerInfo(ctx, h.db)
+	if err != nil {
+		check.Status, check.Message = CheckFail, "Database clock can't be read: "+err.Error()
+		return check
+	}
+	if now.IsZero() {
+		check.Status, check.Message = CheckSkipped, "The database shares this host's clock"
+		return check
+	}
+	rtt := time.Since(start)
+	skew := now.Sub(start.Add(rtt / 2))
+	check.Status = CheckOK
+	check.Message = fmt.Sprintf("Database clock is %s off (±%s)", skew.Abs().Round(time.Millisecond), (rtt / 2).Round(time.Millisecond))
+	if skew.Abs() > maxClockSkew+rtt/2 {
+		check.Status = CheckWarn
+		check.Hint = "Synchronize the clocks of this host and the database server with NTP"
+	}
+	return check
+}
+
+// checkMetadataStore checks the store accepts writes and that every value
+// in it can still be read back
+func (h *Handler) checkMetadataStore() DoctorCheck {
+	check := DoctorCheck{Name: "metadata_store"}
+	if err := h.meta.Ping(); err != nil {
+		check.Status, check.Message = CheckFail, "Metadata store unavailable: "+err.Error()
+		check.Hint = "Check that store.dir exists and is writable; without it the API runs degraded"
+		return check
+	}
+	v, err := h.meta.Verify()
+	if err != nil {
+		check.Status, check.Message = CheckFail, "Metadata store can't be listed: "+err.Error()
+		return check
+	}
+	check.Status = CheckOK
+	check.Message = fmt.Sprintf("%d entries readable, none pending migration", v.Entries)
+	switch {
+	case len(v.Unreadable) > 0:
+		check.Status = CheckFail
+		check.Message = fmt.Sprintf("%d of %d entries can't be read: %s", len(v.Unreadable), v.Entries, someOf(v.Unreadable))
+		check.Hint = "Restore the entries, or the key of store.key_file they were encrypted with, or delete them"
+	case v.Unrotated > 0:
+		check.Status = CheckWarn
+		check.Message = fmt.Sprintf("%d of %d entries aren't encrypted with the active key", v.Unrotated, v.Entries)
+		check.Hint = "Restart the server to re-encrypt them with the first key of store.key_file"
+	}
+	return check
+}
+
+// someOf lists the first few of names
+func someOf(names []string) string {
+	const shown = 10
+	if len(names) <= shown {
+		return strings.Join(names, ", ")
+	}
+	return fmt.Sprintf("%s and %d more", strings.Join(names[:shown], ", "), len(names)-shown)
+}
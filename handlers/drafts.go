@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"sort"
+	"time"
+
+	"sql-engine/auth"
+	"sql-engine/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+const draftPrefix = "draft/"
+
+// Draft is an unsaved query editor buffer. The owner may share it with
+// collaborators, who co-edit by polling GET /drafts/:id and autosaving with
+// the version they last saw.
+type Draft struct {
+	ID            string   `json:"id"`
+	Title         string   `json:"title"`
+	SQL           string   `json:"sql"`
+	Owner         string   `json:"owner,omitempty"`
+	Collaborators []string `json:"collaborators"`
+	// Version increases with every save
+	Version   int64     `json:"version"`
+	SavedBy   string    `json:"saved_by,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	// SavedAt is the time of the last autosave
+	SavedAt time.Time `json:"saved_at"`
+}
+
+// DraftRequest creates or autosaves a draft. Version must be the version the
+// edit is based on; only the owner may change Collaborators.
+type DraftRequest struct {
+	Title         *string  `json:"title"`
+	SQL           *string  `json:"sql"`
+	Collaborators []string `json:"collaborators"`
+	Version       int64    `json:"version"`
+}
+
+// canEdit reports whether principal may read and save the draft
+func (d Draft) canEdit(principal string) bool {
+	return d.Owner == "" || d.Owner == principal || slices.Contains(d.Collaborators, principal)
+}
+
+// ListDrafts lists the drafts the caller owns or collaborates on, most
+// recently saved first
+func (h *Handler) ListDrafts(c *gin.Context) {
+	keys, err := h.meta.List(draftPrefix)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	principal := auth.Principal(c)
+	drafts := []Draft{}
+	for _, key := range keys {
+		var d Draft
+		if err := h.meta.Get(key, &d); err != nil {
+			continue
+		}
+		if d.canEdit(principal) {
+			drafts = append(drafts, d)
+		}
+	}
+	sort.Slice(drafts, func(i, j int) bool { return drafts[i].SavedAt.After(drafts[j].SavedAt) })
+	c.JSON(http.StatusOK, gin.H{"drafts": drafts})
+}
+
+func (h *Handler) CreateDraft(c *gin.Context) {
+	var req DraftRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		return
+	}
+
+	now := time.Now()
+	d := Draft{
+		ID:            newID(),
+		Owner:         auth.Principal(c),
+		Collaborators: []string{},
+		Version:       1,
+		SavedBy:       auth.Principal(c),
+		CreatedAt:     now,
+		SavedAt:       now,
+	}
+	if req.Title != nil {
+		d.Title = *req.Title
+	}
+	if req.SQL != nil {
+		d.SQL = *req.SQL
+	}
+	if req.Collaborators != nil {
+		d.Collaborators = req.Collaborators
+	}
+
+	if err := h.meta.Put(draftPrefix+d.ID, d); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, d)
+}
+
+// GetDraft returns a draft. Polling clients can send If-None-Match with the
+// ETag of the previous response to get 304 while nobody saved.
+func (h *Handler) GetDraft(c *gin.Context) {
+	d, ok := h.loadDraft(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, d)
+}
+
+// SaveDraft autosaves a draft. An edit based on an older version than the
+// stored one is rejected with 409 and the current draft, for the client to
+// merge and retry.
+func (h *Handler) SaveDraft(c *gin.Context) {
+	var req DraftRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		return
+	}
+
+	h.draftsMu.Lock()
+	defer h.draftsMu.Unlock()
+
+	d, ok := h.loadDraft(c)
+	if !ok {
+		return
+	}
+	principal := auth.Principal(c)
+	if req.Collaborators != nil && d.Owner != "" && d.Owner != principal {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the owner can change collaborators"})
+		return
+	}
+	if req.Version != d.Version {
+		c.JSON(http.StatusConflict, gin.H{"error": "Draft was saved by someone else meanwhile", "draft": d})
+		return
+	}
+
+	if req.Title != nil {
+		d.Title = *req.Title
+	}
+	if req.SQL != nil {
+		d.SQL = *req.SQL
+	}
+	if req.Collaborators != nil {
+		d.Collaborators = req.Collaborators
+	}
+	d.Version++
+	d.SavedBy = principal
+	d.SavedAt = time.Now()
+
+	if err := h.meta.Put(draftPrefix+d.ID, d); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, d)
+}
+
+func (h *Handler) DeleteDraft(c *gin.Context) {
+	d, ok := h.loadDraft(c)
+	if !ok {
+		return
+	}
+	if d.Owner != "" && d.Owner != auth.Principal(c) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the owner can delete this draft"})
+		return
+	}
+	if err := h.moveToTrash(c, "draft", d.ID, d.Title, d.Owner, d); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// loadDraft fetches the draft named by the :id route parameter if the
+// caller may edit it, writing an error response otherwise. Drafts of others
+// are reported as missing.
+func (h *Handler) loadDraft(c *gin.Context) (Draft, bool) {
+	var d Draft
+	err := h.meta.Get(draftPrefix+c.Param("id"), &d)
+	if errors.Is(err, store.ErrNotFound) || (err == nil && !d.canEdit(auth.Principal(c))) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Draft not found"})
+		return d, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return d, false
+	}
+	return d, true
+}
@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"sql-engine/rbac"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultDuplicateGroups = 20
+	defaultDuplicateSample = 3
+)
+
+// DuplicateGroup is a combination of values shared by several rows
+type DuplicateGroup struct {
+	Values map[string]interface{} `json:"values"`
+	Count  int64                  `json:"count"`
+	// Keys holds the primary key values of some of the rows
+	Keys []map[string]interface{} `json:"keys,omitempty"`
+}
+
+// FindDuplicates groups a table by ?columns (comma-separated) and returns the
+// value combinations occurring more than once, most frequent first. ?limit
+// caps the groups returned and ?sample the row keys shown per group.
+func (h *Handler) FindDuplicates(c *gin.Context) {
+	schema, ok := h.schemaParam(c)
+	if !ok {
+		return
+	}
+	tableName := c.Param("name")
+	if !h.requireTable(c, schema, tableName) || !h.requireRowLevel(c, schema, tableName) {
+		return
+	}
+	limit, ok := intQuery(c, "limit", defaultDuplicateGroups, h.maxRows(c.Request.Context()))
+	if !ok {
+		return
+	}
+	sample, ok := intQuery(c, "sample", defaultDuplicateSample, h.maxRows(c.Request.Context()))
+	if !ok {
+		return
+	}
+
+	tableColumns, err := h.dialect.ListColumns(h.db, schema, tableName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(tableColumns) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Table not found"})
+		return
+	}
+	access := rbac.FromContext(c.Request.Context())
+	grantSchema := h.grantSchema(schema)
+	var columns []string
+	for _, col := range strings.Split(c.Query("columns"), ",") {
+		if col = strings.TrimSpace(col); col == "" {
+			continue
+		}
+		if !slices.ContainsFunc(tableColumns, func(tc ColumnInfo) bool { return tc.Name == col }) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Column " + col + " not found"})
+			return
+		}
+		if !access.CanColumn(grantSchema, tableName, col) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied: column " + col + " is not granted"})
+			return
+		}
+		columns = append(columns, col)
+	}
+	if len(columns) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "columns is required"})
+		return
+	}
+
+	// Row keys are only shown when the caller may read the whole key
+	keyColumns, err := h.dialect.ListPrimaryKeys(h.db, schema, tableName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if slices.ContainsFunc(keyColumns, func(col string) bool { return !access.CanColumn(grantSchema, tableName, col) }) {
+		keyColumns = nil
+	}
+
+	ctx := c.Request.Context()
+	release, err := h.acquireStatement(ctx)
+	if err != nil {
+		respondQueryError(c, err)
+		return
+	}
+	defer release()
+
+	q := h.dialect.Quote
+	table := q(schema) + "." + q(tableName)
+	quoted := make([]string, len(columns))
+	for i, col := range columns {
+		quoted[i] = q(col)
+	}
+	groupBy := fmt.Sprintf("FROM %s GROUP BY %s HAVING COUNT(*) > 1", table, strings.Join(quoted, ", "))
+
+	var groupCount, rowCount int64
+	if err := h.db.QueryRowContext(ctx, "SELECT COUNT(*), COALESCE(SUM(n), 0) FROM (SELECT COUNT(*) AS n "+groupBy+") d").Scan(&groupCount, &rowCount); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	groups, err := h.duplicateGroups(ctx, fmt.Sprintf("SELECT %s, COUNT(*) %s ORDER BY COUNT(*) DESC %s",
+		strings.Join(quoted, ", "), groupBy, h.dialect.LimitClause(limit)), columns)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(keyColumns) > 0 && sample > 0 {
+		for i := range groups {
+			if groups[i].Keys, err = h.duplicateKeys(ctx, table, keyColumns, groups[i].Values, sample); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+		}
+	}
+
+	// Mask only after the keys were looked up by the real values
+	var roles []string
+	if access != nil {
+		roles = access.Roles
+	}
+	masked := h.masks.Columns(grantSchema, tableName, roles)
+	for _, g := range groups {
+		for col, v := range g.Values {
+			if m, ok := masked[strings.ToLower(col)]; ok {
+				g.Values[col] = h.masks.Apply(m, v)
+			}
+		}
+		for _, key := range g.Keys {
+			for col, v := range key {
+				if m, ok := masked[strings.ToLower(col)]; ok {
+					key[col] = h.masks.Apply(m, v)
+				}
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"schema":           schema,
+		"table_name":       tableName,
+		"columns":          columns,
+		"key_columns":      keyColumns,
+		"duplicate_groups": groupCount,
+		"duplicate_rows":   rowCount,
+		"groups":           groups,
+	})
+}
+
+// duplicateGroups scans the grouped values and the count following them
+func (h *Handler) duplicateGroups(ctx context.Context, query string, columns []string) ([]DuplicateGroup, error) {
+	rows, err := h.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := []DuplicateGroup{}
+	for rows.Next() {
+		vals := make([]interface{}, len(columns))
+		ptrs := make([]interface{}, len(columns)+1)
+		for i := range vals {
+			ptrs[i] = &vals[i]
+		}
+		var g DuplicateGroup
+		ptrs[len(columns)] = &g.Count
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		g.Values = map[string]interface{}{}
+		for i, col := range columns {
+			g.Values[col] = vals[i]
+		}
+		groups = append(groups, g)
+	}
+	return groups, rows.Err()
+}
+
+// duplicateKeys returns the primary keys of up to n rows holding values
+func (h *Handler) duplicateKeys(ctx context.Context, table string, keyColumns []string, values map[string]interface{}, n int) ([]map[string]interface{}, error) {
+	q := h.dialect.Quote
+	keys := make([]string, len(keyColumns))
+	for i, col := range keyColumns {
+		keys[i] = q(col)
+	}
+	var conds []string
+	var args []interface{}
+	for col, v := range values {
+		// GROUP BY puts NULLs together, but NULL = NULL is not true
+		if v == nil {
+			conds = append(conds, q(col)+" IS NULL")
+			continue
+		}
+		args = append(args, v)
+		conds = append(conds, q(col)+" = "+h.dialect.Placeholder(len(args)))
+	}
+
+	rows, err := h.db.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM %s WHERE %s ORDER BY %s %s",
+		strings.Join(keys, ", "), table, strings.Join(conds, " AND "), strings.Join(keys, ", "), h.dialect.LimitClause(n)), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	_, result, err := scanRowMaps(rows)
+	return result, err
+}
@@ -0,0 +1,552 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	sqlparser "github.com/blastrain/vitess-sqlparser/sqlparser"
+	"github.com/gin-gonic/gin"
+)
+
+// Selectivities assumed where statistics are missing, after PostgreSQL's
+// own defaults
+const (
+	defaultEqSelectivity    = 0.005
+	defaultRangeSelectivity = 1.0 / 3
+	defaultLikeSelectivity  = 0.05
+	defaultNullFraction     = 0.01
+	// defaultSelectivity covers conditions the estimator can't analyze,
+	// such as function calls and subqueries
+	defaultSelectivity = 0.5
+	// defaultTableRows stands in for tables and subqueries of unknown size
+	defaultTableRows = 1000
+	// defaultGroups is the number of distinct values of a grouped
+	// expression without statistics
+	defaultGroups = 200
+)
+
+// EstimateRequest is the body of POST /estimate
+type EstimateRequest struct {
+	SQL string `json:"sql" binding:"required"`
+	// Explain also runs the database's EXPLAIN, which the estimate itself
+	// doesn't need
+	Explain bool `json:"explain"`
+}
+
+// QueryEstimate is a rough prediction of what a SELECT reads and returns
+type QueryEstimate struct {
+	// Rows is the number of rows returned, after LIMIT or query.max_rows
+	Rows float64 `json:"rows"`
+	// ScannedRows is the number of table rows read, assuming sequential
+	// scans
+	ScannedRows float64 `json:"scanned_rows"`
+	// Cost is in row operations: rows scanned, joined, grouped and sorted.
+	// It is only meaningful compared to other estimates.
+	Cost   float64         `json:"cost"`
+	Tables []TableEstimate `json:"tables"`
+	// Limited is set when the result is cut at LIMIT or query.max_rows
+	Limited bool `json:"limited,omitempty"`
+	// Assumptions lists what was guessed for lack of statistics
+	Assumptions    []string                 `json:"assumptions,omitempty"`
+	StatsFetchedAt time.Time                `json:"stats_fetched_at"`
+	Plan           []map[string]interface{} `json:"plan,omitempty"`
+}
+
+// TableEstimate is the share of a table's rows the query's conditions keep
+type TableEstimate struct {
+	Schema      string  `json:"schema,omitempty"`
+	Table       string  `json:"table"`
+	Rows        float64 `json:"rows"`
+	Selectivity float64 `json:"selectivity"`
+}
+
+// schemaStats are the cached statistics of one schema's tables, by
+// lowercased table and column name
+type schemaStats struct {
+	rows      map[string]float64
+	columns   map[string]map[string]ColumnStats
+	fetchedAt time.Time
+}
+
+// plannerStats caches statistics so that estimates need no database round
+// trip. Stale entries are served while they are refreshed.
+type plannerStats struct {
+	mu         sync.Mutex
+	schemas    map[string]*schemaStats
+	refreshing map[string]bool
+}
+
+// statsFor returns the cached statistics of a schema, loading them the
+// first time and refreshing them in the background once older than
+// query.estimate_stats_ttl
+func (h *Handler) statsFor(schema string) (*schemaStats, error) {
+	p := &h.estimates
+	p.mu.Lock()
+	stats := p.schemas[schema]
+	if stats != nil && time.Since(stats.fetchedAt) > h.cfg.Query.EstimateStatsTTL && !p.refreshing[schema] {
+		if p.refreshing == nil {
+			p.refreshing = map[string]bool{}
+		}
+		p.refreshing[schema] = true
+		go func() {
+			if _, err := h.loadStats(schema); err != nil {
+				slog.Error("Refreshing planner statistics failed", "schema", schema, "error", err)
+			}
+			p.mu.Lock()
+			delete(p.refreshing, schema)
+			p.mu.Unlock()
+		}()
+	}
+	p.mu.Unlock()
+	if stats != nil {
+		return stats, nil
+	}
+	return h.loadStats(schema)
+}
+
+func (h *Handler) loadStats(schema string) (*schemaStats, error) {
+	tables, err := h.dialect.ListTableStats(h.db, schema, "")
+	if err != nil {
+		return nil, err
+	}
+	columns, err := h.dialect.ListColumnStats(h.db, schema)
+	if err != nil {
+		return nil, err
+	}
+	stats := &schemaStats{
+		rows:      map[string]float64{},
+		columns:   map[string]map[string]ColumnStats{},
+		fetchedAt: time.Now(),
+	}
+	for _, t := range tables {
+		if t.RowEstimate != nil {
+			stats.rows[strings.ToLower(t.Name)] = float64(*t.RowEstimate)
+		}
+	}
+	for _, col := range columns {
+		table := strings.ToLower(col.Table)
+		if stats.columns[table] == nil {
+			stats.columns[table] = map[string]ColumnStats{}
+		}
+		stats.columns[table][strings.ToLower(col.Column)] = col
+	}
+
+	p := &h.estimates
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.schemas == nil {
+		p.schemas = map[string]*schemaStats{}
+	}
+	p.schemas[schema] = stats
+	return stats, nil
+}
+
+// EstimateQuery gives an instant rough estimate of the rows a SELECT reads
+// and returns and of its cost, from cached planner statistics and the
+// parsed statement. It is meant to be called as the user types; with
+// "explain": true the database's actual plan is added.
+func (h *Handler) EstimateQuery(c *gin.Context) {
+	var req EstimateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sql is required"})
+		return
+	}
+	ctx := c.Request.Context()
+	prep, err := h.prepareSelect(ctx, req.SQL)
+	if err != nil {
+		respondQueryError(c, err)
+		return
+	}
+	est, err := h.estimate(ctx, prep.Stmt.(*sqlparser.Select))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load statistics: " + err.Error()})
+		return
+	}
+	if req.Explain {
+		if est.Plan, err = h.explain(ctx, prep.SQL); err != nil {
+			respondQueryError(c, &QueryError{Status: http.StatusUnprocessableEntity, Message: "EXPLAIN failed: " + err.Error()})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, est)
+}
+
+// estimator works out the estimate of one statement
+type estimator struct {
+	h           *Handler
+	tables      []*estimatedTable
+	assumptions []string
+}
+
+// estimatedTable is a table of the FROM clause with what is known of it
+type estimatedTable struct {
+	ref         tableRef
+	stats       *schemaStats
+	rows        float64
+	selectivity float64
+}
+
+// assume records a guess made for lack of statistics
+func (e *estimator) assume(assumption string) {
+	if !slices.Contains(e.assumptions, assumption) {
+		e.assumptions = append(e.assumptions, assumption)
+	}
+}
+
+func (h *Handler) estimate(ctx context.Context, sel *sqlparser.Select) (*QueryEstimate, error) {
+	e := &estimator{h: h}
+	var conditions []sqlparser.Expr
+	if sel.Where != nil {
+		conditions = append(conditions, conjuncts(sel.Where.Expr)...)
+	}
+	est := &QueryEstimate{Tables: []TableEstimate{}}
+	for _, expr := range sel.From {
+		on, err := e.addTables(expr)
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, on...)
+	}
+
+	// Each condition narrows the tables it reads; conditions across two
+	// tables are join conditions
+	joinSelectivity := 1.0
+	for _, cond := range conditions {
+		tables := e.tablesOf(cond)
+		switch len(tables) {
+		case 0:
+			continue
+		case 1:
+			tables[0].selectivity *= e.selectivity(cond, tables[0])
+		default:
+			joinSelectivity *= e.joinSelectivity(cond)
+		}
+	}
+
+	rows := 1.0
+	for _, t := range e.tables {
+		est.ScannedRows += t.rows
+		kept := t.rows * t.selectivity
+		est.Tables = append(est.Tables, TableEstimate{Schema: t.ref.Schema, Table: t.ref.Name, Rows: t.rows, Selectivity: t.selectivity})
+		rows *= kept
+		// Hash joins read both sides once more
+		if len(e.tables) > 1 {
+			est.Cost += kept
+		}
+	}
+	rows = max(rows*joinSelectivity, 0)
+	est.Cost += est.ScannedRows
+
+	// Grouping and DISTINCT
+	aggregates := false
+	sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		if f, ok := node.(*sqlparser.FuncExpr); ok && f.IsAggregate() {
+			aggregates = true
+		}
+		return !aggregates, nil
+	}, sel.SelectExprs)
+	switch {
+	case len(sel.GroupBy) > 0:
+		est.Cost += rows
+		rows = min(rows, e.groups(sel.GroupBy))
+	case aggregates:
+		est.Cost += rows
+		rows = min(rows, 1)
+	case sel.Distinct != "":
+		var exprs []sqlparser.Expr
+		for _, expr := range sel.SelectExprs {
+			if aliased, ok := expr.(*sqlparser.AliasedExpr); ok {
+				exprs = append(exprs, aliased.Expr)
+			}
+		}
+		est.Cost += rows
+		rows = min(rows, e.groups(exprs))
+	}
+	if sel.Having != nil {
+		rows *= defaultRangeSelectivity
+	}
+	if len(sel.OrderBy) > 0 && rows > 1 {
+		est.Cost += rows * math.Log2(rows)
+	}
+
+	limit := float64(h.maxRows(ctx))
+	if sel.Limit != nil {
+		if v, ok := sel.Limit.Rowcount.(*sqlparser.SQLVal); ok && v.Type == sqlparser.IntVal {
+			if n, err := strconv.ParseFloat(string(v.Val), 64); err == nil {
+				limit = n
+			}
+		}
+	}
+	if rows > limit {
+		rows, est.Limited = limit, true
+	}
+	est.Rows = math.Round(rows)
+	est.ScannedRows = math.Round(est.ScannedRows)
+	est.Cost = math.Round(est.Cost)
+	est.Assumptions = e.assumptions
+	for _, t := range e.tables {
+		if t.stats != nil && (est.StatsFetchedAt.IsZero() || t.stats.fetchedAt.Before(est.StatsFetchedAt)) {
+			est.StatsFetchedAt = t.stats.fetchedAt
+		}
+	}
+	return est, nil
+}
+
+// addTables adds the tables of a FROM item and returns its join conditions
+func (e *estimator) addTables(expr sqlparser.TableExpr) ([]sqlparser.Expr, error) {
+	switch expr := expr.(type) {
+	case *sqlparser.AliasedTableExpr:
+		t := &estimatedTable{selectivity: 1, rows: defaultTableRows}
+		name, ok := expr.Expr.(sqlparser.TableName)
+		if !ok {
+			t.ref = tableRef{Name: "(subquery)", Alias: expr.As.String()}
+			e.tables = append(e.tables, t)
+			e.assume("subqueries in FROM are assumed to return 1000 rows")
+			return nil, nil
+		}
+		t.ref = tableRef{Schema: name.Qualifier.String(), Name: name.Name.String(), Alias: expr.As.String()}
+		if t.ref.Alias == "" {
+			t.ref.Alias = t.ref.Name
+		}
+		schema := orDefault(e.h.dialect, t.ref.Schema)
+		stats, err := e.h.statsFor(schema)
+		if err != nil {
+			return nil, err
+		}
+		t.stats = stats
+		if rows, ok := stats.rows[strings.ToLower(t.ref.Name)]; ok {
+			t.rows = rows
+		} else {
+			e.assume("tables without statistics are assumed to hold 1000 rows")
+		}
+		e.tables = append(e.tables, t)
+	case *sqlparser.JoinTableExpr:
+		left, err := e.addTables(expr.LeftExpr)
+		if err != nil {
+			return nil, err
+		}
+		right, err := e.addTables(expr.RightExpr)
+		if err != nil {
+			return nil, err
+		}
+		conditions := append(left, right...)
+		if expr.On != nil {
+			conditions = append(conditions, conjuncts(expr.On)...)
+		}
+		return conditions, nil
+	case *sqlparser.ParenTableExpr:
+		var conditions []sqlparser.Expr
+		for _, inner := range expr.Exprs {
+			on, err := e.addTables(inner)
+			if err != nil {
+				return nil, err
+			}
+			conditions = append(conditions, on...)
+		}
+		return conditions, nil
+	}
+	return nil, nil
+}
+
+// conjuncts splits a condition on its top-level ANDs
+func conjuncts(expr sqlparser.Expr) []sqlparser.Expr {
+	switch expr := expr.(type) {
+	case *sqlparser.AndExpr:
+		return append(conjuncts(expr.Left), conjuncts(expr.Right)...)
+	case *sqlparser.ParenExpr:
+		if _, ok := expr.Expr.(*sqlparser.AndExpr); ok {
+			return conjuncts(expr.Expr)
+		}
+	}
+	return []sqlparser.Expr{expr}
+}
+
+// table resolves the table a column belongs to, or nil
+func (e *estimator) table(col *sqlparser.ColName) *estimatedTable {
+	if !col.Qualifier.IsEmpty() {
+		for _, t := range e.tables {
+			if strings.EqualFold(t.ref.Alias, col.Qualifier.Name.String()) {
+				return t
+			}
+		}
+		return nil
+	}
+	if len(e.tables) == 1 {
+		return e.tables[0]
+	}
+	for _, t := range e.tables {
+		if t.stats == nil {
+			continue
+		}
+		if _, ok := t.stats.columns[strings.ToLower(t.ref.Name)][col.Name.Lowered()]; ok {
+			return t
+		}
+	}
+	return nil
+}
+
+// tablesOf lists the distinct tables a condition reads columns of
+func (e *estimator) tablesOf(expr sqlparser.Expr) []*estimatedTable {
+	var tables []*estimatedTable
+	sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		switch node := node.(type) {
+		case *sqlparser.Subquery:
+			return false, nil
+		case *sqlparser.ColName:
+			t := e.table(node)
+			if t == nil {
+				return false, nil
+			}
+			for _, seen := range tables {
+				if seen == t {
+					return false, nil
+				}
+			}
+			tables = append(tables, t)
+		}
+		return true, nil
+	}, expr)
+	if len(tables) == 0 && len(e.tables) == 1 {
+		// e.g. EXISTS (...) narrows the only table
+		return e.tables
+	}
+	return tables
+}
+
+// columnStats returns the statistics of a column of t, if gathered
+func (e *estimator) columnStats(t *estimatedTable, expr sqlparser.Expr) (ColumnStats, bool) {
+	col, ok := expr.(*sqlparser.ColName)
+	if !ok || t.stats == nil {
+		return ColumnStats{}, false
+	}
+	st, ok := t.stats.columns[strings.ToLower(t.ref.Name)][col.Name.Lowered()]
+	return st, ok && st.Distinct > 0
+}
+
+// eqSelectivity is the share of rows of t where a column equals one value
+func (e *estimator) eqSelectivity(t *estimatedTable, expr sqlparser.Expr) float64 {
+	if st, ok := e.columnStats(t, expr); ok {
+		return (1 - st.NullFraction) / st.Distinct
+	}
+	e.assume("equality on columns without statistics keeps 0.5% of rows")
+	return defaultEqSelectivity
+}
+
+// selectivity estimates the share of t's rows a condition on t keeps
+func (e *estimator) selectivity(expr sqlparser.Expr, t *estimatedTable) float64 {
+	switch expr := expr.(type) {
+	case *sqlparser.AndExpr:
+		return e.selectivity(expr.Left, t) * e.selectivity(expr.Right, t)
+	case *sqlparser.OrExpr:
+		l, r := e.selectivity(expr.Left, t), e.selectivity(expr.Right, t)
+		return l + r - l*r
+	case *sqlparser.NotExpr:
+		return 1 - e.selectivity(expr.Expr, t)
+	case *sqlparser.ParenExpr:
+		return e.selectivity(expr.Expr, t)
+	case *sqlparser.ComparisonExpr:
+		col := expr.Left
+		if _, ok := col.(*sqlparser.ColName); !ok {
+			col = expr.Right
+		}
+		switch expr.Operator {
+		case sqlparser.EqualStr, sqlparser.NullSafeEqualStr:
+			return e.eqSelectivity(t, col)
+		case sqlparser.NotEqualStr:
+			return 1 - e.eqSelectivity(t, col)
+		case sqlparser.LessThanStr, sqlparser.GreaterThanStr, sqlparser.LessEqualStr, sqlparser.GreaterEqualStr:
+			return defaultRangeSelectivity
+		case sqlparser.InStr, sqlparser.NotInStr:
+			s := defaultSelectivity
+			if values, ok := expr.Right.(sqlparser.ValTuple); ok {
+				s = min(1, float64(len(values))*e.eqSelectivity(t, expr.Left))
+			} else {
+				e.assume("IN (subquery) keeps half of the rows")
+			}
+			if expr.Operator == sqlparser.NotInStr {
+				return 1 - s
+			}
+			return s
+		case sqlparser.LikeStr, sqlparser.RegexpStr:
+			return defaultLikeSelectivity
+		case sqlparser.NotLikeStr, sqlparser.NotRegexpStr:
+			return 1 - defaultLikeSelectivity
+		}
+	case *sqlparser.RangeCond:
+		s := defaultRangeSelectivity * defaultRangeSelectivity
+		if expr.Operator == sqlparser.NotBetweenStr {
+			return 1 - s
+		}
+		return s
+	case *sqlparser.IsExpr:
+		nulls := defaultNullFraction
+		if st, ok := e.columnStats(t, expr.Expr); ok {
+			nulls = st.NullFraction
+		}
+		switch expr.Operator {
+		case sqlparser.IsNullStr:
+			return nulls
+		case sqlparser.IsNotNullStr:
+			return 1 - nulls
+		}
+	}
+	e.assume("conditions the estimator can't analyze keep half of the rows")
+	return defaultSelectivity
+}
+
+// joinSelectivity estimates the share of the combined rows of two tables a
+// condition across them keeps. An equijoin matches each row with the rows
+// sharing its value, so it keeps 1 / the larger distinct count.
+func (e *estimator) joinSelectivity(expr sqlparser.Expr) float64 {
+	cmp, ok := expr.(*sqlparser.ComparisonExpr)
+	if !ok || cmp.Operator != sqlparser.EqualStr {
+		return defaultRangeSelectivity
+	}
+	distinct := 0.0
+	for _, side := range []sqlparser.Expr{cmp.Left, cmp.Right} {
+		col, ok := side.(*sqlparser.ColName)
+		if !ok {
+			continue
+		}
+		t := e.table(col)
+		if t == nil {
+			continue
+		}
+		if st, ok := e.columnStats(t, col); ok {
+			distinct = max(distinct, st.Distinct)
+		} else {
+			// Without statistics the column is taken as a key
+			distinct = max(distinct, t.rows)
+		}
+	}
+	if distinct < 1 {
+		return defaultEqSelectivity
+	}
+	return 1 / distinct
+}
+
+// groups estimates the number of distinct combinations of expressions
+func (e *estimator) groups(exprs []sqlparser.Expr) float64 {
+	groups := 1.0
+	for _, expr := range exprs {
+		n := float64(defaultGroups)
+		if col, ok := expr.(*sqlparser.ColName); ok {
+			if t := e.table(col); t != nil {
+				if st, ok := e.columnStats(t, col); ok {
+					n = st.Distinct
+				} else {
+					e.assume("grouped columns without statistics hold 200 distinct values")
+				}
+			}
+		}
+		groups *= n
+	}
+	return groups
+}
@@ -0,0 +1,261 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	exportPlainName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	exportNonWord   = regexp.MustCompile(`[^A-Za-z0-9_]+`)
+)
+
+// ExportSchema converts the introspected schema to DBML, a Mermaid ER
+// diagram or GraphQL SDL, as chosen by ?format
+func (h *Handler) ExportSchema(c *gin.Context) {
+	schema, ok := h.schemaParam(c)
+	if !ok {
+		return
+	}
+	var render func([]TableSchema, []GraphEdge) string
+	switch c.Query("format") {
+	case "dbml":
+		render = exportDBML
+	case "mermaid":
+		render = exportMermaid
+	case "graphql":
+		render = exportGraphQL
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be dbml, mermaid or graphql"})
+		return
+	}
+
+	tables, err := h.visibleSchema(c, schema)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	_, edges := schemaGraph(tables)
+	c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(render(tables, edges)))
+}
+
+func exportDBML(tables []TableSchema, edges []GraphEdge) string {
+	name := func(s string) string {
+		if exportPlainName.MatchString(s) {
+			return s
+		}
+		return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+	}
+	note := func(s string) string {
+		return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`, "\n", `\n`).Replace(s) + "'"
+	}
+
+	var b strings.Builder
+	for _, t := range tables {
+		fmt.Fprintf(&b, "Table %s.%s {\n", name(t.Schema), name(t.Name))
+		for _, col := range t.Columns {
+			var settings []string
+			if len(t.PrimaryKeys) == 1 && t.PrimaryKeys[0] == col.Name {
+				settings = append(settings, "pk")
+			}
+			if col.IsNullable == "NO" {
+				settings = append(settings, "not null")
+			}
+			if uniqueColumn(t, col.Name) && !slices.Contains(settings, "pk") {
+				settings = append(settings, "unique")
+			}
+			if col.Default != nil {
+				settings = append(settings, "default: `"+strings.ReplaceAll(*col.Default, "`", "")+"`")
+			}
+			if col.Comment != "" {
+				settings = append(settings, "note: "+note(col.Comment))
+			}
+			fmt.Fprintf(&b, "  %s %s", name(col.Name), name(col.DataType))
+			if len(settings) > 0 {
+				fmt.Fprintf(&b, " [%s]", strings.Join(settings, ", "))
+			}
+			b.WriteString("\n")
+		}
+		if len(t.PrimaryKeys) > 1 {
+			keys := make([]string, len(t.PrimaryKeys))
+			for i, k := range t.PrimaryKeys {
+				keys[i] = name(k)
+			}
+			fmt.Fprintf(&b, "\n  indexes {\n    (%s) [pk]\n  }\n", strings.Join(keys, ", "))
+		}
+		if t.Comment != "" {
+			fmt.Fprintf(&b, "\n  Note: %s\n", note(t.Comment))
+		}
+		b.WriteString("}\n\n")
+	}
+	for _, e := range edges {
+		rel := ">"
+		if e.Cardinality == "one-to-one" {
+			rel = "-"
+		}
+		from, to := strings.SplitN(e.From, ".", 2), strings.SplitN(e.To, ".", 2)
+		fmt.Fprintf(&b, "Ref: %s.%s.%s %s %s.%s.%s\n",
+			name(from[0]), name(from[1]), name(e.FromColumn), rel, name(to[0]), name(to[1]), name(e.ToColumn))
+	}
+	return b.String()
+}
+
+func exportMermaid(tables []TableSchema, edges []GraphEdge) string {
+	// Entity names are unqualified unless the tables span several schemas
+	qualify := slices.ContainsFunc(tables, func(t TableSchema) bool { return t.Schema != tables[0].Schema })
+	entity := func(schema, table string) string {
+		if qualify {
+			table = schema + "_" + table
+		}
+		return exportNonWord.ReplaceAllString(table, "_")
+	}
+
+	var b strings.Builder
+	b.WriteString("erDiagram\n")
+	for _, t := range tables {
+		fmt.Fprintf(&b, "    %s {\n", entity(t.Schema, t.Name))
+		for _, col := range t.Columns {
+			var keys []string
+			if slices.Contains(t.PrimaryKeys, col.Name) {
+				keys = append(keys, "PK")
+			}
+			if slices.ContainsFunc(t.ForeignKeys, func(fk ForeignKeyInfo) bool { return fk.Column == col.Name }) {
+				keys = append(keys, "FK")
+			}
+			if uniqueColumn(t, col.Name) && !slices.Contains(keys, "PK") {
+				keys = append(keys, "UK")
+			}
+			dataType := strings.Trim(exportNonWord.ReplaceAllString(col.DataType, "_"), "_")
+			if dataType == "" {
+				dataType = "unknown"
+			}
+			fmt.Fprintf(&b, "        %s %s", dataType, exportNonWord.ReplaceAllString(col.Name, "_"))
+			if len(keys) > 0 {
+				b.WriteString(" " + strings.Join(keys, ","))
+			}
+			if col.Comment != "" {
+				fmt.Fprintf(&b, " %q", strings.ReplaceAll(col.Comment, `"`, "'"))
+			}
+			b.WriteString("\n")
+		}
+		b.WriteString("    }\n")
+	}
+	for _, e := range edges {
+		// Parent side: exactly one, or zero or one when the reference is
+		// nullable; child side: many, or at most one for one-to-one keys
+		parent, child := "||", "o{"
+		if e.Optional {
+			parent = "|o"
+		}
+		if e.Cardinality == "one-to-one" {
+			child = "o|"
+		}
+		from, to := strings.SplitN(e.From, ".", 2), strings.SplitN(e.To, ".", 2)
+		fmt.Fprintf(&b, "    %s %s--%s %s : %q\n", entity(to[0], to[1]), parent, child, entity(from[0], from[1]), e.FromColumn)
+	}
+	return b.String()
+}
+
+// exportGraphQL declares an object type per table. Foreign keys add a field
+// resolving to the referenced row and a list of referencing rows on the
+// other side, unless their names are already taken by columns.
+func exportGraphQL(tables []TableSchema, edges []GraphEdge) string {
+	typeNames := map[string]string{}
+	for _, t := range tables {
+		typeNames[t.Schema+"."+t.Name] = graphQLTypeName(t.Name)
+	}
+
+	var b strings.Builder
+	for _, t := range tables {
+		id := t.Schema + "." + t.Name
+		if t.Comment != "" {
+			fmt.Fprintf(&b, "%q\n", t.Comment)
+		}
+		fmt.Fprintf(&b, "type %s {\n", typeNames[id])
+		fields := map[string]bool{}
+		for _, col := range t.Columns {
+			field := graphQLName(col.Name)
+			fields[field] = true
+			fieldType := graphQLType(col.DataType)
+			if len(t.PrimaryKeys) == 1 && t.PrimaryKeys[0] == col.Name {
+				fieldType = "ID"
+			}
+			if col.IsNullable == "NO" || slices.Contains(t.PrimaryKeys, col.Name) {
+				fieldType += "!"
+			}
+			if col.Comment != "" {
+				fmt.Fprintf(&b, "  %q\n", col.Comment)
+			}
+			fmt.Fprintf(&b, "  %s: %s\n", field, fieldType)
+		}
+		for _, e := range edges {
+			switch id {
+			case e.From:
+				field := graphQLName(strings.TrimSuffix(strings.TrimSuffix(e.FromColumn, "_id"), "_ID"))
+				if field == graphQLName(e.FromColumn) {
+					field = graphQLName(strings.SplitN(e.To, ".", 2)[1])
+				}
+				if fields[field] {
+					continue
+				}
+				fields[field] = true
+				fieldType := typeNames[e.To]
+				if !e.Optional {
+					fieldType += "!"
+				}
+				fmt.Fprintf(&b, "  %s: %s\n", field, fieldType)
+			case e.To:
+				field := graphQLName(strings.SplitN(e.From, ".", 2)[1])
+				if fields[field] {
+					continue
+				}
+				fields[field] = true
+				if e.Cardinality == "one-to-one" {
+					fmt.Fprintf(&b, "  %s: %s\n", field, typeNames[e.From])
+				} else {
+					fmt.Fprintf(&b, "  %s: [%s!]!\n", field, typeNames[e.From])
+				}
+			}
+		}
+		b.WriteString("}\n\n")
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// graphQLName turns a SQL identifier into a valid GraphQL name
+func graphQLName(s string) string {
+	s = exportNonWord.ReplaceAllString(s, "_")
+	if s == "" || (s[0] >= '0' && s[0] <= '9') {
+		s = "_" + s
+	}
+	return s
+}
+
+// graphQLTypeName converts snake_case table names to PascalCase
+func graphQLTypeName(table string) string {
+	var b strings.Builder
+	for _, part := range strings.FieldsFunc(table, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9')
+	}) {
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return graphQLName(b.String())
+}
+
+func graphQLType(dataType string) string {
+	t := strings.ToLower(dataType)
+	switch {
+	case strings.Contains(t, "bool"):
+		return "Boolean"
+	case strings.Contains(t, "int") && !strings.Contains(t, "interval") && !strings.Contains(t, "point"):
+		return "Int"
+	case isNumericType(t):
+		return "Float"
+	}
+	return "String"
+}
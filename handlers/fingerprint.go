@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	sqlparser "github.com/blastrain/vitess-sqlparser/sqlparser"
+)
+
+// fingerprint normalizes a statement by replacing literals with placeholders
+// and collapsing literal lists, so queries differing only in their constants
+// share a fingerprint. It returns the normalized text and its hash.
+func fingerprint(stmt sqlparser.Statement) (normalized, hash string) {
+	buf := sqlparser.NewTrackedBuffer(func(buf *sqlparser.TrackedBuffer, node sqlparser.SQLNode) {
+		switch node := node.(type) {
+		case *sqlparser.SQLVal, sqlparser.BoolVal, *sqlparser.NullVal:
+			buf.WriteString("?")
+		case sqlparser.ValTuple:
+			if literalTuple(node) {
+				buf.WriteString("(?)")
+				return
+			}
+			node.Format(buf)
+		default:
+			node.Format(buf)
+		}
+	})
+	buf.WriteNode(stmt)
+
+	normalized = strings.ToLower(buf.String())
+	sum := sha256.Sum256([]byte(normalized))
+	return normalized, hex.EncodeToString(sum[:8])
+}
+
+func literalTuple(tuple sqlparser.ValTuple) bool {
+	for _, expr := range tuple {
+		switch expr.(type) {
+		case *sqlparser.SQLVal, sqlparser.BoolVal, *sqlparser.NullVal:
+		default:
+			return false
+		}
+	}
+	return true
+}
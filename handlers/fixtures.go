@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/goccy/go-yaml"
+)
+
+// FixtureTable reports what loading fixtures did to one table
+type FixtureTable struct {
+	Table    string `json:"table"`
+	Deleted  int64  `json:"deleted"`
+	Inserted int    `json:"inserted"`
+}
+
+// LoadFixtures loads a JSON or YAML object of table names to arrays of rows
+// into the tables of a schema in one transaction. Tables are filled after
+// the tables they reference, and with ?truncate=true emptied first in the
+// reverse order. Sequences owned by their columns are then moved past the
+// loaded keys.
+func (h *Handler) LoadFixtures(c *gin.Context) {
+	if !h.cfg.Fixtures.Enabled {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Fixtures are disabled"})
+		return
+	}
+	schema, ok := h.schemaParam(c)
+	if !ok {
+		return
+	}
+	truncate := c.Query("truncate") == "true"
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read body: " + err.Error()})
+		return
+	}
+	if mime := c.ContentType(); mime == mimeYAML || mediaAliases[mime] == mimeYAML {
+		if body, err = yaml.YAMLToJSON(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid YAML: " + err.Error()})
+			return
+		}
+	}
+	var fixtures map[string][]map[string]interface{}
+	if err := decodeJSONNumbers(body, &fixtures); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid fixtures: expected an object of table names to arrays of rows"})
+		return
+	}
+	if len(fixtures) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No tables to load"})
+		return
+	}
+	total := 0
+	for _, rows := range fixtures {
+		total += len(rows)
+	}
+	if total > h.cfg.Fixtures.MaxRows {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("At most %d rows may be loaded at once", h.cfg.Fixtures.MaxRows)})
+		return
+	}
+
+	targets := make(map[string]*writeTarget, len(fixtures))
+	refs := make(map[string][]string, len(fixtures))
+	for table := range fixtures {
+		if !identPattern.MatchString(table) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Table " + table + " must be a plain identifier"})
+			return
+		}
+		if matchesAny(h.cfg.Query.Denylist.Tables, table) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Denied: table " + table + " is not allowed"})
+			return
+		}
+		columns, err := h.dialect.ListColumns(h.db, schema, table)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if len(columns) == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Table " + table + " not found"})
+			return
+		}
+		targets[table] = &writeTarget{Schema: schema, Table: table, Columns: columns}
+		fks, err := h.dialect.ListForeignKeys(h.db, schema, table)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		for _, fk := range fks {
+			if _, ok := fixtures[fk.ForeignTable]; ok && orDefault(h.dialect, fk.ForeignSchema) == schema {
+				refs[table] = append(refs[table], fk.ForeignTable)
+			}
+		}
+	}
+	order := fixtureOrder(slices.Sorted(maps.Keys(fixtures)), refs)
+	sequences, err := h.dialect.ListSequences(h.db, schema)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	sequences = slices.DeleteFunc(sequences, func(s SequenceInfo) bool {
+		return s.OwnedBy == nil || targets[s.OwnedBy.Table] == nil
+	})
+
+	q := h.dialect.Quote
+	var loaded []FixtureTable
+	retries, ok := h.writeTx(c, func(ctx context.Context, tx *sql.Tx) error {
+		loaded = make([]FixtureTable, len(order))
+		for i, table := range order {
+			loaded[i].Table = table
+		}
+		if truncate {
+			for i := len(order) - 1; i >= 0; i-- {
+				res, err := tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s.%s", q(schema), q(order[i])))
+				if err != nil {
+					return &QueryError{Status: http.StatusBadRequest, Message: fmt.Sprintf("Emptying %s failed: %s", order[i], err), Err: err}
+				}
+				loaded[i].Deleted, _ = res.RowsAffected()
+			}
+		}
+		for i, table := range order {
+			if err := h.insertFixtures(c, ctx, tx, targets[table], fixtures[table]); err != nil {
+				return err
+			}
+			loaded[i].Inserted = len(fixtures[table])
+		}
+		for _, seq := range sequences {
+			if err := h.dialect.ResetSequence(ctx, tx, seq); err != nil {
+				return fmt.Errorf("resetting sequence %s: %w", seq.Name, err)
+			}
+		}
+		return nil
+	})
+	if !ok {
+		return
+	}
+	resp := gin.H{"tables": loaded}
+	if retries > 0 {
+		resp["retries"] = retries
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// insertFixtures inserts the rows of one table
+func (h *Handler) insertFixtures(c *gin.Context, ctx context.Context, tx *sql.Tx, t *writeTarget, rows []map[string]interface{}) error {
+	q := h.dialect.Quote
+	for i, row := range rows {
+		cols, args, err := h.writeValues(c, t, row)
+		if err != nil {
+			return &QueryError{Status: http.StatusBadRequest, Message: fmt.Sprintf("%s row %d: %s", t.Table, i+1, err)}
+		}
+		quoted := make([]string, len(cols))
+		marks := make([]string, len(cols))
+		for j, col := range cols {
+			quoted[j] = q(col)
+			marks[j] = h.dialect.Placeholder(j + 1)
+		}
+		stmt := fmt.Sprintf("INSERT INTO %s.%s (%s) VALUES (%s)", q(t.Schema), q(t.Table), strings.Join(quoted, ", "), strings.Join(marks, ", "))
+		if len(cols) == 0 {
+			stmt = fmt.Sprintf("INSERT INTO %s.%s DEFAULT VALUES", q(t.Schema), q(t.Table))
+		}
+		if _, err := tx.ExecContext(ctx, stmt, args...); err != nil {
+			return &QueryError{Status: http.StatusBadRequest, Message: fmt.Sprintf("%s row %d: Insert failed: %s", t.Table, i+1, err), Err: err}
+		}
+	}
+	return nil
+}
+
+// fixtureOrder sorts tables so that each comes after the tables it
+// references, keeping the given order otherwise. Tables in a reference
+// cycle come last, in the given order, and rely on the database deferring
+// their constraints.
+func fixtureOrder(tables []string, refs map[string][]string) []string {
+	done := make(map[string]bool, len(tables))
+	var order []string
+	for len(order) < len(tables) {
+		progress := false
+		for _, t := range tables {
+			ready := !done[t]
+			for _, ref := range refs[t] {
+				ready = ready && (ref == t || done[ref])
+			}
+			if ready {
+				done[t] = true
+				order = append(order, t)
+				progress = true
+			}
+		}
+		if !progress {
+			for _, t := range tables {
+				if !done[t] {
+					order = append(order, t)
+				}
+			}
+			break
+		}
+	}
+	return order
+}
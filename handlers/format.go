@@ -0,0 +1,430 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	sqlparser "github.com/blastrain/vitess-sqlparser/sqlparser"
+	"github.com/gin-gonic/gin"
+)
+
+// formatKeywords are recased by the formatter. Identifiers spelled like
+// them are quoted, so that recasing never touches a name.
+var formatKeywords = map[string]bool{}
+
+func init() {
+	for _, k := range sqlKeywords {
+		formatKeywords[strings.ToLower(k)] = true
+	}
+	for _, k := range []string{
+		"binary", "char", "collate", "create", "delete", "div", "dual", "escape", "for", "insert", "interval",
+		"lock", "mod", "mode", "regexp", "set", "share", "straight_join", "table", "unsigned", "update",
+	} {
+		formatKeywords[k] = true
+	}
+}
+
+// FormatRequest is the body of POST /format
+type FormatRequest struct {
+	SQL string `json:"sql"`
+	// KeywordCase is upper (the default) or lower
+	KeywordCase string `json:"keyword_case"`
+	// Indent is the number of spaces per level, 2 by default; Tabs indents
+	// with one tab per level instead
+	Indent *int `json:"indent"`
+	Tabs   bool `json:"tabs"`
+	// Compact puts each statement on a single line
+	Compact bool `json:"compact"`
+}
+
+// FormatSQL pretty-prints SQL from its syntax tree: one clause per line,
+// selected columns and joins indented, keywords recased and identifiers
+// quoted only where needed. Comments before a statement are kept; those
+// inside it are dropped, with a warning.
+func (h *Handler) FormatSQL(c *gin.Context) {
+	var req FormatRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		return
+	}
+	if strings.TrimSpace(req.SQL) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "SQL cannot be empty"})
+		return
+	}
+	f := &sqlFormatter{dialect: h.dialect, compact: req.Compact, unit: "  "}
+	switch req.KeywordCase {
+	case "", "upper":
+		f.upper = true
+	case "lower":
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "keyword_case must be upper or lower"})
+		return
+	}
+	switch {
+	case req.Tabs:
+		f.unit = "\t"
+	case req.Indent != nil:
+		if *req.Indent < 0 || *req.Indent > 8 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "indent must be between 0 and 8"})
+			return
+		}
+		f.unit = strings.Repeat(" ", *req.Indent)
+	}
+
+	pieces := splitStatements(req.SQL)
+	var out []string
+	warnings := []string{}
+	for i, p := range pieces {
+		stmt, err := h.dialect.ParseStatement(quoteIdentsForParser(p.body))
+		if err != nil {
+			msg := "SQL syntax error: " + err.Error()
+			if len(pieces) > 1 {
+				msg = fmt.Sprintf("Statement %d: %s", i+1, msg)
+			}
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+		if p.innerComments {
+			warnings = append(warnings, fmt.Sprintf("Comments inside statement %d were dropped", i+1))
+		}
+		out = append(out, p.leading+f.format(stmt)+";")
+	}
+	c.JSON(http.StatusOK, gin.H{"sql": strings.Join(out, "\n\n"), "warnings": warnings})
+}
+
+// sqlFormatter writes statements with its layout and keyword case
+type sqlFormatter struct {
+	dialect Dialect
+	upper   bool
+	compact bool
+	unit    string
+	depth   int
+}
+
+func (f *sqlFormatter) format(stmt sqlparser.Statement) string {
+	buf := sqlparser.NewTrackedBuffer(f.node)
+	buf.Myprintf("%v", stmt)
+	return recaseKeywords(buf.String(), f.upper)
+}
+
+// nl breaks the line before something extra levels deeper than the
+// statement; in compact mode it is a space
+func (f *sqlFormatter) nl(extra int) string {
+	if f.compact {
+		return " "
+	}
+	return "\n" + strings.Repeat(f.unit, f.depth+extra)
+}
+
+func (f *sqlFormatter) node(buf *sqlparser.TrackedBuffer, node sqlparser.SQLNode) {
+	switch node := node.(type) {
+	case sqlparser.ColIdent:
+		buf.WriteString(f.ident(node.String()))
+	case sqlparser.TableIdent:
+		buf.WriteString(f.ident(node.String()))
+	case *sqlparser.SQLVal:
+		// Standard SQL strings double quotes instead of escaping them
+		if node.Type == sqlparser.StrVal {
+			buf.WriteString("'" + strings.ReplaceAll(string(node.Val), "'", "''") + "'")
+			return
+		}
+		node.Format(buf)
+	case *sqlparser.Select:
+		f.selectStmt(buf, node)
+	case *sqlparser.Union:
+		buf.Myprintf("%v%s%s%s%v", node.Left, f.nl(0), node.Type, f.nl(0), node.Right)
+		f.orderLimit(buf, node.OrderBy, node.Limit)
+		buf.WriteString(node.Lock)
+	case *sqlparser.Subquery:
+		f.depth++
+		inner := f.nl(0)
+		buf.Myprintf("(%s%v", strings.TrimPrefix(inner, " "), node.Select)
+		f.depth--
+		buf.Myprintf("%s)", strings.TrimSuffix(f.nl(0), " "))
+	case *sqlparser.JoinTableExpr:
+		buf.Myprintf("%v%s%s %v", node.LeftExpr, f.nl(1), node.Join, node.RightExpr)
+		if node.On != nil {
+			buf.Myprintf(" on %v", node.On)
+		}
+	case *sqlparser.Order:
+		if node.Direction == sqlparser.AscScr {
+			buf.Myprintf("%v", node.Expr)
+			return
+		}
+		node.Format(buf)
+	case *sqlparser.Limit:
+		// LIMIT n OFFSET m rather than MySQL's LIMIT m, n
+		if node != nil {
+			buf.Myprintf(" limit %v", node.Rowcount)
+			if node.Offset != nil {
+				buf.Myprintf(" offset %v", node.Offset)
+			}
+		}
+	default:
+		node.Format(buf)
+	}
+}
+
+func (f *sqlFormatter) selectStmt(buf *sqlparser.TrackedBuffer, node *sqlparser.Select) {
+	buf.WriteString("select")
+	if mods := strings.TrimSpace(node.Cache + node.Distinct + node.Hints); mods != "" {
+		buf.WriteString(" " + mods)
+	}
+	for i, expr := range node.SelectExprs {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		if len(node.SelectExprs) == 1 {
+			buf.Myprintf(" %v", expr)
+		} else {
+			buf.Myprintf("%s%v", f.nl(1), expr)
+		}
+	}
+	// The parser reads SELECT without FROM as selecting from dual
+	if !isDual(node.From) {
+		buf.Myprintf("%sfrom ", f.nl(0))
+		for i, t := range node.From {
+			if i > 0 {
+				buf.Myprintf(",%s", f.nl(1))
+			}
+			buf.Myprintf("%v", t)
+		}
+	}
+	if node.Where != nil && node.Where.Expr != nil {
+		buf.Myprintf("%swhere ", f.nl(0))
+		f.conditions(buf, node.Where.Expr)
+	}
+	if len(node.GroupBy) > 0 {
+		buf.Myprintf("%sgroup by ", f.nl(0))
+		for i, expr := range node.GroupBy {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			buf.Myprintf("%v", expr)
+		}
+	}
+	if node.Having != nil && node.Having.Expr != nil {
+		buf.Myprintf("%shaving ", f.nl(0))
+		f.conditions(buf, node.Having.Expr)
+	}
+	f.orderLimit(buf, node.OrderBy, node.Limit)
+	buf.WriteString(node.Lock)
+}
+
+// conditions writes the terms of a top-level AND chain on lines of their own
+func (f *sqlFormatter) conditions(buf *sqlparser.TrackedBuffer, expr sqlparser.Expr) {
+	var terms []sqlparser.Expr
+	var flatten func(sqlparser.Expr)
+	flatten = func(e sqlparser.Expr) {
+		if and, ok := e.(*sqlparser.AndExpr); ok {
+			flatten(and.Left)
+			flatten(and.Right)
+			return
+		}
+		terms = append(terms, e)
+	}
+	flatten(expr)
+	for i, term := range terms {
+		if i > 0 {
+			buf.Myprintf("%sand ", f.nl(1))
+		}
+		buf.Myprintf("%v", term)
+	}
+}
+
+func (f *sqlFormatter) orderLimit(buf *sqlparser.TrackedBuffer, orderBy sqlparser.OrderBy, limit *sqlparser.Limit) {
+	if len(orderBy) > 0 {
+		buf.Myprintf("%sorder by ", f.nl(0))
+		for i, o := range orderBy {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			buf.Myprintf("%v", o)
+		}
+	}
+	if limit != nil {
+		buf.Myprintf("%slimit %v", f.nl(0), limit.Rowcount)
+		if limit.Offset != nil {
+			buf.Myprintf(" offset %v", limit.Offset)
+		}
+	}
+}
+
+// ident writes a name as is when it needs no quoting
+func (f *sqlFormatter) ident(name string) string {
+	if identPattern.MatchString(name) && !formatKeywords[strings.ToLower(name)] &&
+		!strings.HasPrefix(sqlparser.String(sqlparser.NewColIdent(name)), "`") {
+		return name
+	}
+	return f.dialect.Quote(name)
+}
+
+func isDual(from sqlparser.TableExprs) bool {
+	if len(from) != 1 {
+		return false
+	}
+	t, ok := from[0].(*sqlparser.AliasedTableExpr)
+	if !ok {
+		return false
+	}
+	name, ok := t.Expr.(sqlparser.TableName)
+	return ok && name.Qualifier.IsEmpty() && name.Name.String() == "dual"
+}
+
+// recaseKeywords changes the case of keywords outside quotes
+func recaseKeywords(s string, upper bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		ch := s[i]
+		switch {
+		case ch == '\'' || ch == '"' || ch == '`':
+			j := i + 1
+			for j < len(s) {
+				if s[j] == ch {
+					if j+1 < len(s) && s[j+1] == ch {
+						j += 2
+						continue
+					}
+					break
+				}
+				j++
+			}
+			end := min(j+1, len(s))
+			b.WriteString(s[i:end])
+			i = end
+		case isWordByte(ch):
+			j := i
+			for j < len(s) && isWordByte(s[j]) {
+				j++
+			}
+			word := s[i:j]
+			if formatKeywords[strings.ToLower(word)] {
+				if upper {
+					word = strings.ToUpper(word)
+				} else {
+					word = strings.ToLower(word)
+				}
+			}
+			b.WriteString(word)
+			i = j
+		default:
+			b.WriteByte(ch)
+			i++
+		}
+	}
+	return b.String()
+}
+
+// sqlPiece is one statement of a script
+type sqlPiece struct {
+	// leading holds the comments before the statement, ending in a newline
+	leading string
+	body    string
+	// innerComments is set when the statement itself has comments
+	innerComments bool
+}
+
+// splitStatements splits a script at semicolons outside of quotes and
+// comments, separating each statement's leading comments
+func splitStatements(s string) []sqlPiece {
+	var pieces []sqlPiece
+	var cur sqlPiece
+	var leading, body strings.Builder
+	flush := func() {
+		cur.leading, cur.body = leading.String(), strings.TrimSpace(body.String())
+		if cur.body != "" {
+			pieces = append(pieces, cur)
+		}
+		cur = sqlPiece{}
+		leading.Reset()
+		body.Reset()
+	}
+	for i := 0; i < len(s); {
+		ch := s[i]
+		var comment string
+		switch {
+		case strings.HasPrefix(s[i:], "--"):
+			end := strings.IndexByte(s[i:], '\n')
+			if end < 0 {
+				end = len(s) - i
+			}
+			comment = strings.TrimRight(s[i:i+end], "\r")
+		case strings.HasPrefix(s[i:], "/*"):
+			end := strings.Index(s[i+2:], "*/")
+			if end < 0 {
+				end = len(s) - i - 4
+			}
+			comment = s[i : i+end+4]
+		}
+		if comment != "" {
+			if strings.TrimSpace(body.String()) == "" {
+				leading.WriteString(comment + "\n")
+			} else {
+				cur.innerComments = true
+				body.WriteString(" ")
+			}
+			i += len(comment)
+			continue
+		}
+		switch ch {
+		case ';':
+			flush()
+			i++
+		case '\'', '"', '`':
+			j := i + 1
+			for j < len(s) {
+				if s[j] == ch {
+					if j+1 < len(s) && s[j+1] == ch {
+						j += 2
+						continue
+					}
+					break
+				}
+				j++
+			}
+			end := min(j+1, len(s))
+			body.WriteString(s[i:end])
+			i = end
+		default:
+			body.WriteByte(ch)
+			i++
+		}
+	}
+	flush()
+	return pieces
+}
+
+// quoteIdentsForParser turns "quoted" identifiers into the `quoted` form
+// the MySQL-flavoured parser reads as identifiers rather than strings
+func quoteIdentsForParser(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		ch := s[i]
+		if ch != '\'' && ch != '"' && ch != '`' {
+			b.WriteByte(ch)
+			i++
+			continue
+		}
+		j := i + 1
+		for j < len(s) {
+			if s[j] == ch {
+				if j+1 < len(s) && s[j+1] == ch {
+					j += 2
+					continue
+				}
+				break
+			}
+			j++
+		}
+		end := min(j+1, len(s))
+		if ch == '"' && j < len(s) {
+			name := strings.ReplaceAll(s[i+1:j], `""`, `"`)
+			b.WriteString("`" + strings.ReplaceAll(name, "`", "``") + "`")
+		} else {
+			b.WriteString(s[i:end])
+		}
+		i = end
+	}
+	return b.String()
+}
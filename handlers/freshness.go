@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"sql-engine/alert"
+	"sql-engine/config"
+)
+
+const freshnessPrefix = "freshness/"
+
+// TableFreshness records when a tracked table last received data
+type TableFreshness struct {
+	config.FreshnessTable
+	// LastDataAt is the latest value of the timestamp column
+	LastDataAt *time.Time `json:"last_data_at,omitempty"`
+	// LastModifiedAt is the first check that noticed the latest write
+	LastModifiedAt *time.Time `json:"last_modified_at,omitempty"`
+	WriteCounter   int64      `json:"-"`
+	TrackedSince   time.Time  `json:"tracked_since"`
+	Stale          bool       `json:"stale"`
+	Error          string     `json:"error,omitempty"`
+	CheckedAt      time.Time  `json:"checked_at"`
+}
+
+// StartFreshnessChecks schedules periodic freshness checks of the configured
+// tables
+func (h *Handler) StartFreshnessChecks() {
+	if len(h.cfg.Freshness.Tables) == 0 {
+		return
+	}
+	check := func(ctx context.Context) {
+		for _, t := range h.cfg.Freshness.Tables {
+			h.checkFreshness(ctx, t)
+		}
+	}
+	h.sched.Go("freshness", check)
+	h.sched.Every("freshness", h.cfg.Freshness.Every, check)
+}
+
+// checkFreshness updates the stored freshness of a table and alerts when it
+// turns stale or recovers
+func (h *Handler) checkFreshness(ctx context.Context, t config.FreshnessTable) {
+	now := time.Now()
+	var previous TableFreshness
+	hadPrevious := h.meta.Get(freshnessPrefix+t.Table, &previous) == nil
+
+	f := TableFreshness{FreshnessTable: t, TrackedSince: now, CheckedAt: now}
+	if hadPrevious {
+		f.TrackedSince = previous.TrackedSince
+		f.LastModifiedAt = previous.LastModifiedAt
+		f.WriteCounter = previous.WriteCounter
+	}
+
+	counter, err := h.dialect.WriteCounter(h.db, "", t.Table)
+	if err == nil {
+		if hadPrevious && counter != previous.WriteCounter {
+			f.LastModifiedAt = &now
+		}
+		f.WriteCounter = counter
+	}
+
+	if err == nil && t.Column != "" {
+		var latest interface{}
+		q := h.dialect.Quote
+		err = h.db.QueryRowContext(ctx, fmt.Sprintf("SELECT MAX(%s) FROM %s", q(t.Column), q(t.Table))).Scan(&latest)
+		if ts, ok := parseTimestamp(latest); ok {
+			f.LastDataAt = &ts
+		}
+	}
+	if err != nil {
+		f.Error = err.Error()
+	}
+
+	// Without a known write, the table has been quiet since tracking began
+	since := f.TrackedSince
+	switch {
+	case f.LastDataAt != nil:
+		since = *f.LastDataAt
+	case t.Column == "" && f.LastModifiedAt != nil:
+		since = *f.LastModifiedAt
+	}
+	f.Stale = t.MaxAge > 0 && now.Sub(since) > t.MaxAge
+	if err != nil {
+		f.Stale = previous.Stale // keep the last known state until checks recover
+	}
+
+	if err := h.meta.Put(freshnessPrefix+t.Table, f); err != nil {
+		slog.ErrorContext(ctx, "Failed to store table freshness", "error", err)
+	}
+
+	switch {
+	case f.Stale && !previous.Stale:
+		h.alerts.Send(ctx, alert.Alert{
+			Source:  "freshness",
+			Subject: t.Table,
+			Status:  "stale",
+			Message: fmt.Sprintf("no new data for %s (max %s)", now.Sub(since).Round(time.Second), t.MaxAge),
+			Details: f,
+		})
+	case !f.Stale && previous.Stale:
+		h.alerts.Send(ctx, alert.Alert{
+			Source:  "freshness",
+			Subject: t.Table,
+			Status:  "resolved",
+			Message: "Table received new data",
+		})
+	}
+}
+
+// tableFreshness returns the stored freshness of every tracked table
+func (h *Handler) tableFreshness() map[string]*TableFreshness {
+	out := map[string]*TableFreshness{}
+	for _, t := range h.cfg.Freshness.Tables {
+		var f TableFreshness
+		if err := h.meta.Get(freshnessPrefix+t.Table, &f); err == nil {
+			out[t.Table] = &f
+		}
+	}
+	return out
+}
@@ -0,0 +1,470 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"sql-engine/rbac"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultSearchLimit = 20
+	// searchHeadlineOptions are passed to ts_headline for every snippet
+	searchHeadlineOptions = "StartSel=<mark>, StopSel=</mark>, MaxFragments=2, MaxWords=30, MinWords=10"
+)
+
+// fullTextModes maps search modes to the function parsing the query
+var fullTextModes = map[string]string{"": "plainto_tsquery", "plain": "plainto_tsquery", "websearch": "websearch_to_tsquery"}
+
+// tsvectorIndexExpr matches an index key built by to_tsvector, as printed by
+// pg_get_indexdef, capturing the configuration
+var tsvectorIndexExpr = regexp.MustCompile(`^to_tsvector\('([^']+)'::regconfig,\s*(.+)\)$`)
+
+// FullTextSource is a tsvector column, or a to_tsvector expression with a
+// GIN or GiST index, that a table can be searched by
+type FullTextSource struct {
+	// Name identifies the source in search requests: the column or the
+	// index name
+	Name string `json:"name"`
+	// Column is set for tsvector columns
+	Column string `json:"column,omitempty"`
+	// Expression is the SQL giving the tsvector
+	Expression string `json:"expression"`
+	// Config is the text search configuration of an indexed expression
+	Config string `json:"config,omitempty"`
+	Index  string `json:"index,omitempty"`
+	// Documents are the text columns an indexed expression reads, which are
+	// highlighted by default
+	Documents []string `json:"documents,omitempty"`
+}
+
+// FullTextSearchRequest is a ranked search of one table
+type FullTextSearchRequest struct {
+	Query string `json:"query"`
+	// Mode is plain (plainto_tsquery, the default) or websearch
+	// (websearch_to_tsquery, accepting quotes, OR and -)
+	Mode string `json:"mode"`
+	// Source names the column or index searched; optional when the table
+	// has a single source
+	Source string `json:"source"`
+	// Config overrides the text search configuration of the query
+	Config string `json:"config"`
+	// Columns are returned for each match, by default every column but
+	// tsvectors
+	Columns []string `json:"columns"`
+	// Highlight lists the text columns given a ts_headline snippet
+	Highlight []string `json:"highlight"`
+	Limit     int      `json:"limit"`
+	Offset    int      `json:"offset"`
+}
+
+// SearchRequest is a ranked search of a table by a tsvector column or by
+// text columns parsed on the fly, which needs no index but reads every row
+type SearchRequest struct {
+	Schema string `json:"schema"`
+	Table  string `json:"table"`
+	// Columns are the text columns searched, as one document
+	Columns []string `json:"columns"`
+	// TsvectorColumn is searched instead of Columns
+	TsvectorColumn string `json:"tsvector_column"`
+	Query          string `json:"query"`
+	// Mode is websearch (the default) or plain
+	Mode   string `json:"mode"`
+	Config string `json:"config"`
+	// Return lists the columns returned for each match, by default every
+	// column but tsvectors
+	Return []string `json:"return"`
+	// Highlight lists the text columns given a ts_headline snippet, by
+	// default the searched columns
+	Highlight []string `json:"highlight"`
+	Limit     int      `json:"limit"`
+	Offset    int      `json:"offset"`
+}
+
+// FullTextMatch is a row found by a search
+type FullTextMatch struct {
+	Row       map[string]interface{} `json:"row"`
+	Rank      float64                `json:"rank"`
+	Headlines map[string]string      `json:"headlines,omitempty"`
+}
+
+// fullTextSources finds the tsvector columns of a table and the tsvector
+// expressions its GIN and GiST indexes cover
+func fullTextSources(columns []ColumnInfo, indexes []IndexInfo) []FullTextSource {
+	var sources []FullTextSource
+	for _, col := range columns {
+		if col.DataType != "tsvector" {
+			continue
+		}
+		src := FullTextSource{Name: col.Name, Column: col.Name, Expression: col.Name}
+		for _, idx := range indexes {
+			if (idx.Method == "gin" || idx.Method == "gist") && slices.Contains(idx.Columns, col.Name) {
+				src.Index = idx.Name
+			}
+		}
+		sources = append(sources, src)
+	}
+	for _, idx := range indexes {
+		if idx.Method != "gin" && idx.Method != "gist" {
+			continue
+		}
+		for _, key := range idx.Columns {
+			m := tsvectorIndexExpr.FindStringSubmatch(key)
+			if m == nil {
+				continue
+			}
+			src := FullTextSource{Name: idx.Name, Expression: key, Config: m[1], Index: idx.Name}
+			for _, col := range columns {
+				if mentionsIdent(m[2], col.Name) {
+					src.Documents = append(src.Documents, col.Name)
+				}
+			}
+			sources = append(sources, src)
+		}
+	}
+	return sources
+}
+
+// mentionsIdent reports whether the SQL expr refers to the identifier name,
+// bare or quoted
+func mentionsIdent(expr, name string) bool {
+	return regexp.MustCompile(`(^|[^A-Za-z0-9_"])"?` + regexp.QuoteMeta(name) + `"?($|[^A-Za-z0-9_"])`).MatchString(expr)
+}
+
+// filterFullText drops the sources reading columns the caller may not see
+func filterFullText(access *rbac.Access, schema, table string, sources []FullTextSource) []FullTextSource {
+	if access == nil {
+		return sources
+	}
+	var out []FullTextSource
+	for _, src := range sources {
+		if src.Column != "" && !access.CanColumn(schema, table, src.Column) {
+			continue
+		}
+		if slices.ContainsFunc(src.Documents, func(col string) bool { return !access.CanColumn(schema, table, col) }) {
+			continue
+		}
+		out = append(out, src)
+	}
+	return out
+}
+
+// SearchTable runs a ranked full-text search over a tsvector column or
+// indexed to_tsvector expression of a table, best matches first, with
+// ts_headline snippets of the highlighted columns
+func (h *Handler) SearchTable(c *gin.Context) {
+	schema, ok := h.schemaParam(c)
+	if !ok {
+		return
+	}
+	tableName := c.Param("name")
+	if matchesAny(h.cfg.Query.Denylist.Tables, tableName) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Denied: table " + tableName + " is not allowed"})
+		return
+	}
+	if !h.requireTable(c, schema, tableName) || !h.requireRowLevel(c, schema, tableName) {
+		return
+	}
+	var req FullTextSearchRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		return
+	}
+	if !h.checkFullTextRequest(c, &req) {
+		return
+	}
+
+	columns, err := h.dialect.ListColumns(h.db, schema, tableName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(columns) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Table not found"})
+		return
+	}
+	indexes, err := h.dialect.ListIndexes(h.db, schema, tableName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	access := rbac.FromContext(c.Request.Context())
+	grantSchema := h.grantSchema(schema)
+	sources := filterFullText(access, grantSchema, tableName, fullTextSources(columns, indexes))
+	var src FullTextSource
+	switch i := slices.IndexFunc(sources, func(s FullTextSource) bool { return s.Name == req.Source }); {
+	case len(sources) == 0:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Table has no tsvector column or full-text index"})
+		return
+	case req.Source == "" && len(sources) > 1:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Table has several full-text sources, choose one with source", "sources": sources})
+		return
+	case req.Source == "":
+		src = sources[0]
+	case i < 0:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown full-text source " + req.Source, "sources": sources})
+		return
+	default:
+		src = sources[i]
+	}
+	h.runFullTextSearch(c, schema, tableName, columns, src, req)
+}
+
+// checkFullTextRequest validates the query, mode and configuration of a
+// search and bounds its limit, answering 400 for invalid requests
+func (h *Handler) checkFullTextRequest(c *gin.Context, req *FullTextSearchRequest) bool {
+	if strings.TrimSpace(req.Query) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "query is required"})
+		return false
+	}
+	if _, ok := fullTextModes[req.Mode]; !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mode must be plain or websearch"})
+		return false
+	}
+	if req.Config != "" && !identPattern.MatchString(req.Config) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "config must be a text search configuration name"})
+		return false
+	}
+	if maxRows := h.maxRows(c.Request.Context()); req.Limit <= 0 || req.Limit > maxRows {
+		req.Limit = min(defaultSearchLimit, maxRows)
+	}
+	if req.Offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "offset must not be negative"})
+		return false
+	}
+	return true
+}
+
+// runFullTextSearch searches a table by src, with a request checked by
+// checkFullTextRequest, and responds with the ranked matches
+func (h *Handler) runFullTextSearch(c *gin.Context, schema, tableName string, columns []ColumnInfo, src FullTextSource, req FullTextSearchRequest) {
+	access := rbac.FromContext(c.Request.Context())
+	grantSchema := h.grantSchema(schema)
+	var roles []string
+	if access != nil {
+		roles = access.Roles
+	}
+	masked := h.masks.Columns(grantSchema, tableName, roles)
+	hasColumn := func(name string) bool {
+		return slices.ContainsFunc(columns, func(col ColumnInfo) bool { return col.Name == name })
+	}
+	if len(req.Columns) == 0 {
+		for _, col := range columns {
+			if col.DataType != "tsvector" && access.CanColumn(grantSchema, tableName, col.Name) {
+				req.Columns = append(req.Columns, col.Name)
+			}
+		}
+	}
+	if req.Highlight == nil {
+		req.Highlight = src.Documents
+	}
+	for _, col := range slices.Concat(req.Columns, req.Highlight) {
+		if !hasColumn(col) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Column " + col + " not found"})
+			return
+		}
+		if !access.CanColumn(grantSchema, tableName, col) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied: column " + col + " is not granted"})
+			return
+		}
+	}
+	// Snippets would show the masked text, and a match on it tells as much
+	for _, col := range slices.Concat(req.Highlight, src.Documents, []string{src.Column}) {
+		if _, ok := masked[strings.ToLower(col)]; ok && col != "" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Masked column " + col + " cannot be searched or highlighted"})
+			return
+		}
+	}
+
+	q := h.dialect.Quote
+	document := src.Expression
+	if src.Column != "" {
+		document = q(src.Column)
+	}
+	config := req.Config
+	if config == "" {
+		config = src.Config
+	}
+	toQuery := fullTextModes[req.Mode]
+	args := []interface{}{req.Query}
+	tsquery := toQuery + "(" + h.dialect.Placeholder(1) + ")"
+	headlineConfig := ""
+	if config != "" {
+		args = append(args, config)
+		tsquery = fmt.Sprintf("%s(%s::regconfig, %s)", toQuery, h.dialect.Placeholder(2), h.dialect.Placeholder(1))
+		headlineConfig = h.dialect.Placeholder(2) + "::regconfig, "
+	}
+	selects := make([]string, 0, len(req.Columns)+len(req.Highlight)+1)
+	for _, col := range req.Columns {
+		selects = append(selects, q(col))
+	}
+	selects = append(selects, fmt.Sprintf("ts_rank(%s, %s)", document, tsquery))
+	for _, col := range req.Highlight {
+		selects = append(selects, fmt.Sprintf("ts_headline(%s%s::text, %s, '%s')", headlineConfig, q(col), tsquery, searchHeadlineOptions))
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s.%s WHERE %s @@ %s ORDER BY %d DESC %s OFFSET %d",
+		strings.Join(selects, ", "), q(schema), q(tableName), document, tsquery,
+		len(req.Columns)+1, h.dialect.LimitClause(req.Limit), req.Offset)
+
+	ctx := c.Request.Context()
+	if timeout := h.queryTimeout(ctx); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	release, err := h.acquireStatement(ctx)
+	if err != nil {
+		respondQueryError(c, err)
+		return
+	}
+	defer release()
+	tx, end, err := h.dialect.BeginReadOnly(ctx, h.queryDB(ctx))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start read-only transaction: " + err.Error()})
+		return
+	}
+	defer end()
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Search failed: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+	matches := []FullTextMatch{}
+	for rows.Next() {
+		vals := make([]interface{}, len(req.Columns))
+		headlines := make([]sql.NullString, len(req.Highlight))
+		var m FullTextMatch
+		ptrs := make([]interface{}, 0, len(vals)+len(headlines)+1)
+		for i := range vals {
+			ptrs = append(ptrs, &vals[i])
+		}
+		ptrs = append(ptrs, &m.Rank)
+		for i := range headlines {
+			ptrs = append(ptrs, &headlines[i])
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Row scan failed: " + err.Error()})
+			return
+		}
+		m.Row = map[string]interface{}{}
+		for i, col := range req.Columns {
+			m.Row[col] = vals[i]
+			if mask, ok := masked[strings.ToLower(col)]; ok {
+				m.Row[col] = h.masks.Apply(mask, vals[i])
+			}
+		}
+		if len(headlines) > 0 {
+			m.Headlines = map[string]string{}
+			for i, col := range req.Highlight {
+				if headlines[i].Valid {
+					m.Headlines[col] = headlines[i].String
+				}
+			}
+		}
+		matches = append(matches, m)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Row iteration error: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"schema":     schema,
+		"table_name": tableName,
+		"source":     src,
+		"matches":    matches,
+	})
+}
+
+// Search runs a ranked full-text search of the table in the request, with
+// websearch_to_tsquery syntax by default: quoted phrases, OR and -word.
+// Matches are ranked with ts_rank and highlighted with ts_headline, like
+// those of POST /table/:name/search.
+func (h *Handler) Search(c *gin.Context) {
+	var req SearchRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		return
+	}
+	if (len(req.Columns) == 0) == (req.TsvectorColumn == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Set either columns or tsvector_column"})
+		return
+	}
+	if req.Mode == "" {
+		req.Mode = "websearch"
+	}
+	search := FullTextSearchRequest{
+		Query:     req.Query,
+		Mode:      req.Mode,
+		Config:    req.Config,
+		Columns:   req.Return,
+		Highlight: req.Highlight,
+		Limit:     req.Limit,
+		Offset:    req.Offset,
+	}
+	if !h.checkFullTextRequest(c, &search) {
+		return
+	}
+
+	schema := orDefault(h.dialect, req.Schema)
+	if matchesAny(h.cfg.Query.Denylist.Tables, req.Table) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Denied: table " + req.Table + " is not allowed"})
+		return
+	}
+	if !h.requireTable(c, schema, req.Table) || !h.requireRowLevel(c, schema, req.Table) {
+		return
+	}
+	columns, err := h.dialect.ListColumns(h.db, schema, req.Table)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(columns) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Table not found"})
+		return
+	}
+	access := rbac.FromContext(c.Request.Context())
+	grantSchema := h.grantSchema(schema)
+	for _, col := range slices.Concat(req.Columns, []string{req.TsvectorColumn}) {
+		if col == "" {
+			continue
+		}
+		i := slices.IndexFunc(columns, func(ci ColumnInfo) bool { return ci.Name == col })
+		if i < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Column " + col + " not found"})
+			return
+		}
+		if !access.CanColumn(grantSchema, req.Table, col) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied: column " + col + " is not granted"})
+			return
+		}
+		if col == req.TsvectorColumn && columns[i].DataType != "tsvector" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Column " + col + " is not a tsvector"})
+			return
+		}
+	}
+
+	src := FullTextSource{Name: req.TsvectorColumn, Column: req.TsvectorColumn, Expression: req.TsvectorColumn}
+	if req.TsvectorColumn == "" {
+		quoted := make([]string, len(req.Columns))
+		for i, col := range req.Columns {
+			quoted[i] = h.dialect.Quote(col)
+		}
+		doc, ok := h.dialect.TextSearchDocument(quoted, req.Config)
+		if !ok {
+			c.JSON(http.StatusNotImplemented, gin.H{"error": "Full-text search is not supported by this database"})
+			return
+		}
+		src = FullTextSource{Name: strings.Join(req.Columns, ", "), Expression: doc, Config: req.Config, Documents: req.Columns}
+	}
+	h.runFullTextSearch(c, schema, req.Table, columns, src, search)
+}
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// FunctionInfo describes a user-defined function or procedure
+type FunctionInfo struct {
+	Schema string `json:"schema"`
+	Name   string `json:"name"`
+	// Kind is function, procedure, aggregate or window
+	Kind      string `json:"kind"`
+	Arguments string `json:"arguments"`
+	// ReturnType is empty for procedures
+	ReturnType string `json:"return_type,omitempty"`
+	Language   string `json:"language"`
+	// Volatility is immutable, stable or volatile
+	Volatility string `json:"volatility"`
+	// Definition is the CREATE statement; aggregates have none
+	Definition string `json:"definition,omitempty"`
+}
+
+// GetFunctions lists the functions and procedures defined in a schema
+func (h *Handler) GetFunctions(c *gin.Context) {
+	schema, ok := h.schemaParam(c)
+	if !ok {
+		return
+	}
+	functions, err := h.dialect.ListFunctions(h.db, schema)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if functions == nil {
+		functions = []FunctionInfo{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"schema": schema, "functions": functions})
+}
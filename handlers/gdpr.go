@@ -0,0 +1,245 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SubjectExtractRequest identifies a data subject by a column value
+type SubjectExtractRequest struct {
+	Table  string      `json:"table"`
+	Column string      `json:"column"` // defaults to the table's primary key
+	Value  interface{} `json:"value"`
+	// MaxDepth bounds how many foreign-key hops are followed from the root table
+	MaxDepth int `json:"max_depth"`
+}
+
+// subjectRef links a child table column to the parent column it references
+type subjectRef struct {
+	table, column, parentColumn string
+}
+
+// ExtractSubject collects every row related to a data subject: the matching
+// rows of the root table, rows reachable through foreign keys pointing at
+// them, and rows in other tables whose classified personal-data columns hold
+// the same values as the subject.
+func (h *Handler) ExtractSubject(c *gin.Context) {
+	var req SubjectExtractRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		return
+	}
+	if req.Table == "" || req.Value == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "table and value are required"})
+		return
+	}
+	if req.MaxDepth <= 0 {
+		req.MaxDepth = 3
+	}
+	if req.Column == "" {
+		pks, err := h.dialect.ListPrimaryKeys(h.db, "", req.Table)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if len(pks) != 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "column is required for tables without a single-column primary key"})
+			return
+		}
+		req.Column = pks[0]
+	}
+
+	// Map every table to the foreign keys referencing it
+	tables, err := h.dialect.ListTables(h.db, "")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	referencedBy := map[string][]subjectRef{}
+	for _, t := range tables {
+		fks, err := h.dialect.ListForeignKeys(h.db, "", t.Name)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		for _, fk := range fks {
+			referencedBy[fk.ForeignTable] = append(referencedBy[fk.ForeignTable],
+				subjectRef{table: t.Name, column: fk.Column, parentColumn: fk.ForeignColumn})
+		}
+	}
+
+	ctx := c.Request.Context()
+	bundle := map[string][]map[string]interface{}{}
+	rows, err := h.selectWhereIn(ctx, req.Table, req.Column, []interface{}{req.Value})
+	if err != nil {
+		respondQueryError(c, err)
+		return
+	}
+	if len(rows) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No rows match the subject"})
+		return
+	}
+	bundle[req.Table] = rows
+
+	// Follow foreign keys breadth-first from the root table
+	frontier := []string{req.Table}
+	for depth := 0; depth < req.MaxDepth && len(frontier) > 0; depth++ {
+		var next []string
+		for _, parent := range frontier {
+			for _, ref := range referencedBy[parent] {
+				if _, seen := bundle[ref.table]; seen {
+					continue
+				}
+				keys := distinctValues(bundle[parent], ref.parentColumn)
+				if len(keys) == 0 {
+					continue
+				}
+				children, err := h.selectWhereIn(ctx, ref.table, ref.column, keys)
+				if err != nil {
+					respondQueryError(c, err)
+					return
+				}
+				if len(children) > 0 {
+					bundle[ref.table] = children
+					next = append(next, ref.table)
+				}
+			}
+		}
+		frontier = next
+	}
+
+	// Match classified personal-data values held elsewhere
+	matched, err := h.matchClassifiedValues(ctx, req.Table, bundle)
+	if err != nil {
+		respondQueryError(c, err)
+		return
+	}
+	for table, rows := range matched {
+		bundle[table] = rows
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"subject":      req,
+		"tables":       bundle,
+		"generated_at": time.Now(),
+	})
+}
+
+// matchClassifiedValues finds rows in tables not yet in the bundle whose
+// classified columns share a category and value with the root table
+func (h *Handler) matchClassifiedValues(ctx context.Context, root string, bundle map[string][]map[string]interface{}) (map[string][]map[string]interface{}, error) {
+	rootClass, ok := h.classification(root)
+	if !ok {
+		return nil, nil
+	}
+
+	keys, err := h.meta.List(classificationPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	matched := map[string][]map[string]interface{}{}
+	for _, key := range keys {
+		table := strings.TrimPrefix(key, classificationPrefix)
+		if _, seen := bundle[table]; seen {
+			continue
+		}
+		other, ok := h.classification(table)
+		if !ok {
+			continue
+		}
+
+		for rootCol, rootFinding := range rootClass.Columns {
+			values := distinctValues(bundle[root], rootCol)
+			if len(values) == 0 {
+				continue
+			}
+			for col, finding := range other.Columns {
+				if finding.Category != rootFinding.Category {
+					continue
+				}
+				rows, err := h.selectWhereIn(ctx, table, col, values)
+				if err != nil {
+					return nil, err
+				}
+				matched[table] = append(matched[table], rows...)
+			}
+		}
+	}
+	return matched, nil
+}
+
+// inListChunk caps the values of one IN list, well under the 65535 bind
+// parameters a PostgreSQL statement takes
+const inListChunk = 1000
+
+// selectWhereIn returns every row of table whose column matches one of
+// values, inListChunk values per statement. Like user SQL, each statement
+// runs in a read-only transaction under the query timeout and holds a
+// statement slot, but without the row cap, since an extract must be
+// complete.
+func (h *Handler) selectWhereIn(ctx context.Context, table, column string, values []interface{}) ([]map[string]interface{}, error) {
+	result := []map[string]interface{}{}
+	for chunk := range slices.Chunk(values, inListChunk) {
+		rows, err := h.selectWhereInChunk(ctx, table, column, chunk)
+		if err != nil {
+			return nil, err
+		}
+		// Values are distinct, so no row matches two chunks
+		result = append(result, rows...)
+	}
+	return result, nil
+}
+
+func (h *Handler) selectWhereInChunk(ctx context.Context, table, column string, values []interface{}) ([]map[string]interface{}, error) {
+	placeholders := make([]string, len(values))
+	for i := range values {
+		placeholders[i] = h.dialect.Placeholder(i + 1)
+	}
+
+	if timeout := h.queryTimeout(ctx); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	release, err := h.acquireStatement(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	rows, end, err := h.beginRead(ctx, fmt.Sprintf("SELECT * FROM %s WHERE %s IN (%s)",
+		h.dialect.Quote(table), h.dialect.Quote(column), strings.Join(placeholders, ", ")), values...)
+	if err != nil {
+		return nil, err
+	}
+	defer end()
+	defer rows.Close()
+
+	_, result, err := scanRowMaps(rows)
+	return result, err
+}
+
+func distinctValues(rows []map[string]interface{}, column string) []interface{} {
+	seen := map[string]bool{}
+	var values []interface{}
+	for _, row := range rows {
+		v, ok := row[column]
+		if !ok || v == nil {
+			continue
+		}
+		key := fmt.Sprint(v)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		values = append(values, v)
+	}
+	return values
+}
@@ -0,0 +1,369 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"sql-engine/rbac"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxTileZoom = 30
+	// mvtExtent and mvtBuffer are the tile coordinate space and the margin
+	// around it that ST_AsMVTGeom clips geometries to
+	mvtExtent = 4096
+	mvtBuffer = 64
+	// geoGeometryColumn carries the encoded geometry next to the attributes
+	geoGeometryColumn = "__geometry"
+)
+
+// GeoFeature is a GeoJSON feature
+type GeoFeature struct {
+	Type       string                 `json:"type"`
+	Geometry   json.RawMessage        `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// geoTarget is the geometry column and attributes a spatial request reads,
+// already checked against the caller's grants
+type geoTarget struct {
+	Schema  string
+	Table   string
+	Column  string
+	Columns []string
+	// Geometry is the SQL giving the column as a geometry, and SRID its
+	// spatial reference system
+	Geometry string
+	SRID     int
+	Masked   map[string]string
+	Limit    int
+}
+
+// isGeoColumn reports whether col holds PostGIS geometries or geographies
+func isGeoColumn(col ColumnInfo) bool {
+	return col.UserType != nil && (col.UserType.Name == "geometry" || col.UserType.Name == "geography")
+}
+
+// resolveGeo checks a spatial request against the table, its geometry
+// columns (?geom picks one when there are several) and the grants, writing
+// an error response when it fails. withMasked keeps masked attributes among
+// the default columns, for responses masked after reading.
+func (h *Handler) resolveGeo(c *gin.Context, withMasked bool) (*geoTarget, bool) {
+	schema, ok := h.schemaParam(c)
+	if !ok {
+		return nil, false
+	}
+	tableName := c.Param("name")
+	if matchesAny(h.cfg.Query.Denylist.Tables, tableName) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Denied: table " + tableName + " is not allowed"})
+		return nil, false
+	}
+	if !h.requireTable(c, schema, tableName) || !h.requireRowLevel(c, schema, tableName) {
+		return nil, false
+	}
+	maxRows := h.maxRows(c.Request.Context())
+	limit, ok := intQuery(c, "limit", maxRows, maxRows)
+	if !ok {
+		return nil, false
+	}
+	tableColumns, err := h.dialect.ListColumns(h.db, schema, tableName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if len(tableColumns) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Table not found"})
+		return nil, false
+	}
+
+	var geoColumns []ColumnInfo
+	for _, col := range tableColumns {
+		if isGeoColumn(col) && (c.Query("geom") == "" || c.Query("geom") == col.Name) {
+			geoColumns = append(geoColumns, col)
+		}
+	}
+	switch {
+	case len(geoColumns) == 0 && c.Query("geom") != "":
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Column " + c.Query("geom") + " is not a geometry column"})
+		return nil, false
+	case len(geoColumns) == 0:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Table has no PostGIS geometry column"})
+		return nil, false
+	case len(geoColumns) > 1:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Table has several geometry columns, choose one with geom"})
+		return nil, false
+	}
+	geom := geoColumns[0]
+	if !h.analysisColumn(c, schema, tableName, tableColumns, "geom", geom.Name) {
+		return nil, false
+	}
+
+	access := rbac.FromContext(c.Request.Context())
+	grantSchema := h.grantSchema(schema)
+	var roles []string
+	if access != nil {
+		roles = access.Roles
+	}
+	t := &geoTarget{
+		Schema: schema,
+		Table:  tableName,
+		Column: geom.Name,
+		Masked: h.masks.Columns(grantSchema, tableName, roles),
+		Limit:  limit,
+	}
+	if list := c.Query("columns"); list != "" {
+		for _, col := range strings.Split(list, ",") {
+			col = strings.TrimSpace(col)
+			if !slices.ContainsFunc(tableColumns, func(tc ColumnInfo) bool { return tc.Name == col }) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Column " + col + " not found"})
+				return nil, false
+			}
+			if !access.CanColumn(grantSchema, tableName, col) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Access denied: column " + col + " is not granted"})
+				return nil, false
+			}
+			if _, masked := t.Masked[strings.ToLower(col)]; masked && !withMasked {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Masked column " + col + " cannot be encoded into tiles"})
+				return nil, false
+			}
+			t.Columns = append(t.Columns, col)
+		}
+	} else {
+		for _, col := range filterColumns(access, grantSchema, tableName, tableColumns) {
+			if _, masked := t.Masked[strings.ToLower(col.Name)]; isGeoColumn(col) || (masked && !withMasked) {
+				continue
+			}
+			t.Columns = append(t.Columns, col.Name)
+		}
+	}
+
+	// Geographies are lon/lat, and geometries without a declared SRID are
+	// taken to be too
+	q := h.dialect.Quote
+	t.Geometry, t.SRID = q(geom.Name)+"::geometry", 4326
+	if geom.UserType.Name == "geometry" {
+		var srid sql.NullInt64
+		err := h.db.QueryRowContext(c.Request.Context(), "SELECT Find_SRID($1, $2, $3)", orDefault(h.dialect, schema), tableName, geom.Name).Scan(&srid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read SRID: " + err.Error()})
+			return nil, false
+		}
+		if srid.Int64 > 0 {
+			t.Geometry, t.SRID = q(geom.Name), int(srid.Int64)
+		} else {
+			t.Geometry = "ST_SetSRID(" + q(geom.Name) + ", 4326)"
+		}
+	}
+	return t, true
+}
+
+// transform returns the SQL reprojecting the geometry expr from one SRID to
+// another
+func transform(expr string, from, to int) string {
+	if from == to {
+		return expr
+	}
+	return fmt.Sprintf("ST_Transform(%s, %d)", expr, to)
+}
+
+// parseTile validates z/x/y tile coordinates
+func parseTile(zs, xs, ys string) (z, x, y int, err error) {
+	if z, err = strconv.Atoi(zs); err != nil || z < 0 || z > maxTileZoom {
+		return 0, 0, 0, fmt.Errorf("zoom must be an integer from 0 to %d", maxTileZoom)
+	}
+	x, errX := strconv.Atoi(xs)
+	y, errY := strconv.Atoi(ys)
+	if errX != nil || errY != nil || x < 0 || y < 0 || x >= 1<<z || y >= 1<<z {
+		return 0, 0, 0, fmt.Errorf("x and y must be integers from 0 to %d at zoom %d", 1<<z-1, z)
+	}
+	return z, x, y, nil
+}
+
+// parseBBox parses a minLon,minLat,maxLon,maxLat bounding box
+func parseBBox(s string) ([4]float64, error) {
+	var box [4]float64
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return box, fmt.Errorf("bbox must be minLon,minLat,maxLon,maxLat")
+	}
+	for i, p := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return box, fmt.Errorf("bbox must be minLon,minLat,maxLon,maxLat")
+		}
+		box[i] = v
+	}
+	if box[0] > box[2] || box[1] > box[3] {
+		return box, fmt.Errorf("bbox minimums must not exceed its maximums")
+	}
+	return box, nil
+}
+
+// queryGeo runs a spatial statement read-only under the query limits
+func (h *Handler) queryGeo(c *gin.Context, query string, args []interface{}, read func(*sql.Rows) error) bool {
+	ctx := c.Request.Context()
+	if timeout := h.queryTimeout(ctx); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	release, err := h.acquireStatement(ctx)
+	if err != nil {
+		respondQueryError(c, err)
+		return false
+	}
+	defer release()
+	tx, end, err := h.dialect.BeginReadOnly(ctx, h.queryDB(ctx))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start read-only transaction: " + err.Error()})
+		return false
+	}
+	defer end()
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Execution failed: " + err.Error()})
+		return false
+	}
+	defer rows.Close()
+	if err := read(rows); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	return true
+}
+
+// GetFeatures returns the rows of a PostGIS table as a GeoJSON feature
+// collection in lon/lat. ?bbox=minLon,minLat,maxLon,maxLat or ?tile=z/x/y
+// keeps the features intersecting an area, ?columns picks the properties and
+// ?limit caps the features.
+func (h *Handler) GetFeatures(c *gin.Context) {
+	t, ok := h.resolveGeo(c, true)
+	if !ok {
+		return
+	}
+	q := h.dialect.Quote
+	var args []interface{}
+	var where string
+	switch {
+	case c.Query("bbox") != "" && c.Query("tile") != "":
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Give either bbox or tile"})
+		return
+	case c.Query("bbox") != "":
+		box, err := parseBBox(c.Query("bbox"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		for _, v := range box {
+			args = append(args, v)
+		}
+		envelope := fmt.Sprintf("ST_MakeEnvelope(%s, %s, %s, %s, 4326)",
+			h.dialect.Placeholder(1), h.dialect.Placeholder(2), h.dialect.Placeholder(3), h.dialect.Placeholder(4))
+		where = fmt.Sprintf(" WHERE ST_Intersects(%s, %s)", t.Geometry, transform(envelope, 4326, t.SRID))
+	case c.Query("tile") != "":
+		parts := strings.Split(c.Query("tile"), "/")
+		if len(parts) != 3 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "tile must be z/x/y"})
+			return
+		}
+		z, x, y, err := parseTile(parts[0], parts[1], parts[2])
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		envelope := fmt.Sprintf("ST_TileEnvelope(%d, %d, %d)", z, x, y)
+		where = fmt.Sprintf(" WHERE ST_Intersects(%s, %s)", t.Geometry, transform(envelope, 3857, t.SRID))
+	}
+
+	selects := make([]string, 0, len(t.Columns)+1)
+	for _, col := range t.Columns {
+		selects = append(selects, q(col))
+	}
+	selects = append(selects, fmt.Sprintf("ST_AsGeoJSON(%s)::text AS %s", transform(t.Geometry, t.SRID, 4326), q(geoGeometryColumn)))
+	// Read one extra row to learn whether features were left out
+	query := fmt.Sprintf("SELECT %s FROM %s.%s%s %s",
+		strings.Join(selects, ", "), q(t.Schema), q(t.Table), where, h.dialect.LimitClause(t.Limit+1))
+
+	var result []map[string]interface{}
+	if !h.queryGeo(c, query, args, func(rows *sql.Rows) (err error) {
+		_, result, err = scanRowMaps(rows)
+		return err
+	}) {
+		return
+	}
+	truncated := len(result) > t.Limit
+	if truncated {
+		result = result[:t.Limit]
+	}
+
+	features := make([]GeoFeature, 0, len(result))
+	for _, row := range result {
+		f := GeoFeature{Type: "Feature", Geometry: json.RawMessage("null"), Properties: map[string]interface{}{}}
+		if s, ok := row[geoGeometryColumn].(string); ok {
+			f.Geometry = json.RawMessage(s)
+		}
+		for _, col := range t.Columns {
+			f.Properties[col] = row[col]
+			if m, ok := t.Masked[strings.ToLower(col)]; ok {
+				f.Properties[col] = h.masks.Apply(m, row[col])
+			}
+		}
+		features = append(features, f)
+	}
+	c.Header("Content-Type", "application/geo+json")
+	c.JSON(http.StatusOK, gin.H{
+		"type":      "FeatureCollection",
+		"features":  features,
+		"truncated": truncated,
+	})
+}
+
+// GetTile renders a z/x/y Mapbox vector tile of a PostGIS table with
+// ST_AsMVT, one layer named after the table. ?columns picks the attributes,
+// which cannot include masked columns, and ?limit caps the features.
+func (h *Handler) GetTile(c *gin.Context) {
+	z, x, y, err := parseTile(c.Param("z"), c.Param("x"), strings.TrimSuffix(c.Param("y"), ".mvt"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	t, ok := h.resolveGeo(c, false)
+	if !ok {
+		return
+	}
+	q := h.dialect.Quote
+	envelope := fmt.Sprintf("ST_TileEnvelope(%d, %d, %d)", z, x, y)
+	selects := []string{fmt.Sprintf("ST_AsMVTGeom(%s, %s, %d, %d, true) AS %s",
+		transform(t.Geometry, t.SRID, 3857), envelope, mvtExtent, mvtBuffer, q(geoGeometryColumn))}
+	for _, col := range t.Columns {
+		selects = append(selects, q(col))
+	}
+	query := fmt.Sprintf(`SELECT ST_AsMVT(mvt, %s, %d, %s) FROM (
+		SELECT %s FROM %s.%s WHERE ST_Intersects(%s, %s) %s
+	) mvt`,
+		h.dialect.Placeholder(1), mvtExtent, h.dialect.Placeholder(2),
+		strings.Join(selects, ", "), q(t.Schema), q(t.Table), t.Geometry, transform(envelope, 3857, t.SRID),
+		h.dialect.LimitClause(t.Limit))
+
+	var tile []byte
+	if !h.queryGeo(c, query, []interface{}{t.Table, geoGeometryColumn}, func(rows *sql.Rows) error {
+		for rows.Next() {
+			if err := rows.Scan(&tile); err != nil {
+				return fmt.Errorf("Row scan failed: %w", err)
+			}
+		}
+		return rows.Err()
+	}) {
+		return
+	}
+	c.Data(http.StatusOK, "application/vnd.mapbox-vector-tile", tile)
+}
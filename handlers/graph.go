@@ -0,0 +1,327 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"sql-engine/rbac"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GraphNode is a table of the entity-relationship graph
+type GraphNode struct {
+	ID      string        `json:"id"`
+	Schema  string        `json:"schema"`
+	Name    string        `json:"name"`
+	Comment string        `json:"comment,omitempty"`
+	Columns []GraphColumn `json:"columns"`
+}
+
+// GraphColumn summarizes a column for display in a diagram
+type GraphColumn struct {
+	Name       string `json:"name"`
+	DataType   string `json:"data_type"`
+	Nullable   bool   `json:"nullable"`
+	PrimaryKey bool   `json:"primary_key"`
+	ForeignKey bool   `json:"foreign_key"`
+}
+
+// GraphEdge is a foreign key, pointing from the referencing table to the
+// referenced one. Cardinality is many-to-one, or one-to-one when the
+// referencing column is itself unique; Optional is set when it is nullable.
+type GraphEdge struct {
+	From        string `json:"from"`
+	FromColumn  string `json:"from_column"`
+	To          string `json:"to"`
+	ToColumn    string `json:"to_column"`
+	Cardinality string `json:"cardinality"`
+	Optional    bool   `json:"optional"`
+}
+
+// GetSchemaGraph returns the tables of a schema as nodes and their foreign
+// keys as edges, for rendering an entity-relationship diagram. ?format=dot
+// returns the graph in Graphviz DOT instead of JSON.
+func (h *Handler) GetSchemaGraph(c *gin.Context) {
+	schema, ok := h.schemaParam(c)
+	if !ok {
+		return
+	}
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "dot" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be json or dot"})
+		return
+	}
+
+	tables, err := h.visibleSchema(c, schema)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	nodes, edges := schemaGraph(tables)
+
+	if format == "dot" {
+		c.Data(http.StatusOK, "text/vnd.graphviz; charset=utf-8", []byte(graphDOT(nodes, edges)))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"schema": schema, "nodes": nodes, "edges": edges})
+}
+
+const (
+	defaultJoinDepth = 4
+	maxJoinDepth     = 8
+	maxJoinPaths     = 20
+)
+
+// JoinStep joins one more table along a foreign key
+type JoinStep struct {
+	From string    `json:"from"`
+	To   string    `json:"to"`
+	On   string    `json:"on"`
+	Edge GraphEdge `json:"edge"`
+}
+
+// JoinPath is a chain of joins from one table to another
+type JoinPath struct {
+	Steps []JoinStep `json:"steps"`
+	SQL   string     `json:"sql"`
+}
+
+// GetJoinPath finds the shortest ways to join ?from to ?to along foreign
+// keys, followed in either direction. Every path of the shortest length is
+// returned, up to maxJoinPaths, unless it needs more than ?max_depth joins.
+func (h *Handler) GetJoinPath(c *gin.Context) {
+	schema, ok := h.schemaParam(c)
+	if !ok {
+		return
+	}
+	from, to := c.Query("from"), c.Query("to")
+	if from == "" || to == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from and to are required"})
+		return
+	}
+	maxDepth, ok := intQuery(c, "max_depth", defaultJoinDepth, maxJoinDepth)
+	if !ok {
+		return
+	}
+
+	tables, err := h.visibleSchema(c, schema)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	nodes, edges := schemaGraph(tables)
+	ids := map[string]bool{}
+	for _, n := range nodes {
+		ids[n.ID] = true
+	}
+	for _, name := range []*string{&from, &to} {
+		if !ids[*name] {
+			*name = orDefault(h.dialect, schema) + "." + *name
+		}
+		if !ids[*name] {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Table " + strings.TrimPrefix(*name, orDefault(h.dialect, schema)+".") + " not found"})
+			return
+		}
+	}
+	if from == to {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from and to must be different tables"})
+		return
+	}
+
+	paths := joinPaths(edges, from, to, maxDepth)
+	q := h.dialect.Quote
+	ref := func(id, column string) string {
+		schema, table, _ := strings.Cut(id, ".")
+		return q(schema) + "." + q(table) + "." + q(column)
+	}
+	table := func(id string) string {
+		schema, table, _ := strings.Cut(id, ".")
+		return q(schema) + "." + q(table)
+	}
+	out := []JoinPath{}
+	for _, steps := range paths {
+		sql := "FROM " + table(from)
+		for i := range steps {
+			s := &steps[i]
+			if s.From == s.Edge.From {
+				s.On = ref(s.Edge.From, s.Edge.FromColumn) + " = " + ref(s.Edge.To, s.Edge.ToColumn)
+			} else {
+				s.On = ref(s.Edge.To, s.Edge.ToColumn) + " = " + ref(s.Edge.From, s.Edge.FromColumn)
+			}
+			sql += "\nJOIN " + table(s.To) + " ON " + s.On
+		}
+		out = append(out, JoinPath{Steps: steps, SQL: sql})
+	}
+	length := 0
+	if len(out) > 0 {
+		length = len(out[0].Steps)
+	}
+	c.JSON(http.StatusOK, gin.H{"from": from, "to": to, "length": length, "paths": out})
+}
+
+// joinPaths returns the shortest chains of edges linking from to to, up to
+// maxDepth edges and maxJoinPaths chains
+func joinPaths(edges []GraphEdge, from, to string, maxDepth int) [][]JoinStep {
+	neighbors := map[string][]JoinStep{}
+	for _, e := range edges {
+		if e.From == e.To {
+			continue
+		}
+		neighbors[e.From] = append(neighbors[e.From], JoinStep{From: e.From, To: e.To, Edge: e})
+		neighbors[e.To] = append(neighbors[e.To], JoinStep{From: e.To, To: e.From, Edge: e})
+	}
+
+	// Breadth-first distances from the target tell which steps lead
+	// towards it
+	dist := map[string]int{to: 0}
+	queue := []string{to}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, s := range neighbors[id] {
+			if _, seen := dist[s.To]; !seen {
+				dist[s.To] = dist[id] + 1
+				queue = append(queue, s.To)
+			}
+		}
+	}
+	d, ok := dist[from]
+	if !ok || d > maxDepth {
+		return nil
+	}
+
+	var paths [][]JoinStep
+	var walk func(id string, steps []JoinStep)
+	walk = func(id string, steps []JoinStep) {
+		if len(paths) == maxJoinPaths {
+			return
+		}
+		if id == to {
+			paths = append(paths, slices.Clone(steps))
+			return
+		}
+		for _, s := range neighbors[id] {
+			if next, ok := dist[s.To]; ok && next == dist[id]-1 {
+				walk(s.To, append(steps, s))
+			}
+		}
+	}
+	walk(from, nil)
+	return paths
+}
+
+// visibleSchema returns the cached tables of a schema the caller may see
+func (h *Handler) visibleSchema(c *gin.Context, schema string) ([]TableSchema, error) {
+	snap, ok := h.staleSchema()
+	if !ok || !h.isDefaultSchema(schema) {
+		var err error
+		if snap, err = h.cachedSchema(schema); err != nil {
+			return nil, err
+		}
+	}
+	return h.filterSchema(rbac.FromContext(c.Request.Context()), snap.Schema), nil
+}
+
+func schemaGraph(tables []TableSchema) ([]GraphNode, []GraphEdge) {
+	nodes := []GraphNode{}
+	for _, t := range tables {
+		node := GraphNode{ID: t.Schema + "." + t.Name, Schema: t.Schema, Name: t.Name, Comment: t.Comment, Columns: []GraphColumn{}}
+		for _, col := range t.Columns {
+			node.Columns = append(node.Columns, GraphColumn{
+				Name:       col.Name,
+				DataType:   col.DataType,
+				Nullable:   col.IsNullable == "YES",
+				PrimaryKey: slices.Contains(t.PrimaryKeys, col.Name),
+				ForeignKey: slices.ContainsFunc(t.ForeignKeys, func(fk ForeignKeyInfo) bool { return fk.Column == col.Name }),
+			})
+		}
+		nodes = append(nodes, node)
+	}
+
+	// Edges to tables that are not part of the graph, e.g. in another schema
+	// or not granted, are left out
+	edges := []GraphEdge{}
+	for i, t := range tables {
+		for _, fk := range t.ForeignKeys {
+			to := fk.ForeignSchema + "." + fk.ForeignTable
+			if !slices.ContainsFunc(nodes, func(n GraphNode) bool { return n.ID == to }) {
+				continue
+			}
+			edge := GraphEdge{From: nodes[i].ID, FromColumn: fk.Column, To: to, ToColumn: fk.ForeignColumn, Cardinality: "many-to-one"}
+			if uniqueColumn(t, fk.Column) {
+				edge.Cardinality = "one-to-one"
+			}
+			if j := slices.IndexFunc(nodes[i].Columns, func(col GraphColumn) bool { return col.Name == fk.Column }); j >= 0 {
+				edge.Optional = nodes[i].Columns[j].Nullable
+			}
+			edges = append(edges, edge)
+		}
+	}
+	return nodes, edges
+}
+
+// uniqueColumn reports whether a column alone is a key of the table
+func uniqueColumn(t TableSchema, column string) bool {
+	if len(t.PrimaryKeys) == 1 && t.PrimaryKeys[0] == column {
+		return true
+	}
+	return slices.ContainsFunc(t.Constraints, func(con ConstraintInfo) bool {
+		return con.Type == "UNIQUE" && len(con.Columns) == 1 && con.Columns[0] == column
+	})
+}
+
+// graphDOT renders the graph with one record-shaped node per table and a
+// port per column, so that edges connect the columns involved
+func graphDOT(nodes []GraphNode, edges []GraphEdge) string {
+	var b strings.Builder
+	b.WriteString("digraph schema {\n\trankdir=LR;\n\tnode [shape=record, fontname=\"Helvetica\"];\n")
+	ports := map[string]string{}
+	for _, n := range nodes {
+		fields := []string{dotRecordEscape(n.ID)}
+		for i, col := range n.Columns {
+			port := fmt.Sprintf("c%d", i)
+			ports[n.ID+"\x00"+col.Name] = port
+			label := col.Name + " : " + col.DataType
+			if col.PrimaryKey {
+				label += " (PK)"
+			}
+			fields = append(fields, fmt.Sprintf("<%s> %s\\l", port, dotRecordEscape(label)))
+		}
+		fmt.Fprintf(&b, "\t%s [label=\"{%s}\"];\n", dotQuote(n.ID), strings.Join(fields, "|"))
+	}
+	for _, e := range edges {
+		// Crow's foot on the referencing side unless the key is one-to-one
+		tail := "crow"
+		if e.Cardinality == "one-to-one" {
+			tail = "tee"
+		}
+		if e.Optional {
+			tail = "odot" + tail
+		}
+		fmt.Fprintf(&b, "\t%s -> %s [dir=both, arrowtail=%s, arrowhead=tee];\n",
+			dotEndpoint(ports, e.From, e.FromColumn), dotEndpoint(ports, e.To, e.ToColumn), tail)
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// dotEndpoint addresses a column's port, or the whole node when the column is
+// not shown, e.g. because it is not granted
+func dotEndpoint(ports map[string]string, node, column string) string {
+	if port, ok := ports[node+"\x00"+column]; ok {
+		return dotQuote(node) + ":" + port
+	}
+	return dotQuote(node)
+}
+
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// dotRecordEscape escapes the characters that structure record labels
+func dotRecordEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "{", `\{`, "}", `\}`, "|", `\|`, "<", `\<`, ">", `\>`).Replace(s)
+}
@@ -1,13 +1,125 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
+	"sync"
+	"time"
+
+	"sql-engine/alert"
+	"sql-engine/artifact"
+	"sql-engine/config"
+	"sql-engine/embedding"
+	"sql-engine/leader"
+	"sql-engine/lineage"
+	"sql-engine/llm"
+	"sql-engine/masking"
+	"sql-engine/rbac"
+	"sql-engine/scheduler"
+	"sql-engine/store"
 )
 
 type Handler struct {
-	db *sql.DB
+	db      *sql.DB
+	dialect Dialect
+	meta    *store.Store
+	cfg     *config.Config
+	sched   *scheduler.Scheduler
+	// elector is nil when leader election is disabled
+	elector *leader.Elector
+	alerts  *alert.Notifier
+	masks   *masking.Policy
+	// policy resolves the access of saved query owners for the runs made
+	// on their behalf; nil leaves those runs unrestricted
+	policy  *rbac.Policy
+	lineage *lineage.Emitter
+	// artifacts keeps exports, named schema snapshots and staged imports
+	artifacts artifact.Store
+	// llm is nil unless /nl2sql is configured
+	llm llm.Provider
+	// embedder is nil unless text similarity searches are configured
+	embedder embedding.Embedder
+	// stmts holds a token per statement in flight when statements are capped
+	stmts chan struct{}
+	// lowDB runs the queries of low-priority callers, with lowStmts holding
+	// a token per statement in flight on it; nil unless configured
+	lowDB    *sql.DB
+	lowStmts chan struct{}
+	// replicas take the read-only queries of other callers when configured
+	replicas replicaSet
+
+	cursorMu  sync.Mutex
+	cursorKey []byte
+
+	// jobs holds the IDs of materialize jobs running in this process
+	jobsMu sync.Mutex
+	jobs   map[string]bool
+
+	// hooks holds the IDs of webhooks whose run is in progress in this
+	// process
+	hooksMu sync.Mutex
+	hooks   map[string]bool
+
+	// draftsMu serializes draft saves so version checks don't race
+	draftsMu sync.Mutex
+
+	// accessMu serializes access reviews so that grants stay consistent
+	accessMu sync.Mutex
+
+	// scimMu serializes provisioning so that logins and memberships stay
+	// consistent
+	scimMu sync.Mutex
+
+	snapshot schemaSnapshot
+	usage    usageLedger
+	history  queryHistory
+	notify   notifyHub
+	health   storeHealth
+	status   statusBoard
+	// dbDownSince is when the database stopped answering the status
+	// probes, zero while it answers; only the probes use it
+	dbDownSince time.Time
+	// estimates caches the planner statistics POST /estimate relies on
+	estimates plannerStats
+	// classified holds the categories of scanned columns for masking
+	classified classifiedColumns
+
+	// sessions holds the transactions spanning several requests
+	sessions sessionRegistry
+
+	// migrating is held while migrations are applied or reverted
+	migrating sync.Mutex
+
+	background backgroundWork
+	docs       apiDocs
 }
 
-func NewHandler(db *sql.DB) *Handler {
-	return &Handler{db: db}
+func NewHandler(db *sql.DB, dialect Dialect, meta *store.Store, cfg *config.Config) *Handler {
+	h := &Handler{
+		db:      db,
+		dialect: dialect,
+		meta:    meta,
+		cfg:     cfg,
+		sched:   scheduler.New(),
+		alerts:  alert.NewNotifier(cfg.Alerts.WebhookURL),
+		masks:   masking.New(cfg.Masking),
+		lineage: lineage.New(cfg.Lineage, cfg.Database.DSN),
+		jobs:    make(map[string]bool),
+		hooks:   make(map[string]bool),
+	}
+	h.masks.UseClassifications(h)
+	h.background.ctx, h.background.cancel = context.WithCancel(context.Background())
+	// Components in the order /status reports them
+	h.status.ring("api", true)
+	h.status.ring("database", false)
+	h.status.ring("metadata_store", false)
+	h.status.ring("scheduler", false)
+	if n := cfg.Limits.MaxConcurrentStatements; n > 0 {
+		h.stmts = make(chan struct{}, n)
+	}
+	if cfg.Server.LeaderLease > 0 {
+		h.elector = leader.New(meta, "scheduler", cfg.Server.ReplicaID, cfg.Server.LeaderLease)
+		h.sched.SetGate(h.elector.IsLeader)
+	}
+	return h
 }
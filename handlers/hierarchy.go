@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"sql-engine/rbac"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultTreeDepth = 10
+	maxTreeDepth     = 100
+	// treeDepthColumn carries the level of each row out of the recursive CTE
+	treeDepthColumn = "__tree_depth"
+)
+
+// TreeNode is a row of a self-referencing table with the rows below it
+type TreeNode struct {
+	Row      map[string]interface{} `json:"row"`
+	Children []*TreeNode            `json:"children"`
+}
+
+// GetTableTree expands a self-referencing table into trees with a recursive
+// CTE. The tree starts at the row whose referenced key is ?root, or at every
+// row without a parent. ?depth limits the levels below the roots, ?limit the
+// rows and ?columns the columns shown; ?fk picks the referencing column when
+// the table refers to itself more than once.
+func (h *Handler) GetTableTree(c *gin.Context) {
+	schema, ok := h.schemaParam(c)
+	if !ok {
+		return
+	}
+	tableName := c.Param("name")
+	if matchesAny(h.cfg.Query.Denylist.Tables, tableName) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Denied: table " + tableName + " is not allowed"})
+		return
+	}
+	if !h.requireTable(c, schema, tableName) || !h.requireRowLevel(c, schema, tableName) {
+		return
+	}
+	depth, ok := intQuery(c, "depth", defaultTreeDepth, maxTreeDepth)
+	if !ok {
+		return
+	}
+	maxRows := h.maxRows(c.Request.Context())
+	limit, ok := intQuery(c, "limit", maxRows, maxRows)
+	if !ok {
+		return
+	}
+
+	tableColumns, err := h.dialect.ListColumns(h.db, schema, tableName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(tableColumns) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Table not found"})
+		return
+	}
+	foreignKeys, err := h.dialect.ListForeignKeys(h.db, schema, tableName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	var selfKeys []ForeignKeyInfo
+	for _, fk := range foreignKeys {
+		if fk.ForeignTable == tableName && orDefault(h.dialect, fk.ForeignSchema) == orDefault(h.dialect, schema) {
+			if want := c.Query("fk"); want == "" || want == fk.Column {
+				selfKeys = append(selfKeys, fk)
+			}
+		}
+	}
+	switch {
+	case len(selfKeys) == 0 && c.Query("fk") != "":
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Column " + c.Query("fk") + " does not reference " + tableName})
+		return
+	case len(selfKeys) == 0:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Table " + tableName + " has no foreign key to itself"})
+		return
+	case len(selfKeys) > 1:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Table refers to itself more than once, choose the column with fk"})
+		return
+	}
+	fk := selfKeys[0]
+	// The shape of the tree reveals both key columns
+	if !h.analysisColumn(c, schema, tableName, tableColumns, "fk", fk.Column) ||
+		!h.analysisColumn(c, schema, tableName, tableColumns, "fk", fk.ForeignColumn) {
+		return
+	}
+
+	access := rbac.FromContext(c.Request.Context())
+	grantSchema := h.grantSchema(schema)
+	var columns []string
+	if list := c.Query("columns"); list != "" {
+		for _, col := range strings.Split(list, ",") {
+			col = strings.TrimSpace(col)
+			if !slices.ContainsFunc(tableColumns, func(tc ColumnInfo) bool { return tc.Name == col }) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Column " + col + " not found"})
+				return
+			}
+			if !access.CanColumn(grantSchema, tableName, col) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Access denied: column " + col + " is not granted"})
+				return
+			}
+			columns = append(columns, col)
+		}
+	} else {
+		for _, col := range filterColumns(access, grantSchema, tableName, tableColumns) {
+			columns = append(columns, col.Name)
+		}
+	}
+	// Both key columns are read to link the rows, shown or not
+	selected := slices.Clone(columns)
+	for _, col := range []string{fk.Column, fk.ForeignColumn} {
+		if !slices.Contains(selected, col) {
+			selected = append(selected, col)
+		}
+	}
+
+	q := h.dialect.Quote
+	table := q(schema) + "." + q(tableName)
+	base := make([]string, len(selected))
+	recursive := make([]string, len(selected))
+	for i, col := range selected {
+		base[i] = q(col)
+		recursive[i] = "t." + q(col)
+	}
+	var args []interface{}
+	rootCond := q(fk.Column) + " IS NULL"
+	if root, ok := c.GetQuery("root"); ok {
+		args = append(args, root)
+		rootCond = q(fk.ForeignColumn) + " = " + h.dialect.Placeholder(1)
+	}
+	query := fmt.Sprintf(`WITH RECURSIVE tree AS (
+		SELECT %s, 0 AS %s FROM %s WHERE %s
+		UNION ALL
+		SELECT %s, tree.%s + 1 FROM %s t JOIN tree ON t.%s = tree.%s WHERE tree.%s < %d
+	) SELECT * FROM tree ORDER BY %s %s`,
+		strings.Join(base, ", "), q(treeDepthColumn), table, rootCond,
+		strings.Join(recursive, ", "), q(treeDepthColumn), table, q(fk.Column), q(fk.ForeignColumn), q(treeDepthColumn), depth,
+		q(treeDepthColumn), h.dialect.LimitClause(limit+1))
+
+	ctx := c.Request.Context()
+	if timeout := h.queryTimeout(ctx); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	release, err := h.acquireStatement(ctx)
+	if err != nil {
+		respondQueryError(c, err)
+		return
+	}
+	defer release()
+	tx, end, err := h.dialect.BeginReadOnly(ctx, h.queryDB(ctx))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start read-only transaction: " + err.Error()})
+		return
+	}
+	defer end()
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Execution failed: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+	_, result, err := scanRowMaps(rows)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	truncated := len(result) > limit
+	if truncated {
+		result = result[:limit]
+	}
+
+	var roles []string
+	if access != nil {
+		roles = access.Roles
+	}
+	masked := h.masks.Columns(grantSchema, tableName, roles)
+	roots := []*TreeNode{}
+	// Rows arrive level by level, so every parent is indexed before its
+	// children. Cycles repeat rows on deeper levels, which is why nodes are
+	// indexed by level as well as key.
+	byKey := map[string]*TreeNode{}
+	for _, row := range result {
+		level, _ := strconv.Atoi(fmt.Sprint(row[treeDepthColumn]))
+		node := &TreeNode{Row: map[string]interface{}{}, Children: []*TreeNode{}}
+		for _, col := range columns {
+			node.Row[col] = row[col]
+			if m, ok := masked[strings.ToLower(col)]; ok {
+				node.Row[col] = h.masks.Apply(m, row[col])
+			}
+		}
+		if level == 0 {
+			roots = append(roots, node)
+		} else if parent := byKey[fmt.Sprint(level-1, "/", row[fk.Column])]; parent != nil {
+			parent.Children = append(parent.Children, node)
+		}
+		if key := fmt.Sprint(level, "/", row[fk.ForeignColumn]); byKey[key] == nil {
+			byKey[key] = node
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"schema":        schema,
+		"table_name":    tableName,
+		"parent_column": fk.Column,
+		"key_column":    fk.ForeignColumn,
+		"depth":         depth,
+		"rows":          len(result),
+		"truncated":     truncated,
+		"roots":         roots,
+	})
+}
@@ -0,0 +1,536 @@
+package handlers
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"math"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"sql-engine/auth"
+	"sql-engine/metrics"
+	"sql-engine/reqlog"
+	"sql-engine/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	historyRawPrefix    = "history/raw/"
+	historyHourlyPrefix = "history/hourly/"
+	historyDailyPrefix  = "history/daily/"
+	historyHourFormat   = "2006-01-02T15"
+	// historyFlushEvery is how often each replica adds the queries it
+	// recorded to the raw history, and historyRollupEvery how often the
+	// leader rolls them up
+	historyFlushEvery  = time.Minute
+	historyRollupEvery = 5 * time.Minute
+	// historySettle is how long after an hour ends its records are rolled
+	// up, leaving every replica time to flush them
+	historySettle = 2 * historyFlushEvery
+	// maxPendingHistory caps the records a replica holds between flushes;
+	// the oldest are dropped while the store is unreachable
+	maxPendingHistory = 100000
+	// maxHistorySQL caps the normalized SQL kept per record
+	maxHistorySQL = 2000
+)
+
+// QueryRecord is one query run, kept in the raw history until rolled up
+type QueryRecord struct {
+	At          time.Time `json:"at"`
+	Principal   string    `json:"principal,omitempty"`
+	Workspace   string    `json:"workspace,omitempty"`
+	Fingerprint string    `json:"fingerprint"`
+	// SQL is the query normalized, with its literals replaced
+	SQL        string  `json:"sql"`
+	DurationMS float64 `json:"duration_ms"`
+	Rows       int     `json:"rows"`
+	Failed     bool    `json:"failed,omitempty"`
+}
+
+// HistoryRollup sums the runs of one query fingerprint by one principal
+// and workspace over an hour or a day
+type HistoryRollup struct {
+	Fingerprint string  `json:"fingerprint"`
+	SQL         string  `json:"sql"`
+	Principal   string  `json:"principal,omitempty"`
+	Workspace   string  `json:"workspace,omitempty"`
+	Queries     int64   `json:"queries"`
+	Failures    int64   `json:"failures"`
+	TotalMS     float64 `json:"total_ms"`
+	MaxMS       float64 `json:"max_ms"`
+	Rows        int64   `json:"rows"`
+}
+
+func (r *HistoryRollup) add(o HistoryRollup) {
+	r.Queries += o.Queries
+	r.Failures += o.Failures
+	r.TotalMS += o.TotalMS
+	r.MaxMS = math.Max(r.MaxMS, o.MaxMS)
+	r.Rows += o.Rows
+}
+
+// historyPeriod is the stored summary of an hour or a day
+type historyPeriod struct {
+	Period string `json:"period"`
+	// Sources is the number of raw batches an hour was rolled up from, so
+	// that batches flushed late cause it to be rolled up again
+	Sources  int             `json:"sources,omitempty"`
+	RolledAt time.Time       `json:"rolled_at"`
+	Entries  []HistoryRollup `json:"entries"`
+}
+
+// queryHistory holds the records of this replica since the last flush
+type queryHistory struct {
+	mu      sync.Mutex
+	pending []QueryRecord
+}
+
+// historyCaller is who a request's queries are recorded against
+type historyCaller struct {
+	principal, workspace string
+}
+
+type historyCallerKey struct{}
+
+// TrackHistory marks each request with its caller for the query history.
+// It must run after authentication and ApplyWorkspace.
+func (h *Handler) TrackHistory() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !h.cfg.History.Enabled {
+			c.Next()
+			return
+		}
+		caller := historyCaller{principal: auth.Principal(c)}
+		if ws := workspaceFrom(c.Request.Context()); ws != nil {
+			caller.workspace = ws.Name
+		}
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), historyCallerKey{}, caller))
+		c.Next()
+	}
+}
+
+// recordQuery adds a query run to the latency metrics and the history, and
+// to the log when it ran for at least logging.slow_query. Queries run by the
+// server itself, such as scheduled tests, have no principal.
+func (h *Handler) recordQuery(ctx context.Context, normalized, hash string, elapsed time.Duration, rows int, failed bool) {
+	metrics.ObserveQuery(ctx, elapsed, failed)
+	if slow := h.cfg.Logging.SlowQuery; slow > 0 && elapsed >= slow {
+		// The normalized statement keeps filter values out of the log
+		slog.WarnContext(ctx, "Slow query",
+			"duration_ms", float64(elapsed.Microseconds())/1000,
+			"rows", rows,
+			"failed", failed,
+			"principal", reqlog.Principal(ctx),
+			"fingerprint", hash,
+			"sql", normalized,
+		)
+	}
+	if !h.cfg.History.Enabled {
+		return
+	}
+	caller, _ := ctx.Value(historyCallerKey{}).(historyCaller)
+	if len(normalized) > maxHistorySQL {
+		normalized = normalized[:maxHistorySQL]
+	}
+	r := QueryRecord{
+		At:          time.Now().UTC(),
+		Principal:   caller.principal,
+		Workspace:   caller.workspace,
+		Fingerprint: hash,
+		SQL:         normalized,
+		DurationMS:  float64(elapsed.Microseconds()) / 1000,
+		Rows:        rows,
+		Failed:      failed,
+	}
+	h.history.mu.Lock()
+	defer h.history.mu.Unlock()
+	if len(h.history.pending) >= maxPendingHistory {
+		h.history.pending = h.history.pending[1:]
+	}
+	h.history.pending = append(h.history.pending, r)
+}
+
+// StartQueryHistory flushes the queries this replica records every minute,
+// on every replica, and has the leader roll the raw history up into hourly
+// and daily summaries and delete what is past its retention
+func (h *Handler) StartQueryHistory() {
+	if !h.cfg.History.Enabled {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(historyFlushEvery)
+		defer ticker.Stop()
+		for range ticker.C {
+			h.flushHistory()
+		}
+	}()
+	rollup := func(ctx context.Context) {
+		h.rollupHistory(time.Now().UTC())
+	}
+	h.sched.Go("history-rollup", rollup)
+	h.sched.Every("history-rollup", historyRollupEvery, rollup)
+}
+
+// flushHistory stores the pending records in one batch per hour. Replicas
+// write batches of their own, so they never overwrite each other.
+func (h *Handler) flushHistory() {
+	h.history.mu.Lock()
+	pending := h.history.pending
+	h.history.pending = nil
+	h.history.mu.Unlock()
+
+	hours := map[string][]QueryRecord{}
+	for _, r := range pending {
+		hour := r.At.Format(historyHourFormat)
+		hours[hour] = append(hours[hour], r)
+	}
+	now := time.Now().UnixNano()
+	for hour, records := range hours {
+		key := fmt.Sprintf("%s%s/%s-%d", historyRawPrefix, hour, h.cfg.Server.ReplicaID, now)
+		if err := h.meta.Put(key, records); err != nil {
+			// Kept for the next flush rather than lost
+			slog.Error("Failed to store query history", "error", err)
+			h.history.mu.Lock()
+			h.history.pending = append(records, h.history.pending...)
+			h.history.mu.Unlock()
+		}
+	}
+}
+
+// rollupHistory sums the raw records of every hour that ended a while ago
+// into an hourly summary, rolling an hour up again when batches arrived
+// after its summary, then sums the hours of each past day whose hours
+// changed into a daily summary, and deletes records and summaries past
+// their retention
+func (h *Handler) rollupHistory(now time.Time) {
+	rawKeys, err := h.meta.List(historyRawPrefix)
+	if err != nil {
+		slog.Error("Failed to list query history", "error", err)
+		return
+	}
+	batches := map[string][]string{}
+	for _, key := range rawKeys {
+		hour, _, _ := strings.Cut(strings.TrimPrefix(key, historyRawPrefix), "/")
+		batches[hour] = append(batches[hour], key)
+	}
+
+	settled := now.Add(-historySettle).Truncate(time.Hour).Format(historyHourFormat)
+	days := map[string]bool{}
+	for _, hour := range slices.Sorted(maps.Keys(batches)) {
+		if hour >= settled {
+			continue
+		}
+		var rolled historyPeriod
+		err := h.meta.Get(historyHourlyPrefix+hour, &rolled)
+		if err == nil && rolled.Sources >= len(batches[hour]) {
+			continue
+		}
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			slog.Error("Failed to read hourly query history", "error", err)
+			continue
+		}
+		period := historyPeriod{Period: hour, Sources: len(batches[hour]), RolledAt: now}
+		entries := map[HistoryRollup]*HistoryRollup{}
+		failed := false
+		for _, key := range batches[hour] {
+			var records []QueryRecord
+			if err := h.meta.Get(key, &records); err != nil {
+				slog.Error("Failed to read query history", "error", err)
+				failed = true
+				break
+			}
+			for _, r := range records {
+				failures := int64(0)
+				if r.Failed {
+					failures = 1
+				}
+				addRollup(entries, HistoryRollup{
+					Fingerprint: r.Fingerprint,
+					SQL:         r.SQL,
+					Principal:   r.Principal,
+					Workspace:   r.Workspace,
+					Queries:     1,
+					Failures:    failures,
+					TotalMS:     r.DurationMS,
+					MaxMS:       r.DurationMS,
+					Rows:        int64(r.Rows),
+				})
+			}
+		}
+		if failed {
+			continue
+		}
+		period.Entries = sortedRollups(entries)
+		if err := h.meta.Put(historyHourlyPrefix+hour, period); err != nil {
+			slog.Error("Failed to store hourly query history", "error", err)
+			continue
+		}
+		days[hour[:len(usageDayFormat)]] = true
+	}
+
+	hourlyKeys, err := h.meta.List(historyHourlyPrefix)
+	if err != nil {
+		slog.Error("Failed to list hourly query history", "error", err)
+		return
+	}
+	today := now.Format(usageDayFormat)
+	checked := map[string]bool{}
+	for _, key := range hourlyKeys {
+		day := strings.TrimPrefix(key, historyHourlyPrefix)[:len(usageDayFormat)]
+		if days[day] || checked[day] {
+			continue
+		}
+		checked[day] = true
+		// Days left without a summary, e.g. while no replica led
+		if err := h.meta.Get(historyDailyPrefix+day, &historyPeriod{}); errors.Is(err, store.ErrNotFound) {
+			days[day] = true
+		}
+	}
+	for _, day := range slices.Sorted(maps.Keys(days)) {
+		if day >= today {
+			continue
+		}
+		entries := map[HistoryRollup]*HistoryRollup{}
+		for _, key := range hourlyKeys {
+			if !strings.HasPrefix(key, historyHourlyPrefix+day+"T") {
+				continue
+			}
+			var hour historyPeriod
+			if err := h.meta.Get(key, &hour); err != nil {
+				slog.Error("Failed to read hourly query history", "error", err)
+				continue
+			}
+			for _, e := range hour.Entries {
+				addRollup(entries, e)
+			}
+		}
+		period := historyPeriod{Period: day, RolledAt: now, Entries: sortedRollups(entries)}
+		if err := h.meta.Put(historyDailyPrefix+day, period); err != nil {
+			slog.Error("Failed to store daily query history", "error", err)
+		}
+	}
+
+	h.pruneHistory(now, rawKeys, hourlyKeys)
+}
+
+// pruneHistory deletes raw records of rolled-up hours and summaries past
+// their retention
+func (h *Handler) pruneHistory(now time.Time, rawKeys, hourlyKeys []string) {
+	cfg := h.cfg.History
+	remove := func(key string) {
+		if err := h.meta.Delete(key); err != nil && !errors.Is(err, store.ErrNotFound) {
+			slog.Error("Failed to delete query history", "error", err)
+		}
+	}
+	oldestRaw := now.Add(-cfg.RawRetention).Format(historyHourFormat)
+	for _, key := range rawKeys {
+		hour, _, _ := strings.Cut(strings.TrimPrefix(key, historyRawPrefix), "/")
+		if hour >= oldestRaw {
+			continue
+		}
+		// Never before the hour is summarized
+		if err := h.meta.Get(historyHourlyPrefix+hour, &historyPeriod{}); err == nil {
+			remove(key)
+		}
+	}
+	oldestHour := now.Add(-cfg.HourlyRetention).Format(historyHourFormat)
+	for _, key := range hourlyKeys {
+		if strings.TrimPrefix(key, historyHourlyPrefix) < oldestHour {
+			remove(key)
+		}
+	}
+	if cfg.DailyRetention == 0 {
+		return
+	}
+	dailyKeys, err := h.meta.List(historyDailyPrefix)
+	if err != nil {
+		slog.Error("Failed to list daily query history", "error", err)
+		return
+	}
+	oldestDay := now.Add(-cfg.DailyRetention).Format(usageDayFormat)
+	for _, key := range dailyKeys {
+		if strings.TrimPrefix(key, historyDailyPrefix) < oldestDay {
+			remove(key)
+		}
+	}
+}
+
+// addRollup adds r to the entry of the same fingerprint, principal and
+// workspace
+func addRollup(entries map[HistoryRollup]*HistoryRollup, r HistoryRollup) {
+	k := HistoryRollup{Fingerprint: r.Fingerprint, Principal: r.Principal, Workspace: r.Workspace}
+	if e, ok := entries[k]; ok {
+		e.add(r)
+		return
+	}
+	entries[k] = &r
+}
+
+// sortedRollups returns the entries by total time, longest first
+func sortedRollups(entries map[HistoryRollup]*HistoryRollup) []HistoryRollup {
+	out := make([]HistoryRollup, 0, len(entries))
+	for _, e := range entries {
+		out = append(out, *e)
+	}
+	slices.SortFunc(out, func(a, b HistoryRollup) int {
+		return cmp.Or(cmp.Compare(b.TotalMS, a.TotalMS), strings.Compare(a.Fingerprint, b.Fingerprint),
+			strings.Compare(a.Principal, b.Principal), strings.Compare(a.Workspace, b.Workspace))
+	})
+	return out
+}
+
+// HistoryPoint is the activity of one hour or day of the query history
+type HistoryPoint struct {
+	Period   string  `json:"period"`
+	Queries  int64   `json:"queries"`
+	Failures int64   `json:"failures"`
+	TotalMS  float64 `json:"total_ms"`
+	AvgMS    float64 `json:"avg_ms"`
+	MaxMS    float64 `json:"max_ms"`
+	Rows     int64   `json:"rows"`
+}
+
+// GetQueryAnalytics reports the queries run between ?from and ?to (dates,
+// both included; the last 7 days by default) from the rolled-up history: a
+// point per ?granularity (day, the default, or hour) and the ?limit groups
+// that took the most time, grouped by ?group_by (fingerprint, the default,
+// principal or workspace). The current hour is not rolled up yet; hours of
+// days without a daily summary stand in for it.
+func (h *Handler) GetQueryAnalytics(c *gin.Context) {
+	if !h.cfg.History.Enabled {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Query history is disabled"})
+		return
+	}
+	now := time.Now().UTC()
+	from := now.AddDate(0, 0, -6).Format(usageDayFormat)
+	to := now.Format(usageDayFormat)
+	for _, p := range []struct {
+		name  string
+		value *string
+	}{{"from", &from}, {"to", &to}} {
+		if v := c.Query(p.name); v != "" {
+			if _, err := time.Parse(usageDayFormat, v); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": p.name + " must be a date (YYYY-MM-DD)"})
+				return
+			}
+			*p.value = v
+		}
+	}
+	if from > to {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from must not be after to"})
+		return
+	}
+	granularity := c.DefaultQuery("granularity", "day")
+	if granularity != "day" && granularity != "hour" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "granularity must be day or hour"})
+		return
+	}
+	groupBy := c.DefaultQuery("group_by", "fingerprint")
+	if groupBy != "fingerprint" && groupBy != "principal" && groupBy != "workspace" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "group_by must be fingerprint, principal or workspace"})
+		return
+	}
+	limit, ok := intQuery(c, "limit", 20, 1000)
+	if !ok {
+		return
+	}
+
+	hourlyKeys, err := h.meta.List(historyHourlyPrefix)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	dailyKeys, err := h.meta.List(historyDailyPrefix)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	summarized := map[string]bool{}
+	for _, key := range dailyKeys {
+		summarized[strings.TrimPrefix(key, historyDailyPrefix)] = true
+	}
+	var keys []string
+	for _, key := range hourlyKeys {
+		day := strings.TrimPrefix(key, historyHourlyPrefix)[:len(usageDayFormat)]
+		if day >= from && day <= to && (granularity == "hour" || !summarized[day]) {
+			keys = append(keys, key)
+		}
+	}
+	if granularity == "day" {
+		for _, key := range dailyKeys {
+			if day := strings.TrimPrefix(key, historyDailyPrefix); day >= from && day <= to {
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	points := map[string]*HistoryPoint{}
+	groups := map[HistoryRollup]*HistoryRollup{}
+	for _, key := range keys {
+		var period historyPeriod
+		if err := h.meta.Get(key, &period); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		label := period.Period
+		if granularity == "day" {
+			label = label[:len(usageDayFormat)]
+		}
+		p := points[label]
+		if p == nil {
+			p = &HistoryPoint{Period: label}
+			points[label] = p
+		}
+		for _, e := range period.Entries {
+			p.Queries += e.Queries
+			p.Failures += e.Failures
+			p.TotalMS += e.TotalMS
+			p.MaxMS = math.Max(p.MaxMS, e.MaxMS)
+			p.Rows += e.Rows
+			g := HistoryRollup{}
+			switch groupBy {
+			case "fingerprint":
+				g.Fingerprint, g.SQL = e.Fingerprint, e.SQL
+			case "principal":
+				g.Principal = e.Principal
+			case "workspace":
+				g.Workspace = e.Workspace
+			}
+			g.add(e)
+			addRollup(groups, g)
+		}
+	}
+
+	series := []HistoryPoint{}
+	for _, label := range slices.Sorted(maps.Keys(points)) {
+		p := points[label]
+		if p.Queries > 0 {
+			p.AvgMS = math.Round(p.TotalMS/float64(p.Queries)*1000) / 1000
+		}
+		p.TotalMS = math.Round(p.TotalMS*1000) / 1000
+		series = append(series, *p)
+	}
+	top := sortedRollups(groups)
+	if len(top) > limit {
+		top = top[:limit]
+	}
+	for i := range top {
+		top[i].TotalMS = math.Round(top[i].TotalMS*1000) / 1000
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"from":        from,
+		"to":          to,
+		"granularity": granularity,
+		"group_by":    groupBy,
+		"series":      series,
+		"top":         top,
+	})
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"sql-engine/rbac"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HypertableInfo describes a TimescaleDB hypertable
+type HypertableInfo struct {
+	Schema             string                `json:"schema"`
+	Name               string                `json:"name"`
+	Dimensions         []HypertableDimension `json:"dimensions"`
+	Chunks             int64                 `json:"chunks"`
+	CompressionEnabled bool                  `json:"compression_enabled"`
+	CompressedChunks   int64                 `json:"compressed_chunks"`
+	// Policies are the retention and compression jobs of the hypertable
+	Policies []HypertablePolicy `json:"policies"`
+}
+
+// HypertableDimension is a column a hypertable is partitioned into chunks by
+type HypertableDimension struct {
+	Column   string `json:"column"`
+	DataType string `json:"data_type"`
+	// Kind is time or space
+	Kind string `json:"kind"`
+	// Interval is the chunk interval of a time dimension, e.g. "7 days"
+	Interval   string `json:"interval,omitempty"`
+	Partitions *int   `json:"partitions,omitempty"`
+}
+
+// HypertablePolicy is a background job dropping or compressing old chunks
+type HypertablePolicy struct {
+	JobID int64 `json:"job_id"`
+	// Type is retention or compression
+	Type string `json:"type"`
+	// After is the age of the chunks dropped or compressed
+	After            string `json:"after"`
+	ScheduleInterval string `json:"schedule_interval"`
+	Scheduled        bool   `json:"scheduled"`
+}
+
+// GetHypertables lists the hypertables of a schema. Databases without the
+// TimescaleDB extension have none.
+func (h *Handler) GetHypertables(c *gin.Context) {
+	schema, ok := h.schemaParam(c)
+	if !ok {
+		return
+	}
+	hypertables, err := h.dialect.ListHypertables(h.db, schema)
+	if errors.Is(err, errors.ErrUnsupported) {
+		c.JSON(http.StatusOK, gin.H{"schema": schema, "timescaledb": false, "hypertables": []HypertableInfo{}})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	access := rbac.FromContext(c.Request.Context())
+	out := []HypertableInfo{}
+	for _, ht := range hypertables {
+		if access.CanTable(h.grantSchema(schema), ht.Name) {
+			out = append(out, ht)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"schema": schema, "timescaledb": true, "hypertables": out})
+}
@@ -0,0 +1,457 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"sql-engine/alert"
+	"sql-engine/config"
+	"sql-engine/ingest"
+	"sql-engine/lineage"
+	"sql-engine/scheduler"
+	"sql-engine/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	importRunPrefix     = "import-run/"
+	importHistoryPrefix = "import-history/"
+)
+
+var identPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ImportRequest loads a remote file into a table. Uploads send the same
+// fields, except URL, as multipart form values next to the file.
+type ImportRequest struct {
+	URL    string `json:"url" form:"url"`
+	Table  string `json:"table" form:"table"`
+	Format string `json:"format" form:"format"` // csv or json; detected when empty
+	Mode   string `json:"mode" form:"mode"`     // append (default), replace or upsert
+	// Key lists the columns identifying a row in upsert mode
+	Key []string `json:"key" form:"key"`
+}
+
+// ImportResult describes one import run
+type ImportResult struct {
+	Table    string    `json:"table"`
+	Source   string    `json:"source"`
+	Mode     string    `json:"mode"`
+	Rows     int       `json:"rows"`
+	Replaced int       `json:"replaced,omitempty"` // existing rows overwritten by an upsert
+	Created  bool      `json:"created"`
+	Error    string    `json:"error,omitempty"`
+	RanAt    time.Time `json:"ran_at"`
+	Duration string    `json:"duration"`
+	// Retries counts the times the load was run again after a
+	// serialization failure or deadlock
+	Retries int `json:"retries,omitempty"`
+	// StagedFile is the artifact key of the source of a failed import, kept
+	// for inspection until the lifecycle rules delete it
+	StagedFile string `json:"staged_file,omitempty"`
+}
+
+// ImportData loads a CSV or JSON file into a table, either uploaded as the
+// multipart "file" field or fetched from an http(s) or s3 URL. Missing tables
+// are created with TEXT columns.
+func (h *Handler) ImportData(c *gin.Context) {
+	var req ImportRequest
+	ctx := c.Request.Context()
+
+	var (
+		body        io.ReadCloser
+		name        string
+		contentType string
+		source      string
+	)
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		if h.cfg.Imports.MaxBytes > 0 {
+			// Leave room for the other form fields
+			c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.cfg.Imports.MaxBytes+1<<20)
+		}
+		if err := c.ShouldBind(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validateImport(req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		fh, err := c.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Missing file: " + err.Error()})
+			return
+		}
+		if h.cfg.Imports.MaxBytes > 0 && fh.Size > h.cfg.Imports.MaxBytes {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": ingest.ErrTooLarge.Error()})
+			return
+		}
+		f, err := fh.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		body, name, contentType, source = f, fh.Filename, fh.Header.Get("Content-Type"), "upload:"+fh.Filename
+	} else {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if req.URL == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Either upload a file or set url"})
+			return
+		}
+		if err := validateImport(req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		remote, err := ingest.NewFetcher(h.cfg.Imports).Fetch(ctx, req.URL)
+		if errors.Is(err, ingest.ErrTooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Fetch failed: " + err.Error()})
+			return
+		}
+		body, name, contentType, source = remote.Body, remote.Name, remote.ContentType, req.URL
+	}
+	defer body.Close()
+
+	format, err := ingest.DetectFormat(req.Format, name, contentType)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	staged, r, err := h.stageImport(ctx, name, body)
+	if errors.Is(err, ingest.ErrTooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Staging the import failed: " + err.Error()})
+		return
+	}
+	defer r.Close()
+
+	result, err := h.importData(ctx, r, format, source, req)
+	h.finishStaging(staged, &result, err)
+	switch {
+	case errors.Is(err, ingest.ErrTooLarge):
+		c.JSON(http.StatusRequestEntityTooLarge, result)
+	case err != nil:
+		c.JSON(http.StatusUnprocessableEntity, result)
+	default:
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// GetImportFeeds lists the configured feeds with their last and next run
+func (h *Handler) GetImportFeeds(c *gin.Context) {
+	type feedStatus struct {
+		config.ImportFeed
+		LastRun *ImportResult `json:"last_run,omitempty"`
+		NextRun *time.Time    `json:"next_run,omitempty"`
+	}
+	feeds := []feedStatus{}
+	for _, f := range h.cfg.Imports.Feeds {
+		s := feedStatus{ImportFeed: f}
+		var run ImportResult
+		if err := h.meta.Get(importRunPrefix+f.Name, &run); err == nil {
+			s.LastRun = &run
+		}
+		if sched, err := scheduler.ParseCron(f.Schedule); err == nil {
+			if next := sched.Next(time.Now()); !next.IsZero() {
+				s.NextRun = &next
+			}
+		}
+		// Feed URLs may carry credentials
+		if u, err := url.Parse(f.URL); err == nil {
+			s.URL = u.Redacted()
+		}
+		feeds = append(feeds, s)
+	}
+	c.JSON(http.StatusOK, gin.H{"feeds": feeds})
+}
+
+// GetImportFeedRuns returns the run history of a feed, newest first
+func (h *Handler) GetImportFeedRuns(c *gin.Context) {
+	name := c.Param("name")
+	if !slices.ContainsFunc(h.cfg.Imports.Feeds, func(f config.ImportFeed) bool { return f.Name == name }) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Import feed not found"})
+		return
+	}
+
+	runs := []ImportResult{}
+	if err := h.meta.Get(importHistoryPrefix+name, &runs); err != nil && !errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"feed": name, "runs": runs})
+}
+
+// StartImportFeeds schedules the configured feeds
+func (h *Handler) StartImportFeeds() {
+	for _, feed := range h.cfg.Imports.Feeds {
+		job := func(ctx context.Context) { h.runFeed(ctx, feed) }
+		if feed.Schedule == "" {
+			h.sched.Every("import-feed:"+feed.Name, feed.Every, job)
+			continue
+		}
+		sched, err := scheduler.ParseCron(feed.Schedule)
+		if err != nil {
+			slog.Error("Import feed has an invalid schedule", "feed", feed.Name, "error", err)
+			continue
+		}
+		h.sched.Cron("import-feed:"+feed.Name, sched, job)
+	}
+}
+
+// runFeed pulls a feed, records the run in its history and alerts when a
+// feed starts or stops failing
+func (h *Handler) runFeed(ctx context.Context, feed config.ImportFeed) {
+	start := time.Now()
+	mode := feed.Mode
+	if mode == "" {
+		mode = "append"
+	}
+	result := ImportResult{Table: feed.Table, Source: feed.URL, Mode: mode, RanAt: start}
+
+	remote, err := ingest.NewFetcher(h.cfg.Imports).Fetch(ctx, feed.URL)
+	if err == nil {
+		var format string
+		format, err = ingest.DetectFormat(feed.Format, remote.Name, remote.ContentType)
+		var staged string
+		var r io.ReadCloser
+		if err == nil {
+			staged, r, err = h.stageImport(ctx, remote.Name, remote.Body)
+		}
+		if err == nil {
+			var importErr error
+			result, importErr = h.importData(ctx, r, format, feed.URL, ImportRequest{
+				Table: feed.Table,
+				Mode:  feed.Mode,
+				Key:   feed.Key,
+			})
+			r.Close()
+			h.finishStaging(staged, &result, importErr)
+		}
+		remote.Body.Close()
+	}
+	if err != nil {
+		result.Error = err.Error()
+		result.Duration = time.Since(start).String()
+	}
+	if u, err := url.Parse(result.Source); err == nil {
+		result.Source = u.Redacted()
+	}
+
+	var previous ImportResult
+	hadPrevious := h.meta.Get(importRunPrefix+feed.Name, &previous) == nil
+	if err := h.meta.Put(importRunPrefix+feed.Name, result); err != nil {
+		slog.ErrorContext(ctx, "Failed to store import run", "error", err)
+	}
+	h.recordFeedRun(feed.Name, result)
+
+	switch {
+	case result.Error != "" && (!hadPrevious || previous.Error == ""):
+		h.alerts.Send(ctx, alert.Alert{
+			Source:  "import",
+			Subject: feed.Name,
+			Status:  "failing",
+			Message: result.Error,
+			Details: result,
+		})
+	case result.Error == "" && hadPrevious && previous.Error != "":
+		h.alerts.Send(ctx, alert.Alert{
+			Source:  "import",
+			Subject: feed.Name,
+			Status:  "resolved",
+			Message: fmt.Sprintf("Loaded %d rows into %s", result.Rows, feed.Table),
+		})
+	}
+}
+
+// recordFeedRun prepends result to the feed's run history
+func (h *Handler) recordFeedRun(name string, result ImportResult) {
+	var runs []ImportResult
+	if err := h.meta.Get(importHistoryPrefix+name, &runs); err != nil && !errors.Is(err, store.ErrNotFound) {
+		slog.Error("Failed to read import history", "error", err)
+	}
+	runs = append([]ImportResult{result}, runs...)
+	if len(runs) > h.cfg.Imports.HistorySize {
+		runs = runs[:h.cfg.Imports.HistorySize]
+	}
+	if err := h.meta.Put(importHistoryPrefix+name, runs); err != nil {
+		slog.Error("Failed to store import history", "error", err)
+	}
+}
+
+func validateImport(req ImportRequest) error {
+	if !identPattern.MatchString(req.Table) {
+		return errors.New("table must be a plain identifier")
+	}
+	switch req.Mode {
+	case "", "append", "replace":
+	case "upsert":
+		if len(req.Key) == 0 {
+			return errors.New("upsert mode requires key columns")
+		}
+	default:
+		return errors.New("mode must be append, replace or upsert")
+	}
+	return nil
+}
+
+// importData parses body and loads it into the requested table. A failure is
+// returned and also recorded in the result.
+func (h *Handler) importData(ctx context.Context, body io.Reader, format, source string, req ImportRequest) (ImportResult, error) {
+	table, mode := req.Table, req.Mode
+	if mode == "" {
+		mode = "append"
+	}
+	start := time.Now()
+	result := ImportResult{Table: table, Source: source, Mode: mode, RanAt: start}
+
+	var outputs []lineage.Dataset
+	var inputs []lineage.Dataset
+	if h.lineage.Enabled() {
+		outputs = append(outputs, h.lineage.Dataset("", table))
+		if u, err := url.Parse(source); err == nil && u.Host != "" {
+			inputs = append(inputs, lineage.SourceDataset(u))
+		}
+	}
+	run := h.lineage.StartRun("import."+table, "", inputs, outputs)
+
+	data, err := ingest.Parse(body, format)
+	if err == nil {
+		result.Created, result.Replaced, result.Retries, err = h.loadTable(ctx, table, mode, req.Key, data)
+		result.Rows = len(data.Rows)
+	}
+	run.Finish(err)
+	if err != nil {
+		result.Error = err.Error()
+		result.Rows, result.Replaced = 0, 0
+	}
+	result.Duration = time.Since(start).String()
+	return result, err
+}
+
+// loadTable writes data into table in one transaction, creating the table
+// with TEXT columns when it does not exist. Upserts delete the rows matching
+// each incoming row's key before inserting it and report how many were
+// replaced, along with the times the transaction was retried.
+func (h *Handler) loadTable(ctx context.Context, table, mode string, key []string, data *ingest.Data) (bool, int, int, error) {
+	if len(data.Columns) == 0 {
+		return false, 0, 0, errors.New("source has no columns")
+	}
+	for i, col := range data.Columns {
+		if strings.TrimSpace(col) == "" {
+			data.Columns[i] = fmt.Sprintf("column_%d", i+1)
+		}
+	}
+	for i, col := range data.Columns {
+		if slices.Contains(data.Columns[:i], col) {
+			return false, 0, 0, fmt.Errorf("duplicate column %q", col)
+		}
+	}
+	keyIdx := make([]int, len(key))
+	for i, k := range key {
+		keyIdx[i] = slices.Index(data.Columns, k)
+		if keyIdx[i] < 0 {
+			return false, 0, 0, fmt.Errorf("key column %q is not in the source", k)
+		}
+	}
+
+	tables, err := h.dialect.ListTables(h.db, "")
+	if err != nil {
+		return false, 0, 0, err
+	}
+	exists := slices.ContainsFunc(tables, func(t TableInfo) bool { return t.Name == table })
+
+	release, err := h.acquireStatement(ctx)
+	if err != nil {
+		return false, 0, 0, err
+	}
+	defer release()
+
+	replaced := 0
+	retries, err := h.retryTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		q := h.dialect.Quote
+		cols := make([]string, len(data.Columns))
+		placeholders := make([]string, len(data.Columns))
+		for i, col := range data.Columns {
+			cols[i] = q(col)
+			placeholders[i] = h.dialect.Placeholder(i + 1)
+		}
+
+		if !exists {
+			defs := make([]string, len(cols))
+			for i, col := range cols {
+				defs[i] = col + " TEXT"
+			}
+			if _, err := tx.ExecContext(ctx, fmt.Sprintf("CREATE TABLE %s (%s)", q(table), strings.Join(defs, ", "))); err != nil {
+				return err
+			}
+		} else if mode == "replace" {
+			if _, err := tx.ExecContext(ctx, "DELETE FROM "+q(table)); err != nil {
+				return err
+			}
+		}
+
+		var del *sql.Stmt
+		if mode == "upsert" && exists {
+			conds := make([]string, len(key))
+			for i, k := range key {
+				conds[i] = q(k) + " = " + h.dialect.Placeholder(i+1)
+			}
+			del, err = tx.PrepareContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE %s", q(table), strings.Join(conds, " AND ")))
+			if err != nil {
+				return err
+			}
+			defer del.Close()
+		}
+
+		stmt, err := tx.PrepareContext(ctx, fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+			q(table), strings.Join(cols, ", "), strings.Join(placeholders, ", ")))
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		replaced = 0
+		for i, row := range data.Rows {
+			if del != nil {
+				args := make([]interface{}, len(keyIdx))
+				for j, idx := range keyIdx {
+					if row[idx] == nil {
+						return fmt.Errorf("row %d: key column %q is empty", i+1, key[j])
+					}
+					args[j] = row[idx]
+				}
+				res, err := del.ExecContext(ctx, args...)
+				if err != nil {
+					return fmt.Errorf("row %d: %w", i+1, err)
+				}
+				n, _ := res.RowsAffected()
+				replaced += int(n)
+			}
+			if _, err := stmt.ExecContext(ctx, row...); err != nil {
+				return fmt.Errorf("row %d: %w", i+1, err)
+			}
+		}
+		return nil
+	})
+	return !exists, replaced, retries, err
+}
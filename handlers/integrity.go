@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"sql-engine/rbac"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultIntegritySample = 5
+
+// IntegrityCheck reports the referential problems around one foreign key
+type IntegrityCheck struct {
+	ForeignKeyInfo
+	// Orphans are child rows referencing a parent that does not exist
+	Orphans IntegrityRows `json:"orphans"`
+	// Childless are parent rows no child row references
+	Childless IntegrityRows `json:"childless"`
+}
+
+// IntegrityRows counts matching rows and shows a few of them
+type IntegrityRows struct {
+	Count  int64                    `json:"count"`
+	Sample []map[string]interface{} `json:"sample"`
+}
+
+// CheckTableIntegrity finds orphaned child rows and childless parent rows
+// for every foreign key of a table, or only the one on ?column. ?sample sets
+// how many rows of each kind are returned.
+func (h *Handler) CheckTableIntegrity(c *gin.Context) {
+	schema, ok := h.schemaParam(c)
+	if !ok {
+		return
+	}
+	tableName := c.Param("name")
+	if !h.requireTable(c, schema, tableName) || !h.requireRowLevel(c, schema, tableName) {
+		return
+	}
+	sample, ok := intQuery(c, "sample", defaultIntegritySample, h.maxRows(c.Request.Context()))
+	if !ok {
+		return
+	}
+
+	fks, err := h.dialect.ListForeignKeys(h.db, schema, tableName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if column := c.Query("column"); column != "" {
+		var matching []ForeignKeyInfo
+		for _, fk := range fks {
+			if fk.Column == column {
+				matching = append(matching, fk)
+			}
+		}
+		if len(matching) == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "No foreign key on column " + column})
+			return
+		}
+		fks = matching
+	}
+	for _, fk := range fks {
+		if !h.requireTable(c, fk.ForeignSchema, fk.ForeignTable) || !h.requireRowLevel(c, fk.ForeignSchema, fk.ForeignTable) {
+			return
+		}
+	}
+
+	ctx := c.Request.Context()
+	release, err := h.acquireStatement(ctx)
+	if err != nil {
+		respondQueryError(c, err)
+		return
+	}
+	defer release()
+
+	checks := []IntegrityCheck{}
+	for _, fk := range fks {
+		check, err := h.checkForeignKey(ctx, schema, tableName, fk, sample)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		checks = append(checks, check)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"schema":     schema,
+		"table_name": tableName,
+		"checks":     checks,
+	})
+}
+
+func (h *Handler) checkForeignKey(ctx context.Context, schema, table string, fk ForeignKeyInfo, sample int) (IntegrityCheck, error) {
+	q := h.dialect.Quote
+	child := q(orDefault(h.dialect, schema)) + "." + q(table)
+	parent := q(orDefault(h.dialect, fk.ForeignSchema)) + "." + q(fk.ForeignTable)
+	join := fmt.Sprintf("p.%s = c.%s", q(fk.ForeignColumn), q(fk.Column))
+
+	check := IntegrityCheck{ForeignKeyInfo: fk}
+	var err error
+	check.Orphans, err = h.integrityRows(ctx, schema, table, sample, fmt.Sprintf(
+		"%s c WHERE c.%s IS NOT NULL AND NOT EXISTS (SELECT 1 FROM %s p WHERE %s)",
+		child, q(fk.Column), parent, join), "c")
+	if err != nil {
+		return check, err
+	}
+	check.Childless, err = h.integrityRows(ctx, fk.ForeignSchema, fk.ForeignTable, sample, fmt.Sprintf(
+		"%s p WHERE NOT EXISTS (SELECT 1 FROM %s c WHERE %s)",
+		parent, child, join), "p")
+	return check, err
+}
+
+// integrityRows counts the rows of "FROM from" and samples those of the
+// aliased table, leaving out columns the caller may not read and masking
+// the rest as /run-query would
+func (h *Handler) integrityRows(ctx context.Context, schema, table string, sample int, from, alias string) (IntegrityRows, error) {
+	out := IntegrityRows{Sample: []map[string]interface{}{}}
+	if err := h.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+from).Scan(&out.Count); err != nil {
+		return out, err
+	}
+	if out.Count == 0 || sample == 0 {
+		return out, nil
+	}
+
+	rows, err := h.db.QueryContext(ctx, fmt.Sprintf("SELECT %s.* FROM %s %s", alias, from, h.dialect.LimitClause(sample)))
+	if err != nil {
+		return out, err
+	}
+	defer rows.Close()
+	_, result, err := scanRowMaps(rows)
+	if err != nil {
+		return out, err
+	}
+
+	access := rbac.FromContext(ctx)
+	var roles []string
+	if access != nil {
+		roles = access.Roles
+	}
+	grantSchema := h.grantSchema(schema)
+	masked := h.masks.Columns(grantSchema, table, roles)
+	for _, row := range result {
+		for col, v := range row {
+			if !access.CanColumn(grantSchema, table, col) {
+				delete(row, col)
+			} else if m, ok := masked[strings.ToLower(col)]; ok {
+				row[col] = h.masks.Apply(m, v)
+			}
+		}
+	}
+	out.Sample = result
+	return out, nil
+}
@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultJSONSample = 1000
+	maxJSONSample     = 10000
+	// maxJSONFields caps the distinct paths reported for one column
+	maxJSONFields = 500
+	// maxJSONExamples caps the example values kept per path
+	maxJSONExamples = 3
+)
+
+// JSONField is a path found in the sampled documents. Path joins object
+// keys with dots and marks array elements with [], e.g. items[].sku.
+type JSONField struct {
+	Path string `json:"path"`
+	// Types counts the occurrences of each JSON type: object, array,
+	// string, number, boolean or null
+	Types map[string]int `json:"types"`
+	// Documents is the number of sampled documents holding the path, and
+	// Share that number over the documents sampled
+	Documents int           `json:"documents"`
+	Share     float64       `json:"share"`
+	Examples  []interface{} `json:"examples"`
+}
+
+// jsonStructure accumulates the fields of sampled documents
+type jsonStructure struct {
+	fields    map[string]*JSONField
+	truncated bool
+}
+
+// GetJSONStructure samples the first ?sample non-null values of the JSON
+// column ?column and infers their structure: every key path with the types
+// found there, how many documents hold it and a few example values. Paths
+// can be used in ?filter.<column>.<path> of GET /table/:name/rows, with
+// array indexes in place of [].
+func (h *Handler) GetJSONStructure(c *gin.Context) {
+	schema, ok := h.schemaParam(c)
+	if !ok {
+		return
+	}
+	tableName := c.Param("name")
+	if matchesAny(h.cfg.Query.Denylist.Tables, tableName) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Denied: table " + tableName + " is not allowed"})
+		return
+	}
+	if !h.requireTable(c, schema, tableName) || !h.requireRowLevel(c, schema, tableName) {
+		return
+	}
+	sample, ok := intQuery(c, "sample", defaultJSONSample, maxJSONSample)
+	if !ok {
+		return
+	}
+	columns, err := h.dialect.ListColumns(h.db, schema, tableName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(columns) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Table not found"})
+		return
+	}
+	column := c.Query("column")
+	if !h.analysisColumn(c, schema, tableName, columns, "column", column) {
+		return
+	}
+	i := slices.IndexFunc(columns, func(ci ColumnInfo) bool { return ci.Name == column })
+	q := h.dialect.Quote
+	doc, ok := h.dialect.JSONPath(q(column), columns[i].DataType, nil, false)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Column " + column + " does not hold JSON"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	if timeout := h.queryTimeout(ctx); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	release, err := h.acquireStatement(ctx)
+	if err != nil {
+		respondQueryError(c, err)
+		return
+	}
+	defer release()
+	start := time.Now()
+	tx, end, err := h.dialect.BeginReadOnly(ctx, h.queryDB(ctx))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start read-only transaction: " + err.Error()})
+		return
+	}
+	defer end()
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM %s.%s WHERE %s IS NOT NULL %s",
+		doc, q(schema), q(tableName), q(column), h.dialect.LimitClause(sample)))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Execution failed: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	s := &jsonStructure{fields: map[string]*JSONField{}}
+	roots := map[string]int{}
+	sampled, invalid := 0, 0
+	for rows.Next() {
+		var text sql.NullString
+		if err := rows.Scan(&text); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Row scan failed: " + err.Error()})
+			return
+		}
+		sampled++
+		var v interface{}
+		dec := json.NewDecoder(strings.NewReader(text.String))
+		dec.UseNumber()
+		if !text.Valid || dec.Decode(&v) != nil {
+			invalid++
+			continue
+		}
+		roots[jsonType(v)]++
+		seen := map[string]bool{}
+		s.walk("", v, seen)
+		for path := range seen {
+			s.fields[path].Documents++
+		}
+	}
+	meterStatement(ctx, time.Since(start), sampled)
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Row iteration error: " + err.Error()})
+		return
+	}
+
+	fields := make([]JSONField, 0, len(s.fields))
+	for _, path := range slices.Sorted(maps.Keys(s.fields)) {
+		f := s.fields[path]
+		if documents := sampled - invalid; documents > 0 {
+			f.Share = float64(f.Documents) / float64(documents)
+		}
+		fields = append(fields, *f)
+	}
+	warnings := []string{}
+	if s.truncated {
+		warnings = append(warnings, fmt.Sprintf("Only the first %d distinct paths are listed", maxJSONFields))
+	}
+	if invalid > 0 {
+		warnings = append(warnings, fmt.Sprintf("%d sampled values are not valid JSON", invalid))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"schema":     schema,
+		"table_name": tableName,
+		"column":     column,
+		"sampled":    sampled,
+		"invalid":    invalid,
+		"types":      roots,
+		"fields":     fields,
+		"warnings":   warnings,
+	})
+}
+
+// walk records the fields under v, found at path, marking in seen those
+// present in the current document
+func (s *jsonStructure) walk(path string, v interface{}, seen map[string]bool) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			p := key
+			if path != "" {
+				p = path + "." + key
+			}
+			if s.record(p, child, seen) {
+				s.walk(p, child, seen)
+			}
+		}
+	case []interface{}:
+		for _, child := range v {
+			if s.record(path+"[]", child, seen) {
+				s.walk(path+"[]", child, seen)
+			}
+		}
+	}
+}
+
+// record counts an occurrence of v at path, reporting false once the path
+// limit is reached for paths not seen before
+func (s *jsonStructure) record(path string, v interface{}, seen map[string]bool) bool {
+	f, ok := s.fields[path]
+	if !ok {
+		if len(s.fields) >= maxJSONFields {
+			s.truncated = true
+			return false
+		}
+		f = &JSONField{Path: path, Types: map[string]int{}, Examples: []interface{}{}}
+		s.fields[path] = f
+	}
+	t := jsonType(v)
+	f.Types[t]++
+	seen[path] = true
+	if t != "object" && t != "array" && t != "null" && len(f.Examples) < maxJSONExamples &&
+		!slices.ContainsFunc(f.Examples, func(e interface{}) bool { return sameValue(e, v) }) {
+		f.Examples = append(f.Examples, v)
+	}
+	return true
+}
+
+// jsonType names the JSON type of a decoded value
+func jsonType(v interface{}) string {
+	switch v.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case json.Number, float64:
+		return "number"
+	case bool:
+		return "boolean"
+	}
+	return "null"
+}
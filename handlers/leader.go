@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"sql-engine/cache"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StartLeaderElection campaigns for leadership of scheduled jobs. Only the
+// leader runs them, so replicas sharing a store don't each run every job.
+func (h *Handler) StartLeaderElection() {
+	if h.elector == nil {
+		return
+	}
+	h.elector.Run(context.Background())
+}
+
+// GetLeader reports which replica runs scheduled jobs
+func (h *Handler) GetLeader(c *gin.Context) {
+	cache.SetClass(c, cache.NoStore)
+	if h.elector == nil {
+		c.JSON(http.StatusOK, gin.H{
+			"replica": h.cfg.Server.ReplicaID,
+			"enabled": false,
+			"leader":  true,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"replica": h.cfg.Server.ReplicaID,
+		"enabled": true,
+		"leader":  h.elector.IsLeader(),
+		"lease":   h.elector.Current(),
+	})
+}
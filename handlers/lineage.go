@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"context"
+
+	"sql-engine/lineage"
+
+	sqlparser "github.com/blastrain/vitess-sqlparser/sqlparser"
+)
+
+type lineageJobKey struct{}
+
+// withLineageJob names the lineage job of statements executed with ctx
+func withLineageJob(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, lineageJobKey{}, name)
+}
+
+// lineageJob returns the job name set on ctx, or names ad-hoc queries after
+// their fingerprint so repeated runs of one query shape share a job
+func lineageJob(ctx context.Context, hash string) string {
+	if name, ok := ctx.Value(lineageJobKey{}).(string); ok {
+		return name
+	}
+	return "query." + hash
+}
+
+// lineageInputs lists the tables read by stmt as datasets
+func (h *Handler) lineageInputs(stmt sqlparser.Statement) []lineage.Dataset {
+	if !h.lineage.Enabled() {
+		return nil
+	}
+	var inputs []lineage.Dataset
+	seen := map[lineage.Dataset]bool{}
+	for _, t := range analyzeStatement(stmt).Tables {
+		ds := h.lineage.Dataset(t.Schema, t.Name)
+		if !seen[ds] {
+			seen[ds] = true
+			inputs = append(inputs, ds)
+		}
+	}
+	return inputs
+}
@@ -0,0 +1,326 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"sql-engine/cache"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// notifyBuffer is how many notifications a stream may fall behind
+	// before it is closed, to resume from its last event
+	notifyBuffer = 256
+	// notifyRetry is the reconnection delay suggested to clients, in
+	// milliseconds
+	notifyRetry      = 3000
+	maxNotifyBackoff = 30 * time.Second
+)
+
+// Notification is a NOTIFY received on a channel. ID is the epoch of the
+// channel's listener on this replica and a sequence number within it.
+type Notification struct {
+	ID      string    `json:"id"`
+	Channel string    `json:"channel"`
+	Payload string    `json:"payload"`
+	At      time.Time `json:"at"`
+}
+
+// notifyHub holds the channels this replica listens on
+type notifyHub struct {
+	mu          sync.Mutex
+	channels    map[string]*notifyChannel
+	subscribers int
+}
+
+// notifyChannel is a channel listened on, with its subscribers and latest
+// notifications. The epoch changes whenever notifications may have been
+// missed, e.g. when the connection was lost.
+type notifyChannel struct {
+	name   string
+	epoch  string
+	seq    int64
+	recent []Notification
+	subs   map[chan Notification]bool
+	cancel context.CancelFunc
+	// linger stops the listener once the last subscriber has been gone
+	// for notify.linger
+	linger *time.Timer
+	// ready is closed once the first LISTEN succeeded or failed, err
+	// holding the failure
+	ready chan struct{}
+	err   error
+}
+
+// notifySubscription is a stream's view of a channel: the notifications
+// it missed since its last event and those to come. Gap is set when some
+// were missed that can't be replayed.
+type notifySubscription struct {
+	channel  *notifyChannel
+	events   chan Notification
+	backfill []Notification
+	gap      bool
+}
+
+func notifyEpoch() string {
+	return strconv.FormatInt(time.Now().UnixNano(), 36)
+}
+
+// subscribeNotify subscribes to a channel, listening on it first if no
+// one does yet. lastID is the last event the client received, if any.
+func (h *Handler) subscribeNotify(channel, lastID string) (*notifySubscription, error) {
+	cfg := h.cfg.Notify
+	hub := &h.notify
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	if hub.subscribers >= cfg.MaxSubscribers {
+		return nil, &QueryError{Status: http.StatusServiceUnavailable, Message: "Too many notification streams are open", RetryAfter: 10 * time.Second}
+	}
+	if hub.channels == nil {
+		hub.channels = map[string]*notifyChannel{}
+	}
+	nc, ok := hub.channels[channel]
+	if !ok {
+		if len(hub.channels) >= cfg.MaxChannels {
+			return nil, &QueryError{Status: http.StatusServiceUnavailable, Message: "Too many channels are listened on", RetryAfter: 10 * time.Second}
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		nc = &notifyChannel{
+			name:   channel,
+			epoch:  notifyEpoch(),
+			subs:   map[chan Notification]bool{},
+			cancel: cancel,
+			ready:  make(chan struct{}),
+		}
+		hub.channels[channel] = nc
+		go h.listenLoop(ctx, nc)
+	}
+	if nc.linger != nil {
+		nc.linger.Stop()
+		nc.linger = nil
+	}
+
+	sub := &notifySubscription{channel: nc, events: make(chan Notification, notifyBuffer)}
+	if lastID != "" {
+		epoch, seqText, _ := strings.Cut(lastID, ".")
+		seq, err := strconv.ParseInt(seqText, 10, 64)
+		switch {
+		case err != nil || epoch != nc.epoch || seq > nc.seq:
+			sub.gap = true
+		default:
+			// recent holds consecutive sequence numbers ending at nc.seq
+			first := nc.seq - int64(len(nc.recent)) + 1
+			sub.gap = seq+1 < first
+			sub.backfill = slices.Clone(nc.recent[max(seq+1-first, 0):])
+		}
+	}
+	nc.subs[sub.events] = true
+	hub.subscribers++
+	return sub, nil
+}
+
+// unsubscribeNotify ends a subscription; the channel stays listened on for
+// notify.linger in case the client comes back
+func (h *Handler) unsubscribeNotify(sub *notifySubscription) {
+	hub := &h.notify
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	nc := sub.channel
+	if nc.subs[sub.events] {
+		delete(nc.subs, sub.events)
+		hub.subscribers--
+	}
+	if len(nc.subs) > 0 || hub.channels[nc.name] != nc || nc.linger != nil {
+		return
+	}
+	nc.linger = time.AfterFunc(h.cfg.Notify.Linger, func() {
+		hub.mu.Lock()
+		defer hub.mu.Unlock()
+		if len(nc.subs) == 0 && hub.channels[nc.name] == nc {
+			nc.cancel()
+			delete(hub.channels, nc.name)
+		}
+	})
+}
+
+// listenLoop listens on a channel until it is stopped, reconnecting with
+// a backoff. Notifications sent while disconnected are lost, so a reconnect
+// starts a new epoch and closes the streams, which resume with a gap.
+func (h *Handler) listenLoop(ctx context.Context, nc *notifyChannel) {
+	hub := &h.notify
+	var once sync.Once
+	ready := func() { once.Do(func() { close(nc.ready) }) }
+	backoff := time.Second
+	for {
+		listening := false
+		err := h.dialect.Listen(ctx, h.db, nc.name, func() {
+			listening = true
+			backoff = time.Second
+			ready()
+		}, func(payload string) {
+			h.publishNotify(nc, payload)
+		})
+		if ctx.Err() != nil {
+			return
+		}
+
+		hub.mu.Lock()
+		for sub := range nc.subs {
+			close(sub)
+			delete(nc.subs, sub)
+			hub.subscribers--
+		}
+		nc.epoch, nc.seq, nc.recent = notifyEpoch(), 0, nil
+		if !listening && !isClosed(nc.ready) {
+			// Nobody has been told the channel works; give up on it
+			nc.err = err
+			if hub.channels[nc.name] == nc {
+				delete(hub.channels, nc.name)
+			}
+			hub.mu.Unlock()
+			nc.cancel()
+			ready()
+			return
+		}
+		hub.mu.Unlock()
+
+		slog.ErrorContext(ctx, "Listening on channel failed", "channel", nc.name, "retry_in", backoff.String(), "error", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxNotifyBackoff)
+	}
+}
+
+// publishNotify records a notification and hands it to the subscribers.
+// Those too far behind are dropped, to resume from their last event.
+func (h *Handler) publishNotify(nc *notifyChannel, payload string) {
+	hub := &h.notify
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	nc.seq++
+	n := Notification{
+		ID:      nc.epoch + "." + strconv.FormatInt(nc.seq, 10),
+		Channel: nc.name,
+		Payload: payload,
+		At:      time.Now(),
+	}
+	if limit := h.cfg.Notify.Backfill; limit > 0 {
+		if len(nc.recent) >= limit {
+			nc.recent = nc.recent[len(nc.recent)-limit+1:]
+		}
+		nc.recent = append(nc.recent, n)
+	}
+	for sub := range nc.subs {
+		select {
+		case sub <- n:
+		default:
+			close(sub)
+			delete(nc.subs, sub)
+			hub.subscribers--
+		}
+	}
+}
+
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+// Listen streams the notifications of a PostgreSQL channel as server-sent
+// events named notification, with the Notification as data. Clients
+// reconnecting with Last-Event-ID (or ?last_event_id) first get what they
+// missed from the latest notify.backfill notifications, or a gap event
+// when some can't be replayed, after which they should reload what they
+// show. Channels must match notify.channels.
+func (h *Handler) Listen(c *gin.Context) {
+	patterns := h.cfg.Notify.Channels
+	if len(patterns) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Notifications are not enabled"})
+		return
+	}
+	channel := c.Param("channel")
+	if !matchesAny(patterns, channel) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Denied: channel " + channel + " is not allowed"})
+		return
+	}
+	lastID := c.GetHeader("Last-Event-ID")
+	if lastID == "" {
+		lastID = c.Query("last_event_id")
+	}
+
+	sub, err := h.subscribeNotify(channel, lastID)
+	if err != nil {
+		respondQueryError(c, err)
+		return
+	}
+	defer h.unsubscribeNotify(sub)
+	ctx := c.Request.Context()
+	select {
+	case <-ctx.Done():
+		return
+	case <-sub.channel.ready:
+	}
+	if err := sub.channel.err; err != nil {
+		if errors.Is(err, errors.ErrUnsupported) {
+			c.JSON(http.StatusNotImplemented, gin.H{"error": "Notifications are not supported by this database"})
+		} else {
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to listen on channel " + channel + ": " + err.Error()})
+		}
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("X-Accel-Buffering", "no")
+	cache.SetClass(c, cache.Streamed)
+	c.Status(http.StatusOK)
+	fmt.Fprintf(c.Writer, "retry: %d\n\n", notifyRetry)
+	if sub.gap {
+		fmt.Fprint(c.Writer, "event: gap\ndata: {}\n\n")
+	}
+	for _, n := range sub.backfill {
+		writeNotification(c, n)
+	}
+	c.Writer.Flush()
+
+	keepalive := time.NewTicker(h.cfg.Notify.Keepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case n, ok := <-sub.events:
+			if !ok {
+				// Fell behind or the listener reconnected; the client
+				// resumes from its last event
+				return
+			}
+			writeNotification(c, n)
+		case <-keepalive.C:
+			fmt.Fprint(c.Writer, ": keepalive\n\n")
+		}
+		c.Writer.Flush()
+	}
+}
+
+func writeNotification(c *gin.Context, n Notification) {
+	data, _ := json.Marshal(n)
+	fmt.Fprintf(c.Writer, "id: %s\nevent: notification\ndata: %s\n\n", n.ID, data)
+}
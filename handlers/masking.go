@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+
+	"sql-engine/rbac"
+
+	sqlparser "github.com/blastrain/vitess-sqlparser/sqlparser"
+)
+
+// maskPlan holds the masking method of each result column of a query
+type maskPlan struct {
+	// byIndex is used when the select list has no *, so result columns line
+	// up with select expressions
+	byIndex []string
+	// byName maps lowercased result column names to methods otherwise
+	byName map[string]string
+}
+
+// planMasking works out which result columns of stmt are masked for the
+// caller. Expressions over a masked column are redacted as a whole, and
+// masked columns may only be read in the select list, outside subqueries.
+func (h *Handler) planMasking(ctx context.Context, stmt sqlparser.Statement) (*maskPlan, error) {
+	var roles []string
+	if access := rbac.FromContext(ctx); access != nil {
+		roles = access.Roles
+	}
+	refs := analyzeStatement(stmt)
+
+	colMethod := func(col *sqlparser.ColName) string {
+		if t, ok := refs.column(col); ok {
+			return h.masks.Method(t.Schema, t.Name, col.Name.String(), roles)
+		}
+		// Unresolved columns could come from any table
+		for _, t := range refs.Tables {
+			if m := h.masks.Method(t.Schema, t.Name, col.Name.String(), roles); m != "" {
+				return m
+			}
+		}
+		return ""
+	}
+	exprMethod := func(expr sqlparser.Expr) string {
+		if col, ok := expr.(*sqlparser.ColName); ok {
+			return colMethod(col)
+		}
+		method := ""
+		sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+			if col, ok := node.(*sqlparser.ColName); ok && colMethod(col) != "" {
+				method = "redact"
+			}
+			return true, nil
+		}, expr)
+		return method
+	}
+
+	// Outside the select list a masked column tells about its values, by
+	// filtering, grouping, joining or ordering on them, so it may only be
+	// read there by exempt callers
+	sel := stmt.(*sqlparser.Select)
+	var denied, clause string
+	check := func(name string, node sqlparser.SQLNode) {
+		sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+			if col, ok := node.(*sqlparser.ColName); ok && denied == "" && colMethod(col) != "" {
+				denied, clause = col.Name.String(), name
+			}
+			return denied == "", nil
+		}, node)
+	}
+	for _, expr := range sel.SelectExprs {
+		sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+			if sub, ok := node.(*sqlparser.Subquery); ok {
+				check("a subquery", sub)
+				return false, nil
+			}
+			return true, nil
+		}, expr)
+	}
+	check("FROM or JOIN", sel.From)
+	check("WHERE", sel.Where)
+	check("GROUP BY", sel.GroupBy)
+	check("HAVING", sel.Having)
+	check("ORDER BY", sel.OrderBy)
+	if denied != "" {
+		return nil, &QueryError{Status: http.StatusForbidden, Message: "Masked column " + denied + " cannot be read in " + clause}
+	}
+
+	plan := &maskPlan{byName: map[string]string{}}
+	hasStar := false
+	for _, expr := range sel.SelectExprs {
+		if _, ok := expr.(*sqlparser.StarExpr); ok {
+			hasStar = true
+		}
+	}
+
+	for _, expr := range sel.SelectExprs {
+		switch expr := expr.(type) {
+		case *sqlparser.StarExpr:
+			tables := refs.Tables
+			if !expr.TableName.IsEmpty() {
+				t, _ := refs.star(expr)
+				tables = []tableRef{t}
+			}
+			for _, t := range tables {
+				for col, m := range h.masks.Columns(t.Schema, t.Name, roles) {
+					plan.byName[col] = m
+				}
+			}
+		case *sqlparser.AliasedExpr:
+			m := exprMethod(expr.Expr)
+			plan.byIndex = append(plan.byIndex, m)
+			if m == "" || !hasStar {
+				continue
+			}
+			name := expr.As.String()
+			if col, ok := expr.Expr.(*sqlparser.ColName); ok && name == "" {
+				name = col.Name.String()
+			}
+			if name == "" {
+				return nil, &QueryError{Status: http.StatusBadRequest, Message: "Expressions over masked columns must be aliased when selecting *"}
+			}
+			plan.byName[strings.ToLower(name)] = m
+		}
+	}
+	if hasStar {
+		plan.byIndex = nil
+	}
+	return plan, nil
+}
+
+// applyMasking returns rows with masked values replaced. Rows are copied so the
+// unmasked originals stay intact for the canary comparison.
+func (h *Handler) applyMasking(plan *maskPlan, cols []string, rows []map[string]interface{}) []map[string]interface{} {
+	methods := map[string]string{}
+	for i, col := range cols {
+		m := plan.byName[strings.ToLower(col)]
+		if plan.byIndex != nil && len(plan.byIndex) == len(cols) {
+			m = plan.byIndex[i]
+		}
+		if m != "" {
+			methods[col] = m
+		}
+	}
+	if len(methods) == 0 {
+		return rows
+	}
+
+	masked := make([]map[string]interface{}, len(rows))
+	for i, row := range rows {
+		out := make(map[string]interface{}, len(row))
+		for col, v := range row {
+			if m, ok := methods[col]; ok {
+				v = h.masks.Apply(m, v)
+			}
+			out[col] = v
+		}
+		masked[i] = out
+	}
+	return masked
+}
+
+// masksAny reports whether any result column is masked
+func (p *maskPlan) masksAny() bool {
+	return len(p.byName) > 0 || slices.ContainsFunc(p.byIndex, func(m string) bool { return m != "" })
+}
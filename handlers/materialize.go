@@ -0,0 +1,356 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"sql-engine/auth"
+	"sql-engine/cache"
+	"sql-engine/lineage"
+	"sql-engine/rbac"
+	"sql-engine/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	materializeJobPrefix    = "materialize-job/"
+	materializedTablePrefix = "materialized-table/"
+
+	// Running jobs are re-saved every heartbeat; a job whose replica missed
+	// several is considered lost
+	materializeHeartbeat = 15 * time.Second
+	materializeLostAfter = 4 * materializeHeartbeat
+)
+
+// MaterializeRequest writes the result of a SELECT into a table. Mode is
+// "create" (default, the table must not exist), "append" or "replace".
+type MaterializeRequest struct {
+	SQL   string `json:"sql"`
+	Table string `json:"table"`
+	Mode  string `json:"mode"`
+}
+
+// MaterializeJob tracks one materialization
+type MaterializeJob struct {
+	ID         string     `json:"id"`
+	Table      string     `json:"table"`
+	Mode       string     `json:"mode"`
+	SQL        string     `json:"sql"`
+	Owner      string     `json:"owner,omitempty"`
+	Status     string     `json:"status"` // running, succeeded or failed
+	Rows       int64      `json:"rows"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Duration   string     `json:"duration,omitempty"`
+	// Retries counts the times the job's transaction was run again after a
+	// serialization failure or deadlock
+	Retries int `json:"retries,omitempty"`
+	// Replica runs the job and refreshes HeartbeatAt while it does
+	Replica     string    `json:"replica"`
+	HeartbeatAt time.Time `json:"heartbeat_at"`
+}
+
+// MaterializedTable records who created a table through /materialize; only
+// those tables can later be appended to or replaced
+type MaterializedTable struct {
+	Table     string    `json:"table"`
+	Owner     string    `json:"owner,omitempty"`
+	JobID     string    `json:"job_id"`
+	SQL       string    `json:"sql"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Materialize validates a SELECT like /run-query and starts a job writing its
+// full result into a table with CREATE TABLE AS or INSERT INTO ... SELECT
+func (h *Handler) Materialize(c *gin.Context) {
+	var req MaterializeRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		return
+	}
+	if req.Mode == "" {
+		req.Mode = "create"
+	}
+	if !identPattern.MatchString(req.Table) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "table must be a plain identifier"})
+		return
+	}
+	if !slices.Contains([]string{"create", "append", "replace"}, req.Mode) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mode must be create, append or replace"})
+		return
+	}
+	if matchesAny(h.cfg.Query.Denylist.Tables, req.Table) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Denied: table " + req.Table + " is not allowed"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	prep, err := h.prepareSelect(ctx, req.SQL)
+	if err != nil {
+		respondQueryError(c, err)
+		return
+	}
+	if prep.Mask.masksAny() {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Masked columns cannot be materialized"})
+		return
+	}
+	if prep.Aggregate != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Aggregate-only table " + prep.Aggregate.Table + " cannot be materialized"})
+		return
+	}
+
+	tables, err := h.dialect.ListTables(h.db, "")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	exists := slices.ContainsFunc(tables, func(t TableInfo) bool { return t.Name == req.Table })
+	if exists {
+		if req.Mode == "create" {
+			c.JSON(http.StatusConflict, gin.H{"error": "Table " + req.Table + " already exists"})
+			return
+		}
+		var owned MaterializedTable
+		if err := h.meta.Get(materializedTablePrefix+req.Table, &owned); err != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only tables created by /materialize can be appended to or replaced"})
+			return
+		}
+		if owned.Owner != "" && owned.Owner != auth.Principal(c) && !rbac.FromContext(ctx).IsAdmin() {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only the owner can append to or replace " + req.Table})
+			return
+		}
+	}
+
+	job := MaterializeJob{
+		ID:        newID(),
+		Table:     req.Table,
+		Mode:      req.Mode,
+		SQL:       prep.SQL,
+		Owner:     auth.Principal(c),
+		Status:    "running",
+		CreatedAt: time.Now(),
+		Replica:   h.cfg.Server.ReplicaID,
+	}
+	job.HeartbeatAt = job.CreatedAt
+	// Track the job before it is visible so the janitor doesn't fail it
+	h.setRunningJob(job.ID, true)
+	if err := h.meta.Put(materializeJobPrefix+job.ID, job); err != nil {
+		h.setRunningJob(job.ID, false)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	h.goBackground(context.Background(), func(ctx context.Context) {
+		h.runMaterialize(ctx, job, prep, exists)
+	})
+
+	c.JSON(http.StatusAccepted, job)
+}
+
+// ListMaterializeJobs lists materialization jobs, newest first
+func (h *Handler) ListMaterializeJobs(c *gin.Context) {
+	keys, err := h.meta.List(materializeJobPrefix)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	jobs := []MaterializeJob{}
+	for _, key := range keys {
+		var job MaterializeJob
+		if err := h.meta.Get(key, &job); err != nil {
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].CreatedAt.After(jobs[j].CreatedAt) })
+	c.JSON(http.StatusOK, gin.H{"jobs": jobs})
+}
+
+func (h *Handler) GetMaterializeJob(c *gin.Context) {
+	var job MaterializeJob
+	if err := h.meta.Get(materializeJobPrefix+c.Param("id"), &job); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Materialize job not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	if job.Status != "running" {
+		// Finished jobs are never written again
+		cache.SetClass(c, cache.Immutable)
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// StartMaterializeJanitor periodically fails running jobs whose replica
+// stopped sending heartbeats, e.g. because it was restarted or scaled away
+func (h *Handler) StartMaterializeJanitor() {
+	janitor := func(ctx context.Context) {
+		h.failLostMaterializeJobs()
+	}
+	h.sched.Go("materialize-janitor", janitor)
+	h.sched.Every("materialize-janitor", materializeHeartbeat, janitor)
+}
+
+func (h *Handler) failLostMaterializeJobs() {
+	keys, err := h.meta.List(materializeJobPrefix)
+	if err != nil {
+		slog.Error("Failed to list materialize jobs", "error", err)
+		return
+	}
+	for _, key := range keys {
+		var job MaterializeJob
+		if err := h.meta.Get(key, &job); err != nil || job.Status != "running" {
+			continue
+		}
+		switch {
+		case job.Replica == h.cfg.Server.ReplicaID:
+			// Jobs of this replica are tracked here unless it restarted
+			if h.runningJob(job.ID) {
+				continue
+			}
+			job.Error = "interrupted by a server restart"
+		case time.Since(job.HeartbeatAt) < materializeLostAfter:
+			continue
+		default:
+			job.Error = fmt.Sprintf("replica %s stopped running the job", job.Replica)
+		}
+		job.Status = "failed"
+		if err := h.meta.Put(key, job); err != nil {
+			slog.Error("Failed to store materialize job", "error", err)
+		}
+	}
+}
+
+func (h *Handler) runningJob(id string) bool {
+	h.jobsMu.Lock()
+	defer h.jobsMu.Unlock()
+	return h.jobs[id]
+}
+
+func (h *Handler) setRunningJob(id string, running bool) {
+	h.jobsMu.Lock()
+	defer h.jobsMu.Unlock()
+	if running {
+		h.jobs[id] = true
+	} else {
+		delete(h.jobs, id)
+	}
+}
+
+// heartbeat re-saves a running job until stop is closed; done is closed once
+// it has stopped writing
+func (h *Handler) heartbeat(job MaterializeJob, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(materializeHeartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			job.HeartbeatAt = time.Now()
+			if err := h.meta.Put(materializeJobPrefix+job.ID, job); err != nil {
+				slog.Error("Failed to store materialize job heartbeat", "error", err)
+			}
+		}
+	}
+}
+
+// runMaterialize executes a job and stores its outcome
+func (h *Handler) runMaterialize(ctx context.Context, job MaterializeJob, prep *preparedSelect, exists bool) {
+	if h.cfg.Query.MaterializeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.cfg.Query.MaterializeTimeout)
+		defer cancel()
+	}
+
+	defer h.setRunningJob(job.ID, false)
+	stop, done := make(chan struct{}), make(chan struct{})
+	go h.heartbeat(job, stop, done)
+
+	start := time.Now()
+	var outputs []lineage.Dataset
+	if h.lineage.Enabled() {
+		outputs = append(outputs, h.lineage.Dataset("", job.Table))
+	}
+	run := h.lineage.StartRun("materialize."+job.Table, prep.SQL, h.lineageInputs(prep.Stmt), outputs)
+
+	rows, retries, err := h.materialize(ctx, job, strings.TrimRight(prep.SQL, "; \t\n"), exists)
+	run.Finish(err)
+	close(stop)
+	<-done
+
+	finished := time.Now()
+	job.FinishedAt = &finished
+	job.HeartbeatAt = finished
+	job.Duration = finished.Sub(start).String()
+	job.Retries = retries
+	if err != nil {
+		job.Status = "failed"
+		job.Error = err.Error()
+	} else {
+		job.Status = "succeeded"
+		job.Rows = rows
+	}
+	if err := h.meta.Put(materializeJobPrefix+job.ID, job); err != nil {
+		slog.Error("Failed to store materialize job", "error", err)
+	}
+	if err != nil {
+		return
+	}
+
+	if job.Mode != "append" || !exists {
+		owned := MaterializedTable{Table: job.Table, Owner: job.Owner, JobID: job.ID, SQL: job.SQL, UpdatedAt: finished}
+		if err := h.meta.Put(materializedTablePrefix+job.Table, owned); err != nil {
+			slog.Error("Failed to record materialized table", "error", err)
+		}
+		if _, err := h.reloadSchema(""); err != nil {
+			slog.Error("Schema refresh after materialize failed", "error", err)
+		}
+	}
+}
+
+// materialize writes the result of selectSQL into the job's table in one
+// transaction and returns the number of rows written and the times the
+// transaction was retried
+func (h *Handler) materialize(ctx context.Context, job MaterializeJob, selectSQL string, exists bool) (int64, int, error) {
+	release, err := h.acquireStatement(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer release()
+
+	table := h.dialect.Quote(job.Table)
+	var rows int64
+	retries, err := h.retryTx(ctx, func(tx *sql.Tx) error {
+		if exists && job.Mode == "append" {
+			res, err := tx.ExecContext(ctx, fmt.Sprintf("INSERT INTO %s %s", table, selectSQL))
+			if err != nil {
+				return err
+			}
+			rows, err = res.RowsAffected()
+			return err
+		}
+		if exists {
+			if _, err := tx.ExecContext(ctx, "DROP TABLE "+table); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("CREATE TABLE %s AS %s", table, selectSQL)); err != nil {
+			return err
+		}
+		return tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+table).Scan(&rows)
+	})
+	return rows, retries, err
+}
@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"sql-engine/rbac"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MetaRequest runs a psql-style meta-command such as `\d users`. Timing is
+// the client's current \timing setting, which a bare \timing toggles.
+type MetaRequest struct {
+	Command string `json:"command"`
+	Timing  bool   `json:"timing"`
+}
+
+// TableDescription is the result of \d on a table
+type TableDescription struct {
+	TableSchema
+	Indexes  []IndexInfo   `json:"indexes"`
+	Triggers []TriggerInfo `json:"triggers"`
+}
+
+// RunMetaCommand interprets the meta-commands \d, \dt, \df, \l and \timing
+// with the same introspection the schema endpoints use. Patterns take * and
+// ? wildcards; names may be qualified with their schema.
+func (h *Handler) RunMetaCommand(c *gin.Context) {
+	var req MetaRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		return
+	}
+	fields := strings.Fields(req.Command)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], `\`) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": `Meta-commands start with a backslash, e.g. \dt`})
+		return
+	}
+	// The "+" variants of psql show more detail; here that only adds function
+	// definitions to \df
+	name, verbose := strings.CutSuffix(fields[0], "+")
+	args := fields[1:]
+	if len(args) > 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": name + ": too many arguments"})
+		return
+	}
+	arg := ""
+	if len(args) == 1 {
+		arg = args[0]
+	}
+
+	switch name {
+	case `\d`:
+		if arg == "" || strings.ContainsAny(arg, "*?") {
+			h.metaTables(c, name, arg)
+		} else {
+			h.metaDescribe(c, name, arg)
+		}
+	case `\dt`:
+		h.metaTables(c, name, arg)
+	case `\df`:
+		h.metaFunctions(c, name, arg, verbose)
+	case `\l`:
+		databases, err := h.dialect.ListDatabases(h.db)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if arg != "" {
+			databases = slices.DeleteFunc(databases, func(db string) bool { return !matchesAny([]string{arg}, db) })
+		}
+		c.JSON(http.StatusOK, gin.H{"command": name, "kind": "databases", "result": databases})
+	case `\timing`:
+		timing := !req.Timing
+		switch strings.ToLower(arg) {
+		case "":
+		case "on":
+			timing = true
+		case "off":
+			timing = false
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": `\timing expects "on" or "off"`})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"command": name, "kind": "setting", "result": gin.H{"timing": timing}})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown meta-command " + name})
+	}
+}
+
+// metaTarget splits an optionally schema-qualified name or pattern. The
+// schema must exist; an unqualified name uses the ?schema parameter.
+func (h *Handler) metaTarget(c *gin.Context, arg string) (schema, name string, ok bool) {
+	qualifier, name, qualified := strings.Cut(arg, ".")
+	if !qualified {
+		schema, ok = h.schemaParam(c)
+		return schema, arg, ok
+	}
+	if h.isDefaultSchema(qualifier) {
+		return orDefault(h.dialect, qualifier), name, true
+	}
+	schemas, err := h.dialect.ListSchemas(h.db)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return "", "", false
+	}
+	if !slices.Contains(schemas, qualifier) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Schema " + qualifier + " not found"})
+		return "", "", false
+	}
+	return qualifier, name, true
+}
+
+func (h *Handler) metaTables(c *gin.Context, command, arg string) {
+	schema, pattern, ok := h.metaTarget(c, arg)
+	if !ok {
+		return
+	}
+	tables, err := h.dialect.ListTables(h.db, schema)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	tables = filterTables(rbac.FromContext(c.Request.Context()), h.grantSchema(schema), tables)
+	out := []TableInfo{}
+	for _, t := range tables {
+		if pattern == "" || matchesAny([]string{pattern}, t.Name) {
+			out = append(out, t)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"command": command, "kind": "tables", "schema": schema, "result": out})
+}
+
+func (h *Handler) metaDescribe(c *gin.Context, command, arg string) {
+	schema, tableName, ok := h.metaTarget(c, arg)
+	if !ok || !h.requireTable(c, schema, tableName) {
+		return
+	}
+	table, err := h.getTableSchema(schema, tableName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(table.Columns) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Did not find any relation named " + arg})
+		return
+	}
+	table.Columns = filterColumns(rbac.FromContext(c.Request.Context()), h.grantSchema(schema), tableName, table.Columns)
+	if h.isDefaultSchema(schema) {
+		table.Columns = h.describeTableColumns(tableName, table.Columns)
+	}
+
+	desc := TableDescription{TableSchema: table, Indexes: []IndexInfo{}, Triggers: []TriggerInfo{}}
+	if indexes, err := h.dialect.ListIndexes(h.db, schema, tableName); err == nil && indexes != nil {
+		desc.Indexes = indexes
+	}
+	if triggers, err := h.dialect.ListTriggers(h.db, schema, tableName); err == nil && triggers != nil {
+		desc.Triggers = triggers
+	}
+	c.JSON(http.StatusOK, gin.H{"command": command, "kind": "table", "schema": schema, "result": desc})
+}
+
+func (h *Handler) metaFunctions(c *gin.Context, command, arg string, verbose bool) {
+	schema, pattern, ok := h.metaTarget(c, arg)
+	if !ok {
+		return
+	}
+	functions, err := h.dialect.ListFunctions(h.db, schema)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	out := []FunctionInfo{}
+	for _, f := range functions {
+		if pattern != "" && !matchesAny([]string{pattern}, f.Name) {
+			continue
+		}
+		if !verbose {
+			f.Definition = ""
+		}
+		out = append(out, f)
+	}
+	c.JSON(http.StatusOK, gin.H{"command": command, "kind": "functions", "schema": schema, "result": out})
+}
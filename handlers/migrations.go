@@ -0,0 +1,470 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"sql-engine/auth"
+
+	"github.com/gin-gonic/gin"
+)
+
+// migrationFile matches <version>_<name>.up.sql and .down.sql; a plain
+// <version>_<name>.sql is an up migration
+var migrationFile = regexp.MustCompile(`^([0-9]+)_([^.]+)(\.up|\.down)?\.sql$`)
+
+// migrationExplain names the savepoint the statements of a dry run are
+// explained in
+const migrationExplain = "migration_explain"
+
+// Migration is one versioned schema change. Down reverts it; without it the
+// migration cannot be reverted.
+type Migration struct {
+	Version int64  `json:"version"`
+	Name    string `json:"name"`
+	Up      string `json:"up"`
+	Down    string `json:"down,omitempty"`
+}
+
+func (m Migration) checksum() string {
+	sum := sha256.Sum256([]byte(m.Up))
+	return hex.EncodeToString(sum[:])
+}
+
+// MigrationStatus reports a migration of migrations.dir or the tracking
+// table. Status is applied or pending.
+type MigrationStatus struct {
+	Version    int64  `json:"version"`
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	Reversible bool   `json:"reversible"`
+	Checksum   string `json:"checksum"`
+	// Modified is set when the file changed since it was applied
+	Modified  bool       `json:"modified,omitempty"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+	AppliedBy string     `json:"applied_by,omitempty"`
+	Duration  string     `json:"duration,omitempty"`
+}
+
+// MigrateUpRequest applies the pending migrations up to Target, or all of
+// them when it is 0. Migrations are uploaded next to those of
+// migrations.dir.
+type MigrateUpRequest struct {
+	Target     int64       `json:"target"`
+	DryRun     bool        `json:"dry_run"`
+	Migrations []Migration `json:"migrations"`
+}
+
+// MigrateDownRequest reverts the last Steps applied migrations (default 1),
+// or those above Target when it is set
+type MigrateDownRequest struct {
+	Steps  int    `json:"steps"`
+	Target *int64 `json:"target"`
+	DryRun bool   `json:"dry_run"`
+}
+
+// MigrationRun reports the migrations a request applied or reverted, and in
+// a dry run the plans of their statements. Error is set when a migration
+// failed; those before it stay applied.
+type MigrationRun struct {
+	Direction  string          `json:"direction"`
+	DryRun     bool            `json:"dry_run"`
+	Migrations []MigrationStep `json:"migrations"`
+	Error      string          `json:"error,omitempty"`
+}
+
+type MigrationStep struct {
+	Version    int64                `json:"version"`
+	Name       string               `json:"name"`
+	Duration   string               `json:"duration"`
+	Statements []MigrationStatement `json:"statements"`
+}
+
+// MigrationStatement is a statement of a migration with, in dry runs, its
+// plan when the database can explain it; DDL has none
+type MigrationStatement struct {
+	SQL  string                   `json:"sql"`
+	Plan []map[string]interface{} `json:"plan,omitempty"`
+}
+
+// appliedMigration is a row of the tracking table
+type appliedMigration struct {
+	Migration
+	Checksum  string
+	AppliedAt time.Time
+	AppliedBy string
+	Duration  time.Duration
+}
+
+// LoadMigrations reads the migration files of a directory by version; a
+// missing directory holds none
+func LoadMigrations(dir string) (map[int64]Migration, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return map[int64]Migration{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[int64]Migration)
+	for _, e := range entries {
+		m := migrationFile.FindStringSubmatch(e.Name())
+		if e.IsDir() || m == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: %w", e.Name(), err)
+		}
+		b, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		mig, ok := found[version]
+		if ok && mig.Name != m[2] {
+			return nil, fmt.Errorf("migrations %d_%s and %d_%s share a version", version, mig.Name, version, m[2])
+		}
+		mig.Version, mig.Name = version, m[2]
+		if m[3] == ".down" {
+			mig.Down = string(b)
+		} else {
+			mig.Up = string(b)
+		}
+		found[version] = mig
+	}
+	for v, m := range found {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up file", v, m.Name)
+		}
+	}
+	return found, nil
+}
+
+// ensureMigrationsTable creates the tracking table unless it exists
+func (h *Handler) ensureMigrationsTable(ctx context.Context) error {
+	_, err := h.db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+h.dialect.Quote(h.cfg.Migrations.Table)+` (
+	version BIGINT PRIMARY KEY,
+	name TEXT NOT NULL,
+	checksum TEXT NOT NULL,
+	down_sql TEXT NOT NULL,
+	applied_at TIMESTAMP NOT NULL,
+	applied_by TEXT NOT NULL,
+	duration_ms BIGINT NOT NULL
+)`)
+	return err
+}
+
+// appliedMigrations returns the rows of the tracking table by version
+func (h *Handler) appliedMigrations(ctx context.Context) (map[int64]appliedMigration, error) {
+	if err := h.ensureMigrationsTable(ctx); err != nil {
+		return nil, err
+	}
+	rows, err := h.db.QueryContext(ctx, "SELECT version, name, checksum, down_sql, applied_at, applied_by, duration_ms FROM "+h.dialect.Quote(h.cfg.Migrations.Table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := make(map[int64]appliedMigration)
+	for rows.Next() {
+		var a appliedMigration
+		var ms int64
+		if err := rows.Scan(&a.Version, &a.Name, &a.Checksum, &a.Down, &a.AppliedAt, &a.AppliedBy, &ms); err != nil {
+			return nil, err
+		}
+		a.Duration = time.Duration(ms) * time.Millisecond
+		applied[a.Version] = a
+	}
+	return applied, rows.Err()
+}
+
+// migrationState reads the migrations of the directory and the tracking
+// table, answering the request itself on failure
+func (h *Handler) migrationState(c *gin.Context) (map[int64]Migration, map[int64]appliedMigration, bool) {
+	if !h.cfg.Migrations.Enabled {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Migrations are disabled"})
+		return nil, nil, false
+	}
+	files, err := LoadMigrations(h.cfg.Migrations.Dir)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, nil, false
+	}
+	applied, err := h.appliedMigrations(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, nil, false
+	}
+	return files, applied, true
+}
+
+// GetMigrations lists the migrations by version with whether each is
+// applied
+func (h *Handler) GetMigrations(c *gin.Context) {
+	files, applied, ok := h.migrationState(c)
+	if !ok {
+		return
+	}
+	statuses := []MigrationStatus{}
+	for v, m := range files {
+		s := MigrationStatus{Version: v, Name: m.Name, Status: "pending", Reversible: m.Down != "", Checksum: m.checksum()}
+		if a, ok := applied[v]; ok {
+			s.Status = "applied"
+			s.Reversible = a.Down != "" || m.Down != ""
+			s.Modified = a.Checksum != s.Checksum
+			s.Checksum = a.Checksum
+			s.AppliedAt, s.AppliedBy, s.Duration = &a.AppliedAt, a.AppliedBy, a.Duration.String()
+		}
+		statuses = append(statuses, s)
+	}
+	for v, a := range applied {
+		if _, ok := files[v]; !ok {
+			statuses = append(statuses, MigrationStatus{
+				Version: v, Name: a.Name, Status: "applied", Reversible: a.Down != "", Checksum: a.Checksum,
+				AppliedAt: &a.AppliedAt, AppliedBy: a.AppliedBy, Duration: a.Duration.String(),
+			})
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	c.JSON(http.StatusOK, gin.H{"migrations": statuses})
+}
+
+// MigrateUp applies the pending migrations in version order, each in its
+// own transaction recording it in the tracking table. A dry run applies
+// them in one transaction that is rolled back, explaining each statement.
+func (h *Handler) MigrateUp(c *gin.Context) {
+	var req MigrateUpRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		return
+	}
+	if !h.migrating.TryLock() {
+		c.JSON(http.StatusConflict, gin.H{"error": "Migrations are already running"})
+		return
+	}
+	defer h.migrating.Unlock()
+	files, applied, ok := h.migrationState(c)
+	if !ok {
+		return
+	}
+	for _, m := range req.Migrations {
+		if m.Version <= 0 || m.Up == "" || !identPattern.MatchString(m.Name) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Uploaded migrations need a positive version, a plain identifier as name and up SQL"})
+			return
+		}
+		if f, ok := files[m.Version]; ok && (f.Name != m.Name || f.checksum() != m.checksum()) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Uploaded migration %d differs from %d_%s in %s", m.Version, f.Version, f.Name, h.cfg.Migrations.Dir)})
+			return
+		}
+		files[m.Version] = m
+	}
+	if req.Target > 0 {
+		if _, ok := files[req.Target]; !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("No migration %d", req.Target)})
+			return
+		}
+	}
+
+	var latest int64
+	for v := range applied {
+		latest = max(latest, v)
+	}
+	var pending []Migration
+	for v, m := range files {
+		if _, ok := applied[v]; ok || (req.Target > 0 && v > req.Target) {
+			continue
+		}
+		if v < latest {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Pending migration %d_%s is older than applied migration %d", v, m.Name, latest)})
+			return
+		}
+		pending = append(pending, m)
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].Version < pending[j].Version })
+	h.runMigrations(c, "up", pending, req.DryRun)
+}
+
+// MigrateDown reverts applied migrations from the latest, with the down SQL
+// recorded when they were applied or else that of migrations.dir
+func (h *Handler) MigrateDown(c *gin.Context) {
+	var req MigrateDownRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		return
+	}
+	if req.Steps < 0 || (req.Target != nil && *req.Target < 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "steps and target must not be negative"})
+		return
+	}
+	if req.Steps == 0 && req.Target == nil {
+		req.Steps = 1
+	}
+	if !h.migrating.TryLock() {
+		c.JSON(http.StatusConflict, gin.H{"error": "Migrations are already running"})
+		return
+	}
+	defer h.migrating.Unlock()
+	files, applied, ok := h.migrationState(c)
+	if !ok {
+		return
+	}
+
+	var reverting []Migration
+	for _, a := range applied {
+		if req.Target == nil || a.Version > *req.Target {
+			reverting = append(reverting, a.Migration)
+		}
+	}
+	sort.Slice(reverting, func(i, j int) bool { return reverting[i].Version > reverting[j].Version })
+	if req.Target == nil && len(reverting) > req.Steps {
+		reverting = reverting[:req.Steps]
+	}
+	for i, m := range reverting {
+		if m.Down == "" {
+			m.Down = files[m.Version].Down
+		}
+		if m.Down == "" {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Migration %d_%s has no down SQL", m.Version, m.Name)})
+			return
+		}
+		reverting[i] = m
+	}
+	h.runMigrations(c, "down", reverting, req.DryRun)
+}
+
+// runMigrations applies or reverts migrations in order and answers with
+// what was done; 422 when one failed
+func (h *Handler) runMigrations(c *gin.Context, direction string, migrations []Migration, dryRun bool) {
+	ctx := c.Request.Context()
+	run := MigrationRun{Direction: direction, DryRun: dryRun, Migrations: []MigrationStep{}}
+	var err error
+	if dryRun {
+		err = h.dryRunMigrations(ctx, direction, migrations, &run)
+	} else {
+		principal := auth.Principal(c)
+		for _, m := range migrations {
+			if err = h.runMigration(ctx, direction, m, principal, &run); err != nil {
+				break
+			}
+			slog.InfoContext(ctx, "Migration "+direction, "version", m.Version, "name", m.Name, "by", principal)
+		}
+		if len(run.Migrations) > 0 {
+			if _, err := h.reloadSchema(""); err != nil {
+				slog.ErrorContext(ctx, "Schema refresh after migrations failed", "error", err)
+			}
+		}
+	}
+	if err != nil {
+		run.Error = err.Error()
+		c.JSON(http.StatusUnprocessableEntity, run)
+		return
+	}
+	c.JSON(http.StatusOK, run)
+}
+
+// runMigration applies or reverts one migration in a transaction that also
+// updates the tracking table
+func (h *Handler) runMigration(ctx context.Context, direction string, m Migration, principal string, run *MigrationRun) error {
+	start := time.Now()
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	step, err := h.execMigration(ctx, tx, direction, m, false)
+	if err != nil {
+		return err
+	}
+	table := h.dialect.Quote(h.cfg.Migrations.Table)
+	p := h.dialect.Placeholder
+	if direction == "up" {
+		_, err = tx.ExecContext(ctx, fmt.Sprintf("INSERT INTO %s (version, name, checksum, down_sql, applied_at, applied_by, duration_ms) VALUES (%s, %s, %s, %s, %s, %s, %s)",
+			table, p(1), p(2), p(3), p(4), p(5), p(6), p(7)),
+			m.Version, m.Name, m.checksum(), m.Down, start.UTC(), principal, time.Since(start).Milliseconds())
+	} else {
+		_, err = tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE version = "+p(1), m.Version)
+	}
+	if err != nil {
+		return fmt.Errorf("migration %d_%s: recording it: %w", m.Version, m.Name, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("migration %d_%s: %w", m.Version, m.Name, err)
+	}
+	step.Duration = time.Since(start).String()
+	run.Migrations = append(run.Migrations, step)
+	return nil
+}
+
+// dryRunMigrations runs the migrations in one transaction, so that each
+// sees the changes of those before it, then rolls it back
+func (h *Handler) dryRunMigrations(ctx context.Context, direction string, migrations []Migration, run *MigrationRun) error {
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, m := range migrations {
+		start := time.Now()
+		step, err := h.execMigration(ctx, tx, direction, m, true)
+		if err != nil {
+			return err
+		}
+		step.Duration = time.Since(start).String()
+		run.Migrations = append(run.Migrations, step)
+	}
+	return nil
+}
+
+// execMigration runs the statements of a migration in tx, explaining each
+// first when explain is set
+func (h *Handler) execMigration(ctx context.Context, tx *sql.Tx, direction string, m Migration, explain bool) (MigrationStep, error) {
+	script := m.Up
+	if direction == "down" {
+		script = m.Down
+	}
+	step := MigrationStep{Version: m.Version, Name: m.Name, Statements: []MigrationStatement{}}
+	for i, piece := range splitStatements(script) {
+		stmt := MigrationStatement{SQL: piece.body}
+		if explain {
+			stmt.Plan = h.explainInTx(ctx, tx, piece.body)
+		}
+		if _, err := tx.ExecContext(ctx, piece.body); err != nil {
+			return step, fmt.Errorf("migration %d_%s %s, statement %d: %w", m.Version, m.Name, direction, i+1, err)
+		}
+		step.Statements = append(step.Statements, stmt)
+	}
+	return step, nil
+}
+
+// explainInTx returns the plan of a statement, or nil when the database
+// can't explain it. It runs in a savepoint so that a refused EXPLAIN
+// leaves the transaction usable.
+func (h *Handler) explainInTx(ctx context.Context, tx *sql.Tx, stmt string) []map[string]interface{} {
+	if _, err := tx.ExecContext(ctx, "SAVEPOINT "+migrationExplain); err != nil {
+		return nil
+	}
+	var plan []map[string]interface{}
+	rows, err := tx.QueryContext(ctx, h.dialect.ExplainQuery(stmt))
+	if err == nil {
+		_, plan, err = scanRowMaps(rows)
+		rows.Close()
+	}
+	if err != nil {
+		plan = nil
+		tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+migrationExplain)
+	}
+	tx.ExecContext(ctx, "RELEASE SAVEPOINT "+migrationExplain)
+	return plan
+}
@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"sql-engine/llm"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxPromptLength caps the question sent to the model, in characters
+const maxPromptLength = 4000
+
+// NL2SQLRequest asks for a query answering a question about ?schema
+type NL2SQLRequest struct {
+	Prompt string `json:"prompt"`
+	// Validate checks the generated SQL like /run-query would, true by
+	// default
+	Validate *bool `json:"validate"`
+	// Explain also returns the plan of the validated query
+	Explain bool `json:"explain"`
+}
+
+// UseLLM sets the model behind /nl2sql; nil disables it
+func (h *Handler) UseLLM(model llm.Provider) {
+	h.llm = model
+}
+
+// NaturalLanguageQuery asks the configured model for a SELECT answering the
+// prompt, describing it the tables of ?schema the caller may read. The SQL
+// is returned, not run. Unless validate is false it must pass the checks
+// queries are run with: a single SELECT, no denylisted constructs, only
+// granted tables and columns and no blocked fingerprint. With explain, the
+// plan is read in a read-only transaction.
+func (h *Handler) NaturalLanguageQuery(c *gin.Context) {
+	if h.llm == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Natural language queries are not configured"})
+		return
+	}
+	var req NL2SQLRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		return
+	}
+	req.Prompt = strings.TrimSpace(req.Prompt)
+	if req.Prompt == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "prompt is required"})
+		return
+	}
+	if len([]rune(req.Prompt)) > maxPromptLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("prompt must be at most %d characters", maxPromptLength)})
+		return
+	}
+	validate := req.Validate == nil || *req.Validate
+	if req.Explain && !validate {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "explain needs validate"})
+		return
+	}
+	schema, ok := h.schemaParam(c)
+	if !ok {
+		return
+	}
+	tables, err := h.visibleSchema(c, schema)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(tables) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No tables of schema " + schema + " are visible to you"})
+		return
+	}
+
+	warnings := []string{}
+	if limit := h.cfg.LLM.MaxSchemaTables; len(tables) > limit {
+		warnings = append(warnings, fmt.Sprintf("Only %d of %d tables were described to the model", limit, len(tables)))
+		tables = tables[:limit]
+	}
+	completion, err := h.llm.Complete(c.Request.Context(), h.nl2sqlInstructions(schema, tables), req.Prompt)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Model request failed: " + err.Error()})
+		return
+	}
+	sqlText := extractSQL(completion)
+	if sqlText == "" {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Model returned no SQL"})
+		return
+	}
+
+	resp := gin.H{"sql": sqlText, "validated": validate, "warnings": warnings}
+	if !validate {
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+	prep, err := h.prepareSelect(c.Request.Context(), sqlText)
+	if err != nil {
+		resp["validated"] = false
+		resp["error"] = "Generated SQL was rejected: " + err.Error()
+		c.JSON(http.StatusUnprocessableEntity, resp)
+		return
+	}
+	if req.Explain {
+		plan, err := h.explain(c.Request.Context(), prep.SQL)
+		if err != nil {
+			resp["error"] = "EXPLAIN failed: " + err.Error()
+			c.JSON(http.StatusUnprocessableEntity, resp)
+			return
+		}
+		resp["plan"] = plan
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// nl2sqlInstructions tells the model which SQL to write and describes the
+// tables it may use, one per line, e.g.
+// orders(id integer primary key, user_id integer references users(id) /* buyer */)
+func (h *Handler) nl2sqlInstructions(schema string, tables []TableSchema) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "You translate questions into one read-only %s SELECT statement. ", h.dialect.ProductName())
+	b.WriteString("Reply with the SQL only, without explanations or comments. ")
+	b.WriteString("Use only these tables and columns")
+	if !h.isDefaultSchema(schema) {
+		fmt.Fprintf(&b, ", qualified with the schema %s", schema)
+	}
+	b.WriteString(":\n")
+	for _, t := range tables {
+		var cols []string
+		for _, col := range t.Columns {
+			def := col.Name + " " + col.DataType
+			if slices.Contains(t.PrimaryKeys, col.Name) {
+				def += " primary key"
+			}
+			for _, fk := range t.ForeignKeys {
+				if fk.Column == col.Name {
+					def += " references " + fk.ForeignTable + "(" + fk.ForeignColumn + ")"
+				}
+			}
+			if comment := cmp.Or(col.Description, col.Comment); comment != "" {
+				def += " /* " + strings.ReplaceAll(strings.Join(strings.Fields(comment), " "), "*/", "") + " */"
+			}
+			cols = append(cols, def)
+		}
+		fmt.Fprintf(&b, "%s(%s)", t.Name, strings.Join(cols, ", "))
+		if t.Comment != "" {
+			b.WriteString(" -- " + strings.Join(strings.Fields(t.Comment), " "))
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// extractSQL takes the statement out of a completion, which models tend to
+// wrap in a Markdown code block
+func extractSQL(completion string) string {
+	s := strings.TrimSpace(completion)
+	if start := strings.Index(s, "```"); start >= 0 {
+		s = s[start+3:]
+		if nl := strings.IndexByte(s, '\n'); nl >= 0 && !strings.ContainsAny(s[:nl], " \t") {
+			// Drop the language tag, e.g. ```sql
+			s = s[nl+1:]
+		}
+		if end := strings.Index(s, "```"); end >= 0 {
+			s = s[:end]
+		}
+	}
+	return strings.TrimRight(strings.TrimSpace(s), "; \t\n")
+}
+
+// explain returns the engine's plan of a validated SELECT, read in a
+// read-only transaction within the query timeout
+func (h *Handler) explain(ctx context.Context, sqlText string) ([]map[string]interface{}, error) {
+	if timeout := h.queryTimeout(ctx); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	release, err := h.acquireStatement(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	tx, end, err := h.dialect.BeginReadOnly(ctx, h.queryDB(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer end()
+	rows, err := tx.QueryContext(ctx, h.dialect.ExplainQuery(sqlText))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	_, plan, err := scanRowMaps(rows)
+	return plan, err
+}
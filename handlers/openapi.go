@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	_ "embed"
+	"net/http"
+	"runtime/debug"
+	"sync"
+
+	"sql-engine/openapi"
+
+	"github.com/gin-gonic/gin"
+)
+
+//go:embed apidocs.html
+var apiDocsPage []byte
+
+// apiDocs holds the routes the OpenAPI document describes and the document,
+// built on first request
+type apiDocs struct {
+	routes gin.RoutesInfo
+	once   sync.Once
+	doc    map[string]any
+}
+
+// schemaQuery is the query parameter of the endpoints reading a schema
+var schemaQuery = []string{"schema"}
+
+// apiOperations documents the request and response bodies of handlers by
+// their method name. Handlers left out are listed with their route only.
+func apiOperations() map[string]openapi.Operation {
+	return map[string]openapi.Operation{
+		// Public
+		"GetStatus":      {Public: true},
+		"GetHealthz":     {Public: true, Response: openapi.Object{"status": ""}},
+		"GetReadyz":      {Public: true, Response: openapi.Object{"status": "", "replica": "", "components": map[string]ComponentStatus{}}},
+		"TriggerWebhook": {Public: true},
+		"GetOpenAPI":     {Public: true, Summary: "OpenAPI document", Response: openapi.Object{}},
+		"GetAPIDocs":     {Public: true, Summary: "Swagger UI"},
+		"GetWebUI":       {Public: true, Summary: "SQL editor"},
+		"GetWebUIAsset":  {Public: true, Summary: "SQL editor script or stylesheet"},
+
+		// Schema
+		"GetSchemas":                 {Response: openapi.Object{"schemas": []string{}, "default": ""}},
+		"GetTables":                  {Query: schemaQuery, Response: openapi.Object{"schema": "", "tables": []TableInfo{}}},
+		"GetTablesStats":             {Query: schemaQuery, Response: openapi.Object{"schema": "", "tables": []TableStats{}}},
+		"GetTableColumns":            {Query: schemaQuery, Response: openapi.Object{"schema": "", "table_name": "", "columns": []ColumnInfo{}}},
+		"GetTablePrimaryKeys":        {Query: schemaQuery, Response: openapi.Object{"schema": "", "table_name": "", "primary_keys": []string{}}},
+		"GetTableForeignKeys":        {Query: schemaQuery, Response: openapi.Object{"schema": "", "table_name": "", "foreign_keys": []ForeignKeyInfo{}}},
+		"GetTableIndexes":            {Query: schemaQuery, Response: openapi.Object{"schema": "", "table_name": "", "indexes": []IndexInfo{}}},
+		"GetTableStats":              {Query: schemaQuery, Response: TableStats{}},
+		"GetTableConstraints":        {Query: schemaQuery},
+		"GetTableTriggers":           {Query: schemaQuery},
+		"GetTablePartitions":         {Query: schemaQuery},
+		"GetFullSchema":              {Query: schemaQuery, Response: openapi.Object{"schema": []TableSchema{}}},
+		"SetColumnComment":           {Request: CommentRequest{}},
+		"DiffSchemas":                {Request: SchemaDiffRequest{}, Response: SchemaDiff{}},
+		"ListSchemaSnapshots":        {Response: openapi.Object{"snapshots": []SchemaSnapshot{}}},
+		"SaveSchemaSnapshot":         {Request: SchemaSnapshotRequest{}, Status: http.StatusCreated},
+		"RunMetaCommand":             {Request: MetaRequest{}},
+		"SearchTable":                {Request: FullTextSearchRequest{}},
+		"SimilarRows":                {Request: SimilarRequest{}},
+		"RefreshContinuousAggregate": {Request: CaggRefreshRequest{}},
+
+		// Queries
+		"RunQuery":             {Summary: "Run a read-only query", Request: QueryRequest{}, Response: QueryResult{}},
+		"EstimateQuery":        {Summary: "Estimate a query's rows and cost", Request: EstimateRequest{}, Response: QueryEstimate{}},
+		"FormatSQL":            {Request: FormatRequest{}, Response: openapi.Object{"sql": "", "warnings": []string{}}},
+		"DiffResults":          {Request: ResultDiffRequest{}, Response: ResultDiff{}},
+		"NaturalLanguageQuery": {Summary: "Translate a question into SQL", Request: NL2SQLRequest{}},
+		"RunTimeseries":        {Request: TimeseriesRequest{}},
+		"Aggregate":            {Request: AggregateRequest{}},
+		"Search":               {Request: SearchRequest{}},
+		"CompareDistributions": {Request: DistributionRequest{}},
+		"GetTemplates":         {Response: openapi.Object{"categories": []string{}, "templates": []QueryTemplate{}}},
+		"RenderTemplate":       {Request: TemplateRenderRequest{}},
+
+		// Sessions
+		"CreateSession":   {Summary: "Open a transaction spanning several requests", Request: SessionRequest{}, Response: Session{}, Status: http.StatusCreated},
+		"GetSession":      {Response: Session{}},
+		"RunSessionQuery": {Summary: "Run a read-only query in a session", Request: SessionQueryRequest{}, Response: QueryResult{}},
+		"CommitSession":   {Status: http.StatusNoContent},
+		"RollbackSession": {Status: http.StatusNoContent},
+
+		// Materialize jobs
+		"Materialize":         {Request: MaterializeRequest{}, Response: MaterializeJob{}, Status: http.StatusAccepted},
+		"ListMaterializeJobs": {Response: openapi.Object{"jobs": []MaterializeJob{}}},
+		"GetMaterializeJob":   {Response: MaterializeJob{}},
+
+		// Saved queries
+		"ListSavedQueries":       {Response: openapi.Object{"saved_queries": []SavedQuery{}}},
+		"CreateSavedQuery":       {Request: SavedQueryRequest{}, Response: SavedQuery{}, Status: http.StatusCreated},
+		"GetSavedQuery":          {Response: openapi.Object{"saved_query": SavedQuery{}}},
+		"UpdateSavedQuery":       {Request: SavedQueryRequest{}, Response: SavedQuery{}},
+		"ExecuteSavedQuery":      {Request: SavedQueryExecuteRequest{}},
+		"BulkUpdateSavedQueries": {Request: BulkSavedQueryRequest{}},
+		"ImportSavedQueries":     {Summary: "Import saved queries from Metabase, Redash or Superset", Query: []string{"source", "folder", "dry_run"}, Request: openapi.Object{}, Response: openapi.Object{"source": "", "dry_run": false, "imported": 0, "skipped": 0, "queries": []SavedQueryImportResult{}}},
+
+		// Share links
+		"CreateShare": {Summary: "Create a link to a query, optionally with its result frozen", Request: ShareRequest{}, Response: QueryShare{}, Status: http.StatusCreated},
+		"GetShare":    {Summary: "Open a share link", Query: []string{"run"}, Response: openapi.Object{"share": QueryShare{}, "columns": []string{}, "rows": []map[string]any{}, "frozen_at": ""}},
+		"DeleteShare": {Summary: "Revoke a share link", Status: http.StatusNoContent},
+
+		// Drafts
+		"ListDrafts":  {Response: openapi.Object{"drafts": []Draft{}}},
+		"CreateDraft": {Request: DraftRequest{}, Response: Draft{}, Status: http.StatusCreated},
+		"GetDraft":    {Response: Draft{}},
+		"SaveDraft":   {Request: DraftRequest{}},
+
+		// View preferences
+		"GetTableViewPreferences":        {Query: schemaQuery, Response: ViewPreferences{}},
+		"SetTableViewPreferences":        {Query: schemaQuery, Request: ViewPreferencesRequest{}, Response: ViewPreferences{}},
+		"ResetTableViewPreferences":      {Query: schemaQuery, Status: http.StatusNoContent},
+		"GetSavedQueryViewPreferences":   {Response: ViewPreferences{}},
+		"SetSavedQueryViewPreferences":   {Request: ViewPreferencesRequest{}, Response: ViewPreferences{}},
+		"ResetSavedQueryViewPreferences": {Status: http.StatusNoContent},
+
+		// Data management
+		"ImportData":      {Request: ImportRequest{}, Response: ImportResult{}},
+		"ImportTableRows": {Summary: "Insert the rows of an uploaded CSV or NDJSON file, reporting rejected rows", Query: []string{"schema", "format", "strict"}, Response: TableImportResult{}},
+		"ExtractSubject":  {Request: SubjectExtractRequest{}},
+		"RequestAccess":   {Request: AccessRequestBody{}, Response: AccessRequest{}, Status: http.StatusCreated},
+
+		// Administration
+		"BlockQuery":      {Request: BlockQueryRequest{}, Response: BlockedQuery{}, Status: http.StatusCreated},
+		"GetSQLVariables": {Response: openapi.Object{"variables": []SQLVariable{}}},
+		"SetSQLVariable":  {Request: SQLVariableRequest{}},
+		"ListWebhooks":    {Response: openapi.Object{"webhooks": []Webhook{}}},
+		"CreateWebhook":   {Request: WebhookRequest{}, Response: openapi.Object{"webhook": Webhook{}, "url": ""}, Status: http.StatusCreated},
+		"GetWatermark":    {Response: Watermark{}},
+		"GetDoctor":       {Summary: "Run the self-checks", Response: DoctorReport{}},
+		"GetMigrations":   {Summary: "List the schema migrations and whether each is applied", Response: openapi.Object{"migrations": []MigrationStatus{}}},
+		"MigrateUp":       {Summary: "Apply the pending schema migrations", Request: MigrateUpRequest{}, Response: MigrationRun{}},
+		"MigrateDown":     {Summary: "Revert the latest schema migrations", Request: MigrateDownRequest{}, Response: MigrationRun{}},
+		"LoadFixtures":    {Summary: "Load rows into tables, emptying them first with truncate", Query: []string{"schema", "truncate"}, Request: openapi.Object{"table": []map[string]any{}}, Response: openapi.Object{"tables": []FixtureTable{}}},
+	}
+}
+
+// UseRoutes sets the routes GET /openapi.json describes, once all are
+// registered
+func (h *Handler) UseRoutes(routes gin.RoutesInfo) {
+	h.docs.routes = routes
+}
+
+// GetOpenAPI returns the OpenAPI 3.1 document of the API
+func (h *Handler) GetOpenAPI(c *gin.Context) {
+	h.docs.once.Do(func() {
+		info := openapi.Info{
+			Title:       "SQL Engine API",
+			Version:     "dev",
+			Description: "Browse database schemas and run read-only SQL queries over HTTP",
+		}
+		if bi, ok := debug.ReadBuildInfo(); ok && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+			info.Version = bi.Main.Version
+		}
+		auth := len(h.cfg.Auth.APIKeys) > 0 || h.cfg.Auth.OIDC.Issuer != ""
+		h.docs.doc = openapi.Build(info, h.docs.routes, apiOperations(), auth)
+	})
+	c.JSON(http.StatusOK, h.docs.doc)
+}
+
+// GetAPIDocs serves Swagger UI on the OpenAPI document
+func (h *Handler) GetAPIDocs(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", apiDocsPage)
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PartitionInfo describes a partitioned table or one of its partitions.
+// Strategy, Key and Partitions are only set on tables that are partitioned
+// themselves, so sub-partitions form a tree.
+type PartitionInfo struct {
+	Schema string `json:"schema"`
+	Name   string `json:"name"`
+	// Bound is the partition bound, e.g. "FOR VALUES FROM (...) TO (...)"
+	// or "DEFAULT"; the root table has none
+	Bound string `json:"bound,omitempty"`
+	// SizeBytes includes indexes and TOAST; for partitioned tables it is the
+	// total of their partitions
+	SizeBytes int64 `json:"size_bytes"`
+	// Strategy is range, list or hash
+	Strategy   string          `json:"strategy,omitempty"`
+	Key        string          `json:"key,omitempty"`
+	Partitions []PartitionInfo `json:"partitions,omitempty"`
+}
+
+// GetTablePartitions returns the partition hierarchy of a table
+func (h *Handler) GetTablePartitions(c *gin.Context) {
+	schema, ok := h.schemaParam(c)
+	if !ok {
+		return
+	}
+	tableName := c.Param("name")
+	if !h.requireTable(c, schema, tableName) {
+		return
+	}
+
+	root, err := h.dialect.ListPartitions(h.db, schema, tableName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if root == nil {
+		h.render(c, gin.H{
+			"schema":      schema,
+			"table_name":  tableName,
+			"partitioned": false,
+			"partitions":  []PartitionInfo{},
+		}, nil)
+		return
+	}
+	if root.Partitions == nil {
+		root.Partitions = []PartitionInfo{}
+	}
+
+	h.render(c, gin.H{
+		"schema":      schema,
+		"table_name":  tableName,
+		"partitioned": true,
+		"strategy":    root.Strategy,
+		"key":         root.Key,
+		"size_bytes":  root.SizeBytes,
+		"partitions":  root.Partitions,
+	}, nil)
+}
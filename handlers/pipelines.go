@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// QueryDependency is an upstream of a saved query: another saved query, or a
+// table written by /materialize. Exactly one of the fields is set.
+type QueryDependency struct {
+	SavedQuery string `json:"saved_query,omitempty"`
+	Table      string `json:"table,omitempty"`
+}
+
+// PipelineNode is a saved query taking part in the dependency graph
+type PipelineNode struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	TestEvery string `json:"test_every,omitempty"`
+	// Scheduled is set for queries that start a pipeline on their own
+	// schedule rather than running after their upstreams
+	Scheduled bool     `json:"scheduled"`
+	LastRun   *TestRun `json:"last_run,omitempty"`
+}
+
+// PipelineEdge points from an upstream, a saved query or a materialized
+// table, to the saved query depending on it
+type PipelineEdge struct {
+	From      string `json:"from,omitempty"`
+	FromTable string `json:"from_table,omitempty"`
+	To        string `json:"to"`
+}
+
+// GetSavedQueryDAG returns the saved queries that have or are dependencies
+// as a graph, with the order in which scheduled runs execute them
+func (h *Handler) GetSavedQueryDAG(c *gin.Context) {
+	queries, err := h.savedQueries()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	order, err := dependencyOrder(queries)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	linked := map[string]bool{}
+	edges := []PipelineEdge{}
+	for _, q := range order {
+		for _, d := range q.DependsOn {
+			edges = append(edges, PipelineEdge{From: d.SavedQuery, FromTable: d.Table, To: q.ID})
+			linked[q.ID] = true
+			if d.SavedQuery != "" {
+				linked[d.SavedQuery] = true
+			}
+		}
+	}
+	nodes := []PipelineNode{}
+	ids := []string{}
+	for _, q := range order {
+		if !linked[q.ID] {
+			continue
+		}
+		node := PipelineNode{ID: q.ID, Name: q.Name, TestEvery: q.TestEvery, Scheduled: q.TestEvery != ""}
+		var last TestRun
+		if err := h.meta.Get(testRunPrefix+q.ID, &last); err == nil {
+			node.LastRun = &last
+		}
+		nodes = append(nodes, node)
+		ids = append(ids, q.ID)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"nodes": nodes, "edges": edges, "order": ids})
+}
+
+// runPipeline runs a saved query, with the variable values given and
+// trigger recorded as what ran it, and then, in dependency order, every saved
+// query downstream of it. A query is skipped when an upstream failed or was
+// skipped, or a table it needs was not materialized successfully. Each query
+// runs under the access of its owner.
+func (h *Handler) runPipeline(ctx context.Context, root SavedQuery, trigger string, values map[string]any) {
+	queries, err := h.savedQueries()
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load saved queries for pipeline", "error", err)
+		return
+	}
+	order, err := dependencyOrder(queries)
+	if err != nil {
+		slog.ErrorContext(ctx, "Pipeline not run", "saved_query", root.ID, "error", err)
+		return
+	}
+
+	// Only the root and what depends on it, directly or not, take part
+	included := map[string]bool{root.ID: true}
+	passed := map[string]bool{}
+	for _, q := range order {
+		if q.ID != root.ID && !slices.ContainsFunc(q.DependsOn, func(d QueryDependency) bool { return included[d.SavedQuery] }) {
+			continue
+		}
+		included[q.ID] = true
+
+		by, vars := root.ID, map[string]any(nil)
+		if q.ID == root.ID {
+			q, by, vars = root, trigger, values
+		}
+		var run TestRun
+		if reason := h.pipelineBlocker(q, passed); reason != "" {
+			run = h.skipSavedQuery(q, by, reason)
+		} else if owned, err := h.asOwner(ctx, q); err != nil {
+			run = h.skipSavedQuery(q, by, "Owner access: "+err.Error())
+		} else {
+			run = h.testSavedQuery(owned, q, by, vars)
+		}
+		passed[q.ID] = run.Passed
+	}
+}
+
+// pipelineBlocker returns why q cannot run yet, or "" if it can. passed
+// holds the outcomes of the queries already run in the pipeline; other
+// upstreams are judged by their last run.
+func (h *Handler) pipelineBlocker(q SavedQuery, passed map[string]bool) string {
+	for _, d := range q.DependsOn {
+		if d.Table != "" {
+			if reason := h.materializationBlocker(d.Table); reason != "" {
+				return reason
+			}
+			continue
+		}
+		ok, ran := passed[d.SavedQuery]
+		if !ran {
+			var last TestRun
+			ok = h.meta.Get(testRunPrefix+d.SavedQuery, &last) == nil && last.Passed
+		}
+		if !ok {
+			return "upstream saved query " + d.SavedQuery + " did not pass"
+		}
+	}
+	return ""
+}
+
+// materializationBlocker reports a table whose latest /materialize job did
+// not succeed
+func (h *Handler) materializationBlocker(table string) string {
+	keys, err := h.meta.List(materializeJobPrefix)
+	if err != nil {
+		return "materialize jobs could not be listed: " + err.Error()
+	}
+	var latest *MaterializeJob
+	for _, key := range keys {
+		var job MaterializeJob
+		if err := h.meta.Get(key, &job); err != nil || job.Table != table {
+			continue
+		}
+		if latest == nil || job.CreatedAt.After(latest.CreatedAt) {
+			latest = &job
+		}
+	}
+	switch {
+	case latest == nil:
+		return "table " + table + " has not been materialized"
+	case latest.Status == "running":
+		return "materialization of " + table + " is still running"
+	case latest.Status != "succeeded":
+		return "last materialization of " + table + " failed"
+	}
+	return ""
+}
+
+// skipSavedQuery stores a run of q that did not execute
+func (h *Handler) skipSavedQuery(q SavedQuery, trigger, reason string) TestRun {
+	run := TestRun{QueryID: q.ID, RanAt: time.Now(), Duration: "0s", Skipped: true, Error: reason, TriggeredBy: trigger}
+	slog.Warn("Saved query skipped", "saved_query", q.ID, "name", q.Name, "reason", reason)
+	if err := h.meta.Put(testRunPrefix+q.ID, run); err != nil {
+		slog.Error("Failed to store test run", "error", err)
+	}
+	return run
+}
+
+// checkDependencies verifies that the saved queries q depends on exist and
+// that q would not close a cycle
+func (h *Handler) checkDependencies(q SavedQuery) error {
+	if !slices.ContainsFunc(q.DependsOn, func(d QueryDependency) bool { return d.SavedQuery != "" }) {
+		return nil
+	}
+	queries, err := h.savedQueries()
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(queries, func(other SavedQuery) bool { return other.ID == q.ID })
+	if i < 0 {
+		queries = append(queries, q)
+	} else {
+		queries[i] = q
+	}
+	for _, d := range q.DependsOn {
+		if d.SavedQuery != "" && !slices.ContainsFunc(queries, func(other SavedQuery) bool { return other.ID == d.SavedQuery }) {
+			return fmt.Errorf("depends_on: saved query %s not found", d.SavedQuery)
+		}
+	}
+	_, err = dependencyOrder(queries)
+	return err
+}
+
+// dependencyOrder sorts saved queries so that each comes after the saved
+// queries it depends on, keeping independent queries ordered by name.
+// Dependencies on queries that no longer exist are ignored.
+func dependencyOrder(queries []SavedQuery) ([]SavedQuery, error) {
+	queries = slices.Clone(queries)
+	sort.Slice(queries, func(i, j int) bool { return queries[i].Name < queries[j].Name })
+	exists := map[string]bool{}
+	for _, q := range queries {
+		exists[q.ID] = true
+	}
+
+	done := map[string]bool{}
+	order := make([]SavedQuery, 0, len(queries))
+	for len(order) < len(queries) {
+		progressed := false
+		for _, q := range queries {
+			if done[q.ID] || slices.ContainsFunc(q.DependsOn, func(d QueryDependency) bool {
+				return exists[d.SavedQuery] && !done[d.SavedQuery]
+			}) {
+				continue
+			}
+			done[q.ID] = true
+			order = append(order, q)
+			progressed = true
+		}
+		if !progressed {
+			var cycle []string
+			for _, q := range queries {
+				if !done[q.ID] {
+					cycle = append(cycle, q.Name)
+				}
+			}
+			return nil, fmt.Errorf("saved query dependencies form a cycle among %s", strings.Join(cycle, ", "))
+		}
+	}
+	return order, nil
+}
+
+func (q SavedQuery) dependsOn(id string) bool {
+	return slices.ContainsFunc(q.DependsOn, func(d QueryDependency) bool { return d.SavedQuery == id })
+}
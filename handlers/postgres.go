@@ -0,0 +1,1563 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"sql-engine/database"
+
+	sqlparser "github.com/blastrain/vitess-sqlparser/sqlparser"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+type postgresDialect struct{}
+
+func init() {
+	RegisterDialect(database.DriverPostgres, postgresDialect{})
+}
+
+// Introspection queries run for every table when the schema is loaded. They
+// are prepared on each new connection, see PreparedStatements.
+const (
+	pgListTablesSQL = `
+		SELECT t.table_name, t.table_type, COALESCE(obj_description(c.oid, 'pg_class'), ''), c.relkind = 'p'
+		FROM information_schema.tables t
+		JOIN pg_namespace n ON n.nspname = t.table_schema
+		JOIN pg_class c ON c.relnamespace = n.oid AND c.relname = t.table_name
+		WHERE t.table_schema = $1 
+		ORDER BY t.table_name
+	`
+	pgTableCommentSQL = `
+		SELECT COALESCE(obj_description(c.oid, 'pg_class'), '')
+		FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE n.nspname = $1 AND c.relname = $2
+	`
+	pgListColumnsSQL = `
+		SELECT 
+			c.column_name,
+			c.data_type,
+			c.is_nullable,
+			c.column_default,
+			c.character_maximum_length,
+			c.numeric_precision,
+			c.numeric_scale,
+			COALESCE(col_description(pc.oid, c.ordinal_position::int), ''),
+			tn.nspname,
+			t.typname,
+			t.typtype,
+			(
+				SELECT array_to_json(array_agg(e.enumlabel ORDER BY e.enumsortorder))::text
+				FROM pg_enum e
+				WHERE e.enumtypid = t.oid
+			)
+		FROM information_schema.columns c
+		JOIN pg_namespace pn ON pn.nspname = c.table_schema
+		JOIN pg_class pc ON pc.relnamespace = pn.oid AND pc.relname = c.table_name
+		LEFT JOIN pg_namespace tn
+			ON tn.nspname = COALESCE(c.domain_schema, c.udt_schema)
+			AND (c.domain_name IS NOT NULL OR c.data_type = 'USER-DEFINED')
+		LEFT JOIN pg_type t
+			ON t.typnamespace = tn.oid
+			AND t.typname = COALESCE(c.domain_name, c.udt_name)
+		WHERE c.table_schema = $1 AND c.table_name = $2 
+		ORDER BY c.ordinal_position
+	`
+	pgListPrimaryKeysSQL = `
+		SELECT 
+			kcu.column_name
+		FROM information_schema.key_column_usage kcu
+		JOIN information_schema.table_constraints tc
+			ON kcu.constraint_schema = tc.constraint_schema
+			AND kcu.constraint_name = tc.constraint_name
+		WHERE kcu.table_schema = $1 
+			AND kcu.table_name = $2 
+			AND tc.constraint_type = 'PRIMARY KEY'
+		ORDER BY kcu.ordinal_position
+	`
+	pgListForeignKeysSQL = `
+		SELECT
+			kcu.column_name,
+			ccu.table_schema AS foreign_table_schema,
+			ccu.table_name AS foreign_table_name,
+			ccu.column_name AS foreign_column_name
+		FROM information_schema.key_column_usage kcu
+		JOIN information_schema.referential_constraints rc
+			ON kcu.constraint_schema = rc.constraint_schema
+			AND kcu.constraint_name = rc.constraint_name
+		JOIN information_schema.constraint_column_usage ccu
+			ON rc.unique_constraint_schema = ccu.constraint_schema
+			AND rc.unique_constraint_name = ccu.constraint_name
+		WHERE kcu.table_schema = $1 
+			AND kcu.table_name = $2
+		ORDER BY kcu.column_name
+	`
+	pgListConstraintsSQL = `
+		SELECT
+			con.conname,
+			CASE con.contype WHEN 'c' THEN 'CHECK' WHEN 'u' THEN 'UNIQUE' ELSE 'EXCLUDE' END,
+			COALESCE((
+				SELECT array_to_json(array_agg(a.attname ORDER BY k.ord))::text
+				FROM unnest(con.conkey) WITH ORDINALITY AS k(attnum, ord)
+				JOIN pg_attribute a ON a.attrelid = con.conrelid AND a.attnum = k.attnum
+			), '[]'),
+			CASE WHEN con.contype = 'c' THEN pg_get_expr(con.conbin, con.conrelid, true) ELSE '' END,
+			pg_get_constraintdef(con.oid, true)
+		FROM pg_constraint con
+		JOIN pg_class c ON c.oid = con.conrelid
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE n.nspname = $1 AND c.relname = $2 AND con.contype IN ('c', 'u', 'x')
+		ORDER BY con.contype, con.conname
+	`
+)
+
+func (postgresDialect) PreparedStatements() []string {
+	return []string{pgListTablesSQL, pgTableCommentSQL, pgListColumnsSQL, pgListPrimaryKeysSQL, pgListForeignKeysSQL, pgListConstraintsSQL}
+}
+
+func (postgresDialect) BuiltinFunctions() []string {
+	return []string{
+		"abs", "array_agg", "array_length", "avg", "bool_and", "bool_or", "ceil", "char_length", "coalesce",
+		"concat", "concat_ws", "count", "current_date", "current_timestamp", "date_part", "date_trunc",
+		"extract", "floor", "format", "generate_series", "greatest", "initcap", "json_agg", "json_build_object",
+		"jsonb_agg", "jsonb_build_object", "lag", "lead", "least", "left", "length", "lower", "lpad", "ltrim",
+		"max", "md5", "min", "now", "nullif", "percentile_cont", "position", "rank", "regexp_replace",
+		"replace", "right", "round", "row_number", "rpad", "rtrim", "split_part", "string_agg", "substring",
+		"sum", "to_char", "to_date", "to_timestamp", "trim", "trunc", "unnest", "upper",
+	}
+}
+
+func (postgresDialect) ListDatabases(db *sql.DB) ([]string, error) {
+	rows, err := db.Query(`
+		SELECT datname 
+		FROM pg_database 
+		WHERE datistemplate = false 
+		ORDER BY datname
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var databases []string
+	for rows.Next() {
+		var dbName string
+		if err := rows.Scan(&dbName); err != nil {
+			return nil, err
+		}
+		databases = append(databases, dbName)
+	}
+	return databases, rows.Err()
+}
+
+func (postgresDialect) DefaultSchema() string {
+	return "public"
+}
+
+func (postgresDialect) ListSchemas(db *sql.DB) ([]string, error) {
+	rows, err := db.Query(`
+		SELECT schema_name
+		FROM information_schema.schemata
+		WHERE schema_name NOT IN ('pg_catalog', 'information_schema')
+			AND schema_name NOT LIKE 'pg\_toast%'
+			AND schema_name NOT LIKE 'pg\_temp\_%'
+		ORDER BY schema_name
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var schemas []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		schemas = append(schemas, name)
+	}
+	return schemas, rows.Err()
+}
+
+func (d postgresDialect) ListTables(db *sql.DB, schema string) ([]TableInfo, error) {
+	schema = orDefault(d, schema)
+	rows, err := db.Query(pgListTablesSQL, schema)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tables []TableInfo
+	for rows.Next() {
+		var table TableInfo
+		if err := rows.Scan(&table.Name, &table.Type, &table.Comment, &table.Partitioned); err != nil {
+			return nil, err
+		}
+		table.Schema = schema
+		tables = append(tables, table)
+	}
+	return tables, rows.Err()
+}
+
+func (d postgresDialect) ListConstraints(db *sql.DB, schema, tableName string) ([]ConstraintInfo, error) {
+	// Column names come back as a JSON array in key order
+	rows, err := db.Query(pgListConstraintsSQL, orDefault(d, schema), tableName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var constraints []ConstraintInfo
+	for rows.Next() {
+		var con ConstraintInfo
+		var columns string
+		if err := rows.Scan(&con.Name, &con.Type, &columns, &con.Expression, &con.Definition); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(columns), &con.Columns); err != nil {
+			return nil, err
+		}
+		constraints = append(constraints, con)
+	}
+	return constraints, rows.Err()
+}
+
+func (d postgresDialect) TableComment(db *sql.DB, schema, tableName string) (string, error) {
+	var comment string
+	err := db.QueryRow(pgTableCommentSQL, orDefault(d, schema), tableName).Scan(&comment)
+	return comment, err
+}
+
+func (d postgresDialect) SetComment(ctx context.Context, db *sql.DB, schema, tableName, column, comment string) error {
+	schema = orDefault(d, schema)
+	target := d.Quote(schema) + "." + d.Quote(tableName)
+	if column != "" {
+		target = "COLUMN " + target + "." + d.Quote(column)
+	} else {
+		// COMMENT ON TABLE rejects views and foreign tables
+		var relkind string
+		err := db.QueryRowContext(ctx, `
+			SELECT c.relkind
+			FROM pg_class c
+			JOIN pg_namespace n ON n.oid = c.relnamespace
+			WHERE n.nspname = $1 AND c.relname = $2
+		`, schema, tableName).Scan(&relkind)
+		if err != nil {
+			return err
+		}
+		switch relkind {
+		case "v":
+			target = "VIEW " + target
+		case "m":
+			target = "MATERIALIZED VIEW " + target
+		case "f":
+			target = "FOREIGN TABLE " + target
+		default:
+			target = "TABLE " + target
+		}
+	}
+	// COMMENT takes no bind parameters
+	literal := "NULL"
+	if comment != "" {
+		literal = "'" + strings.ReplaceAll(comment, "'", "''") + "'"
+	}
+	_, err := db.ExecContext(ctx, "COMMENT ON "+target+" IS "+literal)
+	return err
+}
+
+func (d postgresDialect) ListColumns(db *sql.DB, schema, tableName string) ([]ColumnInfo, error) {
+	// Domains are reported over user-defined types, whose enum labels come
+	// back as a JSON array
+	rows, err := db.Query(pgListColumnsSQL, orDefault(d, schema), tableName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []ColumnInfo
+	for rows.Next() {
+		var col ColumnInfo
+		var maxLen, precision, scale sql.NullInt64
+		var def, typeSchema, typeName, typeKind, labels sql.NullString
+
+		if err := rows.Scan(
+			&col.Name, &col.DataType, &col.IsNullable, &def,
+			&maxLen, &precision, &scale, &col.Comment,
+			&typeSchema, &typeName, &typeKind, &labels,
+		); err != nil {
+			return nil, err
+		}
+		if typeName.Valid {
+			col.UserType = &TypeRef{Schema: typeSchema.String, Name: typeName.String, Kind: pgTypeKind(typeKind.String)}
+		}
+		if labels.Valid {
+			if err := json.Unmarshal([]byte(labels.String), &col.EnumLabels); err != nil {
+				return nil, err
+			}
+		}
+
+		if def.Valid {
+			col.Default = &def.String
+		}
+		if maxLen.Valid {
+			val := int(maxLen.Int64)
+			col.MaxLength = &val
+		}
+		if precision.Valid {
+			val := int(precision.Int64)
+			col.NumericPrecision = &val
+		}
+		if scale.Valid {
+			val := int(scale.Int64)
+			col.NumericScale = &val
+		}
+
+		columns = append(columns, col)
+	}
+	return columns, rows.Err()
+}
+
+// pgTypeKind names a pg_type.typtype code
+func pgTypeKind(typtype string) string {
+	switch typtype {
+	case "e":
+		return "enum"
+	case "d":
+		return "domain"
+	case "c":
+		return "composite"
+	case "r":
+		return "range"
+	case "m":
+		return "multirange"
+	default:
+		return "base"
+	}
+}
+
+func (d postgresDialect) ListTypes(db *sql.DB, schema string) ([]TypeInfo, error) {
+	schema = orDefault(d, schema)
+	// Composite types are those created with CREATE TYPE, not the row types
+	// of tables; types installed by extensions are left out. Lists come back
+	// as JSON.
+	rows, err := db.Query(`
+		SELECT
+			t.typname,
+			t.typtype,
+			(
+				SELECT array_to_json(array_agg(e.enumlabel ORDER BY e.enumsortorder))::text
+				FROM pg_enum e
+				WHERE e.enumtypid = t.oid
+			),
+			CASE WHEN t.typtype = 'd' THEN format_type(t.typbasetype, t.typtypmod) ELSE '' END,
+			t.typnotnull,
+			t.typdefault,
+			(
+				SELECT array_to_json(array_agg(pg_get_constraintdef(con.oid, true) ORDER BY con.conname))::text
+				FROM pg_constraint con
+				WHERE con.contypid = t.oid
+			),
+			(
+				SELECT json_agg(json_build_object(
+					'name', a.attname,
+					'data_type', format_type(a.atttypid, a.atttypmod)
+				) ORDER BY a.attnum)::text
+				FROM pg_attribute a
+				WHERE t.typtype = 'c' AND a.attrelid = t.typrelid AND a.attnum > 0 AND NOT a.attisdropped
+			)
+		FROM pg_type t
+		JOIN pg_namespace n ON n.oid = t.typnamespace
+		LEFT JOIN pg_class c ON c.oid = t.typrelid
+		WHERE n.nspname = $1
+			AND (t.typtype IN ('e', 'd') OR (t.typtype = 'c' AND c.relkind = 'c'))
+			AND NOT EXISTS (
+				SELECT 1 FROM pg_depend dep
+				WHERE dep.classid = 'pg_type'::regclass
+					AND dep.objid = t.oid
+					AND dep.deptype = 'e'
+			)
+		ORDER BY t.typname
+	`, schema)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var types []TypeInfo
+	for rows.Next() {
+		t := TypeInfo{Schema: schema}
+		var kind string
+		var def, labels, checks, attributes sql.NullString
+		if err := rows.Scan(&t.Name, &kind, &labels, &t.BaseType, &t.NotNull, &def, &checks, &attributes); err != nil {
+			return nil, err
+		}
+		t.Kind = pgTypeKind(kind)
+		if def.Valid {
+			t.Default = &def.String
+		}
+		for _, list := range []struct {
+			json sql.NullString
+			into any
+		}{{labels, &t.Labels}, {checks, &t.Checks}, {attributes, &t.Attributes}} {
+			if list.json.Valid {
+				if err := json.Unmarshal([]byte(list.json.String), list.into); err != nil {
+					return nil, err
+				}
+			}
+		}
+		types = append(types, t)
+	}
+	return types, rows.Err()
+}
+
+func (d postgresDialect) ListPrimaryKeys(db *sql.DB, schema, tableName string) ([]string, error) {
+	rows, err := db.Query(pgListPrimaryKeysSQL, orDefault(d, schema), tableName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var primaryKeys []string
+	for rows.Next() {
+		var colName string
+		if err := rows.Scan(&colName); err != nil {
+			return nil, err
+		}
+		primaryKeys = append(primaryKeys, colName)
+	}
+	return primaryKeys, rows.Err()
+}
+
+func (d postgresDialect) ListForeignKeys(db *sql.DB, schema, tableName string) ([]ForeignKeyInfo, error) {
+	rows, err := db.Query(pgListForeignKeysSQL, orDefault(d, schema), tableName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var foreignKeys []ForeignKeyInfo
+	for rows.Next() {
+		var fk ForeignKeyInfo
+		if err := rows.Scan(&fk.Column, &fk.ForeignSchema, &fk.ForeignTable, &fk.ForeignColumn); err != nil {
+			return nil, err
+		}
+		foreignKeys = append(foreignKeys, fk)
+	}
+	return foreignKeys, rows.Err()
+}
+
+func (d postgresDialect) ListIndexes(db *sql.DB, schema, tableName string) ([]IndexInfo, error) {
+	// Key columns are listed one per line; expressions come back as text
+	rows, err := db.Query(`
+		SELECT
+			i.relname,
+			(
+				SELECT string_agg(pg_get_indexdef(ix.indexrelid, k + 1, true), E'\n' ORDER BY k)
+				FROM generate_subscripts(ix.indkey, 1) AS k
+				WHERE k < ix.indnkeyatts
+			),
+			ix.indisunique,
+			ix.indisprimary,
+			am.amname,
+			pg_get_indexdef(ix.indexrelid),
+			pg_relation_size(ix.indexrelid),
+			s.idx_scan,
+			s.idx_tup_read,
+			s.idx_tup_fetch
+		FROM pg_index ix
+		JOIN pg_class i ON i.oid = ix.indexrelid
+		JOIN pg_class t ON t.oid = ix.indrelid
+		JOIN pg_namespace n ON n.oid = t.relnamespace
+		JOIN pg_am am ON am.oid = i.relam
+		LEFT JOIN pg_stat_user_indexes s ON s.indexrelid = ix.indexrelid
+		WHERE n.nspname = $1 AND t.relname = $2
+		ORDER BY i.relname
+	`, orDefault(d, schema), tableName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var indexes []IndexInfo
+	for rows.Next() {
+		var idx IndexInfo
+		var cols string
+		var size, scans, read, fetched sql.NullInt64
+		if err := rows.Scan(
+			&idx.Name, &cols, &idx.Unique, &idx.Primary, &idx.Method, &idx.Definition,
+			&size, &scans, &read, &fetched,
+		); err != nil {
+			return nil, err
+		}
+		idx.Columns = strings.Split(cols, "\n")
+		idx.SizeBytes = nullableInt64(size)
+		idx.Scans = nullableInt64(scans)
+		idx.TuplesRead = nullableInt64(read)
+		idx.TuplesFetched = nullableInt64(fetched)
+		indexes = append(indexes, idx)
+	}
+	return indexes, rows.Err()
+}
+
+func (d postgresDialect) WriteCounter(db *sql.DB, schema, tableName string) (int64, error) {
+	var n int64
+	err := db.QueryRow(`
+		SELECT n_tup_ins + n_tup_upd + n_tup_del
+		FROM pg_stat_user_tables
+		WHERE schemaname = $1 AND relname = $2
+	`, orDefault(d, schema), tableName).Scan(&n)
+	return n, err
+}
+
+// ListHypertables reads the timescaledb_information views
+func (d postgresDialect) ListHypertables(db *sql.DB, schema string) ([]HypertableInfo, error) {
+	var installed bool
+	if err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'timescaledb')`).Scan(&installed); err != nil {
+		return nil, err
+	}
+	if !installed {
+		return nil, errors.ErrUnsupported
+	}
+	schema = orDefault(d, schema)
+
+	rows, err := db.Query(`
+		SELECT
+			h.hypertable_name,
+			h.num_chunks,
+			h.compression_enabled,
+			(SELECT COUNT(*) FROM timescaledb_information.chunks ch
+			 WHERE ch.hypertable_schema = h.hypertable_schema
+			   AND ch.hypertable_name = h.hypertable_name
+			   AND ch.is_compressed)
+		FROM timescaledb_information.hypertables h
+		WHERE h.hypertable_schema = $1
+		ORDER BY h.hypertable_name
+	`, schema)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hypertables []HypertableInfo
+	index := map[string]int{}
+	for rows.Next() {
+		ht := HypertableInfo{Schema: schema, Dimensions: []HypertableDimension{}, Policies: []HypertablePolicy{}}
+		if err := rows.Scan(&ht.Name, &ht.Chunks, &ht.CompressionEnabled, &ht.CompressedChunks); err != nil {
+			return nil, err
+		}
+		index[ht.Name] = len(hypertables)
+		hypertables = append(hypertables, ht)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	rows, err = db.Query(`
+		SELECT
+			hypertable_name,
+			column_name,
+			column_type::text,
+			lower(dimension_type),
+			COALESCE(time_interval::text, integer_interval::text, ''),
+			num_partitions
+		FROM timescaledb_information.dimensions
+		WHERE hypertable_schema = $1
+		ORDER BY hypertable_name, dimension_number
+	`, schema)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var table string
+		var dim HypertableDimension
+		var partitions sql.NullInt64
+		if err := rows.Scan(&table, &dim.Column, &dim.DataType, &dim.Kind, &dim.Interval, &partitions); err != nil {
+			return nil, err
+		}
+		if partitions.Valid {
+			n := int(partitions.Int64)
+			dim.Partitions = &n
+		}
+		if i, ok := index[table]; ok {
+			hypertables[i].Dimensions = append(hypertables[i].Dimensions, dim)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	rows, err = db.Query(`
+		SELECT
+			hypertable_name,
+			job_id,
+			CASE proc_name WHEN 'policy_retention' THEN 'retention' ELSE 'compression' END,
+			COALESCE(config->>'drop_after', config->>'compress_after', ''),
+			schedule_interval::text,
+			scheduled
+		FROM timescaledb_information.jobs
+		WHERE hypertable_schema = $1 AND proc_name IN ('policy_retention', 'policy_compression')
+		ORDER BY hypertable_name, job_id
+	`, schema)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var table string
+		var p HypertablePolicy
+		if err := rows.Scan(&table, &p.JobID, &p.Type, &p.After, &p.ScheduleInterval, &p.Scheduled); err != nil {
+			return nil, err
+		}
+		if i, ok := index[table]; ok {
+			hypertables[i].Policies = append(hypertables[i].Policies, p)
+		}
+	}
+	return hypertables, rows.Err()
+}
+
+// partitionStrategies names the pg_partitioned_table.partstrat codes
+var partitionStrategies = map[string]string{"r": "range", "l": "list", "h": "hash"}
+
+// ListPartitions walks pg_partition_tree, which lists every descendant of the
+// table with its parent, and nests the partitions under their parents
+func (d postgresDialect) ListPartitions(db *sql.DB, schema, tableName string) (*PartitionInfo, error) {
+	rows, err := db.Query(`
+		SELECT
+			c.oid,
+			COALESCE(t.parentrelid::oid, 0),
+			n.nspname,
+			c.relname,
+			COALESCE(pg_get_expr(c.relpartbound, c.oid), ''),
+			CASE WHEN t.isleaf THEN pg_total_relation_size(c.oid) ELSE 0 END,
+			COALESCE(pt.partstrat::text, ''),
+			CASE WHEN pt.partrelid IS NULL THEN '' ELSE pg_get_partkeydef(c.oid) END
+		FROM pg_partition_tree(to_regclass(format('%I.%I', $1::text, $2::text))) t
+		JOIN pg_class c ON c.oid = t.relid
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		LEFT JOIN pg_partitioned_table pt ON pt.partrelid = c.oid
+		ORDER BY t.level, c.relname
+	`, orDefault(d, schema), tableName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rootOID int64
+	nodes := map[int64]*PartitionInfo{}
+	children := map[int64][]int64{}
+	for rows.Next() {
+		var oid, parent int64
+		var p PartitionInfo
+		var strategy, keyDef string
+		if err := rows.Scan(&oid, &parent, &p.Schema, &p.Name, &p.Bound, &p.SizeBytes, &strategy, &keyDef); err != nil {
+			return nil, err
+		}
+		p.Strategy = partitionStrategies[strategy]
+		// pg_get_partkeydef returns e.g. "RANGE (created_at)"
+		if _, key, ok := strings.Cut(keyDef, "("); ok {
+			p.Key = strings.TrimSuffix(key, ")")
+		}
+		nodes[oid] = &p
+		if rootOID == 0 {
+			rootOID = oid
+		} else {
+			children[parent] = append(children[parent], oid)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	root := nodes[rootOID]
+	if root == nil || root.Strategy == "" {
+		return nil, nil
+	}
+
+	var build func(oid int64) PartitionInfo
+	build = func(oid int64) PartitionInfo {
+		p := *nodes[oid]
+		for _, child := range children[oid] {
+			sub := build(child)
+			p.SizeBytes += sub.SizeBytes
+			p.Partitions = append(p.Partitions, sub)
+		}
+		return p
+	}
+	tree := build(rootOID)
+	return &tree, nil
+}
+
+// triggerCondition extracts the WHEN clause of pg_get_triggerdef output
+var triggerCondition = regexp.MustCompile(`(?s) WHEN \((.*)\) EXECUTE (?:FUNCTION|PROCEDURE) `)
+
+func (d postgresDialect) ListTriggers(db *sql.DB, schema, tableName string) ([]TriggerInfo, error) {
+	// tgtype bits: 1 row, 2 before, 4 insert, 8 delete, 16 update,
+	// 32 truncate, 64 instead of
+	rows, err := db.Query(`
+		SELECT
+			t.tgname,
+			t.tgtype,
+			t.tgenabled <> 'D',
+			pn.nspname || '.' || p.proname,
+			pg_get_triggerdef(t.oid, true)
+		FROM pg_trigger t
+		JOIN pg_class c ON c.oid = t.tgrelid
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		JOIN pg_proc p ON p.oid = t.tgfoid
+		JOIN pg_namespace pn ON pn.oid = p.pronamespace
+		WHERE n.nspname = $1 AND c.relname = $2 AND NOT t.tgisinternal
+		ORDER BY t.tgname
+	`, orDefault(d, schema), tableName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var triggers []TriggerInfo
+	for rows.Next() {
+		var t TriggerInfo
+		var tgtype int
+		if err := rows.Scan(&t.Name, &tgtype, &t.Enabled, &t.Function, &t.Definition); err != nil {
+			return nil, err
+		}
+		switch {
+		case tgtype&64 != 0:
+			t.Timing = "INSTEAD OF"
+		case tgtype&2 != 0:
+			t.Timing = "BEFORE"
+		default:
+			t.Timing = "AFTER"
+		}
+		t.Level = "STATEMENT"
+		if tgtype&1 != 0 {
+			t.Level = "ROW"
+		}
+		for _, e := range []struct {
+			bit  int
+			name string
+		}{{4, "INSERT"}, {16, "UPDATE"}, {8, "DELETE"}, {32, "TRUNCATE"}} {
+			if tgtype&e.bit != 0 {
+				t.Events = append(t.Events, e.name)
+			}
+		}
+		if m := triggerCondition.FindStringSubmatch(t.Definition); m != nil {
+			t.Condition = m[1]
+		}
+		triggers = append(triggers, t)
+	}
+	return triggers, rows.Err()
+}
+
+func (d postgresDialect) ListTableStats(db *sql.DB, schema, tableName string) ([]TableStats, error) {
+	schema = orDefault(d, schema)
+	// reltuples is -1 for tables that were never vacuumed or analyzed
+	rows, err := db.Query(`
+		SELECT
+			c.relname,
+			CASE WHEN c.reltuples < 0 THEN s.n_live_tup ELSE c.reltuples::bigint END,
+			pg_total_relation_size(c.oid),
+			pg_relation_size(c.oid),
+			pg_indexes_size(c.oid),
+			s.n_dead_tup,
+			s.last_vacuum,
+			s.last_autovacuum,
+			s.last_analyze,
+			s.last_autoanalyze
+		FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		LEFT JOIN pg_stat_user_tables s ON s.relid = c.oid
+		WHERE n.nspname = $1
+			AND c.relkind IN ('r', 'p', 'm')
+			AND ($2 = '' OR c.relname = $2)
+		ORDER BY c.relname
+	`, schema, tableName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []TableStats
+	for rows.Next() {
+		st := TableStats{Schema: schema}
+		var estimate, total, table, index, dead sql.NullInt64
+		var vacuum, autovacuum, analyze, autoanalyze sql.NullTime
+		if err := rows.Scan(
+			&st.Name, &estimate, &total, &table, &index, &dead,
+			&vacuum, &autovacuum, &analyze, &autoanalyze,
+		); err != nil {
+			return nil, err
+		}
+		st.RowEstimate = nullableInt64(estimate)
+		st.TotalBytes = nullableInt64(total)
+		st.TableBytes = nullableInt64(table)
+		st.IndexBytes = nullableInt64(index)
+		st.DeadTuples = nullableInt64(dead)
+		st.LastVacuum = nullableTime(vacuum)
+		st.LastAutovacuum = nullableTime(autovacuum)
+		st.LastAnalyze = nullableTime(analyze)
+		st.LastAutoanalyze = nullableTime(autoanalyze)
+		stats = append(stats, st)
+	}
+	return stats, rows.Err()
+}
+
+// ListColumnStats reads pg_stats, where a negative n_distinct is a fraction
+// of the table's rows rather than a count
+func (d postgresDialect) ListColumnStats(db *sql.DB, schema string) ([]ColumnStats, error) {
+	rows, err := db.Query(`
+		SELECT
+			s.tablename,
+			s.attname,
+			CASE WHEN s.n_distinct >= 0 THEN s.n_distinct
+				ELSE -s.n_distinct * GREATEST(c.reltuples, 0) END,
+			s.null_frac
+		FROM pg_stats s
+		JOIN pg_namespace n ON n.nspname = s.schemaname
+		JOIN pg_class c ON c.relnamespace = n.oid AND c.relname = s.tablename
+		WHERE s.schemaname = $1 AND NOT s.inherited
+	`, orDefault(d, schema))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []ColumnStats
+	for rows.Next() {
+		var st ColumnStats
+		if err := rows.Scan(&st.Table, &st.Column, &st.Distinct, &st.NullFraction); err != nil {
+			return nil, err
+		}
+		stats = append(stats, st)
+	}
+	return stats, rows.Err()
+}
+
+func (d postgresDialect) ListViews(db *sql.DB, schema string) ([]ViewInfo, error) {
+	schema = orDefault(d, schema)
+	// Dependencies come from the view's rewrite rule, one "schema<TAB>name"
+	// per line
+	rows, err := db.Query(`
+		SELECT
+			c.relname,
+			c.relkind = 'm',
+			pg_get_viewdef(c.oid, true),
+			COALESCE(m.ispopulated, true),
+			COALESCE((
+				SELECT string_agg(DISTINCT dn.nspname || E'\t' || dc.relname, E'\n')
+				FROM pg_rewrite r
+				JOIN pg_depend dep
+					ON dep.classid = 'pg_rewrite'::regclass
+					AND dep.objid = r.oid
+					AND dep.refclassid = 'pg_class'::regclass
+				JOIN pg_class dc ON dc.oid = dep.refobjid
+				JOIN pg_namespace dn ON dn.oid = dc.relnamespace
+				WHERE r.ev_class = c.oid AND dc.oid <> c.oid
+			), '')
+		FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		LEFT JOIN pg_matviews m
+			ON m.schemaname = n.nspname AND m.matviewname = c.relname
+		WHERE n.nspname = $1 AND c.relkind IN ('v', 'm')
+		ORDER BY c.relname
+	`, schema)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var views []ViewInfo
+	for rows.Next() {
+		var v ViewInfo
+		var populated bool
+		var deps string
+		if err := rows.Scan(&v.Name, &v.Materialized, &v.Definition, &populated, &deps); err != nil {
+			return nil, err
+		}
+		v.Schema = schema
+		if v.Materialized {
+			v.Populated = &populated
+		}
+		v.Dependencies = []ViewDependency{}
+		for _, line := range strings.Split(deps, "\n") {
+			if depSchema, name, ok := strings.Cut(line, "\t"); ok {
+				v.Dependencies = append(v.Dependencies, ViewDependency{Schema: depSchema, Name: name})
+			}
+		}
+		views = append(views, v)
+	}
+	return views, rows.Err()
+}
+
+func (d postgresDialect) ListSequences(db *sql.DB, schema string) ([]SequenceInfo, error) {
+	schema = orDefault(d, schema)
+	// Serial columns own their sequence with an auto dependency, identity
+	// columns with an internal one. last_value is null when the sequence
+	// was never used or isn't readable by the current role.
+	rows, err := db.Query(`
+		SELECT
+			s.sequencename,
+			s.data_type::text,
+			s.start_value,
+			s.increment_by,
+			s.min_value,
+			s.max_value,
+			s.cache_size,
+			s.cycle,
+			s.last_value,
+			tc.relname,
+			a.attname
+		FROM pg_sequences s
+		JOIN pg_namespace n ON n.nspname = s.schemaname
+		JOIN pg_class c ON c.relnamespace = n.oid AND c.relname = s.sequencename
+		LEFT JOIN pg_depend dep
+			ON dep.classid = 'pg_class'::regclass
+			AND dep.objid = c.oid
+			AND dep.refclassid = 'pg_class'::regclass
+			AND dep.refobjsubid > 0
+			AND dep.deptype IN ('a', 'i')
+		LEFT JOIN pg_class tc ON tc.oid = dep.refobjid
+		LEFT JOIN pg_attribute a ON a.attrelid = dep.refobjid AND a.attnum = dep.refobjsubid
+		WHERE s.schemaname = $1
+		ORDER BY s.sequencename
+	`, schema)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sequences []SequenceInfo
+	for rows.Next() {
+		s := SequenceInfo{Schema: schema}
+		var cache, last sql.NullInt64
+		var table, column sql.NullString
+		if err := rows.Scan(
+			&s.Name, &s.DataType, &s.StartValue, &s.Increment, &s.MinValue, &s.MaxValue,
+			&cache, &s.Cycle, &last, &table, &column,
+		); err != nil {
+			return nil, err
+		}
+		s.Cache = nullableInt64(cache)
+		s.LastValue = nullableInt64(last)
+		if table.Valid {
+			s.OwnedBy = &SequenceOwner{Table: table.String, Column: column.String}
+		}
+		sequences = append(sequences, s)
+	}
+	return sequences, rows.Err()
+}
+
+func (d postgresDialect) ResetSequence(ctx context.Context, tx *sql.Tx, seq SequenceInfo) error {
+	col := d.Quote(seq.OwnedBy.Column)
+	_, err := tx.ExecContext(ctx, fmt.Sprintf(
+		`SELECT setval($1::regclass, COALESCE(MAX(%s), $2), MAX(%s) IS NOT NULL) FROM %s.%s`,
+		col, col, d.Quote(seq.Schema), d.Quote(seq.OwnedBy.Table),
+	), d.Quote(seq.Schema)+"."+d.Quote(seq.Name), seq.StartValue)
+	return err
+}
+
+func (d postgresDialect) ListFunctions(db *sql.DB, schema string) ([]FunctionInfo, error) {
+	schema = orDefault(d, schema)
+	// pg_get_functiondef rejects aggregates
+	rows, err := db.Query(`
+		SELECT
+			p.proname,
+			CASE p.prokind
+				WHEN 'p' THEN 'procedure'
+				WHEN 'a' THEN 'aggregate'
+				WHEN 'w' THEN 'window'
+				ELSE 'function'
+			END,
+			pg_get_function_arguments(p.oid),
+			COALESCE(pg_get_function_result(p.oid), ''),
+			l.lanname,
+			CASE p.provolatile
+				WHEN 'i' THEN 'immutable'
+				WHEN 's' THEN 'stable'
+				ELSE 'volatile'
+			END,
+			CASE WHEN p.prokind IN ('f', 'p', 'w') THEN pg_get_functiondef(p.oid) ELSE '' END
+		FROM pg_proc p
+		JOIN pg_namespace n ON n.oid = p.pronamespace
+		JOIN pg_language l ON l.oid = p.prolang
+		WHERE n.nspname = $1
+			AND NOT EXISTS (
+				SELECT 1 FROM pg_depend dep
+				WHERE dep.classid = 'pg_proc'::regclass
+					AND dep.objid = p.oid
+					AND dep.deptype = 'e'
+			)
+		ORDER BY p.proname, pg_get_function_identity_arguments(p.oid)
+	`, schema)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var functions []FunctionInfo
+	for rows.Next() {
+		f := FunctionInfo{Schema: schema}
+		if err := rows.Scan(&f.Name, &f.Kind, &f.Arguments, &f.ReturnType, &f.Language, &f.Volatility, &f.Definition); err != nil {
+			return nil, err
+		}
+		functions = append(functions, f)
+	}
+	return functions, rows.Err()
+}
+
+func (d postgresDialect) RefreshMaterializedView(ctx context.Context, db *sql.DB, schema, view string, concurrently bool) error {
+	stmt := "REFRESH MATERIALIZED VIEW "
+	if concurrently {
+		stmt += "CONCURRENTLY "
+	}
+	_, err := db.ExecContext(ctx, stmt+d.Quote(orDefault(d, schema))+"."+d.Quote(view))
+	return err
+}
+
+// ListContinuousAggregates reads timescaledb_information. The refresh policy
+// job runs against the aggregate's materialization hypertable.
+func (d postgresDialect) ListContinuousAggregates(db *sql.DB, schema string) ([]ContinuousAggregateInfo, error) {
+	var installed bool
+	if err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'timescaledb')`).Scan(&installed); err != nil {
+		return nil, err
+	}
+	if !installed {
+		return nil, errors.ErrUnsupported
+	}
+	schema = orDefault(d, schema)
+
+	rows, err := db.Query(`
+		SELECT
+			ca.view_name,
+			ca.hypertable_schema,
+			ca.hypertable_name,
+			ca.materialized_only,
+			ca.compression_enabled,
+			ca.view_definition,
+			j.job_id,
+			COALESCE(j.config->>'start_offset', ''),
+			COALESCE(j.config->>'end_offset', ''),
+			COALESCE(j.schedule_interval::text, ''),
+			COALESCE(j.scheduled, false),
+			js.last_successful_finish
+		FROM timescaledb_information.continuous_aggregates ca
+		LEFT JOIN timescaledb_information.jobs j
+			ON j.proc_name = 'policy_refresh_continuous_aggregate'
+			AND j.hypertable_schema = ca.materialization_hypertable_schema
+			AND j.hypertable_name = ca.materialization_hypertable_name
+		LEFT JOIN timescaledb_information.job_stats js ON js.job_id = j.job_id
+		WHERE ca.view_schema = $1
+		ORDER BY ca.view_name
+	`, schema)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var caggs []ContinuousAggregateInfo
+	for rows.Next() {
+		cagg := ContinuousAggregateInfo{Schema: schema}
+		var policy ContinuousAggregatePolicy
+		var jobID sql.NullInt64
+		var lastRefresh sql.NullTime
+		if err := rows.Scan(&cagg.Name, &cagg.HypertableSchema, &cagg.HypertableName,
+			&cagg.MaterializedOnly, &cagg.CompressionEnabled, &cagg.Definition,
+			&jobID, &policy.StartOffset, &policy.EndOffset, &policy.ScheduleInterval, &policy.Scheduled,
+			&lastRefresh); err != nil {
+			return nil, err
+		}
+		if jobID.Valid {
+			policy.JobID = jobID.Int64
+			cagg.RefreshPolicy = &policy
+		}
+		if lastRefresh.Valid {
+			cagg.LastRefresh = &lastRefresh.Time
+		}
+		caggs = append(caggs, cagg)
+	}
+	return caggs, rows.Err()
+}
+
+// RefreshContinuousAggregate calls refresh_continuous_aggregate, which cannot
+// run inside a transaction. Bounds are cast so the "any" arguments get a type.
+func (d postgresDialect) RefreshContinuousAggregate(ctx context.Context, db *sql.DB, schema, view string, start, end any) error {
+	var args []any
+	bound := func(v any) string {
+		switch v.(type) {
+		case nil:
+			return "NULL"
+		case int64:
+			args = append(args, v)
+			return fmt.Sprintf("$%d::bigint", len(args)+1)
+		default:
+			args = append(args, v)
+			return fmt.Sprintf("$%d::timestamptz", len(args)+1)
+		}
+	}
+	stmt := fmt.Sprintf("CALL refresh_continuous_aggregate($1::regclass, %s, %s)", bound(start), bound(end))
+	args = append([]any{d.Quote(orDefault(d, schema)) + "." + d.Quote(view)}, args...)
+	_, err := db.ExecContext(ctx, stmt, args...)
+	return err
+}
+
+// BeginReadOnly also applies the schema and time zone of the request's
+// workspace for the length of the transaction
+func (postgresDialect) BeginReadOnly(ctx context.Context, db *sql.DB) (*sql.Tx, func(), error) {
+	return beginWorkspaceTx(ctx, db, &sql.TxOptions{ReadOnly: true})
+}
+
+// BeginSession starts a REPEATABLE READ transaction, whose snapshot is
+// taken by its first statement, in the request's workspace like
+// BeginReadOnly
+func (postgresDialect) BeginSession(ctx context.Context, db *sql.DB, write bool) (*sql.Tx, func(), error) {
+	return beginWorkspaceTx(ctx, db, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: !write})
+}
+
+// beginWorkspaceTx starts a transaction with the workspace settings and
+// resource limits of the request
+func beginWorkspaceTx(ctx context.Context, db *sql.DB, opts *sql.TxOptions) (*sql.Tx, func(), error) {
+	tx, err := db.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	settings := map[string]string{}
+	if ws := workspaceFrom(ctx); ws != nil {
+		settings["TimeZone"] = ws.Timezone
+		if ws.Schema != "" {
+			settings["search_path"] = postgresDialect{}.Quote(ws.Schema)
+		}
+	}
+	for setting, value := range resourceSettings(ctx) {
+		settings[setting] = value
+	}
+	if err := (postgresDialect{}).SetLocal(ctx, tx, settings); err != nil {
+		tx.Rollback()
+		return nil, nil, err
+	}
+	return tx, func() { tx.Rollback() }, nil
+}
+
+// SetLocal uses set_config, as SET LOCAL takes no parameters; empty values
+// are skipped
+func (postgresDialect) SetLocal(ctx context.Context, tx *sql.Tx, settings map[string]string) error {
+	for setting, value := range settings {
+		if value == "" {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, "SELECT set_config($1, $2, true)", setting, value); err != nil {
+			return fmt.Errorf("setting %s: %w", setting, err)
+		}
+	}
+	return nil
+}
+
+// Retryable matches serialization_failure and deadlock_detected
+func (postgresDialect) Retryable(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && (pgErr.Code == "40001" || pgErr.Code == "40P01")
+}
+
+// ConnectionLost matches the connection_exception class, terminations by a
+// shutting down or restarting server and network errors other than timeouts
+func (postgresDialect) ConnectionLost(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return strings.HasPrefix(pgErr.Code, "08") || pgErr.Code == "57P01" || pgErr.Code == "57P02" || pgErr.Code == "57P03"
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return !netErr.Timeout()
+	}
+	return errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.ErrUnexpectedEOF) || pgconn.SafeToRetry(err)
+}
+
+// RowVersion is the ID of the transaction that last wrote the row
+func (postgresDialect) RowVersion() string {
+	return "xmin::text"
+}
+
+// CopyOut runs COPY through the pgx connection underneath database/sql,
+// which has no API for it
+func (postgresDialect) CopyOut(ctx context.Context, db *sql.DB, w io.Writer, query, format string) error {
+	options := "FORMAT csv, HEADER"
+	if format == "binary" {
+		options = "FORMAT binary"
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Raw(func(driverConn interface{}) error {
+		pg := pgxConn(driverConn).PgConn()
+		if err := pg.Exec(ctx, "BEGIN READ ONLY").Close(); err != nil {
+			return err
+		}
+		defer pg.Exec(context.Background(), "ROLLBACK").Close()
+		_, err := pg.CopyTo(ctx, w, fmt.Sprintf("COPY (%s) TO STDOUT WITH (%s)", query, options))
+		return err
+	})
+}
+
+// pgxConn returns the pgx connection under a database/sql driver
+// connection, which the tracing instrumentation may wrap
+func pgxConn(driverConn interface{}) *pgx.Conn {
+	if wrapped, ok := driverConn.(interface{ Raw() driver.Conn }); ok {
+		driverConn = wrapped.Raw()
+	}
+	return driverConn.(*stdlib.Conn).Conn()
+}
+
+// wal2jsonOptions are the options of the wal2json output plugin: one JSON
+// object per change, with begin and commit records carrying the commit time
+const wal2jsonOptions = `'format-version', '2', 'include-transaction', 'true', 'include-timestamp', 'true', 'add-tables', $2`
+
+// wal2jsonChange is a record written by wal2json
+type wal2jsonChange struct {
+	Action    string          `json:"action"`
+	Schema    string          `json:"schema"`
+	Table     string          `json:"table"`
+	Timestamp string          `json:"timestamp"`
+	Columns   []wal2jsonValue `json:"columns"`
+	Identity  []wal2jsonValue `json:"identity"`
+}
+
+type wal2jsonValue struct {
+	Name  string      `json:"name"`
+	Value interface{} `json:"value"`
+}
+
+var wal2jsonActions = map[string]string{"I": "insert", "U": "update", "D": "delete", "T": "truncate"}
+
+// PeekChanges decodes the slot with wal2json. The changes of a transaction
+// are numbered from its commit LSN, as transactions are decoded in commit
+// order but their changes' own LSNs interleave.
+func (postgresDialect) PeekChanges(ctx context.Context, db *sql.DB, slot string, tables []string, after string, limit int) ([]ChangeEvent, error) {
+	if err := pgEnsureSlot(ctx, db, slot); err != nil {
+		return nil, err
+	}
+	rows, err := db.QueryContext(ctx, "SELECT lsn::text, data FROM pg_logical_slot_peek_changes($1, NULL, NULL, "+wal2jsonOptions+")",
+		slot, strings.Join(tables, ","))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	changes := []ChangeEvent{}
+	var txn []ChangeEvent
+	for rows.Next() {
+		var lsn, data string
+		if err := rows.Scan(&lsn, &data); err != nil {
+			return nil, err
+		}
+		var rec wal2jsonChange
+		if err := json.Unmarshal([]byte(data), &rec); err != nil {
+			return nil, fmt.Errorf("wal2json record at %s: %w", lsn, err)
+		}
+		switch rec.Action {
+		case "B":
+			txn = txn[:0]
+		case "C":
+			commit, err := pgParseLSN(lsn)
+			if err != nil {
+				return nil, err
+			}
+			for i, change := range txn {
+				change.Position = fmt.Sprintf("%016X-%06d", commit, i)
+				change.CommittedAt = rec.Timestamp
+				if change.Position <= after {
+					continue
+				}
+				changes = append(changes, change)
+				if len(changes) >= limit {
+					return changes, nil
+				}
+			}
+		default:
+			action, ok := wal2jsonActions[rec.Action]
+			if !ok {
+				continue
+			}
+			change := ChangeEvent{Action: action, Schema: rec.Schema, Table: rec.Table}
+			if len(rec.Columns) > 0 {
+				change.Columns = map[string]interface{}{}
+				for _, v := range rec.Columns {
+					change.Columns[v.Name] = v.Value
+				}
+			}
+			if len(rec.Identity) > 0 {
+				change.Identity = map[string]interface{}{}
+				for _, v := range rec.Identity {
+					change.Identity[v.Name] = v.Value
+				}
+			}
+			txn = append(txn, change)
+		}
+	}
+	return changes, rows.Err()
+}
+
+// AdvanceSlot moves the slot to the commit of the last transaction before
+// the one of position, which may have been read only in part. Slots don't
+// move backwards.
+func (postgresDialect) AdvanceSlot(ctx context.Context, db *sql.DB, slot string, tables []string, position string) error {
+	if err := pgEnsureSlot(ctx, db, slot); err != nil {
+		return err
+	}
+	target := "pg_current_wal_lsn()"
+	args := []interface{}{slot}
+	if position != "" {
+		commit, _, _ := strings.Cut(position, "-")
+		var lsn sql.NullString
+		err := db.QueryRowContext(ctx, `SELECT max(lsn)::text FROM pg_logical_slot_peek_changes($1, NULL, NULL, `+wal2jsonOptions+`)
+			WHERE data::jsonb->>'action' = 'C' AND lsn < $3::pg_lsn`,
+			slot, strings.Join(tables, ","), pgFormatLSN(commit)).Scan(&lsn)
+		if err != nil || !lsn.Valid {
+			return err
+		}
+		target = "$2::pg_lsn"
+		args = append(args, lsn.String)
+	}
+	_, err := db.ExecContext(ctx, fmt.Sprintf(`SELECT pg_replication_slot_advance(slot_name, %s)
+		FROM pg_replication_slots WHERE slot_name = $1 AND confirmed_flush_lsn < %s`, target, target), args...)
+	return err
+}
+
+// ReplicationLag measures a standby's lag as the age of the last transaction
+// replayed. A standby that has replayed everything it received is not
+// lagging, however long ago the primary last wrote.
+func (postgresDialect) ReplicationLag(ctx context.Context, db *sql.DB) (bool, time.Duration, error) {
+	var replica bool
+	var seconds sql.NullFloat64
+	err := db.QueryRowContext(ctx, `
+		SELECT pg_is_in_recovery(),
+			CASE WHEN NOT pg_is_in_recovery() OR pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+			ELSE EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()) END`).Scan(&replica, &seconds)
+	if err != nil {
+		return false, 0, err
+	}
+	return replica, time.Duration(seconds.Float64 * float64(time.Second)), nil
+}
+
+func (postgresDialect) ServerInfo(ctx context.Context, db *sql.DB) (string, time.Time, error) {
+	var version string
+	var now time.Time
+	err := db.QueryRowContext(ctx, "SELECT current_setting('server_version'), clock_timestamp()").Scan(&version, &now)
+	return "PostgreSQL " + version, now, err
+}
+
+func (d postgresDialect) UnreadableTables(ctx context.Context, db *sql.DB, schema string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT c.relname
+		FROM pg_class c
+		JOIN pg_namespace n ON n.oid = c.relnamespace
+		WHERE n.nspname = $1 AND c.relkind IN ('r', 'p', 'v', 'm', 'f')
+			AND NOT (has_schema_privilege(n.oid, 'USAGE') AND has_table_privilege(c.oid, 'SELECT'))
+		ORDER BY c.relname
+	`, orDefault(d, schema))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tables []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		tables = append(tables, name)
+	}
+	return tables, rows.Err()
+}
+
+// StatementStats checks pg_stat_statements is installed and preloaded, and
+// that the connection sees every role's statements in it
+func (postgresDialect) StatementStats(ctx context.Context, db *sql.DB) error {
+	var installed, readAll bool
+	err := db.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'pg_stat_statements'),
+			pg_has_role('pg_read_all_stats', 'MEMBER')`).Scan(&installed, &readAll)
+	if err != nil {
+		return err
+	}
+	if !installed {
+		return errors.New("the pg_stat_statements extension is not installed")
+	}
+	// Reading fails unless the library is in shared_preload_libraries
+	if _, err := db.ExecContext(ctx, "SELECT 1 FROM pg_stat_statements LIMIT 1"); err != nil {
+		return fmt.Errorf("pg_stat_statements can't be read: %w", err)
+	}
+	if !readAll {
+		return errors.New("pg_stat_statements hides other roles' statements without pg_read_all_stats")
+	}
+	return nil
+}
+
+// pgEnsureSlot creates the logical replication slot unless it exists
+func pgEnsureSlot(ctx context.Context, db *sql.DB, slot string) error {
+	var exists bool
+	err := db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM pg_replication_slots WHERE slot_name = $1)", slot).Scan(&exists)
+	if err != nil || exists {
+		return err
+	}
+	_, err = db.ExecContext(ctx, "SELECT pg_create_logical_replication_slot($1, 'wal2json')", slot)
+	// Another replica may have created it meanwhile
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "42710" {
+		return nil
+	}
+	return err
+}
+
+// pgParseLSN parses an LSN printed as two hexadecimal halves, e.g. 16/B374D848
+func pgParseLSN(lsn string) (uint64, error) {
+	hi, lo, ok := strings.Cut(lsn, "/")
+	h, errHi := strconv.ParseUint(hi, 16, 32)
+	l, errLo := strconv.ParseUint(lo, 16, 32)
+	if !ok || errHi != nil || errLo != nil {
+		return 0, fmt.Errorf("invalid LSN %q", lsn)
+	}
+	return h<<32 | l, nil
+}
+
+// pgFormatLSN prints the 16 hexadecimal digits of an LSN in PostgreSQL's
+// notation
+func pgFormatLSN(digits string) string {
+	n, _ := strconv.ParseUint(digits, 16, 64)
+	return fmt.Sprintf("%X/%X", n>>32, n&0xFFFFFFFF)
+}
+
+// Listen holds a pooled connection for as long as it listens, and
+// unsubscribes it before handing it back
+func (d postgresDialect) Listen(ctx context.Context, db *sql.DB, channel string, ready func(), notify func(payload string)) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Raw(func(driverConn interface{}) error {
+		pg := pgxConn(driverConn)
+		if _, err := pg.Exec(ctx, "LISTEN "+d.Quote(channel)); err != nil {
+			return err
+		}
+		ready()
+		for {
+			n, err := pg.WaitForNotification(ctx)
+			if err == nil {
+				notify(n.Payload)
+				continue
+			}
+			if ctx.Err() == nil {
+				return err
+			}
+			if _, err := pg.Exec(context.Background(), "UNLISTEN *"); err != nil {
+				return driver.ErrBadConn
+			}
+			return nil
+		}
+	})
+}
+
+func (postgresDialect) Quote(ident string) string {
+	return `"` + strings.ReplaceAll(ident, `"`, `""`) + `"`
+}
+
+func (postgresDialect) ProductName() string {
+	return "PostgreSQL"
+}
+
+func (postgresDialect) StrictTypes() bool {
+	return true
+}
+
+func (postgresDialect) ExplainQuery(query string) string {
+	return "EXPLAIN (FORMAT JSON) " + query
+}
+
+func (postgresDialect) Placeholder(n int) string {
+	return fmt.Sprintf("$%d", n)
+}
+
+func (postgresDialect) LimitClause(n int) string {
+	return fmt.Sprintf("LIMIT %d", n)
+}
+
+func (postgresDialect) BucketEpoch(expr string, seconds int64) string {
+	return fmt.Sprintf("(floor(extract(epoch FROM %s) / %d) * %d)::bigint", expr, seconds, seconds)
+}
+
+func (postgresDialect) TruncateTime(expr, unit string) string {
+	return fmt.Sprintf("date_trunc('%s', %s)", unit, expr)
+}
+
+// BinarySlice supports bytea and large objects referenced by an oid column
+func (postgresDialect) BinarySlice(col, dataType, start, count string) (string, string, bool) {
+	switch dataType {
+	case "bytea":
+		return "octet_length(" + col + ")", fmt.Sprintf("substring(%s FROM %s FOR %s)", col, start, count), true
+	case "oid":
+		// lo_get takes a 0-based offset
+		return "octet_length(lo_get(" + col + "))", fmt.Sprintf("lo_get(%s, (%s) - 1, %s)", col, start, count), true
+	}
+	return "", "", false
+}
+
+// JSONPath supports json and jsonb columns; digit-only path elements index
+// arrays
+func (postgresDialect) JSONPath(col, dataType string, path []string, numeric bool) (string, bool) {
+	if dataType != "json" && dataType != "jsonb" {
+		return "", false
+	}
+	doc := col + "::jsonb"
+	if len(path) == 0 {
+		return doc + " #>> '{}'", true
+	}
+	parent := doc
+	for _, key := range path[:len(path)-1] {
+		parent += " -> " + pgJSONKey(key)
+	}
+	last := pgJSONKey(path[len(path)-1])
+	if numeric {
+		return fmt.Sprintf("CASE WHEN jsonb_typeof(%s -> %s) = 'number' THEN (%s ->> %s)::numeric END", parent, last, parent, last), true
+	}
+	return parent + " ->> " + last, true
+}
+
+// TextSearchDocument joins the columns as text and parses them with
+// to_tsvector; config must be a valid identifier
+func (postgresDialect) TextSearchDocument(cols []string, config string) (string, bool) {
+	texts := make([]string, len(cols))
+	for i, col := range cols {
+		texts[i] = col + "::text"
+	}
+	doc := "concat_ws(' ', " + strings.Join(texts, ", ") + ")"
+	if config != "" {
+		return fmt.Sprintf("to_tsvector(%s::regconfig, %s)", sqlLiteral(config), doc), true
+	}
+	return "to_tsvector(" + doc + ")", true
+}
+
+// pgJSONKey returns a path element as the right operand of -> and ->>
+func pgJSONKey(key string) string {
+	if key != "" && strings.Trim(key, "0123456789") == "" {
+		return key
+	}
+	return sqlLiteral(key)
+}
+
+func (postgresDialect) ParseStatement(sqlText string) (sqlparser.Statement, error) {
+	return sqlparser.Parse(sqlText)
+}
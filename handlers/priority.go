@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+
+	"sql-engine/auth"
+	"sql-engine/rbac"
+
+	"github.com/gin-gonic/gin"
+)
+
+type lowPriorityKey struct{}
+
+// isLowPriority reports whether a request runs its queries on the
+// low-priority pool
+func isLowPriority(ctx context.Context) bool {
+	low, _ := ctx.Value(lowPriorityKey{}).(bool)
+	return low
+}
+
+// UseLowPriorityPool sets the pool that runs the queries of low-priority
+// callers; nil runs every query on the main pool
+func (h *Handler) UseLowPriorityPool(db *sql.DB) {
+	h.lowDB = db
+	if db != nil {
+		h.lowStmts = make(chan struct{}, h.cfg.Database.LowPriority.MaxOpenConns)
+	}
+}
+
+// ClassifyPriority marks the requests of anonymous callers and of the
+// principals and roles listed in database.low_priority as low priority. It
+// must run after RBAC.
+func (h *Handler) ClassifyPriority() gin.HandlerFunc {
+	low := h.cfg.Database.LowPriority
+	return func(c *gin.Context) {
+		if h.lowDB == nil {
+			c.Next()
+			return
+		}
+		principal := auth.Principal(c)
+		var roles []string
+		if access := rbac.FromContext(c.Request.Context()); access != nil {
+			roles = access.Roles
+		}
+		if (principal == "" && low.Anonymous) ||
+			(principal != "" && slices.Contains(low.Principals, principal)) ||
+			slices.ContainsFunc(low.Roles, func(r string) bool { return slices.Contains(roles, r) }) {
+			c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), lowPriorityKey{}, true))
+		}
+		c.Next()
+	}
+}
+
+// queryDB is the pool the request's read-only queries run on: the
+// low-priority pool, a healthy read replica or else the primary
+func (h *Handler) queryDB(ctx context.Context) *sql.DB {
+	if h.lowDB != nil && isLowPriority(ctx) {
+		return h.lowDB
+	}
+	if db := h.pickReplica(); db != nil {
+		return db
+	}
+	return h.db
+}
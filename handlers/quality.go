@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"sql-engine/alert"
+	"sql-engine/config"
+	"sql-engine/lineage"
+
+	"github.com/gin-gonic/gin"
+)
+
+const qualityResultPrefix = "quality-result/"
+
+// QualityResult is the latest evaluation of a data-quality rule
+type QualityResult struct {
+	Rule      config.QualityRule `json:"rule"`
+	Status    string             `json:"status"` // "pass", "fail" or "error"
+	Observed  string             `json:"observed,omitempty"`
+	Message   string             `json:"message,omitempty"`
+	CheckedAt time.Time          `json:"checked_at"`
+}
+
+// GetQuality returns the latest result of every configured rule
+func (h *Handler) GetQuality(c *gin.Context) {
+	results := []QualityResult{}
+	summary := map[string]int{"pass": 0, "fail": 0, "error": 0, "pending": 0}
+	for _, rule := range h.cfg.Quality.Rules {
+		var r QualityResult
+		if err := h.meta.Get(qualityResultPrefix+rule.Name, &r); err != nil {
+			r = QualityResult{Rule: rule, Status: "pending"}
+		}
+		summary[r.Status]++
+		results = append(results, r)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"summary": summary,
+		"results": results,
+	})
+}
+
+// RunQuality evaluates every rule immediately
+func (h *Handler) RunQuality(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"results": h.evaluateQuality(c.Request.Context())})
+}
+
+// StartQualityChecks schedules periodic evaluation of the configured rules
+func (h *Handler) StartQualityChecks() {
+	if len(h.cfg.Quality.Rules) == 0 {
+		return
+	}
+	h.sched.Every("quality", h.cfg.Quality.Every, func(ctx context.Context) {
+		h.evaluateQuality(ctx)
+	})
+}
+
+// evaluateQuality checks every rule, stores the results and alerts on status
+// changes
+func (h *Handler) evaluateQuality(ctx context.Context) []QualityResult {
+	var results []QualityResult
+	for _, rule := range h.cfg.Quality.Rules {
+		r := h.evaluateRule(ctx, rule)
+
+		var previous QualityResult
+		hadPrevious := h.meta.Get(qualityResultPrefix+rule.Name, &previous) == nil
+		if err := h.meta.Put(qualityResultPrefix+rule.Name, r); err != nil {
+			slog.ErrorContext(ctx, "Failed to store quality result", "error", err)
+		}
+
+		switch {
+		case r.Status != "pass" && (!hadPrevious || previous.Status != r.Status):
+			h.alerts.Send(ctx, alert.Alert{
+				Source:  "quality",
+				Subject: rule.Name,
+				Status:  "failing",
+				Message: r.Message,
+				Details: r,
+			})
+		case r.Status == "pass" && hadPrevious && previous.Status != "pass":
+			h.alerts.Send(ctx, alert.Alert{
+				Source:  "quality",
+				Subject: rule.Name,
+				Status:  "resolved",
+				Message: "Rule passes again",
+			})
+		}
+		results = append(results, r)
+	}
+	return results
+}
+
+func (h *Handler) evaluateRule(ctx context.Context, rule config.QualityRule) (r QualityResult) {
+	inputs := []lineage.Dataset{h.lineage.Dataset("", rule.Table)}
+	if rule.RefTable != "" {
+		inputs = append(inputs, h.lineage.Dataset("", rule.RefTable))
+	}
+	run := h.lineage.StartRun("quality."+rule.Name, "", inputs, nil)
+	defer func() {
+		if r.Status == "error" {
+			run.Finish(errors.New(r.Message))
+		} else {
+			run.Finish(nil)
+		}
+	}()
+
+	r = QualityResult{Rule: rule, CheckedAt: time.Now()}
+	q := h.dialect.Quote
+	table := q(rule.Table)
+
+	var err error
+	switch rule.Type {
+	case "unique":
+		cols := make([]string, len(rule.Columns))
+		for i, col := range rule.Columns {
+			cols[i] = q(col)
+		}
+		var dupes int64
+		err = h.db.QueryRowContext(ctx, fmt.Sprintf(
+			"SELECT COUNT(*) FROM (SELECT 1 FROM %s GROUP BY %s HAVING COUNT(*) > 1) d",
+			table, strings.Join(cols, ", "))).Scan(&dupes)
+		r.Observed = fmt.Sprintf("%d duplicate groups", dupes)
+		if err == nil && dupes > 0 {
+			r.Message = fmt.Sprintf("%d value groups of (%s) are duplicated", dupes, strings.Join(rule.Columns, ", "))
+		}
+
+	case "references":
+		var orphans int64
+		err = h.db.QueryRowContext(ctx, fmt.Sprintf(
+			"SELECT COUNT(*) FROM %s c WHERE c.%s IS NOT NULL AND NOT EXISTS (SELECT 1 FROM %s p WHERE p.%s = c.%s)",
+			table, q(rule.Column), q(rule.RefTable), q(rule.RefColumn), q(rule.Column))).Scan(&orphans)
+		r.Observed = fmt.Sprintf("%d orphaned rows", orphans)
+		if err == nil && orphans > 0 {
+			r.Message = fmt.Sprintf("%d rows reference missing %s.%s values", orphans, rule.RefTable, rule.RefColumn)
+		}
+
+	case "freshness":
+		var latest interface{}
+		err = h.db.QueryRowContext(ctx, fmt.Sprintf("SELECT MAX(%s) FROM %s", q(rule.Column), table)).Scan(&latest)
+		if err == nil {
+			ts, ok := parseTimestamp(latest)
+			switch {
+			case !ok:
+				r.Message = "table has no parseable timestamps"
+			case time.Since(ts) > rule.MaxAge:
+				r.Observed = ts.Format(time.RFC3339)
+				r.Message = fmt.Sprintf("latest data is %s old (max %s)", time.Since(ts).Round(time.Second), rule.MaxAge)
+			default:
+				r.Observed = ts.Format(time.RFC3339)
+			}
+		}
+
+	case "accepted_values":
+		placeholders := make([]string, len(rule.Values))
+		args := make([]interface{}, len(rule.Values))
+		for i, v := range rule.Values {
+			placeholders[i] = h.dialect.Placeholder(i + 1)
+			args[i] = v
+		}
+		var invalid int64
+		err = h.db.QueryRowContext(ctx, fmt.Sprintf(
+			"SELECT COUNT(*) FROM %s WHERE %s IS NOT NULL AND CAST(%s AS TEXT) NOT IN (%s)",
+			table, q(rule.Column), q(rule.Column), strings.Join(placeholders, ", ")), args...).Scan(&invalid)
+		r.Observed = fmt.Sprintf("%d unexpected values", invalid)
+		if err == nil && invalid > 0 {
+			r.Message = fmt.Sprintf("%d rows have %s outside the accepted values", invalid, rule.Column)
+		}
+	}
+
+	switch {
+	case err != nil:
+		r.Status = "error"
+		r.Message = err.Error()
+	case r.Message != "":
+		r.Status = "fail"
+	default:
+		r.Status = "pass"
+	}
+	return r
+}
+
+// parseTimestamp interprets driver timestamp values, including the text
+// timestamps SQLite returns
+func parseTimestamp(v interface{}) (time.Time, bool) {
+	var s string
+	switch v := v.(type) {
+	case time.Time:
+		return v, true
+	case []byte:
+		s = string(v)
+	case string:
+		s = v
+	default:
+		return time.Time{}, false
+	}
+
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999-07:00", "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
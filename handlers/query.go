@@ -1,15 +1,77 @@
 package handlers
 
 import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
+
+	"sql-engine/rbac"
 
 	sqlparser "github.com/blastrain/vitess-sqlparser/sqlparser"
 	"github.com/gin-gonic/gin"
 )
 
+// QueryRequest runs SQL, or pages through its results when PageSize or
+// Cursor is set. A cursor carries its query, so SQL may be omitted when
+// passing one.
 type QueryRequest struct {
-	SQL string `json:"sql"`
+	SQL      string `json:"sql"`
+	PageSize int    `json:"page_size"`
+	Cursor   string `json:"cursor"`
+	ResultFormat
+}
+
+// QueryResult is the output of a validated and executed query
+type QueryResult struct {
+	Columns []string                 `json:"columns"`
+	Rows    []map[string]interface{} `json:"rows"`
+	// NextCursor fetches the following page of a paginated query
+	NextCursor string `json:"next_cursor,omitempty"`
+	// SuppressedGroups counts the groups of an aggregate-only table left
+	// out for having fewer rows than aggregate_only.min_group_size
+	SuppressedGroups int `json:"suppressed_groups,omitempty"`
+}
+
+// QueryError is a query failure with the HTTP status it should be reported
+// with and optional extra response fields
+type QueryError struct {
+	Status  int
+	Message string
+	Details gin.H
+	// RetryAfter, when set, is sent as the Retry-After header
+	RetryAfter time.Duration
+	// Err is the database error behind the failure, if any
+	Err error
+}
+
+func (e *QueryError) Error() string {
+	return e.Message
+}
+
+func (e *QueryError) Unwrap() error {
+	return e.Err
+}
+
+// respondQueryError writes err as a JSON error response
+func respondQueryError(c *gin.Context, err error) {
+	qe, ok := err.(*QueryError)
+	if !ok {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	body := gin.H{"error": qe.Message}
+	for k, v := range qe.Details {
+		body[k] = v
+	}
+	if qe.RetryAfter > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(qe.RetryAfter.Seconds())))
+	}
+	c.JSON(qe.Status, body)
 }
 
 func (h *Handler) RunQuery(c *gin.Context) {
@@ -20,47 +82,331 @@ func (h *Handler) RunQuery(c *gin.Context) {
 		return
 	}
 
-	sqlText := strings.TrimSpace(req.SQL)
-	if sqlText == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "SQL cannot be empty"})
+	format, ok := h.resultFormat(c, req.ResultFormat)
+	if !ok {
 		return
 	}
+	ctx := withResultFormat(c.Request.Context(), format)
 
-	// Parse SQL syntax
-	stmt, err := sqlparser.Parse(sqlText)
+	if acceptsNDJSON(c) {
+		if req.Cursor != "" || req.PageSize > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Streamed results can't be paginated"})
+			return
+		}
+		h.streamQueryRows(c, ctx, req.SQL)
+		return
+	}
+
+	sqlText := req.SQL
+	var page *queryPage
+	if req.Cursor != "" || req.PageSize > 0 {
+		page = &queryPage{PageSize: req.PageSize}
+		if req.Cursor != "" {
+			cur, err := h.decodeCursor(req.Cursor)
+			if _, ok := err.(*QueryError); ok {
+				respondQueryError(c, err)
+				return
+			}
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Bad cursor: " + err.Error()})
+				return
+			}
+			page.Hash, page.Offset = cur.Hash, cur.Offset
+			if page.PageSize <= 0 {
+				page.PageSize = cur.PageSize
+			}
+			if sqlText == "" {
+				sqlText = cur.SQL
+			}
+		}
+	}
+
+	result, err := h.executeQuery(ctx, sqlText, page)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "SQL syntax error: " + err.Error()})
+		respondQueryError(c, err)
 		return
 	}
 
+	resp := gin.H{
+		"columns": result.Columns,
+		"rows":    result.Rows,
+	}
+	if result.NextCursor != "" {
+		resp["next_cursor"] = result.NextCursor
+	}
+	if result.SuppressedGroups > 0 {
+		resp["suppressed_groups"] = result.SuppressedGroups
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// executeQuery validates user SQL, applies the safety rewrites and runs it.
+// With a page, only that page is read and the result carries the cursor of
+// the next one. args are the values of saved query variables, bound in
+// place of their markers.
+func (h *Handler) executeQuery(ctx context.Context, sqlText string, page *queryPage, args ...any) (*QueryResult, error) {
+	return h.runSelect(ctx, sqlText, page, nil, args...)
+}
+
+// runSelect implements executeQuery and streamQuery: with a stream, the
+// rows are passed to it as they are read and left out of the result
+func (h *Handler) runSelect(ctx context.Context, sqlText string, page *queryPage, stream *rowStream, args ...any) (*QueryResult, error) {
+	prep, err := h.prepareSelect(ctx, sqlText)
+	if err != nil {
+		return nil, err
+	}
+	sqlText, stmt, plan, hash := prep.SQL, prep.Stmt, prep.Mask, prep.Hash
+	if len(args) > 0 {
+		sqlText = h.bindPlaceholders(sqlText, len(args))
+	}
+
+	// Add LIMIT to protect DB
+	originalSQL := sqlText
+	if prep.Aggregate != nil {
+		sqlText = prep.Aggregate.countGroups(sqlText)
+	}
+	if page != nil {
+		if page.Hash != "" && page.Hash != hash {
+			return nil, &QueryError{Status: http.StatusBadRequest, Message: "Cursor belongs to a different query"}
+		}
+		if len(stmt.(*sqlparser.Select).OrderBy) == 0 {
+			return nil, &QueryError{Status: http.StatusBadRequest, Message: "Paginated queries need an ORDER BY so pages are stable"}
+		}
+		if page.PageSize <= 0 || page.PageSize > h.maxRows(ctx) {
+			page.PageSize = h.maxRows(ctx)
+		}
+		// Read one extra row to learn whether another page follows
+		sqlText = fmt.Sprintf("SELECT * FROM (%s) AS page %s OFFSET %d",
+			strings.TrimRight(sqlText, "; \t\n"), h.dialect.LimitClause(page.PageSize+1), page.Offset)
+	} else if !strings.Contains(strings.ToUpper(sqlText), "LIMIT") {
+		sqlText += " " + h.dialect.LimitClause(h.maxRows(ctx))
+	}
+
+	if timeout := h.queryTimeout(ctx); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	release, err := h.acquireStatement(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	run := h.lineage.StartRun(lineageJob(ctx, hash), originalSQL, h.lineageInputs(stmt), nil)
+
+	// Execute query in a read-only transaction
+	start := time.Now()
+	rows, end, err := h.beginRead(ctx, sqlText, args...)
+	if err != nil {
+		run.Finish(err)
+		h.recordQuery(ctx, prep.Normalized, hash, time.Since(start), 0, true)
+		return nil, err
+	}
+	defer end()
+	defer rows.Close()
+	format := resultFormatFrom(ctx)
+	var types map[string]string
+	if format != nil {
+		types = columnTypes(rows)
+	}
+
+	if stream != nil && prep.Aggregate == nil {
+		return h.streamRows(rows, plan, format, types, stream, func(n int, err error) {
+			run.Finish(err)
+			meterStatement(ctx, time.Since(start), n)
+			h.recordQuery(ctx, prep.Normalized, hash, time.Since(start), n, err != nil)
+		})
+	}
+
+	cols, result, err := scanRowMaps(rows)
+	run.Finish(err)
+	meterStatement(ctx, time.Since(start), len(result))
+	h.recordQuery(ctx, prep.Normalized, hash, time.Since(start), len(result), err != nil)
+	if err != nil {
+		return nil, &QueryError{Status: http.StatusInternalServerError, Message: err.Error()}
+	}
+	suppressed := 0
+	if prep.Aggregate != nil {
+		cols, result, suppressed = prep.Aggregate.suppressSmallGroups(cols, result)
+	}
+
+	if stream != nil {
+		result = format.apply(types, h.applyMasking(plan, cols, result))
+		if err := stream.Header(cols, suppressed); err != nil {
+			return nil, err
+		}
+		if err := stream.Rows(result); err != nil {
+			return nil, err
+		}
+		return &QueryResult{Columns: cols, SuppressedGroups: suppressed}, nil
+	}
+
+	var next string
+	if page != nil {
+		if len(result) > page.PageSize {
+			result = result[:page.PageSize]
+			next, err = h.encodeCursor(queryCursor{
+				SQL:      originalSQL,
+				Hash:     hash,
+				Offset:   page.Offset + page.PageSize,
+				PageSize: page.PageSize,
+				IssuedAt: time.Now().Unix(),
+			})
+			if err != nil {
+				if _, ok := err.(*QueryError); ok {
+					return nil, err
+				}
+				return nil, &QueryError{Status: http.StatusInternalServerError, Message: "Failed to issue cursor: " + err.Error()}
+			}
+		}
+	} else if sqlText != originalSQL && len(args) == 0 && prep.Aggregate == nil && sessionFrom(ctx) == nil && h.shouldCanary() {
+		go h.runCanary(context.WithoutCancel(ctx), originalSQL, sqlText, result, time.Since(start))
+	}
+
+	result = format.apply(types, h.applyMasking(plan, cols, result))
+	return &QueryResult{Columns: cols, Rows: result, NextCursor: next, SuppressedGroups: suppressed}, nil
+}
+
+// preparedSelect is user SQL that passed validation
+type preparedSelect struct {
+	SQL  string
+	Stmt sqlparser.Statement
+	Mask *maskPlan
+	// Aggregate is set when the statement reads an aggregate-only table
+	Aggregate *aggregatePlan
+	// Normalized is the statement with its literals replaced, and Hash the
+	// fingerprint hash of it
+	Normalized string
+	Hash       string
+}
+
+// prepareSelect parses user SQL and checks it is a SELECT the caller may run:
+// no denylisted constructs, only granted tables and columns, aggregate-only
+// tables only through aggregates, and not a blocked fingerprint
+func (h *Handler) prepareSelect(ctx context.Context, sqlText string) (*preparedSelect, error) {
+	sqlText = strings.TrimSpace(sqlText)
+	if sqlText == "" {
+		return nil, &QueryError{Status: http.StatusBadRequest, Message: "SQL cannot be empty"}
+	}
+	sqlText, err := h.expandSQLVariables(sqlText)
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse SQL syntax
+	stmt, err := h.dialect.ParseStatement(sqlText)
+	if err != nil {
+		return nil, &QueryError{Status: http.StatusBadRequest, Message: "SQL syntax error: " + err.Error()}
+	}
+
 	// Only allow SELECT
 	switch stmt.(type) {
 	case *sqlparser.Select:
 	default:
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Only SELECT statements are allowed"})
-		return
+		return nil, &QueryError{Status: http.StatusBadRequest, Message: "Only SELECT statements are allowed"}
 	}
 
-	// Add LIMIT to protect DB
-	if !strings.Contains(strings.ToUpper(sqlText), "LIMIT") {
-		sqlText += " LIMIT 100"
+	// Reject banned functions, schemas and locking reads
+	if err := h.checkDenylist(stmt); err != nil {
+		return nil, err
 	}
 
-	// Execute query
-	rows, err := h.db.Query(sqlText)
+	// Enforce table and column grants
+	if err := authorizeStatement(rbac.FromContext(ctx), stmt); err != nil {
+		return nil, err
+	}
+	plan, err := h.planMasking(ctx, stmt)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Execution failed: " + err.Error()})
-		return
+		return nil, err
+	}
+	aggregate, err := h.planAggregateOnly(ctx, sqlText, stmt)
+	if err != nil {
+		return nil, err
 	}
-	defer rows.Close()
 
+	// Reject blocked query fingerprints
+	normalized, hash := fingerprint(stmt)
+	blocked, err := h.blockedQuery(hash)
+	if err != nil {
+		if h.checkStore() {
+			return nil, &QueryError{Status: http.StatusInternalServerError, Message: "Blocklist lookup failed: " + err.Error()}
+		}
+		// The blocklist is skipped rather than failing every query while
+		// the store is down; responses carry X-Degraded meanwhile
+		slog.ErrorContext(ctx, "Skipping blocklist check, metadata store unavailable", "error", err)
+	}
+	if blocked != nil {
+		return nil, &QueryError{
+			Status:  http.StatusForbidden,
+			Message: blocked.Message,
+			Details: gin.H{
+				"fingerprint": blocked.Fingerprint,
+				"alternative": blocked.Alternative,
+			},
+		}
+	}
+
+	return &preparedSelect{SQL: sqlText, Stmt: stmt, Mask: plan, Aggregate: aggregate, Normalized: normalized, Hash: hash}, nil
+}
+
+// acquireStatement waits up to the queue timeout for a global statement slot
+// and returns the function releasing it. Low-priority requests wait for a
+// slot of their pool instead, so they never hold those of the main pool.
+func (h *Handler) acquireStatement(ctx context.Context) (func(), error) {
+	stmts := h.stmts
+	if h.lowDB != nil && isLowPriority(ctx) {
+		stmts = h.lowStmts
+	}
+	if stmts == nil {
+		return func() {}, nil
+	}
+	release := func() { <-stmts }
+	select {
+	case stmts <- struct{}{}:
+		return release, nil
+	default:
+	}
+
+	timer := time.NewTimer(h.cfg.Limits.QueueTimeout)
+	defer timer.Stop()
+	select {
+	case stmts <- struct{}{}:
+		return release, nil
+	case <-timer.C:
+		return nil, &QueryError{
+			Status:     http.StatusTooManyRequests,
+			Message:    "Database is busy, try again shortly",
+			RetryAfter: time.Second,
+		}
+	case <-ctx.Done():
+		return nil, &QueryError{Status: http.StatusServiceUnavailable, Message: "Query cancelled while waiting: " + ctx.Err().Error()}
+	}
+}
+
+// scanRowMaps reads every remaining row into a column name → value map
+func scanRowMaps(rows *sql.Rows) ([]string, []map[string]interface{}, error) {
 	// Get column names
 	cols, err := rows.Columns()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get columns: " + err.Error()})
-		return
+		return nil, nil, fmt.Errorf("Failed to get columns: %w", err)
+	}
+
+	var result []map[string]interface{}
+	err = scanRowBatches(rows, cols, 0, func(batch []map[string]interface{}) error {
+		result = batch
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
 	}
+	return cols, result, nil
+}
 
+// scanRowBatches reads the remaining rows into column name → value maps and
+// passes them to emit size at a time, or all at once when size is 0
+func scanRowBatches(rows *sql.Rows, cols []string, size int, emit func([]map[string]interface{}) error) error {
 	// Process rows
 	result := []map[string]interface{}{}
 	for rows.Next() {
@@ -72,8 +418,7 @@ func (h *Handler) RunQuery(c *gin.Context) {
 		}
 
 		if err := rows.Scan(ptrs...); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Row scan failed: " + err.Error()})
-			return
+			return fmt.Errorf("Row scan failed: %w", err)
 		}
 
 		rowMap := map[string]interface{}{}
@@ -81,15 +426,20 @@ func (h *Handler) RunQuery(c *gin.Context) {
 			rowMap[col] = vals[i]
 		}
 		result = append(result, rowMap)
+		if size > 0 && len(result) == size {
+			if err := emit(result); err != nil {
+				return err
+			}
+			result = []map[string]interface{}{}
+		}
 	}
 
 	if err := rows.Err(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Row iteration error: " + err.Error()})
-		return
+		return fmt.Errorf("Row iteration error: %w", err)
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"columns": cols,
-		"rows":    result,
-	})
+	if size > 0 && len(result) == 0 {
+		return nil
+	}
+	return emit(result)
 }
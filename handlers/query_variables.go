@@ -0,0 +1,237 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// queryVariableTypes are the types a saved query variable may declare
+var queryVariableTypes = []string{"string", "integer", "number", "boolean", "date", "timestamp"}
+
+// queryVariableMarker stands in for a bound variable while the query is
+// validated; the parser reads it as a bind variable
+const queryVariableMarker = ":__var"
+
+// QueryVariable is a typed {{name}} placeholder of a saved query. Values
+// are bound as parameters, never spliced into the SQL. A variable without a
+// default must be supplied on every run. Placeholders the query doesn't
+// declare refer to workspace SQL variables.
+type QueryVariable struct {
+	Name string `json:"name"`
+	// Type is string, integer, number, boolean, date (YYYY-MM-DD) or
+	// timestamp (RFC 3339)
+	Type        string `json:"type"`
+	Default     any    `json:"default,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// convert checks a supplied value against the variable's type and returns
+// it as bound. Numbers, booleans and dates may also be sent as strings.
+func (v QueryVariable) convert(value any) (any, error) {
+	s, isString := value.(string)
+	switch v.Type {
+	case "string":
+		if !isString {
+			return nil, errors.New("must be a string")
+		}
+		return s, nil
+	case "integer":
+		switch n := value.(type) {
+		case float64:
+			if n == math.Trunc(n) && math.Abs(n) < 1<<53 {
+				return int64(n), nil
+			}
+		case string:
+			if i, err := strconv.ParseInt(strings.TrimSpace(n), 10, 64); err == nil {
+				return i, nil
+			}
+		}
+		return nil, errors.New("must be an integer")
+	case "number":
+		switch n := value.(type) {
+		case float64:
+			return n, nil
+		case string:
+			if f, err := strconv.ParseFloat(strings.TrimSpace(n), 64); err == nil && !math.IsInf(f, 0) && !math.IsNaN(f) {
+				return f, nil
+			}
+		}
+		return nil, errors.New("must be a number")
+	case "boolean":
+		switch b := value.(type) {
+		case bool:
+			return b, nil
+		case string:
+			if parsed, err := strconv.ParseBool(strings.TrimSpace(b)); err == nil {
+				return parsed, nil
+			}
+		}
+		return nil, errors.New("must be true or false")
+	case "date":
+		if isString {
+			if d, err := time.Parse(time.DateOnly, strings.TrimSpace(s)); err == nil {
+				return d.Format(time.DateOnly), nil
+			}
+		}
+		return nil, errors.New("must be a date (YYYY-MM-DD)")
+	case "timestamp":
+		if isString {
+			if t, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(s)); err == nil {
+				return t, nil
+			}
+		}
+		return nil, errors.New("must be an RFC 3339 timestamp")
+	}
+	return nil, fmt.Errorf("has unknown type %s", v.Type)
+}
+
+// validateQueryVariables checks the declared variables of a saved query:
+// valid unique names, known types, defaults of the right type, and each
+// referred to by the SQL
+func validateQueryVariables(vars []QueryVariable, sqlText string) error {
+	used := map[string]bool{}
+	for _, m := range sqlVariableReference.FindAllStringSubmatch(sqlText, -1) {
+		used[m[1]] = true
+	}
+	seen := map[string]bool{}
+	for i, v := range vars {
+		if !sqlVariableName.MatchString(v.Name) {
+			return fmt.Errorf("variables[%d]: name must be lowercase letters, digits and underscores", i)
+		}
+		if seen[v.Name] {
+			return fmt.Errorf("variables[%d]: %s is declared twice", i, v.Name)
+		}
+		seen[v.Name] = true
+		if !slices.Contains(queryVariableTypes, v.Type) {
+			return fmt.Errorf("variables[%d]: type must be one of %s", i, strings.Join(queryVariableTypes, ", "))
+		}
+		if v.Default != nil {
+			if _, err := v.convert(v.Default); err != nil {
+				return fmt.Errorf("variables[%d]: default %s", i, err)
+			}
+		}
+		if !used[v.Name] {
+			return fmt.Errorf("variables[%d]: sql does not refer to {{%s}}", i, v.Name)
+		}
+	}
+	return nil
+}
+
+// bindQueryVariables replaces the declared {{name}} placeholders of q with
+// bind markers and returns the values to bind, in order. Values not
+// supplied fall back to the defaults; missing, mistyped and undeclared
+// values are rejected together.
+func bindQueryVariables(q SavedQuery, values map[string]any) (string, []any, error) {
+	problems := map[string]string{}
+	for name := range values {
+		if !slices.ContainsFunc(q.Variables, func(v QueryVariable) bool { return v.Name == name }) {
+			problems[name] = "is not a variable of this query"
+		}
+	}
+	bound := map[string]any{}
+	for _, v := range q.Variables {
+		value, ok := values[v.Name]
+		if !ok || value == nil {
+			if v.Default == nil {
+				problems[v.Name] = "is required"
+				continue
+			}
+			value = v.Default
+		}
+		converted, err := v.convert(value)
+		if err != nil {
+			problems[v.Name] = err.Error()
+			continue
+		}
+		bound[v.Name] = converted
+	}
+	if len(problems) > 0 {
+		names := make([]string, 0, len(problems))
+		for name := range problems {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		return "", nil, &QueryError{
+			Status:  http.StatusBadRequest,
+			Message: "Invalid variables: " + strings.Join(names, ", "),
+			Details: gin.H{"variables": problems},
+		}
+	}
+
+	// Each occurrence gets a parameter of its own, as not every driver can
+	// bind one parameter twice
+	var args []any
+	sqlText := sqlVariableReference.ReplaceAllStringFunc(q.SQL, func(m string) string {
+		value, ok := bound[sqlVariableReference.FindStringSubmatch(m)[1]]
+		if !ok {
+			return m
+		}
+		args = append(args, value)
+		return queryVariableMarker + strconv.Itoa(len(args))
+	})
+	return sqlText, args, nil
+}
+
+// bindPlaceholders turns the bind markers of n variables into the
+// dialect's parameter placeholders
+func (h *Handler) bindPlaceholders(sqlText string, n int) string {
+	// Highest first, so that :__var1 doesn't match the start of :__var10
+	for i := n; i > 0; i-- {
+		sqlText = strings.ReplaceAll(sqlText, queryVariableMarker+strconv.Itoa(i), h.dialect.Placeholder(i))
+	}
+	return sqlText
+}
+
+// SavedQueryExecuteRequest holds the variable values of a saved query run
+type SavedQueryExecuteRequest struct {
+	Variables map[string]any `json:"variables"`
+}
+
+// ExecuteSavedQuery runs a saved query with the variable values in the
+// body, bound as parameters
+func (h *Handler) ExecuteSavedQuery(c *gin.Context) {
+	format, ok := h.queryResultFormat(c)
+	if !ok {
+		return
+	}
+	var req SavedQueryExecuteRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		return
+	}
+	q, ok := h.loadSavedQuery(c)
+	if !ok {
+		return
+	}
+	sqlText, args, err := bindQueryVariables(q, req.Variables)
+	if err == nil {
+		err = h.checkSavedQuerySchema(c.Request.Context(), q)
+	}
+	if err != nil {
+		respondQueryError(c, err)
+		return
+	}
+
+	ctx := withResultFormat(withLineageJob(c.Request.Context(), "saved-query."+q.ID), format)
+	result, err := h.executeQuery(ctx, sqlText, nil, args...)
+	if err != nil {
+		respondQueryError(c, err)
+		return
+	}
+	resp := gin.H{
+		"columns": result.Columns,
+		"rows":    result.Rows,
+	}
+	if prefs := h.viewPreferences(c, savedQueryView(q.ID)); prefs != nil {
+		resp["view_preferences"] = prefs
+	}
+	c.JSON(http.StatusOK, resp)
+}
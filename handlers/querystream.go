@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+
+	"sql-engine/cache"
+
+	"github.com/gin-gonic/gin"
+)
+
+// mimeNDJSON is newline-delimited JSON, the format POST /run-query streams
+// rows in
+const mimeNDJSON = "application/x-ndjson"
+
+// streamBatchRows is how many rows of a streamed query are read, masked and
+// sent at a time
+const streamBatchRows = 500
+
+// rowStream receives a query's result as it is read: the columns first,
+// then the rows in batches
+type rowStream struct {
+	Header func(cols []string, suppressed int) error
+	Rows   func(rows []map[string]interface{}) error
+}
+
+// streamQuery runs user SQL like executeQuery, passing the rows to stream
+// as they are read instead of collecting them. Results of aggregate-only
+// tables are read in full first, since small groups are only known at the
+// end.
+func (h *Handler) streamQuery(ctx context.Context, sqlText string, stream rowStream) error {
+	_, err := h.runSelect(ctx, sqlText, nil, &stream)
+	return err
+}
+
+// streamRows masks and formats the rows of a running query and passes them
+// to stream a batch at a time, then calls done with the number read
+func (h *Handler) streamRows(rows *sql.Rows, plan *maskPlan, format *resultFormat, types map[string]string, stream *rowStream, done func(int, error)) (*QueryResult, error) {
+	cols, err := rows.Columns()
+	if err == nil {
+		err = stream.Header(cols, 0)
+	}
+	n := 0
+	if err == nil {
+		err = scanRowBatches(rows, cols, streamBatchRows, func(batch []map[string]interface{}) error {
+			n += len(batch)
+			return stream.Rows(format.apply(types, h.applyMasking(plan, cols, batch)))
+		})
+	}
+	done(n, err)
+	if err != nil {
+		if _, ok := err.(*QueryError); ok {
+			return nil, err
+		}
+		return nil, &QueryError{Status: http.StatusInternalServerError, Message: err.Error(), Err: err}
+	}
+	return &QueryResult{Columns: cols}, nil
+}
+
+// acceptsNDJSON reports whether the client asked for rows as a stream
+func acceptsNDJSON(c *gin.Context) bool {
+	return c.GetHeader("Accept") != "" && c.NegotiateFormat(mimeJSON, mimeNDJSON) == mimeNDJSON
+}
+
+// streamQueryRows answers POST /run-query in NDJSON: {"columns": [...]}
+// first, then each row as an array of its values in column order. An error
+// once the stream started ends it with {"error": "..."}.
+func (h *Handler) streamQueryRows(c *gin.Context, ctx context.Context, sqlText string) {
+	enc := json.NewEncoder(c.Writer)
+	var cols []string
+	started := false
+	err := h.streamQuery(ctx, sqlText, rowStream{
+		Header: func(columns []string, suppressed int) error {
+			cols, started = columns, true
+			cache.SetClass(c, cache.Streamed)
+			c.Header("Content-Type", mimeNDJSON)
+			c.Status(http.StatusOK)
+			header := gin.H{"columns": cols}
+			if suppressed > 0 {
+				header["suppressed_groups"] = suppressed
+			}
+			if err := enc.Encode(header); err != nil {
+				return err
+			}
+			c.Writer.Flush()
+			return nil
+		},
+		Rows: func(rows []map[string]interface{}) error {
+			values := make([]interface{}, len(cols))
+			for _, row := range rows {
+				for i, col := range cols {
+					values[i] = row[col]
+				}
+				if err := enc.Encode(values); err != nil {
+					return err
+				}
+			}
+			c.Writer.Flush()
+			return nil
+		},
+	})
+	switch {
+	case err == nil:
+	case started:
+		enc.Encode(gin.H{"error": err.Error()})
+	default:
+		respondQueryError(c, err)
+	}
+}
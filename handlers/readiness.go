@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"maps"
+	"net/http"
+	"time"
+
+	"sql-engine/cache"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ComponentStatus is the state of one dependency checked by /readyz.
+// Optional components are reported without affecting readiness.
+type ComponentStatus struct {
+	Status    string   `json:"status"`
+	LatencyMS *float64 `json:"latency_ms,omitempty"`
+	Error     string   `json:"error,omitempty"`
+	Optional  bool     `json:"optional,omitempty"`
+	// Details holds component-specific figures, such as pool usage
+	Details gin.H `json:"details,omitempty"`
+}
+
+// GetHealthz answers as long as the process serves requests, for liveness
+// probes. It checks no dependency, so that an unavailable database doesn't
+// get the instance restarted.
+func (h *Handler) GetHealthz(c *gin.Context) {
+	cache.SetClass(c, cache.NoStore)
+	c.JSON(http.StatusOK, gin.H{"status": "alive"})
+}
+
+// GetReadyz checks what the instance needs to serve queries: a database
+// answering a ping within server.readiness.timeout, a free pool
+// connection and, on a replica, replication lag within
+// server.readiness.max_replication_lag. It answers 503 when one fails, so
+// that load balancers and readiness probes route elsewhere. Read replicas
+// are reported as of their last check.
+func (h *Handler) GetReadyz(c *gin.Context) {
+	cache.SetClass(c, cache.NoStore)
+	cfg := h.cfg.Server.Readiness
+	ctx, cancel := context.WithTimeout(c.Request.Context(), cfg.Timeout)
+	defer cancel()
+
+	components := map[string]ComponentStatus{
+		"database": pingStatus(ctx, h.db),
+		"pool":     poolStatus(h.db),
+	}
+	if h.lowDB != nil {
+		components["database_low_priority"] = pingStatus(ctx, h.lowDB)
+		components["pool_low_priority"] = poolStatus(h.lowDB)
+	}
+	// Queries fall back to the primary, so replicas don't affect readiness
+	maps.Copy(components, h.replicaStatuses())
+	if cfg.MaxReplicationLag > 0 {
+		if status, ok := h.replicationStatus(ctx, cfg.MaxReplicationLag); ok {
+			components["replication"] = status
+		}
+	}
+	// Features keeping state are disabled while the store is down, but
+	// queries are still served
+	store := ComponentStatus{Status: "ok", Optional: true}
+	if err := h.storeDown(); err != nil {
+		store.Status, store.Error = "down", err.Error()
+	}
+	components["metadata_store"] = store
+
+	status, code := "ready", http.StatusOK
+	for _, component := range components {
+		if component.Status != "ok" && !component.Optional {
+			status, code = "not_ready", http.StatusServiceUnavailable
+		}
+	}
+	c.JSON(code, gin.H{
+		"status":     status,
+		"replica":    h.cfg.Server.ReplicaID,
+		"components": components,
+	})
+}
+
+func pingStatus(ctx context.Context, db *sql.DB) ComponentStatus {
+	start := time.Now()
+	err := db.PingContext(ctx)
+	latency := float64(time.Since(start).Microseconds()) / 1000
+	if err != nil {
+		return ComponentStatus{Status: "down", LatencyMS: &latency, Error: err.Error()}
+	}
+	return ComponentStatus{Status: "ok", LatencyMS: &latency}
+}
+
+// poolStatus fails when every connection the pool may open is in use, so
+// that a saturated instance gets no new traffic until some are returned
+func poolStatus(db *sql.DB) ComponentStatus {
+	s := db.Stats()
+	status := ComponentStatus{Status: "ok", Details: gin.H{
+		"in_use":     s.InUse,
+		"idle":       s.Idle,
+		"max_open":   s.MaxOpenConnections,
+		"wait_count": s.WaitCount,
+	}}
+	if s.MaxOpenConnections > 0 && s.InUse >= s.MaxOpenConnections {
+		status.Status, status.Error = "saturated", "no free connection"
+	}
+	return status
+}
+
+// replicationStatus checks the lag of a database replica. It reports
+// nothing for engines without replication.
+func (h *Handler) replicationStatus(ctx context.Context, maxLag time.Duration) (ComponentStatus, bool) {
+	replica, lag, err := h.dialect.ReplicationLag(ctx, h.db)
+	switch {
+	case errors.Is(err, errors.ErrUnsupported):
+		return ComponentStatus{}, false
+	case err != nil:
+		return ComponentStatus{Status: "down", Error: err.Error()}, true
+	}
+	status := ComponentStatus{Status: "ok", Details: gin.H{"replica": replica}}
+	if !replica {
+		return status, true
+	}
+	status.Details["lag_seconds"] = lag.Seconds()
+	if lag > maxLag {
+		status.Status, status.Error = "lagging", "replay is "+lag.Round(time.Second).String()+" behind the primary"
+	}
+	return status, true
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/goccy/go-yaml"
+)
+
+// Media types schema endpoints can answer in. JSON is the default.
+const (
+	mimeJSON = "application/json"
+	mimeYAML = "application/yaml"
+	mimeSQL  = "application/sql"
+)
+
+// mediaAliases are other names clients use for the same formats
+var mediaAliases = map[string]string{
+	"application/x-yaml": mimeYAML,
+	"text/yaml":          mimeYAML,
+	"text/x-yaml":        mimeYAML,
+	"text/x-sql":         mimeSQL,
+}
+
+// render answers 200 with body in the format negotiated from the Accept
+// header: JSON, YAML or, for endpoints passing ddl, the CREATE statements
+// it returns. Clients accepting none of them get 406.
+func (h *Handler) render(c *gin.Context, body any, ddl func() string) {
+	offers := []string{mimeJSON, mimeYAML}
+	if ddl != nil {
+		offers = append(offers, mimeSQL)
+	}
+	format := mimeJSON
+	if c.GetHeader("Accept") != "" {
+		all := slices.Clone(offers)
+		for alias, mime := range mediaAliases {
+			if slices.Contains(offers, mime) {
+				all = append(all, alias)
+			}
+		}
+		format = c.NegotiateFormat(all...)
+		if mime, ok := mediaAliases[format]; ok {
+			format = mime
+		}
+	}
+
+	switch format {
+	case mimeYAML:
+		b, err := json.Marshal(body)
+		if err == nil {
+			b, err = yaml.JSONToYAML(b)
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.Data(http.StatusOK, mimeYAML+"; charset=utf-8", b)
+	case mimeSQL:
+		c.Data(http.StatusOK, mimeSQL+"; charset=utf-8", []byte(ddl()))
+	case mimeJSON:
+		c.JSON(http.StatusOK, body)
+	default:
+		c.JSON(http.StatusNotAcceptable, gin.H{
+			"error":     "None of the accepted formats is available",
+			"available": offers,
+		})
+	}
+}
+
+// schemaDDL returns the CREATE TABLE statements of tables, referenced
+// tables first where foreign keys allow
+func (h *Handler) schemaDDL(tables []TableSchema) string {
+	key := func(schema, name string) string { return strings.ToLower(schema + "." + name) }
+	byKey := map[string]TableSchema{}
+	for _, t := range tables {
+		byKey[key(t.Schema, t.Name)] = t
+	}
+
+	var stmts []string
+	done := map[string]bool{}
+	var add func(t TableSchema)
+	add = func(t TableSchema) {
+		k := key(t.Schema, t.Name)
+		if done[k] {
+			return
+		}
+		// Marked before its references, so that cycles end
+		done[k] = true
+		for _, fk := range t.ForeignKeys {
+			schema := fk.ForeignSchema
+			if schema == "" {
+				schema = t.Schema
+			}
+			if ref, ok := byKey[key(schema, fk.ForeignTable)]; ok {
+				add(ref)
+			}
+		}
+		stmts = append(stmts, h.createTableStatement(t.Schema, t))
+	}
+	for _, t := range tables {
+		add(t)
+	}
+	return strings.Join(stmts, "\n\n") + "\n"
+}
@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// readReplica is the pool of a read replica and the outcome of its last
+// health check
+type readReplica struct {
+	name string
+	db   *sql.DB
+	// healthy is set while the replica answers and lags at most
+	// database.replicas.max_lag
+	healthy atomic.Bool
+
+	mu     sync.Mutex
+	status ComponentStatus
+}
+
+// replicaSet routes read-only queries to the healthy replicas
+type replicaSet struct {
+	replicas []*readReplica
+	// next is the round-robin position
+	next atomic.Uint64
+}
+
+// UseReplicas sets the pools of the read replicas. They get no queries
+// until StartReplicaChecks finds them healthy.
+func (h *Handler) UseReplicas(dbs []*sql.DB) {
+	for i, db := range dbs {
+		r := &readReplica{name: fmt.Sprintf("replica_%d", i+1), db: db}
+		r.status = ComponentStatus{Status: "unchecked", Optional: true}
+		h.replicas.replicas = append(h.replicas.replicas, r)
+	}
+}
+
+// StartReplicaChecks checks the replicas now and then every
+// database.replicas.check_interval. Like the store monitor, it runs on
+// every instance.
+func (h *Handler) StartReplicaChecks() {
+	if len(h.replicas.replicas) == 0 {
+		return
+	}
+	h.checkReplicas()
+	go func() {
+		ticker := time.NewTicker(h.cfg.Database.Replicas.CheckInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			h.checkReplicas()
+		}
+	}()
+}
+
+func (h *Handler) checkReplicas() {
+	var wg sync.WaitGroup
+	for _, r := range h.replicas.replicas {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.checkReplica(r)
+		}()
+	}
+	wg.Wait()
+}
+
+// checkReplica pings a replica and measures its lag, taking it out of the
+// rotation while either fails and logging when that changes
+func (h *Handler) checkReplica(r *readReplica) {
+	cfg := h.cfg.Database.Replicas
+	ctx, cancel := context.WithTimeout(context.Background(), min(cfg.CheckInterval, h.cfg.Server.Readiness.Timeout))
+	defer cancel()
+
+	status := pingStatus(ctx, r.db)
+	status.Optional = true
+	status.Details = gin.H{"in_use": r.db.Stats().InUse, "checked_at": time.Now().UTC()}
+	if status.Status == "ok" {
+		_, lag, err := h.dialect.ReplicationLag(ctx, r.db)
+		switch {
+		case errors.Is(err, errors.ErrUnsupported):
+		case err != nil:
+			status.Status, status.Error = "down", err.Error()
+		default:
+			status.Details["lag_seconds"] = lag.Seconds()
+			if cfg.MaxLag > 0 && lag > cfg.MaxLag {
+				status.Status, status.Error = "lagging", "replay is "+lag.Round(time.Second).String()+" behind the primary"
+			}
+		}
+	}
+	h.status.ring("database_"+r.name, false).record(status.Status == "ok", time.Duration(*status.LatencyMS*float64(time.Millisecond)))
+
+	healthy := status.Status == "ok"
+	if was := r.healthy.Swap(healthy); was != healthy {
+		if healthy {
+			slog.Info("Replica healthy, routing queries to it", "replica", r.name)
+		} else {
+			slog.Warn("Replica unhealthy, routing its queries elsewhere", "replica", r.name, "status", status.Status, "error", status.Error)
+		}
+	}
+	r.mu.Lock()
+	r.status = status
+	r.mu.Unlock()
+}
+
+// pickReplica returns the pool of a healthy replica, chosen as
+// database.replicas.selection says, or nil when none is healthy
+func (h *Handler) pickReplica() *sql.DB {
+	replicas := h.replicas.replicas
+	if len(replicas) == 0 {
+		return nil
+	}
+	if h.cfg.Database.Replicas.Selection == "least_connections" {
+		var best *readReplica
+		bestInUse := 0
+		for _, r := range replicas {
+			if !r.healthy.Load() {
+				continue
+			}
+			if inUse := r.db.Stats().InUse; best == nil || inUse < bestInUse {
+				best, bestInUse = r, inUse
+			}
+		}
+		if best == nil {
+			return nil
+		}
+		return best.db
+	}
+	var healthy []*readReplica
+	for _, r := range replicas {
+		if r.healthy.Load() {
+			healthy = append(healthy, r)
+		}
+	}
+	if len(healthy) == 0 {
+		return nil
+	}
+	return healthy[h.replicas.next.Add(1)%uint64(len(healthy))].db
+}
+
+// replicaStatuses reports the last check of each replica for /readyz
+func (h *Handler) replicaStatuses() map[string]ComponentStatus {
+	statuses := map[string]ComponentStatus{}
+	for _, r := range h.replicas.replicas {
+		r.mu.Lock()
+		statuses["database_"+r.name] = r.status
+		r.mu.Unlock()
+	}
+	return statuses
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"context"
+	"strconv"
+
+	"sql-engine/config"
+	"sql-engine/rbac"
+
+	"github.com/gin-gonic/gin"
+)
+
+type resourceLimitsKey struct{}
+
+// resourceSettings returns the PostgreSQL settings that limit the
+// transactions of a request, or nil
+func resourceSettings(ctx context.Context) map[string]string {
+	settings, _ := ctx.Value(resourceLimitsKey{}).(map[string]string)
+	return settings
+}
+
+// ApplyResourceLimits attaches the settings of query.resource_limits for
+// the caller's roles to the request context, for the transactions running
+// its SQL to set. It must run after RBAC.
+func (h *Handler) ApplyResourceLimits() gin.HandlerFunc {
+	cfg := h.cfg.Query.ResourceLimits
+	return func(c *gin.Context) {
+		var roles []string
+		if access := rbac.FromContext(c.Request.Context()); access != nil {
+			roles = access.Roles
+		}
+		if settings := limitSettings(cfg.For(roles)); settings != nil {
+			c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), resourceLimitsKey{}, settings))
+		}
+		c.Next()
+	}
+}
+
+// limitSettings maps limits to the PostgreSQL settings they change
+func limitSettings(l config.ResourceLimits) map[string]string {
+	if l.IsZero() {
+		return nil
+	}
+	settings := map[string]string{}
+	if l.StatementTimeout > 0 {
+		settings["statement_timeout"] = strconv.FormatInt(l.StatementTimeout.Milliseconds(), 10)
+	}
+	if l.IdleInTransactionSessionTimeout > 0 {
+		settings["idle_in_transaction_session_timeout"] = strconv.FormatInt(l.IdleInTransactionSessionTimeout.Milliseconds(), 10)
+	}
+	if l.WorkMem != "" {
+		settings["work_mem"] = l.WorkMem
+	}
+	if l.TempFileLimit != "" {
+		settings["temp_file_limit"] = l.TempFileLimit
+	}
+	return settings
+}
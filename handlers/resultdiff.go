@@ -0,0 +1,321 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"sql-engine/artifact"
+	"sql-engine/auth"
+	"sql-engine/rbac"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// resultArtifactPrefix is where POST /diff keeps saved results
+	resultArtifactPrefix = "results/"
+	// maxListedDifferences caps the rows listed per kind of difference;
+	// the counts are always complete
+	maxListedDifferences = 1000
+)
+
+// DiffSide is either a query to run or the ID of a result saved by an
+// earlier diff
+type DiffSide struct {
+	SQL    string `json:"sql,omitempty"`
+	Result string `json:"result,omitempty"`
+}
+
+// ResultDiffRequest compares the rows of two results matched on Key. With
+// Save, the result of To is kept to be compared against later.
+type ResultDiffRequest struct {
+	From DiffSide `json:"from"`
+	To   DiffSide `json:"to"`
+	Key  []string `json:"key"`
+	Save bool     `json:"save"`
+}
+
+// StoredResult is a query result saved in the artifact store
+type StoredResult struct {
+	ID        string                   `json:"id"`
+	SQL       string                   `json:"sql"`
+	Columns   []string                 `json:"columns"`
+	Rows      []map[string]interface{} `json:"rows"`
+	Owner     string                   `json:"owner,omitempty"`
+	CreatedAt time.Time                `json:"created_at"`
+}
+
+// RowChange is a row present on both sides whose other columns differ
+type RowChange struct {
+	Key     map[string]interface{} `json:"key"`
+	Changes map[string][2]any      `json:"changes"`
+}
+
+// ResultDiff lists the rows only in To (added), only in From (removed) and
+// in both with different values (changed)
+type ResultDiff struct {
+	AddedColumns   []string                 `json:"added_columns"`
+	RemovedColumns []string                 `json:"removed_columns"`
+	Added          []map[string]interface{} `json:"added"`
+	Removed        []map[string]interface{} `json:"removed"`
+	Changed        []RowChange              `json:"changed"`
+	Counts         map[string]int           `json:"counts"`
+	// Truncated is set when more differences were found than listed
+	Truncated bool     `json:"truncated,omitempty"`
+	Warnings  []string `json:"warnings"`
+	// SavedResult is the ID of the saved To result
+	SavedResult string `json:"saved_result,omitempty"`
+}
+
+// DiffResults runs two queries, or compares a query against a result saved
+// earlier, and lists the rows added, removed and changed between them,
+// matching rows on the key columns. Values are compared as they are
+// returned as JSON. Changes lists each differing column as [from, to].
+func (h *Handler) DiffResults(c *gin.Context) {
+	var req ResultDiffRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		return
+	}
+	if len(req.Key) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "key must list the columns identifying a row"})
+		return
+	}
+	if req.Save && req.To.SQL == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "save needs to.sql"})
+		return
+	}
+	var diff ResultDiff
+	from, ok := h.loadDiffSide(c, "from", req.From, &diff)
+	if !ok {
+		return
+	}
+	to, ok := h.loadDiffSide(c, "to", req.To, &diff)
+	if !ok {
+		return
+	}
+
+	fromRows, err := keyRows("from", from, req.Key)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	toRows, err := keyRows("to", to, req.Key)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+
+	diff.AddedColumns, diff.RemovedColumns = []string{}, []string{}
+	var shared []string
+	for _, col := range to.Columns {
+		if slices.Contains(from.Columns, col) {
+			if !slices.Contains(req.Key, col) {
+				shared = append(shared, col)
+			}
+		} else {
+			diff.AddedColumns = append(diff.AddedColumns, col)
+		}
+	}
+	for _, col := range from.Columns {
+		if !slices.Contains(to.Columns, col) {
+			diff.RemovedColumns = append(diff.RemovedColumns, col)
+		}
+	}
+
+	diff.Added, diff.Removed, diff.Changed = []map[string]interface{}{}, []map[string]interface{}{}, []RowChange{}
+	diff.Counts = map[string]int{"added": 0, "removed": 0, "changed": 0, "unchanged": 0}
+	for _, k := range toRows.order {
+		row := toRows.rows[k]
+		old, ok := fromRows.rows[k]
+		if !ok {
+			diff.Counts["added"]++
+			if len(diff.Added) < maxListedDifferences {
+				diff.Added = append(diff.Added, row)
+			} else {
+				diff.Truncated = true
+			}
+			continue
+		}
+		changes := map[string][2]any{}
+		for _, col := range shared {
+			if !sameValue(old[col], row[col]) {
+				changes[col] = [2]any{old[col], row[col]}
+			}
+		}
+		if len(changes) == 0 {
+			diff.Counts["unchanged"]++
+			continue
+		}
+		diff.Counts["changed"]++
+		if len(diff.Changed) < maxListedDifferences {
+			key := map[string]interface{}{}
+			for _, col := range req.Key {
+				key[col] = row[col]
+			}
+			diff.Changed = append(diff.Changed, RowChange{Key: key, Changes: changes})
+		} else {
+			diff.Truncated = true
+		}
+	}
+	for _, k := range fromRows.order {
+		if _, ok := toRows.rows[k]; ok {
+			continue
+		}
+		diff.Counts["removed"]++
+		if len(diff.Removed) < maxListedDifferences {
+			diff.Removed = append(diff.Removed, fromRows.rows[k])
+		} else {
+			diff.Truncated = true
+		}
+	}
+
+	if req.Save {
+		id, err := h.saveResult(c, req.To.SQL, to)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Saving the result failed: " + err.Error()})
+			return
+		}
+		diff.SavedResult = id
+	}
+	if diff.Warnings == nil {
+		diff.Warnings = []string{}
+	}
+	c.JSON(http.StatusOK, diff)
+}
+
+// loadDiffSide runs or loads one side of a diff, writing an error response
+// if it can't. A query returning max_rows rows adds a warning, as its result
+// may have been cut short.
+func (h *Handler) loadDiffSide(c *gin.Context, field string, side DiffSide, diff *ResultDiff) (*QueryResult, bool) {
+	if (side.SQL == "") == (side.Result == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": field + ": set either sql or result"})
+		return nil, false
+	}
+	ctx := c.Request.Context()
+	if side.SQL != "" {
+		result, err := h.executeQuery(withLineageJob(ctx, "diff"), side.SQL, nil)
+		if err != nil {
+			var qe *QueryError
+			if errors.As(err, &qe) {
+				qe.Message = field + ": " + qe.Message
+			}
+			respondQueryError(c, err)
+			return nil, false
+		}
+		if len(result.Rows) >= h.maxRows(ctx) {
+			diff.Warnings = append(diff.Warnings, fmt.Sprintf("%s returned %d rows, the row limit; the diff may be incomplete", field, len(result.Rows)))
+		}
+		return result, true
+	}
+
+	stored, err := h.readResult(ctx, side.Result)
+	switch {
+	case errors.Is(err, errInvalidResultID):
+		c.JSON(http.StatusBadRequest, gin.H{"error": field + ": invalid result ID"})
+		return nil, false
+	case errors.Is(err, artifact.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": field + ": result " + side.Result + " not found or expired"})
+		return nil, false
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": field + ": reading result failed: " + err.Error()})
+		return nil, false
+	}
+	// Results hold the data their owner was allowed to read
+	access := rbac.FromContext(ctx)
+	if stored.Owner != "" && stored.Owner != auth.Principal(c) && (access == nil || !access.IsAdmin()) {
+		c.JSON(http.StatusNotFound, gin.H{"error": field + ": result " + side.Result + " not found or expired"})
+		return nil, false
+	}
+	return &QueryResult{Columns: stored.Columns, Rows: stored.Rows}, true
+}
+
+var errInvalidResultID = errors.New("invalid result ID")
+
+// readResult reads a result kept by saveResult
+func (h *Handler) readResult(ctx context.Context, id string) (*StoredResult, error) {
+	key := resultArtifactPrefix + id + ".json"
+	if !artifact.ValidKey(key) {
+		return nil, errInvalidResultID
+	}
+	r, err := h.artifacts.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	var stored StoredResult
+	dec := json.NewDecoder(r)
+	// Keeps large integers exact
+	dec.UseNumber()
+	if err := dec.Decode(&stored); err != nil {
+		return nil, err
+	}
+	return &stored, nil
+}
+
+// saveResult keeps a result in the artifact store and returns its ID
+func (h *Handler) saveResult(c *gin.Context, sqlText string, result *QueryResult) (string, error) {
+	stored := StoredResult{
+		ID:        newID(),
+		SQL:       sqlText,
+		Columns:   result.Columns,
+		Rows:      result.Rows,
+		Owner:     auth.Principal(c),
+		CreatedAt: time.Now(),
+	}
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return "", err
+	}
+	return stored.ID, h.artifacts.Put(c.Request.Context(), resultArtifactPrefix+stored.ID+".json", bytes.NewReader(data))
+}
+
+// keyedRows indexes the rows of a result by their key, keeping their order
+type keyedRows struct {
+	rows  map[string]map[string]interface{}
+	order []string
+}
+
+// keyRows indexes a result on the key columns, which must be present and
+// unique
+func keyRows(field string, result *QueryResult, key []string) (keyedRows, error) {
+	for _, col := range key {
+		if !slices.Contains(result.Columns, col) {
+			return keyedRows{}, fmt.Errorf("%s: key column %s is not in the result", field, col)
+		}
+	}
+	kr := keyedRows{rows: make(map[string]map[string]interface{}, len(result.Rows))}
+	for _, row := range result.Rows {
+		values := make([]any, len(key))
+		for i, col := range key {
+			values[i] = row[col]
+		}
+		k := jsonValue(values)
+		if _, ok := kr.rows[k]; ok {
+			return keyedRows{}, fmt.Errorf("%s: key %s is not unique", field, k)
+		}
+		kr.rows[k] = row
+		kr.order = append(kr.order, k)
+	}
+	return kr, nil
+}
+
+// sameValue compares values as they are returned, so that a saved result
+// read back from JSON matches the rows of a fresh run
+func sameValue(a, b any) bool {
+	return jsonValue(a) == jsonValue(b)
+}
+
+func jsonValue(v any) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+// retryTx runs fn in a transaction and commits it. When the database aborts
+// the transaction for a serialization failure or a deadlock, fn runs again
+// in a new one, up to transactions.max_retries times with exponential
+// backoff, so fn must not keep state across calls. It returns how many
+// times fn was retried.
+func (h *Handler) retryTx(ctx context.Context, fn func(tx *sql.Tx) error) (int, error) {
+	cfg := h.cfg.Transactions
+	delay := cfg.Backoff
+	for retries := 0; ; retries++ {
+		err := h.runTx(ctx, fn)
+		if err == nil || retries >= cfg.MaxRetries || !h.dialect.Retryable(err) {
+			return retries, err
+		}
+		// Jitter keeps transactions that collided from colliding again
+		wait := delay/2 + rand.N(delay/2+1)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return retries, err
+		}
+		delay *= 2
+		if cfg.MaxBackoff > 0 && delay > cfg.MaxBackoff {
+			delay = cfg.MaxBackoff
+		}
+	}
+}
+
+// runTx runs fn in a transaction limited by the request's resource limits and
+// commits it, rolling it back if fn fails
+func (h *Handler) runTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return &QueryError{Status: http.StatusInternalServerError, Message: "Failed to start transaction: " + err.Error(), Err: err}
+	}
+	defer tx.Rollback()
+	if err := h.dialect.SetLocal(ctx, tx, resourceSettings(ctx)); err != nil {
+		return &QueryError{Status: http.StatusInternalServerError, Message: "Failed to apply resource limits: " + err.Error(), Err: err}
+	}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return &QueryError{Status: http.StatusInternalServerError, Message: "Commit failed: " + err.Error(), Err: err}
+	}
+	return nil
+}
+
+// beginRead starts the read-only transaction of a statement, or its
+// savepoint in a session, and runs query in it. A query failing because its
+// connection was lost, e.g. to a database restart, runs once more on a new
+// connection, reads being safe to repeat; in a session it isn't, as the
+// session's transaction went with the connection. end finishes the
+// transaction once rows are read.
+func (h *Handler) beginRead(ctx context.Context, query string, args ...any) (*sql.Rows, func(), error) {
+	for retried := false; ; retried = true {
+		rows, end, err := h.tryRead(ctx, query, args...)
+		if err == nil {
+			return rows, end, nil
+		}
+		if retried || sessionFrom(ctx) != nil || ctx.Err() != nil || !h.dialect.ConnectionLost(err) {
+			return nil, nil, err
+		}
+		slog.Warn("Database connection lost, retrying the query on another", "error", err)
+	}
+}
+
+func (h *Handler) tryRead(ctx context.Context, query string, args ...any) (*sql.Rows, func(), error) {
+	tx, end, err := h.beginStatement(ctx)
+	if err != nil {
+		return nil, nil, &QueryError{Status: http.StatusInternalServerError, Message: "Failed to start read-only transaction: " + err.Error(), Err: err}
+	}
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		end()
+		return nil, nil, &QueryError{Status: http.StatusInternalServerError, Message: "Execution failed: " + err.Error(), Err: err}
+	}
+	return rows, end, nil
+}
@@ -0,0 +1,312 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"sql-engine/rbac"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rowFilterOps maps the operators of GET /table/:name/rows filters to those
+// of ColumnFilter
+var rowFilterOps = map[string]string{"eq": "=", "neq": "!=", "lt": "<", "lte": "<=", "gt": ">", "gte": ">="}
+
+// GetTableRows pages through a table without SQL:
+//
+//   - ?columns=a,b selects columns, by default every granted one
+//   - ?filter.<column>=<op>:<value> keeps matching rows, where op is eq, neq,
+//     lt, lte, gt, gte, like, in (comma-separated values) or is (null or
+//     not_null); ?filter.<column>=is_null and =not_null take no value.
+//     eq: with nothing after the colon matches empty strings, never NULLs;
+//     PostgreSQL's numeric and date/time columns hold no empty strings, so
+//     it is refused for them. Repeated filters must all match. ?filter.<column>.<path>
+//     filters on a value inside a JSON column, e.g.
+//     ?filter.payload.items.0.sku=eq:A1, comparing numbers for lt, lte, gt
+//     and gte with a numeric value and text otherwise
+//   - ?sort=a,-b orders by columns, descending when prefixed with -
+//   - ?limit and ?offset select the page
+//   - ?versions=true adds each row's version as _version, to send in
+//     If-Match when updating or deleting it
+func (h *Handler) GetTableRows(c *gin.Context) {
+	schema, ok := h.schemaParam(c)
+	if !ok {
+		return
+	}
+	tableName := c.Param("name")
+	if matchesAny(h.cfg.Query.Denylist.Tables, tableName) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Denied: table " + tableName + " is not allowed"})
+		return
+	}
+	if !h.requireTable(c, schema, tableName) || !h.requireRowLevel(c, schema, tableName) {
+		return
+	}
+	maxRows := h.maxRows(c.Request.Context())
+	limit, ok := intQuery(c, "limit", maxRows, maxRows)
+	if !ok {
+		return
+	}
+	offset, ok := intQuery(c, "offset", 0, math.MaxInt)
+	if !ok {
+		return
+	}
+	format, ok := h.queryResultFormat(c)
+	if !ok {
+		return
+	}
+
+	tableColumns, err := h.dialect.ListColumns(h.db, schema, tableName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(tableColumns) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Table not found"})
+		return
+	}
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return h.dialect.Placeholder(len(args))
+	}
+	sel, ok := h.parseRowSelection(c, schema, tableName, tableColumns, arg)
+	if !ok {
+		return
+	}
+	columns := sel.Columns
+	q := h.dialect.Quote
+
+	quoted := make([]string, len(columns))
+	for i, col := range columns {
+		quoted[i] = q(col)
+	}
+	if c.Query("versions") == "true" {
+		expr := h.dialect.RowVersion()
+		if expr == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": h.dialect.ProductName() + " has no row versions"})
+			return
+		}
+		quoted = append(quoted, expr+" AS "+q(rowVersionColumn))
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s.%s%s", strings.Join(quoted, ", "), q(schema), q(tableName), sel.clauses())
+	// Read one extra row to learn whether another page follows
+	query += fmt.Sprintf(" %s OFFSET %d", h.dialect.LimitClause(limit+1), offset)
+
+	ctx := c.Request.Context()
+	if timeout := h.queryTimeout(ctx); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	release, err := h.acquireStatement(ctx)
+	if err != nil {
+		respondQueryError(c, err)
+		return
+	}
+	defer release()
+	start := time.Now()
+	rows, end, err := h.beginRead(ctx, query, args...)
+	if err != nil {
+		respondQueryError(c, err)
+		return
+	}
+	defer end()
+	defer rows.Close()
+	var types map[string]string
+	if format != nil {
+		types = columnTypes(rows)
+	}
+	_, result, err := scanRowMaps(rows)
+	meterStatement(ctx, time.Since(start), len(result))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	hasMore := len(result) > limit
+	if hasMore {
+		result = result[:limit]
+	}
+
+	access := rbac.FromContext(c.Request.Context())
+	var roles []string
+	if access != nil {
+		roles = access.Roles
+	}
+	masked := h.masks.Columns(h.grantSchema(schema), tableName, roles)
+	for _, row := range result {
+		for col, v := range row {
+			if m, ok := masked[strings.ToLower(col)]; ok {
+				row[col] = h.masks.Apply(m, v)
+			}
+		}
+	}
+	format.apply(types, result)
+
+	resp := gin.H{
+		"schema":     schema,
+		"table_name": tableName,
+		"columns":    columns,
+		"rows":       result,
+		"limit":      limit,
+		"offset":     offset,
+		"has_more":   hasMore,
+	}
+	if prefs := h.viewPreferences(c, tableView(schema, tableName)); prefs != nil {
+		resp["view_preferences"] = prefs
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// rowSelection is the columns, conditions and order of a table read, as
+// given by the query string of GET /table/:name/rows
+type rowSelection struct {
+	Columns []string
+	Where   []string
+	OrderBy []string
+}
+
+// clauses returns the WHERE and ORDER BY clauses of the selection
+func (s *rowSelection) clauses() string {
+	var clause string
+	if len(s.Where) > 0 {
+		clause += " WHERE " + strings.Join(s.Where, " AND ")
+	}
+	if len(s.OrderBy) > 0 {
+		clause += " ORDER BY " + strings.Join(s.OrderBy, ", ")
+	}
+	return clause
+}
+
+// parseRowSelection reads ?columns, ?filter.<column> and ?sort, checking
+// them against the table and the caller's grants; arg binds a filter value
+// and returns its placeholder
+func (h *Handler) parseRowSelection(c *gin.Context, schema, tableName string, tableColumns []ColumnInfo, arg func(interface{}) string) (*rowSelection, bool) {
+	access := rbac.FromContext(c.Request.Context())
+	grantSchema := h.grantSchema(schema)
+
+	var columns []string
+	if list := c.Query("columns"); list != "" {
+		for _, col := range strings.Split(list, ",") {
+			col = strings.TrimSpace(col)
+			if !slices.ContainsFunc(tableColumns, func(tc ColumnInfo) bool { return tc.Name == col }) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Column " + col + " not found"})
+				return nil, false
+			}
+			if !access.CanColumn(grantSchema, tableName, col) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Access denied: column " + col + " is not granted"})
+				return nil, false
+			}
+			columns = append(columns, col)
+		}
+	} else {
+		for _, col := range filterColumns(access, grantSchema, tableName, tableColumns) {
+			columns = append(columns, col.Name)
+		}
+	}
+	if len(columns) == 0 {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied: no column of " + tableName + " is granted"})
+		return nil, false
+	}
+
+	// Filtering or sorting by a column tells about its values, so both need
+	// it readable unmasked
+	q := h.dialect.Quote
+	var conds []string
+	var filterKeys []string
+	for key := range c.Request.URL.Query() {
+		if strings.HasPrefix(key, "filter.") {
+			filterKeys = append(filterKeys, key)
+		}
+	}
+	sort.Strings(filterKeys)
+	for _, key := range filterKeys {
+		col, path := strings.TrimPrefix(key, "filter."), ""
+		if !slices.ContainsFunc(tableColumns, func(tc ColumnInfo) bool { return tc.Name == col }) {
+			// filter.<column>.<path> filters on a value inside a JSON column
+			if name, rest, ok := strings.Cut(col, "."); ok {
+				col, path = name, rest
+			}
+		}
+		if !h.analysisColumn(c, schema, tableName, tableColumns, "filter", col) {
+			return nil, false
+		}
+		for _, spec := range c.QueryArray(key) {
+			op, value, _ := strings.Cut(spec, ":")
+			f := ColumnFilter{Column: col, Path: path}
+			var cond string
+			var err error
+			switch op {
+			case "like":
+				if cond, err = h.filterColumn(f, tableColumns, false); err == nil {
+					cond += " LIKE " + arg(value)
+				}
+			case "in":
+				values := strings.Split(value, ",")
+				marks := make([]string, len(values))
+				for i, v := range values {
+					marks[i] = arg(v)
+				}
+				if slices.Contains(values, "") {
+					if err = h.emptyFilterValue(f, tableColumns); err != nil {
+						break
+					}
+				}
+				if cond, err = h.filterColumn(f, tableColumns, false); err == nil {
+					cond += " IN (" + strings.Join(marks, ", ") + ")"
+				}
+			case "is_null", "not_null":
+				if value != "" {
+					err = fmt.Errorf("%s takes no value", op)
+					break
+				}
+				f.Op = op
+				cond, err = h.filterCondition(f, tableColumns, arg)
+			case "is":
+				isOps := map[string]string{"null": "is_null", "not_null": "not_null"}
+				if _, ok := isOps[value]; !ok {
+					err = fmt.Errorf("is takes null or not_null")
+					break
+				}
+				f.Op = isOps[value]
+				cond, err = h.filterCondition(f, tableColumns, arg)
+			default:
+				cmp, ok := rowFilterOps[op]
+				if !ok {
+					err = fmt.Errorf("unknown operator %s", op)
+					break
+				}
+				f.Op, f.Value = cmp, value
+				cond, err = h.filterCondition(f, tableColumns, arg)
+			}
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": key + ": " + err.Error()})
+				return nil, false
+			}
+			conds = append(conds, cond)
+		}
+	}
+
+	var orderBy []string
+	if list := c.Query("sort"); list != "" {
+		for _, col := range strings.Split(list, ",") {
+			col = strings.TrimSpace(col)
+			dir := "ASC"
+			if name, desc := strings.CutPrefix(col, "-"); desc {
+				col, dir = name, "DESC"
+			}
+			if !h.analysisColumn(c, schema, tableName, tableColumns, "sort", col) {
+				return nil, false
+			}
+			orderBy = append(orderBy, q(col)+" "+dir)
+		}
+	}
+
+	return &rowSelection{Columns: columns, Where: conds, OrderBy: orderBy}, true
+}
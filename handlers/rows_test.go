@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"sql-engine/config"
+	"sql-engine/masking"
+
+	"github.com/gin-gonic/gin"
+)
+
+// strictSQLite is SQLite posing as an engine that enforces column types,
+// as PostgreSQL does
+type strictSQLite struct {
+	sqliteDialect
+}
+
+func (strictSQLite) StrictTypes() bool {
+	return true
+}
+
+// newRowsTestHandler returns a handler over an in-memory SQLite database
+// holding an events table whose rows have a value, an empty string or zero,
+// and NULLs in each column
+func newRowsTestHandler(t *testing.T, dialect Dialect) (*Handler, []ColumnInfo) {
+	t.Helper()
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	db.SetMaxOpenConns(1)
+	for _, stmt := range []string{
+		`CREATE TABLE events (name TEXT, amount NUMERIC, created_at TIMESTAMP)`,
+		`INSERT INTO events VALUES ('alice', 10, '2024-01-01 10:00:00')`,
+		`INSERT INTO events VALUES ('', 0, '2024-01-02 00:00:00')`,
+		`INSERT INTO events VALUES (NULL, NULL, NULL)`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h := &Handler{db: db, dialect: dialect, cfg: &config.Config{}, masks: masking.New(config.MaskingConfig{})}
+	columns, err := dialect.ListColumns(db, "", "events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h, columns
+}
+
+// countRows counts the rows of events matching the filters of query, or
+// returns the status and body of the response refusing them
+func countRows(t *testing.T, h *Handler, columns []ColumnInfo, query url.Values) (int, int, string) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	c.Request = httptest.NewRequest(http.MethodGet, "/table/events/rows?"+query.Encode(), nil)
+
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return h.dialect.Placeholder(len(args))
+	}
+	sel, ok := h.parseRowSelection(c, "", "events", columns, arg)
+	if !ok {
+		return 0, rec.Code, rec.Body.String()
+	}
+	var n int
+	if err := h.db.QueryRow("SELECT count(*) FROM events"+sel.clauses(), args...).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n, http.StatusOK, ""
+}
+
+func TestRowFilters(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h, columns := newRowsTestHandler(t, sqliteDialect{})
+
+	tests := []struct {
+		column, spec string
+		want         int
+	}{
+		{"name", "eq:alice", 1},
+		{"name", "eq:", 1},
+		{"name", "is_null", 1},
+		{"name", "not_null", 2},
+		{"name", "is:null", 1},
+		{"amount", "eq:10", 1},
+		{"amount", "eq:0", 1},
+		{"amount", "is_null", 1},
+		{"amount", "not_null", 2},
+		{"created_at", "eq:2024-01-01 10:00:00", 1},
+		{"created_at", "is_null", 1},
+		{"created_at", "not_null", 2},
+		// Without strict types an empty string is compared like any other
+		{"amount", "eq:", 0},
+		{"created_at", "eq:", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.column+"="+tt.spec, func(t *testing.T) {
+			got, status, body := countRows(t, h, columns, url.Values{"filter." + tt.column: {tt.spec}})
+			if status != http.StatusOK {
+				t.Fatalf("status %d: %s", status, body)
+			}
+			if got != tt.want {
+				t.Errorf("got %d rows, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRowFiltersRejected(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h, columns := newRowsTestHandler(t, strictSQLite{})
+
+	tests := []struct {
+		column, spec, want string
+	}{
+		{"amount", "eq:", "holds no empty strings"},
+		{"created_at", "eq:", "holds no empty strings"},
+		{"amount", "in:1,", "holds no empty strings"},
+		{"name", "is_null:x", "is_null takes no value"},
+		{"name", "is:empty", "is takes null or not_null"},
+		{"name", "between:a", "unknown operator between"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.column+"="+tt.spec, func(t *testing.T) {
+			_, status, body := countRows(t, h, columns, url.Values{"filter." + tt.column: {tt.spec}})
+			if status != http.StatusBadRequest {
+				t.Fatalf("status %d, want %d", status, http.StatusBadRequest)
+			}
+			if !strings.Contains(body, tt.want) {
+				t.Errorf("body %s does not mention %q", body, tt.want)
+			}
+		})
+	}
+
+	// Text columns may still be compared with an empty string
+	got, status, body := countRows(t, h, columns, url.Values{"filter.name": {"eq:"}})
+	if status != http.StatusOK || got != 1 {
+		t.Errorf("filter.name=eq: got %d rows, status %d: %s", got, status, body)
+	}
+}
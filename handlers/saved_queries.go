@@ -0,0 +1,501 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"sql-engine/auth"
+	"sql-engine/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	savedQueryPrefix = "saved-query/"
+	testRunPrefix    = "saved-query-test/"
+)
+
+// SavedQuery is a named query kept for reuse, optionally carrying assertions
+// that turn it into a data-quality test
+type SavedQuery struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	SQL         string `json:"sql"`
+	Owner       string `json:"owner,omitempty"`
+	// Folder is a slash-separated path grouping queries, e.g. "finance/monthly"
+	Folder string   `json:"folder,omitempty"`
+	Tags   []string `json:"tags,omitempty"`
+	// Variables declares the {{name}} placeholders bound on each run
+	Variables  []QueryVariable `json:"variables,omitempty"`
+	Assertions []Assertion     `json:"assertions,omitempty"`
+	// TestEvery schedules the query and its assertions to run periodically,
+	// e.g. "1h"
+	TestEvery string `json:"test_every,omitempty"`
+	// DependsOn lists what the query needs to be fresh before it runs; a
+	// query depending on other saved queries runs right after them
+	DependsOn []QueryDependency `json:"depends_on,omitempty"`
+	// ReferencedTables are the tables the query read when it was last
+	// saved, checked before each run for dropped columns
+	ReferencedTables []TableSchema `json:"referenced_tables,omitempty"`
+	CreatedAt        time.Time     `json:"created_at"`
+	UpdatedAt        time.Time     `json:"updated_at"`
+}
+
+// SavedQueryRequest is the writable part of a saved query
+type SavedQueryRequest struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	SQL         string            `json:"sql"`
+	Folder      string            `json:"folder"`
+	Tags        []string          `json:"tags"`
+	Variables   []QueryVariable   `json:"variables"`
+	Assertions  []Assertion       `json:"assertions"`
+	TestEvery   string            `json:"test_every"`
+	DependsOn   []QueryDependency `json:"depends_on"`
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ListSavedQueries lists the saved queries, optionally only those of ?owner,
+// in ?folder (including its subfolders) or tagged ?tag
+func (h *Handler) ListSavedQueries(c *gin.Context) {
+	queries, err := h.savedQueries()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	filter := SavedQueryFilter{Owner: c.Query("owner"), Folder: c.Query("folder"), Tag: c.Query("tag")}
+	queries = slices.DeleteFunc(queries, func(q SavedQuery) bool { return !filter.matches(q) })
+	c.JSON(http.StatusOK, gin.H{"saved_queries": queries})
+}
+
+func (h *Handler) CreateSavedQuery(c *gin.Context) {
+	var req SavedQueryRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		return
+	}
+	if err := req.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	now := time.Now()
+	q := SavedQuery{
+		ID:        newID(),
+		Owner:     auth.Principal(c),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	req.apply(&q)
+	if err := h.checkSavedQueryAccess(c.Request.Context(), q); err != nil {
+		respondQueryError(c, err)
+		return
+	}
+	if err := h.checkDependencies(q); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	q.ReferencedTables = h.referencedTables(q)
+
+	if err := h.meta.Put(savedQueryPrefix+q.ID, q); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	h.scheduleTests(q)
+
+	c.JSON(http.StatusCreated, q)
+}
+
+func (h *Handler) GetSavedQuery(c *gin.Context) {
+	q, ok := h.loadSavedQuery(c)
+	if !ok {
+		return
+	}
+
+	// The last test ran under the owner's access; its results go only to
+	// callers who may run the query themselves
+	var last TestRun
+	if err := h.meta.Get(testRunPrefix+q.ID, &last); err == nil && h.checkSavedQueryAccess(c.Request.Context(), q) == nil {
+		c.JSON(http.StatusOK, gin.H{"saved_query": q, "last_test": last})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"saved_query": q})
+}
+
+func (h *Handler) UpdateSavedQuery(c *gin.Context) {
+	q, ok := h.loadOwnedSavedQuery(c)
+	if !ok {
+		return
+	}
+
+	var req SavedQueryRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		return
+	}
+	if err := req.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	req.apply(&q)
+	if err := h.checkSavedQueryAccess(c.Request.Context(), q); err != nil {
+		respondQueryError(c, err)
+		return
+	}
+	if err := h.checkDependencies(q); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	q.ReferencedTables = h.referencedTables(q)
+	q.UpdatedAt = time.Now()
+	if err := h.meta.Put(savedQueryPrefix+q.ID, q); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	h.scheduleTests(q)
+
+	c.JSON(http.StatusOK, q)
+}
+
+func (h *Handler) DeleteSavedQuery(c *gin.Context) {
+	q, ok := h.loadOwnedSavedQuery(c)
+	if !ok {
+		return
+	}
+	queries, err := h.savedQueries()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	for _, other := range queries {
+		if other.dependsOn(q.ID) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Saved query " + other.Name + " depends on this query"})
+			return
+		}
+	}
+
+	if err := h.moveToTrash(c, "saved-query", q.ID, q.Name, q.Owner, q); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	h.meta.Delete(testRunPrefix + q.ID)
+	h.sched.Cancel(testJobName(q.ID))
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) RunSavedQuery(c *gin.Context) {
+	format, ok := h.queryResultFormat(c)
+	if !ok {
+		return
+	}
+	q, ok := h.loadSavedQuery(c)
+	if !ok {
+		return
+	}
+
+	// Variables take their defaults; use /execute to supply them
+	sqlText, args, err := bindQueryVariables(q, nil)
+	if err == nil {
+		err = h.checkSavedQuerySchema(c.Request.Context(), q)
+	}
+	if err != nil {
+		respondQueryError(c, err)
+		return
+	}
+	ctx := withResultFormat(withLineageJob(c.Request.Context(), "saved-query."+q.ID), format)
+	result, err := h.executeQuery(ctx, sqlText, nil, args...)
+	if err != nil {
+		respondQueryError(c, err)
+		return
+	}
+
+	resp := gin.H{
+		"columns": result.Columns,
+		"rows":    result.Rows,
+	}
+	if prefs := h.viewPreferences(c, savedQueryView(q.ID)); prefs != nil {
+		resp["view_preferences"] = prefs
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// TestSavedQuery runs a saved query and evaluates its assertions
+func (h *Handler) TestSavedQuery(c *gin.Context) {
+	q, ok := h.loadSavedQuery(c)
+	if !ok {
+		return
+	}
+	if len(q.Assertions) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Saved query has no assertions"})
+		return
+	}
+
+	run := h.testSavedQuery(c.Request.Context(), q, "", nil)
+	c.JSON(http.StatusOK, run)
+}
+
+// StartScheduledTests schedules every saved query that declares a test
+// interval, together with the queries downstream of it
+func (h *Handler) StartScheduledTests() {
+	queries, err := h.savedQueries()
+	if err != nil {
+		slog.Error("Failed to load saved queries for scheduling", "error", err)
+		return
+	}
+	for _, q := range queries {
+		h.scheduleTests(q)
+	}
+	// Saved queries may be created, changed or deleted on other replicas
+	h.sched.Every("saved-query-sync", time.Minute, func(ctx context.Context) {
+		h.syncScheduledTests()
+	})
+}
+
+// syncScheduledTests brings the scheduled tests in line with the stored
+// saved queries
+func (h *Handler) syncScheduledTests() {
+	queries, err := h.savedQueries()
+	if err != nil {
+		slog.Error("Failed to load saved queries for scheduling", "error", err)
+		return
+	}
+	current := make(map[string]bool)
+	for _, q := range queries {
+		name := testJobName(q.ID)
+		current[name] = true
+		every, _ := time.ParseDuration(q.TestEvery)
+		if interval, ok := h.sched.Interval(name); !ok || interval != every {
+			h.scheduleTests(q)
+		}
+	}
+	for _, name := range h.sched.Names(testJobName("")) {
+		if !current[name] {
+			h.sched.Cancel(name)
+		}
+	}
+}
+
+func (h *Handler) scheduleTests(q SavedQuery) {
+	every, err := time.ParseDuration(q.TestEvery)
+	if q.TestEvery == "" || err != nil {
+		h.sched.Cancel(testJobName(q.ID))
+		return
+	}
+
+	id := q.ID
+	h.sched.Every(testJobName(id), every, func(ctx context.Context) {
+		var current SavedQuery
+		if err := h.meta.Get(savedQueryPrefix+id, &current); err != nil {
+			return
+		}
+		h.runPipeline(ctx, current, "", nil)
+	})
+}
+
+func testJobName(id string) string {
+	return "saved-query-test:" + id
+}
+
+// testSavedQuery executes q with the variable values given, the defaults
+// filling in the rest, evaluates its assertions and stores the run. trigger
+// names what ran q, if not a direct request.
+func (h *Handler) testSavedQuery(ctx context.Context, q SavedQuery, trigger string, values map[string]any) TestRun {
+	start := time.Now()
+	run := TestRun{QueryID: q.ID, RanAt: start, TriggeredBy: trigger}
+
+	sqlText, args, err := bindQueryVariables(q, values)
+	if err == nil {
+		err = h.checkSavedQuerySchema(ctx, q)
+	}
+	var result *QueryResult
+	if err == nil {
+		result, err = h.executeQuery(withLineageJob(ctx, "saved-query-test."+q.ID), sqlText, nil, args...)
+	}
+	if err != nil {
+		run.Error = err.Error()
+	} else {
+		run.Passed = true
+		for _, a := range q.Assertions {
+			r := a.Evaluate(result)
+			run.Passed = run.Passed && r.Passed
+			run.Results = append(run.Results, r)
+		}
+	}
+	run.Duration = time.Since(start).String()
+
+	if !run.Passed {
+		slog.WarnContext(ctx, "Saved query failed its assertions", "saved_query", q.ID, "name", q.Name)
+	}
+	if err := h.meta.Put(testRunPrefix+q.ID, run); err != nil {
+		slog.ErrorContext(ctx, "Failed to store test run", "error", err)
+	}
+	return run
+}
+
+// checkSavedQueryAccess verifies that the caller of ctx may run q, with its
+// variables as bind parameters
+func (h *Handler) checkSavedQueryAccess(ctx context.Context, q SavedQuery) error {
+	n := 0
+	sqlText := sqlVariableReference.ReplaceAllStringFunc(q.SQL, func(m string) string {
+		name := sqlVariableReference.FindStringSubmatch(m)[1]
+		if !slices.ContainsFunc(q.Variables, func(v QueryVariable) bool { return v.Name == name }) {
+			return m
+		}
+		n++
+		return queryVariableMarker + strconv.Itoa(n)
+	})
+	_, err := h.prepareSelect(ctx, sqlText)
+	return err
+}
+
+func (h *Handler) savedQueries() ([]SavedQuery, error) {
+	keys, err := h.meta.List(savedQueryPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	queries := []SavedQuery{}
+	for _, key := range keys {
+		var q SavedQuery
+		if err := h.meta.Get(key, &q); err != nil {
+			return nil, err
+		}
+		queries = append(queries, q)
+	}
+	return queries, nil
+}
+
+// loadSavedQuery fetches the saved query named by the :id route parameter,
+// writing an error response if it can't be loaded
+func (h *Handler) loadSavedQuery(c *gin.Context) (SavedQuery, bool) {
+	var q SavedQuery
+	if err := h.meta.Get(savedQueryPrefix+c.Param("id"), &q); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Saved query not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return q, false
+	}
+	return q, true
+}
+
+// loadOwnedSavedQuery is loadSavedQuery restricted to the query's owner
+func (h *Handler) loadOwnedSavedQuery(c *gin.Context) (SavedQuery, bool) {
+	q, ok := h.loadSavedQuery(c)
+	if !ok {
+		return q, false
+	}
+	if q.Owner != "" && q.Owner != auth.Principal(c) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the owner can modify this saved query"})
+		return q, false
+	}
+	return q, true
+}
+
+func (r *SavedQueryRequest) validate() error {
+	if strings.TrimSpace(r.Name) == "" {
+		return errors.New("name is required")
+	}
+	if strings.TrimSpace(r.SQL) == "" {
+		return errors.New("sql is required")
+	}
+	if r.TestEvery != "" {
+		every, err := time.ParseDuration(r.TestEvery)
+		if err != nil {
+			return errors.New("test_every: " + err.Error())
+		}
+		if every < time.Minute {
+			return errors.New("test_every must be at least 1m")
+		}
+	}
+	for _, tag := range r.Tags {
+		if strings.TrimSpace(tag) == "" {
+			return errors.New("tags must not be empty")
+		}
+	}
+	if err := validateQueryVariables(r.Variables, r.SQL); err != nil {
+		return err
+	}
+	for i, a := range r.Assertions {
+		if err := a.validate(); err != nil {
+			return fmt.Errorf("assertions[%d]: %w", i, err)
+		}
+	}
+	for i, d := range r.DependsOn {
+		if (d.SavedQuery == "") == (d.Table == "") {
+			return fmt.Errorf("depends_on[%d]: set either saved_query or table", i)
+		}
+		if d.SavedQuery != "" && r.TestEvery != "" {
+			return errors.New("test_every cannot be combined with saved query dependencies; the query runs after its upstreams")
+		}
+	}
+	return nil
+}
+
+func (r *SavedQueryRequest) apply(q *SavedQuery) {
+	q.Name = strings.TrimSpace(r.Name)
+	q.Description = r.Description
+	q.SQL = strings.TrimSpace(r.SQL)
+	q.Folder = cleanFolder(r.Folder)
+	q.Tags = cleanTags(r.Tags)
+	q.Variables = r.Variables
+	q.Assertions = r.Assertions
+	q.TestEvery = r.TestEvery
+	q.DependsOn = r.DependsOn
+}
+
+// SavedQueryFilter selects saved queries by owner, folder and tag; empty
+// fields match every query
+type SavedQueryFilter struct {
+	Owner string `json:"owner"`
+	// Folder matches the folder and its subfolders
+	Folder string `json:"folder"`
+	Tag    string `json:"tag"`
+}
+
+func (f SavedQueryFilter) matches(q SavedQuery) bool {
+	folder := cleanFolder(f.Folder)
+	return (f.Owner == "" || q.Owner == f.Owner) &&
+		(folder == "" || q.Folder == folder || strings.HasPrefix(q.Folder, folder+"/")) &&
+		(f.Tag == "" || slices.Contains(q.Tags, strings.TrimSpace(f.Tag)))
+}
+
+// cleanFolder trims spaces and leading, trailing and repeated slashes
+func cleanFolder(folder string) string {
+	var parts []string
+	for _, part := range strings.Split(folder, "/") {
+		if part = strings.TrimSpace(part); part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// cleanTags trims tags and drops duplicates, keeping their order
+func cleanTags(tags []string) []string {
+	var out []string
+	for _, tag := range tags {
+		if tag = strings.TrimSpace(tag); tag != "" && !slices.Contains(out, tag) {
+			out = append(out, tag)
+		}
+	}
+	return out
+}
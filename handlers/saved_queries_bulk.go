@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// BulkSavedQueryRequest applies one change to many saved queries, e.g. to
+// hand over the queries of someone who left. Queries are selected by IDs, by
+// Filter, or both, in which case both must match.
+type BulkSavedQueryRequest struct {
+	IDs    []string         `json:"ids"`
+	Filter SavedQueryFilter `json:"filter"`
+	// Action is move (to Folder), tag (adding AddTags and removing
+	// RemoveTags), transfer (to Owner) or delete (to the trash)
+	Action     string   `json:"action"`
+	Folder     string   `json:"folder"`
+	AddTags    []string `json:"add_tags"`
+	RemoveTags []string `json:"remove_tags"`
+	Owner      string   `json:"owner"`
+	// DryRun reports the queries affected without changing them
+	DryRun bool `json:"dry_run"`
+}
+
+// BulkSavedQueryResult is the outcome for one selected query
+type BulkSavedQueryResult struct {
+	ID     string   `json:"id"`
+	Name   string   `json:"name,omitempty"`
+	Owner  string   `json:"owner,omitempty"`
+	Folder string   `json:"folder,omitempty"`
+	Tags   []string `json:"tags,omitempty"`
+	// Change describes what is changed, e.g. "owner: alice -> bob"
+	Change string `json:"change,omitempty"`
+	// Skipped tells why the query is left alone
+	Skipped string `json:"skipped,omitempty"`
+}
+
+// BulkUpdateSavedQueries moves, re-tags, transfers or deletes the selected
+// saved queries, reporting the outcome per query
+func (h *Handler) BulkUpdateSavedQueries(c *gin.Context) {
+	var req BulkSavedQueryRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		return
+	}
+	if len(req.IDs) == 0 && req.Filter == (SavedQueryFilter{}) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Select queries with ids or filter"})
+		return
+	}
+	switch req.Action {
+	case "move", "delete":
+	case "tag":
+		if len(cleanTags(req.AddTags)) == 0 && len(cleanTags(req.RemoveTags)) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "tag needs add_tags or remove_tags"})
+			return
+		}
+	case "transfer":
+		if strings.TrimSpace(req.Owner) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "transfer needs an owner"})
+			return
+		}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "action must be move, tag, transfer or delete"})
+		return
+	}
+
+	queries, err := h.savedQueries()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	var selected []SavedQuery
+	for _, q := range queries {
+		if (len(req.IDs) == 0 || slices.Contains(req.IDs, q.ID)) && req.Filter.matches(q) {
+			selected = append(selected, q)
+		}
+	}
+	results := []BulkSavedQueryResult{}
+	for _, id := range req.IDs {
+		if !slices.ContainsFunc(queries, func(q SavedQuery) bool { return q.ID == id }) {
+			results = append(results, BulkSavedQueryResult{ID: id, Skipped: "not found"})
+		}
+	}
+
+	changed := 0
+	for _, q := range selected {
+		r := BulkSavedQueryResult{ID: q.ID, Name: q.Name, Owner: q.Owner, Folder: q.Folder, Tags: q.Tags}
+		updated := q
+		switch req.Action {
+		case "move":
+			updated.Folder = cleanFolder(req.Folder)
+			r.Change = "folder: " + q.Folder + " -> " + updated.Folder
+		case "tag":
+			tags := slices.DeleteFunc(slices.Clone(q.Tags), func(t string) bool { return slices.Contains(cleanTags(req.RemoveTags), t) })
+			updated.Tags = cleanTags(append(tags, req.AddTags...))
+			r.Change = "tags: [" + strings.Join(q.Tags, ", ") + "] -> [" + strings.Join(updated.Tags, ", ") + "]"
+		case "transfer":
+			updated.Owner = strings.TrimSpace(req.Owner)
+			r.Change = "owner: " + q.Owner + " -> " + updated.Owner
+		case "delete":
+			r.Change = "deleted"
+			// Queries depending on this one must go too
+			if i := slices.IndexFunc(queries, func(other SavedQuery) bool {
+				return other.dependsOn(q.ID) && !slices.ContainsFunc(selected, func(s SavedQuery) bool { return s.ID == other.ID })
+			}); i >= 0 {
+				r.Change, r.Skipped = "", "saved query "+queries[i].Name+" depends on it"
+			}
+		}
+		if req.Action != "delete" && updated.Folder == q.Folder && updated.Owner == q.Owner && slices.Equal(updated.Tags, q.Tags) {
+			r.Change, r.Skipped = "", "unchanged"
+		}
+		if r.Skipped == "" && !req.DryRun {
+			if err := h.applyBulkChange(c, req.Action, q, updated); err != nil {
+				r.Change, r.Skipped = "", "failed: "+err.Error()
+			}
+		}
+		if r.Skipped == "" {
+			changed++
+		}
+		results = append(results, r)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"action":  req.Action,
+		"dry_run": req.DryRun,
+		"matched": len(selected),
+		"changed": changed,
+		"results": results,
+	})
+}
+
+// applyBulkChange stores the updated query, or moves q to the trash
+func (h *Handler) applyBulkChange(c *gin.Context, action string, q, updated SavedQuery) error {
+	if action == "delete" {
+		if err := h.moveToTrash(c, "saved-query", q.ID, q.Name, q.Owner, q); err != nil {
+			return err
+		}
+		h.meta.Delete(testRunPrefix + q.ID)
+		h.sched.Cancel(testJobName(q.ID))
+		return nil
+	}
+	updated.UpdatedAt = time.Now()
+	return h.meta.Put(savedQueryPrefix+q.ID, updated)
+}
@@ -0,0 +1,466 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"sql-engine/auth"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxQueryExportBytes caps the size of an uploaded query library
+const maxQueryExportBytes = 32 << 20
+
+// querySources are the tools whose query exports can be imported
+var querySources = []string{"metabase", "redash", "superset"}
+
+var (
+	// foreignPlaceholder matches the {{ ... }} placeholders of Metabase,
+	// Redash and Jinja, optionally quoted
+	foreignPlaceholder = regexp.MustCompile(`'?\{\{\s*([^{}]*?)\s*\}\}'?`)
+	// identifierChars are the runs of characters not allowed in a
+	// variable name
+	identifierChars = regexp.MustCompile(`[^a-z0-9_]+`)
+)
+
+// SavedQueryImportResult is the outcome for one query of an imported library
+type SavedQueryImportResult struct {
+	// ID is empty for skipped queries and dry runs
+	ID        string   `json:"id,omitempty"`
+	Name      string   `json:"name"`
+	Folder    string   `json:"folder,omitempty"`
+	Variables []string `json:"variables,omitempty"`
+	// Warnings tell what was dropped in the conversion
+	Warnings []string `json:"warnings,omitempty"`
+	// Skipped tells why the query wasn't imported
+	Skipped string `json:"skipped,omitempty"`
+}
+
+// foreignQuery is a query read out of another tool's export, with the
+// placeholders of its SQL still in that tool's syntax
+type foreignQuery struct {
+	Name        string
+	Description string
+	SQL         string
+	Folder      string
+	Tags        []string
+	// Params maps the expressions inside the SQL's placeholders to the
+	// variables they become
+	Params map[string]foreignParam
+	// Textual is set when the tool splices values into the SQL as text,
+	// so that '{{ name }}' stands for a string value
+	Textual bool
+	// Unsupported tells why the query can't be converted
+	Unsupported string
+	Warnings    []string
+}
+
+// foreignParam is the variable a placeholder becomes; Unsupported tells why
+// it can't become one
+type foreignParam struct {
+	Variable    QueryVariable
+	Unsupported string
+}
+
+// ImportSavedQueries creates saved queries from the query library exported
+// by Metabase (GET /api/card), Redash (GET /api/queries) or Superset (GET
+// /api/v1/saved_query/), detected from the body unless ?source names it.
+// Parameters become typed variables where the tool's semantics allow it;
+// queries relying on templating without an equivalent are skipped.
+// ?folder puts them in a folder and ?dry_run=true only reports the outcome.
+func (h *Handler) ImportSavedQueries(c *gin.Context) {
+	source := c.Query("source")
+	if source != "" && !slices.Contains(querySources, source) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "source must be one of " + strings.Join(querySources, ", ")})
+		return
+	}
+	var body any
+	if err := json.NewDecoder(http.MaxBytesReader(c.Writer, c.Request.Body, maxQueryExportBytes)).Decode(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid export: " + err.Error()})
+		return
+	}
+	items := exportItems(body)
+	if len(items) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Export contains no queries"})
+		return
+	}
+	if source == "" {
+		if source = detectQuerySource(items[0]); source == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Export format not recognized; set source to one of " + strings.Join(querySources, ", ")})
+			return
+		}
+	}
+
+	existing, err := h.savedQueries()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	taken := map[string]bool{}
+	for _, q := range existing {
+		taken[q.Folder+"/"+q.Name] = true
+	}
+
+	dryRun := c.Query("dry_run") == "true"
+	owner := auth.Principal(c)
+	results := []SavedQueryImportResult{}
+	imported := 0
+	for i, item := range items {
+		var fq foreignQuery
+		switch source {
+		case "metabase":
+			fq = parseMetabaseCard(item)
+		case "redash":
+			fq = parseRedashQuery(item)
+		case "superset":
+			fq = parseSupersetQuery(item)
+		}
+		if fq.Name == "" {
+			fq.Name = fmt.Sprintf("Imported %s query %d", source, i+1)
+		}
+		req, warnings, skipped := fq.convert()
+		req.Folder = cleanFolder(c.Query("folder") + "/" + fq.Folder)
+		req.Tags = fq.Tags
+		result := SavedQueryImportResult{Name: strings.TrimSpace(fq.Name), Folder: req.Folder, Warnings: warnings, Skipped: skipped}
+		for _, v := range req.Variables {
+			result.Variables = append(result.Variables, v.Name)
+		}
+		if result.Skipped == "" {
+			if err := req.validate(); err != nil {
+				result.Skipped = err.Error()
+			} else if key := req.Folder + "/" + strings.TrimSpace(req.Name); taken[key] {
+				result.Skipped = "A saved query with this name already exists in the folder"
+			} else {
+				taken[key] = true
+			}
+		}
+		if result.Skipped == "" && !dryRun {
+			now := time.Now()
+			q := SavedQuery{ID: newID(), Owner: owner, CreatedAt: now, UpdatedAt: now}
+			req.apply(&q)
+			q.ReferencedTables = h.referencedTables(q)
+			if err := h.meta.Put(savedQueryPrefix+q.ID, q); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "queries": results})
+				return
+			}
+			result.ID = q.ID
+		}
+		if result.Skipped == "" {
+			imported++
+		}
+		results = append(results, result)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"source":   source,
+		"dry_run":  dryRun,
+		"imported": imported,
+		"skipped":  len(results) - imported,
+		"queries":  results,
+	})
+}
+
+// exportItems unwraps the list of queries of an export: a bare list, one
+// query, or a list under the results (Redash) or result (Superset) key
+func exportItems(body any) []map[string]any {
+	if obj, ok := body.(map[string]any); ok {
+		if list, ok := obj["results"].([]any); ok {
+			body = list
+		} else if list, ok := obj["result"].([]any); ok {
+			body = list
+		} else {
+			return []map[string]any{obj}
+		}
+	}
+	list, _ := body.([]any)
+	var items []map[string]any
+	for _, item := range list {
+		if obj, ok := item.(map[string]any); ok {
+			items = append(items, obj)
+		}
+	}
+	return items
+}
+
+// detectQuerySource tells the tool an exported query comes from by its
+// fields
+func detectQuerySource(item map[string]any) string {
+	switch {
+	case item["dataset_query"] != nil:
+		return "metabase"
+	case item["query"] != nil && (item["options"] != nil || item["data_source_id"] != nil):
+		return "redash"
+	case item["sql"] != nil && (item["label"] != nil || item["template_parameters"] != nil):
+		return "superset"
+	}
+	return ""
+}
+
+// parseMetabaseCard reads a native question; its template tags become
+// variables, except field filters, card references and snippets, which
+// expand to SQL rather than a value
+func parseMetabaseCard(card map[string]any) foreignQuery {
+	fq := foreignQuery{
+		Name:        stringField(card, "name"),
+		Description: stringField(card, "description"),
+		Params:      map[string]foreignParam{},
+	}
+	if collection, ok := card["collection"].(map[string]any); ok {
+		fq.Folder = stringField(collection, "name")
+	}
+
+	// Older versions keep the SQL under native, newer ones in the first
+	// stage of the query
+	query, _ := card["dataset_query"].(map[string]any)
+	var tags map[string]any
+	if native, ok := query["native"].(map[string]any); ok {
+		fq.SQL = stringField(native, "query")
+		tags, _ = native["template-tags"].(map[string]any)
+	} else if stages, ok := query["stages"].([]any); ok && len(stages) > 0 {
+		stage, _ := stages[0].(map[string]any)
+		fq.SQL = stringField(stage, "native")
+		tags, _ = stage["template-tags"].(map[string]any)
+	}
+	if fq.SQL == "" {
+		fq.Unsupported = "Question is built with the query builder, not SQL"
+		return fq
+	}
+	if strings.Contains(fq.SQL, "[[") {
+		fq.Unsupported = "Optional clauses ([[ ... ]]) have no equivalent"
+		return fq
+	}
+
+	for name, raw := range tags {
+		tag, _ := raw.(map[string]any)
+		v := QueryVariable{Name: name, Description: stringField(tag, "display-name"), Default: tag["default"]}
+		// Recent versions keep defaults as lists
+		if list, ok := v.Default.([]any); ok && len(list) == 1 {
+			v.Default = list[0]
+		}
+		switch t := stringField(tag, "type"); t {
+		case "text":
+			v.Type = "string"
+		case "number":
+			v.Type = "number"
+		case "date":
+			v.Type = "date"
+		case "dimension", "temporal-unit":
+			fq.Params[name] = foreignParam{Unsupported: fmt.Sprintf("Field filter {{%s}} has no equivalent", name)}
+			continue
+		default:
+			fq.Params[name] = foreignParam{Unsupported: fmt.Sprintf("%s tag {{%s}} has no equivalent", t, name)}
+			continue
+		}
+		fq.Params[name] = foreignParam{Variable: v}
+	}
+	return fq
+}
+
+// parseRedashQuery reads a Redash query, whose parameters are spliced into
+// the SQL as text. Date ranges become a variable for each end.
+func parseRedashQuery(query map[string]any) foreignQuery {
+	fq := foreignQuery{
+		Name:        stringField(query, "name"),
+		Description: stringField(query, "description"),
+		SQL:         stringField(query, "query"),
+		Params:      map[string]foreignParam{},
+		Textual:     true,
+	}
+	if tags, ok := query["tags"].([]any); ok {
+		for _, tag := range tags {
+			if s, ok := tag.(string); ok {
+				fq.Tags = append(fq.Tags, s)
+			}
+		}
+	}
+
+	options, _ := query["options"].(map[string]any)
+	params, _ := options["parameters"].([]any)
+	for _, raw := range params {
+		p, _ := raw.(map[string]any)
+		name := stringField(p, "name")
+		v := QueryVariable{Name: name, Description: stringField(p, "title"), Default: p["value"]}
+		switch t := stringField(p, "type"); t {
+		case "text", "enum", "query":
+			if p["multiValuesOptions"] != nil {
+				fq.Params[name] = foreignParam{Unsupported: fmt.Sprintf("Multi-value parameter {{ %s }} has no equivalent", name)}
+				continue
+			}
+			v.Type = "string"
+		case "number":
+			v.Type = "number"
+		case "date":
+			v.Type = "date"
+		case "datetime-local", "datetime-with-seconds":
+			v.Type = "timestamp"
+		case "date-range", "datetime-range", "datetime-range-with-seconds":
+			ends, _ := v.Default.(map[string]any)
+			for _, end := range []string{"start", "end"} {
+				bound := v
+				bound.Name = name + "_" + end
+				bound.Type = "date"
+				if t != "date-range" {
+					bound.Type = "timestamp"
+				}
+				bound.Default = ends[end]
+				fq.Params[name+"."+end] = foreignParam{Variable: bound}
+			}
+			continue
+		default:
+			fq.Params[name] = foreignParam{Unsupported: fmt.Sprintf("%s parameter {{ %s }} has no equivalent", t, name)}
+			continue
+		}
+		fq.Params[name] = foreignParam{Variable: v}
+	}
+	return fq
+}
+
+// parseSupersetQuery reads a SQL Lab saved query. Its Jinja placeholders
+// become variables typed after their default in template_parameters;
+// statements, filters and macro calls have no equivalent.
+func parseSupersetQuery(query map[string]any) foreignQuery {
+	fq := foreignQuery{
+		Name:        stringField(query, "label"),
+		Description: stringField(query, "description"),
+		SQL:         stringField(query, "sql"),
+		Params:      map[string]foreignParam{},
+		Textual:     true,
+	}
+	if strings.Contains(fq.SQL, "{%") {
+		fq.Unsupported = "Jinja statements ({% ... %}) have no equivalent"
+		return fq
+	}
+
+	// template_parameters is a JSON document in a string
+	defaults := map[string]any{}
+	switch p := query["template_parameters"].(type) {
+	case string:
+		if strings.TrimSpace(p) != "" && json.Unmarshal([]byte(p), &defaults) != nil {
+			fq.Warnings = append(fq.Warnings, "template_parameters is not a JSON object; defaults were dropped")
+		}
+	case map[string]any:
+		defaults = p
+	}
+	for name, value := range defaults {
+		v := QueryVariable{Name: name, Type: "string", Default: value}
+		switch d := value.(type) {
+		case float64:
+			v.Type = "number"
+			if d == math.Trunc(d) {
+				v.Type = "integer"
+			}
+		case bool:
+			v.Type = "boolean"
+		case string:
+		default:
+			v.Default = nil
+		}
+		fq.Params[name] = foreignParam{Variable: v}
+	}
+	return fq
+}
+
+// convert rewrites the query's placeholders as {{name}} references to the
+// variables they map to, returning why the query can't be imported if it
+// can't
+func (fq foreignQuery) convert() (SavedQueryRequest, []string, string) {
+	req := SavedQueryRequest{Name: fq.Name, Description: fq.Description}
+	warnings := fq.Warnings
+	if fq.Unsupported != "" {
+		return req, warnings, fq.Unsupported
+	}
+
+	names := map[string]string{} // placeholder expression → variable name
+	taken := map[string]bool{}
+	unsupported := ""
+	sqlText := foreignPlaceholder.ReplaceAllStringFunc(fq.SQL, func(m string) string {
+		expr := foreignPlaceholder.FindStringSubmatch(m)[1]
+		quoted := strings.HasPrefix(m, "'") && strings.HasSuffix(m, "'") && len(m) > 1
+		// Only a value spliced in as a whole string literal can be bound
+		if strings.HasPrefix(m, "'") != strings.HasSuffix(m, "'") || quoted && !fq.Textual {
+			unsupported = fmt.Sprintf("{{ %s }} is part of a string literal", expr)
+			return m
+		}
+		name, ok := names[expr]
+		if !ok {
+			param, declared := fq.Params[expr]
+			switch {
+			case param.Unsupported != "":
+				unsupported = param.Unsupported
+				return m
+			case !declared && !fq.Textual:
+				unsupported = fmt.Sprintf("{{ %s }} is not a declared parameter", expr)
+				return m
+			case !declared && !sqlVariableName.MatchString(strings.ToLower(expr)):
+				unsupported = fmt.Sprintf("Template expression {{ %s }} has no equivalent", expr)
+				return m
+			case !declared:
+				param.Variable = QueryVariable{Name: expr, Type: "string"}
+				warnings = append(warnings, fmt.Sprintf("{{ %s }} has no declared type or default; imported as a required string", expr))
+			}
+
+			v := param.Variable
+			v.Name = variableName(v.Name, taken)
+			// A quoted value is text, whatever the default looks like
+			if quoted && (v.Type == "integer" || v.Type == "number" || v.Type == "boolean") {
+				v.Type = "string"
+			}
+			if v.Default != nil {
+				if _, err := v.convert(v.Default); err != nil {
+					warnings = append(warnings, fmt.Sprintf("Default of %s dropped: %s", v.Name, err))
+					v.Default = nil
+				}
+			}
+			taken[v.Name] = true
+			names[expr] = v.Name
+			req.Variables = append(req.Variables, v)
+			name = v.Name
+		}
+		return "{{" + name + "}}"
+	})
+	if unsupported != "" {
+		return req, warnings, unsupported
+	}
+	if insideStringLiteral(sqlText, sqlVariableReference) {
+		return req, warnings, "A parameter is part of a string literal"
+	}
+	req.SQL = sqlText
+	return req, warnings, ""
+}
+
+// variableName turns a parameter name into a valid variable name not yet
+// taken
+func variableName(name string, taken map[string]bool) string {
+	name = strings.Trim(identifierChars.ReplaceAllString(strings.ToLower(name), "_"), "_")
+	if name == "" || name[0] >= '0' && name[0] <= '9' {
+		name = "p_" + name
+	}
+	for base, n := name, 2; taken[name]; n++ {
+		name = fmt.Sprintf("%s_%d", base, n)
+	}
+	return name
+}
+
+// insideStringLiteral reports whether a match of re lies within a
+// single-quoted literal, where it would be text rather than a bound value
+func insideStringLiteral(sqlText string, re *regexp.Regexp) bool {
+	for _, loc := range re.FindAllStringIndex(sqlText, -1) {
+		// Doubled quotes escape a quote and leave the count even
+		if strings.Count(sqlText[:loc[0]], "'")%2 == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+func stringField(obj map[string]any, key string) string {
+	s, _ := obj[key].(string)
+	return s
+}
@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+
+	"sql-engine/rbac"
+
+	sqlparser "github.com/blastrain/vitess-sqlparser/sqlparser"
+	"github.com/gin-gonic/gin"
+)
+
+// savedQueryStatement parses the SQL of a saved query with its variables
+// replaced by bind markers, so that it can be analyzed without values
+func (h *Handler) savedQueryStatement(q SavedQuery) (sqlparser.Statement, error) {
+	sqlText := sqlVariableReference.ReplaceAllStringFunc(q.SQL, func(m string) string {
+		name := sqlVariableReference.FindStringSubmatch(m)[1]
+		if slices.ContainsFunc(q.Variables, func(v QueryVariable) bool { return v.Name == name }) {
+			return queryVariableMarker + "1"
+		}
+		return m
+	})
+	sqlText, err := h.expandSQLVariables(sqlText)
+	if err != nil {
+		return nil, err
+	}
+	return h.dialect.ParseStatement(sqlText)
+}
+
+// referencedTables returns the cached definitions of the tables a saved
+// query reads, kept with the query to check it against later. Names that
+// aren't tables, such as CTEs, are left out.
+func (h *Handler) referencedTables(q SavedQuery) []TableSchema {
+	stmt, err := h.savedQueryStatement(q)
+	if err != nil {
+		return nil
+	}
+	var tables []TableSchema
+	for _, ref := range analyzeStatement(stmt).Tables {
+		if _, ok := findTableSchema(tables, orDefault(h.dialect, ref.Schema), ref.Name); ok {
+			continue
+		}
+		snap, err := h.cachedSchema(ref.Schema)
+		if err != nil {
+			return nil
+		}
+		if t, ok := findTableSchema(snap.Schema, orDefault(h.dialect, ref.Schema), ref.Name); ok {
+			t.Freshness, t.DBT = nil, nil
+			tables = append(tables, t)
+		}
+	}
+	return tables
+}
+
+// checkSavedQuerySchema compares the tables and columns a saved query reads
+// with the cached schema before it runs. If any were dropped since the query
+// was saved, it returns a 409 naming them, with the changes to the query's
+// tables since then, instead of the database's error. Queries saved before
+// their tables were recorded aren't checked.
+func (h *Handler) checkSavedQuerySchema(ctx context.Context, q SavedQuery) error {
+	if len(q.ReferencedTables) == 0 {
+		return nil
+	}
+	stmt, err := h.savedQueryStatement(q)
+	if err != nil {
+		// Left for the run to report
+		return nil
+	}
+	refs := analyzeStatement(stmt)
+	dropped, _, err := h.droppedReferences(q.ReferencedTables, refs, h.cachedSchema)
+	if err != nil || len(dropped) == 0 {
+		return nil
+	}
+	// The cached copy may predate the column coming back
+	dropped, current, err := h.droppedReferences(q.ReferencedTables, refs, h.reloadSchema)
+	if err != nil || len(dropped) == 0 {
+		return nil
+	}
+
+	access := rbac.FromContext(ctx)
+	diffs := map[string]SchemaDiff{}
+	for _, t := range q.ReferencedTables {
+		if _, ok := diffs[t.Schema]; ok {
+			continue
+		}
+		inSchema := func(tables []TableSchema) []TableSchema {
+			return slices.DeleteFunc(slices.Clone(tables), func(other TableSchema) bool { return other.Schema != t.Schema })
+		}
+		diffs[t.Schema] = h.diffSchemas(h.filterSchema(access, inSchema(q.ReferencedTables)), h.filterSchema(access, inSchema(current)), t.Schema)
+	}
+	return &QueryError{
+		Status:  http.StatusConflict,
+		Message: "Saved query references dropped " + strings.Join(dropped, ", "),
+		Details: gin.H{
+			"dropped":     dropped,
+			"saved_at":    q.UpdatedAt,
+			"schema_diff": diffs,
+		},
+	}
+}
+
+// droppedReferences lists the tables and columns read by a statement that
+// were among the saved tables but are missing from the schemas returned by
+// load, along with the current versions of the saved tables
+func (h *Handler) droppedReferences(saved []TableSchema, refs queryRefs, load func(string) (*SchemaSnapshot, error)) ([]string, []TableSchema, error) {
+	var current []TableSchema
+	loaded := map[string]bool{}
+	for _, t := range saved {
+		if loaded[t.Schema] {
+			continue
+		}
+		loaded[t.Schema] = true
+		snap, err := load(t.Schema)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, other := range saved {
+			if other.Schema != t.Schema {
+				continue
+			}
+			if now, ok := findTableSchema(snap.Schema, t.Schema, other.Name); ok {
+				current = append(current, now)
+			}
+		}
+	}
+
+	var dropped []string
+	add := func(kind, name string) {
+		if !slices.Contains(dropped, kind+" "+name) {
+			dropped = append(dropped, kind+" "+name)
+		}
+	}
+	for _, ref := range refs.Tables {
+		schema := orDefault(h.dialect, ref.Schema)
+		if _, ok := findTableSchema(saved, schema, ref.Name); !ok {
+			continue
+		}
+		if _, ok := findTableSchema(current, schema, ref.Name); !ok {
+			add("table", ref.Name)
+		}
+	}
+	for _, col := range refs.Columns {
+		if col.Table != "" {
+			schema := orDefault(h.dialect, col.Schema)
+			was, _ := findTableSchema(saved, schema, col.Table)
+			now, ok := findTableSchema(current, schema, col.Table)
+			if ok && hasColumn(was, col.Name) && !hasColumn(now, col.Name) {
+				add("column", col.Table+"."+col.Name)
+			}
+			continue
+		}
+		// An unqualified column is dropped if one of the tables had it and
+		// none has it now
+		var had, has bool
+		for _, ref := range refs.Tables {
+			schema := orDefault(h.dialect, ref.Schema)
+			was, _ := findTableSchema(saved, schema, ref.Name)
+			now, _ := findTableSchema(current, schema, ref.Name)
+			had = had || hasColumn(was, col.Name)
+			has = has || hasColumn(now, col.Name)
+		}
+		if had && !has {
+			add("column", col.Name)
+		}
+	}
+	return dropped, current, nil
+}
+
+func findTableSchema(tables []TableSchema, schema, name string) (TableSchema, bool) {
+	i := slices.IndexFunc(tables, func(t TableSchema) bool { return t.Schema == schema && t.Name == name })
+	if i < 0 {
+		return TableSchema{}, false
+	}
+	return tables[i], true
+}
+
+func hasColumn(t TableSchema, name string) bool {
+	return slices.ContainsFunc(t.Columns, func(c ColumnInfo) bool { return c.Name == name })
+}
@@ -3,14 +3,26 @@ package handlers
 import (
 	"database/sql"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"sql-engine/rbac"
 
 	"github.com/gin-gonic/gin"
 )
 
 // TableInfo represents basic table information
 type TableInfo struct {
-	Name string `json:"name"`
-	Type string `json:"type"`
+	Schema  string `json:"schema"`
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Comment string `json:"comment,omitempty"`
+	// Partitioned marks the parent of a partitioned table
+	Partitioned bool            `json:"partitioned,omitempty"`
+	Freshness   *TableFreshness `json:"freshness,omitempty"`
+	DBT         *DBTModel       `json:"dbt,omitempty"`
 }
 
 // ColumnInfo represents column information
@@ -22,323 +34,411 @@ type ColumnInfo struct {
 	MaxLength        *int    `json:"max_length"`
 	NumericPrecision *int    `json:"numeric_precision"`
 	NumericScale     *int    `json:"numeric_scale"`
+	Description      string  `json:"description,omitempty"`
+	// Comment is the comment stored in the database, while Description
+	// comes from dbt
+	Comment string `json:"comment,omitempty"`
+	// UserType references the enum, domain or other user-defined type of
+	// the column, listed by /types
+	UserType *TypeRef `json:"user_type,omitempty"`
+	// EnumLabels are the values allowed by an enum column, in order
+	EnumLabels []string `json:"enum_labels,omitempty"`
 }
 
 // ForeignKeyInfo represents foreign key information
 type ForeignKeyInfo struct {
 	Column        string `json:"column"`
+	ForeignSchema string `json:"foreign_schema"`
 	ForeignTable  string `json:"foreign_table"`
 	ForeignColumn string `json:"foreign_column"`
 }
 
+// IndexInfo represents an index of a table. Size and usage statistics are
+// nil when the database doesn't track them.
+type IndexInfo struct {
+	Name string `json:"name"`
+	// Columns lists the key columns; expressions are shown as their SQL
+	Columns       []string `json:"columns"`
+	Unique        bool     `json:"unique"`
+	Primary       bool     `json:"primary"`
+	Method        string   `json:"method"`
+	Definition    string   `json:"definition"`
+	SizeBytes     *int64   `json:"size_bytes"`
+	Scans         *int64   `json:"scans"`
+	TuplesRead    *int64   `json:"tuples_read"`
+	TuplesFetched *int64   `json:"tuples_fetched"`
+}
+
 // TableSchema represents complete table schema
 type TableSchema struct {
+	Schema      string           `json:"schema"`
 	Name        string           `json:"name"`
+	Comment     string           `json:"comment,omitempty"`
 	Columns     []ColumnInfo     `json:"columns"`
 	PrimaryKeys []string         `json:"primary_keys"`
 	ForeignKeys []ForeignKeyInfo `json:"foreign_keys"`
+	Constraints []ConstraintInfo `json:"constraints"`
+	FullText    []FullTextSource `json:"full_text,omitempty"`
+	Freshness   *TableFreshness  `json:"freshness,omitempty"`
+	DBT         *DBTModel        `json:"dbt,omitempty"`
 }
 
 func (h *Handler) GetDatabases(c *gin.Context) {
-	rows, err := h.db.Query(`
-		SELECT datname 
-		FROM pg_database 
-		WHERE datistemplate = false 
-		ORDER BY datname
-	`)
+	databases, err := h.dialect.ListDatabases(h.db)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	defer rows.Close()
 
-	var databases []string
-	for rows.Next() {
-		var dbName string
-		if err := rows.Scan(&dbName); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-		databases = append(databases, dbName)
+	c.JSON(http.StatusOK, gin.H{"databases": databases})
+}
+
+// GetSchemas lists the schemas holding user tables
+func (h *Handler) GetSchemas(c *gin.Context) {
+	schemas, err := h.dialect.ListSchemas(h.db)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"databases": databases})
+	h.render(c, gin.H{
+		"schemas": schemas,
+		"default": h.dialect.DefaultSchema(),
+	}, nil)
 }
 
 func (h *Handler) GetTables(c *gin.Context) {
-	rows, err := h.db.Query(`
-		SELECT table_name, table_type 
-		FROM information_schema.tables 
-		WHERE table_schema = 'public' 
-		ORDER BY table_name
-	`)
+	schema, ok := h.schemaParam(c)
+	if !ok {
+		return
+	}
+	tables, err := h.dialect.ListTables(h.db, schema)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	defer rows.Close()
 
-	var tables []TableInfo
-	for rows.Next() {
-		var table TableInfo
-		if err := rows.Scan(&table.Name, &table.Type); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
+	tables = filterTables(rbac.FromContext(c.Request.Context()), h.grantSchema(schema), tables)
+	if h.isDefaultSchema(schema) {
+		freshness := h.tableFreshness()
+		models, _ := h.dbtModels()
+		for i := range tables {
+			tables[i].Freshness = freshness[tables[i].Name]
+			tables[i].DBT = models[strings.ToLower(tables[i].Name)]
 		}
-		tables = append(tables, table)
 	}
 
-	c.JSON(http.StatusOK, gin.H{"tables": tables})
+	h.render(c, gin.H{"schema": schema, "tables": tables}, nil)
 }
 
 func (h *Handler) GetTableColumns(c *gin.Context) {
+	schema, ok := h.schemaParam(c)
+	if !ok {
+		return
+	}
 	tableName := c.Param("name")
+	if !h.requireTable(c, schema, tableName) {
+		return
+	}
 
-	rows, err := h.db.Query(`
-		SELECT 
-			column_name,
-			data_type,
-			is_nullable,
-			column_default,
-			character_maximum_length,
-			numeric_precision,
-			numeric_scale
-		FROM information_schema.columns 
-		WHERE table_schema = 'public' AND table_name = $1 
-		ORDER BY ordinal_position
-	`, tableName)
+	columns, err := h.dialect.ListColumns(h.db, schema, tableName)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	defer rows.Close()
-
-	var columns []ColumnInfo
-	for rows.Next() {
-		var col ColumnInfo
-		var maxLen, precision, scale sql.NullInt64
-		var def sql.NullString
-
-		if err := rows.Scan(
-			&col.Name, &col.DataType, &col.IsNullable, &def,
-			&maxLen, &precision, &scale,
-		); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-
-		if def.Valid {
-			col.Default = &def.String
-		}
-		if maxLen.Valid {
-			val := int(maxLen.Int64)
-			col.MaxLength = &val
-		}
-		if precision.Valid {
-			val := int(precision.Int64)
-			col.NumericPrecision = &val
-		}
-		if scale.Valid {
-			val := int(scale.Int64)
-			col.NumericScale = &val
-		}
-
-		columns = append(columns, col)
+	columns = filterColumns(rbac.FromContext(c.Request.Context()), h.grantSchema(schema), tableName, columns)
+	if h.isDefaultSchema(schema) {
+		columns = h.describeTableColumns(tableName, columns)
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	h.render(c, gin.H{
+		"schema":     schema,
 		"table_name": tableName,
 		"columns":    columns,
-	})
+	}, nil)
 }
 
 func (h *Handler) GetTablePrimaryKeys(c *gin.Context) {
+	schema, ok := h.schemaParam(c)
+	if !ok {
+		return
+	}
 	tableName := c.Param("name")
+	if !h.requireTable(c, schema, tableName) {
+		return
+	}
 
-	rows, err := h.db.Query(`
-		SELECT 
-			column_name
-		FROM information_schema.key_column_usage
-		WHERE table_schema = 'public' 
-			AND table_name = $1 
-			AND constraint_name IN (
-				SELECT constraint_name 
-				FROM information_schema.table_constraints 
-				WHERE constraint_type = 'PRIMARY KEY'
-			)
-		ORDER BY ordinal_position
-	`, tableName)
+	primaryKeys, err := h.dialect.ListPrimaryKeys(h.db, schema, tableName)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	defer rows.Close()
-
-	var primaryKeys []string
-	for rows.Next() {
-		var colName string
-		if err := rows.Scan(&colName); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
-		}
-		primaryKeys = append(primaryKeys, colName)
-	}
+	primaryKeys = filterPrimaryKeys(rbac.FromContext(c.Request.Context()), h.grantSchema(schema), tableName, primaryKeys)
 
-	c.JSON(http.StatusOK, gin.H{
+	h.render(c, gin.H{
+		"schema":       schema,
 		"table_name":   tableName,
 		"primary_keys": primaryKeys,
-	})
+	}, nil)
 }
 
 func (h *Handler) GetTableForeignKeys(c *gin.Context) {
+	schema, ok := h.schemaParam(c)
+	if !ok {
+		return
+	}
 	tableName := c.Param("name")
+	if !h.requireTable(c, schema, tableName) {
+		return
+	}
 
-	rows, err := h.db.Query(`
-		SELECT
-			kcu.column_name,
-			ccu.table_name AS foreign_table_name,
-			ccu.column_name AS foreign_column_name
-		FROM information_schema.key_column_usage kcu
-		JOIN information_schema.referential_constraints rc
-			ON kcu.constraint_name = rc.constraint_name
-		JOIN information_schema.constraint_column_usage ccu
-			ON rc.unique_constraint_name = ccu.constraint_name
-		WHERE kcu.table_schema = 'public' 
-			AND kcu.table_name = $1
-		ORDER BY kcu.column_name
-	`, tableName)
+	foreignKeys, err := h.dialect.ListForeignKeys(h.db, schema, tableName)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	defer rows.Close()
 
-	var foreignKeys []ForeignKeyInfo
-	for rows.Next() {
-		var fk ForeignKeyInfo
-		if err := rows.Scan(&fk.Column, &fk.ForeignTable, &fk.ForeignColumn); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	h.render(c, gin.H{
+		"schema":       schema,
+		"table_name":   tableName,
+		"foreign_keys": h.filterForeignKeys(rbac.FromContext(c.Request.Context()), schema, tableName, foreignKeys),
+	}, nil)
+}
+
+func (h *Handler) GetTableIndexes(c *gin.Context) {
+	schema, ok := h.schemaParam(c)
+	if !ok {
+		return
+	}
+	tableName := c.Param("name")
+	if !h.requireTable(c, schema, tableName) {
+		return
+	}
+
+	indexes, err := h.dialect.ListIndexes(h.db, schema, tableName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	indexes = filterIndexes(rbac.FromContext(c.Request.Context()), h.grantSchema(schema), tableName, indexes)
+	if indexes == nil {
+		indexes = []IndexInfo{}
+	}
+
+	h.render(c, gin.H{
+		"schema":     schema,
+		"table_name": tableName,
+		"indexes":    indexes,
+	}, nil)
+}
+
+// GetFullSchema returns the tables of a schema with their columns, keys and
+// constraints, as JSON, YAML or, for Accept: application/sql, CREATE TABLE
+// statements
+func (h *Handler) GetFullSchema(c *gin.Context) {
+	access := rbac.FromContext(c.Request.Context())
+
+	schema, ok := h.schemaParam(c)
+	if !ok {
+		return
+	}
+
+	// Only the default schema is persisted and enriched
+	if h.isDefaultSchema(schema) {
+		if snap, ok := h.staleSchema(); ok {
+			c.Header("Cache-Control", "no-store")
+			tables := h.enrichSchema(h.filterSchema(access, snap.Schema))
+			h.render(c, gin.H{
+				"schema":     tables,
+				"stale":      true,
+				"fetched_at": snap.FetchedAt,
+			}, func() string { return h.schemaDDL(tables) })
 			return
 		}
-		foreignKeys = append(foreignKeys, fk)
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"table_name":   tableName,
-		"foreign_keys": foreignKeys,
-	})
+	snap, err := h.cachedSchema(schema)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	tables := h.filterSchema(access, snap.Schema)
+	if h.isDefaultSchema(schema) {
+		tables = h.enrichSchema(tables)
+	}
+	c.Header("Last-Modified", snap.FetchedAt.UTC().Format(http.TimeFormat))
+	h.render(c, gin.H{"schema": tables}, func() string { return h.schemaDDL(tables) })
 }
 
-func (h *Handler) GetFullSchema(c *gin.Context) {
-	// Get all tables
-	tableRows, err := h.db.Query(`
-		SELECT table_name 
-		FROM information_schema.tables 
-		WHERE table_schema = 'public' 
-		ORDER BY table_name
-	`)
+// RefreshSchema introspects a schema now instead of waiting for its cached
+// copy to expire, e.g. after a migration
+func (h *Handler) RefreshSchema(c *gin.Context) {
+	schema, ok := h.schemaParam(c)
+	if !ok {
+		return
+	}
+	snap, err := h.reloadSchema(schema)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	defer tableRows.Close()
+	c.JSON(http.StatusOK, gin.H{
+		"schema":     orDefault(h.dialect, schema),
+		"tables":     len(h.filterSchema(rbac.FromContext(c.Request.Context()), snap.Schema)),
+		"fetched_at": snap.FetchedAt,
+	})
+}
 
-	var tables []string
-	for tableRows.Next() {
-		var tableName string
-		if err := tableRows.Scan(&tableName); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
+// enrichSchema returns a copy of schema with freshness and dbt metadata
+// attached
+func (h *Handler) enrichSchema(schema []TableSchema) []TableSchema {
+	freshness := h.tableFreshness()
+	models, _ := h.dbtModels()
+	out := make([]TableSchema, len(schema))
+	for i, t := range schema {
+		t.Freshness = freshness[t.Name]
+		if m := models[strings.ToLower(t.Name)]; m != nil {
+			t.DBT = m
+			t.Columns = describeColumns(m, t.Columns)
 		}
-		tables = append(tables, tableName)
+		out[i] = t
 	}
+	return out
+}
 
-	var schema []TableSchema
-	for _, table := range tables {
-		tableSchema, err := h.getTableSchema(table)
-		if err != nil {
-			continue // Skip tables that can't be read
+// describeTableColumns attaches dbt descriptions to the columns of a table
+func (h *Handler) describeTableColumns(table string, columns []ColumnInfo) []ColumnInfo {
+	models, _ := h.dbtModels()
+	return describeColumns(models[strings.ToLower(table)], columns)
+}
+
+// describeColumns returns a copy of columns with dbt column descriptions
+func describeColumns(m *DBTModel, columns []ColumnInfo) []ColumnInfo {
+	out := make([]ColumnInfo, len(columns))
+	for i, col := range columns {
+		if m != nil {
+			col.Description = m.Columns[strings.ToLower(col.Name)]
 		}
-		schema = append(schema, tableSchema)
+		out[i] = col
+	}
+	return out
+}
+
+func nullableInt64(n sql.NullInt64) *int64 {
+	if !n.Valid {
+		return nil
+	}
+	return &n.Int64
+}
+
+// schemaParam returns the schema named by the "schema" query parameter, or
+// the dialect's default schema. Unknown schemas are answered with a 404.
+func (h *Handler) schemaParam(c *gin.Context) (string, bool) {
+	schema := c.Query("schema")
+	if ws := workspaceFrom(c.Request.Context()); schema == "" && ws != nil {
+		schema = ws.Schema
+	}
+	schema = orDefault(h.dialect, schema)
+	if h.isDefaultSchema(schema) {
+		return schema, true
+	}
+	schemas, err := h.dialect.ListSchemas(h.db)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return "", false
+	}
+	if !slices.Contains(schemas, schema) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Schema " + schema + " not found"})
+		return "", false
+	}
+	return schema, true
+}
+
+// intQuery reads a non-negative integer query parameter, capped at max.
+// Invalid values are answered with a 400.
+func intQuery(c *gin.Context, name string, def, max int) (int, bool) {
+	s := c.Query(name)
+	if s == "" {
+		return min(def, max), true
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": name + " must be a non-negative integer"})
+		return 0, false
 	}
+	return min(n, max), true
+}
 
-	c.JSON(http.StatusOK, gin.H{"schema": schema})
+func (h *Handler) isDefaultSchema(schema string) bool {
+	return schema == "" || schema == h.dialect.DefaultSchema()
 }
 
-func (h *Handler) getTableSchema(tableName string) (TableSchema, error) {
+// loadFullSchema introspects the tables of a schema, schema_cache.concurrency
+// at a time, in the order ListTables returns them
+func (h *Handler) loadFullSchema(schemaName string) ([]TableSchema, error) {
+	tables, err := h.dialect.ListTables(h.db, schemaName)
+	if err != nil {
+		return nil, err
+	}
+
+	loaded := make([]TableSchema, len(tables))
+	failed := make([]bool, len(tables))
+	slots := make(chan struct{}, max(h.cfg.SchemaCache.Concurrency, 1))
+	var wg sync.WaitGroup
+	for i, table := range tables {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() { <-slots; wg.Done() }()
+			t, err := h.getTableSchema(table.Schema, table.Name)
+			loaded[i], failed[i] = t, err != nil
+		}()
+	}
+	wg.Wait()
+
+	var schema []TableSchema
+	for i, t := range loaded {
+		if !failed[i] { // Skip tables that can't be read
+			schema = append(schema, t)
+		}
+	}
+	return schema, nil
+}
+
+func (h *Handler) getTableSchema(schemaName, tableName string) (TableSchema, error) {
 	var schema TableSchema
+	schema.Schema = orDefault(h.dialect, schemaName)
 	schema.Name = tableName
 
 	// Get columns
-	colRows, err := h.db.Query(`
-		SELECT 
-			column_name,
-			data_type,
-			is_nullable,
-			column_default
-		FROM information_schema.columns 
-		WHERE table_schema = 'public' AND table_name = $1 
-		ORDER BY ordinal_position
-	`, tableName)
+	columns, err := h.dialect.ListColumns(h.db, schemaName, tableName)
 	if err != nil {
 		return schema, err
 	}
-	defer colRows.Close()
-
-	for colRows.Next() {
-		var col ColumnInfo
-		var def sql.NullString
-
-		colRows.Scan(&col.Name, &col.DataType, &col.IsNullable, &def)
+	schema.Columns = columns
 
-		if def.Valid {
-			col.Default = &def.String
-		}
-		schema.Columns = append(schema.Columns, col)
+	if comment, err := h.dialect.TableComment(h.db, schemaName, tableName); err == nil {
+		schema.Comment = comment
 	}
 
 	// Get primary keys
-	pkRows, err := h.db.Query(`
-		SELECT column_name
-		FROM information_schema.key_column_usage
-		WHERE table_schema = 'public' 
-			AND table_name = $1 
-			AND constraint_name IN (
-				SELECT constraint_name 
-				FROM information_schema.table_constraints 
-				WHERE constraint_type = 'PRIMARY KEY'
-			)
-	`, tableName)
-	if err == nil {
-		defer pkRows.Close()
-		for pkRows.Next() {
-			var colName string
-			pkRows.Scan(&colName)
-			schema.PrimaryKeys = append(schema.PrimaryKeys, colName)
-		}
+	if primaryKeys, err := h.dialect.ListPrimaryKeys(h.db, schemaName, tableName); err == nil {
+		schema.PrimaryKeys = primaryKeys
 	}
 
 	// Get foreign keys
-	fkRows, err := h.db.Query(`
-		SELECT
-			kcu.column_name,
-			ccu.table_name AS foreign_table_name,
-			ccu.column_name AS foreign_column_name
-		FROM information_schema.key_column_usage kcu
-		JOIN information_schema.referential_constraints rc
-			ON kcu.constraint_name = rc.constraint_name
-		JOIN information_schema.constraint_column_usage ccu
-			ON rc.unique_constraint_name = ccu.constraint_name
-		WHERE kcu.table_schema = 'public' 
-			AND kcu.table_name = $1
-	`, tableName)
-	if err == nil {
-		defer fkRows.Close()
-		for fkRows.Next() {
-			var fk ForeignKeyInfo
-			fkRows.Scan(&fk.Column, &fk.ForeignTable, &fk.ForeignColumn)
-			schema.ForeignKeys = append(schema.ForeignKeys, fk)
-		}
+	if foreignKeys, err := h.dialect.ListForeignKeys(h.db, schemaName, tableName); err == nil {
+		schema.ForeignKeys = foreignKeys
+	}
+
+	// Get check, unique and exclusion constraints
+	if constraints, err := h.dialect.ListConstraints(h.db, schemaName, tableName); err == nil {
+		schema.Constraints = constraints
+	}
+
+	// Detect tsvector columns and full-text indexes
+	if indexes, err := h.dialect.ListIndexes(h.db, schemaName, tableName); err == nil {
+		schema.FullText = fullTextSources(columns, indexes)
 	}
 
 	return schema, nil
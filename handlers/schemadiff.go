@@ -0,0 +1,445 @@
+package handlers
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"sql-engine/auth"
+	"sql-engine/rbac"
+	"sql-engine/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+const namedSnapshotPrefix = "schema/snapshots/"
+
+// SchemaSource is either a schema of the connected database or a stored
+// snapshot: "latest" for the one kept by the server, or the name of one
+// saved with POST /schema/snapshots
+type SchemaSource struct {
+	Schema   string `json:"schema,omitempty"`
+	Snapshot string `json:"snapshot,omitempty"`
+}
+
+// SchemaDiffRequest compares two schemas. Statements turn From into To.
+type SchemaDiffRequest struct {
+	From SchemaSource `json:"from"`
+	To   SchemaSource `json:"to"`
+}
+
+// SchemaSnapshotRequest saves the current state of a schema under a name
+type SchemaSnapshotRequest struct {
+	Name   string `json:"name"`
+	Schema string `json:"schema"`
+}
+
+// SchemaDiff lists what differs between two schemas. Tables are matched by
+// name, so two schemas of the same database can be compared.
+type SchemaDiff struct {
+	AddedTables   []TableSchema `json:"added_tables"`
+	RemovedTables []string      `json:"removed_tables"`
+	ChangedTables []TableDiff   `json:"changed_tables"`
+	// Statements are written for PostgreSQL; SQLite only runs those
+	// creating and dropping tables and columns
+	Statements []string `json:"statements"`
+}
+
+// TableDiff lists the changes within a table present on both sides
+type TableDiff struct {
+	Name               string           `json:"name"`
+	AddedColumns       []ColumnInfo     `json:"added_columns,omitempty"`
+	RemovedColumns     []string         `json:"removed_columns,omitempty"`
+	ChangedColumns     []ColumnChange   `json:"changed_columns,omitempty"`
+	PrimaryKey         *KeyChange       `json:"primary_key,omitempty"`
+	AddedForeignKeys   []ForeignKeyInfo `json:"added_foreign_keys,omitempty"`
+	RemovedForeignKeys []ForeignKeyInfo `json:"removed_foreign_keys,omitempty"`
+	AddedConstraints   []ConstraintInfo `json:"added_constraints,omitempty"`
+	RemovedConstraints []ConstraintInfo `json:"removed_constraints,omitempty"`
+}
+
+// ColumnChange describes a column whose type, nullability or default
+// differs. Changes names the properties that did.
+type ColumnChange struct {
+	Name    string     `json:"name"`
+	Changes []string   `json:"changes"`
+	From    ColumnInfo `json:"from"`
+	To      ColumnInfo `json:"to"`
+}
+
+// KeyChange holds the primary key columns on both sides
+type KeyChange struct {
+	From []string `json:"from"`
+	To   []string `json:"to"`
+}
+
+// DiffSchemas compares two schemas of the connected database or stored
+// snapshots, returning the differences and the statements applying them
+func (h *Handler) DiffSchemas(c *gin.Context) {
+	var req SchemaDiffRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		return
+	}
+	from, fromSchema, ok := h.loadSchemaSource(c, "from", req.From)
+	if !ok {
+		return
+	}
+	to, _, ok := h.loadSchemaSource(c, "to", req.To)
+	if !ok {
+		return
+	}
+	access := rbac.FromContext(c.Request.Context())
+	c.JSON(http.StatusOK, h.diffSchemas(h.filterSchema(access, from), h.filterSchema(access, to), fromSchema))
+}
+
+// SaveSchemaSnapshot stores the current state of a schema for later diffs
+func (h *Handler) SaveSchemaSnapshot(c *gin.Context) {
+	var req SchemaSnapshotRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		return
+	}
+	if !identPattern.MatchString(req.Name) || req.Name == "latest" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name must be a plain identifier other than latest"})
+		return
+	}
+	tables, schema, ok := h.loadSchemaSource(c, "schema", SchemaSource{Schema: req.Schema})
+	if !ok {
+		return
+	}
+	key, err := h.putSnapshotArtifact(c.Request.Context(), req.Name, tables)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Storing the snapshot failed: " + err.Error()})
+		return
+	}
+	var previous SchemaSnapshot
+	hadPrevious := h.meta.Get(namedSnapshotPrefix+req.Name, &previous) == nil
+	snap := SchemaSnapshot{
+		Name:       req.Name,
+		SchemaName: schema,
+		FetchedAt:  time.Now(),
+		CreatedBy:  auth.Principal(c),
+		Artifact:   key,
+		Tables:     len(tables),
+	}
+	if err := h.meta.Put(namedSnapshotPrefix+req.Name, snap); err != nil {
+		h.artifacts.Delete(context.Background(), key)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if hadPrevious && previous.Artifact != "" {
+		h.artifacts.Delete(context.Background(), previous.Artifact)
+	}
+	c.JSON(http.StatusCreated, gin.H{"name": snap.Name, "schema": schema, "fetched_at": snap.FetchedAt, "tables": len(tables)})
+}
+
+// ListSchemaSnapshots lists the saved snapshots without their contents
+func (h *Handler) ListSchemaSnapshots(c *gin.Context) {
+	keys, err := h.meta.List(namedSnapshotPrefix)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	snapshots := []gin.H{}
+	for _, key := range keys {
+		var snap SchemaSnapshot
+		if err := h.meta.Get(key, &snap); err != nil {
+			continue
+		}
+		snapshots = append(snapshots, gin.H{
+			"name":       snap.Name,
+			"schema":     snap.SchemaName,
+			"fetched_at": snap.FetchedAt,
+			"created_by": snap.CreatedBy,
+			"tables":     cmp.Or(snap.Tables, len(snap.Schema)),
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"snapshots": snapshots})
+}
+
+// DeleteSchemaSnapshot moves a named snapshot to the trash
+func (h *Handler) DeleteSchemaSnapshot(c *gin.Context) {
+	var snap SchemaSnapshot
+	if err := h.meta.Get(namedSnapshotPrefix+c.Param("name"), &snap); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Snapshot not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	if err := h.moveToTrash(c, "schema-snapshot", c.Param("name"), snap.Name, snap.CreatedBy, snap); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// loadSchemaSource returns the tables of a source and the schema they belong
+// to, writing an error response if they can't be loaded
+func (h *Handler) loadSchemaSource(c *gin.Context, field string, src SchemaSource) ([]TableSchema, string, bool) {
+	if src.Snapshot != "" {
+		if src.Schema != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": field + ": set either schema or snapshot"})
+			return nil, "", false
+		}
+		key := namedSnapshotPrefix + src.Snapshot
+		if src.Snapshot == "latest" {
+			key = schemaSnapshotKey
+		}
+		var snap SchemaSnapshot
+		if err := h.meta.Get(key, &snap); err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": field + ": snapshot " + src.Snapshot + " not found"})
+			} else {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			}
+			return nil, "", false
+		}
+		tables, err := h.snapshotTables(c.Request.Context(), snap)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": field + ": reading snapshot " + src.Snapshot + " failed: " + err.Error()})
+			return nil, "", false
+		}
+		schema := snap.SchemaName
+		if schema == "" {
+			schema = h.dialect.DefaultSchema()
+		}
+		return tables, schema, true
+	}
+
+	schema := orDefault(h.dialect, src.Schema)
+	if !h.isDefaultSchema(schema) {
+		schemas, err := h.dialect.ListSchemas(h.db)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return nil, "", false
+		}
+		if !slices.Contains(schemas, schema) {
+			c.JSON(http.StatusNotFound, gin.H{"error": field + ": schema " + schema + " not found"})
+			return nil, "", false
+		}
+	}
+	tables, err := h.loadFullSchema(schema)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, "", false
+	}
+	return tables, schema, true
+}
+
+func (h *Handler) diffSchemas(from, to []TableSchema, fromSchema string) SchemaDiff {
+	diff := SchemaDiff{AddedTables: []TableSchema{}, RemovedTables: []string{}, ChangedTables: []TableDiff{}, Statements: []string{}}
+	q := h.dialect.Quote
+	qualified := func(name string) string { return q(fromSchema) + "." + q(name) }
+
+	fromTables := map[string]TableSchema{}
+	for _, t := range from {
+		fromTables[t.Name] = t
+	}
+	toTables := map[string]TableSchema{}
+	for _, t := range to {
+		toTables[t.Name] = t
+	}
+
+	var drops []string
+	for _, name := range slices.Sorted(maps.Keys(fromTables)) {
+		if _, ok := toTables[name]; !ok {
+			diff.RemovedTables = append(diff.RemovedTables, name)
+			drops = append(drops, "DROP TABLE "+qualified(name)+";")
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(toTables)) {
+		t := toTables[name]
+		old, ok := fromTables[name]
+		if !ok {
+			diff.AddedTables = append(diff.AddedTables, t)
+			diff.Statements = append(diff.Statements, h.createTableStatement(fromSchema, t))
+			continue
+		}
+		if td, stmts := h.diffTable(fromSchema, old, t); len(stmts) > 0 {
+			diff.ChangedTables = append(diff.ChangedTables, td)
+			diff.Statements = append(diff.Statements, stmts...)
+		}
+	}
+	diff.Statements = append(diff.Statements, drops...)
+	return diff
+}
+
+// diffTable compares two versions of a table and returns the differences
+// with the statements applying them to the table in schema
+func (h *Handler) diffTable(schema string, from, to TableSchema) (TableDiff, []string) {
+	q := h.dialect.Quote
+	table := q(schema) + "." + q(to.Name)
+	td := TableDiff{Name: to.Name}
+	var stmts []string
+	alter := func(format string, args ...any) {
+		stmts = append(stmts, "ALTER TABLE "+table+" "+fmt.Sprintf(format, args...)+";")
+	}
+
+	for _, col := range from.Columns {
+		if !slices.ContainsFunc(to.Columns, func(c ColumnInfo) bool { return c.Name == col.Name }) {
+			td.RemovedColumns = append(td.RemovedColumns, col.Name)
+			alter("DROP COLUMN %s", q(col.Name))
+		}
+	}
+	for _, col := range to.Columns {
+		i := slices.IndexFunc(from.Columns, func(c ColumnInfo) bool { return c.Name == col.Name })
+		if i < 0 {
+			td.AddedColumns = append(td.AddedColumns, col)
+			alter("ADD COLUMN %s", h.columnDefinition(col))
+			continue
+		}
+		old := from.Columns[i]
+		change := ColumnChange{Name: col.Name, From: old, To: col}
+		if columnType(old) != columnType(col) {
+			change.Changes = append(change.Changes, "type")
+			alter("ALTER COLUMN %s TYPE %s", q(col.Name), columnType(col))
+		}
+		if old.IsNullable != col.IsNullable {
+			change.Changes = append(change.Changes, "nullable")
+			if col.IsNullable == "NO" {
+				alter("ALTER COLUMN %s SET NOT NULL", q(col.Name))
+			} else {
+				alter("ALTER COLUMN %s DROP NOT NULL", q(col.Name))
+			}
+		}
+		if deref(old.Default) != deref(col.Default) {
+			change.Changes = append(change.Changes, "default")
+			if col.Default == nil {
+				alter("ALTER COLUMN %s DROP DEFAULT", q(col.Name))
+			} else {
+				alter("ALTER COLUMN %s SET DEFAULT %s", q(col.Name), *col.Default)
+			}
+		}
+		if len(change.Changes) > 0 {
+			td.ChangedColumns = append(td.ChangedColumns, change)
+		}
+	}
+
+	if !slices.Equal(from.PrimaryKeys, to.PrimaryKeys) {
+		td.PrimaryKey = &KeyChange{From: from.PrimaryKeys, To: to.PrimaryKeys}
+		if len(from.PrimaryKeys) > 0 {
+			// Primary key constraint names are not introspected
+			stmts = append(stmts, fmt.Sprintf("-- drop the primary key (%s) of %s", strings.Join(from.PrimaryKeys, ", "), table))
+		}
+		if len(to.PrimaryKeys) > 0 {
+			alter("ADD PRIMARY KEY (%s)", h.quoteList(to.PrimaryKeys))
+		}
+	}
+
+	fkKey := func(t TableSchema, fk ForeignKeyInfo) string {
+		// References within the compared schema match across schemas
+		schema := fk.ForeignSchema
+		if schema == t.Schema {
+			schema = ""
+		}
+		return fk.Column + "\x00" + schema + "\x00" + fk.ForeignTable + "\x00" + fk.ForeignColumn
+	}
+	for _, fk := range from.ForeignKeys {
+		if !slices.ContainsFunc(to.ForeignKeys, func(o ForeignKeyInfo) bool { return fkKey(to, o) == fkKey(from, fk) }) {
+			td.RemovedForeignKeys = append(td.RemovedForeignKeys, fk)
+			stmts = append(stmts, fmt.Sprintf("-- drop the foreign key on %s (%s) referencing %s.%s", table, fk.Column, fk.ForeignTable, fk.ForeignColumn))
+		}
+	}
+	for _, fk := range to.ForeignKeys {
+		if !slices.ContainsFunc(from.ForeignKeys, func(o ForeignKeyInfo) bool { return fkKey(from, o) == fkKey(to, fk) }) {
+			td.AddedForeignKeys = append(td.AddedForeignKeys, fk)
+			refSchema := fk.ForeignSchema
+			if refSchema == to.Schema {
+				refSchema = schema
+			}
+			ref := q(refSchema) + "." + q(fk.ForeignTable)
+			alter("ADD FOREIGN KEY (%s) REFERENCES %s (%s)", q(fk.Column), ref, q(fk.ForeignColumn))
+		}
+	}
+
+	// Constraints match by definition, their names often being generated
+	conKey := func(con ConstraintInfo) string { return con.Type + "\x00" + con.Definition }
+	for _, con := range from.Constraints {
+		if !slices.ContainsFunc(to.Constraints, func(o ConstraintInfo) bool { return conKey(o) == conKey(con) }) {
+			td.RemovedConstraints = append(td.RemovedConstraints, con)
+			alter("DROP CONSTRAINT %s", q(con.Name))
+		}
+	}
+	for _, con := range to.Constraints {
+		if !slices.ContainsFunc(from.Constraints, func(o ConstraintInfo) bool { return conKey(o) == conKey(con) }) {
+			td.AddedConstraints = append(td.AddedConstraints, con)
+			alter("ADD CONSTRAINT %s %s", q(con.Name), con.Definition)
+		}
+	}
+	return td, stmts
+}
+
+func (h *Handler) createTableStatement(schema string, t TableSchema) string {
+	q := h.dialect.Quote
+	table := q(schema) + "." + q(t.Name)
+	var defs []string
+	for _, col := range t.Columns {
+		defs = append(defs, h.columnDefinition(col))
+	}
+	if len(t.PrimaryKeys) > 0 {
+		defs = append(defs, "PRIMARY KEY ("+h.quoteList(t.PrimaryKeys)+")")
+	}
+	for _, fk := range t.ForeignKeys {
+		refSchema := fk.ForeignSchema
+		if refSchema == t.Schema {
+			refSchema = schema
+		}
+		defs = append(defs, fmt.Sprintf("FOREIGN KEY (%s) REFERENCES %s.%s (%s)",
+			q(fk.Column), q(refSchema), q(fk.ForeignTable), q(fk.ForeignColumn)))
+	}
+	for _, con := range t.Constraints {
+		defs = append(defs, "CONSTRAINT "+q(con.Name)+" "+con.Definition)
+	}
+	return fmt.Sprintf("CREATE TABLE %s (\n\t%s\n);", table, strings.Join(defs, ",\n\t"))
+}
+
+func (h *Handler) columnDefinition(col ColumnInfo) string {
+	def := h.dialect.Quote(col.Name) + " " + columnType(col)
+	if col.IsNullable == "NO" {
+		def += " NOT NULL"
+	}
+	if col.Default != nil {
+		def += " DEFAULT " + *col.Default
+	}
+	return def
+}
+
+func (h *Handler) quoteList(names []string) string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = h.dialect.Quote(name)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// columnType spells out a column's type with its length or precision, using
+// the name of user-defined types
+func columnType(col ColumnInfo) string {
+	t := col.DataType
+	if col.UserType != nil {
+		t = col.UserType.Schema + "." + col.UserType.Name
+	}
+	switch {
+	case col.MaxLength != nil:
+		t += fmt.Sprintf("(%d)", *col.MaxLength)
+	case (t == "numeric" || t == "decimal") && col.NumericPrecision != nil && col.NumericScale != nil:
+		t += fmt.Sprintf("(%d,%d)", *col.NumericPrecision, *col.NumericScale)
+	}
+	return t
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
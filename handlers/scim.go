@@ -0,0 +1,859 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"sql-engine/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	scimUserPrefix  = "scim-user/"
+	scimGroupPrefix = "scim-group/"
+	// scimLoginPrefix indexes users by lower-cased userName, which is the
+	// principal they sign in as
+	scimLoginPrefix = "scim-login/"
+
+	scimUserSchema  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	scimGroupSchema = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	scimListSchema  = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	scimErrorSchema = "urn:ietf:params:scim:api:messages:2.0:Error"
+
+	maxSCIMResults = 200
+	maxSCIMBody    = 1 << 20
+)
+
+var (
+	// scimFilter matches the filters identity providers send to look up a
+	// resource: one attribute compared for equality
+	scimFilter = regexp.MustCompile(`^(\w+)\s+(?i:eq)\s+"((?:[^"\\]|\\.)*)"$`)
+	// scimPath matches PATCH paths: an attribute, optionally a filter on
+	// its values and a sub-attribute, e.g. emails[type eq "work"].value
+	scimPath = regexp.MustCompile(`^([A-Za-z$][\w$]*)(?:\[(\w+)\s+(?i:eq)\s+"((?:[^"\\]|\\.)*)"\])?(?:\.(\w+))?$`)
+)
+
+type SCIMMeta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+	LastModified time.Time `json:"lastModified"`
+	Location     string    `json:"location"`
+}
+
+type SCIMName struct {
+	Formatted  string `json:"formatted,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+}
+
+type SCIMEmail struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// SCIMRef refers to a user from a group or a group from a user
+type SCIMRef struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+// SCIMUser is a provisioned user. UserName is the principal it signs in as,
+// i.e. the auth.oidc.identity_claim of its tokens.
+type SCIMUser struct {
+	Schemas     []string    `json:"schemas"`
+	ID          string      `json:"id"`
+	ExternalID  string      `json:"externalId,omitempty"`
+	UserName    string      `json:"userName"`
+	Name        *SCIMName   `json:"name,omitempty"`
+	DisplayName string      `json:"displayName,omitempty"`
+	Emails      []SCIMEmail `json:"emails,omitempty"`
+	Active      bool        `json:"active"`
+	// Groups is read-only: memberships change through the groups
+	Groups []SCIMRef `json:"groups,omitempty"`
+	Meta   SCIMMeta  `json:"meta"`
+}
+
+// SCIMGroup is a provisioned group, whose members get the RBAC roles
+// scim.group_roles maps its display name to
+type SCIMGroup struct {
+	Schemas     []string  `json:"schemas"`
+	ID          string    `json:"id"`
+	ExternalID  string    `json:"externalId,omitempty"`
+	DisplayName string    `json:"displayName"`
+	Members     []SCIMRef `json:"members,omitempty"`
+	Meta        SCIMMeta  `json:"meta"`
+}
+
+type scimLogin struct {
+	ID string `json:"id"`
+}
+
+type scimPatchRequest struct {
+	Schemas    []string `json:"schemas"`
+	Operations []struct {
+		Op    string          `json:"op"`
+		Path  string          `json:"path"`
+		Value json.RawMessage `json:"value"`
+	} `json:"Operations"`
+}
+
+// scimStatus is an error answered in the SCIM error format
+type scimStatus struct {
+	status   int
+	scimType string
+	detail   string
+}
+
+func (e *scimStatus) Error() string { return e.detail }
+
+func scimErrorf(status int, scimType, format string, args ...interface{}) error {
+	return &scimStatus{status: status, scimType: scimType, detail: fmt.Sprintf(format, args...)}
+}
+
+// respondSCIM writes v as application/scim+json
+func respondSCIM(c *gin.Context, status int, v interface{}) {
+	c.Header("Content-Type", "application/scim+json")
+	c.JSON(status, v)
+}
+
+// respondSCIMError answers an error in the SCIM error format
+func respondSCIMError(c *gin.Context, err error) {
+	e, ok := err.(*scimStatus)
+	if !ok {
+		e = &scimStatus{status: http.StatusInternalServerError, detail: err.Error()}
+	}
+	body := gin.H{"schemas": []string{scimErrorSchema}, "status": strconv.Itoa(e.status), "detail": e.detail}
+	if e.scimType != "" {
+		body["scimType"] = e.scimType
+	}
+	c.Header("Content-Type", "application/scim+json")
+	c.AbortWithStatusJSON(e.status, body)
+}
+
+// RequireSCIM authenticates the identity provider with scim.token. The
+// SCIM API answers 404 while no token is configured.
+func (h *Handler) RequireSCIM() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := h.cfg.SCIM.Token
+		if token == "" {
+			respondSCIMError(c, scimErrorf(http.StatusNotFound, "", "SCIM provisioning is not enabled"))
+			return
+		}
+		bearer, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(bearer), []byte(token)) != 1 {
+			respondSCIMError(c, scimErrorf(http.StatusUnauthorized, "", "Invalid or missing SCIM token"))
+			return
+		}
+		c.Next()
+	}
+}
+
+// Provisioned implements rbac.Directory: users are found by userName,
+// compared case-insensitively, and get the roles of their groups
+func (h *Handler) Provisioned(principal string) ([]string, bool, bool, error) {
+	var login scimLogin
+	err := h.meta.Get(scimLoginPrefix+strings.ToLower(principal), &login)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, false, false, nil
+	} else if err != nil {
+		return nil, false, false, err
+	}
+	var u SCIMUser
+	err = h.meta.Get(scimUserPrefix+login.ID, &u)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, false, false, nil
+	} else if err != nil {
+		return nil, false, false, err
+	}
+	var roles []string
+	for _, g := range u.Groups {
+		roles = append(roles, h.cfg.SCIM.GroupRoles[g.Display]...)
+	}
+	return roles, u.Active, true, nil
+}
+
+// GetSCIMServiceProviderConfig describes the SCIM features supported
+func (h *Handler) GetSCIMServiceProviderConfig(c *gin.Context) {
+	unsupported := gin.H{"supported": false}
+	respondSCIM(c, http.StatusOK, gin.H{
+		"schemas":        []string{"urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"},
+		"patch":          gin.H{"supported": true},
+		"bulk":           gin.H{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         gin.H{"supported": true, "maxResults": maxSCIMResults},
+		"changePassword": unsupported,
+		"sort":           unsupported,
+		"etag":           unsupported,
+		"authenticationSchemes": []gin.H{{
+			"type":        "oauthbearertoken",
+			"name":        "Bearer token",
+			"description": "The token set in scim.token",
+			"primary":     true,
+		}},
+		"meta": gin.H{"resourceType": "ServiceProviderConfig", "location": "/scim/v2/ServiceProviderConfig"},
+	})
+}
+
+// GetSCIMResourceTypes lists the Users and Groups resource types
+func (h *Handler) GetSCIMResourceTypes(c *gin.Context) {
+	types := []gin.H{
+		{"schemas": []string{"urn:ietf:params:scim:schemas:core:2.0:ResourceType"}, "id": "User", "name": "User",
+			"endpoint": "/Users", "schema": scimUserSchema, "meta": gin.H{"resourceType": "ResourceType", "location": "/scim/v2/ResourceTypes/User"}},
+		{"schemas": []string{"urn:ietf:params:scim:schemas:core:2.0:ResourceType"}, "id": "Group", "name": "Group",
+			"endpoint": "/Groups", "schema": scimGroupSchema, "meta": gin.H{"resourceType": "ResourceType", "location": "/scim/v2/ResourceTypes/Group"}},
+	}
+	respondSCIM(c, http.StatusOK, scimList(types, len(types), 1))
+}
+
+func scimList[T any](resources []T, total, start int) gin.H {
+	return gin.H{
+		"schemas":      []string{scimListSchema},
+		"totalResults": total,
+		"startIndex":   start,
+		"itemsPerPage": len(resources),
+		"Resources":    resources,
+	}
+}
+
+// scimPage reads ?filter, ?startIndex and ?count. The filter is attribute
+// and value, attribute lower-cased, or empty strings.
+func scimPage(c *gin.Context) (attr, value string, start, count int, err error) {
+	if f := strings.TrimSpace(c.Query("filter")); f != "" {
+		m := scimFilter.FindStringSubmatch(f)
+		if m == nil {
+			return "", "", 0, 0, scimErrorf(http.StatusBadRequest, "invalidFilter", "Only filters of the form attribute eq \"value\" are supported")
+		}
+		value, _ = strconv.Unquote(`"` + m[2] + `"`)
+		attr = strings.ToLower(m[1])
+	}
+	start, count = 1, maxSCIMResults
+	if v := c.Query("startIndex"); v != "" {
+		if start, err = strconv.Atoi(v); err != nil {
+			return "", "", 0, 0, scimErrorf(http.StatusBadRequest, "invalidValue", "startIndex must be an integer")
+		}
+		start = max(start, 1)
+	}
+	if v := c.Query("count"); v != "" {
+		if count, err = strconv.Atoi(v); err != nil {
+			return "", "", 0, 0, scimErrorf(http.StatusBadRequest, "invalidValue", "count must be an integer")
+		}
+		count = min(max(count, 0), maxSCIMResults)
+	}
+	return attr, value, start, count, nil
+}
+
+// paginate returns the count resources from the 1-based start
+func paginate[T any](all []T, start, count int) []T {
+	if start > len(all) {
+		return []T{}
+	}
+	return all[start-1 : min(start-1+count, len(all))]
+}
+
+// readSCIM decodes a request body, which identity providers send as
+// application/scim+json
+func readSCIM(c *gin.Context, v interface{}) error {
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxSCIMBody))
+	if err == nil {
+		err = json.Unmarshal(body, v)
+	}
+	if err != nil {
+		return scimErrorf(http.StatusBadRequest, "invalidSyntax", "Invalid JSON: %v", err)
+	}
+	return nil
+}
+
+func (h *Handler) scimUser(id string) (*SCIMUser, error) {
+	var u SCIMUser
+	if err := h.meta.Get(scimUserPrefix+id, &u); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return nil, scimErrorf(http.StatusNotFound, "", "User %s not found", id)
+		}
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (h *Handler) scimGroup(id string) (*SCIMGroup, error) {
+	var g SCIMGroup
+	if err := h.meta.Get(scimGroupPrefix+id, &g); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return nil, scimErrorf(http.StatusNotFound, "", "Group %s not found", id)
+		}
+		return nil, err
+	}
+	return &g, nil
+}
+
+func (h *Handler) ListSCIMUsers(c *gin.Context) {
+	attr, value, start, count, err := scimPage(c)
+	if err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+	keys, err := h.meta.List(scimUserPrefix)
+	if err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+	users := []SCIMUser{}
+	for _, key := range keys {
+		var u SCIMUser
+		if err := h.meta.Get(key, &u); err != nil {
+			continue
+		}
+		switch attr {
+		case "":
+		case "username":
+			if !strings.EqualFold(u.UserName, value) {
+				continue
+			}
+		case "externalid":
+			if u.ExternalID != value {
+				continue
+			}
+		case "id":
+			if u.ID != value {
+				continue
+			}
+		default:
+			respondSCIMError(c, scimErrorf(http.StatusBadRequest, "invalidFilter", "Users can't be filtered by %s", attr))
+			return
+		}
+		users = append(users, u)
+	}
+	respondSCIM(c, http.StatusOK, scimList(paginate(users, start, count), len(users), start))
+}
+
+func (h *Handler) GetSCIMUser(c *gin.Context) {
+	u, err := h.scimUser(c.Param("id"))
+	if err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+	respondSCIM(c, http.StatusOK, u)
+}
+
+// CreateSCIMUser provisions a user; userName must be unused
+func (h *Handler) CreateSCIMUser(c *gin.Context) {
+	u := SCIMUser{Active: true}
+	if err := readSCIM(c, &u); err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+	h.scimMu.Lock()
+	defer h.scimMu.Unlock()
+	now := time.Now().UTC()
+	u.ID, u.Groups = newID(), nil
+	u.Meta = SCIMMeta{Created: now}
+	if err := h.saveSCIMUser(&u, ""); err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+	c.Header("Location", u.Meta.Location)
+	respondSCIM(c, http.StatusCreated, u)
+}
+
+// ReplaceSCIMUser replaces a user's attributes
+func (h *Handler) ReplaceSCIMUser(c *gin.Context) {
+	h.scimMu.Lock()
+	defer h.scimMu.Unlock()
+	prev, err := h.scimUser(c.Param("id"))
+	if err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+	u := SCIMUser{Active: true}
+	if err := readSCIM(c, &u); err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+	u.ID, u.Groups, u.Meta = prev.ID, prev.Groups, prev.Meta
+	if err := h.saveSCIMUser(&u, prev.UserName); err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+	respondSCIM(c, http.StatusOK, u)
+}
+
+// PatchSCIMUser applies a PatchOp to a user. Deprovisioning usually sets
+// active to false, which refuses the user's requests from then on.
+func (h *Handler) PatchSCIMUser(c *gin.Context) {
+	h.scimMu.Lock()
+	defer h.scimMu.Unlock()
+	prev, err := h.scimUser(c.Param("id"))
+	if err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+	var req scimPatchRequest
+	if err := readSCIM(c, &req); err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+	data, _ := json.Marshal(prev)
+	var doc map[string]interface{}
+	json.Unmarshal(data, &doc)
+	for _, op := range req.Operations {
+		var value interface{}
+		if len(op.Value) > 0 {
+			if err := json.Unmarshal(op.Value, &value); err != nil {
+				respondSCIMError(c, scimErrorf(http.StatusBadRequest, "invalidSyntax", "Invalid value: %v", err))
+				return
+			}
+		}
+		if err := scimPatch(doc, strings.ToLower(op.Op), op.Path, value); err != nil {
+			respondSCIMError(c, err)
+			return
+		}
+	}
+	// Some identity providers send booleans as strings
+	if s, ok := doc["active"].(string); ok {
+		doc["active"], _ = strconv.ParseBool(s)
+	}
+	data, _ = json.Marshal(doc)
+	var u SCIMUser
+	if err := json.Unmarshal(data, &u); err != nil {
+		respondSCIMError(c, scimErrorf(http.StatusBadRequest, "invalidValue", "Invalid user: %v", err))
+		return
+	}
+	u.ID, u.Groups, u.Meta = prev.ID, prev.Groups, prev.Meta
+	if err := h.saveSCIMUser(&u, prev.UserName); err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+	respondSCIM(c, http.StatusOK, u)
+}
+
+// saveSCIMUser validates and writes a user, moving its login from
+// prevName if userName changed
+func (h *Handler) saveSCIMUser(u *SCIMUser, prevName string) error {
+	if u.UserName == "" {
+		return scimErrorf(http.StatusBadRequest, "invalidValue", "userName is required")
+	}
+	u.Schemas = []string{scimUserSchema}
+	u.Meta.ResourceType = "User"
+	u.Meta.LastModified = time.Now().UTC()
+	u.Meta.Location = "/scim/v2/Users/" + u.ID
+	login := strings.ToLower(u.UserName)
+	if login != strings.ToLower(prevName) {
+		if err := h.meta.Create(scimLoginPrefix+login, scimLogin{ID: u.ID}); errors.Is(err, store.ErrExists) {
+			return scimErrorf(http.StatusConflict, "uniqueness", "userName %s is taken", u.UserName)
+		} else if err != nil {
+			return err
+		}
+	}
+	if err := h.meta.Put(scimUserPrefix+u.ID, u); err != nil {
+		return err
+	}
+	if prevName != "" && login != strings.ToLower(prevName) {
+		h.meta.Delete(scimLoginPrefix + strings.ToLower(prevName))
+	}
+	return nil
+}
+
+// DeleteSCIMUser deprovisions a user, removing it from its groups
+func (h *Handler) DeleteSCIMUser(c *gin.Context) {
+	h.scimMu.Lock()
+	defer h.scimMu.Unlock()
+	u, err := h.scimUser(c.Param("id"))
+	if err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+	for _, ref := range u.Groups {
+		g, err := h.scimGroup(ref.Value)
+		if err != nil {
+			continue
+		}
+		g.Members = slices.DeleteFunc(g.Members, func(m SCIMRef) bool { return m.Value == u.ID })
+		g.Meta.LastModified = time.Now().UTC()
+		if err := h.meta.Put(scimGroupPrefix+g.ID, g); err != nil {
+			respondSCIMError(c, err)
+			return
+		}
+	}
+	if err := h.meta.Delete(scimUserPrefix + u.ID); err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+	h.meta.Delete(scimLoginPrefix + strings.ToLower(u.UserName))
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) ListSCIMGroups(c *gin.Context) {
+	attr, value, start, count, err := scimPage(c)
+	if err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+	keys, err := h.meta.List(scimGroupPrefix)
+	if err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+	groups := []SCIMGroup{}
+	for _, key := range keys {
+		var g SCIMGroup
+		if err := h.meta.Get(key, &g); err != nil {
+			continue
+		}
+		switch attr {
+		case "":
+		case "displayname":
+			if !strings.EqualFold(g.DisplayName, value) {
+				continue
+			}
+		case "externalid":
+			if g.ExternalID != value {
+				continue
+			}
+		case "id":
+			if g.ID != value {
+				continue
+			}
+		default:
+			respondSCIMError(c, scimErrorf(http.StatusBadRequest, "invalidFilter", "Groups can't be filtered by %s", attr))
+			return
+		}
+		if c.Query("excludedAttributes") == "members" {
+			g.Members = nil
+		}
+		groups = append(groups, g)
+	}
+	respondSCIM(c, http.StatusOK, scimList(paginate(groups, start, count), len(groups), start))
+}
+
+func (h *Handler) GetSCIMGroup(c *gin.Context) {
+	g, err := h.scimGroup(c.Param("id"))
+	if err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+	respondSCIM(c, http.StatusOK, g)
+}
+
+// CreateSCIMGroup provisions a group; its members must be provisioned users
+func (h *Handler) CreateSCIMGroup(c *gin.Context) {
+	var g SCIMGroup
+	if err := readSCIM(c, &g); err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+	h.scimMu.Lock()
+	defer h.scimMu.Unlock()
+	g.ID = newID()
+	g.Meta = SCIMMeta{Created: time.Now().UTC()}
+	if err := h.saveSCIMGroup(&g, nil); err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+	c.Header("Location", g.Meta.Location)
+	respondSCIM(c, http.StatusCreated, g)
+}
+
+// ReplaceSCIMGroup replaces a group's name and members
+func (h *Handler) ReplaceSCIMGroup(c *gin.Context) {
+	h.scimMu.Lock()
+	defer h.scimMu.Unlock()
+	prev, err := h.scimGroup(c.Param("id"))
+	if err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+	var g SCIMGroup
+	if err := readSCIM(c, &g); err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+	g.ID, g.Meta = prev.ID, prev.Meta
+	if err := h.saveSCIMGroup(&g, prev.Members); err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+	respondSCIM(c, http.StatusOK, g)
+}
+
+// PatchSCIMGroup renames a group or adds, removes and replaces members
+func (h *Handler) PatchSCIMGroup(c *gin.Context) {
+	h.scimMu.Lock()
+	defer h.scimMu.Unlock()
+	prev, err := h.scimGroup(c.Param("id"))
+	if err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+	var req scimPatchRequest
+	if err := readSCIM(c, &req); err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+	g := *prev
+	g.Members = slices.Clone(prev.Members)
+	for _, op := range req.Operations {
+		if err := patchSCIMGroup(&g, strings.ToLower(op.Op), op.Path, op.Value); err != nil {
+			respondSCIMError(c, err)
+			return
+		}
+	}
+	if err := h.saveSCIMGroup(&g, prev.Members); err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+	respondSCIM(c, http.StatusOK, g)
+}
+
+// patchSCIMGroup applies one operation of a PatchOp to a group
+func patchSCIMGroup(g *SCIMGroup, op, path string, value json.RawMessage) error {
+	if op != "add" && op != "replace" && op != "remove" {
+		return scimErrorf(http.StatusBadRequest, "invalidSyntax", "Unknown operation %q", op)
+	}
+	m := scimPath.FindStringSubmatch(path)
+	switch {
+	case path == "":
+		// The value holds the attributes to set
+		var attrs map[string]json.RawMessage
+		if err := json.Unmarshal(value, &attrs); err != nil || op == "remove" {
+			return scimErrorf(http.StatusBadRequest, "noTarget", "An operation without path needs an object value")
+		}
+		for name, v := range attrs {
+			if err := patchSCIMGroup(g, op, name, v); err != nil {
+				return err
+			}
+		}
+	case m == nil:
+		return scimErrorf(http.StatusBadRequest, "invalidPath", "Invalid path %q", path)
+	case strings.EqualFold(m[1], "displayName") || strings.EqualFold(m[1], "externalId"):
+		var s string
+		if op != "remove" {
+			if err := json.Unmarshal(value, &s); err != nil {
+				return scimErrorf(http.StatusBadRequest, "invalidValue", "%s must be a string", m[1])
+			}
+		}
+		if strings.EqualFold(m[1], "displayName") {
+			g.DisplayName = s
+		} else {
+			g.ExternalID = s
+		}
+	case strings.EqualFold(m[1], "members") && m[2] != "":
+		// members[value eq "id"]
+		if op != "remove" || !strings.EqualFold(m[2], "value") {
+			return scimErrorf(http.StatusBadRequest, "invalidPath", "Members can only be removed by value")
+		}
+		id, _ := strconv.Unquote(`"` + m[3] + `"`)
+		g.Members = slices.DeleteFunc(g.Members, func(r SCIMRef) bool { return r.Value == id })
+	case strings.EqualFold(m[1], "members"):
+		var refs []SCIMRef
+		if len(value) > 0 {
+			if err := json.Unmarshal(value, &refs); err != nil {
+				return scimErrorf(http.StatusBadRequest, "invalidValue", "members must be an array of references")
+			}
+		}
+		switch {
+		case op == "replace":
+			g.Members = refs
+		case op == "add":
+			g.Members = append(g.Members, refs...)
+		case len(refs) == 0:
+			g.Members = nil
+		default:
+			g.Members = slices.DeleteFunc(g.Members, func(r SCIMRef) bool {
+				return slices.ContainsFunc(refs, func(x SCIMRef) bool { return x.Value == r.Value })
+			})
+		}
+	default:
+		return scimErrorf(http.StatusBadRequest, "invalidPath", "Groups have no attribute %s", m[1])
+	}
+	return nil
+}
+
+// saveSCIMGroup validates and writes a group, then updates the groups of
+// the users who joined or left it since prevMembers
+func (h *Handler) saveSCIMGroup(g *SCIMGroup, prevMembers []SCIMRef) error {
+	if g.DisplayName == "" {
+		return scimErrorf(http.StatusBadRequest, "invalidValue", "displayName is required")
+	}
+	members := []SCIMRef{}
+	for _, ref := range g.Members {
+		if slices.ContainsFunc(members, func(m SCIMRef) bool { return m.Value == ref.Value }) {
+			continue
+		}
+		u, err := h.scimUser(ref.Value)
+		if err != nil {
+			var e *scimStatus
+			if errors.As(err, &e) && e.status == http.StatusNotFound {
+				return scimErrorf(http.StatusBadRequest, "invalidValue", "Member %s is not a provisioned user", ref.Value)
+			}
+			return err
+		}
+		members = append(members, SCIMRef{Value: u.ID, Display: u.UserName, Ref: u.Meta.Location})
+	}
+	g.Members = members
+	g.Schemas = []string{scimGroupSchema}
+	g.Meta.ResourceType = "Group"
+	g.Meta.LastModified = time.Now().UTC()
+	g.Meta.Location = "/scim/v2/Groups/" + g.ID
+	if err := h.meta.Put(scimGroupPrefix+g.ID, g); err != nil {
+		return err
+	}
+	return h.syncSCIMMemberships(g.ID, g, append(slices.Clone(prevMembers), members...))
+}
+
+// syncSCIMMemberships updates the group references of users, removing the
+// group from those who aren't members of g (nil once deleted)
+func (h *Handler) syncSCIMMemberships(id string, g *SCIMGroup, users []SCIMRef) error {
+	seen := map[string]bool{}
+	for _, ref := range users {
+		if seen[ref.Value] {
+			continue
+		}
+		seen[ref.Value] = true
+		u, err := h.scimUser(ref.Value)
+		if err != nil {
+			continue
+		}
+		u.Groups = slices.DeleteFunc(u.Groups, func(r SCIMRef) bool { return r.Value == id })
+		if g != nil && slices.ContainsFunc(g.Members, func(m SCIMRef) bool { return m.Value == u.ID }) {
+			u.Groups = append(u.Groups, SCIMRef{Value: id, Display: g.DisplayName, Ref: g.Meta.Location})
+		}
+		if err := h.meta.Put(scimUserPrefix+u.ID, u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteSCIMGroup deletes a group; its members lose the group's roles
+func (h *Handler) DeleteSCIMGroup(c *gin.Context) {
+	h.scimMu.Lock()
+	defer h.scimMu.Unlock()
+	g, err := h.scimGroup(c.Param("id"))
+	if err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+	if err := h.meta.Delete(scimGroupPrefix + g.ID); err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+	if err := h.syncSCIMMemberships(g.ID, nil, g.Members); err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// scimPatch applies one operation of a PatchOp to a resource decoded as a
+// map. Attribute names are matched case-insensitively.
+func scimPatch(doc map[string]interface{}, op, path string, value interface{}) error {
+	if op != "add" && op != "replace" && op != "remove" {
+		return scimErrorf(http.StatusBadRequest, "invalidSyntax", "Unknown operation %q", op)
+	}
+	if path == "" {
+		attrs, ok := value.(map[string]interface{})
+		if !ok || op == "remove" {
+			return scimErrorf(http.StatusBadRequest, "noTarget", "An operation without path needs an object value")
+		}
+		for name, v := range attrs {
+			if err := scimPatch(doc, op, name, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	m := scimPath.FindStringSubmatch(path)
+	if m == nil {
+		return scimErrorf(http.StatusBadRequest, "invalidPath", "Invalid path %q", path)
+	}
+	key := scimAttr(doc, m[1])
+	switch strings.ToLower(key) {
+	case "id", "meta", "groups", "schemas":
+		return scimErrorf(http.StatusBadRequest, "mutability", "%s can't be changed", m[1])
+	}
+	filterAttr, sub := m[2], m[4]
+	filterValue, _ := strconv.Unquote(`"` + m[3] + `"`)
+
+	switch {
+	case filterAttr == "" && sub == "":
+		existing, isList := doc[key].([]interface{})
+		switch {
+		case op == "remove":
+			delete(doc, key)
+		case op == "add" && isList:
+			if values, ok := value.([]interface{}); ok {
+				doc[key] = append(existing, values...)
+			} else {
+				doc[key] = append(existing, value)
+			}
+		default:
+			doc[key] = value
+		}
+	case filterAttr == "":
+		obj, _ := doc[key].(map[string]interface{})
+		if obj == nil {
+			obj = map[string]interface{}{}
+			doc[key] = obj
+		}
+		subKey := scimAttr(obj, sub)
+		if op == "remove" {
+			delete(obj, subKey)
+		} else {
+			obj[subKey] = value
+		}
+	default:
+		list, _ := doc[key].([]interface{})
+		matched := false
+		kept := list[:0:0]
+		for _, item := range list {
+			elem, _ := item.(map[string]interface{})
+			v, _ := elem[scimAttr(elem, filterAttr)].(string)
+			if elem == nil || !strings.EqualFold(v, filterValue) {
+				kept = append(kept, item)
+				continue
+			}
+			matched = true
+			switch {
+			case op == "remove" && sub == "":
+				continue
+			case op == "remove":
+				delete(elem, scimAttr(elem, sub))
+			case sub == "":
+				item = value
+			default:
+				elem[scimAttr(elem, sub)] = value
+			}
+			kept = append(kept, item)
+		}
+		if !matched && op != "remove" {
+			if sub == "" {
+				kept = append(kept, value)
+			} else {
+				kept = append(kept, map[string]interface{}{filterAttr: filterValue, sub: value})
+			}
+		}
+		doc[key] = kept
+	}
+	return nil
+}
+
+// scimAttr returns the key of doc matching an attribute name regardless of
+// case, or the name if there is none
+func scimAttr(doc map[string]interface{}, name string) string {
+	for key := range doc {
+		if strings.EqualFold(key, name) {
+			return key
+		}
+	}
+	return name
+}
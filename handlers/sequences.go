@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"net/http"
+
+	"sql-engine/rbac"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sequenceWarnFraction is the share of a sequence's range after which it is
+// reported as near exhaustion
+const sequenceWarnFraction = 0.8
+
+// SequenceInfo describes a sequence. LastValue is nil until the sequence is
+// first used (or when it can't be read), Cache when the engine has none.
+type SequenceInfo struct {
+	Schema     string         `json:"schema"`
+	Name       string         `json:"name"`
+	DataType   string         `json:"data_type"`
+	StartValue int64          `json:"start_value"`
+	Increment  int64          `json:"increment"`
+	MinValue   int64          `json:"min_value"`
+	MaxValue   int64          `json:"max_value"`
+	Cache      *int64         `json:"cache"`
+	Cycle      bool           `json:"cycle"`
+	LastValue  *int64         `json:"last_value"`
+	OwnedBy    *SequenceOwner `json:"owned_by"`
+	// UsedFraction is the share of the range between min and max already
+	// consumed in the direction of the increment
+	UsedFraction   float64 `json:"used_fraction"`
+	NearExhaustion bool    `json:"near_exhaustion"`
+}
+
+// SequenceOwner is the column a sequence feeds, e.g. a serial or identity
+// column
+type SequenceOwner struct {
+	Table  string `json:"table"`
+	Column string `json:"column"`
+}
+
+// GetSequences lists the sequences of a schema with their current values
+func (h *Handler) GetSequences(c *gin.Context) {
+	schema, ok := h.schemaParam(c)
+	if !ok {
+		return
+	}
+	sequences, err := h.dialect.ListSequences(h.db, schema)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	access := rbac.FromContext(c.Request.Context())
+	out := []SequenceInfo{}
+	for _, s := range sequences {
+		if access != nil && s.OwnedBy != nil && !access.CanTable(h.grantSchema(schema), s.OwnedBy.Table) {
+			continue
+		}
+		s.UsedFraction = s.used()
+		// Cycling sequences wrap around instead of running out
+		s.NearExhaustion = !s.Cycle && s.UsedFraction >= sequenceWarnFraction
+		out = append(out, s)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"schema": schema, "sequences": out})
+}
+
+func (s SequenceInfo) used() float64 {
+	if s.LastValue == nil || s.MaxValue <= s.MinValue {
+		return 0
+	}
+	// Convert first; the full int64 range overflows in integer arithmetic
+	span := float64(s.MaxValue) - float64(s.MinValue)
+	if s.Increment < 0 {
+		return (float64(s.MaxValue) - float64(*s.LastValue)) / span
+	}
+	return (float64(*s.LastValue) - float64(s.MinValue)) / span
+}
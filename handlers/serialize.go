@@ -0,0 +1,410 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ResultFormat selects how the values of a query result are written. The
+// zero value keeps the values as the driver reads them: binary values in
+// base64, PostgreSQL NUMERIC, interval and array values as the text
+// PostgreSQL prints, json and jsonb in base64, and timestamps in RFC 3339.
+type ResultFormat struct {
+	// Binary is "base64", "hex", or "preview" to return a BinaryPreview of
+	// each value
+	Binary string `json:"binary" form:"binary"`
+	// Numbers is "number" to write NUMERIC values as JSON numbers, or
+	// "string" to write every number as a string so that clients reading
+	// JSON numbers as doubles keep every digit
+	Numbers string `json:"numbers" form:"numbers"`
+	// Timezone is the IANA zone timestamps with a time zone are converted to
+	Timezone string `json:"timezone" form:"timezone"`
+	// Timestamps is "rfc3339", "unix", "unix_ms" or a Go time layout such
+	// as "2006-01-02 15:04:05"
+	Timestamps string `json:"timestamps" form:"timestamps"`
+	// Null is written in place of NULL values when set
+	Null *string `json:"null" form:"null"`
+	// Intervals is "text", "iso8601", or "seconds" counting months as 30
+	// days
+	Intervals string `json:"intervals" form:"intervals"`
+	// Arrays is "text", or "json" to write PostgreSQL arrays as JSON arrays
+	Arrays string `json:"arrays" form:"arrays"`
+	// JSON is "base64", "embed" to write json and jsonb values as JSON, or
+	// "string"
+	JSON string `json:"json" form:"json"`
+}
+
+// resultFormat is a validated ResultFormat
+type resultFormat struct {
+	ResultFormat
+	loc          *time.Location
+	previewBytes int
+}
+
+type resultFormatKey struct{}
+
+// withResultFormat makes the queries run with ctx write their values in f
+func withResultFormat(ctx context.Context, f *resultFormat) context.Context {
+	if f == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, resultFormatKey{}, f)
+}
+
+func resultFormatFrom(ctx context.Context) *resultFormat {
+	f, _ := ctx.Value(resultFormatKey{}).(*resultFormat)
+	return f
+}
+
+// queryResultFormat reads a ResultFormat from the query string
+func (h *Handler) queryResultFormat(c *gin.Context) (*resultFormat, bool) {
+	var f ResultFormat
+	if err := c.ShouldBindQuery(&f); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return h.resultFormat(c, f)
+}
+
+// resultFormat validates f, answering 400 when it is invalid. It returns nil
+// for the defaults.
+func (h *Handler) resultFormat(c *gin.Context, f ResultFormat) (*resultFormat, bool) {
+	if f == (ResultFormat{Binary: f.Binary}) && (f.Binary == "" || f.Binary == "base64") {
+		return nil, true
+	}
+	bad := func(msg string) (*resultFormat, bool) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return nil, false
+	}
+	rf := &resultFormat{ResultFormat: f, previewBytes: h.cfg.Query.BinaryPreviewBytes}
+	switch {
+	case !oneOf(f.Binary, "base64", "hex", "preview"):
+		return bad("binary must be base64, hex or preview")
+	case !oneOf(f.Numbers, "number", "string"):
+		return bad("numbers must be number or string")
+	case !oneOf(f.Intervals, "text", "iso8601", "seconds"):
+		return bad("intervals must be text, iso8601 or seconds")
+	case !oneOf(f.Arrays, "text", "json"):
+		return bad("arrays must be text or json")
+	case !oneOf(f.JSON, "base64", "embed", "string"):
+		return bad("json must be base64, embed or string")
+	}
+	// A layout without any element of the reference time formats nothing
+	if !oneOf(f.Timestamps, "rfc3339", "unix", "unix_ms") && time.Unix(0, 0).UTC().Format(f.Timestamps) == f.Timestamps {
+		return bad("timestamps must be rfc3339, unix, unix_ms or a Go time layout")
+	}
+	if f.Timezone != "" {
+		loc, err := time.LoadLocation(f.Timezone)
+		if err != nil {
+			return bad("Unknown timezone " + f.Timezone)
+		}
+		rf.loc = loc
+	}
+	return rf, true
+}
+
+// oneOf reports whether s is empty or one of values
+func oneOf(s string, values ...string) bool {
+	if s == "" {
+		return true
+	}
+	for _, v := range values {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// columnTypes maps the columns of rows to their database type names, upper
+// case; it is empty when the driver doesn't tell them
+func columnTypes(rows *sql.Rows) map[string]string {
+	types := map[string]string{}
+	cts, err := rows.ColumnTypes()
+	if err != nil {
+		return types
+	}
+	for _, ct := range cts {
+		types[ct.Name()] = strings.ToUpper(ct.DatabaseTypeName())
+	}
+	return types
+}
+
+// apply rewrites the values of rows in place, given the database types of
+// their columns. A nil format leaves them as they are.
+func (f *resultFormat) apply(types map[string]string, rows []map[string]interface{}) []map[string]interface{} {
+	if f == nil {
+		return rows
+	}
+	for _, row := range rows {
+		for col, v := range row {
+			row[col] = f.value(types[col], v)
+		}
+	}
+	return rows
+}
+
+func (f *resultFormat) value(typ string, v interface{}) interface{} {
+	if v == nil {
+		if f.Null != nil {
+			return *f.Null
+		}
+		return nil
+	}
+	if typ == "JSON" || typ == "JSONB" {
+		var doc []byte
+		switch d := v.(type) {
+		case []byte:
+			doc = d
+		case string:
+			doc = []byte(d)
+		}
+		switch {
+		case doc == nil:
+		case f.JSON == "embed" && json.Valid(doc):
+			return json.RawMessage(doc)
+		case f.JSON == "string":
+			return string(doc)
+		}
+	}
+
+	switch x := v.(type) {
+	case []byte:
+		switch f.Binary {
+		case "hex":
+			return hex.EncodeToString(x)
+		case "preview":
+			return binaryPreview(x, f.previewBytes)
+		}
+	case time.Time:
+		// DATE and TIMESTAMP values are wall times, which a zone would shift
+		if f.loc != nil && typ != "DATE" && typ != "TIMESTAMP" {
+			x = x.In(f.loc)
+		}
+		switch f.Timestamps {
+		case "", "rfc3339":
+			return x
+		case "unix":
+			return x.Unix()
+		case "unix_ms":
+			return x.UnixMilli()
+		default:
+			return x.Format(f.Timestamps)
+		}
+	case int64:
+		if f.Numbers == "string" {
+			return strconv.FormatInt(x, 10)
+		}
+	case float64:
+		if f.Numbers == "string" {
+			return strconv.FormatFloat(x, 'g', -1, 64)
+		}
+	case string:
+		switch {
+		case typ == "INTERVAL" && f.Intervals != "" && f.Intervals != "text":
+			if iv, ok := parseInterval(x); ok {
+				if f.Intervals == "seconds" {
+					return iv.seconds()
+				}
+				return iv.iso8601()
+			}
+		case strings.HasPrefix(typ, "_") && f.Arrays == "json":
+			if elems, ok := parseArray(x, strings.TrimPrefix(typ, "_"), f.Numbers); ok {
+				return elems
+			}
+		case f.Numbers == "number" && (strings.HasPrefix(typ, "NUMERIC") || strings.HasPrefix(typ, "DECIMAL")):
+			// NaN and Infinity have no JSON number
+			if json.Valid([]byte(x)) {
+				return json.Number(x)
+			}
+		}
+	}
+	return v
+}
+
+// pgInterval is an interval as PostgreSQL keeps it
+type pgInterval struct {
+	months, days int64
+	micros       int64
+}
+
+// intervalPart matches a "<n> <unit>" part of PostgreSQL's interval output
+var intervalPart = regexp.MustCompile(`^(-?\d+) (years?|mons?|days?)$`)
+
+// parseInterval reads an interval in PostgreSQL's default output style,
+// e.g. "1 year 2 mons -3 days 04:05:06.5"
+func parseInterval(s string) (pgInterval, bool) {
+	var iv pgInterval
+	fields := strings.Fields(s)
+	for i := 0; i < len(fields); i++ {
+		if i+1 < len(fields) {
+			if m := intervalPart.FindStringSubmatch(fields[i] + " " + fields[i+1]); m != nil {
+				n, _ := strconv.ParseInt(m[1], 10, 64)
+				switch m[2][0] {
+				case 'y':
+					iv.months += 12 * n
+				case 'm':
+					iv.months += n
+				default:
+					iv.days += n
+				}
+				i++
+				continue
+			}
+		}
+		clock := fields[i]
+		sign := int64(1)
+		if strings.HasPrefix(clock, "-") {
+			sign, clock = -1, clock[1:]
+		}
+		parts := strings.Split(clock, ":")
+		if len(parts) != 3 {
+			return iv, false
+		}
+		h, err1 := strconv.ParseInt(parts[0], 10, 64)
+		m, err2 := strconv.ParseInt(parts[1], 10, 64)
+		sec, err3 := strconv.ParseFloat(parts[2], 64)
+		if err1 != nil || err2 != nil || err3 != nil {
+			return iv, false
+		}
+		iv.micros += sign * ((h*3600+m*60)*1e6 + int64(math.Round(sec*1e6)))
+	}
+	return iv, true
+}
+
+func (iv pgInterval) seconds() float64 {
+	return float64(iv.months)*30*86400 + float64(iv.days)*86400 + float64(iv.micros)/1e6
+}
+
+// iso8601 writes the interval as an ISO 8601 duration, e.g. P1Y2M3DT4H5M6S
+func (iv pgInterval) iso8601() string {
+	var b strings.Builder
+	b.WriteString("P")
+	if y := iv.months / 12; y != 0 {
+		fmt.Fprintf(&b, "%dY", y)
+	}
+	if m := iv.months % 12; m != 0 {
+		fmt.Fprintf(&b, "%dM", m)
+	}
+	if iv.days != 0 {
+		fmt.Fprintf(&b, "%dD", iv.days)
+	}
+	if iv.micros != 0 {
+		b.WriteString("T")
+		us := iv.micros
+		if h := us / 3600e6; h != 0 {
+			fmt.Fprintf(&b, "%dH", h)
+		}
+		if m := us % 3600e6 / 60e6; m != 0 {
+			fmt.Fprintf(&b, "%dM", m)
+		}
+		if s := us % 60e6; s != 0 {
+			b.WriteString(strconv.FormatFloat(float64(s)/1e6, 'f', -1, 64) + "S")
+		}
+	}
+	if b.Len() == 1 {
+		return "PT0S"
+	}
+	return b.String()
+}
+
+// parseArray reads a PostgreSQL array literal such as {1,2,NULL} or
+// {{"a b",c},{d,e}} of elements of type elem into nested slices. Numbers
+// and booleans are converted unless numbers asks for strings.
+func parseArray(s, elem, numbers string) ([]interface{}, bool) {
+	// A lower bound other than 1 is written first, e.g. [0:1]={a,b}
+	if strings.HasPrefix(s, "[") {
+		_, s, _ = strings.Cut(s, "=")
+	}
+	p := arrayParser{s: s, elem: elem, numbers: numbers}
+	v, ok := p.array()
+	return v, ok && p.i == len(p.s)
+}
+
+type arrayParser struct {
+	s             string
+	i             int
+	elem, numbers string
+}
+
+func (p *arrayParser) array() ([]interface{}, bool) {
+	if p.i >= len(p.s) || p.s[p.i] != '{' {
+		return nil, false
+	}
+	p.i++
+	elems := []interface{}{}
+	if p.i < len(p.s) && p.s[p.i] == '}' {
+		p.i++
+		return elems, true
+	}
+	for p.i < len(p.s) {
+		switch p.s[p.i] {
+		case '{':
+			sub, ok := p.array()
+			if !ok {
+				return nil, false
+			}
+			elems = append(elems, sub)
+		case '"':
+			var b strings.Builder
+			for p.i++; p.i < len(p.s) && p.s[p.i] != '"'; p.i++ {
+				if p.s[p.i] == '\\' && p.i+1 < len(p.s) {
+					p.i++
+				}
+				b.WriteByte(p.s[p.i])
+			}
+			if p.i >= len(p.s) {
+				return nil, false
+			}
+			p.i++
+			elems = append(elems, b.String())
+		default:
+			end := strings.IndexAny(p.s[p.i:], ",}")
+			if end < 0 {
+				return nil, false
+			}
+			raw := strings.TrimSpace(p.s[p.i : p.i+end])
+			p.i += end
+			elems = append(elems, p.scalar(raw))
+		}
+		if p.i >= len(p.s) {
+			return nil, false
+		}
+		if p.s[p.i] == '}' {
+			p.i++
+			return elems, true
+		}
+		if p.s[p.i] != ',' {
+			return nil, false
+		}
+		p.i++
+	}
+	return nil, false
+}
+
+// scalar converts an unquoted element
+func (p *arrayParser) scalar(raw string) interface{} {
+	if strings.EqualFold(raw, "NULL") {
+		return nil
+	}
+	switch p.elem {
+	case "BOOL":
+		return raw == "t"
+	case "INT2", "INT4", "INT8", "FLOAT4", "FLOAT8", "NUMERIC":
+		if json.Valid([]byte(raw)) && p.numbers != "string" {
+			return json.Number(raw)
+		}
+	}
+	return raw
+}
@@ -0,0 +1,434 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"sql-engine/auth"
+	"sql-engine/cache"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sessionStep names the savepoint each statement of a session runs in
+const sessionStep = "session_step"
+
+// Session is a transaction that several requests continue: its queries
+// read one snapshot and, in write mode, row writes made through it are
+// only seen by others once it commits. Sessions live on the replica that
+// opened them.
+type Session struct {
+	// ID ends with the replica that opened the session, so that the others
+	// can point requests to it
+	ID string `json:"id"`
+	// Mode is read, or write to take the writes of the row endpoints sent
+	// with its ID in X-Session-ID
+	Mode       string    `json:"mode"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	Statements int       `json:"statements"`
+	// IdleExpiresAt is when the session is rolled back unless used again,
+	// ExpiresAt when it is rolled back however busy
+	IdleExpiresAt time.Time `json:"idle_expires_at"`
+	ExpiresAt     time.Time `json:"expires_at"`
+}
+
+// SessionRequest opens a session, in read mode unless Mode is write
+type SessionRequest struct {
+	Mode string `json:"mode"`
+}
+
+// SessionQueryRequest runs a SELECT in a session
+type SessionQueryRequest struct {
+	SQL string `json:"sql"`
+	ResultFormat
+}
+
+// dbSession is an open session and the transaction it holds. Its Session
+// fields are guarded by the registry's lock.
+type dbSession struct {
+	Session
+	principal string
+	tx        *sql.Tx
+	end       func()
+	cancel    context.CancelFunc
+	// mu is held by the one request using the session at a time
+	mu    sync.Mutex
+	timer *time.Timer
+	// steps counts the statements run, copied to Statements between
+	// requests
+	steps int
+	// broken is why the transaction can't go on, once it can't
+	broken error
+}
+
+// sessionRegistry holds the sessions open on this replica
+type sessionRegistry struct {
+	mu   sync.Mutex
+	open map[string]*dbSession
+}
+
+type sessionKey struct{}
+
+// withSession makes the queries run with ctx use the session's transaction
+func withSession(ctx context.Context, s *dbSession) context.Context {
+	return context.WithValue(ctx, sessionKey{}, s)
+}
+
+func sessionFrom(ctx context.Context) *dbSession {
+	s, _ := ctx.Value(sessionKey{}).(*dbSession)
+	return s
+}
+
+// beginStatement starts the read-only transaction a query runs in or, in a
+// session, a savepoint of its transaction, which end rolls back so that a
+// failed statement leaves the session usable
+func (h *Handler) beginStatement(ctx context.Context) (*sql.Tx, func(), error) {
+	if s := sessionFrom(ctx); s != nil {
+		if err := s.savepoint(ctx); err != nil {
+			return nil, nil, err
+		}
+		return s.tx, func() { s.endStep(false) }, nil
+	}
+	return h.dialect.BeginReadOnly(ctx, h.queryDB(ctx))
+}
+
+// step runs fn in a savepoint of the session's transaction, keeping its
+// work only when it succeeds
+func (s *dbSession) step(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	if err := s.savepoint(ctx); err != nil {
+		return &QueryError{Status: http.StatusInternalServerError, Message: "Session failed: " + err.Error(), Err: err}
+	}
+	err := fn(s.tx)
+	s.endStep(err == nil)
+	return err
+}
+
+func (s *dbSession) savepoint(ctx context.Context) error {
+	s.steps++
+	if _, err := s.tx.ExecContext(ctx, "SAVEPOINT "+sessionStep); err != nil {
+		s.broken = err
+		return err
+	}
+	return nil
+}
+
+// endStep releases the savepoint of a statement, rolling back to it unless
+// keep is set. The session ends if that fails, e.g. for a lost connection.
+func (s *dbSession) endStep(keep bool) {
+	ctx := context.Background()
+	if !keep {
+		if _, err := s.tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT "+sessionStep); err != nil {
+			s.broken = err
+			return
+		}
+	}
+	if _, err := s.tx.ExecContext(ctx, "RELEASE SAVEPOINT "+sessionStep); err != nil {
+		s.broken = err
+	}
+}
+
+// close rolls back the transaction unless committed and frees its
+// connection
+func (s *dbSession) close() {
+	s.end()
+	s.cancel()
+}
+
+// CreateSession opens a session: it takes a connection, and holds it until
+// the session is committed, rolled back or expires
+func (h *Handler) CreateSession(c *gin.Context) {
+	cfg := h.cfg.Sessions
+	if !cfg.Enabled {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Sessions are disabled"})
+		return
+	}
+	var req SessionRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		return
+	}
+	switch req.Mode {
+	case "", "read":
+		req.Mode = "read"
+	case "write":
+		if !h.cfg.Writes.Enabled {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Writes are disabled"})
+			return
+		}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mode must be read or write"})
+		return
+	}
+
+	principal := auth.Principal(c)
+	now := time.Now().UTC()
+	s := &dbSession{
+		Session:   Session{ID: h.newSessionID(), Mode: req.Mode, CreatedAt: now, LastUsedAt: now, ExpiresAt: now.Add(cfg.MaxLifetime)},
+		principal: principal,
+	}
+	// The session counts against the limits, but can't be used, while its
+	// transaction starts
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reg := &h.sessions
+	reg.mu.Lock()
+	mine := 0
+	for _, open := range reg.open {
+		if open.principal == principal {
+			mine++
+		}
+	}
+	switch {
+	case mine >= cfg.MaxPerCaller:
+		reg.mu.Unlock()
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many open sessions; commit or roll back one first"})
+		return
+	case len(reg.open) >= cfg.MaxOpen:
+		reg.mu.Unlock()
+		respondQueryError(c, &QueryError{Status: http.StatusServiceUnavailable, Message: "All sessions are in use, try again shortly", RetryAfter: 10 * time.Second})
+		return
+	}
+	if reg.open == nil {
+		reg.open = map[string]*dbSession{}
+	}
+	reg.open[s.ID] = s
+	reg.mu.Unlock()
+
+	// The transaction outlives the request, but waiting for a connection
+	// ends with it
+	var ctx context.Context
+	ctx, s.cancel = context.WithCancel(context.WithoutCancel(c.Request.Context()))
+	stop := context.AfterFunc(c.Request.Context(), s.cancel)
+	tx, end, err := h.dialect.BeginSession(ctx, h.db, req.Mode == "write")
+	if stop() && err == nil {
+		reg.mu.Lock()
+		s.tx, s.end = tx, end
+		h.armSession(s)
+		resp := s.Session
+		reg.mu.Unlock()
+		c.JSON(http.StatusCreated, resp)
+		return
+	}
+	if err == nil {
+		end()
+		err = context.Cause(ctx)
+	}
+	s.cancel()
+	reg.mu.Lock()
+	delete(reg.open, s.ID)
+	reg.mu.Unlock()
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start session: " + err.Error()})
+}
+
+// GetSession describes one of the caller's sessions
+func (h *Handler) GetSession(c *gin.Context) {
+	cache.SetClass(c, cache.NoStore)
+	reg := &h.sessions
+	reg.mu.Lock()
+	s := reg.open[c.Param("id")]
+	if s == nil || s.principal != auth.Principal(c) || s.tx == nil {
+		reg.mu.Unlock()
+		h.sessionNotFound(c, c.Param("id"))
+		return
+	}
+	resp := s.Session
+	reg.mu.Unlock()
+	c.JSON(http.StatusOK, resp)
+}
+
+// RunSessionQuery runs a SELECT in a session's transaction, with the
+// checks, limits and masking of POST /run-query
+func (h *Handler) RunSessionQuery(c *gin.Context) {
+	var req SessionQueryRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		return
+	}
+	format, ok := h.resultFormat(c, req.ResultFormat)
+	if !ok {
+		return
+	}
+	s, ok := h.acquireSession(c, c.Param("id"))
+	if !ok {
+		return
+	}
+	defer h.releaseSession(s)
+
+	result, err := h.executeQuery(withResultFormat(withSession(c.Request.Context(), s), format), req.SQL, nil)
+	if err != nil {
+		respondQueryError(c, err)
+		return
+	}
+	resp := gin.H{"columns": result.Columns, "rows": result.Rows}
+	if result.SuppressedGroups > 0 {
+		resp["suppressed_groups"] = result.SuppressedGroups
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// CommitSession commits a session's transaction and ends the session
+func (h *Handler) CommitSession(c *gin.Context) {
+	s, ok := h.acquireSession(c, c.Param("id"))
+	if !ok {
+		return
+	}
+	h.endSession(s)
+	err := s.tx.Commit()
+	s.close()
+	s.mu.Unlock()
+	switch {
+	case err != nil && h.dialect.Retryable(err):
+		c.JSON(http.StatusConflict, gin.H{"error": "Transaction aborted by concurrent writes: " + err.Error()})
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Commit failed: " + err.Error()})
+	default:
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// RollbackSession rolls back a session's transaction and ends the session
+func (h *Handler) RollbackSession(c *gin.Context) {
+	s, ok := h.acquireSession(c, c.Param("id"))
+	if !ok {
+		return
+	}
+	h.endSession(s)
+	s.close()
+	s.mu.Unlock()
+	c.Status(http.StatusNoContent)
+}
+
+// acquireSession takes one of the caller's sessions for the request,
+// answering 404 for unknown ones, 421 for those of another replica and 409
+// while another request uses it.
+// releaseSession gives it back.
+func (h *Handler) acquireSession(c *gin.Context, id string) (*dbSession, bool) {
+	reg := &h.sessions
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	s := reg.open[id]
+	if s == nil || s.principal != auth.Principal(c) {
+		h.sessionNotFound(c, id)
+		return nil, false
+	}
+	if !s.mu.TryLock() {
+		c.JSON(http.StatusConflict, gin.H{"error": "Session is busy with another request"})
+		return nil, false
+	}
+	s.timer.Stop()
+	return s, true
+}
+
+// newSessionID returns a random session ID ending with this replica's ID
+func (h *Handler) newSessionID() string {
+	return newID() + "." + base64.RawURLEncoding.EncodeToString([]byte(h.cfg.Server.ReplicaID))
+}
+
+// sessionReplica returns the replica a session ID names
+func sessionReplica(id string) (string, bool) {
+	_, encoded, ok := strings.Cut(id, ".")
+	if !ok {
+		return "", false
+	}
+	replica, err := base64.RawURLEncoding.DecodeString(encoded)
+	return string(replica), err == nil && len(replica) > 0
+}
+
+// sessionNotFound answers a request for a session this replica doesn't
+// hold: 421 with the replica that opened it in X-Replica-ID, or 404 if it
+// was opened here and has ended
+func (h *Handler) sessionNotFound(c *gin.Context, id string) {
+	if replica, ok := sessionReplica(id); ok && replica != h.cfg.Server.ReplicaID {
+		c.Header("X-Replica-ID", replica)
+		c.JSON(http.StatusMisdirectedRequest, gin.H{"error": "Session is held by replica " + replica, "replica": replica})
+		return
+	}
+	c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+}
+
+// releaseSession gives back a session after a request, ending it if its
+// transaction broke
+func (h *Handler) releaseSession(s *dbSession) {
+	defer s.mu.Unlock()
+	if s.broken != nil {
+		slog.Warn("Session ended, its transaction failed", "session", s.ID, "error", s.broken)
+		h.endSession(s)
+		s.close()
+		return
+	}
+	reg := &h.sessions
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	s.LastUsedAt = time.Now().UTC()
+	s.Statements = s.steps
+	h.armSession(s)
+}
+
+// armSession sets when an idle session expires. The registry's lock must be
+// held.
+func (h *Handler) armSession(s *dbSession) {
+	s.IdleExpiresAt = s.LastUsedAt.Add(h.cfg.Sessions.IdleTimeout)
+	if s.IdleExpiresAt.After(s.ExpiresAt) {
+		s.IdleExpiresAt = s.ExpiresAt
+	}
+	if s.timer == nil {
+		s.timer = time.AfterFunc(time.Until(s.IdleExpiresAt), func() { h.expireSession(s) })
+		return
+	}
+	s.timer.Reset(time.Until(s.IdleExpiresAt))
+}
+
+// expireSession rolls back a session left idle or open too long. Sessions
+// in use are left to releaseSession, which sets a new expiry.
+func (h *Handler) expireSession(s *dbSession) {
+	reg := &h.sessions
+	reg.mu.Lock()
+	if reg.open[s.ID] != s || time.Now().Before(s.IdleExpiresAt) || !s.mu.TryLock() {
+		reg.mu.Unlock()
+		return
+	}
+	delete(reg.open, s.ID)
+	reg.mu.Unlock()
+	defer s.mu.Unlock()
+	slog.Info("Session expired, rolled back", "session", s.ID, "principal", s.principal)
+	s.close()
+}
+
+// endSession removes a session from the registry
+func (h *Handler) endSession(s *dbSession) {
+	reg := &h.sessions
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	delete(reg.open, s.ID)
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+}
+
+// closeSessions rolls back every open session, at shutdown
+func (h *Handler) closeSessions() {
+	reg := &h.sessions
+	reg.mu.Lock()
+	open := reg.open
+	reg.open = nil
+	reg.mu.Unlock()
+	for _, s := range open {
+		s.mu.Lock()
+		if s.timer != nil {
+			s.timer.Stop()
+		}
+		if s.tx != nil {
+			s.close()
+		}
+		s.mu.Unlock()
+	}
+}
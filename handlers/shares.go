@@ -0,0 +1,286 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"sql-engine/artifact"
+	"sql-engine/auth"
+	"sql-engine/rbac"
+	"sql-engine/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+const sharePrefix = "share/"
+
+// ShareRequest shares a query, given as SQL or as a saved query with
+// variable values. With Snapshot, its result is run now and frozen.
+type ShareRequest struct {
+	SQL        string         `json:"sql"`
+	SavedQuery string         `json:"saved_query"`
+	Variables  map[string]any `json:"variables"`
+	Snapshot   bool           `json:"snapshot"`
+	// ExpiresIn is a duration such as 24h; sharing.default_ttl when empty
+	ExpiresIn string `json:"expires_in"`
+	ResultFormat
+}
+
+// QueryShare is a shared query. It is stored under the hash of its token,
+// which is only returned when the share is created.
+type QueryShare struct {
+	Token string `json:"token,omitempty"`
+	SQL   string `json:"sql"`
+	// SavedQuery, Variables and Declared record the saved query the SQL
+	// was copied from, the values bound to its variables and their types
+	SavedQuery string          `json:"saved_query,omitempty"`
+	Variables  map[string]any  `json:"variables,omitempty"`
+	Declared   []QueryVariable `json:"declared_variables,omitempty"`
+	// Format is the result format asked for, if any
+	Format *ResultFormat `json:"format,omitempty"`
+	// Result is the ID of the frozen result in the artifact store
+	Result    string    `json:"result,omitempty"`
+	CreatedBy string    `json:"created_by,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// shareKey is the store key of the share with token
+func shareKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return sharePrefix + hex.EncodeToString(sum[:])
+}
+
+// query returns the SQL to run with its bound variables
+func (s QueryShare) query() (string, []any, error) {
+	return bindQueryVariables(SavedQuery{SQL: s.SQL, Variables: s.Declared}, s.Variables)
+}
+
+// CreateShare stores a query under a new random token for GET /share/:token.
+// The query is checked against the caller's grants; with snapshot, it runs
+// and its result is kept.
+func (h *Handler) CreateShare(c *gin.Context) {
+	var req ShareRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		return
+	}
+	format, ok := h.resultFormat(c, req.ResultFormat)
+	if !ok {
+		return
+	}
+	ttl := h.cfg.Sharing.DefaultTTL
+	if req.ExpiresIn != "" {
+		d, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || d <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "expires_in must be a positive duration such as 24h"})
+			return
+		}
+		if d > h.cfg.Sharing.MaxTTL {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "expires_in must be at most " + h.cfg.Sharing.MaxTTL.String()})
+			return
+		}
+		ttl = d
+	}
+
+	now := time.Now()
+	share := QueryShare{
+		SQL:       req.SQL,
+		Variables: req.Variables,
+		CreatedBy: auth.Principal(c),
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+	if format != nil {
+		share.Format = &req.ResultFormat
+	}
+	switch {
+	case (req.SQL == "") == (req.SavedQuery == ""):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Set either sql or saved_query"})
+		return
+	case req.SavedQuery != "":
+		var q SavedQuery
+		if err := h.meta.Get(savedQueryPrefix+req.SavedQuery, &q); errors.Is(err, store.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Saved query not found"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		share.SQL, share.SavedQuery, share.Declared = q.SQL, q.ID, q.Variables
+	case len(req.Variables) > 0:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Variables can only be bound to a saved query"})
+		return
+	}
+
+	sqlText, args, err := share.query()
+	if err != nil {
+		respondQueryError(c, err)
+		return
+	}
+	ctx := c.Request.Context()
+	if req.Snapshot {
+		result, err := h.executeQuery(withResultFormat(withLineageJob(ctx, "share"), format), sqlText, nil, args...)
+		if err != nil {
+			respondQueryError(c, err)
+			return
+		}
+		if share.Result, err = h.saveResult(c, share.SQL, result); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Storing the result failed: " + err.Error()})
+			return
+		}
+	} else if _, err := h.prepareSelect(ctx, sqlText); err != nil {
+		respondQueryError(c, err)
+		return
+	}
+
+	b := make([]byte, 24)
+	rand.Read(b)
+	token := base64.RawURLEncoding.EncodeToString(b)
+	if err := h.meta.Put(shareKey(token), share); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	share.Token = token
+	c.JSON(http.StatusCreated, share)
+}
+
+// GetShare returns a shared query with its frozen result, or without one
+// runs it with the caller's grants. A frozen result is only returned if the
+// caller may run the query, with the caller's masks applied, and not to
+// callers held to aggregates. ?run=true runs it even when a result was
+// frozen.
+func (h *Handler) GetShare(c *gin.Context) {
+	share, ok := h.loadShare(c)
+	if !ok {
+		return
+	}
+	resp := gin.H{"share": share}
+	ctx := c.Request.Context()
+	sqlText, args, err := share.query()
+	if err != nil {
+		respondQueryError(c, err)
+		return
+	}
+	if share.Result != "" && c.Query("run") != "true" {
+		// The frozen rows hold what the creator could read; the viewer
+		// sees them only through their own grants and masks
+		prep, err := h.prepareSelect(ctx, sqlText)
+		if err != nil {
+			respondQueryError(c, err)
+			return
+		}
+		// Nor do they carry group sizes, so a viewer held to aggregates
+		// gets the query run again with small groups left out
+		if prep.Aggregate == nil {
+			h.frozenShareResult(c, resp, share.Result, prep)
+			return
+		}
+	}
+
+	var format *resultFormat
+	if share.Format != nil {
+		if format, ok = h.resultFormat(c, *share.Format); !ok {
+			return
+		}
+	}
+	result, err := h.executeQuery(withResultFormat(withLineageJob(ctx, "share"), format), sqlText, nil, args...)
+	if err != nil {
+		respondQueryError(c, err)
+		return
+	}
+	resp["columns"], resp["rows"] = result.Columns, result.Rows
+	c.JSON(http.StatusOK, resp)
+}
+
+// frozenShareResult answers with the frozen result id of a share, masked as
+// prep says
+func (h *Handler) frozenShareResult(c *gin.Context, resp gin.H, id string, prep *preparedSelect) {
+	stored, err := h.readResult(c.Request.Context(), id)
+	if errors.Is(err, artifact.ErrNotFound) {
+		c.JSON(http.StatusGone, gin.H{"error": "The frozen result has expired; use ?run=true"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Reading the result failed: " + err.Error()})
+		return
+	}
+	resp["columns"], resp["rows"] = stored.Columns, h.applyMasking(prep.Mask, stored.Columns, stored.Rows)
+	resp["frozen_at"] = stored.CreatedAt
+	c.JSON(http.StatusOK, resp)
+}
+
+// DeleteShare revokes a link; only its creator and administrators may
+func (h *Handler) DeleteShare(c *gin.Context) {
+	share, ok := h.loadShare(c)
+	if !ok {
+		return
+	}
+	access := rbac.FromContext(c.Request.Context())
+	if share.CreatedBy != "" && share.CreatedBy != auth.Principal(c) && (access == nil || !access.IsAdmin()) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the creator can revoke this link"})
+		return
+	}
+	if err := h.meta.Delete(shareKey(c.Param("token"))); err != nil && !errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if share.Result != "" {
+		h.artifacts.Delete(context.Background(), resultArtifactPrefix+share.Result+".json")
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// loadShare reads the share of the :token parameter, answering 410 once it
+// has expired
+func (h *Handler) loadShare(c *gin.Context) (QueryShare, bool) {
+	var share QueryShare
+	if err := h.meta.Get(shareKey(c.Param("token")), &share); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Share link not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return share, false
+	}
+	if time.Now().After(share.ExpiresAt) {
+		c.JSON(http.StatusGone, gin.H{"error": "Share link expired"})
+		return share, false
+	}
+	return share, true
+}
+
+// StartShareExpiry deletes expired share links and their frozen results
+// every hour
+func (h *Handler) StartShareExpiry() {
+	h.sched.Every("share-expiry", time.Hour, func(ctx context.Context) {
+		h.expireShares(time.Now())
+	})
+}
+
+func (h *Handler) expireShares(now time.Time) {
+	keys, err := h.meta.List(sharePrefix)
+	if err != nil {
+		slog.Error("Failed to list share links", "error", err)
+		return
+	}
+	for _, key := range keys {
+		var share QueryShare
+		if err := h.meta.Get(key, &share); err != nil || now.Before(share.ExpiresAt) {
+			continue
+		}
+		if share.Result != "" {
+			h.artifacts.Delete(context.Background(), resultArtifactPrefix+share.Result+".json")
+		}
+		if err := h.meta.Delete(key); err != nil && !errors.Is(err, store.ErrNotFound) {
+			slog.Error("Failed to delete expired share link", "error", err)
+		}
+	}
+}
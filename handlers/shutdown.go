@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// cancelledWorkWait is how long Drain waits for cancelled work to record
+// its outcome, e.g. a materialize job marking itself failed
+const cancelledWorkWait = 5 * time.Second
+
+// backgroundWork tracks what requests leave running once answered, such as
+// materialize jobs and webhook runs, so that shutdown can wait for it
+type backgroundWork struct {
+	mu sync.Mutex
+	// active counts the work in progress. Once draining, idle is closed
+	// when the last one finishes.
+	active   int
+	draining bool
+	idle     chan struct{}
+	// ctx is cancelled when shutdown stops waiting
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// goBackground runs fn in the background with a context keeping ctx's
+// values but cancelled only when shutdown gives up waiting for it
+func (h *Handler) goBackground(ctx context.Context, fn func(ctx context.Context)) {
+	b := &h.background
+	ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(b.ctx, cancel)
+
+	b.mu.Lock()
+	b.active++
+	b.mu.Unlock()
+
+	go func() {
+		defer func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			if b.active--; b.active == 0 && b.draining {
+				close(b.idle)
+			}
+		}()
+		defer cancel()
+		defer stop()
+		fn(ctx)
+	}()
+}
+
+// Drain waits until ctx is done for scheduled runs and background work in
+// progress to finish, starting no more scheduled runs. Work still running
+// then is cancelled, given a moment to record its outcome, and ctx's error
+// returned. Sessions still open are rolled back.
+func (h *Handler) Drain(ctx context.Context) error {
+	h.closeSessions()
+	b := &h.background
+	b.mu.Lock()
+	if !b.draining {
+		b.draining = true
+		b.idle = make(chan struct{})
+		if b.active == 0 {
+			close(b.idle)
+		}
+	}
+	idle := b.idle
+	b.mu.Unlock()
+
+	schedErr := h.sched.Drain(ctx)
+	select {
+	case <-idle:
+		return schedErr
+	case <-ctx.Done():
+		select {
+		case <-idle:
+			return schedErr
+		default:
+		}
+		b.cancel()
+		select {
+		case <-idle:
+		case <-time.After(cancelledWorkWait):
+		}
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,285 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"sql-engine/embedding"
+	"sql-engine/rbac"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultSimilarK = 10
+	// similarDistanceColumn carries the distance next to the returned columns
+	similarDistanceColumn = "__distance"
+)
+
+// vectorMetrics maps the distance metrics of similarity searches to their
+// pgvector operators. inner_product gives the negative inner product, so
+// that smaller is nearer for every metric.
+var vectorMetrics = map[string]string{
+	"l2":            "<->",
+	"cosine":        "<=>",
+	"inner_product": "<#>",
+	"l1":            "<+>",
+}
+
+// SimilarRequest finds the rows nearest to a vector, given as is or as text
+// turned into one by the configured embedder
+type SimilarRequest struct {
+	// Column is the vector column searched; optional when the table has one
+	Column string    `json:"column"`
+	Vector []float64 `json:"vector"`
+	Text   string    `json:"text"`
+	// Metric is l2 (the default), cosine, inner_product or l1
+	Metric string `json:"metric"`
+	K      int    `json:"k"`
+	// Columns are returned for each match, by default every column but
+	// vectors
+	Columns []string       `json:"columns"`
+	Filters []ColumnFilter `json:"filters"`
+}
+
+// SimilarMatch is a row found by a similarity search
+type SimilarMatch struct {
+	Row      map[string]interface{} `json:"row"`
+	Distance float64                `json:"distance"`
+}
+
+// UseEmbedder sets the model turning the text of similarity searches into
+// vectors; nil accepts only vectors
+func (h *Handler) UseEmbedder(embedder embedding.Embedder) {
+	h.embedder = embedder
+}
+
+// isVectorColumn reports whether col holds pgvector vectors
+func isVectorColumn(col ColumnInfo) bool {
+	return col.UserType != nil && (col.UserType.Name == "vector" || col.UserType.Name == "halfvec")
+}
+
+// SimilarRows returns the k rows whose vector column is nearest to the
+// request's vector, nearest first, using the column's index when the metric
+// matches it. Filters narrow the rows searched.
+func (h *Handler) SimilarRows(c *gin.Context) {
+	schema, ok := h.schemaParam(c)
+	if !ok {
+		return
+	}
+	tableName := c.Param("name")
+	if matchesAny(h.cfg.Query.Denylist.Tables, tableName) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Denied: table " + tableName + " is not allowed"})
+		return
+	}
+	if !h.requireTable(c, schema, tableName) || !h.requireRowLevel(c, schema, tableName) {
+		return
+	}
+	var req SimilarRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		return
+	}
+	if (len(req.Vector) == 0) == (strings.TrimSpace(req.Text) == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Set either vector or text"})
+		return
+	}
+	if req.Metric == "" {
+		req.Metric = "l2"
+	}
+	op, ok := vectorMetrics[req.Metric]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "metric must be l2, cosine, inner_product or l1"})
+		return
+	}
+	maxRows := h.maxRows(c.Request.Context())
+	if req.K <= 0 || req.K > maxRows {
+		req.K = min(defaultSimilarK, maxRows)
+	}
+
+	columns, err := h.dialect.ListColumns(h.db, schema, tableName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(columns) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Table not found"})
+		return
+	}
+	var vectors []ColumnInfo
+	for _, col := range columns {
+		if isVectorColumn(col) && (req.Column == "" || req.Column == col.Name) {
+			vectors = append(vectors, col)
+		}
+	}
+	switch {
+	case len(vectors) == 0 && req.Column != "":
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Column " + req.Column + " is not a vector column"})
+		return
+	case len(vectors) == 0:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Table has no pgvector column"})
+		return
+	case len(vectors) > 1:
+		names := make([]string, len(vectors))
+		for i, col := range vectors {
+			names[i] = col.Name
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Table has several vector columns, choose one with column", "columns": names})
+		return
+	}
+	vec := vectors[0]
+	if !h.analysisColumn(c, schema, tableName, columns, "column", vec.Name) {
+		return
+	}
+
+	q := h.dialect.Quote
+	ctx := c.Request.Context()
+	var dims int
+	err = h.db.QueryRowContext(ctx, "SELECT atttypmod FROM pg_attribute WHERE attrelid = $1::regclass AND attname = $2",
+		q(orDefault(h.dialect, schema))+"."+q(tableName), vec.Name).Scan(&dims)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read vector dimensions: " + err.Error()})
+		return
+	}
+	if req.Text != "" {
+		if h.embedder == nil {
+			c.JSON(http.StatusNotImplemented, gin.H{"error": "Text queries need an embedder; send a vector instead"})
+			return
+		}
+		req.Vector, err = h.embedder.Embed(ctx, req.Text)
+		if err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Embedding request failed: " + err.Error()})
+			return
+		}
+	}
+	// An unconstrained column takes vectors of any length
+	if dims > 0 && len(req.Vector) != dims {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Column %s holds vectors of %d dimensions, got %d", vec.Name, dims, len(req.Vector))})
+		return
+	}
+	elems := make([]string, len(req.Vector))
+	for i, v := range req.Vector {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "vector must hold finite numbers"})
+			return
+		}
+		elems[i] = strconv.FormatFloat(v, 'g', -1, 64)
+	}
+
+	access := rbac.FromContext(ctx)
+	grantSchema := h.grantSchema(schema)
+	var roles []string
+	if access != nil {
+		roles = access.Roles
+	}
+	masked := h.masks.Columns(grantSchema, tableName, roles)
+	if len(req.Columns) == 0 {
+		for _, col := range filterColumns(access, grantSchema, tableName, columns) {
+			if !isVectorColumn(col) {
+				req.Columns = append(req.Columns, col.Name)
+			}
+		}
+	}
+	for _, col := range req.Columns {
+		if !slices.ContainsFunc(columns, func(ci ColumnInfo) bool { return ci.Name == col }) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Column " + col + " not found"})
+			return
+		}
+		if !access.CanColumn(grantSchema, tableName, col) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied: column " + col + " is not granted"})
+			return
+		}
+	}
+
+	args := []interface{}{"[" + strings.Join(elems, ",") + "]"}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return h.dialect.Placeholder(len(args))
+	}
+	distance := fmt.Sprintf("%s %s %s::%s.%s", q(vec.Name), op, h.dialect.Placeholder(1), q(vec.UserType.Schema), q(vec.UserType.Name))
+	conds := []string{q(vec.Name) + " IS NOT NULL"}
+	for i, f := range req.Filters {
+		if !h.analysisColumn(c, schema, tableName, columns, fmt.Sprintf("filters[%d]", i), f.Column) {
+			return
+		}
+		cond, err := h.filterCondition(f, columns, arg)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("filters[%d]: %v", i, err)})
+			return
+		}
+		conds = append(conds, cond)
+	}
+	selects := make([]string, 0, len(req.Columns)+1)
+	for _, col := range req.Columns {
+		selects = append(selects, q(col))
+	}
+	selects = append(selects, distance+" AS "+q(similarDistanceColumn))
+	// Ordering by the operator itself lets an HNSW or IVFFlat index serve
+	// the search
+	query := fmt.Sprintf("SELECT %s FROM %s.%s WHERE %s ORDER BY %s %s",
+		strings.Join(selects, ", "), q(schema), q(tableName), strings.Join(conds, " AND "),
+		distance, h.dialect.LimitClause(req.K))
+
+	if timeout := h.queryTimeout(ctx); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	release, err := h.acquireStatement(ctx)
+	if err != nil {
+		respondQueryError(c, err)
+		return
+	}
+	defer release()
+	tx, end, err := h.dialect.BeginReadOnly(ctx, h.queryDB(ctx))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start read-only transaction: " + err.Error()})
+		return
+	}
+	defer end()
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Execution failed: " + err.Error()})
+		return
+	}
+	defer rows.Close()
+	matches := []SimilarMatch{}
+	for rows.Next() {
+		vals := make([]interface{}, len(req.Columns))
+		var m SimilarMatch
+		ptrs := make([]interface{}, 0, len(vals)+1)
+		for i := range vals {
+			ptrs = append(ptrs, &vals[i])
+		}
+		ptrs = append(ptrs, &m.Distance)
+		if err := rows.Scan(ptrs...); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Row scan failed: " + err.Error()})
+			return
+		}
+		m.Row = map[string]interface{}{}
+		for i, col := range req.Columns {
+			m.Row[col] = vals[i]
+			if mask, ok := masked[strings.ToLower(col)]; ok {
+				m.Row[col] = h.masks.Apply(mask, vals[i])
+			}
+		}
+		matches = append(matches, m)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Row iteration error: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"schema":     schema,
+		"table_name": tableName,
+		"column":     vec.Name,
+		"metric":     req.Metric,
+		"matches":    matches,
+	})
+}
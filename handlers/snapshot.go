@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const schemaSnapshotKey = "schema/snapshot"
+
+// SchemaSnapshot is the persisted result of the last successful
+// introspection, or of one saved under a name for later diffs
+type SchemaSnapshot struct {
+	Schema    []TableSchema `json:"schema"`
+	FetchedAt time.Time     `json:"fetched_at"`
+	// Name, SchemaName and CreatedBy are only set on named snapshots
+	Name       string `json:"name,omitempty"`
+	SchemaName string `json:"schema_name,omitempty"`
+	CreatedBy  string `json:"created_by,omitempty"`
+	// Artifact is the key of the tables of a named snapshot in the artifact
+	// store, where Schema is left empty; older named snapshots hold them
+	// inline
+	Artifact string `json:"artifact,omitempty"`
+	Tables   int    `json:"tables,omitempty"`
+}
+
+// schemaSnapshot caches introspected schemas in memory. The default
+// schema's entry starts out as the persisted snapshot, served while the
+// first live introspection after boot is still running.
+type schemaSnapshot struct {
+	mu      sync.RWMutex
+	current *SchemaSnapshot
+	stale   bool
+	// digest hashes the tables of current, for X-Schema-Digest
+	digest string
+	// others holds the schemas other than the default by name
+	others map[string]*SchemaSnapshot
+	// loading serializes introspections, so that concurrent requests missing
+	// the cache wait for one load instead of each running their own
+	loading sync.Mutex
+}
+
+// WarmSchema loads the persisted schema snapshot so it can be served
+// immediately, then refreshes it from the database in the background
+func (h *Handler) WarmSchema() {
+	var snap SchemaSnapshot
+	if err := h.meta.Get(schemaSnapshotKey, &snap); err == nil {
+		h.snapshot.mu.Lock()
+		h.snapshot.current = &snap
+		h.snapshot.stale = true
+		h.snapshot.digest = schemaDigest(snap.Schema)
+		h.snapshot.mu.Unlock()
+		slog.Info("Loaded schema snapshot", "fetched_at", snap.FetchedAt)
+	}
+
+	go func() {
+		if _, err := h.refreshSchema(); err != nil {
+			slog.Error("Background schema refresh failed", "error", err)
+		}
+	}()
+}
+
+// staleSchema returns the persisted snapshot if a refresh is still pending
+func (h *Handler) staleSchema() (*SchemaSnapshot, bool) {
+	h.snapshot.mu.RLock()
+	defer h.snapshot.mu.RUnlock()
+	if h.snapshot.stale && h.snapshot.current != nil {
+		return h.snapshot.current, true
+	}
+	return nil, false
+}
+
+// StartSchemaRefresh reloads the cached schemas every
+// schema_cache.refresh_every on this replica
+func (h *Handler) StartSchemaRefresh() {
+	every := h.cfg.SchemaCache.RefreshEvery
+	if every == 0 || h.cfg.SchemaCache.TTL == 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(every)
+		defer ticker.Stop()
+		for range ticker.C {
+			h.snapshot.mu.RLock()
+			schemas := []string{""}
+			for name := range h.snapshot.others {
+				schemas = append(schemas, name)
+			}
+			h.snapshot.mu.RUnlock()
+			for _, schema := range schemas {
+				if _, err := h.reloadSchema(schema); err != nil {
+					slog.Error("Background schema refresh failed", "schema", orDefault(h.dialect, schema), "error", err)
+				}
+			}
+		}
+	}()
+}
+
+// cachedSchema returns the introspected tables of a schema, loading them
+// when the cached copy is missing or older than schema_cache.ttl
+func (h *Handler) cachedSchema(schema string) (*SchemaSnapshot, error) {
+	if snap := h.freshSchema(schema); snap != nil {
+		return snap, nil
+	}
+	h.snapshot.loading.Lock()
+	defer h.snapshot.loading.Unlock()
+	// Another request may have loaded it while this one waited
+	if snap := h.freshSchema(schema); snap != nil {
+		return snap, nil
+	}
+	return h.loadSchemaSnapshot(schema)
+}
+
+// freshSchema returns the cached schema if it is younger than the TTL
+func (h *Handler) freshSchema(schema string) *SchemaSnapshot {
+	ttl := h.cfg.SchemaCache.TTL
+	if ttl == 0 {
+		return nil
+	}
+	h.snapshot.mu.RLock()
+	defer h.snapshot.mu.RUnlock()
+	snap := h.snapshot.others[schema]
+	if h.isDefaultSchema(schema) {
+		snap = h.snapshot.current
+		if h.snapshot.stale {
+			return nil
+		}
+	}
+	if snap == nil || time.Since(snap.FetchedAt) >= ttl {
+		return nil
+	}
+	return snap
+}
+
+// reloadSchema introspects a schema now and replaces its cached copy
+func (h *Handler) reloadSchema(schema string) (*SchemaSnapshot, error) {
+	h.snapshot.loading.Lock()
+	defer h.snapshot.loading.Unlock()
+	return h.loadSchemaSnapshot(schema)
+}
+
+func (h *Handler) loadSchemaSnapshot(schema string) (*SchemaSnapshot, error) {
+	if h.isDefaultSchema(schema) {
+		return h.refreshSchema()
+	}
+	tables, err := h.loadFullSchema(schema)
+	if err != nil {
+		return nil, err
+	}
+	snap := &SchemaSnapshot{Schema: tables, FetchedAt: time.Now()}
+	h.snapshot.mu.Lock()
+	if h.snapshot.others == nil {
+		h.snapshot.others = map[string]*SchemaSnapshot{}
+	}
+	h.snapshot.others[schema] = snap
+	h.snapshot.mu.Unlock()
+	return snap, nil
+}
+
+// refreshSchema introspects the default schema and persists the result
+func (h *Handler) refreshSchema() (*SchemaSnapshot, error) {
+	schema, err := h.loadFullSchema("")
+	if err != nil {
+		return nil, err
+	}
+
+	snap := &SchemaSnapshot{Schema: schema, FetchedAt: time.Now()}
+	if err := h.meta.Put(schemaSnapshotKey, snap); err != nil {
+		slog.Error("Failed to persist schema snapshot", "error", err)
+	}
+
+	h.snapshot.mu.Lock()
+	h.snapshot.current = snap
+	h.snapshot.stale = false
+	h.snapshot.digest = schemaDigest(schema)
+	h.snapshot.mu.Unlock()
+
+	return snap, nil
+}
+
+// schemaDigest hashes the structure of tables: columns, keys, constraints
+// and comments, leaving out freshness, which changes with the data
+func schemaDigest(tables []TableSchema) string {
+	structure := make([]TableSchema, len(tables))
+	for i, t := range tables {
+		t.Freshness = nil
+		structure[i] = t
+	}
+	b, err := json.Marshal(structure)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:8])
+}
+
+// SchemaDigest sends X-Schema-Digest on every response when
+// server.schema_digest is set. It changes whenever a refresh finds the
+// default schema's tables changed, telling clients to refetch /schema.
+func (h *Handler) SchemaDigest() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if h.cfg.Server.SchemaDigest {
+			h.snapshot.mu.RLock()
+			digest := h.snapshot.digest
+			h.snapshot.mu.RUnlock()
+			if digest != "" {
+				c.Header("X-Schema-Digest", digest)
+			}
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,960 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"sql-engine/database"
+
+	sqlparser "github.com/blastrain/vitess-sqlparser/sqlparser"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+type sqliteDialect struct{}
+
+// viewDefinition captures the SELECT of a CREATE VIEW statement
+var viewDefinition = regexp.MustCompile(`(?is)^\s*CREATE\s+(?:TEMP\s+|TEMPORARY\s+)?VIEW\s+.*?\bAS\s+(.*)$`)
+
+func init() {
+	RegisterDialect(database.DriverSQLite, sqliteDialect{})
+}
+
+func (sqliteDialect) ListDatabases(db *sql.DB) ([]string, error) {
+	rows, err := db.Query(`SELECT name FROM pragma_database_list ORDER BY seq`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var databases []string
+	for rows.Next() {
+		var dbName string
+		if err := rows.Scan(&dbName); err != nil {
+			return nil, err
+		}
+		databases = append(databases, dbName)
+	}
+	return databases, rows.Err()
+}
+
+func (sqliteDialect) DefaultSchema() string {
+	return "main"
+}
+
+// ListSchemas lists the main database and any attached ones
+func (sqliteDialect) ListSchemas(db *sql.DB) ([]string, error) {
+	rows, err := db.Query(`SELECT name FROM pragma_database_list WHERE name <> 'temp' ORDER BY seq`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var schemas []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		schemas = append(schemas, name)
+	}
+	return schemas, rows.Err()
+}
+
+func (d sqliteDialect) ListTables(db *sql.DB, schema string) ([]TableInfo, error) {
+	schema = orDefault(d, schema)
+	// Report types the same way information_schema does
+	rows, err := db.Query(fmt.Sprintf(`
+		SELECT name, CASE type WHEN 'view' THEN 'VIEW' ELSE 'BASE TABLE' END
+		FROM %s.sqlite_master
+		WHERE type IN ('table', 'view') AND name NOT LIKE 'sqlite_%%'
+		ORDER BY name
+	`, d.Quote(schema)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tables []TableInfo
+	for rows.Next() {
+		var table TableInfo
+		if err := rows.Scan(&table.Name, &table.Type); err != nil {
+			return nil, err
+		}
+		table.Schema = schema
+		tables = append(tables, table)
+	}
+	return tables, rows.Err()
+}
+
+// sqliteCheck finds CHECK constraints, with their optional names, in a column
+// definition or table constraint
+var sqliteCheck = regexp.MustCompile("(?i)(?:\\bCONSTRAINT\\s+(\"[^\"]*\"|`[^`]*`|\\[[^\\]]*\\]|\\w+)\\s+)?\\bCHECK\\s*\\(")
+
+// ListConstraints reads UNIQUE constraints from their automatic indexes and
+// parses CHECK constraints out of the CREATE TABLE statement. SQLite has no
+// exclusion constraints.
+func (d sqliteDialect) ListConstraints(db *sql.DB, schema, tableName string) ([]ConstraintInfo, error) {
+	schema = orDefault(d, schema)
+	var createSQL string
+	err := db.QueryRow(fmt.Sprintf(`SELECT sql FROM %s.sqlite_master WHERE type = 'table' AND name = ?1`, d.Quote(schema)), tableName).Scan(&createSQL)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	columns, err := d.ListColumns(db, schema, tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	var constraints []ConstraintInfo
+	for i, elem := range tableElements(createSQL) {
+		fields := strings.Fields(elem)
+		if len(fields) == 0 {
+			continue
+		}
+		// Column definitions start with the column name
+		column := ""
+		switch strings.ToUpper(fields[0]) {
+		case "CONSTRAINT", "CHECK", "UNIQUE", "PRIMARY", "FOREIGN":
+		default:
+			column = strings.Trim(fields[0], "\"`[]")
+		}
+		for j, m := range sqliteCheck.FindAllStringSubmatchIndex(elem, -1) {
+			expr, ok := balancedParens(elem[m[1]-1:])
+			if !ok {
+				continue
+			}
+			con := ConstraintInfo{Type: "CHECK", Expression: strings.TrimSpace(expr)}
+			con.Definition = "CHECK (" + con.Expression + ")"
+			if m[2] >= 0 {
+				con.Name = strings.Trim(elem[m[2]:m[3]], "\"`[]")
+				con.Definition = "CONSTRAINT " + elem[m[2]:m[3]] + " " + con.Definition
+			} else {
+				con.Name = fmt.Sprintf("%s_check_%d_%d", tableName, i+1, j+1)
+			}
+			if column != "" {
+				con.Columns = []string{column}
+			} else {
+				con.Columns = referencedColumns(con.Expression, columns)
+			}
+			constraints = append(constraints, con)
+		}
+	}
+
+	rows, err := db.Query(`
+		SELECT il.name, ii.name
+		FROM pragma_index_list(?1, ?2) il
+		JOIN pragma_index_info(il.name, ?2) ii
+		WHERE il.origin = 'u'
+		ORDER BY il.name, ii.seqno
+	`, tableName, schema)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var index, column string
+		if err := rows.Scan(&index, &column); err != nil {
+			return nil, err
+		}
+		if n := len(constraints); n == 0 || constraints[n-1].Name != index {
+			constraints = append(constraints, ConstraintInfo{Name: index, Type: "UNIQUE"})
+		}
+		last := &constraints[len(constraints)-1]
+		last.Columns = append(last.Columns, column)
+	}
+	for i := range constraints {
+		if constraints[i].Type == "UNIQUE" {
+			constraints[i].Definition = "UNIQUE (" + strings.Join(constraints[i].Columns, ", ") + ")"
+		}
+	}
+	return constraints, rows.Err()
+}
+
+// tableElements splits the body of a CREATE TABLE statement into its column
+// definitions and table constraints
+func tableElements(createSQL string) []string {
+	var elems []string
+	depth, start := 0, -1
+	var quote byte
+	for i := 0; i < len(createSQL); i++ {
+		ch := createSQL[i]
+		switch {
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			}
+		case ch == '\'' || ch == '"' || ch == '`':
+			quote = ch
+		case ch == '[':
+			quote = ']'
+		case ch == '(':
+			depth++
+			if depth == 1 {
+				start = i + 1
+			}
+		case ch == ')':
+			depth--
+			if depth == 0 && start >= 0 {
+				return append(elems, createSQL[start:i])
+			}
+		case ch == ',' && depth == 1:
+			elems = append(elems, createSQL[start:i])
+			start = i + 1
+		}
+	}
+	return elems
+}
+
+// balancedParens returns what is inside the parenthesis s starts with
+func balancedParens(s string) (string, bool) {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			}
+		case ch == '\'' || ch == '"' || ch == '`':
+			quote = ch
+		case ch == '(':
+			depth++
+		case ch == ')':
+			depth--
+			if depth == 0 {
+				return s[1:i], true
+			}
+		}
+	}
+	return "", false
+}
+
+// referencedColumns returns the columns whose names appear in expr
+func referencedColumns(expr string, columns []ColumnInfo) []string {
+	names := []string{}
+	for _, col := range columns {
+		pattern := `(?i)(^|[^\w])` + regexp.QuoteMeta(col.Name) + `($|[^\w])`
+		if regexp.MustCompile(pattern).MatchString(expr) {
+			names = append(names, col.Name)
+		}
+	}
+	return names
+}
+
+// TableComment returns nothing: SQLite has no comments
+func (sqliteDialect) TableComment(db *sql.DB, schema, tableName string) (string, error) {
+	return "", nil
+}
+
+func (sqliteDialect) PeekChanges(ctx context.Context, db *sql.DB, slot string, tables []string, after string, limit int) ([]ChangeEvent, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func (sqliteDialect) AdvanceSlot(ctx context.Context, db *sql.DB, slot string, tables []string, position string) error {
+	return errors.ErrUnsupported
+}
+
+func (sqliteDialect) ReplicationLag(ctx context.Context, db *sql.DB) (bool, time.Duration, error) {
+	return false, 0, errors.ErrUnsupported
+}
+
+// ServerInfo returns no clock, the database being a file read by this
+// process
+func (sqliteDialect) ServerInfo(ctx context.Context, db *sql.DB) (string, time.Time, error) {
+	var version string
+	err := db.QueryRowContext(ctx, "SELECT sqlite_version()").Scan(&version)
+	return "SQLite " + version, time.Time{}, err
+}
+
+// UnreadableTables returns none: access to a SQLite file is all or nothing
+func (sqliteDialect) UnreadableTables(ctx context.Context, db *sql.DB, schema string) ([]string, error) {
+	return nil, nil
+}
+
+func (sqliteDialect) StatementStats(ctx context.Context, db *sql.DB) error {
+	return errors.ErrUnsupported
+}
+
+func (sqliteDialect) Listen(ctx context.Context, db *sql.DB, channel string, ready func(), notify func(payload string)) error {
+	return errors.ErrUnsupported
+}
+
+func (sqliteDialect) SetComment(ctx context.Context, db *sql.DB, schema, tableName, column, comment string) error {
+	return errors.ErrUnsupported
+}
+
+func (d sqliteDialect) ListColumns(db *sql.DB, schema, tableName string) ([]ColumnInfo, error) {
+	rows, err := db.Query(`
+		SELECT name, type, "notnull", dflt_value
+		FROM pragma_table_info(?1, ?2)
+		ORDER BY cid
+	`, tableName, orDefault(d, schema))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []ColumnInfo
+	for rows.Next() {
+		var col ColumnInfo
+		var notNull bool
+		var def sql.NullString
+
+		if err := rows.Scan(&col.Name, &col.DataType, &notNull, &def); err != nil {
+			return nil, err
+		}
+
+		col.IsNullable = "YES"
+		if notNull {
+			col.IsNullable = "NO"
+		}
+		if def.Valid {
+			col.Default = &def.String
+		}
+
+		columns = append(columns, col)
+	}
+	return columns, rows.Err()
+}
+
+func (sqliteDialect) ListHypertables(db *sql.DB, schema string) ([]HypertableInfo, error) {
+	return nil, errors.ErrUnsupported
+}
+
+// ListPartitions returns nothing: SQLite has no partitioned tables
+func (sqliteDialect) ListPartitions(db *sql.DB, schema, tableName string) (*PartitionInfo, error) {
+	return nil, nil
+}
+
+// ListTypes returns nothing: SQLite has no user-defined types
+func (sqliteDialect) ListTypes(db *sql.DB, schema string) ([]TypeInfo, error) {
+	return nil, nil
+}
+
+func (d sqliteDialect) ListPrimaryKeys(db *sql.DB, schema, tableName string) ([]string, error) {
+	rows, err := db.Query(`
+		SELECT name
+		FROM pragma_table_info(?1, ?2)
+		WHERE pk > 0
+		ORDER BY pk
+	`, tableName, orDefault(d, schema))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var primaryKeys []string
+	for rows.Next() {
+		var colName string
+		if err := rows.Scan(&colName); err != nil {
+			return nil, err
+		}
+		primaryKeys = append(primaryKeys, colName)
+	}
+	return primaryKeys, rows.Err()
+}
+
+func (d sqliteDialect) ListForeignKeys(db *sql.DB, schema, tableName string) ([]ForeignKeyInfo, error) {
+	// Foreign keys can only reference tables of the same database
+	schema = orDefault(d, schema)
+	rows, err := db.Query(`
+		SELECT "from", "table", "to"
+		FROM pragma_foreign_key_list(?1, ?2)
+		ORDER BY "from"
+	`, tableName, schema)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var foreignKeys []ForeignKeyInfo
+	for rows.Next() {
+		var fk ForeignKeyInfo
+		var to sql.NullString
+		if err := rows.Scan(&fk.Column, &fk.ForeignTable, &to); err != nil {
+			return nil, err
+		}
+		fk.ForeignColumn = to.String
+		fk.ForeignSchema = schema
+		foreignKeys = append(foreignKeys, fk)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	// A NULL target column means the foreign table's primary key
+	for i, fk := range foreignKeys {
+		if fk.ForeignColumn != "" {
+			continue
+		}
+		pks, err := d.ListPrimaryKeys(db, schema, fk.ForeignTable)
+		if err != nil {
+			return nil, err
+		}
+		if len(pks) > 0 {
+			foreignKeys[i].ForeignColumn = pks[0]
+		}
+	}
+	return foreignKeys, nil
+}
+
+// ListIndexes reads the index pragmas. SQLite keeps no usage statistics, and
+// index sizes are only known when the dbstat table is compiled in.
+func (d sqliteDialect) ListIndexes(db *sql.DB, schema, tableName string) ([]IndexInfo, error) {
+	schema = orDefault(d, schema)
+	rows, err := db.Query(fmt.Sprintf(`
+		SELECT il.name, il."unique", il.origin = 'pk', COALESCE(m.sql, '')
+		FROM pragma_index_list(?1, ?2) il
+		LEFT JOIN %s.sqlite_master m ON m.type = 'index' AND m.name = il.name
+		ORDER BY il.name
+	`, d.Quote(schema)), tableName, schema)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var indexes []IndexInfo
+	for rows.Next() {
+		idx := IndexInfo{Method: "btree"}
+		if err := rows.Scan(&idx.Name, &idx.Unique, &idx.Primary, &idx.Definition); err != nil {
+			return nil, err
+		}
+		indexes = append(indexes, idx)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	for i, idx := range indexes {
+		// cid -2 marks an expression, -1 the rowid
+		cols, err := db.Query(`
+			SELECT CASE cid WHEN -2 THEN '<expression>' WHEN -1 THEN 'rowid' ELSE name END
+			FROM pragma_index_xinfo(?1, ?2)
+			WHERE key = 1
+			ORDER BY seqno
+		`, idx.Name, schema)
+		if err != nil {
+			return nil, err
+		}
+		for cols.Next() {
+			var col string
+			if err := cols.Scan(&col); err != nil {
+				cols.Close()
+				return nil, err
+			}
+			indexes[i].Columns = append(indexes[i].Columns, col)
+		}
+		cols.Close()
+		if err := cols.Err(); err != nil {
+			return nil, err
+		}
+
+		var size sql.NullInt64
+		if err := db.QueryRow(`SELECT SUM(pgsize) FROM dbstat(?1) WHERE name = ?2`, schema, idx.Name).Scan(&size); err == nil {
+			indexes[i].SizeBytes = nullableInt64(size)
+		}
+	}
+	return indexes, nil
+}
+
+// sqliteTrigger parses the head of a CREATE TRIGGER statement
+var sqliteTrigger = regexp.MustCompile(`(?is)\bTRIGGER\s+(?:IF\s+NOT\s+EXISTS\s+)?.+?\s+(BEFORE|AFTER|INSTEAD\s+OF)?\s*(DELETE|INSERT|UPDATE)\b.*?\s+ON\s+\S+(?:\s+FOR\s+EACH\s+ROW)?(?:\s+WHEN\s+(.+?))?\s+BEGIN\b`)
+
+// ListTriggers parses the stored CREATE TRIGGER statements. SQLite triggers
+// always fire per row, BEFORE when no timing is given.
+func (d sqliteDialect) ListTriggers(db *sql.DB, schema, tableName string) ([]TriggerInfo, error) {
+	rows, err := db.Query(fmt.Sprintf(`
+		SELECT name, sql
+		FROM %s.sqlite_master
+		WHERE type = 'trigger' AND tbl_name = ?1
+		ORDER BY name
+	`, d.Quote(orDefault(d, schema))), tableName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var triggers []TriggerInfo
+	for rows.Next() {
+		t := TriggerInfo{Timing: "BEFORE", Level: "ROW", Enabled: true}
+		if err := rows.Scan(&t.Name, &t.Definition); err != nil {
+			return nil, err
+		}
+		if m := sqliteTrigger.FindStringSubmatch(t.Definition); m != nil {
+			if m[1] != "" {
+				t.Timing = strings.ToUpper(strings.Join(strings.Fields(m[1]), " "))
+			}
+			t.Events = []string{strings.ToUpper(m[2])}
+			t.Condition = strings.TrimSpace(m[3])
+		}
+		triggers = append(triggers, t)
+	}
+	return triggers, rows.Err()
+}
+
+// WriteCounter has no per-table statistics to read in SQLite, so it combines
+// the row count with the highest rowid; updates in place go unnoticed
+func (d sqliteDialect) WriteCounter(db *sql.DB, schema, tableName string) (int64, error) {
+	var count, maxRowID int64
+	err := db.QueryRow(fmt.Sprintf("SELECT COUNT(*), COALESCE(MAX(rowid), 0) FROM %s.%s",
+		d.Quote(orDefault(d, schema)), d.Quote(tableName))).Scan(&count, &maxRowID)
+	return count<<32 ^ maxRowID, err
+}
+
+// ListTableStats estimates row counts from sqlite_stat1 when ANALYZE has run
+// and counts rows otherwise. Sizes need the dbstat table; SQLite has no
+// vacuum or analyze history.
+func (d sqliteDialect) ListTableStats(db *sql.DB, schema, tableName string) ([]TableStats, error) {
+	schema = orDefault(d, schema)
+	tables, err := d.ListTables(db, schema)
+	if err != nil {
+		return nil, err
+	}
+
+	var stats []TableStats
+	for _, t := range tables {
+		if t.Type != "BASE TABLE" || (tableName != "" && t.Name != tableName) {
+			continue
+		}
+		st := TableStats{Schema: schema, Name: t.Name}
+
+		var stat string
+		var estimate int64
+		err := db.QueryRow(fmt.Sprintf(`SELECT stat FROM %s.sqlite_stat1 WHERE tbl = ?1 LIMIT 1`, d.Quote(schema)), t.Name).Scan(&stat)
+		if err == nil {
+			// The first number of stat is the table's row count
+			_, err = fmt.Sscan(stat, &estimate)
+		}
+		if err != nil {
+			err = db.QueryRow(fmt.Sprintf(`SELECT COUNT(*) FROM %s.%s`, d.Quote(schema), d.Quote(t.Name))).Scan(&estimate)
+			if err != nil {
+				return nil, err
+			}
+		}
+		st.RowEstimate = &estimate
+
+		var table, index sql.NullInt64
+		err = db.QueryRow(fmt.Sprintf(`
+			SELECT
+				(SELECT SUM(pgsize) FROM dbstat(?1) WHERE name = ?2),
+				(SELECT COALESCE(SUM(pgsize), 0) FROM dbstat(?1) WHERE name IN (
+					SELECT name FROM %s.sqlite_master WHERE type = 'index' AND tbl_name = ?2
+				))
+		`, d.Quote(schema)), schema, t.Name).Scan(&table, &index)
+		if err == nil && table.Valid {
+			total := table.Int64 + index.Int64
+			st.TableBytes = &table.Int64
+			st.IndexBytes = &index.Int64
+			st.TotalBytes = &total
+		}
+		stats = append(stats, st)
+	}
+	return stats, nil
+}
+
+// ListColumnStats derives distinct counts from sqlite_stat1, which ANALYZE
+// fills for indexed columns: its stat holds the row count, then the average
+// rows per value of the index's leading column. Null fractions are unknown.
+func (d sqliteDialect) ListColumnStats(db *sql.DB, schema string) ([]ColumnStats, error) {
+	schema = orDefault(d, schema)
+	rows, err := db.Query(fmt.Sprintf(`
+		SELECT s.tbl, i.name, s.stat
+		FROM %[1]s.sqlite_stat1 s
+		JOIN pragma_index_info(s.idx, ?1) i ON i.seqno = 0
+	`, d.Quote(schema)), schema)
+	if err != nil {
+		// sqlite_stat1 only exists once ANALYZE has run
+		if strings.Contains(err.Error(), "no such table") {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []ColumnStats
+	for rows.Next() {
+		var st ColumnStats
+		var stat string
+		if err := rows.Scan(&st.Table, &st.Column, &stat); err != nil {
+			return nil, err
+		}
+		var total, perValue float64
+		if n, _ := fmt.Sscan(stat, &total, &perValue); n == 2 && perValue > 0 {
+			st.Distinct = total / perValue
+			stats = append(stats, st)
+		}
+	}
+	return stats, rows.Err()
+}
+
+// ListViews lists the views of a schema. SQLite keeps the CREATE VIEW
+// statement only, so dependencies are taken from the parsed definition.
+func (d sqliteDialect) ListViews(db *sql.DB, schema string) ([]ViewInfo, error) {
+	schema = orDefault(d, schema)
+	rows, err := db.Query(fmt.Sprintf(`
+		SELECT name, sql
+		FROM %s.sqlite_master
+		WHERE type = 'view'
+		ORDER BY name
+	`, d.Quote(schema)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var views []ViewInfo
+	for rows.Next() {
+		var v ViewInfo
+		var create string
+		if err := rows.Scan(&v.Name, &create); err != nil {
+			return nil, err
+		}
+		v.Schema = schema
+		v.Definition = create
+		if m := viewDefinition.FindStringSubmatch(create); m != nil {
+			v.Definition = strings.TrimSpace(m[1])
+		}
+		v.Dependencies = []ViewDependency{}
+		if stmt, err := d.ParseStatement(v.Definition); err == nil {
+			for _, t := range analyzeStatement(stmt).Tables {
+				dep := ViewDependency{Schema: orDefault(d, t.Schema), Name: t.Name}
+				if !slices.Contains(v.Dependencies, dep) {
+					v.Dependencies = append(v.Dependencies, dep)
+				}
+			}
+		}
+		views = append(views, v)
+	}
+	return views, rows.Err()
+}
+
+// ListSequences reports the AUTOINCREMENT counters of a schema, SQLite's only
+// sequences. Each is named after its table and has no cache.
+func (d sqliteDialect) ListSequences(db *sql.DB, schema string) ([]SequenceInfo, error) {
+	schema = orDefault(d, schema)
+	rows, err := db.Query(fmt.Sprintf(`
+		SELECT m.name, p.name
+		FROM %s.sqlite_master m
+		JOIN pragma_table_info(m.name, ?1) p ON p.pk = 1
+		WHERE m.type = 'table' AND m.sql LIKE '%%AUTOINCREMENT%%'
+		ORDER BY m.name
+	`, d.Quote(schema)), schema)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sequences []SequenceInfo
+	for rows.Next() {
+		s := SequenceInfo{
+			Schema:     schema,
+			DataType:   "integer",
+			StartValue: 1,
+			Increment:  1,
+			MinValue:   1,
+			MaxValue:   math.MaxInt64,
+		}
+		var column string
+		if err := rows.Scan(&s.Name, &column); err != nil {
+			return nil, err
+		}
+		s.OwnedBy = &SequenceOwner{Table: s.Name, Column: column}
+		sequences = append(sequences, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// sqlite_sequence only exists once an AUTOINCREMENT table was created,
+	// and only has rows for tables that were inserted into
+	for i := range sequences {
+		var last int64
+		err := db.QueryRow(fmt.Sprintf(`SELECT seq FROM %s.sqlite_sequence WHERE name = ?1`, d.Quote(schema)), sequences[i].Name).Scan(&last)
+		if err == nil {
+			sequences[i].LastValue = &last
+		}
+	}
+	return sequences, nil
+}
+
+// ResetSequence sets the sqlite_sequence row of an AUTOINCREMENT table,
+// which otherwise never goes down when rows are deleted
+func (d sqliteDialect) ResetSequence(ctx context.Context, tx *sql.Tx, seq SequenceInfo) error {
+	schema := d.Quote(seq.Schema)
+	_, err := tx.ExecContext(ctx, fmt.Sprintf(
+		`UPDATE %s.sqlite_sequence SET seq = (SELECT COALESCE(MAX(%s), 0) FROM %s.%s) WHERE name = ?1`,
+		schema, d.Quote(seq.OwnedBy.Column), schema, d.Quote(seq.OwnedBy.Table),
+	), seq.OwnedBy.Table)
+	return err
+}
+
+// ListFunctions returns nothing: SQLite functions are registered by the
+// application at runtime and not stored in the database
+func (sqliteDialect) ListFunctions(db *sql.DB, schema string) ([]FunctionInfo, error) {
+	return nil, nil
+}
+
+func (sqliteDialect) RefreshMaterializedView(ctx context.Context, db *sql.DB, schema, view string, concurrently bool) error {
+	return errors.New("sqlite has no materialized views")
+}
+
+func (sqliteDialect) ListContinuousAggregates(db *sql.DB, schema string) ([]ContinuousAggregateInfo, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func (sqliteDialect) RefreshContinuousAggregate(ctx context.Context, db *sql.DB, schema, view string, start, end any) error {
+	return errors.ErrUnsupported
+}
+
+// Retryable matches SQLITE_BUSY and SQLITE_LOCKED, raised when another
+// connection holds a conflicting lock past the busy timeout
+func (sqliteDialect) Retryable(err error) bool {
+	var liteErr *sqlite.Error
+	if !errors.As(err, &liteErr) {
+		return false
+	}
+	// Extended result codes keep the primary code in the low byte
+	code := liteErr.Code() & 0xff
+	return code == sqlite3.SQLITE_BUSY || code == sqlite3.SQLITE_LOCKED
+}
+
+// ConnectionLost only matches connections database/sql should discard: a
+// database file has no connection to lose
+func (sqliteDialect) ConnectionLost(err error) bool {
+	return errors.Is(err, driver.ErrBadConn)
+}
+
+// RowVersion is unsupported: SQLite keeps no version of a row
+func (sqliteDialect) RowVersion() string {
+	return ""
+}
+
+// BeginReadOnly switches a dedicated connection to query_only for the length
+// of the transaction; the driver ignores read-only transaction options
+func (sqliteDialect) BeginReadOnly(ctx context.Context, db *sql.DB) (*sql.Tx, func(), error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := conn.ExecContext(ctx, "PRAGMA query_only = ON"); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		conn.ExecContext(context.Background(), "PRAGMA query_only = OFF")
+		conn.Close()
+		return nil, nil, err
+	}
+	end := func() {
+		tx.Rollback()
+		if _, err := conn.ExecContext(context.Background(), "PRAGMA query_only = OFF"); err != nil {
+			// Don't hand a read-only connection back to the pool
+			conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+		}
+		conn.Close()
+	}
+	return tx, end, nil
+}
+
+// BinarySlice supports columns with BLOB affinity, i.e. declared BLOB or
+// without a type
+func (sqliteDialect) BinarySlice(col, dataType, start, count string) (string, string, bool) {
+	if dataType != "" && !strings.Contains(strings.ToUpper(dataType), "BLOB") {
+		return "", "", false
+	}
+	return "length(CAST(" + col + " AS BLOB))", fmt.Sprintf("substr(CAST(%s AS BLOB), %s, %s)", col, start, count), true
+}
+
+// JSONPath supports JSON stored as text, in columns declared JSON, with
+// text affinity or without a type; values that aren't valid JSON read as
+// null. Digit-only path elements index arrays.
+func (sqliteDialect) JSONPath(col, dataType string, path []string, numeric bool) (string, bool) {
+	t := strings.ToUpper(dataType)
+	if t != "" && !strings.Contains(t, "JSON") && !strings.Contains(t, "TEXT") && !strings.Contains(t, "CHAR") && !strings.Contains(t, "CLOB") {
+		return "", false
+	}
+	if len(path) == 0 {
+		return fmt.Sprintf("CASE WHEN json_valid(%s) THEN json(%s) END", col, col), true
+	}
+	p := "$"
+	for _, key := range path {
+		if key != "" && strings.Trim(key, "0123456789") == "" {
+			p += "[" + key + "]"
+		} else {
+			p += `."` + strings.ReplaceAll(key, `"`, `\"`) + `"`
+		}
+	}
+	lit := sqlLiteral(p)
+	if numeric {
+		return fmt.Sprintf("CASE WHEN json_valid(%s) AND json_type(%s, %s) IN ('integer', 'real') THEN json_extract(%s, %s) END", col, col, lit, col, lit), true
+	}
+	// Booleans read as true and false, like PostgreSQL's ->>, not 1 and 0
+	return fmt.Sprintf("CASE WHEN json_valid(%s) THEN CASE json_type(%s, %s) WHEN 'true' THEN 'true' WHEN 'false' THEN 'false' ELSE CAST(json_extract(%s, %s) AS TEXT) END END",
+		col, col, lit, col, lit), true
+}
+
+// TextSearchDocument isn't supported: FTS5 needs a virtual table
+func (sqliteDialect) TextSearchDocument(cols []string, config string) (string, bool) {
+	return "", false
+}
+
+// SetLocal ignores the settings, which SQLite doesn't have
+func (sqliteDialect) SetLocal(ctx context.Context, tx *sql.Tx, settings map[string]string) error {
+	return nil
+}
+
+// BeginSession starts a deferred transaction, which reads one snapshot from
+// its first statement on: without WAL, its lock keeps writers out until it
+// ends
+func (d sqliteDialect) BeginSession(ctx context.Context, db *sql.DB, write bool) (*sql.Tx, func(), error) {
+	if !write {
+		return d.BeginReadOnly(ctx, db)
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tx, func() { tx.Rollback() }, nil
+}
+
+// CopyOut writes CSV itself, formatting values the way PostgreSQL's COPY
+// does; SQLite has no binary export format
+func (d sqliteDialect) CopyOut(ctx context.Context, db *sql.DB, w io.Writer, query, format string) error {
+	if format != "csv" {
+		return errors.ErrUnsupported
+	}
+	tx, end, err := d.BeginReadOnly(ctx, db)
+	if err != nil {
+		return err
+	}
+	defer end()
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	out := NewCSVWriter(w)
+	if err := out.Write(cols, nil); err != nil {
+		return err
+	}
+	vals := make([]interface{}, len(cols))
+	ptrs := make([]interface{}, len(cols))
+	for i := range vals {
+		ptrs[i] = &vals[i]
+	}
+	record := make([]string, len(cols))
+	nulls := make([]bool, len(cols))
+	for rows.Next() {
+		if err := rows.Scan(ptrs...); err != nil {
+			return err
+		}
+		for i, v := range vals {
+			nulls[i] = v == nil
+			switch v := v.(type) {
+			case nil:
+				record[i] = ""
+			case []byte:
+				record[i] = `\x` + hex.EncodeToString(v)
+			case time.Time:
+				record[i] = v.Format(time.RFC3339Nano)
+			default:
+				record[i] = fmt.Sprint(v)
+			}
+		}
+		if err := out.Write(record, nulls); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return out.Flush()
+}
+
+func (sqliteDialect) PreparedStatements() []string {
+	return nil
+}
+
+func (sqliteDialect) BuiltinFunctions() []string {
+	return []string{
+		"abs", "avg", "char", "coalesce", "count", "date", "datetime", "group_concat", "hex", "ifnull",
+		"instr", "json_extract", "json_group_array", "json_object", "julianday", "lag", "lead", "length",
+		"lower", "ltrim", "max", "min", "nullif", "printf", "random", "rank", "replace", "round",
+		"row_number", "rtrim", "strftime", "substr", "sum", "time", "total", "trim", "typeof", "unixepoch",
+		"upper",
+	}
+}
+
+func (sqliteDialect) Quote(ident string) string {
+	return `"` + strings.ReplaceAll(ident, `"`, `""`) + `"`
+}
+
+func (sqliteDialect) ProductName() string {
+	return "SQLite"
+}
+
+func (sqliteDialect) StrictTypes() bool {
+	return false
+}
+
+func (sqliteDialect) ExplainQuery(query string) string {
+	return "EXPLAIN QUERY PLAN " + query
+}
+
+func (sqliteDialect) Placeholder(n int) string {
+	return fmt.Sprintf("?%d", n)
+}
+
+func (sqliteDialect) LimitClause(n int) string {
+	return fmt.Sprintf("LIMIT %d", n)
+}
+
+// BucketEpoch floors in SQL as integer division truncates towards zero,
+// which would misplace timestamps before 1970
+func (sqliteDialect) BucketEpoch(expr string, seconds int64) string {
+	epoch := fmt.Sprintf("CAST(strftime('%%s', %s) AS INTEGER)", expr)
+	return fmt.Sprintf("(%s - ((%s %% %d) + %d) %% %d)", epoch, epoch, seconds, seconds, seconds)
+}
+
+// TruncateTime works on the ISO-8601 text SQLite stores timestamps as
+func (sqliteDialect) TruncateTime(expr, unit string) string {
+	switch unit {
+	case "hour":
+		return fmt.Sprintf("strftime('%%Y-%%m-%%d %%H:00:00', %s)", expr)
+	case "week":
+		// Move to the next Sunday (or stay on one), then back to Monday
+		return fmt.Sprintf("date(%s, 'weekday 0', '-6 days')", expr)
+	case "month":
+		return fmt.Sprintf("date(%s, 'start of month')", expr)
+	case "year":
+		return fmt.Sprintf("date(%s, 'start of year')", expr)
+	default:
+		return fmt.Sprintf("date(%s)", expr)
+	}
+}
+
+func (sqliteDialect) ParseStatement(sqlText string) (sqlparser.Statement, error) {
+	return sqlparser.Parse(sqlText)
+}
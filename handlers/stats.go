@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"sql-engine/rbac"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TableStats holds size and maintenance statistics of a table. Fields the
+// database doesn't track are nil.
+type TableStats struct {
+	Schema string `json:"schema"`
+	Name   string `json:"name"`
+	// RowEstimate is the planner's row estimate, not an exact count
+	RowEstimate     *int64     `json:"row_estimate"`
+	TotalBytes      *int64     `json:"total_bytes"`
+	TableBytes      *int64     `json:"table_bytes"`
+	IndexBytes      *int64     `json:"index_bytes"`
+	DeadTuples      *int64     `json:"dead_tuples"`
+	LastVacuum      *time.Time `json:"last_vacuum"`
+	LastAutovacuum  *time.Time `json:"last_autovacuum"`
+	LastAnalyze     *time.Time `json:"last_analyze"`
+	LastAutoanalyze *time.Time `json:"last_autoanalyze"`
+}
+
+// ColumnStats is what the planner knows of the values of a column
+type ColumnStats struct {
+	Table  string `json:"table"`
+	Column string `json:"column"`
+	// Distinct estimates the number of distinct non-null values
+	Distinct     float64 `json:"distinct"`
+	NullFraction float64 `json:"null_fraction"`
+}
+
+func (h *Handler) GetTableStats(c *gin.Context) {
+	schema, ok := h.schemaParam(c)
+	if !ok {
+		return
+	}
+	tableName := c.Param("name")
+	if !h.requireTable(c, schema, tableName) {
+		return
+	}
+
+	stats, err := h.dialect.ListTableStats(h.db, schema, tableName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(stats) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Table not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, stats[0])
+}
+
+// GetTablesStats returns the statistics of every table in a schema
+func (h *Handler) GetTablesStats(c *gin.Context) {
+	schema, ok := h.schemaParam(c)
+	if !ok {
+		return
+	}
+
+	stats, err := h.dialect.ListTableStats(h.db, schema, "")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	access := rbac.FromContext(c.Request.Context())
+	out := []TableStats{}
+	for _, s := range stats {
+		if access == nil || access.CanTable(h.grantSchema(schema), s.Name) {
+			out = append(out, s)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"schema": schema, "tables": out})
+}
+
+func nullableTime(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
@@ -0,0 +1,290 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// statusBucket is the resolution of the status history, and
+	// statusHistory how many buckets each component keeps
+	statusBucket  = time.Minute
+	statusHistory = 24 * 60
+	// statusProbeInterval is how often every replica probes its components
+	statusProbeInterval = 15 * time.Second
+	// statusRecent is the window the current status of a component is
+	// judged on
+	statusRecent = 5 * time.Minute
+)
+
+// statusSample aggregates the checks of a component in one bucket
+type statusSample struct {
+	start    time.Time
+	checks   int
+	failures int
+	total    time.Duration
+	max      time.Duration
+}
+
+// statusRing is the recent history of one component, in a ring of buckets
+type statusRing struct {
+	name string
+	// passive components are measured from the requests they serve rather
+	// than probed, so a single failure doesn't make them down
+	passive bool
+
+	mu      sync.Mutex
+	buckets [statusHistory]statusSample
+	last    time.Time
+	lastOK  bool
+}
+
+func (r *statusRing) record(ok bool, latency time.Duration) {
+	now := time.Now()
+	start := now.Truncate(statusBucket)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b := &r.buckets[start.Unix()/int64(statusBucket.Seconds())%statusHistory]
+	if !b.start.Equal(start) {
+		*b = statusSample{start: start}
+	}
+	b.checks++
+	if !ok {
+		b.failures++
+	}
+	b.total += latency
+	b.max = max(b.max, latency)
+	r.last, r.lastOK = now, ok
+}
+
+// since returns the buckets starting at or after t, oldest first
+func (r *statusRing) since(t time.Time) []statusSample {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var samples []statusSample
+	start := time.Now().Truncate(statusBucket)
+	for i := statusHistory - 1; i >= 0; i-- {
+		bucketStart := start.Add(-time.Duration(i) * statusBucket)
+		if bucketStart.Before(t.Truncate(statusBucket)) {
+			continue
+		}
+		b := r.buckets[bucketStart.Unix()/int64(statusBucket.Seconds())%statusHistory]
+		if b.start.Equal(bucketStart) {
+			samples = append(samples, b)
+		}
+	}
+	return samples
+}
+
+// statusBoard holds the rings of every component, in the order they are
+// reported
+type statusBoard struct {
+	mu     sync.Mutex
+	rings  []*statusRing
+	probes map[string]func(ctx context.Context) error
+}
+
+func (s *statusBoard) ring(name string, passive bool) *statusRing {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range s.rings {
+		if r.name == name {
+			return r
+		}
+	}
+	r := &statusRing{name: name, passive: passive}
+	s.rings = append(s.rings, r)
+	return r
+}
+
+// AddStatusProbe adds a component to /status, checked with probe every
+// statusProbeInterval. Probes must be registered before StartStatusProbes.
+func (h *Handler) AddStatusProbe(name string, probe func(ctx context.Context) error) {
+	h.status.ring(name, false)
+	h.status.mu.Lock()
+	defer h.status.mu.Unlock()
+	if h.status.probes == nil {
+		h.status.probes = map[string]func(ctx context.Context) error{}
+	}
+	h.status.probes[name] = probe
+}
+
+// StartStatusProbes probes the database, the scheduler and the components
+// added with AddStatusProbe now and then periodically. The metadata store
+// is checked by StartStoreMonitor. Like it, this runs on every replica.
+func (h *Handler) StartStatusProbes() {
+	h.AddStatusProbe("database", func(ctx context.Context) error {
+		err := h.db.PingContext(ctx)
+		h.observeDatabase(err)
+		return err
+	})
+	if h.lowDB != nil {
+		h.AddStatusProbe("database_low_priority", func(ctx context.Context) error {
+			return h.lowDB.PingContext(ctx)
+		})
+	}
+	h.AddStatusProbe("scheduler", func(ctx context.Context) error {
+		if !h.sched.Running() {
+			return errors.New("scheduler stopped")
+		}
+		// Some replica must hold the lease for scheduled jobs to run
+		if h.elector != nil && time.Now().After(h.elector.Current().ExpiresAt) {
+			return errors.New("no replica leads scheduled jobs")
+		}
+		return nil
+	})
+	probe := func() {
+		h.status.mu.Lock()
+		probes := make(map[string]func(ctx context.Context) error, len(h.status.probes))
+		for name, p := range h.status.probes {
+			probes[name] = p
+		}
+		h.status.mu.Unlock()
+		for name, p := range probes {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			start := time.Now()
+			err := p(ctx)
+			cancel()
+			h.status.ring(name, false).record(err == nil, time.Since(start))
+		}
+	}
+	probe()
+	go func() {
+		ticker := time.NewTicker(statusProbeInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			probe()
+		}
+	}()
+}
+
+// observeDatabase logs when the database stops answering the status probes
+// and when it answers again. Queries meanwhile fail, and connections broken
+// by the outage are replaced as the pool reconnects.
+func (h *Handler) observeDatabase(err error) {
+	switch {
+	case err != nil && h.dbDownSince.IsZero():
+		slog.Error("Database unreachable, queries fail until it reconnects", "error", err)
+		h.dbDownSince = time.Now()
+	case err == nil && !h.dbDownSince.IsZero():
+		slog.Info("Database reachable again", "down_for", time.Since(h.dbDownSince).Round(time.Second).String())
+		h.dbDownSince = time.Time{}
+	}
+}
+
+// RecordStatus records the outcome and latency of every request as the
+// availability of the API; server errors count as failures
+func (h *Handler) RecordStatus() gin.HandlerFunc {
+	api := h.status.ring("api", true)
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		api.record(c.Writer.Status() < http.StatusInternalServerError, time.Since(start))
+	}
+}
+
+// StatusSummary is the availability and latency of a component over a window
+type StatusSummary struct {
+	Checks int `json:"checks"`
+	// Availability is the percentage of successful checks, or nil without
+	// any
+	Availability *float64 `json:"availability"`
+	AvgMs        float64  `json:"avg_ms"`
+	MaxMs        float64  `json:"max_ms"`
+}
+
+// StatusPoint is one bucket of a component's history
+type StatusPoint struct {
+	Start time.Time `json:"start"`
+	StatusSummary
+}
+
+func summarize(samples []statusSample) StatusSummary {
+	var s StatusSummary
+	var failures int
+	var total, maxLatency time.Duration
+	for _, b := range samples {
+		s.Checks += b.checks
+		failures += b.failures
+		total += b.total
+		maxLatency = max(maxLatency, b.max)
+	}
+	if s.Checks > 0 {
+		availability := math.Round(float64(s.Checks-failures)/float64(s.Checks)*1e5) / 1e3
+		s.Availability = &availability
+		s.AvgMs = math.Round(float64(total.Microseconds())/float64(s.Checks)) / 1e3
+	}
+	s.MaxMs = float64(maxLatency.Microseconds()) / 1e3
+	return s
+}
+
+// GetStatus reports the current status of each component of this replica
+// with its availability and latency over the last hour and day, and its
+// history in one minute buckets over the last ?minutes (60 by default, up
+// to a day). It needs no credentials so that status pages can poll it, and
+// reveals no error messages. Components are up, degraded when checks
+// failed in the last five minutes, down when their last probe failed, or
+// unknown before their first check.
+func (h *Handler) GetStatus(c *gin.Context) {
+	minutes, ok := intQuery(c, "minutes", 60, statusHistory)
+	if !ok {
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+
+	now := time.Now()
+	h.status.mu.Lock()
+	rings := append([]*statusRing(nil), h.status.rings...)
+	h.status.mu.Unlock()
+
+	overall := "operational"
+	var components []gin.H
+	for _, r := range rings {
+		recent := summarize(r.since(now.Add(-statusRecent)))
+		r.mu.Lock()
+		last, lastOK := r.last, r.lastOK
+		r.mu.Unlock()
+
+		status := "up"
+		switch {
+		case last.IsZero() && !r.passive:
+			status = "unknown"
+		case !r.passive && !lastOK:
+			status = "down"
+			overall = "outage"
+		case recent.Availability != nil && *recent.Availability < 100:
+			status = "degraded"
+			if overall == "operational" {
+				overall = "degraded"
+			}
+		}
+
+		history := []StatusPoint{}
+		for _, b := range r.since(now.Add(-time.Duration(minutes) * time.Minute)) {
+			history = append(history, StatusPoint{Start: b.start, StatusSummary: summarize([]statusSample{b})})
+		}
+		component := gin.H{
+			"name":     r.name,
+			"status":   status,
+			"last_1h":  summarize(r.since(now.Add(-time.Hour))),
+			"last_24h": summarize(r.since(now.Add(-24 * time.Hour))),
+			"history":  history,
+		}
+		if !last.IsZero() {
+			component["last_checked"] = last
+		}
+		components = append(components, component)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status":     overall,
+		"replica":    h.cfg.Server.ReplicaID,
+		"components": components,
+	})
+}
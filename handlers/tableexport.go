@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"sql-engine/cache"
+	"sql-engine/rbac"
+
+	"github.com/gin-gonic/gin"
+)
+
+// exportFormats maps the formats of GET /table/:name/export to their
+// content types and file extensions
+var exportFormats = map[string][2]string{
+	"csv":    {"text/csv; charset=utf-8", "csv"},
+	"binary": {"application/octet-stream", "bin"},
+}
+
+// sqlLiteral quotes v as a string literal. COPY takes no bind parameters,
+// so exports inline their filter values; backslashes need no escaping with
+// standard_conforming_strings, the default since PostgreSQL 9.1.
+func sqlLiteral(v interface{}) string {
+	return "'" + strings.ReplaceAll(fmt.Sprint(v), "'", "''") + "'"
+}
+
+// exportWriter starts the response on the first write, so that errors
+// raised before any data is produced can still be reported as JSON
+type exportWriter struct {
+	c           *gin.Context
+	contentType string
+	filename    string
+	gzip        bool
+	gz          *gzip.Writer
+	started     bool
+}
+
+func (w *exportWriter) Write(p []byte) (int, error) {
+	if !w.started {
+		w.started = true
+		w.c.Header("Content-Type", w.contentType)
+		w.c.Header("Content-Disposition", `attachment; filename="`+w.filename+`"`)
+		w.c.Header("Vary", "Accept-Encoding")
+		if w.gzip {
+			w.c.Header("Content-Encoding", "gzip")
+			w.gz = gzip.NewWriter(w.c.Writer)
+		}
+		w.c.Status(http.StatusOK)
+	}
+	if w.gz != nil {
+		return w.gz.Write(p)
+	}
+	return w.c.Writer.Write(p)
+}
+
+// Close flushes the compressed stream
+func (w *exportWriter) Close() error {
+	if w.gz != nil {
+		return w.gz.Close()
+	}
+	return nil
+}
+
+// ExportTable streams a whole table, or the rows kept by the filters of GET
+// /table/:name/rows, as CSV with a header line or, on PostgreSQL, in the
+// binary COPY format (?format=binary). The response is gzip-compressed for
+// clients that accept it. Exports are not capped by max_rows but by
+// query.export_timeout, and leave out masked columns since values are not
+// seen on the way. With ?save=true the export is written to the artifact
+// store instead, to be downloaded from GET /exports/:id. With
+// query.watermark, CSV exports end with a watermark row.
+func (h *Handler) ExportTable(c *gin.Context) {
+	schema, ok := h.schemaParam(c)
+	if !ok {
+		return
+	}
+	tableName := c.Param("name")
+	if matchesAny(h.cfg.Query.Denylist.Tables, tableName) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Denied: table " + tableName + " is not allowed"})
+		return
+	}
+	if !h.requireTable(c, schema, tableName) || !h.requireRowLevel(c, schema, tableName) {
+		return
+	}
+	format := c.DefaultQuery("format", "csv")
+	kind, ok := exportFormats[format]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be csv or binary"})
+		return
+	}
+
+	tableColumns, err := h.dialect.ListColumns(h.db, schema, tableName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(tableColumns) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Table not found"})
+		return
+	}
+	sel, ok := h.parseRowSelection(c, schema, tableName, tableColumns, sqlLiteral)
+	if !ok {
+		return
+	}
+	access := rbac.FromContext(c.Request.Context())
+	var roles []string
+	if access != nil {
+		roles = access.Roles
+	}
+	masked := h.masks.Columns(h.grantSchema(schema), tableName, roles)
+	var columns []string
+	for _, col := range sel.Columns {
+		if _, ok := masked[strings.ToLower(col)]; !ok {
+			columns = append(columns, h.dialect.Quote(col))
+		} else if c.Query("columns") != "" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Masked column " + col + " cannot be exported"})
+			return
+		}
+	}
+	if len(columns) == 0 {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied: every granted column of " + tableName + " is masked"})
+		return
+	}
+	q := h.dialect.Quote
+	query := fmt.Sprintf("SELECT %s FROM %s.%s%s", strings.Join(columns, ", "), q(schema), q(tableName), sel.clauses())
+
+	ctx := c.Request.Context()
+	deadline := time.Time{}
+	if h.cfg.Query.ExportTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.cfg.Query.ExportTimeout)
+		defer cancel()
+		deadline = time.Now().Add(h.cfg.Query.ExportTimeout)
+	}
+	// The server's write timeout is meant for ordinary responses
+	http.NewResponseController(c.Writer).SetWriteDeadline(deadline)
+	release, err := h.acquireStatement(ctx)
+	if err != nil {
+		respondQueryError(c, err)
+		return
+	}
+	defer release()
+	if c.Query("save") == "true" {
+		h.saveExport(c, ctx, schema, tableName, format, query)
+		return
+	}
+
+	var wm *Watermark
+	if format == "csv" {
+		if wm, err = h.watermark(c, schema, tableName, ""); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record the export's watermark: " + err.Error()})
+			return
+		}
+	}
+
+	cache.SetClass(c, cache.Streamed)
+	w := &exportWriter{
+		c:           c,
+		contentType: kind[0],
+		filename:    tableName + "." + kind[1],
+		gzip:        strings.Contains(c.GetHeader("Accept-Encoding"), "gzip"),
+	}
+	start := time.Now()
+	err = h.dialect.CopyOut(ctx, h.queryDB(ctx), w, query, format)
+	meterStatement(ctx, time.Since(start), 0)
+	if err == nil && wm != nil {
+		_, err = w.Write(wm.footer())
+	}
+	if err == nil {
+		err = w.Close()
+	}
+	switch {
+	case err == nil:
+	case w.started:
+		// Too late for an error response; dropping the connection tells
+		// the client the export is incomplete
+		c.Error(err)
+		panic(http.ErrAbortHandler)
+	case errors.Is(err, errors.ErrUnsupported):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Format " + format + " is not supported by this database"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Export failed: " + err.Error()})
+	}
+}
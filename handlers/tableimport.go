@@ -0,0 +1,302 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"sql-engine/ingest"
+	"sql-engine/rbac"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxImportErrors caps the rejected rows listed in an import report; the
+// count is always complete
+const maxImportErrors = 1000
+
+// TableImportError reports a row of an uploaded file that was not imported.
+// Row counts the data rows from 1, leaving out a CSV header.
+type TableImportError struct {
+	Row   int    `json:"row"`
+	Error string `json:"error"`
+}
+
+// TableImportResult reports what POST /table/:name/import loaded
+type TableImportResult struct {
+	Inserted int                `json:"inserted"`
+	Rejected int                `json:"rejected"`
+	Errors   []TableImportError `json:"errors"`
+	Retries  int                `json:"retries,omitempty"`
+}
+
+// reject records a rejected row, listing the first maxImportErrors
+func (r *TableImportResult) reject(row int, err error) {
+	r.Rejected++
+	if len(r.Errors) < maxImportErrors {
+		r.Errors = append(r.Errors, TableImportError{Row: row, Error: err.Error()})
+	}
+}
+
+// ImportTableRows inserts the rows of a CSV or NDJSON file, uploaded as the
+// multipart "file" field, into an existing table in one transaction. The
+// header, or the keys of the JSON objects, name the columns. Rows that
+// can't be read, that hold values of the wrong type or that the database
+// rejects are skipped and reported; with ?strict=true any rejected row
+// rolls the import back.
+func (h *Handler) ImportTableRows(c *gin.Context) {
+	t, ok := h.writableTable(c)
+	if !ok {
+		return
+	}
+	if h.cfg.Imports.MaxBytes > 0 {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.cfg.Imports.MaxBytes+1<<20)
+	}
+	fh, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing file: " + err.Error()})
+		return
+	}
+	if h.cfg.Imports.MaxBytes > 0 && fh.Size > h.cfg.Imports.MaxBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": ingest.ErrTooLarge.Error()})
+		return
+	}
+	format, err := ingest.DetectFormat(c.Query("format"), fh.Filename, fh.Header.Get("Content-Type"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	f, err := fh.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	defer f.Close()
+	data, rejected, err := ingest.ParseRows(f, format)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + format + " file: " + err.Error()})
+		return
+	}
+	if len(data.Rows)+len(rejected) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No rows to import"})
+		return
+	}
+	if len(data.Rows)+len(rejected) > h.cfg.Writes.MaxImportRows {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("At most %d rows may be imported at once", h.cfg.Writes.MaxImportRows)})
+		return
+	}
+	columns, ok := h.importColumns(c, t, data.Columns)
+	if !ok {
+		return
+	}
+	strict := c.Query("strict") == "true"
+
+	result := TableImportResult{Errors: []TableImportError{}}
+	for _, r := range rejected {
+		result.reject(r.Row, r.Err)
+	}
+	var rows []importRow
+	for i, values := range data.Rows {
+		obj := make(map[string]interface{}, len(columns))
+		for j, col := range columns {
+			// Columns a JSON object leaves out get their default
+			if values[j] == ingest.Absent {
+				continue
+			}
+			obj[col.Name] = importValue(col, values[j])
+		}
+		cols, args, err := h.writeValues(c, t, obj)
+		if err != nil {
+			result.reject(data.Numbers[i], err)
+			continue
+		}
+		rows = append(rows, importRow{Number: data.Numbers[i], Cols: cols, Args: args})
+	}
+	if strict && result.Rejected > 0 {
+		slices.SortFunc(result.Errors, func(a, b TableImportError) int { return a.Row - b.Row })
+		c.JSON(http.StatusUnprocessableEntity, result)
+		return
+	}
+	if len(rows) == 0 {
+		c.JSON(http.StatusOK, result)
+		return
+	}
+
+	checked := result
+	retries, ok := h.writeTx(c, func(ctx context.Context, tx *sql.Tx) error {
+		result = checked
+		result.Errors = slices.Clone(checked.Errors)
+		for start := 0; start < len(rows); {
+			end := importBatchEnd(rows, start)
+			if err := h.insertImportBatch(ctx, tx, t, rows[start:end], &result); err != nil {
+				return err
+			}
+			start = end
+		}
+		if strict && result.Rejected > 0 {
+			return &QueryError{Status: http.StatusUnprocessableEntity, Message: fmt.Sprintf("%d rows were rejected; nothing was imported", result.Rejected), Details: gin.H{"rejected": result.Rejected, "errors": result.Errors}}
+		}
+		return nil
+	})
+	if !ok {
+		return
+	}
+	result.Retries = retries
+	slices.SortFunc(result.Errors, func(a, b TableImportError) int { return a.Row - b.Row })
+	c.JSON(http.StatusOK, result)
+}
+
+// importColumns maps the columns of a file to those of the table, by name
+// and else ignoring case, answering 400 for columns the table lacks and 403
+// for columns the caller may not write
+func (h *Handler) importColumns(c *gin.Context, t *writeTarget, header []string) ([]ColumnInfo, bool) {
+	columns := make([]ColumnInfo, len(header))
+	var unknown []string
+	for i, name := range header {
+		j := slices.IndexFunc(t.Columns, func(ci ColumnInfo) bool { return ci.Name == name })
+		if j < 0 {
+			j = slices.IndexFunc(t.Columns, func(ci ColumnInfo) bool { return strings.EqualFold(ci.Name, name) })
+		}
+		if j < 0 {
+			unknown = append(unknown, name)
+			continue
+		}
+		if slices.ContainsFunc(columns[:i], func(ci ColumnInfo) bool { return ci.Name == t.Columns[j].Name }) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Column " + t.Columns[j].Name + " appears twice in the file"})
+			return nil, false
+		}
+		columns[i] = t.Columns[j]
+	}
+	if len(unknown) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Columns not in table " + t.Table + ": " + strings.Join(unknown, ", ")})
+		return nil, false
+	}
+	access := rbac.FromContext(c.Request.Context())
+	for _, col := range columns {
+		if !access.CanWrite(h.grantSchema(t.Schema), t.Table, col.Name) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied: column " + col.Name + " is not granted for writing"})
+			return nil, false
+		}
+	}
+	return columns, true
+}
+
+// importValue converts a value read from a file to what columnValue expects
+// of JSON: CSV holds only text, and ingest reads JSON integers as int64 and
+// other numbers and nested documents as text
+func importValue(col ColumnInfo, v interface{}) interface{} {
+	t := strings.ToLower(col.DataType)
+	switch x := v.(type) {
+	case int64:
+		return json.Number(strconv.FormatInt(x, 10))
+	case string:
+		switch {
+		case strings.Contains(t, "json") && json.Valid([]byte(x)):
+			var doc interface{}
+			if decodeJSONNumbers([]byte(x), &doc) == nil {
+				return doc
+			}
+		case strings.Contains(t, "bool"):
+			if b, err := strconv.ParseBool(x); err == nil {
+				return b
+			}
+		}
+	}
+	return v
+}
+
+// importRow is a row of a file converted for the table
+type importRow struct {
+	// Number counts the data rows of the file from 1
+	Number int
+	Cols   []string
+	Args   []interface{}
+}
+
+// importBatchEnd returns where the batch of rows beginning at start ends:
+// rows writing the same columns, as many as one statement may bind. Rows
+// writing no column are inserted with DEFAULT VALUES, one at a time.
+func importBatchEnd(rows []importRow, start int) int {
+	cols := rows[start].Cols
+	if len(cols) == 0 {
+		return start + 1
+	}
+	size := max(1, min(500, 30000/len(cols)))
+	end := start + 1
+	for end < len(rows) && end-start < size && slices.Equal(rows[end].Cols, cols) {
+		end++
+	}
+	return end
+}
+
+// insertImportBatch inserts rows writing the same columns with one statement
+// in a savepoint. If the database rejects it, the rows are inserted one at a
+// time to find those it rejects, which are reported and skipped.
+func (h *Handler) insertImportBatch(ctx context.Context, tx *sql.Tx, t *writeTarget, rows []importRow, result *TableImportResult) error {
+	q := h.dialect.Quote
+	quoted := make([]string, len(rows[0].Cols))
+	for i, col := range rows[0].Cols {
+		quoted[i] = q(col)
+	}
+	insert := func(rows []importRow) (rejected, err error) {
+		var args []interface{}
+		values := make([]string, len(rows))
+		for i, row := range rows {
+			marks := make([]string, len(row.Args))
+			for j, v := range row.Args {
+				args = append(args, v)
+				marks[j] = h.dialect.Placeholder(len(args))
+			}
+			values[i] = "(" + strings.Join(marks, ", ") + ")"
+		}
+		stmt := fmt.Sprintf("INSERT INTO %s.%s (%s) VALUES %s", q(t.Schema), q(t.Table), strings.Join(quoted, ", "), strings.Join(values, ", "))
+		if len(quoted) == 0 {
+			stmt = fmt.Sprintf("INSERT INTO %s.%s DEFAULT VALUES", q(t.Schema), q(t.Table))
+		}
+		if _, err := tx.ExecContext(ctx, "SAVEPOINT table_import"); err != nil {
+			return nil, err
+		}
+		if _, rejected = tx.ExecContext(ctx, stmt, args...); rejected != nil {
+			// Timeouts and conflicts to retry end the transaction instead
+			if ctx.Err() != nil || h.dialect.Retryable(rejected) {
+				return nil, rejected
+			}
+			if _, err = tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT table_import"); err == nil {
+				_, err = tx.ExecContext(ctx, "RELEASE SAVEPOINT table_import")
+			}
+			return rejected, err
+		}
+		_, err = tx.ExecContext(ctx, "RELEASE SAVEPOINT table_import")
+		return nil, err
+	}
+
+	rejected, err := insert(rows)
+	if err != nil {
+		return err
+	}
+	if rejected == nil {
+		result.Inserted += len(rows)
+		return nil
+	}
+	if len(rows) == 1 {
+		result.reject(rows[0].Number, rejected)
+		return nil
+	}
+	for _, row := range rows {
+		rejected, err := insert([]importRow{row})
+		if err != nil {
+			return err
+		}
+		if rejected != nil {
+			result.reject(row.Number, rejected)
+		} else {
+			result.Inserted++
+		}
+	}
+	return nil
+}
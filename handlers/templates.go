@@ -0,0 +1,256 @@
+package handlers
+
+import (
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"sql-engine/rbac"
+
+	"github.com/gin-gonic/gin"
+)
+
+// QueryTemplate is a parameterized query shipped with the server. Its SQL
+// refers to placeholders as {{name}}.
+type QueryTemplate struct {
+	ID           string                `json:"id"`
+	Category     string                `json:"category"`
+	Name         string                `json:"name"`
+	Description  string                `json:"description"`
+	SQL          string                `json:"sql"`
+	Placeholders []TemplatePlaceholder `json:"placeholders"`
+}
+
+// TemplatePlaceholder is a value filled in when a template is rendered. Kind
+// is table, column, columns (comma-separated), number or period.
+type TemplatePlaceholder struct {
+	Name        string `json:"name"`
+	Kind        string `json:"kind"`
+	Description string `json:"description"`
+	// Table names the table placeholder whose columns a column may use
+	Table string `json:"table,omitempty"`
+	// Column names the column placeholder a period truncates
+	Column  string `json:"column,omitempty"`
+	Default string `json:"default,omitempty"`
+	// Options lists the accepted values; for tables they come from the
+	// connected schema
+	Options []string `json:"options,omitempty"`
+}
+
+// TemplateRenderRequest holds the placeholder values of a template
+type TemplateRenderRequest struct {
+	Values map[string]string `json:"values"`
+}
+
+var templatePlaceholder = regexp.MustCompile(`\{\{(\w+)\}\}`)
+
+var periods = []string{"day", "week", "month"}
+
+var templateLibrary = []QueryTemplate{
+	{
+		ID:          "top-n-per-group",
+		Category:    "ranking",
+		Name:        "Top N per group",
+		Description: "The rows with the highest values of a column within each group",
+		SQL: `SELECT * FROM {{table}} a
+WHERE (
+	SELECT COUNT(*) FROM {{table}} b
+	WHERE b.{{group_column}} = a.{{group_column}} AND b.{{order_column}} > a.{{order_column}}
+) < {{n}}
+ORDER BY a.{{group_column}}, a.{{order_column}} DESC`,
+		Placeholders: []TemplatePlaceholder{
+			{Name: "table", Kind: "table", Description: "Table to rank"},
+			{Name: "group_column", Kind: "column", Table: "table", Description: "Column defining the groups"},
+			{Name: "order_column", Kind: "column", Table: "table", Description: "Column ranked, highest first"},
+			{Name: "n", Kind: "number", Default: "3", Description: "Rows kept per group"},
+		},
+	},
+	{
+		ID:          "duplicates",
+		Category:    "data-quality",
+		Name:        "Duplicate rows",
+		Description: "Values of one or more columns that occur more than once",
+		SQL: `SELECT {{columns}}, COUNT(*) AS copies
+FROM {{table}}
+GROUP BY {{columns}}
+HAVING COUNT(*) > 1
+ORDER BY copies DESC`,
+		Placeholders: []TemplatePlaceholder{
+			{Name: "table", Kind: "table", Description: "Table to check"},
+			{Name: "columns", Kind: "columns", Table: "table", Description: "Columns that should be unique together"},
+		},
+	},
+	{
+		ID:          "fk-orphans",
+		Category:    "data-quality",
+		Name:        "Orphaned references",
+		Description: "Rows referencing a parent row that does not exist, e.g. where a foreign key is missing or was not enforced",
+		SQL: `SELECT c.*
+FROM {{child_table}} c
+LEFT JOIN {{parent_table}} p ON p.{{parent_column}} = c.{{child_column}}
+WHERE c.{{child_column}} IS NOT NULL AND p.{{parent_column}} IS NULL`,
+		Placeholders: []TemplatePlaceholder{
+			{Name: "child_table", Kind: "table", Description: "Table holding the references"},
+			{Name: "child_column", Kind: "column", Table: "child_table", Description: "Referencing column"},
+			{Name: "parent_table", Kind: "table", Description: "Referenced table"},
+			{Name: "parent_column", Kind: "column", Table: "parent_table", Description: "Referenced column, usually the primary key"},
+		},
+	},
+	{
+		ID:          "table-growth",
+		Category:    "growth",
+		Name:        "Table growth",
+		Description: "New rows per day, week or month according to a timestamp column",
+		SQL: `SELECT {{period}} AS period_start, COUNT(*) AS new_rows
+FROM {{table}}
+WHERE {{time_column}} IS NOT NULL
+GROUP BY {{period}}
+ORDER BY period_start`,
+		Placeholders: []TemplatePlaceholder{
+			{Name: "table", Kind: "table", Description: "Table to measure"},
+			{Name: "time_column", Kind: "column", Table: "table", Description: "Creation timestamp of the rows"},
+			{Name: "period", Kind: "period", Column: "time_column", Default: "day", Options: periods, Description: "Length of the periods counted"},
+		},
+	},
+}
+
+// GetTemplates lists the bundled query templates, optionally of one
+// ?category, with the tables of the default schema as table options
+func (h *Handler) GetTemplates(c *gin.Context) {
+	tables, err := h.dialect.ListTables(h.db, "")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	tables = filterTables(rbac.FromContext(c.Request.Context()), "", tables)
+	var tableNames []string
+	for _, t := range tables {
+		tableNames = append(tableNames, t.Name)
+	}
+
+	category := c.Query("category")
+	var categories []string
+	templates := []QueryTemplate{}
+	for _, t := range templateLibrary {
+		if !slices.Contains(categories, t.Category) {
+			categories = append(categories, t.Category)
+		}
+		if category != "" && t.Category != category {
+			continue
+		}
+		t.Placeholders = slices.Clone(t.Placeholders)
+		for i, p := range t.Placeholders {
+			if p.Kind == "table" {
+				t.Placeholders[i].Options = tableNames
+			}
+		}
+		templates = append(templates, t)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"categories": categories, "templates": templates})
+}
+
+// RenderTemplate fills in a template's placeholders after checking tables
+// and columns against the connected schema. The SQL can be sent to
+// /run-query as is.
+func (h *Handler) RenderTemplate(c *gin.Context) {
+	i := slices.IndexFunc(templateLibrary, func(t QueryTemplate) bool { return t.ID == c.Param("id") })
+	if i < 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
+		return
+	}
+	tmpl := templateLibrary[i]
+	var req TemplateRenderRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		return
+	}
+
+	values := map[string]string{}
+	for _, p := range tmpl.Placeholders {
+		v := strings.TrimSpace(req.Values[p.Name])
+		if v == "" {
+			v = p.Default
+		}
+		if v == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": p.Name + " is required"})
+			return
+		}
+		values[p.Name] = v
+	}
+
+	tables, err := h.dialect.ListTables(h.db, "")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	access := rbac.FromContext(c.Request.Context())
+	// Placeholders are listed after those they refer to, so a table's
+	// columns are known by the time its column placeholders are checked
+	columns := map[string][]ColumnInfo{}
+	substitutions := map[string]string{}
+	for _, p := range tmpl.Placeholders {
+		v := values[p.Name]
+		switch p.Kind {
+		case "table":
+			if !slices.ContainsFunc(tables, func(t TableInfo) bool { return t.Name == v }) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": p.Name + ": table " + v + " not found"})
+				return
+			}
+			if !access.CanTable("", v) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Access denied: table " + v + " is not granted"})
+				return
+			}
+			if columns[v], err = h.dialect.ListColumns(h.db, "", v); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			substitutions[p.Name] = h.templateIdent(v)
+		case "column", "columns":
+			table := values[p.Table]
+			var quoted []string
+			for _, name := range strings.Split(v, ",") {
+				name = strings.TrimSpace(name)
+				if !slices.ContainsFunc(columns[table], func(col ColumnInfo) bool { return col.Name == name }) {
+					c.JSON(http.StatusBadRequest, gin.H{"error": p.Name + ": column " + name + " not found in " + table})
+					return
+				}
+				quoted = append(quoted, h.templateIdent(name))
+			}
+			if p.Kind == "column" && len(quoted) > 1 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": p.Name + " takes a single column"})
+				return
+			}
+			substitutions[p.Name] = strings.Join(quoted, ", ")
+		case "number":
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": p.Name + " must be a positive integer"})
+				return
+			}
+			substitutions[p.Name] = strconv.Itoa(n)
+		case "period":
+			if !slices.Contains(periods, v) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": p.Name + " must be day, week or month"})
+				return
+			}
+			substitutions[p.Name] = h.dialect.TruncateTime(substitutions[p.Column], v)
+		}
+	}
+
+	sql := templatePlaceholder.ReplaceAllStringFunc(tmpl.SQL, func(m string) string {
+		return substitutions[m[2:len(m)-2]]
+	})
+	c.JSON(http.StatusOK, gin.H{"id": tmpl.ID, "sql": sql})
+}
+
+// templateIdent quotes only names that need it: the query validator does not
+// accept quoted identifiers, and names are checked against the schema anyway
+func (h *Handler) templateIdent(name string) string {
+	if identPattern.MatchString(name) {
+		return name
+	}
+	return h.dialect.Quote(name)
+}
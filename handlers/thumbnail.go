@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	_ "image/gif" // registers GIF decoding
+	"image/jpeg"
+	"image/png"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetThumbnail scales an image stored in a binary column down to fit ?size
+// pixels (128 by default) and returns it as PNG, or JPEG for JPEG sources.
+// The value is selected as for DownloadBlob. Images already small enough are
+// re-encoded at their size.
+func (h *Handler) GetThumbnail(c *gin.Context) {
+	limits := h.cfg.Query.Thumbnails
+	if !limits.Enabled {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Thumbnails are disabled"})
+		return
+	}
+	size, ok := intQuery(c, "size", 128, limits.MaxSize)
+	if !ok {
+		return
+	}
+	if size == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "size must be positive"})
+		return
+	}
+	ref, ok := h.resolveBlob(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	release, err := h.acquireStatement(ctx)
+	if err != nil {
+		respondQueryError(c, err)
+		return
+	}
+	tx, end, err := h.dialect.BeginReadOnly(ctx, h.queryDB(ctx))
+	if err != nil {
+		release()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start read-only transaction: " + err.Error()})
+		return
+	}
+	n, ok := h.blobSize(c, tx, ref)
+	if ok && n > limits.MaxSourceBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Image is larger than query.thumbnails.max_source_bytes"})
+		ok = false
+	}
+	var data []byte
+	for pos := int64(0); ok && pos < n; pos += blobChunkSize {
+		chunk, err := h.blobSlice(ctx, tx, ref, pos, min(blobChunkSize, n-pos))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			ok = false
+		}
+		data = append(data, chunk...)
+	}
+	// Decoding needs no connection
+	end()
+	release()
+	if !ok {
+		return
+	}
+
+	contentType := http.DetectContentType(data)
+	if contentType != "image/png" && contentType != "image/jpeg" && contentType != "image/gif" {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Value is " + contentType + ", not a PNG, JPEG or GIF image"})
+		return
+	}
+	// Check the dimensions before decoding, so that a small file cannot
+	// claim a huge canvas
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Invalid image: " + err.Error()})
+		return
+	}
+	if cfg.Width*cfg.Height > limits.MaxSourcePixels {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Image is larger than query.thumbnails.max_source_pixels"})
+		return
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Invalid image: " + err.Error()})
+		return
+	}
+
+	thumb := scaleImage(src, size)
+	var out bytes.Buffer
+	if contentType == "image/jpeg" {
+		err = jpeg.Encode(&out, thumb, &jpeg.Options{Quality: 85})
+	} else {
+		contentType = "image/png"
+		err = png.Encode(&out, thumb)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Data(http.StatusOK, contentType, out.Bytes())
+}
+
+// scaleImage shrinks src to fit a size×size box, keeping its aspect ratio,
+// by averaging the source pixels covered by each target pixel
+func scaleImage(src image.Image, size int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= size && h <= size {
+		return src
+	}
+	tw, th := size, max(h*size/w, 1)
+	if h > w {
+		tw, th = max(w*size/h, 1), size
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, tw, th))
+	for y := range th {
+		y0, y1 := b.Min.Y+y*h/th, b.Min.Y+max((y+1)*h/th, y*h/th+1)
+		for x := range tw {
+			x0, x1 := b.Min.X+x*w/tw, b.Min.X+max((x+1)*w/tw, x*w/tw+1)
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					c := color.NRGBA64Model.Convert(src.At(sx, sy)).(color.NRGBA64)
+					// Weigh colors by alpha so transparent pixels don't darken edges
+					r += uint64(c.R) * uint64(c.A)
+					g += uint64(c.G) * uint64(c.A)
+					bl += uint64(c.B) * uint64(c.A)
+					a += uint64(c.A)
+					n++
+				}
+			}
+			var px color.NRGBA
+			if a > 0 {
+				px = color.NRGBA{uint8(r / a >> 8), uint8(g / a >> 8), uint8(bl / a >> 8), uint8(a / n >> 8)}
+			}
+			dst.SetNRGBA(x, y, px)
+		}
+	}
+	return dst
+}
@@ -0,0 +1,430 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"sql-engine/rbac"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxTimeseriesBuckets caps the points of a gap-filled series
+const maxTimeseriesBuckets = 10000
+
+// TimeseriesRequest describes a series of aggregates over fixed time
+// buckets. Interval is a duration such as "15m", "1h" or "7d"; buckets are
+// aligned to the Unix epoch in UTC. Start and End (RFC 3339) bound the
+// series; without them it spans the buckets found.
+type TimeseriesRequest struct {
+	Schema       string                `json:"schema"`
+	Table        string                `json:"table"`
+	TimeColumn   string                `json:"time_column"`
+	Interval     string                `json:"interval"`
+	Start        *time.Time            `json:"start"`
+	End          *time.Time            `json:"end"`
+	Aggregations []TimeseriesAggregate `json:"aggregations"`
+	Filters      []ColumnFilter        `json:"filters"`
+	// Fill is the value of empty buckets: "null" (default) or "zero".
+	// Counts of empty buckets are always zero.
+	Fill string `json:"fill"`
+}
+
+// TimeseriesAggregate is one value computed per bucket. Function is count,
+// sum, avg, min or max; count needs no column.
+type TimeseriesAggregate struct {
+	Function string `json:"function"`
+	Column   string `json:"column"`
+	// Alias names the value in the points, by default function_column
+	Alias string `json:"alias"`
+}
+
+// ColumnFilter restricts the rows analyzed. Op is =, !=, <, <=, >, >=,
+// is_null or not_null. A string Value of "" matches empty strings, never
+// NULLs, which only is_null and not_null test.
+type ColumnFilter struct {
+	Column string `json:"column"`
+	// Path filters on a value inside a JSON column instead, given as keys
+	// and array indexes separated by dots, e.g. items.0.sku
+	Path  string      `json:"path,omitempty"`
+	Op    string      `json:"op"`
+	Value interface{} `json:"value"`
+}
+
+var (
+	timeseriesFunctions = []string{"count", "sum", "avg", "min", "max"}
+	filterOps           = map[string]string{"=": "=", "!=": "<>", "<": "<", "<=": "<=", ">": ">", ">=": ">="}
+)
+
+// RunTimeseries builds and runs a bucketed aggregate query and returns one
+// point per bucket, with the gaps filled in
+func (h *Handler) RunTimeseries(c *gin.Context) {
+	var req TimeseriesRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		return
+	}
+	interval, err := parseBucketInterval(req.Interval)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "interval: " + err.Error()})
+		return
+	}
+	if req.Fill == "" {
+		req.Fill = "null"
+	}
+	if req.Fill != "null" && req.Fill != "zero" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": `fill must be "null" or "zero"`})
+		return
+	}
+	if req.Start != nil && req.End != nil && !req.Start.Before(*req.End) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "start must be before end"})
+		return
+	}
+	if len(req.Aggregations) == 0 {
+		req.Aggregations = []TimeseriesAggregate{{Function: "count"}}
+	}
+
+	schema := orDefault(h.dialect, req.Schema)
+	if matchesAny(h.cfg.Query.Denylist.Tables, req.Table) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Denied: table " + req.Table + " is not allowed"})
+		return
+	}
+	if !h.requireTable(c, schema, req.Table) || !h.requireRowLevel(c, schema, req.Table) {
+		return
+	}
+	columns, err := h.dialect.ListColumns(h.db, schema, req.Table)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(columns) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Table not found"})
+		return
+	}
+
+	checkColumn := func(field, col string) bool {
+		return h.analysisColumn(c, schema, req.Table, columns, field, col)
+	}
+	if !checkColumn("time_column", req.TimeColumn) {
+		return
+	}
+
+	q := h.dialect.Quote
+	bucket := h.dialect.BucketEpoch(q(req.TimeColumn), int64(interval/time.Second))
+	selects := []string{bucket + " AS bucket"}
+	aliases := []string{}
+	for i, agg := range req.Aggregations {
+		agg.Function = strings.ToLower(agg.Function)
+		if !slices.Contains(timeseriesFunctions, agg.Function) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown aggregate function " + agg.Function})
+			return
+		}
+		arg := "*"
+		if agg.Column != "" {
+			if !checkColumn(fmt.Sprintf("aggregations[%d]", i), agg.Column) {
+				return
+			}
+			arg = q(agg.Column)
+		} else if agg.Function != "count" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": agg.Function + " needs a column"})
+			return
+		}
+		if agg.Alias == "" {
+			agg.Alias = agg.Function
+			if agg.Column != "" {
+				agg.Alias += "_" + agg.Column
+			}
+		}
+		if agg.Alias == "bucket" || slices.Contains(aliases, agg.Alias) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Duplicate alias " + agg.Alias})
+			return
+		}
+		req.Aggregations[i] = agg
+		aliases = append(aliases, agg.Alias)
+		selects = append(selects, fmt.Sprintf("%s(%s)", strings.ToUpper(agg.Function), arg))
+	}
+
+	conds := []string{q(req.TimeColumn) + " IS NOT NULL"}
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return h.dialect.Placeholder(len(args))
+	}
+	if req.Start != nil {
+		conds = append(conds, q(req.TimeColumn)+" >= "+arg(req.Start.UTC()))
+	}
+	if req.End != nil {
+		conds = append(conds, q(req.TimeColumn)+" < "+arg(req.End.UTC()))
+	}
+	for i, f := range req.Filters {
+		if !checkColumn(fmt.Sprintf("filters[%d]", i), f.Column) {
+			return
+		}
+		cond, err := h.filterCondition(f, columns, arg)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("filters[%d]: %v", i, err)})
+			return
+		}
+		conds = append(conds, cond)
+	}
+
+	sqlText := fmt.Sprintf("SELECT %s FROM %s.%s WHERE %s GROUP BY %s ORDER BY 1",
+		strings.Join(selects, ", "), q(schema), q(req.Table), strings.Join(conds, " AND "), bucket)
+
+	ctx := c.Request.Context()
+	if timeout := h.queryTimeout(ctx); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	values, err := h.timeseriesBuckets(ctx, sqlText, args, len(aliases))
+	if err != nil {
+		respondQueryError(c, err)
+		return
+	}
+
+	// Fill the gaps between the first and last bucket of the range
+	var first, last int64
+	step := int64(interval / time.Second)
+	if len(values) > 0 {
+		epochs := slices.Sorted(maps.Keys(values))
+		first, last = epochs[0], epochs[len(epochs)-1]
+	}
+	if req.Start != nil {
+		first = floorDiv(req.Start.Unix(), step) * step
+	}
+	if req.End != nil {
+		last = floorDiv(req.End.Unix()-1, step) * step
+	}
+	points := []map[string]interface{}{}
+	if len(values) > 0 || (req.Start != nil && req.End != nil) {
+		if (last-first)/step+1 > maxTimeseriesBuckets {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("The series would have more than %d buckets; use a longer interval or a shorter range", maxTimeseriesBuckets)})
+			return
+		}
+		for epoch := first; epoch <= last; epoch += step {
+			point := map[string]interface{}{"bucket": time.Unix(epoch, 0).UTC()}
+			row, found := values[epoch]
+			for i, agg := range req.Aggregations {
+				switch {
+				case found:
+					point[agg.Alias] = row[i]
+				case agg.Function == "count" || req.Fill == "zero":
+					point[agg.Alias] = 0
+				default:
+					point[agg.Alias] = nil
+				}
+			}
+			points = append(points, point)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"sql":      sqlText,
+		"interval": interval.String(),
+		"columns":  append([]string{"bucket"}, aliases...),
+		"points":   points,
+	})
+}
+
+// timeseriesBuckets runs the bucketed query read-only and returns the
+// aggregates keyed by bucket start (Unix seconds)
+func (h *Handler) timeseriesBuckets(ctx context.Context, sqlText string, args []interface{}, n int) (map[int64][]interface{}, error) {
+	release, err := h.acquireStatement(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	tx, end, err := h.dialect.BeginReadOnly(ctx, h.queryDB(ctx))
+	if err != nil {
+		return nil, &QueryError{Status: http.StatusInternalServerError, Message: "Failed to start read-only transaction: " + err.Error()}
+	}
+	defer end()
+	rows, err := tx.QueryContext(ctx, sqlText, args...)
+	if err != nil {
+		return nil, &QueryError{Status: http.StatusInternalServerError, Message: "Execution failed: " + err.Error()}
+	}
+	defer rows.Close()
+
+	values := map[int64][]interface{}{}
+	for rows.Next() {
+		var epoch sql.NullInt64
+		vals := make([]interface{}, n)
+		ptrs := []interface{}{&epoch}
+		for i := range vals {
+			ptrs = append(ptrs, &vals[i])
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, &QueryError{Status: http.StatusInternalServerError, Message: "Row scan failed: " + err.Error()}
+		}
+		// Values the database cannot read as a time have no bucket
+		if epoch.Valid {
+			values[epoch.Int64] = vals
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, &QueryError{Status: http.StatusInternalServerError, Message: err.Error()}
+	}
+	return values, nil
+}
+
+// parseBucketInterval parses a Go duration, also accepting whole days such
+// as "7d". Buckets are at least a second long.
+func parseBucketInterval(s string) (time.Duration, error) {
+	var d time.Duration
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid interval %q", s)
+		}
+		d = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		if d, err = time.ParseDuration(s); err != nil {
+			return 0, err
+		}
+	}
+	if d < time.Second || d%time.Second != 0 {
+		return 0, errors.New("must be a whole number of seconds, at least 1s")
+	}
+	return d, nil
+}
+
+// floorDiv divides rounding towards negative infinity
+func floorDiv(a, b int64) int64 {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
+
+// analysisColumn writes an error response unless col is a column of the
+// table the caller may read unmasked. Aggregates would otherwise reveal
+// what masking hides.
+func (h *Handler) analysisColumn(c *gin.Context, schema, table string, columns []ColumnInfo, field, col string) bool {
+	access := rbac.FromContext(c.Request.Context())
+	var roles []string
+	if access != nil {
+		roles = access.Roles
+	}
+	grantSchema := h.grantSchema(schema)
+	switch {
+	case !slices.ContainsFunc(columns, func(ci ColumnInfo) bool { return ci.Name == col }):
+		c.JSON(http.StatusBadRequest, gin.H{"error": field + ": column " + col + " not found"})
+	case !access.CanColumn(grantSchema, table, col):
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied: column " + col + " is not granted"})
+	case h.masks.Method(grantSchema, table, col, roles) != "":
+		c.JSON(http.StatusForbidden, gin.H{"error": "Masked column " + col + " cannot be analyzed"})
+	default:
+		return true
+	}
+	return false
+}
+
+// filterCondition returns the SQL condition of a filter whose column was
+// already checked; arg binds a value and returns its placeholder. Values at
+// a JSON path are compared as text, or as numbers when the value is one.
+func (h *Handler) filterCondition(f ColumnFilter, columns []ColumnInfo, arg func(interface{}) string) (string, error) {
+	number, numeric := filterNumber(f)
+	col, err := h.filterColumn(f, columns, numeric)
+	if err != nil {
+		return "", err
+	}
+	switch f.Op {
+	case "is_null":
+		return col + " IS NULL", nil
+	case "not_null":
+		return col + " IS NOT NULL", nil
+	}
+	op, ok := filterOps[f.Op]
+	if !ok {
+		return "", errors.New("unknown operator " + f.Op)
+	}
+	if f.Value == nil {
+		return "", errors.New(f.Op + " needs a value; is_null and not_null match NULLs")
+	}
+	if f.Value == "" {
+		if err := h.emptyFilterValue(f, columns); err != nil {
+			return "", err
+		}
+	}
+	if numeric {
+		// Inlined, as a parsed number can't inject SQL and exports bind
+		// values as string literals
+		return col + " " + op + " " + strconv.FormatFloat(number, 'g', -1, 64), nil
+	}
+	if f.Path != "" {
+		return col + " " + op + " " + arg(fmt.Sprint(f.Value)), nil
+	}
+	return col + " " + op + " " + arg(f.Value), nil
+}
+
+// emptyFilterValue refuses comparing a numeric or date/time column with an
+// empty string where the engine enforces column types: such columns never
+// hold one, the cast fails, and callers likely meant NULL
+func (h *Handler) emptyFilterValue(f ColumnFilter, columns []ColumnInfo) error {
+	i := slices.IndexFunc(columns, func(ci ColumnInfo) bool { return ci.Name == f.Column })
+	if f.Path != "" || i < 0 || !h.dialect.StrictTypes() {
+		return nil
+	}
+	if dataType := columns[i].DataType; isNumericType(dataType) || isTemporalType(dataType) {
+		return fmt.Errorf("column %s is %s and holds no empty strings; is_null and not_null match NULLs", f.Column, dataType)
+	}
+	return nil
+}
+
+// filterColumn returns the expression a filter tests: the quoted column, or
+// the value at its JSON path
+func (h *Handler) filterColumn(f ColumnFilter, columns []ColumnInfo, numeric bool) (string, error) {
+	col := h.dialect.Quote(f.Column)
+	if f.Path == "" {
+		return col, nil
+	}
+	path := strings.Split(f.Path, ".")
+	if slices.Contains(path, "") {
+		return "", errors.New("path " + f.Path + " has an empty element")
+	}
+	var dataType string
+	if i := slices.IndexFunc(columns, func(ci ColumnInfo) bool { return ci.Name == f.Column }); i >= 0 {
+		dataType = columns[i].DataType
+	}
+	expr, ok := h.dialect.JSONPath(col, dataType, path, numeric)
+	if !ok {
+		return "", errors.New("column " + f.Column + " does not hold JSON")
+	}
+	return expr, nil
+}
+
+// filterNumber returns the value of a JSON path filter as a number if it
+// compares numbers: when it was sent as one, or for an ordering operator
+// when it reads as one
+func filterNumber(f ColumnFilter) (float64, bool) {
+	if f.Path == "" {
+		return 0, false
+	}
+	switch v := f.Value.(type) {
+	case float64:
+		return v, true
+	case json.Number:
+		n, err := v.Float64()
+		return n, err == nil
+	case string:
+		if f.Op == "<" || f.Op == "<=" || f.Op == ">" || f.Op == ">=" {
+			n, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			return n, err == nil && !math.IsInf(n, 0) && !math.IsNaN(n)
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,232 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sort"
+	"time"
+
+	"sql-engine/auth"
+	"sql-engine/rbac"
+	"sql-engine/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+const trashPrefix = "trash/"
+
+// trashKinds maps the kinds of saved objects deletion moves to the trash to
+// the store prefix of their keys
+var trashKinds = map[string]string{
+	"saved-query":     savedQueryPrefix,
+	"draft":           draftPrefix,
+	"schema-snapshot": namedSnapshotPrefix,
+}
+
+// TrashItem is a deleted saved object that can still be restored. Deleting
+// an object whose ID is already in the trash replaces the older copy.
+type TrashItem struct {
+	Kind      string    `json:"kind"`
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Owner     string    `json:"owner,omitempty"`
+	DeletedBy string    `json:"deleted_by,omitempty"`
+	DeletedAt time.Time `json:"deleted_at"`
+	// PurgeAt is when the item is deleted for good, unless kept forever
+	PurgeAt *time.Time `json:"purge_at,omitempty"`
+	// Object is the deleted object as it was stored
+	Object json.RawMessage `json:"object,omitempty"`
+}
+
+func trashKey(kind, id string) string {
+	return trashPrefix + kind + "/" + id
+}
+
+// visibleTo reports whether the caller may list, restore and purge the item:
+// its owner, whoever deleted it, and administrators
+func (t TrashItem) visibleTo(c *gin.Context) bool {
+	principal := auth.Principal(c)
+	if access := rbac.FromContext(c.Request.Context()); access != nil && access.IsAdmin() {
+		return true
+	}
+	return t.Owner == "" || t.Owner == principal || t.DeletedBy == principal
+}
+
+// moveToTrash deletes a saved object, keeping a copy in the trash
+func (h *Handler) moveToTrash(c *gin.Context, kind, id, name, owner string, object any) error {
+	data, err := json.Marshal(object)
+	if err != nil {
+		return err
+	}
+	item := TrashItem{
+		Kind:      kind,
+		ID:        id,
+		Name:      name,
+		Owner:     owner,
+		DeletedBy: auth.Principal(c),
+		DeletedAt: time.Now(),
+		Object:    data,
+	}
+	if retention := h.cfg.Trash.Retention; retention > 0 {
+		purgeAt := item.DeletedAt.Add(retention)
+		item.PurgeAt = &purgeAt
+	}
+	var replaced TrashItem
+	hadReplaced := h.meta.Get(trashKey(kind, id), &replaced) == nil
+	if err := h.meta.Put(trashKey(kind, id), item); err != nil {
+		return err
+	}
+	if hadReplaced {
+		h.deleteTrashArtifacts(replaced)
+	}
+	return h.meta.Delete(trashKinds[kind] + id)
+}
+
+// deleteTrashArtifacts deletes the files an item purged from the trash kept
+// in the artifact store
+func (h *Handler) deleteTrashArtifacts(item TrashItem) {
+	if item.Kind != "schema-snapshot" {
+		return
+	}
+	var snap SchemaSnapshot
+	if err := json.Unmarshal(item.Object, &snap); err != nil || snap.Artifact == "" {
+		return
+	}
+	if err := h.artifacts.Delete(context.Background(), snap.Artifact); err != nil {
+		slog.Error("Failed to delete snapshot artifact", "error", err)
+	}
+}
+
+// ListTrash lists the deleted objects the caller may restore, most recently
+// deleted first, optionally only those of ?kind
+func (h *Handler) ListTrash(c *gin.Context) {
+	prefix := trashPrefix
+	if kind := c.Query("kind"); kind != "" {
+		if _, ok := trashKinds[kind]; !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "kind must be saved-query, draft or schema-snapshot"})
+			return
+		}
+		prefix += kind + "/"
+	}
+	keys, err := h.meta.List(prefix)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	items := []TrashItem{}
+	for _, key := range keys {
+		var item TrashItem
+		if err := h.meta.Get(key, &item); err != nil || !item.visibleTo(c) {
+			continue
+		}
+		item.Object = nil
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].DeletedAt.After(items[j].DeletedAt) })
+	c.JSON(http.StatusOK, gin.H{"items": items, "retention": h.cfg.Trash.Retention.String()})
+}
+
+// RestoreTrashItem puts a deleted object back where it was. It fails when an
+// object with the same ID has been created since.
+func (h *Handler) RestoreTrashItem(c *gin.Context) {
+	item, ok := h.loadTrashItem(c)
+	if !ok {
+		return
+	}
+	key := trashKinds[item.Kind] + item.ID
+	if err := h.meta.Create(key, item.Object); err != nil {
+		if errors.Is(err, store.ErrExists) {
+			c.JSON(http.StatusConflict, gin.H{"error": "A " + item.Kind + " with ID " + item.ID + " exists again; delete or rename it first"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	if err := h.meta.Delete(trashKey(item.Kind, item.ID)); err != nil && !errors.Is(err, store.ErrNotFound) {
+		slog.ErrorContext(c.Request.Context(), "Failed to remove restored item from the trash", "error", err)
+	}
+	if item.Kind == "saved-query" {
+		var q SavedQuery
+		if err := json.Unmarshal(item.Object, &q); err == nil {
+			h.scheduleTests(q)
+		}
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", item.Object)
+}
+
+// PurgeTrashItem deletes a trashed object for good
+func (h *Handler) PurgeTrashItem(c *gin.Context) {
+	item, ok := h.loadTrashItem(c)
+	if !ok {
+		return
+	}
+	if err := h.meta.Delete(trashKey(item.Kind, item.ID)); err != nil && !errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	h.deleteTrashArtifacts(item)
+	c.Status(http.StatusNoContent)
+}
+
+// loadTrashItem fetches the item named by the :kind and :id route
+// parameters, writing an error response if it can't be loaded. Items the
+// caller may not see are reported as missing.
+func (h *Handler) loadTrashItem(c *gin.Context) (TrashItem, bool) {
+	var item TrashItem
+	kind := c.Param("kind")
+	if _, ok := trashKinds[kind]; !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown kind " + kind})
+		return item, false
+	}
+	err := h.meta.Get(trashKey(kind, c.Param("id")), &item)
+	if errors.Is(err, store.ErrNotFound) || (err == nil && !item.visibleTo(c)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Item not found in the trash"})
+		return item, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return item, false
+	}
+	return item, true
+}
+
+// StartTrashPurge periodically deletes trashed objects whose retention has
+// passed
+func (h *Handler) StartTrashPurge() {
+	if h.cfg.Trash.Retention == 0 {
+		return
+	}
+	purge := func(ctx context.Context) {
+		h.purgeTrash()
+	}
+	h.sched.Go("trash-purge", purge)
+	h.sched.Every("trash-purge", time.Hour, purge)
+}
+
+func (h *Handler) purgeTrash() {
+	keys, err := h.meta.List(trashPrefix)
+	if err != nil {
+		slog.Error("Failed to list the trash", "error", err)
+		return
+	}
+	now := time.Now()
+	for _, key := range keys {
+		var item TrashItem
+		if err := h.meta.Get(key, &item); err != nil {
+			continue
+		}
+		// The retention configured now applies, not the one at deletion
+		purgeAt := item.DeletedAt.Add(h.cfg.Trash.Retention)
+		if now.Before(purgeAt) {
+			continue
+		}
+		if err := h.meta.Delete(key); err != nil && !errors.Is(err, store.ErrNotFound) {
+			slog.Error("Failed to purge trash item", "error", err)
+			continue
+		}
+		h.deleteTrashArtifacts(item)
+	}
+}
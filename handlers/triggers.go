@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TriggerInfo describes a trigger on a table
+type TriggerInfo struct {
+	Name string `json:"name"`
+	// Timing is BEFORE, AFTER or INSTEAD OF
+	Timing string `json:"timing"`
+	// Events lists INSERT, UPDATE, DELETE and TRUNCATE
+	Events []string `json:"events"`
+	// Level is ROW or STATEMENT
+	Level     string `json:"level"`
+	Condition string `json:"condition,omitempty"`
+	// Function is the trigger function called; SQLite triggers have their
+	// statements inline in the definition instead
+	Function   string `json:"function,omitempty"`
+	Enabled    bool   `json:"enabled"`
+	Definition string `json:"definition"`
+}
+
+func (h *Handler) GetTableTriggers(c *gin.Context) {
+	schema, ok := h.schemaParam(c)
+	if !ok {
+		return
+	}
+	tableName := c.Param("name")
+	if !h.requireTable(c, schema, tableName) {
+		return
+	}
+
+	triggers, err := h.dialect.ListTriggers(h.db, schema, tableName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if triggers == nil {
+		triggers = []TriggerInfo{}
+	}
+
+	h.render(c, gin.H{
+		"schema":     schema,
+		"table_name": tableName,
+		"triggers":   triggers,
+	}, nil)
+}
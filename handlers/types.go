@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TypeRef points at a user-defined type
+type TypeRef struct {
+	Schema string `json:"schema"`
+	Name   string `json:"name"`
+	// Kind is enum, domain, composite, range, multirange or base
+	Kind string `json:"kind"`
+}
+
+// TypeInfo describes an enum, domain or composite type. Only the fields of
+// its kind are set.
+type TypeInfo struct {
+	Schema string `json:"schema"`
+	Name   string `json:"name"`
+	Kind   string `json:"kind"`
+	// Labels are an enum's values in sort order
+	Labels []string `json:"labels,omitempty"`
+	// BaseType, NotNull, Default and Checks describe a domain
+	BaseType string   `json:"base_type,omitempty"`
+	NotNull  bool     `json:"not_null,omitempty"`
+	Default  *string  `json:"default,omitempty"`
+	Checks   []string `json:"checks,omitempty"`
+	// Attributes are a composite type's fields
+	Attributes []TypeAttribute `json:"attributes,omitempty"`
+}
+
+// TypeAttribute is a field of a composite type
+type TypeAttribute struct {
+	Name     string `json:"name"`
+	DataType string `json:"data_type"`
+}
+
+// GetTypes lists the user-defined types of a schema
+func (h *Handler) GetTypes(c *gin.Context) {
+	schema, ok := h.schemaParam(c)
+	if !ok {
+		return
+	}
+	types, err := h.dialect.ListTypes(h.db, schema)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if types == nil {
+		types = []TypeInfo{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"schema": schema, "types": types})
+}
@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"errors"
+	"maps"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"sql-engine/auth"
+	"sql-engine/store"
+
+	sqlparser "github.com/blastrain/vitess-sqlparser/sqlparser"
+	"github.com/gin-gonic/gin"
+)
+
+const sqlVariablePrefix = "sql-variable/"
+
+var (
+	sqlVariableName      = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+	sqlVariableReference = regexp.MustCompile(`\{\{\s*([a-z_][a-z0-9_]*)\s*\}\}`)
+)
+
+// SQLVariable is a workspace-wide constant or snippet that queries refer to
+// as {{name}}, e.g. a fiscal year or a standard date filter. Value must be a
+// single SQL expression; it is parenthesized when expanded.
+type SQLVariable struct {
+	Name        string    `json:"name"`
+	Value       string    `json:"value"`
+	Description string    `json:"description,omitempty"`
+	UpdatedBy   string    `json:"updated_by,omitempty"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// SQLVariableRequest is the writable part of a SQL variable
+type SQLVariableRequest struct {
+	Value       string `json:"value"`
+	Description string `json:"description"`
+}
+
+func (h *Handler) GetSQLVariables(c *gin.Context) {
+	vars, err := h.sqlVariables()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	list := []SQLVariable{}
+	for _, name := range slices.Sorted(maps.Keys(vars)) {
+		list = append(list, vars[name])
+	}
+	c.JSON(http.StatusOK, gin.H{"variables": list})
+}
+
+// SetSQLVariable creates or replaces the variable named by :name
+func (h *Handler) SetSQLVariable(c *gin.Context) {
+	name := c.Param("name")
+	if !sqlVariableName.MatchString(name) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name must be lowercase letters, digits and underscores"})
+		return
+	}
+	var req SQLVariableRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		return
+	}
+	value := strings.TrimSpace(req.Value)
+	if err := h.validateSQLVariable(value); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "value: " + err.Error()})
+		return
+	}
+
+	v := SQLVariable{
+		Name:        name,
+		Value:       value,
+		Description: req.Description,
+		UpdatedBy:   auth.Principal(c),
+		UpdatedAt:   time.Now(),
+	}
+	if err := h.meta.Put(sqlVariablePrefix+name, v); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, v)
+}
+
+func (h *Handler) DeleteSQLVariable(c *gin.Context) {
+	if err := h.meta.Delete(sqlVariablePrefix + c.Param("name")); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "SQL variable not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// validateSQLVariable accepts values that parse as exactly one expression,
+// so that an expanded variable cannot add clauses or statements to a query
+func (h *Handler) validateSQLVariable(value string) error {
+	if value == "" {
+		return errors.New("is required")
+	}
+	if strings.Contains(value, "{{") {
+		return errors.New("cannot refer to other variables")
+	}
+	if strings.Contains(value, ";") || strings.Contains(value, "--") || strings.Contains(value, "/*") {
+		return errors.New("cannot contain semicolons or comments")
+	}
+	// The parentheses added on expansion must only close at the end
+	if inner, ok := balancedParens("(" + value + ")"); !ok || inner != value {
+		return errors.New("has unbalanced parentheses")
+	}
+	stmt, err := h.dialect.ParseStatement("SELECT (" + value + ")")
+	if err != nil {
+		return errors.New("must be a single SQL expression")
+	}
+	if sel, ok := stmt.(*sqlparser.Select); !ok || len(sel.SelectExprs) != 1 {
+		return errors.New("must be a single SQL expression")
+	}
+	return nil
+}
+
+// expandSQLVariables replaces {{name}} references in sqlText with the
+// parenthesized variable values. The store is only read when the query
+// refers to a variable.
+func (h *Handler) expandSQLVariables(sqlText string) (string, error) {
+	if !sqlVariableReference.MatchString(sqlText) {
+		return sqlText, nil
+	}
+	vars, err := h.sqlVariables()
+	if err != nil {
+		return "", &QueryError{Status: http.StatusServiceUnavailable, Message: "SQL variables unavailable: " + err.Error()}
+	}
+	var unknown string
+	expanded := sqlVariableReference.ReplaceAllStringFunc(sqlText, func(m string) string {
+		name := sqlVariableReference.FindStringSubmatch(m)[1]
+		v, ok := vars[name]
+		if !ok {
+			unknown = name
+			return m
+		}
+		return "(" + v.Value + ")"
+	})
+	if unknown != "" {
+		return "", &QueryError{Status: http.StatusBadRequest, Message: "Unknown SQL variable: " + unknown}
+	}
+	return expanded, nil
+}
+
+func (h *Handler) sqlVariables() (map[string]SQLVariable, error) {
+	keys, err := h.meta.List(sqlVariablePrefix)
+	if err != nil {
+		return nil, err
+	}
+	vars := map[string]SQLVariable{}
+	for _, key := range keys {
+		var v SQLVariable
+		if err := h.meta.Get(key, &v); err != nil {
+			return nil, err
+		}
+		vars[v.Name] = v
+	}
+	return vars, nil
+}
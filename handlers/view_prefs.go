@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"sql-engine/auth"
+	"sql-engine/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+const viewPrefsPrefix = "view-prefs/"
+
+// Bounds of view preferences, which only name result columns
+const (
+	maxViewColumns     = 1000
+	maxViewColumnWidth = 4000
+)
+
+// ViewPreferences is how a user lays out the columns of a table preview or
+// of a saved query's results. Columns are named as in the results; names
+// the results no longer have are ignored by clients rather than rejected,
+// so preferences survive schema changes.
+type ViewPreferences struct {
+	// ColumnOrder lists columns in display order; columns left out follow
+	// in their result order
+	ColumnOrder []string `json:"column_order"`
+	Hidden      []string `json:"hidden_columns"`
+	// Widths are in pixels
+	Widths map[string]int `json:"column_widths"`
+	// Pinned columns stay in view when scrolling sideways
+	Pinned    []string  `json:"pinned_columns"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ViewPreferencesRequest replaces the caller's preferences for a view
+type ViewPreferencesRequest struct {
+	ColumnOrder []string       `json:"column_order"`
+	Hidden      []string       `json:"hidden_columns"`
+	Widths      map[string]int `json:"column_widths"`
+	Pinned      []string       `json:"pinned_columns"`
+}
+
+func (r ViewPreferencesRequest) validate() error {
+	for field, names := range map[string][]string{"column_order": r.ColumnOrder, "hidden_columns": r.Hidden, "pinned_columns": r.Pinned} {
+		if len(names) > maxViewColumns {
+			return fmt.Errorf("%s lists more than %d columns", field, maxViewColumns)
+		}
+		seen := map[string]bool{}
+		for _, name := range names {
+			if name == "" {
+				return fmt.Errorf("%s has an empty column name", field)
+			}
+			if seen[name] {
+				return fmt.Errorf("%s lists %s twice", field, name)
+			}
+			seen[name] = true
+		}
+	}
+	if len(r.Widths) > maxViewColumns {
+		return fmt.Errorf("column_widths has more than %d columns", maxViewColumns)
+	}
+	for name, width := range r.Widths {
+		if name == "" || width <= 0 || width > maxViewColumnWidth {
+			return fmt.Errorf("column_widths: width of %q must be between 1 and %d", name, maxViewColumnWidth)
+		}
+	}
+	return nil
+}
+
+// viewPrefsKey is where principal's preferences for a view are stored.
+// Views are "table/<schema>.<table>" or "saved-query/<id>".
+func viewPrefsKey(principal, view string) string {
+	return viewPrefsPrefix + url.PathEscape(principal) + "/" + view
+}
+
+func tableView(schema, table string) string {
+	return "table/" + schema + "." + table
+}
+
+func savedQueryView(id string) string {
+	return "saved-query/" + id
+}
+
+// viewPreferences returns the caller's preferences for a view, to send
+// along with its results; nil when there are none or the store can't be
+// read, as results don't depend on them
+func (h *Handler) viewPreferences(c *gin.Context, view string) *ViewPreferences {
+	var prefs ViewPreferences
+	if err := h.meta.Get(viewPrefsKey(auth.Principal(c), view), &prefs); err != nil {
+		return nil
+	}
+	return &prefs
+}
+
+// GetTableViewPreferences returns the caller's layout of a table's preview
+func (h *Handler) GetTableViewPreferences(c *gin.Context) {
+	if view, ok := h.tableViewParam(c); ok {
+		h.getViewPreferences(c, view)
+	}
+}
+
+// SetTableViewPreferences replaces the caller's layout of a table's preview
+func (h *Handler) SetTableViewPreferences(c *gin.Context) {
+	if view, ok := h.tableViewParam(c); ok {
+		h.setViewPreferences(c, view)
+	}
+}
+
+// ResetTableViewPreferences deletes the caller's layout of a table's preview
+func (h *Handler) ResetTableViewPreferences(c *gin.Context) {
+	if view, ok := h.tableViewParam(c); ok {
+		h.resetViewPreferences(c, view)
+	}
+}
+
+// GetSavedQueryViewPreferences returns the caller's layout of a saved
+// query's results
+func (h *Handler) GetSavedQueryViewPreferences(c *gin.Context) {
+	if q, ok := h.loadSavedQuery(c); ok {
+		h.getViewPreferences(c, savedQueryView(q.ID))
+	}
+}
+
+// SetSavedQueryViewPreferences replaces the caller's layout of a saved
+// query's results
+func (h *Handler) SetSavedQueryViewPreferences(c *gin.Context) {
+	if q, ok := h.loadSavedQuery(c); ok {
+		h.setViewPreferences(c, savedQueryView(q.ID))
+	}
+}
+
+// ResetSavedQueryViewPreferences deletes the caller's layout of a saved
+// query's results
+func (h *Handler) ResetSavedQueryViewPreferences(c *gin.Context) {
+	if q, ok := h.loadSavedQuery(c); ok {
+		h.resetViewPreferences(c, savedQueryView(q.ID))
+	}
+}
+
+// tableViewParam resolves the table of the request, which must exist and
+// which the caller must be allowed to read
+func (h *Handler) tableViewParam(c *gin.Context) (string, bool) {
+	schema, ok := h.schemaParam(c)
+	if !ok {
+		return "", false
+	}
+	table := c.Param("name")
+	if !h.requireTable(c, schema, table) {
+		return "", false
+	}
+	columns, err := h.dialect.ListColumns(h.db, schema, table)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return "", false
+	}
+	if len(columns) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Table not found"})
+		return "", false
+	}
+	return tableView(schema, table), true
+}
+
+func (h *Handler) getViewPreferences(c *gin.Context, view string) {
+	var prefs ViewPreferences
+	if err := h.meta.Get(viewPrefsKey(auth.Principal(c), view), &prefs); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "No view preferences saved"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	c.JSON(http.StatusOK, prefs)
+}
+
+func (h *Handler) setViewPreferences(c *gin.Context, view string) {
+	var req ViewPreferencesRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		return
+	}
+	if err := req.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	prefs := ViewPreferences{
+		ColumnOrder: orEmpty(req.ColumnOrder),
+		Hidden:      orEmpty(req.Hidden),
+		Widths:      req.Widths,
+		Pinned:      orEmpty(req.Pinned),
+		UpdatedAt:   time.Now().UTC(),
+	}
+	if prefs.Widths == nil {
+		prefs.Widths = map[string]int{}
+	}
+	if err := h.meta.Put(viewPrefsKey(auth.Principal(c), view), prefs); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, prefs)
+}
+
+func (h *Handler) resetViewPreferences(c *gin.Context, view string) {
+	if err := h.meta.Delete(viewPrefsKey(auth.Principal(c), view)); err != nil && !errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func orEmpty(names []string) []string {
+	if names == nil {
+		return []string{}
+	}
+	return names
+}
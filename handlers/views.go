@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"sql-engine/auth"
+	"sql-engine/lineage"
+	"sql-engine/rbac"
+
+	"github.com/gin-gonic/gin"
+)
+
+const viewRefreshPrefix = "view-refresh/"
+
+// ViewInfo describes a regular or materialized view
+type ViewInfo struct {
+	Schema       string           `json:"schema"`
+	Name         string           `json:"name"`
+	Materialized bool             `json:"materialized"`
+	Definition   string           `json:"definition"`
+	Dependencies []ViewDependency `json:"dependencies"`
+	// Populated is false for materialized views created WITH NO DATA
+	Populated   *bool        `json:"populated,omitempty"`
+	LastRefresh *ViewRefresh `json:"last_refresh,omitempty"`
+}
+
+// ViewDependency is a relation a view reads
+type ViewDependency struct {
+	Schema string `json:"schema"`
+	Name   string `json:"name"`
+}
+
+// ViewRefresh records a materialized view refresh. Databases don't track
+// refresh times, so only refreshes made through the API are known.
+type ViewRefresh struct {
+	RefreshedAt time.Time `json:"refreshed_at"`
+	Duration    string    `json:"duration"`
+	By          string    `json:"by,omitempty"`
+}
+
+// GetViews lists the views of a schema with their definitions
+func (h *Handler) GetViews(c *gin.Context) {
+	schema, ok := h.schemaParam(c)
+	if !ok {
+		return
+	}
+	views, err := h.dialect.ListViews(h.db, schema)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	access := rbac.FromContext(c.Request.Context())
+	out := []ViewInfo{}
+	for _, v := range views {
+		if access != nil && !access.CanTable(h.grantSchema(schema), v.Name) {
+			continue
+		}
+		if v.Materialized {
+			var r ViewRefresh
+			if err := h.meta.Get(viewRefreshPrefix+schema+"."+v.Name, &r); err == nil {
+				v.LastRefresh = &r
+			}
+		}
+		out = append(out, v)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"schema": schema, "views": out})
+}
+
+// RefreshView refreshes a materialized view, concurrently when
+// ?concurrently=true (which needs a unique index on the view)
+func (h *Handler) RefreshView(c *gin.Context) {
+	schema, ok := h.schemaParam(c)
+	if !ok {
+		return
+	}
+	name := c.Param("name")
+	if !h.requireTable(c, schema, name) {
+		return
+	}
+
+	views, err := h.dialect.ListViews(h.db, schema)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	var view *ViewInfo
+	for i := range views {
+		if views[i].Name == name {
+			view = &views[i]
+		}
+	}
+	if view == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "View not found"})
+		return
+	}
+	if !view.Materialized {
+		c.JSON(http.StatusBadRequest, gin.H{"error": name + " is not a materialized view"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	if h.cfg.Query.MaterializeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.cfg.Query.MaterializeTimeout)
+		defer cancel()
+	}
+	release, err := h.acquireStatement(ctx)
+	if err != nil {
+		respondQueryError(c, err)
+		return
+	}
+	defer release()
+
+	var inputs, outputs []lineage.Dataset
+	if h.lineage.Enabled() {
+		for _, dep := range view.Dependencies {
+			inputs = append(inputs, h.lineage.Dataset(dep.Schema, dep.Name))
+		}
+		outputs = append(outputs, h.lineage.Dataset(schema, name))
+	}
+	run := h.lineage.StartRun("refresh."+schema+"."+name, "", inputs, outputs)
+
+	start := time.Now()
+	err = h.dialect.RefreshMaterializedView(ctx, h.db, schema, name, c.Query("concurrently") == "true")
+	run.Finish(err)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Refresh failed: " + err.Error()})
+		return
+	}
+
+	refresh := ViewRefresh{RefreshedAt: start, Duration: time.Since(start).String(), By: auth.Principal(c)}
+	if err := h.meta.Put(viewRefreshPrefix+schema+"."+name, refresh); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"schema": schema, "view": name, "refresh": refresh})
+}
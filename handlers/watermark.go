@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"sql-engine/auth"
+	"sql-engine/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+const watermarkPrefix = "watermark/"
+
+// Watermark records who downloaded an export and when. Its ID ends the
+// file, so that a leaked extract leads back to the download.
+type Watermark struct {
+	ID        string `json:"id"`
+	Principal string `json:"principal"`
+	ClientIP  string `json:"client_ip,omitempty"`
+	Schema    string `json:"schema"`
+	Table     string `json:"table"`
+	// Export is the saved export downloaded, if not a direct export
+	Export    string    `json:"export,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// watermark records a watermark for the caller's download of a CSV export,
+// or returns nil when query.watermark is off
+func (h *Handler) watermark(c *gin.Context, schema, table, export string) (*Watermark, error) {
+	if !h.cfg.Query.Watermark {
+		return nil, nil
+	}
+	principal := auth.Principal(c)
+	if principal == "" {
+		principal = "anonymous"
+	}
+	wm := &Watermark{
+		ID:        newID(),
+		Principal: principal,
+		ClientIP:  c.ClientIP(),
+		Schema:    schema,
+		Table:     table,
+		Export:    export,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := h.meta.Put(watermarkPrefix+wm.ID, wm); err != nil {
+		return nil, err
+	}
+	c.Header("X-Watermark", wm.ID)
+	return wm, nil
+}
+
+// footer is the last row of a watermarked CSV file: a single field, which
+// spreadsheets and CSV readers take as a short row rather than an error
+func (wm *Watermark) footer() []byte {
+	text := fmt.Sprintf("# watermark %s: exported by %s at %s", wm.ID, wm.Principal, wm.CreatedAt.Format(time.RFC3339))
+	if wm.Export != "" {
+		text += " from shared export " + wm.Export
+	}
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	w.Write([]string{text})
+	w.Flush()
+	return b.Bytes()
+}
+
+// GetWatermark returns the download a watermark ID found in a file was
+// issued for
+func (h *Handler) GetWatermark(c *gin.Context) {
+	var wm Watermark
+	err := h.meta.Get(watermarkPrefix+c.Param("id"), &wm)
+	if errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Watermark not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, wm)
+}
@@ -0,0 +1,304 @@
+package handlers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"sql-engine/auth"
+	"sql-engine/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	webhookPrefix = "webhook/"
+	// maxWebhookPayload caps the body of an inbound webhook call
+	maxWebhookPayload = 1 << 20
+)
+
+// Webhook lets an external system run a saved query by POSTing to a signed
+// URL, binding the query's variables to values taken from the payload
+type Webhook struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	SavedQuery string `json:"saved_query"`
+	// Pipeline also runs the saved queries downstream of it, with their
+	// variables' defaults
+	Pipeline bool `json:"pipeline,omitempty"`
+	// Variables maps variable names to the path of their value in the JSON
+	// payload: object keys separated by dots, with array indexes, e.g.
+	// "data.items.0.sku"
+	Variables map[string]string `json:"variables,omitempty"`
+	// Secret signs the URL; it is never returned
+	Secret       string           `json:"secret,omitempty"`
+	CreatedBy    string           `json:"created_by,omitempty"`
+	CreatedAt    time.Time        `json:"created_at"`
+	LastDelivery *WebhookDelivery `json:"last_delivery,omitempty"`
+}
+
+// WebhookDelivery is an accepted call of a webhook. The outcome of the run
+// is the saved query's last test run, triggered by webhook:<id>.
+type WebhookDelivery struct {
+	ReceivedAt time.Time      `json:"received_at"`
+	Variables  map[string]any `json:"variables,omitempty"`
+}
+
+// WebhookRequest is the writable part of a webhook
+type WebhookRequest struct {
+	Name       string            `json:"name"`
+	SavedQuery string            `json:"saved_query"`
+	Pipeline   bool              `json:"pipeline"`
+	Variables  map[string]string `json:"variables"`
+}
+
+// signature signs the webhook's ID with its secret, as carried by its URL
+func (w Webhook) signature() string {
+	mac := hmac.New(sha256.New, []byte(w.Secret))
+	mac.Write([]byte(w.ID))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// url returns the path external systems POST to
+func (w Webhook) url() string {
+	return "/hooks/" + w.ID + "/" + w.signature()
+}
+
+func newWebhookSecret() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (h *Handler) ListWebhooks(c *gin.Context) {
+	keys, err := h.meta.List(webhookPrefix)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	hooks := []Webhook{}
+	for _, key := range keys {
+		var w Webhook
+		if err := h.meta.Get(key, &w); err != nil {
+			continue
+		}
+		w.Secret = ""
+		hooks = append(hooks, w)
+	}
+	c.JSON(http.StatusOK, gin.H{"webhooks": hooks})
+}
+
+// CreateWebhook creates a webhook for a saved query and returns it with its
+// signed URL, which is shown only here and when rotated
+func (h *Handler) CreateWebhook(c *gin.Context) {
+	var req WebhookRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+		return
+	}
+	if strings.TrimSpace(req.Name) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+		return
+	}
+	var q SavedQuery
+	if err := h.meta.Get(savedQueryPrefix+req.SavedQuery, &q); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Saved query " + req.SavedQuery + " not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	for name, path := range req.Variables {
+		if !slices.ContainsFunc(q.Variables, func(v QueryVariable) bool { return v.Name == name }) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "variables: " + name + " is not a variable of the saved query"})
+			return
+		}
+		if strings.TrimSpace(path) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "variables: the path of " + name + " is empty"})
+			return
+		}
+	}
+
+	w := Webhook{
+		ID:         newID(),
+		Name:       req.Name,
+		SavedQuery: q.ID,
+		Pipeline:   req.Pipeline,
+		Variables:  req.Variables,
+		Secret:     newWebhookSecret(),
+		CreatedBy:  auth.Principal(c),
+		CreatedAt:  time.Now(),
+	}
+	if err := h.meta.Put(webhookPrefix+w.ID, w); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	url := w.url()
+	w.Secret = ""
+	c.JSON(http.StatusCreated, gin.H{"webhook": w, "url": url})
+}
+
+// RotateWebhook gives a webhook a new secret, invalidating its URL, and
+// returns the new one
+func (h *Handler) RotateWebhook(c *gin.Context) {
+	var w Webhook
+	if err := h.meta.Get(webhookPrefix+c.Param("id"), &w); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	w.Secret = newWebhookSecret()
+	if err := h.meta.Put(webhookPrefix+w.ID, w); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	url := w.url()
+	w.Secret = ""
+	c.JSON(http.StatusOK, gin.H{"webhook": w, "url": url})
+}
+
+func (h *Handler) DeleteWebhook(c *gin.Context) {
+	key := webhookPrefix + c.Param("id")
+	if err := h.meta.Get(key, &Webhook{}); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	if err := h.meta.Delete(key); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// TriggerWebhook is called by external systems, authenticated by the
+// signature in the URL rather than credentials. It binds the saved query's
+// variables from the JSON body, answers 202 once they are valid and runs
+// the query, or its pipeline, in the background. A webhook runs once at a
+// time per replica; calls meanwhile get 429.
+func (h *Handler) TriggerWebhook(c *gin.Context) {
+	var w Webhook
+	err := h.meta.Get(webhookPrefix+c.Param("id"), &w)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	// Unknown webhooks and bad signatures look alike
+	if err != nil || !hmac.Equal([]byte(c.Param("signature")), []byte(w.signature())) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxWebhookPayload))
+	if err != nil {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Payload is larger than " + strconv.Itoa(maxWebhookPayload) + " bytes"})
+		return
+	}
+	var payload any
+	if len(strings.TrimSpace(string(body))) > 0 {
+		if err := json.Unmarshal(body, &payload); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON"})
+			return
+		}
+	}
+	values := map[string]any{}
+	for name, path := range w.Variables {
+		if v, ok := payloadValue(payload, path); ok {
+			values[name] = v
+		}
+	}
+
+	var q SavedQuery
+	if err := h.meta.Get(savedQueryPrefix+w.SavedQuery, &q); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Saved query of the webhook not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+	if _, _, err := bindQueryVariables(q, values); err != nil {
+		respondQueryError(c, err)
+		return
+	}
+
+	h.hooksMu.Lock()
+	running := h.hooks[w.ID]
+	h.hooks[w.ID] = true
+	h.hooksMu.Unlock()
+	if running {
+		c.Header("Retry-After", "10")
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Webhook is already running"})
+		return
+	}
+
+	delivery := &WebhookDelivery{ReceivedAt: time.Now(), Variables: values}
+	w.LastDelivery = delivery
+	if err := h.meta.Put(webhookPrefix+w.ID, w); err != nil {
+		slog.ErrorContext(c.Request.Context(), "Failed to record webhook delivery", "error", err)
+	}
+	h.goBackground(c.Request.Context(), func(ctx context.Context) {
+		defer func() {
+			h.hooksMu.Lock()
+			delete(h.hooks, w.ID)
+			h.hooksMu.Unlock()
+		}()
+		if w.Pipeline {
+			h.runPipeline(ctx, q, "webhook:"+w.ID, values)
+		} else if owned, err := h.asOwner(ctx, q); err != nil {
+			h.skipSavedQuery(q, "webhook:"+w.ID, "Owner access: "+err.Error())
+		} else {
+			h.testSavedQuery(owned, q, "webhook:"+w.ID, values)
+		}
+	})
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"webhook":     w.ID,
+		"saved_query": q.ID,
+		"pipeline":    w.Pipeline,
+		"delivery":    delivery,
+	})
+}
+
+// payloadValue reads the value at a dotted path out of a decoded JSON
+// payload; digit-only elements index arrays
+func payloadValue(payload any, path string) (any, bool) {
+	v := payload
+	for _, key := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]any:
+			child, ok := node[key]
+			if !ok {
+				return nil, false
+			}
+			v = child
+		case []any:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, false
+			}
+			v = node[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+	"path"
+
+	"github.com/gin-gonic/gin"
+)
+
+// webUI is the SQL editor served at /: a schema tree, a query editor, a
+// results grid and exports, all calling the API from the browser
+//
+//go:embed webui
+var webUI embed.FS
+
+// webUIAssets serves the editor's scripts and styles under /ui/
+var webUIAssets = func() http.FileSystem {
+	sub, err := fs.Sub(webUI, "webui")
+	if err != nil {
+		panic(err)
+	}
+	return http.FS(sub)
+}()
+
+// GetWebUI serves the SQL editor page
+func (h *Handler) GetWebUI(c *gin.Context) {
+	page, err := webUI.ReadFile("webui/index.html")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Data(http.StatusOK, "text/html; charset=utf-8", page)
+}
+
+// GetWebUIAsset serves a script or stylesheet of the SQL editor
+func (h *Handler) GetWebUIAsset(c *gin.Context) {
+	file := c.Param("file")
+	if path.Ext(file) == "" {
+		// Rather than a directory listing
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+		return
+	}
+	c.FileFromFS(file, webUIAssets)
+}
@@ -0,0 +1,332 @@
+// SQL editor served at / by the engine itself. It only talks to the public
+// API, on the same origin, with the API key kept in localStorage.
+'use strict';
+
+const $ = (id) => document.getElementById(id);
+
+const state = {
+  defaultSchema: '',
+  schema: '',
+  tables: [],
+  // last results, for the export buttons
+  columns: [],
+  rows: [],
+};
+
+// api calls an endpoint and returns its JSON answer, throwing with the
+// answer's error message when the request fails
+async function api(path, options = {}) {
+  const response = await fetch(path, withKey(options));
+  const data = await response.json().catch(() => ({}));
+  if (!response.ok) {
+    if (response.status === 401) {
+      $('apiKey').focus();
+    }
+    throw new Error(data.error || response.status + ' ' + response.statusText);
+  }
+  return data;
+}
+
+function withKey(options) {
+  const headers = Object.assign({}, options.headers);
+  const key = localStorage.getItem('sqlengine.apiKey');
+  if (key) {
+    headers['X-API-Key'] = key;
+  }
+  return Object.assign({}, options, { headers });
+}
+
+function el(tag, className, text) {
+  const node = document.createElement(tag);
+  if (className) {
+    node.className = className;
+  }
+  if (text !== undefined) {
+    node.textContent = text;
+  }
+  return node;
+}
+
+// quoteIdent quotes names that aren't plain lower-case identifiers; both
+// PostgreSQL and SQLite take double quotes
+function quoteIdent(name) {
+  return /^[a-z_][a-z0-9_]*$/.test(name) ? name : '"' + name.replace(/"/g, '""') + '"';
+}
+
+function qualifiedName(table) {
+  if (state.schema === state.defaultSchema) {
+    return quoteIdent(table);
+  }
+  return quoteIdent(state.schema) + '.' + quoteIdent(table);
+}
+
+// Schema tree
+
+async function loadSchemas() {
+  const select = $('schemaSelect');
+  try {
+    const data = await api('schemas');
+    state.defaultSchema = data.default;
+    select.replaceChildren(...data.schemas.map((name) => el('option', '', name)));
+    select.value = state.schema || data.default;
+  } catch (error) {
+    showTreeMessage('Failed to load schemas: ' + error.message);
+    return;
+  }
+  await loadTree();
+}
+
+async function loadTree() {
+  state.schema = $('schemaSelect').value;
+  showTreeMessage('Loading...');
+  try {
+    const data = await api('schema?schema=' + encodeURIComponent(state.schema));
+    state.tables = data.schema || [];
+    renderTree();
+  } catch (error) {
+    showTreeMessage('Failed to load tables: ' + error.message);
+  }
+}
+
+function showTreeMessage(text) {
+  const item = el('li', 'tree-message', text);
+  $('schemaTree').replaceChildren(item);
+}
+
+function renderTree() {
+  const filter = $('treeFilter').value.trim().toLowerCase();
+  const tables = state.tables.filter((t) => t.name.toLowerCase().includes(filter));
+  if (tables.length === 0) {
+    showTreeMessage(state.tables.length ? 'No table matches' : 'No tables');
+    return;
+  }
+  $('schemaTree').replaceChildren(...tables.map(tableItem));
+}
+
+function tableItem(table) {
+  const item = el('li');
+  const node = el('div', 'tree-node');
+  const toggle = el('span', 'toggle', '▸');
+  const query = el('span', 'link', 'query');
+  const exportLink = el('span', 'link', 'export');
+  node.append(toggle, el('span', 'name', table.name), query, exportLink);
+
+  const columns = el('ul', 'tree-children');
+  columns.hidden = true;
+  for (const column of table.columns || []) {
+    const child = el('div', 'tree-node');
+    child.title = 'Insert the column name';
+    child.append(el('span', 'name', column.name), el('span', 'type', column.data_type));
+    child.addEventListener('click', () => insertText(quoteIdent(column.name)));
+    const li = el('li');
+    li.append(child);
+    columns.append(li);
+  }
+
+  node.addEventListener('click', () => {
+    columns.hidden = !columns.hidden;
+    toggle.textContent = columns.hidden ? '▸' : '▾';
+  });
+  query.addEventListener('click', (event) => {
+    event.stopPropagation();
+    $('sqlEditor').value = 'SELECT * FROM ' + qualifiedName(table.name) + ' LIMIT 100';
+    runQuery();
+  });
+  exportLink.addEventListener('click', (event) => {
+    event.stopPropagation();
+    exportTable(table.name);
+  });
+
+  item.append(node, columns);
+  return item;
+}
+
+// insertText puts text at the editor's cursor
+function insertText(text) {
+  const editor = $('sqlEditor');
+  editor.setRangeText(text, editor.selectionStart, editor.selectionEnd, 'end');
+  editor.focus();
+}
+
+// Editor
+
+async function formatSQL() {
+  const editor = $('sqlEditor');
+  if (!editor.value.trim()) {
+    return;
+  }
+  try {
+    const data = await api('format', {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ sql: editor.value }),
+    });
+    editor.value = data.sql;
+  } catch (error) {
+    showError(error.message);
+  }
+}
+
+async function runQuery() {
+  const editor = $('sqlEditor');
+  const sql = (editor.value.substring(editor.selectionStart, editor.selectionEnd) || editor.value).trim();
+  if (!sql) {
+    return;
+  }
+  setInfo('Running...', '');
+  setExportable(false);
+  const started = performance.now();
+  try {
+    const data = await api('run-query', {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ sql }),
+    });
+    const elapsed = Math.round(performance.now() - started);
+    state.columns = data.columns || [];
+    state.rows = data.rows || [];
+    let info = state.rows.length + (state.rows.length === 1 ? ' row' : ' rows') + ' in ' + elapsed + ' ms';
+    if (data.suppressed_groups) {
+      info += ', ' + data.suppressed_groups + ' small groups left out';
+    }
+    setInfo(info, 'ok');
+    renderResults();
+    setExportable(state.columns.length > 0);
+  } catch (error) {
+    setInfo('Query failed', 'failed');
+    showError(error.message);
+  }
+}
+
+// Results
+
+function setInfo(text, className) {
+  const info = $('resultsInfo');
+  info.textContent = text;
+  info.className = 'results-info ' + className;
+}
+
+function showError(message) {
+  $('resultsContent').replaceChildren(el('div', 'error-message', message));
+}
+
+function renderResults() {
+  if (state.rows.length === 0) {
+    $('resultsContent').replaceChildren(el('div', 'empty-state', 'The query returned no rows'));
+    return;
+  }
+  const table = el('table', 'data-table');
+  const head = el('tr');
+  head.append(...state.columns.map((name) => el('th', '', name)));
+  table.append(el('thead'), el('tbody'));
+  table.tHead.append(head);
+  for (const row of state.rows) {
+    const tr = el('tr');
+    for (const name of state.columns) {
+      tr.append(cell(row[name]));
+    }
+    table.tBodies[0].append(tr);
+  }
+  $('resultsContent').replaceChildren(table);
+}
+
+function cell(value) {
+  if (value === null || value === undefined) {
+    return el('td', 'null', 'NULL');
+  }
+  if (typeof value === 'number') {
+    return el('td', 'number', String(value));
+  }
+  const text = typeof value === 'object' ? JSON.stringify(value) : String(value);
+  const td = el('td', '', text);
+  td.title = text;
+  return td;
+}
+
+// Exports
+
+function setExportable(enabled) {
+  $('exportCSV').disabled = !enabled;
+  $('exportJSON').disabled = !enabled;
+}
+
+// csvField formats a value like the table export does: NULL as an empty
+// field and the empty string quoted
+function csvField(value) {
+  if (value === null || value === undefined) {
+    return '';
+  }
+  const text = typeof value === 'object' ? JSON.stringify(value) : String(value);
+  return text === '' || /[",\r\n]/.test(text) ? '"' + text.replace(/"/g, '""') + '"' : text;
+}
+
+function exportCSV() {
+  const lines = [state.columns.map(csvField).join(',')];
+  for (const row of state.rows) {
+    lines.push(state.columns.map((name) => csvField(row[name])).join(','));
+  }
+  download(new Blob([lines.join('\r\n') + '\r\n'], { type: 'text/csv' }), 'results.csv');
+}
+
+function exportJSON() {
+  const body = JSON.stringify({ columns: state.columns, rows: state.rows }, null, 2);
+  download(new Blob([body], { type: 'application/json' }), 'results.json');
+}
+
+// exportTable downloads a whole table through GET /table/:name/export,
+// which streams every row rather than the first page
+async function exportTable(name) {
+  const path = 'table/' + encodeURIComponent(name) + '/export?schema=' + encodeURIComponent(state.schema);
+  try {
+    const response = await fetch(path, withKey({}));
+    if (!response.ok) {
+      const data = await response.json().catch(() => ({}));
+      throw new Error(data.error || response.status + ' ' + response.statusText);
+    }
+    download(await response.blob(), name + '.csv');
+  } catch (error) {
+    setInfo('Export failed', 'failed');
+    showError(error.message);
+  }
+}
+
+function download(blob, filename) {
+  const link = document.createElement('a');
+  link.href = URL.createObjectURL(blob);
+  link.download = filename;
+  link.click();
+  setTimeout(() => URL.revokeObjectURL(link.href), 0);
+}
+
+document.addEventListener('DOMContentLoaded', () => {
+  const key = $('apiKey');
+  key.value = localStorage.getItem('sqlengine.apiKey') || '';
+  key.addEventListener('change', () => {
+    if (key.value) {
+      localStorage.setItem('sqlengine.apiKey', key.value);
+    } else {
+      localStorage.removeItem('sqlengine.apiKey');
+    }
+    loadSchemas();
+  });
+
+  $('schemaSelect').addEventListener('change', loadTree);
+  $('refreshTree').addEventListener('click', loadSchemas);
+  $('treeFilter').addEventListener('input', renderTree);
+  $('clearEditor').addEventListener('click', () => {
+    $('sqlEditor').value = '';
+    $('sqlEditor').focus();
+  });
+  $('formatSQL').addEventListener('click', formatSQL);
+  $('runQuery').addEventListener('click', runQuery);
+  $('exportCSV').addEventListener('click', exportCSV);
+  $('exportJSON').addEventListener('click', exportJSON);
+  $('sqlEditor').addEventListener('keydown', (event) => {
+    if ((event.ctrlKey || event.metaKey) && event.key === 'Enter') {
+      event.preventDefault();
+      runQuery();
+    }
+  });
+
+  loadSchemas();
+});
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"time"
+
+	"sql-engine/auth"
+	"sql-engine/config"
+	"sql-engine/rbac"
+
+	"github.com/gin-gonic/gin"
+)
+
+type workspaceKey struct{}
+
+// workspaceFrom returns the workspace whose defaults apply to a request, or
+// nil
+func workspaceFrom(ctx context.Context) *config.WorkspaceConfig {
+	ws, _ := ctx.Value(workspaceKey{}).(*config.WorkspaceConfig)
+	return ws
+}
+
+// workspacesOf lists the workspaces a caller belongs to, in config order
+func (h *Handler) workspacesOf(c *gin.Context) []*config.WorkspaceConfig {
+	principal := auth.Principal(c)
+	var roles []string
+	if access := rbac.FromContext(c.Request.Context()); access != nil {
+		roles = access.Roles
+	}
+	var out []*config.WorkspaceConfig
+	for i := range h.cfg.Workspaces {
+		ws := &h.cfg.Workspaces[i]
+		if (principal != "" && slices.Contains(ws.Members, principal)) ||
+			slices.ContainsFunc(ws.Roles, func(r string) bool { return slices.Contains(roles, r) }) {
+			out = append(out, ws)
+		}
+	}
+	return out
+}
+
+// ApplyWorkspace attaches the caller's workspace to the request context so
+// that its defaults apply. X-Workspace picks among several; naming one the
+// caller is not a member of is refused. It must run after RBAC.
+func (h *Handler) ApplyWorkspace() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if len(h.cfg.Workspaces) == 0 {
+			c.Next()
+			return
+		}
+		member := h.workspacesOf(c)
+		var ws *config.WorkspaceConfig
+		if name := c.GetHeader("X-Workspace"); name != "" {
+			i := slices.IndexFunc(member, func(ws *config.WorkspaceConfig) bool { return ws.Name == name })
+			if i < 0 {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Not a member of workspace " + name})
+				return
+			}
+			ws = member[i]
+		} else if len(member) > 0 {
+			ws = member[0]
+		}
+		if ws != nil {
+			c.Header("X-Workspace", ws.Name)
+			c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), workspaceKey{}, ws))
+		}
+		c.Next()
+	}
+}
+
+// maxRows is the row limit of a request: its workspace's, or the configured
+// one
+func (h *Handler) maxRows(ctx context.Context) int {
+	if ws := workspaceFrom(ctx); ws != nil && ws.MaxRows > 0 {
+		return ws.MaxRows
+	}
+	return h.cfg.Query.MaxRows
+}
+
+// queryTimeout is the statement timeout of a request: its workspace's, or
+// the configured one, capped for low-priority requests
+func (h *Handler) queryTimeout(ctx context.Context) time.Duration {
+	timeout := h.cfg.Query.Timeout
+	if ws := workspaceFrom(ctx); ws != nil && ws.Timeout > 0 {
+		timeout = ws.Timeout
+	}
+	if low := h.cfg.Database.LowPriority.StatementTimeout; h.lowDB != nil && isLowPriority(ctx) && low > 0 && (timeout == 0 || low < timeout) {
+		return low
+	}
+	return timeout
+}
+
+// GetWorkspace returns the caller's current workspace with the settings in
+// effect, and the other workspaces it may switch to with X-Workspace
+func (h *Handler) GetWorkspace(c *gin.Context) {
+	ctx := c.Request.Context()
+	names := []string{}
+	for _, ws := range h.workspacesOf(c) {
+		names = append(names, ws.Name)
+	}
+	settings := gin.H{
+		"schema":   h.dialect.DefaultSchema(),
+		"max_rows": h.maxRows(ctx),
+		"timeout":  h.queryTimeout(ctx).String(),
+		"timezone": "",
+	}
+	name := ""
+	if ws := workspaceFrom(ctx); ws != nil {
+		name = ws.Name
+		if ws.Schema != "" {
+			settings["schema"] = ws.Schema
+		}
+		settings["timezone"] = ws.Timezone
+	}
+	c.JSON(http.StatusOK, gin.H{"workspace": name, "workspaces": names, "settings": settings})
+}
@@ -0,0 +1,274 @@
+package i18n
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"sql-engine/config"
+
+	"github.com/gin-gonic/gin"
+	"github.com/goccy/go-yaml"
+)
+
+//go:embed locales/*.yaml
+var builtin embed.FS
+
+// placeholder matches the {name} parts of catalog messages
+var placeholder = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// rule translates the messages matching an English pattern
+type rule struct {
+	pattern *regexp.Regexp
+	names   []string
+	text    string
+	// literal is the length of the pattern without placeholders; longer
+	// patterns are more specific and tried first
+	literal int
+}
+
+// Catalog holds the translations of every locale
+type Catalog struct {
+	locales       map[string][]rule
+	defaultLocale string
+}
+
+// New loads the built-in catalogs and those of cfg.Dir
+func New(cfg config.I18nConfig) (*Catalog, error) {
+	c := &Catalog{locales: map[string][]rule{}, defaultLocale: strings.ToLower(cfg.DefaultLocale)}
+	messages := map[string]map[string]string{}
+	load := func(name string, data []byte) error {
+		locale := strings.ToLower(strings.TrimSuffix(filepath.Base(name), ".yaml"))
+		var m map[string]string
+		if err := yaml.Unmarshal(data, &m); err != nil {
+			return fmt.Errorf("catalog %s: %w", name, err)
+		}
+		if messages[locale] == nil {
+			messages[locale] = map[string]string{}
+		}
+		for k, v := range m {
+			messages[locale][k] = v
+		}
+		return nil
+	}
+
+	files, _ := builtin.ReadDir("locales")
+	for _, f := range files {
+		data, err := builtin.ReadFile("locales/" + f.Name())
+		if err != nil {
+			return nil, err
+		}
+		if err := load(f.Name(), data); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Dir != "" {
+		paths, err := filepath.Glob(filepath.Join(cfg.Dir, "*.yaml"))
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range paths {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			if err := load(path, data); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for locale, m := range messages {
+		for message, text := range m {
+			r, err := compileRule(message, text)
+			if err != nil {
+				return nil, fmt.Errorf("catalog %s: %w", locale, err)
+			}
+			c.locales[locale] = append(c.locales[locale], r)
+		}
+		sort.Slice(c.locales[locale], func(i, j int) bool {
+			a, b := c.locales[locale][i], c.locales[locale][j]
+			if a.literal != b.literal {
+				return a.literal > b.literal
+			}
+			return a.pattern.String() < b.pattern.String()
+		})
+	}
+	if c.defaultLocale != "" && c.defaultLocale != "en" && c.locales[c.defaultLocale] == nil {
+		return nil, fmt.Errorf("no catalog for default locale %s", cfg.DefaultLocale)
+	}
+	return c, nil
+}
+
+// compileRule turns a message with {name} placeholders into a pattern
+// matching the whole message
+func compileRule(message, text string) (rule, error) {
+	r := rule{text: text}
+	var expr strings.Builder
+	expr.WriteString("^")
+	last := 0
+	for _, m := range placeholder.FindAllStringSubmatchIndex(message, -1) {
+		expr.WriteString(regexp.QuoteMeta(message[last:m[0]]))
+		expr.WriteString("(.+?)")
+		r.literal += m[0] - last
+		r.names = append(r.names, message[m[2]:m[3]])
+		last = m[1]
+	}
+	expr.WriteString(regexp.QuoteMeta(message[last:]))
+	expr.WriteString("$")
+	r.literal += len(message) - last
+	for _, name := range placeholder.FindAllStringSubmatch(text, -1) {
+		if !strings.Contains(message, name[0]) {
+			return r, fmt.Errorf("%q uses %s, which %q lacks", text, name[0], message)
+		}
+	}
+	r.pattern = regexp.MustCompile(expr.String())
+	return r, nil
+}
+
+// Translate returns message in locale, or unchanged when the locale has no
+// matching entry. Placeholder values are translated too, so that messages
+// wrapping others, such as "row 2: ...", need one entry each.
+func (c *Catalog) Translate(locale, message string) string {
+	return c.translate(c.locales[locale], message, 2)
+}
+
+func (c *Catalog) translate(rules []rule, message string, depth int) string {
+	for _, r := range rules {
+		m := r.pattern.FindStringSubmatch(message)
+		if m == nil {
+			continue
+		}
+		values := map[string]string{}
+		for i, name := range r.names {
+			values[name] = m[i+1]
+			if depth > 0 {
+				values[name] = c.translate(rules, m[i+1], depth-1)
+			}
+		}
+		return placeholder.ReplaceAllStringFunc(r.text, func(p string) string {
+			return values[p[1:len(p)-1]]
+		})
+	}
+	return message
+}
+
+// Negotiate picks the locale for an Accept-Language header: the preferred
+// language with a catalog, English when it comes first, or the default
+func (c *Catalog) Negotiate(header string) string {
+	type choice struct {
+		tag string
+		q   float64
+	}
+	var choices []choice
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		if tag != "" && q > 0 {
+			choices = append(choices, choice{strings.ToLower(strings.TrimSpace(tag)), q})
+		}
+	}
+	sort.SliceStable(choices, func(i, j int) bool { return choices[i].q > choices[j].q })
+	for _, ch := range choices {
+		if ch.tag == "*" {
+			break
+		}
+		base, _, _ := strings.Cut(ch.tag, "-")
+		for _, tag := range []string{ch.tag, base} {
+			if tag == "en" {
+				return ""
+			}
+			if c.locales[tag] != nil {
+				return tag
+			}
+		}
+	}
+	if c.defaultLocale == "en" {
+		return ""
+	}
+	return c.defaultLocale
+}
+
+// Middleware translates the "error" field of JSON error responses to the
+// locale negotiated for the request
+func (c *Catalog) Middleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		locale := c.Negotiate(ctx.GetHeader("Accept-Language"))
+		if locale == "" {
+			ctx.Next()
+			return
+		}
+		w := &translatingWriter{ResponseWriter: ctx.Writer}
+		ctx.Writer = w
+		ctx.Next()
+		ctx.Writer = w.ResponseWriter
+		if !w.buffering {
+			return
+		}
+		body := w.body.Bytes()
+		var fields map[string]json.RawMessage
+		var message string
+		if json.Unmarshal(body, &fields) == nil && json.Unmarshal(fields["error"], &message) == nil {
+			if translated := c.Translate(locale, message); translated != message {
+				fields["error"], _ = json.Marshal(translated)
+				if out, err := json.Marshal(fields); err == nil {
+					body = out
+					w.Header().Set("Content-Language", locale)
+				}
+			}
+		}
+		w.Header().Del("Content-Length")
+		w.ResponseWriter.Write(body)
+	}
+}
+
+// translatingWriter holds back JSON error responses so their message can
+// be replaced; everything else is written through
+type translatingWriter struct {
+	gin.ResponseWriter
+	decided   bool
+	buffering bool
+	body      bytes.Buffer
+}
+
+func (w *translatingWriter) decide() {
+	if w.decided {
+		return
+	}
+	w.decided = true
+	w.buffering = w.Status() >= http.StatusBadRequest &&
+		strings.HasPrefix(w.Header().Get("Content-Type"), "application/json")
+}
+
+func (w *translatingWriter) Write(b []byte) (int, error) {
+	w.decide()
+	if w.buffering {
+		return w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *translatingWriter) WriteString(s string) (int, error) {
+	w.decide()
+	if w.buffering {
+		return w.body.WriteString(s)
+	}
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *translatingWriter) Written() bool {
+	return w.buffering || w.ResponseWriter.Written()
+}
@@ -0,0 +1,147 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"time"
+
+	"sql-engine/artifact"
+	"sql-engine/config"
+)
+
+// ErrTooLarge is returned when a source exceeds the configured size cap
+var ErrTooLarge = errors.New("source file exceeds the size limit")
+
+// acceptedTypes are the content types a remote source may be served with.
+// Anything else, typically an HTML error or login page, is rejected.
+var acceptedTypes = []string{
+	"text/csv", "application/csv", "text/plain", "application/vnd.ms-excel",
+	"application/json", "application/x-ndjson",
+	"application/octet-stream", "binary/octet-stream",
+}
+
+// Remote is an opened remote source file
+type Remote struct {
+	Body        io.ReadCloser
+	Name        string // last path segment, used to detect the format
+	ContentType string
+}
+
+// Fetcher downloads source files over HTTP(S) or from S3
+type Fetcher struct {
+	cfg    config.ImportsConfig
+	client *http.Client
+}
+
+func NewFetcher(cfg config.ImportsConfig) *Fetcher {
+	return &Fetcher{cfg: cfg, client: &http.Client{Timeout: cfg.FetchTimeout}}
+}
+
+// Fetch opens the source at rawURL, an http, https or s3://bucket/key URL.
+// Reading the body fails with ErrTooLarge past the configured size cap.
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string) (*Remote, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var req *http.Request
+	switch u.Scheme {
+	case "http", "https":
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	case "s3":
+		req, err = f.s3Request(ctx, u.Host, u.Path)
+	default:
+		return nil, fmt.Errorf("unsupported URL scheme %q, use http, https or s3", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("fetching %s: %s", u.Redacted(), resp.Status)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "" && !slices.Contains(acceptedTypes, mediaType(ct)) {
+		resp.Body.Close()
+		return nil, fmt.Errorf("fetching %s: unexpected content type %q", u.Redacted(), ct)
+	}
+	if f.cfg.MaxBytes > 0 && resp.ContentLength > f.cfg.MaxBytes {
+		resp.Body.Close()
+		return nil, ErrTooLarge
+	}
+
+	return &Remote{
+		Body:        LimitReader(resp.Body, f.cfg.MaxBytes),
+		Name:        path.Base(u.Path),
+		ContentType: resp.Header.Get("Content-Type"),
+	}, nil
+}
+
+// s3Request builds a GET for an S3 object, signed when credentials are
+// configured and anonymous otherwise
+func (f *Fetcher) s3Request(ctx context.Context, bucket, key string) (*http.Request, error) {
+	s3 := f.cfg.S3
+	if s3.AccessKeyID == "" {
+		s3.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+		s3.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		s3.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+
+	var target string
+	if s3.Endpoint != "" {
+		// Path-style addressing for S3-compatible stores
+		target = s3.Endpoint + "/" + bucket + artifact.S3Escape(key)
+	} else {
+		target = "https://" + bucket + ".s3." + s3.Region + ".amazonaws.com" + artifact.S3Escape(key)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	if s3.AccessKeyID != "" {
+		artifact.SignV4(req, s3, time.Now())
+	}
+	return req, nil
+}
+
+// limitedReader fails instead of silently truncating at the cap
+type limitedReader struct {
+	io.ReadCloser
+	remaining int64
+}
+
+// LimitReader wraps r so reading more than max bytes fails with ErrTooLarge.
+// A max of 0 or less means no limit.
+func LimitReader(r io.ReadCloser, max int64) io.ReadCloser {
+	if max <= 0 {
+		return r
+	}
+	return &limitedReader{ReadCloser: r, remaining: max}
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, ErrTooLarge
+	}
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.ReadCloser.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n, ErrTooLarge
+	}
+	return n, err
+}
@@ -0,0 +1,353 @@
+package ingest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+)
+
+// Supported source formats
+const (
+	FormatCSV  = "csv"
+	FormatJSON = "json"
+)
+
+// Data is a parsed source file: a header and rows of values in column order
+type Data struct {
+	Columns []string
+	Rows    [][]interface{}
+	// Numbers holds the row number of each of Rows, counted from 1 without
+	// a CSV header, when ParseRows left rows out
+	Numbers []int
+}
+
+// RowError is a row of a source that ParseRows could not read
+type RowError struct {
+	Row int
+	Err error
+}
+
+// Absent is the value ParseRows gives the columns a JSON object leaves out,
+// so that they can be told apart from null
+var Absent = absent{}
+
+type absent struct{}
+
+// DetectFormat picks a format from an explicit choice, the file name and the
+// content type, in that order
+func DetectFormat(explicit, name, contentType string) (string, error) {
+	if explicit != "" {
+		switch explicit {
+		case FormatCSV, FormatJSON:
+			return explicit, nil
+		}
+		return "", fmt.Errorf("unsupported format %q", explicit)
+	}
+	switch strings.ToLower(path.Ext(name)) {
+	case ".csv":
+		return FormatCSV, nil
+	case ".json", ".ndjson", ".jsonl":
+		return FormatJSON, nil
+	}
+	switch mediaType(contentType) {
+	case "text/csv", "application/csv":
+		return FormatCSV, nil
+	case "application/json", "application/x-ndjson":
+		return FormatJSON, nil
+	}
+	return "", errors.New("cannot tell the format, set it to csv or json")
+}
+
+// Parse reads a CSV file with a header row, or JSON holding an array of
+// objects or newline-delimited objects
+func Parse(r io.Reader, format string) (*Data, error) {
+	switch format {
+	case FormatCSV:
+		return parseCSV(r)
+	case FormatJSON:
+		return parseJSON(r)
+	}
+	return nil, fmt.Errorf("unsupported format %q", format)
+}
+
+// ParseRows reads a source like Parse, except that rows it can't read are
+// reported instead of failing the whole file: CSV records with broken
+// quoting or the wrong number of fields, and lines of newline-delimited
+// JSON that aren't objects. Newline-delimited JSON is read line by line.
+func ParseRows(r io.Reader, format string) (*Data, []RowError, error) {
+	switch format {
+	case FormatCSV:
+		return parseCSVRows(r)
+	case FormatJSON:
+		return parseJSONRows(r)
+	}
+	return nil, nil, fmt.Errorf("unsupported format %q", format)
+}
+
+func parseCSV(r io.Reader) (*Data, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, errors.New("file is empty")
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Strip a UTF-8 byte order mark left by spreadsheet exports
+	header[0] = strings.TrimPrefix(header[0], "\uFEFF")
+
+	data := &Data{Columns: header}
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		row := make([]interface{}, len(record))
+		for i, v := range record {
+			if v != "" {
+				row[i] = v
+			}
+		}
+		data.Rows = append(data.Rows, row)
+	}
+	return data, nil
+}
+
+func parseCSVRows(r io.Reader) (*Data, []RowError, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, nil, errors.New("file is empty")
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	header[0] = strings.TrimPrefix(header[0], "\uFEFF")
+
+	data := &Data{Columns: header}
+	var rejected []RowError
+	for n := 1; ; n++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			rejected = append(rejected, RowError{Row: n, Err: parseErr.Err})
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(record) != len(header) {
+			rejected = append(rejected, RowError{Row: n, Err: fmt.Errorf("has %d fields, the header %d", len(record), len(header))})
+			continue
+		}
+		row := make([]interface{}, len(record))
+		for i, v := range record {
+			if v != "" {
+				row[i] = v
+			}
+		}
+		data.Rows = append(data.Rows, row)
+		data.Numbers = append(data.Numbers, n)
+	}
+	return data, rejected, nil
+}
+
+func parseJSON(r io.Reader) (*Data, error) {
+	br := bufio.NewReader(r)
+	first, err := peekNonSpace(br)
+	if err != nil {
+		return nil, errors.New("file is empty")
+	}
+
+	var objects []map[string]interface{}
+	dec := json.NewDecoder(br)
+	dec.UseNumber()
+	if first == '[' {
+		if err := dec.Decode(&objects); err != nil {
+			return nil, err
+		}
+	} else {
+		for {
+			var obj map[string]interface{}
+			if err := dec.Decode(&obj); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			objects = append(objects, obj)
+		}
+	}
+
+	data := &Data{}
+	index := map[string]int{}
+	for _, obj := range objects {
+		for key := range obj {
+			if _, ok := index[key]; !ok {
+				index[key] = len(data.Columns)
+				data.Columns = append(data.Columns, key)
+			}
+		}
+	}
+	// Keys of a JSON object are unordered; keep the header stable
+	slices.Sort(data.Columns)
+	for i, col := range data.Columns {
+		index[col] = i
+	}
+
+	for _, obj := range objects {
+		row := make([]interface{}, len(data.Columns))
+		for key, v := range obj {
+			row[index[key]] = jsonValue(v)
+		}
+		data.Rows = append(data.Rows, row)
+	}
+	return data, nil
+}
+
+func parseJSONRows(r io.Reader) (*Data, []RowError, error) {
+	br := bufio.NewReader(r)
+	first, err := peekNonSpace(br)
+	if err != nil {
+		return nil, nil, errors.New("file is empty")
+	}
+
+	var objects []map[string]interface{}
+	var numbers []int
+	var rejected []RowError
+	if first == '[' {
+		// An array can't be resumed after a syntax error, only elements
+		// that aren't objects are skipped
+		var elements []json.RawMessage
+		if err := json.NewDecoder(br).Decode(&elements); err != nil {
+			return nil, nil, err
+		}
+		for i, raw := range elements {
+			obj, err := decodeObject(raw)
+			if err != nil {
+				rejected = append(rejected, RowError{Row: i + 1, Err: err})
+				continue
+			}
+			objects = append(objects, obj)
+			numbers = append(numbers, i+1)
+		}
+	} else {
+		n := 0
+		for {
+			line, err := br.ReadBytes('\n')
+			if err != nil && err != io.EOF {
+				return nil, nil, err
+			}
+			if len(bytes.TrimSpace(line)) > 0 {
+				n++
+				if obj, err := decodeObject(line); err != nil {
+					rejected = append(rejected, RowError{Row: n, Err: err})
+				} else {
+					objects = append(objects, obj)
+					numbers = append(numbers, n)
+				}
+			}
+			if err == io.EOF {
+				break
+			}
+		}
+	}
+
+	data := &Data{Columns: objectKeys(objects), Numbers: numbers}
+	for _, obj := range objects {
+		row := make([]interface{}, len(data.Columns))
+		for i, col := range data.Columns {
+			v, ok := obj[col]
+			if !ok {
+				row[i] = Absent
+				continue
+			}
+			row[i] = jsonValue(v)
+		}
+		data.Rows = append(data.Rows, row)
+	}
+	return data, rejected, nil
+}
+
+// decodeObject decodes a single JSON object, with numbers as json.Number
+func decodeObject(raw []byte) (map[string]interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("not a JSON object")
+	}
+	if dec.More() {
+		return nil, errors.New("unexpected data after the object")
+	}
+	return obj, nil
+}
+
+// objectKeys returns the keys found in any of objects, sorted since the keys
+// of a JSON object are unordered
+func objectKeys(objects []map[string]interface{}) []string {
+	var keys []string
+	seen := map[string]bool{}
+	for _, obj := range objects {
+		for key := range obj {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// jsonValue converts a decoded JSON value to one the database drivers accept
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		// Kept as text so that decimals keep every digit
+		return v.String()
+	case map[string]interface{}, []interface{}:
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+	return v
+}
+
+func peekNonSpace(br *bufio.Reader) (byte, error) {
+	for {
+		b, err := br.Peek(1)
+		if err != nil {
+			return 0, err
+		}
+		if !bytes.ContainsAny(b, " \t\r\n") {
+			return b[0], nil
+		}
+		br.ReadByte()
+	}
+}
+
+// mediaType strips parameters such as charset from a Content-Type value
+func mediaType(contentType string) string {
+	mt, _, _ := strings.Cut(contentType, ";")
+	return strings.ToLower(strings.TrimSpace(mt))
+}
@@ -0,0 +1,150 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"sql-engine/store"
+)
+
+// Lease is the stored claim of a leadership term
+type Lease struct {
+	Holder    string    `json:"holder"`
+	Term      int64     `json:"term"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Elector campaigns for leadership among replicas sharing a metadata store.
+// Each term is a separate key created atomically, so when a lease expires
+// exactly one replica can claim the next term; only the holder renews it.
+type Elector struct {
+	meta   *store.Store
+	prefix string
+	id     string
+	ttl    time.Duration
+
+	mu      sync.Mutex
+	current Lease
+	leading bool
+}
+
+// New returns an elector for the named role. id must be unique per replica.
+func New(meta *store.Store, role, id string, ttl time.Duration) *Elector {
+	return &Elector{
+		meta:   meta,
+		prefix: "leader/" + role + "/",
+		id:     id,
+		ttl:    ttl,
+	}
+}
+
+// Run campaigns once, then keeps renewing or contesting the lease in the
+// background until ctx ends
+func (e *Elector) Run(ctx context.Context) {
+	e.campaign()
+	go func() {
+		ticker := time.NewTicker(e.ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				e.campaign()
+			}
+		}
+	}()
+}
+
+// IsLeader reports whether this replica holds an unexpired lease
+func (e *Elector) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leading && time.Now().Before(e.current.ExpiresAt)
+}
+
+// Current returns the latest lease seen
+func (e *Elector) Current() Lease {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.current
+}
+
+func (e *Elector) campaign() {
+	lease, err := e.tryLead()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if err != nil {
+		slog.Error("Leader election failed", "error", err)
+		// Keep leading only while the lease we hold is still valid
+		return
+	}
+	switch {
+	case lease.Holder == e.id && !e.leading:
+		slog.Info("Leader elected", "replica", e.id, "term", lease.Term)
+	case lease.Holder != e.id && e.leading:
+		slog.Warn("Leadership lost", "replica", e.id, "leader", lease.Holder)
+	}
+	e.current = lease
+	e.leading = lease.Holder == e.id
+}
+
+// tryLead renews the lease if this replica holds it, claims the next term if
+// it expired, and returns the lease in force
+func (e *Elector) tryLead() (Lease, error) {
+	keys, err := e.meta.List(e.prefix)
+	if err != nil {
+		return Lease{}, err
+	}
+
+	var last Lease
+	if len(keys) > 0 {
+		if err := e.meta.Get(keys[len(keys)-1], &last); err != nil {
+			return Lease{}, err
+		}
+	}
+
+	now := time.Now()
+	switch {
+	case len(keys) > 0 && last.Holder == e.id:
+		last.ExpiresAt = now.Add(e.ttl)
+		if err := e.meta.Put(e.key(last.Term), last); err != nil {
+			return Lease{}, err
+		}
+	case len(keys) == 0 || now.After(last.ExpiresAt):
+		next := Lease{Holder: e.id, Term: last.Term + 1, ExpiresAt: now.Add(e.ttl)}
+		err := e.meta.Create(e.key(next.Term), next)
+		if errors.Is(err, store.ErrExists) {
+			// Another replica claimed the term first
+			if err := e.meta.Get(e.key(next.Term), &next); err != nil {
+				return Lease{}, err
+			}
+			return next, nil
+		}
+		if err != nil {
+			return Lease{}, err
+		}
+		last = next
+	default:
+		return last, nil
+	}
+
+	// Drop terms older than the previous one
+	for _, key := range keys {
+		if term, err := strconv.ParseInt(strings.TrimPrefix(key, e.prefix), 10, 64); err == nil && term < last.Term-1 {
+			e.meta.Delete(key)
+		}
+	}
+	return last, nil
+}
+
+// key zero-pads the term so keys sort in term order
+func (e *Elector) key(term int64) string {
+	return fmt.Sprintf("%s%020d", e.prefix, term)
+}
@@ -0,0 +1,245 @@
+package lineage
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"sql-engine/config"
+)
+
+const (
+	producer  = "https://github.com/seaside37/sql-engine-demo"
+	schemaURL = "https://openlineage.io/spec/2-0-2/OpenLineage.json#/definitions/RunEvent"
+	sqlFacet  = "https://openlineage.io/spec/facets/1-1-0/SQLJobFacet.json#/$defs/SQLJobFacet"
+)
+
+// Event types of a run
+const (
+	Start    = "START"
+	Complete = "COMPLETE"
+	Fail     = "FAIL"
+)
+
+// RunEvent is an OpenLineage run event
+type RunEvent struct {
+	EventType string    `json:"eventType"`
+	EventTime time.Time `json:"eventTime"`
+	Run       Run       `json:"run"`
+	Job       Job       `json:"job"`
+	Inputs    []Dataset `json:"inputs"`
+	Outputs   []Dataset `json:"outputs"`
+	Producer  string    `json:"producer"`
+	SchemaURL string    `json:"schemaURL"`
+}
+
+type Run struct {
+	RunID  string                 `json:"runId"`
+	Facets map[string]interface{} `json:"facets,omitempty"`
+}
+
+type Job struct {
+	Namespace string                 `json:"namespace"`
+	Name      string                 `json:"name"`
+	Facets    map[string]interface{} `json:"facets,omitempty"`
+}
+
+type Dataset struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+// Emitter posts run events to an OpenLineage collector. A nil or unconfigured
+// Emitter drops events, so callers need not check.
+type Emitter struct {
+	cfg    config.LineageConfig
+	client *http.Client
+	// database prefixes dataset names when derived from the DSN
+	database string
+	// events queues events for in-order delivery
+	events chan RunEvent
+}
+
+// New creates an emitter for cfg. Without a configured dataset namespace,
+// datasets are named after the database in dsn following the OpenLineage
+// naming conventions: namespace postgres://host:port, name
+// database.schema.table.
+func New(cfg config.LineageConfig, dsn string) *Emitter {
+	e := &Emitter{
+		cfg:    cfg,
+		client: &http.Client{Timeout: 10 * time.Second},
+		events: make(chan RunEvent, 1024),
+	}
+	if e.cfg.DatasetNamespace == "" {
+		e.cfg.DatasetNamespace, e.database = datasetNaming(dsn)
+	}
+	if e.Enabled() {
+		go e.deliver()
+	}
+	return e
+}
+
+// deliver posts queued events one at a time so a run's events arrive in order
+func (e *Emitter) deliver() {
+	for ev := range e.events {
+		if err := e.post(ev); err != nil {
+			slog.Error("Lineage event delivery failed", "error", err)
+		}
+	}
+}
+
+func datasetNaming(dsn string) (namespace, database string) {
+	u, err := url.Parse(dsn)
+	if err != nil || u.Scheme == "" {
+		return "unknown", ""
+	}
+	switch u.Scheme {
+	case "postgres", "postgresql":
+		port := u.Port()
+		if port == "" {
+			port = "5432"
+		}
+		return "postgres://" + u.Hostname() + ":" + port, strings.TrimPrefix(u.Path, "/")
+	case "sqlite":
+		return "sqlite://" + u.Host + u.Path, ""
+	}
+	return u.Scheme + "://" + u.Host, strings.TrimPrefix(u.Path, "/")
+}
+
+// Enabled reports whether events are delivered anywhere
+func (e *Emitter) Enabled() bool {
+	return e != nil && e.cfg.URL != ""
+}
+
+// SourceDataset names a file fetched from a URL
+func SourceDataset(u *url.URL) Dataset {
+	return Dataset{Namespace: u.Scheme + "://" + u.Host, Name: u.Path}
+}
+
+// Dataset names a table in the configured dataset namespace
+func (e *Emitter) Dataset(schema, table string) Dataset {
+	if schema == "" {
+		schema = "public"
+	}
+	name := schema + "." + table
+	if e.database != "" {
+		name = e.database + "." + name
+	}
+	return Dataset{Namespace: e.cfg.DatasetNamespace, Name: name}
+}
+
+// RunTracker emits the events of one run
+type RunTracker struct {
+	e       *Emitter
+	runID   string
+	job     Job
+	inputs  []Dataset
+	outputs []Dataset
+}
+
+// StartRun emits the START event of a job run reading inputs and writing
+// outputs. sqlText, when set, is attached as the job's SQL facet.
+func (e *Emitter) StartRun(job, sqlText string, inputs, outputs []Dataset) *RunTracker {
+	if !e.Enabled() {
+		return nil
+	}
+	t := &RunTracker{
+		e:       e,
+		runID:   newRunID(),
+		job:     Job{Namespace: e.cfg.Namespace, Name: job},
+		inputs:  inputs,
+		outputs: outputs,
+	}
+	if sqlText != "" {
+		t.job.Facets = map[string]interface{}{
+			"sql": map[string]string{"_producer": producer, "_schemaURL": sqlFacet, "query": sqlText},
+		}
+	}
+	t.emit(Start, nil)
+	return t
+}
+
+// Finish emits COMPLETE, or FAIL with the error message when err is set
+func (t *RunTracker) Finish(err error) {
+	if t == nil {
+		return
+	}
+	if err != nil {
+		t.emit(Fail, map[string]interface{}{
+			"errorMessage": map[string]string{
+				"_producer":           producer,
+				"_schemaURL":          "https://openlineage.io/spec/facets/1-0-1/ErrorMessageRunFacet.json#/$defs/ErrorMessageRunFacet",
+				"message":             err.Error(),
+				"programmingLanguage": "SQL",
+			},
+		})
+		return
+	}
+	t.emit(Complete, nil)
+}
+
+func (t *RunTracker) emit(eventType string, runFacets map[string]interface{}) {
+	inputs, outputs := t.inputs, t.outputs
+	if inputs == nil {
+		inputs = []Dataset{}
+	}
+	if outputs == nil {
+		outputs = []Dataset{}
+	}
+	ev := RunEvent{
+		EventType: eventType,
+		EventTime: time.Now().UTC(),
+		Run:       Run{RunID: t.runID, Facets: runFacets},
+		Job:       t.job,
+		Inputs:    inputs,
+		Outputs:   outputs,
+		Producer:  producer,
+		SchemaURL: schemaURL,
+	}
+	// Delivery must not slow down or fail the run itself
+	select {
+	case t.e.events <- ev:
+	default:
+		slog.Warn("Lineage event queue full, dropping event", "event", eventType, "job", t.job.Name)
+	}
+}
+
+func (e *Emitter) post(ev RunEvent) error {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, e.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.cfg.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+e.cfg.APIKey)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+// newRunID returns a random (version 4) UUID
+func newRunID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
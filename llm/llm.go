@@ -0,0 +1,42 @@
+// Package llm asks language models for completions, e.g. to draft SQL from
+// a question
+package llm
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"sql-engine/config"
+)
+
+// Provider completes a prompt under a system instruction
+type Provider interface {
+	Complete(ctx context.Context, system, prompt string) (string, error)
+}
+
+var (
+	providersMu sync.RWMutex
+	providers   = map[string]func(cfg config.LLMConfig) Provider{}
+)
+
+// Register makes a provider available under name for llm.provider
+func Register(name string, factory func(cfg config.LLMConfig) Provider) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	providers[name] = factory
+}
+
+// New returns the configured provider, or nil when no URL is configured
+func New(cfg config.LLMConfig) (Provider, error) {
+	if cfg.URL == "" {
+		return nil, nil
+	}
+	providersMu.RLock()
+	factory, ok := providers[cfg.Provider]
+	providersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown LLM provider %q", cfg.Provider)
+	}
+	return factory(cfg), nil
+}
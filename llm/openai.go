@@ -0,0 +1,93 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"sql-engine/config"
+)
+
+func init() {
+	Register("openai", func(cfg config.LLMConfig) Provider {
+		return &openAI{cfg: cfg, client: &http.Client{Timeout: cfg.Timeout}}
+	})
+}
+
+// openAI talks to an OpenAI-compatible chat completions endpoint
+type openAI struct {
+	cfg    config.LLMConfig
+	client *http.Client
+}
+
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type chatRequest struct {
+	Model       string        `json:"model"`
+	Messages    []chatMessage `json:"messages"`
+	Temperature float64       `json:"temperature"`
+}
+
+type chatResponse struct {
+	Choices []struct {
+		Message chatMessage `json:"message"`
+	} `json:"choices"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (o *openAI) Complete(ctx context.Context, system, prompt string) (string, error) {
+	body, err := json.Marshal(chatRequest{
+		Model: o.cfg.Model,
+		Messages: []chatMessage{
+			{Role: "system", Content: system},
+			{Role: "user", Content: prompt},
+		},
+		// The same question should give the same query
+		Temperature: 0,
+	})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(o.cfg.URL, "/")+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if o.cfg.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+o.cfg.APIKey)
+	}
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return "", err
+	}
+	var out chatResponse
+	if err := json.Unmarshal(data, &out); err != nil {
+		return "", fmt.Errorf("model API returned %s", resp.Status)
+	}
+	if out.Error != nil {
+		return "", fmt.Errorf("model API returned %s: %s", resp.Status, out.Error.Message)
+	}
+	if resp.StatusCode >= 300 {
+		return "", fmt.Errorf("model API returned %s", resp.Status)
+	}
+	if len(out.Choices) == 0 {
+		return "", errors.New("model returned no completion")
+	}
+	return out.Choices[0].Message.Content, nil
+}
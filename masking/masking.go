@@ -0,0 +1,124 @@
+package masking
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+
+	"sql-engine/config"
+)
+
+const defaultSchema = "public"
+
+// Policy decides which columns are masked for a caller and how
+type Policy struct {
+	salt  string
+	rules []config.MaskRule
+	// byCategory maps personal-data categories to methods
+	byCategory map[string]string
+	exempt     []string
+	// classified is nil until classifications are looked up
+	classified Classifications
+}
+
+// Classifications holds the personal-data categories found in columns
+type Classifications interface {
+	// Categories maps the lowercased columns of a table to the category
+	// found in each. schema is "" for the default schema.
+	Categories(schema, table string) map[string]string
+}
+
+func New(cfg config.MaskingConfig) *Policy {
+	return &Policy{salt: cfg.Salt, rules: cfg.Rules, byCategory: cfg.Classifications, exempt: cfg.ClassificationExemptRoles}
+}
+
+// UseClassifications masks the columns classified in c as the configured
+// classifications say
+func (p *Policy) UseClassifications(c Classifications) {
+	p.classified = c
+}
+
+// Method returns the masking method for a column, or "" when the column is
+// not masked for a caller holding roles
+func (p *Policy) Method(schema, table, column string, roles []string) string {
+	ruleSchema := schema
+	if ruleSchema == "" {
+		ruleSchema = defaultSchema
+	}
+	for _, r := range p.rules {
+		rs, rt, ok := strings.Cut(r.Table, ".")
+		if !ok {
+			rs, rt = defaultSchema, r.Table
+		}
+		if !strings.EqualFold(rs, ruleSchema) || !strings.EqualFold(rt, table) || !strings.EqualFold(r.Column, column) {
+			continue
+		}
+		if slices.ContainsFunc(r.ExemptRoles, func(role string) bool { return slices.Contains(roles, role) }) {
+			return ""
+		}
+		return r.Method
+	}
+	if !p.classifies(roles) {
+		return ""
+	}
+	return p.byCategory[p.classified.Categories(schema, table)[strings.ToLower(column)]]
+}
+
+// classifies reports whether classified columns are masked for a caller
+// holding roles
+func (p *Policy) classifies(roles []string) bool {
+	return p.classified != nil && len(p.byCategory) > 0 &&
+		!slices.ContainsFunc(p.exempt, func(role string) bool { return slices.Contains(roles, role) })
+}
+
+// Columns lists the masked columns of a table for a caller holding roles
+func (p *Policy) Columns(schema, table string, roles []string) map[string]string {
+	var columns []string
+	for _, r := range p.rules {
+		columns = append(columns, r.Column)
+	}
+	if p.classifies(roles) {
+		for col := range p.classified.Categories(schema, table) {
+			columns = append(columns, col)
+		}
+	}
+	masked := map[string]string{}
+	for _, col := range columns {
+		if m := p.Method(schema, table, col, roles); m != "" {
+			masked[strings.ToLower(col)] = m
+		}
+	}
+	return masked
+}
+
+// Apply masks a single value. NULLs stay NULL.
+func (p *Policy) Apply(method string, v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	s, ok := v.(string)
+	if b, isBytes := v.([]byte); isBytes {
+		s, ok = string(b), true
+	}
+	if !ok {
+		s = fmt.Sprint(v)
+	}
+
+	switch method {
+	case "hash":
+		sum := sha256.Sum256([]byte(p.salt + s))
+		return hex.EncodeToString(sum[:8])
+	case "redact":
+		return "[REDACTED]"
+	case "partial":
+		// Keep the last four characters, e.g. card and phone suffixes
+		r := []rune(s)
+		keep := min(4, len(r)/2)
+		return strings.Repeat("*", len(r)-keep) + string(r[len(r)-keep:])
+	case "null":
+		return nil
+	}
+	return v
+}
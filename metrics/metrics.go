@@ -0,0 +1,65 @@
+// Package metrics exposes Prometheus metrics. Query latencies carry the
+// trace ID of a sampled request as an exemplar, served in the OpenMetrics
+// format to scrapers asking for it.
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"sql-engine/config"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	registry = prometheus.NewRegistry()
+	// queryDuration is nil unless metrics are enabled
+	queryDuration *prometheus.HistogramVec
+)
+
+// Setup registers the metrics. Without metrics.enabled it does nothing and
+// observations are dropped.
+func Setup(cfg config.MetricsConfig) {
+	if !cfg.Enabled {
+		return
+	}
+	queryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sqlengine_query_duration_seconds",
+		Help:    "Time queries took to run, from the start of their transaction to their last row.",
+		Buckets: cfg.QueryBuckets,
+	}, []string{"outcome"})
+	registry.MustRegister(
+		queryDuration,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// Handler serves the metrics, in the OpenMetrics format when the scraper
+// accepts it
+func Handler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.HandlerFor(registry, promhttp.HandlerOpts{EnableOpenMetrics: true}))
+}
+
+// ObserveQuery records how long a query took. Queries run while serving a
+// sampled trace keep its ID as the exemplar of their bucket.
+func ObserveQuery(ctx context.Context, elapsed time.Duration, failed bool) {
+	if queryDuration == nil {
+		return
+	}
+	outcome := "ok"
+	if failed {
+		outcome = "error"
+	}
+	observer := queryDuration.WithLabelValues(outcome)
+	if span := trace.SpanContextFromContext(ctx); span.IsSampled() {
+		observer.(prometheus.ExemplarObserver).ObserveWithExemplar(elapsed.Seconds(), prometheus.Labels{"trace_id": span.TraceID().String()})
+		return
+	}
+	observer.Observe(elapsed.Seconds())
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"sql-engine/handlers"
+)
+
+// runMigrate implements `sqlengine migrate`: it lists, applies or reverts
+// the schema migrations through /migrations, uploading those of --dir
+func runMigrate(args []string) int {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: sqlengine migrate status|up|down [flags]")
+		fs.PrintDefaults()
+	}
+	var conn connection
+	conn.register(fs)
+	dir := fs.String("dir", "", "directory of migrations to upload with up, next to the server's migrations.dir")
+	target := fs.Int64("target", 0, "up: apply up to this version; down: revert the versions above it")
+	steps := fs.Int("steps", 0, "down: number of migrations to revert, 1 unless --target is set")
+	dryRun := fs.Bool("dry-run", false, "run the migrations in a transaction that is rolled back, printing the plans of their statements")
+	asJSON := fs.Bool("json", false, "print the response as JSON")
+	positional := parseInterspersed(fs, args)
+	if len(positional) != 1 {
+		fs.Usage()
+		return 2
+	}
+	command := positional[0]
+	targetSet := false
+	fs.Visit(func(f *flag.Flag) { targetSet = targetSet || f.Name == "target" })
+
+	var body any
+	switch command {
+	case "status":
+	case "up":
+		req := handlers.MigrateUpRequest{Target: *target, DryRun: *dryRun}
+		if *dir != "" {
+			files, err := handlers.LoadMigrations(*dir)
+			if err != nil {
+				return fail(err)
+			}
+			if len(files) == 0 {
+				return fail(errors.New("no migrations in " + *dir))
+			}
+			for _, m := range files {
+				req.Migrations = append(req.Migrations, m)
+			}
+			sort.Slice(req.Migrations, func(i, j int) bool { return req.Migrations[i].Version < req.Migrations[j].Version })
+		}
+		body = req
+	case "down":
+		req := handlers.MigrateDownRequest{Steps: *steps, DryRun: *dryRun}
+		if targetSet {
+			req.Target = target
+		}
+		body = req
+	default:
+		fs.Usage()
+		return 2
+	}
+	quietLogs()
+
+	client, base, closeConn, err := conn.client()
+	if err != nil {
+		return fail(err)
+	}
+	defer closeConn()
+
+	var req *http.Request
+	if body == nil {
+		req, _ = http.NewRequest(http.MethodGet, base+"/migrations", nil)
+	} else {
+		b, _ := json.Marshal(body)
+		req, _ = http.NewRequest(http.MethodPost, base+"/migrations/"+command, bytes.NewReader(b))
+		req.Header.Set("Content-Type", "application/json")
+	}
+	// A failed migration answers 422 with those applied before it
+	resp, err := conn.do(client, req, http.StatusUnprocessableEntity)
+	if err != nil {
+		return fail(err)
+	}
+	defer resp.Body.Close()
+
+	if body == nil {
+		var status struct {
+			Migrations []handlers.MigrationStatus `json:"migrations"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+			return fail(err)
+		}
+		if *asJSON {
+			printJSON(status)
+			return 0
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tSTATUS\tAPPLIED AT\tAPPLIED BY")
+		for _, m := range status.Migrations {
+			state, at := m.Status, ""
+			if m.Modified {
+				state += " (modified)"
+			}
+			if m.AppliedAt != nil {
+				at = m.AppliedAt.Local().Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", m.Version, m.Name, state, at, m.AppliedBy)
+		}
+		w.Flush()
+		return 0
+	}
+
+	var run handlers.MigrationRun
+	if err := json.NewDecoder(resp.Body).Decode(&run); err != nil {
+		return fail(err)
+	}
+	if *asJSON {
+		printJSON(run)
+	} else {
+		printMigrationRun(run)
+	}
+	if run.Error != "" {
+		return fail(errors.New(run.Error))
+	}
+	return 0
+}
+
+// printMigrationRun lists the migrations of a run and, in a dry run, their
+// statements with the plans the database gave
+func printMigrationRun(run handlers.MigrationRun) {
+	verb := map[string]string{"up": "Applied", "down": "Reverted"}[run.Direction]
+	if run.DryRun {
+		verb = map[string]string{"up": "Would apply", "down": "Would revert"}[run.Direction]
+	}
+	if len(run.Migrations) == 0 && run.Error == "" {
+		fmt.Println("Nothing to migrate")
+	}
+	for _, m := range run.Migrations {
+		fmt.Printf("%s %d_%s (%s)\n", verb, m.Version, m.Name, m.Duration)
+		if !run.DryRun {
+			continue
+		}
+		for _, s := range m.Statements {
+			fmt.Printf("  %s\n", s.SQL)
+			for _, row := range s.Plan {
+				b, _ := json.Marshal(row)
+				fmt.Printf("    %s\n", b)
+			}
+		}
+	}
+}
+
+func printJSON(v any) {
+	b, _ := json.MarshalIndent(v, "", "  ")
+	fmt.Println(string(b))
+}
@@ -0,0 +1,196 @@
+// Package openapi builds an OpenAPI 3.1 document from the routes gin serves
+// and the Go types their handlers bind and return, so that the document
+// can't drift from the code
+package openapi
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Operation documents what a route's handler reads and writes beyond what
+// the route itself says
+type Operation struct {
+	Summary string
+	// Request is a value of the type of the JSON body the handler binds
+	Request any
+	// Response is a value of the type of the JSON body answered with
+	// Status, 200 by default
+	Response any
+	Status   int
+	// Query lists the query parameters the handler reads
+	Query []string
+	// Public routes need no credentials
+	Public bool
+}
+
+// Object describes an inline JSON object by the types of its fields' values,
+// for responses written as gin.H
+type Object map[string]any
+
+// Info is the document's title and version
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+var (
+	pathParam  = regexp.MustCompile(`[:*]([A-Za-z0-9_]+)`)
+	wordBounds = regexp.MustCompile(`([a-z0-9])([A-Z])`)
+)
+
+// Build returns the document of routes. Operations are keyed by the name
+// of the handler method, e.g. "RunQuery"; routes without one are listed
+// with a generic response. auth adds the API key and bearer token schemes.
+func Build(info Info, routes gin.RoutesInfo, operations map[string]Operation, auth bool) map[string]any {
+	schemas := newSchemaSet()
+	schemas.components["Error"] = map[string]any{
+		"type":       "object",
+		"properties": map[string]any{"error": map[string]any{"type": "string"}},
+		"required":   []string{"error"},
+	}
+	errorResponse := map[string]any{
+		"description": "Error",
+		"content": map[string]any{
+			"application/json": map[string]any{"schema": map[string]any{"$ref": "#/components/schemas/Error"}},
+		},
+	}
+
+	paths := map[string]any{}
+	ids := map[string]bool{}
+	for _, route := range routes {
+		name := handlerName(route.Handler)
+		op := operations[name]
+		path := pathParam.ReplaceAllString(route.Path, "{$1}")
+
+		// Handlers serving several routes get IDs from the later ones' paths
+		id := operationID(name, route.Method, path)
+		if ids[id] {
+			id = operationID("", route.Method, path)
+		}
+		ids[id] = true
+		operation := map[string]any{
+			"operationId": id,
+			"summary":     op.Summary,
+			"tags":        []string{tag(path)},
+		}
+		if op.Summary == "" {
+			operation["summary"] = summarize(name)
+		}
+
+		var params []any
+		for _, m := range pathParam.FindAllStringSubmatch(route.Path, -1) {
+			params = append(params, map[string]any{
+				"name": m[1], "in": "path", "required": true,
+				"schema": map[string]any{"type": "string"},
+			})
+		}
+		for _, q := range op.Query {
+			params = append(params, map[string]any{
+				"name": q, "in": "query",
+				"schema": map[string]any{"type": "string"},
+			})
+		}
+		if len(params) > 0 {
+			operation["parameters"] = params
+		}
+
+		if op.Request != nil {
+			operation["requestBody"] = map[string]any{
+				"required": true,
+				"content": map[string]any{
+					"application/json": map[string]any{"schema": schemas.of(op.Request)},
+				},
+			}
+		}
+
+		status := op.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		success := map[string]any{"description": http.StatusText(status)}
+		if op.Response != nil {
+			success["content"] = map[string]any{
+				"application/json": map[string]any{"schema": schemas.of(op.Response)},
+			}
+		}
+		operation["responses"] = map[string]any{
+			strconv.Itoa(status): success,
+			"default":            errorResponse,
+		}
+		if op.Public && auth {
+			operation["security"] = []any{}
+		}
+
+		item, _ := paths[path].(map[string]any)
+		if item == nil {
+			item = map[string]any{}
+			paths[path] = item
+		}
+		item[strings.ToLower(route.Method)] = operation
+	}
+
+	components := map[string]any{"schemas": schemas.components}
+	doc := map[string]any{
+		"openapi":    "3.1.0",
+		"info":       info,
+		"paths":      paths,
+		"components": components,
+	}
+	if auth {
+		components["securitySchemes"] = map[string]any{
+			"apiKey": map[string]any{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+			"bearer": map[string]any{"type": "http", "scheme": "bearer", "bearerFormat": "JWT"},
+		}
+		doc["security"] = []any{
+			map[string]any{"apiKey": []string{}},
+			map[string]any{"bearer": []string{}},
+		}
+	}
+	return doc
+}
+
+// handlerName extracts the method name out of a handler's function name,
+// e.g. "sql-engine/handlers.(*Handler).RunQuery-fm"
+func handlerName(fn string) string {
+	fn = strings.TrimSuffix(fn, "-fm")
+	if i := strings.LastIndex(fn, "."); i >= 0 {
+		fn = fn[i+1:]
+	}
+	if fn == "" || !unicode.IsUpper(rune(fn[0])) {
+		return ""
+	}
+	return fn
+}
+
+func operationID(name, method, path string) string {
+	if name != "" {
+		return name
+	}
+	id := strings.ToLower(method) + strings.NewReplacer("/", "_", "{", "", "}", "", "-", "_").Replace(path)
+	return strings.TrimSuffix(id, "_")
+}
+
+// summarize turns a handler name into a sentence, e.g. "Run query"
+func summarize(name string) string {
+	if name == "" {
+		return ""
+	}
+	words := strings.ToLower(wordBounds.ReplaceAllString(name, "$1 $2"))
+	return strings.ToUpper(words[:1]) + words[1:]
+}
+
+// tag groups operations by the first segment of their path
+func tag(path string) string {
+	segment, _, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	if segment == "" {
+		return "default"
+	}
+	return segment
+}
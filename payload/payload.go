@@ -0,0 +1,287 @@
+// Package payload compresses responses for clients accepting it and refuses
+// responses too large to be worth sending whole
+package payload
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"sql-engine/cache"
+	"sql-engine/config"
+
+	"github.com/gin-gonic/gin"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// encoder is what the gzip and zstd writers have in common
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// encoders reuse compressors across responses, their state being costly to
+// allocate
+var encoders = map[string]*sync.Pool{
+	"gzip": {New: func() any { return gzip.NewWriter(nil) }},
+	"zstd": {New: func() any {
+		enc, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		return enc
+	}},
+}
+
+// compressible lists the content types worth compressing; images, archives
+// and binary downloads mostly aren't
+var compressible = []string{
+	"text/",
+	"application/json",
+	"application/x-ndjson",
+	"application/yaml",
+	"application/sql",
+	"application/xml",
+	"application/javascript",
+	"application/geo+json",
+	"application/vnd.apache.arrow",
+}
+
+// Middleware compresses responses with the first of
+// server.compression.encodings the client accepts, leaving out bodies under
+// server.compression.min_size, and answers 422 instead of bodies over
+// server.max_response_bytes. Streamed responses are compressed as they are
+// written and never capped.
+func Middleware(cfg config.ServerConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		encoding := ""
+		if cfg.Compression.Enabled {
+			c.Writer.Header().Add("Vary", "Accept-Encoding")
+			encoding = negotiate(c.GetHeader("Accept-Encoding"), cfg.Compression.Encodings)
+		}
+		if encoding == "" && cfg.MaxResponseBytes == 0 {
+			c.Next()
+			return
+		}
+		w := &writer{
+			ResponseWriter: c.Writer,
+			ctx:            c,
+			status:         http.StatusOK,
+			encoding:       encoding,
+			minSize:        cfg.Compression.MinSize,
+			maxSize:        cfg.MaxResponseBytes,
+		}
+		c.Writer = w
+		c.Next()
+		c.Writer = w.ResponseWriter
+		w.finish()
+	}
+}
+
+// negotiate picks the first of offers an Accept-Encoding header accepts, or
+// "" for none
+func negotiate(header string, offers []string) string {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		accepted[strings.ToLower(strings.TrimSpace(name))] = q > 0
+	}
+	for _, offer := range offers {
+		if ok, listed := accepted[offer]; ok || !listed && accepted["*"] {
+			return offer
+		}
+	}
+	return ""
+}
+
+// writer holds back a response until it is complete, so that its size is
+// known, unless the handler marked it Streamed
+type writer struct {
+	gin.ResponseWriter
+	ctx       *gin.Context
+	status    int
+	written   bool
+	streaming bool
+	body      bytes.Buffer
+	encoding  string
+	enc       encoder
+	minSize   int
+	maxSize   int64
+}
+
+func (w *writer) WriteHeader(code int) {
+	if !w.streaming {
+		w.status = code
+	}
+}
+
+func (w *writer) WriteHeaderNow() {
+	if w.stream() {
+		return
+	}
+	w.written = true
+}
+
+func (w *writer) Write(b []byte) (int, error) {
+	if w.stream() {
+		if w.enc != nil {
+			return w.enc.Write(b)
+		}
+		return w.ResponseWriter.Write(b)
+	}
+	w.written = true
+	return w.body.Write(b)
+}
+
+func (w *writer) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Flush sends what a streamed response compressed so far
+func (w *writer) Flush() {
+	if !w.streaming {
+		return
+	}
+	if w.enc != nil {
+		w.enc.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+func (w *writer) Status() int {
+	return w.status
+}
+
+func (w *writer) Size() int {
+	if !w.written {
+		return -1
+	}
+	return w.body.Len()
+}
+
+func (w *writer) Written() bool {
+	return w.written
+}
+
+// stream reports whether writes go out as they come, sending the headers
+// on the first one
+func (w *writer) stream() bool {
+	if w.streaming {
+		return true
+	}
+	if !cache.IsStreamed(w.ctx) {
+		return false
+	}
+	w.streaming, w.written = true, true
+	if w.compress() {
+		w.enc.Reset(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.WriteHeaderNow()
+	return true
+}
+
+// compress decides whether the response is compressed, setting its headers
+// and taking an encoder if it is
+func (w *writer) compress() bool {
+	h := w.Header()
+	if w.encoding == "" || h.Get("Content-Encoding") != "" || !bodyAllowed(w.status) {
+		return false
+	}
+	contentType := h.Get("Content-Type")
+	// Proxies buffering compressed event streams would hold events back
+	if strings.HasPrefix(contentType, "text/event-stream") || !hasAnyPrefix(contentType, compressible) {
+		return false
+	}
+	h.Set("Content-Encoding", w.encoding)
+	h.Del("Content-Length")
+	// The compressed body is a different representation of the same
+	// content
+	if etag := h.Get("ETag"); strings.HasPrefix(etag, `"`) {
+		h.Set("ETag", "W/"+etag)
+	}
+	w.enc = encoders[w.encoding].Get().(encoder)
+	return true
+}
+
+// finish sends a held back response, compressed or replaced by an error,
+// or completes a streamed one
+func (w *writer) finish() {
+	if w.streaming {
+		if w.enc != nil {
+			w.enc.Close()
+			encoders[w.encoding].Put(w.enc)
+		}
+		return
+	}
+
+	if w.maxSize > 0 && int64(w.body.Len()) > w.maxSize {
+		w.tooLarge()
+	}
+	if w.body.Len() < w.minSize || !w.compress() {
+		w.ResponseWriter.WriteHeader(w.status)
+		if w.body.Len() > 0 {
+			w.ResponseWriter.Write(w.body.Bytes())
+		} else {
+			w.ResponseWriter.WriteHeaderNow()
+		}
+		return
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	w.enc.Reset(w.ResponseWriter)
+	w.enc.Write(w.body.Bytes())
+	w.enc.Close()
+	encoders[w.encoding].Put(w.enc)
+}
+
+// tooLarge replaces a response over the size cap with an error pointing to
+// the ways of getting the data in parts
+func (w *writer) tooLarge() {
+	size := w.body.Len()
+	slog.WarnContext(w.ctx.Request.Context(), "Response too large", "path", w.ctx.Request.URL.Path, "bytes", size, "max_bytes", w.maxSize)
+	body, _ := json.Marshal(gin.H{
+		"error":              fmt.Sprintf("Result too large: %s exceeds the %s response limit", formatBytes(int64(size)), formatBytes(w.maxSize)),
+		"hint":               "Export the table with GET /table/:name/export, or page through the result with page_size and cursor",
+		"max_response_bytes": w.maxSize,
+	})
+	h := w.Header()
+	for _, name := range []string{"ETag", "Last-Modified", "Content-Length", "Content-Disposition"} {
+		h.Del(name)
+	}
+	h.Set("Content-Type", "application/json; charset=utf-8")
+	h.Set("Cache-Control", "no-store")
+	w.status = http.StatusUnprocessableEntity
+	w.body.Reset()
+	w.body.Write(body)
+}
+
+func formatBytes(n int64) string {
+	if n < 1<<20 {
+		return fmt.Sprintf("%d bytes", n)
+	}
+	return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+}
+
+func bodyAllowed(status int) bool {
+	return status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,145 @@
+package pii
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Categories of personal data the detector recognises
+const (
+	Email      = "email"
+	Phone      = "phone"
+	NationalID = "national_id"
+	CreditCard = "credit_card"
+	IPAddress  = "ip_address"
+	Address    = "address"
+	BirthDate  = "birth_date"
+	Name       = "person_name"
+)
+
+// Finding describes a column that likely contains personal data
+type Finding struct {
+	Category string `json:"category"`
+	// Confidence is the share of sampled values matching the category, or a
+	// fixed score when the finding comes from the column name only
+	Confidence float64 `json:"confidence"`
+	Source     string  `json:"source"` // "data" or "name"
+}
+
+var valuePatterns = []struct {
+	category string
+	re       *regexp.Regexp
+	check    func(string) bool
+}{
+	{Email, regexp.MustCompile(`^[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}$`), nil},
+	{NationalID, regexp.MustCompile(`^\d{3}-\d{2}-\d{4}$`), nil}, // US SSN
+	{NationalID, regexp.MustCompile(`^\d{17}[\dXx]$`), nil},      // PRC resident ID
+	{CreditCard, regexp.MustCompile(`^(?:\d[ -]?){12,18}\d$`), luhnValid},
+	{Phone, regexp.MustCompile(`^\+?[\d\s().\-]{7,20}$`), hasPhoneDigits},
+	{IPAddress, regexp.MustCompile(`^(?:\d{1,3}\.){3}\d{1,3}$`), nil},
+}
+
+var dateLike = regexp.MustCompile(`^\d{4}[-/.]\d{1,2}[-/.]\d{1,2}`)
+
+var namePatterns = []struct {
+	category string
+	keywords []string
+}{
+	{Email, []string{"email", "e_mail", "mail"}},
+	{Phone, []string{"phone", "mobile", "tel", "fax"}},
+	{NationalID, []string{"ssn", "social_security", "national_id", "id_card", "idcard", "passport", "tax_id"}},
+	{CreditCard, []string{"card_number", "credit_card", "cc_number", "pan"}},
+	{IPAddress, []string{"ip_address", "ip_addr", "client_ip", "remote_addr"}},
+	{Address, []string{"address", "street", "zipcode", "postal_code", "postcode"}},
+	{BirthDate, []string{"birth", "dob"}},
+	{Name, []string{"first_name", "last_name", "full_name", "surname"}},
+}
+
+// Classify inspects a column's name and sampled values and returns the most
+// likely personal-data category. Values must match at least threshold (0-1)
+// of the non-empty samples for a data-based finding.
+func Classify(column string, samples []string, threshold float64) (Finding, bool) {
+	if f, ok := classifyValues(samples, threshold); ok {
+		return f, true
+	}
+	return classifyName(column)
+}
+
+func classifyValues(samples []string, threshold float64) (Finding, bool) {
+	var values []string
+	for _, v := range samples {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	if len(values) == 0 {
+		return Finding{}, false
+	}
+
+	var best Finding
+	for _, p := range valuePatterns {
+		matched := 0
+		for _, v := range values {
+			if p.re.MatchString(v) && (p.check == nil || p.check(v)) {
+				matched++
+			}
+		}
+
+		ratio := float64(matched) / float64(len(values))
+		if ratio >= threshold && ratio > best.Confidence {
+			best = Finding{Category: p.category, Confidence: ratio, Source: "data"}
+		}
+	}
+	return best, best.Category != ""
+}
+
+func classifyName(column string) (Finding, bool) {
+	name := strings.ToLower(column)
+	for _, p := range namePatterns {
+		for _, kw := range p.keywords {
+			if name == kw || strings.HasPrefix(name, kw+"_") || strings.HasSuffix(name, "_"+kw) ||
+				(len(kw) > 4 && strings.Contains(name, kw)) {
+				return Finding{Category: p.category, Confidence: 0.5, Source: "name"}, true
+			}
+		}
+	}
+	return Finding{}, false
+}
+
+// hasPhoneDigits filters out plain integers such as row ids: a phone number
+// needs formatting characters, a leading +, or the 11-digit mobile shape
+func hasPhoneDigits(v string) bool {
+	digits := 0
+	for _, r := range v {
+		if r >= '0' && r <= '9' {
+			digits++
+		}
+	}
+	if digits < 7 || digits > 15 || dateLike.MatchString(v) {
+		return false
+	}
+	formatted := digits != len(v)
+	mobile := digits == 11 && v[0] == '1'
+	return formatted || mobile
+}
+
+// luhnValid reports whether the digits in v pass the Luhn checksum
+func luhnValid(v string) bool {
+	sum, n := 0, 0
+	for i := len(v) - 1; i >= 0; i-- {
+		c := v[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if n%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n >= 13 && sum%10 == 0
+}
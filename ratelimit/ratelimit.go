@@ -0,0 +1,134 @@
+package ratelimit
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"sql-engine/auth"
+	"sql-engine/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// idleAfter is how long an unused caller's state is kept
+const idleAfter = 10 * time.Minute
+
+// Limiter enforces per-caller request rates and query concurrency
+type Limiter struct {
+	cfg config.LimitsConfig
+
+	mu        sync.Mutex
+	callers   map[string]*caller
+	lastSweep time.Time
+}
+
+type caller struct {
+	tokens   float64
+	last     time.Time
+	inFlight int
+}
+
+func New(cfg config.LimitsConfig) *Limiter {
+	return &Limiter{cfg: cfg, callers: map[string]*caller{}, lastSweep: time.Now()}
+}
+
+// callerKey identifies the caller by principal, or by client address for
+// anonymous requests
+func callerKey(c *gin.Context) string {
+	if p := auth.Principal(c); p != "" {
+		return p
+	}
+	return "ip:" + c.ClientIP()
+}
+
+// get returns the state of a caller, creating it with a full bucket. l.mu
+// must be held.
+func (l *Limiter) get(key string, now time.Time) *caller {
+	if now.Sub(l.lastSweep) > idleAfter {
+		for k, st := range l.callers {
+			if st.inFlight == 0 && now.Sub(st.last) > idleAfter {
+				delete(l.callers, k)
+			}
+		}
+		l.lastSweep = now
+	}
+	st, ok := l.callers[key]
+	if !ok {
+		st = &caller{tokens: float64(l.burst()), last: now}
+		l.callers[key] = st
+	}
+	return st
+}
+
+func (l *Limiter) burst() int {
+	if l.cfg.Burst > 0 {
+		return l.cfg.Burst
+	}
+	return 1
+}
+
+// Middleware rejects callers exceeding their requests per minute with 429 and
+// a Retry-After header. It must run after authentication.
+func (l *Limiter) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if l.cfg.RequestsPerMinute <= 0 {
+			c.Next()
+			return
+		}
+		perSecond := float64(l.cfg.RequestsPerMinute) / 60
+		now := time.Now()
+
+		l.mu.Lock()
+		st := l.get(callerKey(c), now)
+		st.tokens = math.Min(float64(l.burst()), st.tokens+now.Sub(st.last).Seconds()*perSecond)
+		st.last = now
+		allowed := st.tokens >= 1
+		if allowed {
+			st.tokens--
+		}
+		wait := (1 - st.tokens) / perSecond
+		l.mu.Unlock()
+
+		if !allowed {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait))))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Rate limit exceeded"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// Concurrency rejects callers already running their maximum number of
+// queries. Apply it to routes that execute statements.
+func (l *Limiter) Concurrency() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if l.cfg.MaxConcurrentQueries <= 0 {
+			c.Next()
+			return
+		}
+		key := callerKey(c)
+
+		l.mu.Lock()
+		st := l.get(key, time.Now())
+		allowed := st.inFlight < l.cfg.MaxConcurrentQueries
+		if allowed {
+			st.inFlight++
+		}
+		l.mu.Unlock()
+
+		if !allowed {
+			c.Header("Retry-After", "1")
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Too many concurrent queries"})
+			return
+		}
+		defer func() {
+			l.mu.Lock()
+			st.inFlight--
+			l.mu.Unlock()
+		}()
+		c.Next()
+	}
+}
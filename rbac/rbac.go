@@ -0,0 +1,276 @@
+package rbac
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+
+	"sql-engine/auth"
+	"sql-engine/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultSchema is assumed for unqualified table names and grant patterns
+const DefaultSchema = "public"
+
+type accessKey struct{}
+
+// Policy maps principals to roles and roles to the schemas, tables and
+// columns they may read or write
+type Policy struct {
+	cfg config.RBACConfig
+	// dir is nil unless users are provisioned by an identity provider
+	dir      Directory
+	required bool
+	// extra is nil unless grants are made outside the configuration
+	extra GrantSource
+}
+
+// GrantSource holds grants made to principals outside the configuration,
+// such as approved access requests
+type GrantSource interface {
+	// GrantsOf returns the grants of a principal that are in effect now
+	GrantsOf(principal string) ([]config.GrantConfig, error)
+}
+
+// Directory holds the users provisioned by an identity provider
+type Directory interface {
+	// Provisioned reports whether a principal is a provisioned user,
+	// whether the user is active and the roles granted by its groups
+	Provisioned(principal string) (roles []string, active, ok bool, err error)
+}
+
+func New(cfg config.RBACConfig) *Policy {
+	return &Policy{cfg: cfg}
+}
+
+// UseDirectory grants provisioned users the roles of their groups and
+// refuses deactivated ones, or with required all callers with a verified
+// token who aren't provisioned. API keys identify services and are exempt.
+func (p *Policy) UseDirectory(d Directory, required bool) {
+	p.dir = d
+	p.required = required
+}
+
+// UseGrants adds the grants of a GrantSource to those of the caller's roles
+func (p *Policy) UseGrants(g GrantSource) {
+	p.extra = g
+}
+
+// Access is the effective set of grants of one caller. A nil *Access is
+// unrestricted: it is used when RBAC is disabled and for internal jobs.
+type Access struct {
+	Principal string
+	Roles     []string
+	grants    []config.GrantConfig
+	admin     bool
+}
+
+// AccessFor resolves the roles of a principal from role assignments, the
+// configured JWT roles claim, the roles of its provisioned groups and the
+// default roles
+func (p *Policy) AccessFor(principal string, claims map[string]interface{}, provisioned []string) *Access {
+	if !p.cfg.Enabled {
+		return nil
+	}
+
+	roles := slices.Clone(p.cfg.DefaultRoles)
+	roles = append(roles, p.cfg.Assignments[principal]...)
+	roles = append(roles, provisioned...)
+	if p.cfg.RolesClaim != "" {
+		switch v := claims[p.cfg.RolesClaim].(type) {
+		case []interface{}:
+			for _, r := range v {
+				if s, ok := r.(string); ok {
+					roles = append(roles, s)
+				}
+			}
+		case string:
+			roles = append(roles, strings.Fields(v)...)
+		}
+	}
+	slices.Sort(roles)
+	roles = slices.Compact(roles)
+
+	a := &Access{Principal: principal, Roles: roles}
+	for _, role := range roles {
+		if slices.Contains(p.cfg.AdminRoles, role) {
+			a.admin = true
+		}
+		a.grants = append(a.grants, p.cfg.Roles[role].Grants...)
+	}
+	return a
+}
+
+// IsAdmin reports whether the caller holds an administrative role
+func (a *Access) IsAdmin() bool {
+	return a == nil || a.admin
+}
+
+// CanTable reports whether any grant covers the table
+func (a *Access) CanTable(schema, table string) bool {
+	if a == nil || a.admin {
+		return true
+	}
+	for _, g := range a.grants {
+		if grantMatches(g.Table, schema, table) {
+			return true
+		}
+	}
+	return false
+}
+
+// CanColumn reports whether the caller may read a column of a table
+func (a *Access) CanColumn(schema, table, column string) bool {
+	if a == nil || a.admin {
+		return true
+	}
+	for _, g := range a.grants {
+		if !grantMatches(g.Table, schema, table) {
+			continue
+		}
+		if len(g.Columns) == 0 || slices.Contains(g.Columns, column) {
+			return true
+		}
+	}
+	return false
+}
+
+// CanWrite reports whether the caller may write a column of a table, or
+// rows of the table when column is empty
+func (a *Access) CanWrite(schema, table, column string) bool {
+	if a == nil || a.admin {
+		return true
+	}
+	for _, g := range a.grants {
+		if !g.Write || !grantMatches(g.Table, schema, table) {
+			continue
+		}
+		if column == "" || len(g.Columns) == 0 || slices.Contains(g.Columns, column) {
+			return true
+		}
+	}
+	return false
+}
+
+// RestrictsColumns reports whether the caller may only read some columns of
+// a table
+func (a *Access) RestrictsColumns(schema, table string) bool {
+	if a == nil || a.admin {
+		return false
+	}
+	for _, g := range a.grants {
+		if grantMatches(g.Table, schema, table) && len(g.Columns) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// grantMatches matches a "schema.table" pattern (path.Match syntax, a bare
+// table pattern implies the default schema) against a table
+func grantMatches(pattern, schema, table string) bool {
+	if pattern == "*" {
+		return true
+	}
+	if !strings.Contains(pattern, ".") {
+		pattern = DefaultSchema + "." + pattern
+	}
+	if schema == "" {
+		schema = DefaultSchema
+	}
+	ok, _ := path.Match(pattern, schema+"."+table)
+	return ok
+}
+
+// WithAccess attaches a caller's access to a context
+func WithAccess(ctx context.Context, a *Access) context.Context {
+	return context.WithValue(ctx, accessKey{}, a)
+}
+
+// FromContext returns the access attached to ctx, or nil (unrestricted)
+func FromContext(ctx context.Context) *Access {
+	a, _ := ctx.Value(accessKey{}).(*Access)
+	return a
+}
+
+// Middleware resolves the caller's access and attaches it to the request
+// context. It must run after authentication.
+func (p *Policy) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, claims := auth.Principal(c), auth.Claims(c)
+		var provisioned []string
+		if p.dir != nil && claims != nil {
+			roles, active, ok, err := p.dir.Provisioned(principal)
+			switch {
+			case err != nil && p.required:
+				c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to look up provisioned user: " + err.Error()})
+				return
+			case err != nil:
+				slog.ErrorContext(c.Request.Context(), "Failed to look up provisioned user", "principal", principal, "error", err)
+			case ok && !active:
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "User " + principal + " is deactivated"})
+				return
+			case !ok && p.required:
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "User " + principal + " is not provisioned"})
+				return
+			}
+			provisioned = roles
+		}
+		if a := p.AccessFor(principal, claims, provisioned); a != nil {
+			p.addGrants(c.Request.Context(), a)
+			c.Request = c.Request.WithContext(WithAccess(c.Request.Context(), a))
+		}
+		c.Next()
+	}
+}
+
+// AccessOf resolves the access of a principal outside a request, for the
+// jobs run on its behalf. Claims aren't available there, so only role
+// assignments, provisioned groups, default roles and grants count.
+func (p *Policy) AccessOf(ctx context.Context, principal string) (*Access, error) {
+	var provisioned []string
+	if p.dir != nil && principal != "" {
+		roles, active, ok, err := p.dir.Provisioned(principal)
+		switch {
+		case err != nil:
+			return nil, fmt.Errorf("failed to look up provisioned user: %w", err)
+		case ok && !active:
+			return nil, fmt.Errorf("user %s is deactivated", principal)
+		}
+		provisioned = roles
+	}
+	a := p.AccessFor(principal, nil, provisioned)
+	if a != nil {
+		p.addGrants(ctx, a)
+	}
+	return a, nil
+}
+
+// addGrants adds the grants made to a's principal outside the configuration
+func (p *Policy) addGrants(ctx context.Context, a *Access) {
+	if p.extra == nil || a.Principal == "" {
+		return
+	}
+	grants, err := p.extra.GrantsOf(a.Principal)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to look up temporary grants", "principal", a.Principal, "error", err)
+	}
+	a.grants = append(a.grants, grants...)
+}
+
+// RequireAdmin rejects callers without an administrative role
+func RequireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !FromContext(c.Request.Context()).IsAdmin() {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Administrator role required"})
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,166 @@
+package reqlog
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	"sql-engine/auth"
+	"sql-engine/config"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Logger writes one structured entry per sampled request to the default
+// logger set by Setup
+type Logger struct {
+	cfg config.LoggingConfig
+	out *slog.Logger
+	// bodies is set when any rate asks for request bodies, which then have
+	// to be captured before the handler reads them
+	bodies bool
+}
+
+func New(cfg config.LoggingConfig) *Logger {
+	l := &Logger{
+		cfg:    cfg,
+		out:    slog.Default(),
+		bodies: cfg.Bodies > 0,
+	}
+	for _, rule := range cfg.Rules {
+		if rule.Bodies != nil && *rule.Bodies > 0 {
+			l.bodies = true
+		}
+	}
+	return l
+}
+
+// rates are the sample rates applying to one request
+type rates struct {
+	errors, success, bodies float64
+}
+
+// ratesFor returns the defaults overridden by the first matching rule
+func (l *Logger) ratesFor(route, principal string) rates {
+	r := rates{errors: l.cfg.Errors, success: l.cfg.Success, bodies: l.cfg.Bodies}
+	for _, rule := range l.cfg.Rules {
+		if !matchRoute(rule.Route, route) || (rule.Principal != "" && rule.Principal != principal) {
+			continue
+		}
+		if rule.Errors != nil {
+			r.errors = *rule.Errors
+		}
+		if rule.Success != nil {
+			r.success = *rule.Success
+		}
+		if rule.Bodies != nil {
+			r.bodies = *rule.Bodies
+		}
+		break
+	}
+	return r
+}
+
+func matchRoute(pattern, route string) bool {
+	if pattern == "" {
+		return true
+	}
+	if strings.HasSuffix(pattern, "*") {
+		return strings.HasPrefix(route, strings.TrimSuffix(pattern, "*"))
+	}
+	return pattern == route
+}
+
+func sampled(rate float64) bool {
+	return rate >= 1 || (rate > 0 && rand.Float64() < rate)
+}
+
+// Middleware gives each request its ID and logs requests once they
+// complete. It should run first so that requests rejected by later
+// middleware are logged too.
+func (l *Logger) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		withRequestID(c)
+		var body *limitedBuffer
+		if l.bodies && c.Request.Body != nil {
+			body = &limitedBuffer{max: l.cfg.MaxBodyBytes}
+			c.Request.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.TeeReader(c.Request.Body, body), c.Request.Body}
+		}
+
+		c.Next()
+
+		route := c.FullPath()
+		principal := auth.Principal(c)
+		r := l.ratesFor(route, principal)
+		status := c.Writer.Status()
+		rate := r.success
+		if status >= 400 {
+			rate = r.errors
+		}
+		if !sampled(rate) {
+			return
+		}
+
+		attrs := []slog.Attr{
+			slog.String("method", c.Request.Method),
+			slog.String("route", route),
+			slog.String("path", c.Request.URL.Path),
+			slog.Int("status", status),
+			slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.Int("bytes", max(c.Writer.Size(), 0)),
+			slog.String("client_ip", c.ClientIP()),
+			slog.String("principal", principal),
+			slog.Float64("sample_rate", rate),
+		}
+		if q := c.Request.URL.RawQuery; q != "" {
+			attrs = append(attrs, slog.String("query", q))
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, slog.String("errors", c.Errors.String()))
+		}
+		// Ties the entry to the request's trace when tracing is enabled
+		if sc := trace.SpanContextFromContext(c.Request.Context()); sc.IsValid() {
+			attrs = append(attrs, slog.String("trace_id", sc.TraceID().String()))
+		}
+		if body != nil && body.Len() > 0 && sampled(r.bodies) {
+			attrs = append(attrs, slog.String("body", body.String()))
+			if body.truncated {
+				attrs = append(attrs, slog.Bool("body_truncated", true))
+			}
+		}
+
+		level := slog.LevelInfo
+		if status >= 500 {
+			level = slog.LevelError
+		} else if status >= 400 {
+			level = slog.LevelWarn
+		}
+		l.out.LogAttrs(c.Request.Context(), level, "request", attrs...)
+	}
+}
+
+// limitedBuffer keeps the first max bytes written to it
+type limitedBuffer struct {
+	bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.Len(); room < len(p) {
+		b.truncated = true
+		if room > 0 {
+			b.Buffer.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
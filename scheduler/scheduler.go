@@ -0,0 +1,178 @@
+package scheduler
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scheduler runs named jobs at fixed intervals. Scheduling a name that is
+// already registered replaces the previous job.
+type Scheduler struct {
+	mu     sync.Mutex
+	jobs   map[string]job
+	ctx    context.Context
+	cancel context.CancelFunc
+	gate   func() bool
+
+	// active counts the runs in progress. Once Drain is called no run
+	// starts, and idle is closed when the last one finishes.
+	active   int
+	draining bool
+	idle     chan struct{}
+}
+
+type job struct {
+	cancel   context.CancelFunc
+	interval time.Duration // zero for cron jobs
+}
+
+func New() *Scheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Scheduler{
+		jobs:   make(map[string]job),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// SetGate makes jobs skip their runs while allowed returns false, e.g. on
+// replicas that aren't the leader
+func (s *Scheduler) SetGate(allowed func() bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gate = allowed
+}
+
+// Go runs fn once in the background, subject to the gate
+func (s *Scheduler) Go(name string, fn func(ctx context.Context)) {
+	go s.run(s.ctx, name, fn)
+}
+
+// Interval returns the interval of a job started with Every
+func (s *Scheduler) Interval(name string) (time.Duration, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[name]
+	return j.interval, ok
+}
+
+// Names lists the registered jobs whose names start with prefix
+func (s *Scheduler) Names(prefix string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var names []string
+	for name := range s.jobs {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Every runs fn each interval until the job is cancelled or the scheduler
+// stops. Runs of the same job never overlap.
+func (s *Scheduler) Every(name string, interval time.Duration, fn func(ctx context.Context)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if j, ok := s.jobs[name]; ok {
+		j.cancel()
+	}
+	ctx, cancel := context.WithCancel(s.ctx)
+	s.jobs[name] = job{cancel: cancel, interval: interval}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.run(ctx, name, fn)
+			}
+		}
+	}()
+}
+
+// Cancel stops a job; it is a no-op for unknown names
+func (s *Scheduler) Cancel(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if j, ok := s.jobs[name]; ok {
+		j.cancel()
+		delete(s.jobs, name)
+	}
+}
+
+// Running reports whether the scheduler has not been stopped
+func (s *Scheduler) Running() bool {
+	return s.ctx.Err() == nil
+}
+
+// Stop cancels every job
+func (s *Scheduler) Stop() {
+	s.cancel()
+}
+
+// Drain starts no more runs and waits for those in progress to finish
+// until ctx is done, then stops the scheduler. It returns ctx's error if
+// runs were still in progress.
+func (s *Scheduler) Drain(ctx context.Context) error {
+	s.mu.Lock()
+	if !s.draining {
+		s.draining = true
+		s.idle = make(chan struct{})
+		if s.active == 0 {
+			close(s.idle)
+		}
+	}
+	idle := s.idle
+	s.mu.Unlock()
+	defer s.cancel()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		select {
+		case <-idle:
+			return nil
+		default:
+			return ctx.Err()
+		}
+	}
+}
+
+func (s *Scheduler) run(ctx context.Context, name string, fn func(ctx context.Context)) {
+	s.mu.Lock()
+	gate, draining := s.gate, s.draining
+	if !draining {
+		s.active++
+	}
+	s.mu.Unlock()
+	if draining {
+		return
+	}
+	defer func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.active--; s.active == 0 && s.draining {
+			close(s.idle)
+		}
+	}()
+	if gate != nil && !gate() {
+		return
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			slog.ErrorContext(ctx, "Scheduled job panicked", "job", name, "panic", r)
+		}
+	}()
+	fn(ctx)
+}
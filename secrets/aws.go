@@ -0,0 +1,73 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"sql-engine/artifact"
+)
+
+// aws reads a secret from AWS Secrets Manager by name or ARN. A secret
+// holding JSON, as the ones RDS rotates do, gives its fields; any other is
+// returned whole and takes no key.
+func (r *Resolver) aws(ctx context.Context, id, key string) (string, error) {
+	creds := r.cfg.AWS
+	if creds.AccessKeyID == "" {
+		creds.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+		creds.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		creds.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+	if creds.Region == "" {
+		creds.Region = os.Getenv("AWS_REGION")
+	}
+	if creds.Region == "" {
+		creds.Region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if creds.AccessKeyID == "" || creds.Region == "" {
+		return "", errors.New("no AWS credentials or region; set database.secrets.aws or the AWS_* environment variables")
+	}
+	endpoint := strings.TrimRight(creds.Endpoint, "/")
+	if endpoint == "" {
+		endpoint = "https://secretsmanager." + creds.Region + ".amazonaws.com"
+	}
+
+	body, _ := json.Marshal(map[string]string{"SecretId": id})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	artifact.SignV4Payload(req, creds, "secretsmanager", body, time.Now())
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("%s %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	var secret struct {
+		SecretString string `json:"SecretString"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return "", err
+	}
+	var fields map[string]any
+	if json.Unmarshal([]byte(secret.SecretString), &fields) != nil {
+		if key != "" {
+			return "", fmt.Errorf("isn't JSON, so has no field %q", key)
+		}
+		return secret.SecretString, nil
+	}
+	return field(fields, key)
+}
@@ -0,0 +1,88 @@
+// Package secrets fetches secrets referenced in configuration values as
+// ${provider:reference#key}, so that credentials such as database
+// passwords aren't written in the configuration
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"sql-engine/config"
+)
+
+// reference matches ${provider:reference} and ${provider:reference#key}
+var reference = regexp.MustCompile(`\$\{(vault|aws|file):([^}#]+)(?:#([^}]+))?\}`)
+
+// HasReferences reports whether s refers to secrets
+func HasReferences(s string) bool {
+	return reference.MatchString(s)
+}
+
+// Resolver fetches secrets from the configured providers
+type Resolver struct {
+	cfg    config.SecretsConfig
+	client *http.Client
+}
+
+func New(cfg config.SecretsConfig) *Resolver {
+	return &Resolver{cfg: cfg, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Expand replaces the references in s with the secrets they name, passed
+// through escape when it is set, e.g. to fit them in a URL
+func (r *Resolver) Expand(ctx context.Context, s string, escape func(string) string) (string, error) {
+	var failed error
+	expanded := reference.ReplaceAllStringFunc(s, func(ref string) string {
+		if failed != nil {
+			return ref
+		}
+		m := reference.FindStringSubmatch(ref)
+		value, err := r.fetch(ctx, m[1], strings.TrimSpace(m[2]), m[3])
+		if err != nil {
+			failed = fmt.Errorf("%s secret %s: %w", m[1], m[2], err)
+			return ref
+		}
+		if escape != nil {
+			value = escape(value)
+		}
+		return value
+	})
+	return expanded, failed
+}
+
+// fetch returns the secret at ref, or its field key when key is set
+func (r *Resolver) fetch(ctx context.Context, provider, ref, key string) (string, error) {
+	switch provider {
+	case "vault":
+		return r.vault(ctx, ref, key)
+	case "aws":
+		return r.aws(ctx, ref, key)
+	default:
+		return envFile(ref, key)
+	}
+}
+
+// field picks key out of the fields of a secret. Without a key the secret
+// must hold a single field.
+func field(fields map[string]any, key string) (string, error) {
+	if key == "" {
+		if len(fields) != 1 {
+			return "", fmt.Errorf("holds %d fields, name one with #key", len(fields))
+		}
+		for _, v := range fields {
+			return fmt.Sprint(v), nil
+		}
+	}
+	v, ok := fields[key]
+	if !ok {
+		return "", fmt.Errorf("has no field %q", key)
+	}
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprint(v), nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+
+	"sql-engine/config"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+	"google.golang.org/grpc"
+)
+
+// newServer configures the HTTP server: timeouts, keep-alives and which
+// protocols are served
+func newServer(cfg config.ServerConfig, handler http.Handler) *http.Server {
+	srv := &http.Server{
+		Addr:              cfg.Listen,
+		Handler:           handler,
+		ReadTimeout:       cfg.ReadTimeout,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+		Protocols:         new(http.Protocols),
+	}
+	srv.Protocols.SetHTTP1(true)
+	if cfg.HTTP2.Enabled {
+		srv.Protocols.SetHTTP2(true)
+		srv.Protocols.SetUnencryptedHTTP2(cfg.HTTP2.Cleartext)
+		srv.HTTP2 = &http.HTTP2Config{
+			MaxConcurrentStreams: cfg.HTTP2.MaxConcurrentStreams,
+			SendPingTimeout:      cfg.HTTP2.PingInterval,
+		}
+	}
+	return srv
+}
+
+// serve runs srv over plain HTTP, or over HTTPS with HTTP/2 when TLS is
+// configured, optionally with a companion HTTP→HTTPS redirect listener
+func serve(srv *http.Server, tlsCfg config.TLSConfig) error {
+	if !tlsCfg.Enabled() {
+		slog.Info("Server starting", "addr", srv.Addr)
+		return srv.ListenAndServe()
+	}
+
+	// Plain HTTP requests are redirected; with ACME the same listener also
+	// answers HTTP-01 challenges
+	var redirect http.Handler = redirectToHTTPS(srv.Addr)
+	certFile, keyFile := tlsCfg.CertFile, tlsCfg.KeyFile
+
+	if len(tlsCfg.ACME.Domains) > 0 {
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(tlsCfg.ACME.Domains...),
+			Cache:      autocert.DirCache(tlsCfg.ACME.CacheDir),
+			Email:      tlsCfg.ACME.Email,
+		}
+		if tlsCfg.ACME.DirectoryURL != "" {
+			m.Client = &acme.Client{DirectoryURL: tlsCfg.ACME.DirectoryURL}
+		}
+		srv.TLSConfig = m.TLSConfig()
+		redirect = m.HTTPHandler(redirect)
+		certFile, keyFile = "", ""
+	}
+
+	if tlsCfg.RedirectListen != "" {
+		go func() {
+			slog.Info("HTTP redirect listening", "addr", tlsCfg.RedirectListen)
+			if err := http.ListenAndServe(tlsCfg.RedirectListen, redirect); err != nil {
+				slog.Error("HTTP redirect listener failed", "error", err)
+			}
+		}()
+	}
+
+	slog.Info("Server starting with TLS", "addr", srv.Addr)
+	return srv.ListenAndServeTLS(certFile, keyFile)
+}
+
+// redirectToHTTPS sends clients to the same URL on the HTTPS listener
+func redirectToHTTPS(httpsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(httpsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
+
+// serveGRPC runs the gRPC server on its own listener
+func serveGRPC(srv *grpc.Server, addr string) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	slog.Info("gRPC server starting", "addr", addr)
+	return srv.Serve(lis)
+}
+
+// stopGRPC waits for running calls to finish, cancelling those still
+// running when ctx ends
+func stopGRPC(ctx context.Context, srv *grpc.Server) {
+	done := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		slog.Warn("Cancelling gRPC calls still running after the grace period")
+		srv.Stop()
+	}
+}
@@ -0,0 +1,129 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// encryptedMagic prefixes every encrypted value on disk
+var encryptedMagic = []byte("SQEENC1\n")
+
+// Keyring holds the AES-256 keys used to encrypt stored values. The first key
+// in the key file encrypts new writes; the rest stay available to decrypt
+// values written before a rotation.
+type Keyring struct {
+	active string
+	keys   map[string]cipher.AEAD
+}
+
+// LoadKeyring reads a key file with one "<key-id> <base64 32-byte key>" pair
+// per line. Blank lines and lines starting with # are ignored.
+func LoadKeyring(path string) (*Keyring, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	k := &Keyring{keys: make(map[string]cipher.AEAD)}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		id, encoded, ok := strings.Cut(line, " ")
+		if !ok {
+			return nil, fmt.Errorf("store: malformed key line %q", id)
+		}
+		raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("store: key %s: %w", id, err)
+		}
+		if len(raw) != 32 {
+			return nil, fmt.Errorf("store: key %s must be 32 bytes", id)
+		}
+
+		block, err := aes.NewCipher(raw)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+
+		if k.active == "" {
+			k.active = id
+		}
+		k.keys[id] = aead
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if k.active == "" {
+		return nil, errors.New("store: key file contains no keys")
+	}
+	return k, nil
+}
+
+// seal encrypts plaintext with the active key, binding it to the store key
+func (k *Keyring) seal(key string, plaintext []byte) ([]byte, error) {
+	aead := k.keys[k.active]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.Write(encryptedMagic)
+	buf.WriteString(k.active)
+	buf.WriteByte('\n')
+	buf.Write(nonce)
+	buf.Write(aead.Seal(nil, nonce, plaintext, []byte(key)))
+	return buf.Bytes(), nil
+}
+
+// open decrypts a value written by seal. Values stored before encryption was
+// enabled are returned unchanged.
+func (k *Keyring) open(key string, data []byte) ([]byte, error) {
+	if !isEncrypted(data) {
+		return data, nil
+	}
+
+	id, body, ok := bytes.Cut(data[len(encryptedMagic):], []byte("\n"))
+	if !ok {
+		return nil, errors.New("store: malformed encrypted value")
+	}
+	aead, ok := k.keys[string(id)]
+	if !ok {
+		return nil, fmt.Errorf("store: unknown encryption key %s", id)
+	}
+	if len(body) < aead.NonceSize() {
+		return nil, errors.New("store: malformed encrypted value")
+	}
+	nonce, ciphertext := body[:aead.NonceSize()], body[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, []byte(key))
+}
+
+// sealedWith reports the key id an encrypted value was written with
+func sealedWith(data []byte) string {
+	if !isEncrypted(data) {
+		return ""
+	}
+	id, _, _ := bytes.Cut(data[len(encryptedMagic):], []byte("\n"))
+	return string(id)
+}
+
+func isEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, encryptedMagic)
+}
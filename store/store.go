@@ -0,0 +1,286 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrNotFound is returned when a key has no stored value
+var ErrNotFound = errors.New("store: key not found")
+
+// ErrExists is returned by Create when the key already has a value
+var ErrExists = errors.New("store: key already exists")
+
+// Store is a file-backed metadata store holding one JSON document per key
+type Store struct {
+	dir  string
+	keys *Keyring
+	mu   sync.RWMutex
+}
+
+func Open(dir string) (*Store, error) {
+	s := New(dir)
+	if err := s.Ping(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// New returns a store for dir without touching the disk, for starting while
+// the directory is unavailable
+func New(dir string) *Store {
+	return &Store{dir: dir}
+}
+
+// Ping checks that the store directory exists, creating it if needed, and
+// accepts writes
+func (s *Store) Ping() error {
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(s.dir, ".ping-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// UseKeyring encrypts values written from now on. Values already on disk stay
+// readable; call Rotate to re-encrypt them with the active key.
+func (s *Store) UseKeyring(k *Keyring) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = k
+}
+
+func (s *Store) path(key string) string {
+	return filepath.Join(s.dir, url.PathEscape(key)+".json")
+}
+
+// Get decodes the value stored under key into v
+func (s *Store) Get(key string, v interface{}) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	data, err := s.read(key)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (s *Store) read(key string) ([]byte, error) {
+	data, err := os.ReadFile(s.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if isEncrypted(data) {
+		if s.keys == nil {
+			return nil, errors.New("store: value is encrypted but no keyring is configured")
+		}
+		return s.keys.open(key, data)
+	}
+	return data, nil
+}
+
+// Put stores v under key, replacing any previous value atomically
+func (s *Store) Put(key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.write(key, data)
+}
+
+// Create stores v under key only if the key has no value yet. The check is
+// atomic across processes sharing the store directory, so replicas can use
+// it to agree on a single value.
+func (s *Store) Create(key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tmp, err := s.writeTemp(key, data)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	// Unlike rename, link fails when the target exists
+	err = os.Link(tmp, s.path(key))
+	if errors.Is(err, os.ErrExist) {
+		return ErrExists
+	}
+	return err
+}
+
+func (s *Store) write(key string, data []byte) error {
+	tmp, err := s.writeTemp(key, data)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path(key))
+}
+
+// writeTemp writes the (sealed) value for key to a temporary file in the
+// store directory and returns its name
+func (s *Store) writeTemp(key string, data []byte) (string, error) {
+	if s.keys != nil {
+		sealed, err := s.keys.seal(key, data)
+		if err != nil {
+			return "", err
+		}
+		data = sealed
+	}
+
+	tmp, err := os.CreateTemp(s.dir, ".tmp-*")
+	if err != nil {
+		return "", err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+// Rotate re-encrypts every value not yet written with the active key and
+// returns how many values were rewritten
+func (s *Store) Rotate() (int, error) {
+	if s.keys == nil {
+		return 0, errors.New("store: no keyring configured")
+	}
+
+	keys, err := s.List("")
+	if err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rotated := 0
+	for _, key := range keys {
+		raw, err := os.ReadFile(s.path(key))
+		if err != nil {
+			return rotated, err
+		}
+		if sealedWith(raw) == s.keys.active {
+			continue
+		}
+
+		data, err := s.read(key)
+		if err != nil {
+			return rotated, err
+		}
+		if err := s.write(key, data); err != nil {
+			return rotated, err
+		}
+		rotated++
+	}
+	return rotated, nil
+}
+
+// Verification is the state of the values on disk
+type Verification struct {
+	Entries int `json:"entries"`
+	// Unreadable lists the keys whose value can't be decrypted or decoded
+	Unreadable []string `json:"unreadable,omitempty"`
+	// Unrotated counts the values not written with the active key, which
+	// Rotate would rewrite
+	Unrotated int `json:"unrotated"`
+}
+
+// Verify reads back every value, reporting those the store can no longer
+// decode. Values are schemaless JSON documents, so there is no other state
+// to migrate.
+func (s *Store) Verify() (Verification, error) {
+	var v Verification
+	keys, err := s.List("")
+	if err != nil {
+		return v, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, key := range keys {
+		raw, err := os.ReadFile(s.path(key))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return v, err
+		}
+		v.Entries++
+		if s.keys != nil && sealedWith(raw) != s.keys.active {
+			v.Unrotated++
+		}
+		data, err := s.read(key)
+		if err == nil && !json.Valid(data) {
+			err = errors.New("invalid JSON")
+		}
+		if err != nil {
+			v.Unreadable = append(v.Unreadable, key)
+		}
+	}
+	return v, nil
+}
+
+func (s *Store) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := os.Remove(s.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// List returns the sorted keys starting with prefix
+func (s *Store) List(prefix string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	files, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		key, err := url.PathUnescape(strings.TrimSuffix(name, ".json"))
+		if err != nil {
+			continue
+		}
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
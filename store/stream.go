@@ -0,0 +1,191 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// streamMagic prefixes every encrypted stream on disk. Streams are sealed
+// in chunks so that large bodies never have to be held in memory.
+var streamMagic = []byte("SQESTR1\n")
+
+const (
+	// streamChunk is the plaintext size of every chunk but the last
+	streamChunk = 64 << 10
+	// finalChunk marks the length of the last chunk, so that a truncated
+	// stream is detected
+	finalChunk = 1 << 31
+)
+
+var errMalformedStream = errors.New("store: malformed encrypted stream")
+
+// SealStream encrypts r with the active key as it is read, binding it to
+// key. Each chunk gets its own nonce and records whether it is the last.
+func (k *Keyring) SealStream(key string, r io.Reader) io.Reader {
+	return &sealReader{keys: k, key: key, src: r}
+}
+
+// OpenStream decrypts a stream written by SealStream with whichever key of
+// the keyring sealed it. Streams written before encryption was enabled are
+// returned unchanged.
+func (k *Keyring) OpenStream(key string, r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	if head, _ := br.Peek(len(streamMagic)); !bytes.Equal(head, streamMagic) {
+		return br, nil
+	}
+	br.Discard(len(streamMagic))
+	id, err := br.ReadString('\n')
+	if err != nil {
+		return nil, errMalformedStream
+	}
+	aead, ok := k.keys[id[:len(id)-1]]
+	if !ok {
+		return nil, fmt.Errorf("store: unknown encryption key %s", id[:len(id)-1])
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(br, nonce); err != nil {
+		return nil, errMalformedStream
+	}
+	return &openReader{aead: aead, key: key, src: br, nonce: nonce}, nil
+}
+
+// StreamSealedWithActive reports whether the stream read from r was sealed
+// with the active key, as opposed to a retired key or none
+func (k *Keyring) StreamSealedWithActive(r io.Reader) (bool, error) {
+	head := make([]byte, len(streamMagic)+len(k.active)+1)
+	if _, err := io.ReadFull(r, head); errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return bytes.Equal(head, append(append(bytes.Clone(streamMagic), k.active...), '\n')), nil
+}
+
+// chunkNonce derives the nonce of chunk n from the stream's random base
+func chunkNonce(base []byte, n uint64) []byte {
+	nonce := bytes.Clone(base)
+	tail := nonce[len(nonce)-8:]
+	binary.BigEndian.PutUint64(tail, binary.BigEndian.Uint64(tail)^n)
+	return nonce
+}
+
+// chunkData is the additional data of a chunk: the key it belongs to and
+// whether it is the last
+func chunkData(key string, final bool) []byte {
+	if final {
+		return append([]byte(key), 1)
+	}
+	return append([]byte(key), 0)
+}
+
+type sealReader struct {
+	keys  *Keyring
+	key   string
+	src   io.Reader
+	aead  cipher.AEAD
+	nonce []byte
+	n     uint64
+	buf   bytes.Buffer
+	done  bool
+}
+
+func (s *sealReader) Read(p []byte) (int, error) {
+	for s.buf.Len() == 0 && !s.done {
+		if err := s.next(); err != nil {
+			return 0, err
+		}
+	}
+	if s.buf.Len() == 0 {
+		return 0, io.EOF
+	}
+	return s.buf.Read(p)
+}
+
+// next seals the following chunk into buf, starting with the header
+func (s *sealReader) next() error {
+	if s.aead == nil {
+		s.aead = s.keys.keys[s.keys.active]
+		s.nonce = make([]byte, s.aead.NonceSize())
+		if _, err := rand.Read(s.nonce); err != nil {
+			return err
+		}
+		s.buf.Write(streamMagic)
+		s.buf.WriteString(s.keys.active)
+		s.buf.WriteByte('\n')
+		s.buf.Write(s.nonce)
+	}
+
+	chunk := make([]byte, streamChunk)
+	n, err := io.ReadFull(s.src, chunk)
+	switch {
+	case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+		s.done = true
+	case err != nil:
+		return err
+	}
+	sealed := s.aead.Seal(nil, chunkNonce(s.nonce, s.n), chunk[:n], chunkData(s.key, s.done))
+	length := uint32(len(sealed))
+	if s.done {
+		length |= finalChunk
+	}
+	s.buf.Write(binary.BigEndian.AppendUint32(nil, length))
+	s.buf.Write(sealed)
+	s.n++
+	return nil
+}
+
+type openReader struct {
+	aead  cipher.AEAD
+	key   string
+	src   io.Reader
+	nonce []byte
+	n     uint64
+	buf   bytes.Buffer
+	done  bool
+}
+
+func (o *openReader) Read(p []byte) (int, error) {
+	for o.buf.Len() == 0 && !o.done {
+		if err := o.next(); err != nil {
+			return 0, err
+		}
+	}
+	if o.buf.Len() == 0 {
+		return 0, io.EOF
+	}
+	return o.buf.Read(p)
+}
+
+// next decrypts the following chunk into buf
+func (o *openReader) next() error {
+	var prefix [4]byte
+	if _, err := io.ReadFull(o.src, prefix[:]); errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return errors.New("store: truncated encrypted stream")
+	} else if err != nil {
+		return err
+	}
+	length := binary.BigEndian.Uint32(prefix[:])
+	final := length&finalChunk != 0
+	length &^= finalChunk
+	if int(length) > streamChunk+o.aead.Overhead() {
+		return errMalformedStream
+	}
+	sealed := make([]byte, length)
+	if _, err := io.ReadFull(o.src, sealed); err != nil {
+		return errors.New("store: truncated encrypted stream")
+	}
+	plain, err := o.aead.Open(nil, chunkNonce(o.nonce, o.n), sealed, chunkData(o.key, final))
+	if err != nil {
+		return err
+	}
+	o.buf.Write(plain)
+	o.n++
+	o.done = final
+	return nil
+}
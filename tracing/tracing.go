@@ -0,0 +1,142 @@
+// Package tracing exports OpenTelemetry spans of requests and database
+// calls over OTLP
+package tracing
+
+import (
+	"context"
+	"database/sql/driver"
+	"regexp"
+
+	"sql-engine/config"
+
+	"github.com/XSAM/otelsql"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// maxStatement caps the length of the statements recorded on spans
+const maxStatement = 4096
+
+var (
+	stringLiteral = regexp.MustCompile(`'(?:[^']|'')*'`)
+	// numberLiteral matches numbers that aren't part of an identifier or
+	// a $n placeholder, with the character before them
+	numberLiteral = regexp.MustCompile(`(^|[^\w$.])\d+(?:\.\d+)?(?:[eE][-+]?\d+)?`)
+)
+
+// Setup installs the global tracer provider, exporting spans over OTLP,
+// and the W3C trace context and baggage propagators. The returned function
+// flushes the spans not exported yet. Without tracing.enabled it does
+// nothing.
+func Setup(ctx context.Context, cfg config.TracingConfig, replicaID string) (func(context.Context) error, error) {
+	if !cfg.Enabled {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	if cfg.Protocol == "grpc" {
+		var opts []otlptracegrpc.Option
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlptracegrpc.WithEndpoint(cfg.Endpoint))
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		if len(cfg.Headers) > 0 {
+			opts = append(opts, otlptracegrpc.WithHeaders(cfg.Headers))
+		}
+		exporter, err = otlptracegrpc.New(ctx, opts...)
+	} else {
+		var opts []otlptracehttp.Option
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(cfg.Endpoint))
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		if len(cfg.Headers) > 0 {
+			opts = append(opts, otlptracehttp.WithHeaders(cfg.Headers))
+		}
+		exporter, err = otlptracehttp.New(ctx, opts...)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		attribute.String("service.name", cfg.ServiceName),
+		attribute.String("service.instance.id", replicaID),
+	))
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+// Middleware starts a span named after the route for each request,
+// continuing the trace of the caller's traceparent header. Health probes
+// and status polls aren't traced.
+func Middleware(cfg config.TracingConfig) gin.HandlerFunc {
+	if !cfg.Enabled {
+		return func(c *gin.Context) { c.Next() }
+	}
+	return otelgin.Middleware(cfg.ServiceName, otelgin.WithGinFilter(func(c *gin.Context) bool {
+		switch c.FullPath() {
+		case "/health", "/healthz", "/readyz", "/status", "/metrics":
+			return false
+		}
+		return true
+	}))
+}
+
+// DatabaseOptions instrument a database/sql pool so that every statement,
+// transaction and connection gets a span. system is the db.system.name,
+// e.g. postgresql.
+func DatabaseOptions(cfg config.TracingConfig, system string) []otelsql.Option {
+	opts := []otelsql.Option{
+		otelsql.WithAttributes(attribute.String("db.system.name", system)),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{
+			// Statements are recorded redacted below instead
+			DisableQuery:         true,
+			DisableErrSkip:       true,
+			OmitConnResetSession: true,
+			OmitRows:             true,
+		}),
+	}
+	if cfg.Statements {
+		opts = append(opts, otelsql.WithAttributesGetter(func(_ context.Context, _ otelsql.Method, query string, _ []driver.NamedValue) []attribute.KeyValue {
+			if query == "" {
+				return nil
+			}
+			return []attribute.KeyValue{attribute.String("db.query.text", Redact(query))}
+		}))
+	}
+	return opts
+}
+
+// Redact replaces the string and number literals of a statement with ?, so
+// that spans don't carry the values callers filter on. Bound parameters
+// are never recorded.
+func Redact(query string) string {
+	query = stringLiteral.ReplaceAllString(query, "?")
+	query = numberLiteral.ReplaceAllString(query, "${1}?")
+	if len(query) > maxStatement {
+		query = query[:maxStatement] + "…"
+	}
+	return query
+}